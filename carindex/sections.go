@@ -0,0 +1,168 @@
+// Package carindex adapts an indexed CAR to the file.BlockSectionReader
+// interface, so that UnixFS file readers can read sections of raw leaves
+// directly from the CAR rather than loading and hashing whole blocks. Leaves
+// stored next to each other in the CAR are read together. The content of
+// dag-pb leaves can be located in the CAR with Sections.LeafData.
+package carindex
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/file"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+)
+
+// Sections provides access to the blocks of a CAR by their location in the
+// CAR's data payload.
+type Sections struct {
+	data io.ReaderAt
+	idx  index.Index
+}
+
+var _ file.RangedBlockReader = (*Sections)(nil)
+
+// New creates Sections for the CAR read by r. The CAR's own index is used if
+// it has one, otherwise an index is generated by reading the whole payload.
+func New(r *carv2.Reader) (*Sections, error) {
+	dr, err := r.DataReader()
+	if err != nil {
+		return nil, err
+	}
+	ir, err := r.IndexReader()
+	if err != nil {
+		return nil, err
+	}
+	var idx index.Index
+	if ir != nil {
+		idx, err = index.ReadFrom(ir)
+	} else {
+		idx, err = carv2.GenerateIndex(dr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewFromIndex(dr, idx), nil
+}
+
+// NewFromIndex creates Sections over a CARv1 data payload and an index of it.
+func NewFromIndex(data io.ReaderAt, idx index.Index) *Sections {
+	return &Sections{data: data, idx: idx}
+}
+
+// BlockSection implements file.BlockSectionReader.
+func (s *Sections) BlockSection(ctx context.Context, c cid.Cid) (io.ReaderAt, int64, error) {
+	var found bool
+	var section *io.SectionReader
+	var sectionErr error
+	err := s.idx.GetAll(c, func(offset uint64) bool {
+		section, sectionErr = s.readSection(c, int64(offset))
+		if sectionErr != nil {
+			return false
+		}
+		found = section != nil
+		return !found
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if sectionErr != nil {
+		return nil, 0, sectionErr
+	}
+	if !found {
+		return nil, 0, index.ErrNotFound
+	}
+	return section, section.Size(), nil
+}
+
+// BlockOffset implements file.RangedBlockReader, giving the position of the
+// block's data in the CAR's data payload.
+func (s *Sections) BlockOffset(ctx context.Context, c cid.Cid) (int64, int64, error) {
+	ra, size, err := s.BlockSection(ctx, c)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, offset, _ := ra.(*io.SectionReader).Outer()
+	return offset, size, nil
+}
+
+// Blocks implements file.RangedBlockReader, returning the CAR's data payload.
+func (s *Sections) Blocks() io.ReaderAt {
+	return s.data
+}
+
+// leafHeaderSize is enough of a dag-pb leaf to locate its content, unless it
+// has unusual fields before it.
+const leafHeaderSize = 64
+
+// LeafData returns a reader over the content of the file leaf c: the whole
+// block if it's a raw leaf, or the content a dag-pb UnixFS leaf holds, located
+// with file.LeafDataSection by reading the start of the block.
+func (s *Sections) LeafData(ctx context.Context, c cid.Cid) (*io.SectionReader, error) {
+	ra, size, err := s.BlockSection(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	switch c.Prefix().Codec {
+	case cid.Raw:
+		return io.NewSectionReader(ra, 0, size), nil
+	case cid.DagProtobuf:
+	default:
+		return nil, fmt.Errorf("unsupported leaf codec 0x%x", c.Prefix().Codec)
+	}
+	head := make([]byte, min(size, leafHeaderSize))
+	if _, err := ra.ReadAt(head, 0); err != nil {
+		return nil, err
+	}
+	offset, length, err := file.LeafDataSection(head)
+	if errors.Is(err, io.ErrUnexpectedEOF) && int64(len(head)) < size {
+		head = make([]byte, size)
+		if _, err := ra.ReadAt(head, 0); err != nil {
+			return nil, err
+		}
+		offset, length, err = file.LeafDataSection(head)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c, err)
+	}
+	return io.NewSectionReader(ra, offset, length), nil
+}
+
+// readSection reads the header of the section at offset, returning a reader
+// over its block data if the section holds c. Indexes may match on multihash
+// alone, so sections for CIDs with a different codec are also accepted.
+func (s *Sections) readSection(c cid.Cid, offset int64) (*io.SectionReader, error) {
+	br := bufio.NewReaderSize(io.NewSectionReader(s.data, offset, 1<<20), 64)
+	length, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	headerLen := int64(uvarintSize(length))
+	cidLen, sectionCid, err := cid.CidFromReader(br)
+	if err != nil {
+		return nil, err
+	}
+	if string(sectionCid.Hash()) != string(c.Hash()) {
+		return nil, nil
+	}
+	if int64(length) < int64(cidLen) {
+		return nil, fmt.Errorf("invalid CAR section at offset %d", offset)
+	}
+	start := offset + headerLen + int64(cidLen)
+	return io.NewSectionReader(s.data, start, int64(length)-int64(cidLen)), nil
+}
+
+func uvarintSize(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
@@ -0,0 +1,202 @@
+package carindex_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/carindex"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/file"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSectionReads(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "file.car")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// the CAR root is not used, any valid CID will do
+	car, err := storage.NewReadableWritable(f, []cid.Cid{cid.MustParse("bafkqaaa")})
+	require.NoError(t, err)
+	ls := cidlink.DefaultLinkSystem()
+	ls.SetWriteStorage(car)
+	ls.SetReadStorage(car)
+
+	content := random.Bytes(1 << 20)
+	root, _, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-4096", &ls)
+	require.NoError(t, err)
+	require.NoError(t, car.Finalize())
+
+	r, err := carv2.OpenReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+	sections, err := carindex.New(r)
+	require.NoError(t, err)
+
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	readable, err := storage.OpenReadable(f)
+	require.NoError(t, err)
+
+	// count the raw leaves that are loaded through the LinkSystem
+	var rawLoads int
+	reading := cidlink.DefaultLinkSystem()
+	reading.SetReadStorage(readable)
+	readOpener := reading.StorageReadOpener
+	reading.StorageReadOpener = func(lc ipld.LinkContext, l ipld.Link) (io.Reader, error) {
+		if l.(cidlink.Link).Cid.Prefix().Codec == cid.Raw {
+			rawLoads++
+		}
+		return readOpener(lc, l)
+	}
+	reading.NodeReifier = unixfsnode.Reify
+
+	sctx := file.WithBlockSectionReader(ctx, sections)
+	nd, err := reading.Load(ipld.LinkContext{Ctx: sctx}, root, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	rs, err := nd.(file.LargeBytesNode).AsLargeBytes()
+	require.NoError(t, err)
+
+	for _, off := range []int64{500000, 12345, 1<<20 - 10, 0} {
+		_, err = rs.Seek(off, io.SeekStart)
+		require.NoError(t, err)
+		buf := make([]byte, 10000)
+		n, err := io.ReadFull(rs, buf)
+		if err != io.ErrUnexpectedEOF {
+			require.NoError(t, err)
+		}
+		require.Equal(t, content[off:off+int64(n)], buf[:n])
+	}
+	require.Zero(t, rawLoads)
+}
+
+func TestLeafData(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "file.car")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	car, err := storage.NewReadableWritable(f, []cid.Cid{cid.MustParse("bafkqaaa")})
+	require.NoError(t, err)
+	ls := cidlink.DefaultLinkSystem()
+	ls.SetWriteStorage(car)
+	ls.SetReadStorage(car)
+
+	content := random.Bytes(1 << 20)
+	root, _, err := builder.BuildUnixFSFileWithProfile(bytes.NewReader(content), "unixfs-v1-cidv0", &ls)
+	require.NoError(t, err)
+	nd, err := ls.Load(ipld.LinkContext{Ctx: ctx}, root, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	require.NoError(t, car.Finalize())
+
+	r, err := carv2.OpenReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+	sections, err := carindex.New(r)
+	require.NoError(t, err)
+
+	// the leaves are dag-pb File nodes, read straight out of the CAR
+	var got []byte
+	links := nd.(dagpb.PBNode).FieldLinks().Iterator()
+	for !links.Done() {
+		_, lnk := links.Next()
+		c := lnk.FieldHash().Link().(cidlink.Link).Cid
+		require.Equal(t, uint64(cid.DagProtobuf), c.Prefix().Codec)
+		sr, err := sections.LeafData(ctx, c)
+		require.NoError(t, err)
+		leaf, err := io.ReadAll(sr)
+		require.NoError(t, err)
+		got = append(got, leaf...)
+	}
+	require.Equal(t, content, got)
+
+	_, err = sections.LeafData(ctx, root.(cidlink.Link).Cid)
+	var notLeaf file.ErrNotLeaf
+	require.ErrorAs(t, err, &notLeaf)
+}
+
+type countingReaderAt struct {
+	io.ReaderAt
+	reads int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	// section headers are read in small pieces, only count reads of content
+	if len(p) > 4096 {
+		c.reads++
+	}
+	return c.ReaderAt.ReadAt(p, off)
+}
+
+func TestCoalescedReads(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "file.car")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	car, err := storage.NewReadableWritable(f, []cid.Cid{cid.MustParse("bafkqaaa")})
+	require.NoError(t, err)
+	ls := cidlink.DefaultLinkSystem()
+	ls.SetWriteStorage(car)
+	ls.SetReadStorage(car)
+
+	content := random.Bytes(1 << 20)
+	root, _, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-8192", &ls)
+	require.NoError(t, err)
+	require.NoError(t, car.Finalize())
+
+	r, err := carv2.OpenReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+	dr, err := r.DataReader()
+	require.NoError(t, err)
+	idx, err := carv2.GenerateIndex(dr)
+	require.NoError(t, err)
+	counting := &countingReaderAt{ReaderAt: dr}
+	sections := carindex.NewFromIndex(counting, idx)
+
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	readable, err := storage.OpenReadable(f)
+	require.NoError(t, err)
+	reading := cidlink.DefaultLinkSystem()
+	reading.SetReadStorage(readable)
+	reading.NodeReifier = unixfsnode.Reify
+
+	sctx := file.WithBlockSectionReader(ctx, sections)
+	nd, err := reading.Load(ipld.LinkContext{Ctx: sctx}, root, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	rs, err := nd.(file.LargeBytesNode).AsLargeBytes()
+	require.NoError(t, err)
+
+	got, err := io.ReadAll(rs)
+	require.NoError(t, err)
+	require.Equal(t, content, got)
+	// 128 leaves, read in a handful of spans
+	require.Less(t, counting.reads, 8)
+
+	counting.reads = 0
+	_, err = rs.Seek(300000, io.SeekStart)
+	require.NoError(t, err)
+	buf := make([]byte, 100000)
+	_, err = io.ReadFull(rs, buf)
+	require.NoError(t, err)
+	require.Equal(t, content[300000:400000], buf)
+	require.Less(t, counting.reads, 4)
+}
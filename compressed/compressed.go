@@ -0,0 +1,158 @@
+// Package compressed stores the leaves of UnixFS files compressed with zstd,
+// and decompresses them transparently when the file is read.
+//
+// A compressed leaf is stored under ZstdLeafCodec, which marks it as
+// compressed. Leaves that do not shrink when compressed are stored as plain
+// raw leaves, so a file may contain a mix of both. File nodes record the
+// uncompressed size of every leaf, so compressed files can be read and seeked
+// like any other once decompression is attached to a LinkSystem with
+// AddDecompressionToLinkSystem, or to the context of a file node with
+// WithDecompression.
+package compressed
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/file"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/klauspost/compress/zstd"
+	"github.com/multiformats/go-multicodec"
+	multihash "github.com/multiformats/go-multihash/core"
+)
+
+// ZstdLeafCodec is the codec, from the multicodec private use range, that
+// marks a leaf block as zstd compressed.
+const ZstdLeafCodec uint64 = 0x300000
+
+// MaxLeafSize is the largest leaf that will be decompressed, guarding readers
+// against blocks that decompress to an unreasonable size.
+const MaxLeafSize = 4 << 20
+
+// LeafLinkPrototype is the link prototype compressed leaves are stored under.
+var LeafLinkPrototype = cidlink.LinkPrototype{
+	Prefix: cid.Prefix{
+		Version:  1,
+		Codec:    ZstdLeafCodec,
+		MhType:   multihash.SHA2_256,
+		MhLength: 32,
+	},
+}
+
+var rawLinkPrototype = cidlink.LinkPrototype{
+	Prefix: cid.Prefix{
+		Version:  1,
+		Codec:    uint64(multicodec.Raw),
+		MhType:   multihash.SHA2_256,
+		MhLength: 32,
+	},
+}
+
+type options struct {
+	level zstd.EncoderLevel
+}
+
+// Option configures compression.
+type Option func(*options)
+
+// WithLevel sets the zstd compression level, the default is
+// zstd.SpeedDefault.
+func WithLevel(level zstd.EncoderLevel) Option {
+	return func(o *options) {
+		o.level = level
+	}
+}
+
+// LeafEncoder returns a builder.LeafEncoder that compresses leaves with zstd,
+// storing leaves which don't get smaller as raw leaves.
+func LeafEncoder(opts ...Option) (builder.LeafEncoder, error) {
+	o := options{level: zstd.SpeedDefault}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(o.level), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return func(leaf []byte) ([]byte, cidlink.LinkPrototype, error) {
+		if len(leaf) > MaxLeafSize {
+			return leaf, rawLinkPrototype, nil
+		}
+		compressed := enc.EncodeAll(leaf, nil)
+		if len(compressed) >= len(leaf) {
+			return leaf, rawLinkPrototype, nil
+		}
+		return compressed, LeafLinkPrototype, nil
+	}, nil
+}
+
+var (
+	decoder     *zstd.Decoder
+	decoderErr  error
+	decoderOnce sync.Once
+)
+
+func sharedDecoder() (*zstd.Decoder, error) {
+	decoderOnce.Do(func() {
+		decoder, decoderErr = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(MaxLeafSize))
+	})
+	return decoder, decoderErr
+}
+
+// DecodeLeaf decompresses a leaf stored under ZstdLeafCodec. It is a
+// file.LeafDecoder.
+func DecodeLeaf(_ context.Context, c cid.Cid, block []byte) ([]byte, error) {
+	dec, err := sharedDecoder()
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := dec.DecodeAll(block, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c, err)
+	}
+	return leaf, nil
+}
+
+var _ file.LeafDecoder = DecodeLeaf
+
+// BuildFile imports the content of r as a UnixFS file with compressed leaves.
+// It is builder.BuildUnixFSFile with a compressing leaf encoder.
+func BuildFile(r io.Reader, chunker string, ls *ipld.LinkSystem, opts ...Option) (ipld.Link, uint64, error) {
+	enc, err := LeafEncoder(opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return builder.BuildUnixFSFile(r, chunker, ls, builder.WithLeafEncoder(enc))
+}
+
+// WithDecompression returns a context that lets file nodes created with it
+// read compressed leaves.
+func WithDecompression(ctx context.Context) context.Context {
+	return file.WithLeafDecoder(ctx, ZstdLeafCodec, DecodeLeaf)
+}
+
+// Reify behaves like unixfsnode.Reify, but files it creates can read
+// compressed leaves.
+func Reify(lnkCtx linking.LinkContext, n ipld.Node, lsys *ipld.LinkSystem) (ipld.Node, error) {
+	ctx := lnkCtx.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	lnkCtx.Ctx = WithDecompression(ctx)
+	return unixfsnode.Reify(lnkCtx, n, lsys)
+}
+
+// AddDecompressionToLinkSystem sets the NodeReifier of lsys, and the "unixfs"
+// reifier used by InterpretAs selector clauses, to Reify.
+func AddDecompressionToLinkSystem(lsys *ipld.LinkSystem) {
+	unixfsnode.AddUnixFSReificationToLinkSystem(lsys)
+	lsys.NodeReifier = Reify
+	lsys.KnownReifiers["unixfs"] = Reify
+}
@@ -0,0 +1,112 @@
+package compressed_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode/compressed"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/file"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func storedSize(storage *cidlink.Memory) int {
+	var total int
+	for _, blk := range storage.Bag {
+		total += len(blk)
+	}
+	return total
+}
+
+func TestCompressedFile(t *testing.T) {
+	// compressible text, with a run of random bytes in the middle that
+	// will be stored uncompressed
+	var content []byte
+	for len(content) < 1<<20 {
+		content = append(content, []byte("all work and no play makes jack a dull boy\n")...)
+	}
+	content = append(content[:500000], append(random.Bytes(300000), content[500000:]...)...)
+
+	ls := cidlink.DefaultLinkSystem()
+	storage := &cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+	root, _, err := compressed.BuildFile(bytes.NewReader(content), "size-65536", &ls)
+	require.NoError(t, err)
+
+	plainLs := cidlink.DefaultLinkSystem()
+	plainStorage := &cidlink.Memory{}
+	plainLs.StorageWriteOpener = plainStorage.OpenWrite
+	_, _, err = builder.BuildUnixFSFile(bytes.NewReader(content), "size-65536", &plainLs)
+	require.NoError(t, err)
+	require.Less(t, storedSize(storage), storedSize(plainStorage)/2)
+
+	var raw, zstd int
+	substrate, err := ls.Load(ipld.LinkContext{}, root, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	links := substrate.(dagpb.PBNode).FieldLinks().Iterator()
+	for !links.Done() {
+		_, lnk := links.Next()
+		switch lnk.FieldHash().Link().(cidlink.Link).Cid.Prefix().Codec {
+		case cid.Raw:
+			raw++
+		case compressed.ZstdLeafCodec:
+			zstd++
+		}
+	}
+	require.Greater(t, raw, 0)
+	require.Greater(t, zstd, 0)
+
+	reading := ls
+	compressed.AddDecompressionToLinkSystem(&reading)
+	nd, err := reading.Load(ipld.LinkContext{}, root, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	rdr, err := nd.(datamodel.LargeBytesNode).AsLargeBytes()
+	require.NoError(t, err)
+	got, err := io.ReadAll(rdr)
+	require.NoError(t, err)
+	require.Equal(t, content, got)
+
+	_, err = rdr.Seek(123456, io.SeekStart)
+	require.NoError(t, err)
+	buf := make([]byte, 100000)
+	_, err = io.ReadFull(rdr, buf)
+	require.NoError(t, err)
+	require.Equal(t, content[123456:223456], buf)
+}
+
+func TestCompressedSmallFile(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := &cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	content := bytes.Repeat([]byte("a"), 1000)
+	root, _, err := compressed.BuildFile(bytes.NewReader(content), "", &ls)
+	require.NoError(t, err)
+	require.Equal(t, uint64(cid.DagProtobuf), root.(cidlink.Link).Cid.Prefix().Codec)
+
+	substrate, err := ls.Load(ipld.LinkContext{}, root, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	nd, err := file.NewUnixFSFile(compressed.WithDecompression(context.Background()), substrate, &ls)
+	require.NoError(t, err)
+	got, err := nd.AsBytes()
+	require.NoError(t, err)
+	require.Equal(t, content, got)
+
+	// incompressible content is stored exactly as an uncompressed import
+	content = random.Bytes(1000)
+	root, _, err = compressed.BuildFile(bytes.NewReader(content), "", &ls)
+	require.NoError(t, err)
+	expected, _, err := builder.BuildUnixFSFile(bytes.NewReader(content), "", &ls)
+	require.NoError(t, err)
+	require.Equal(t, expected, root)
+}
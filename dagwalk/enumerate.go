@@ -0,0 +1,192 @@
+package dagwalk
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// Filter selects which blocks Enumerate reports.
+type Filter int
+
+const (
+	// AllBlocks reports every block.
+	AllBlocks Filter = iota
+	// StructureOnly reports every block except file leaves. Raw leaves are
+	// never loaded.
+	StructureOnly
+	// LeavesOnly reports only file leaves.
+	LeavesOnly
+)
+
+type enumerateOptions struct {
+	filter   Filter
+	maxDepth int
+}
+
+// EnumerateOption configures Enumerate.
+type EnumerateOption func(*enumerateOptions)
+
+// WithFilter sets which blocks are reported, the default is AllBlocks.
+func WithFilter(filter Filter) EnumerateOption {
+	return func(o *enumerateOptions) {
+		o.filter = filter
+	}
+}
+
+// WithMaxDepth stops the walk at blocks depth links below the root, which
+// is at depth 0. Blocks at the maximum depth are reported, but not loaded
+// unless the filter needs to know whether they are leaves.
+func WithMaxDepth(depth int) EnumerateOption {
+	return func(o *enumerateOptions) {
+		o.maxDepth = depth
+	}
+}
+
+// EnumerateFunc is called by Enumerate for each block reported, with its depth
+// below the root. Returning an error stops the walk, and Enumerate returns
+// the error.
+type EnumerateFunc func(c cid.Cid, depth int) error
+
+type enumerator struct {
+	ctx    context.Context
+	lsys   *ipld.LinkSystem
+	lnkCtx linking.LinkContext
+	opts   enumerateOptions
+	cb     EnumerateFunc
+	// the shallowest depth each block has been reached at, a block is only
+	// walked again if it's reached at a shallower depth before the limit
+	seen map[cid.Cid]int
+}
+
+// Enumerate walks the DAG under root in depth-first order and calls cb with
+// the CID of each block it contains, once per block. A file leaf is a raw
+// block, or a dag-pb file node without links.
+//
+// Blocks are reported as they are reached, so that a pinning service can act
+// on them as the walk proceeds; only the set of CIDs already seen is kept.
+// Only dag-pb links are followed; blocks of any other codec are treated as
+// leaves.
+func Enumerate(ctx context.Context, lsys *ipld.LinkSystem, root ipld.Link, cb EnumerateFunc, opts ...EnumerateOption) error {
+	o := enumerateOptions{maxDepth: -1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	cl, ok := root.(cidlink.Link)
+	if !ok {
+		return fmt.Errorf("unsupported link type: %T", root)
+	}
+	e := &enumerator{
+		ctx:    ctx,
+		lsys:   lsys,
+		lnkCtx: linking.LinkContext{Ctx: ctx},
+		opts:   o,
+		cb:     cb,
+		seen:   make(map[cid.Cid]int),
+	}
+	return e.visit(cl.Cid, 0)
+}
+
+// EnumerateChan runs Enumerate in a goroutine, sending each CID reported on
+// the returned channel. The channel is closed when the walk ends, after which
+// the error channel receives the result of the walk. Cancelling ctx stops the
+// walk.
+func EnumerateChan(ctx context.Context, lsys *ipld.LinkSystem, root ipld.Link, opts ...EnumerateOption) (<-chan cid.Cid, <-chan error) {
+	out := make(chan cid.Cid)
+	errCh := make(chan error, 1)
+	go func() {
+		err := Enumerate(ctx, lsys, root, func(c cid.Cid, _ int) error {
+			select {
+			case out <- c:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}, opts...)
+		close(out)
+		errCh <- err
+		close(errCh)
+	}()
+	return out, errCh
+}
+
+func (e *enumerator) visit(c cid.Cid, depth int) error {
+	prev, seen := e.seen[c]
+	if seen && prev <= depth {
+		return nil
+	}
+	e.seen[c] = depth
+
+	if c.Prefix().Codec != cid.DagProtobuf {
+		if !seen && e.opts.filter != StructureOnly {
+			return e.cb(c, depth)
+		}
+		return nil
+	}
+	atLimit := e.opts.maxDepth >= 0 && depth >= e.opts.maxDepth
+	if atLimit && e.opts.filter == AllBlocks {
+		if !seen {
+			return e.cb(c, depth)
+		}
+		return nil
+	}
+
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
+	raw, err := e.lsys.LoadRaw(e.lnkCtx, cidlink.Link{Cid: c})
+	if err != nil {
+		return err
+	}
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagpb.DecodeBytes(nb, raw); err != nil {
+		return fmt.Errorf("block %s: %w", c, err)
+	}
+	pbnd := nb.Build().(dagpb.PBNode)
+	leaf, err := isFileLeaf(pbnd)
+	if err != nil {
+		return fmt.Errorf("block %s: %w", c, err)
+	}
+	if !seen && (e.opts.filter == AllBlocks || (e.opts.filter == LeavesOnly) == leaf) {
+		if err := e.cb(c, depth); err != nil {
+			return err
+		}
+	}
+	if atLimit {
+		return nil
+	}
+
+	itr := pbnd.FieldLinks().Iterator()
+	for !itr.Done() {
+		_, lnk := itr.Next()
+		cl, ok := lnk.FieldHash().Link().(cidlink.Link)
+		if !ok {
+			return fmt.Errorf("unsupported link type: %T", lnk.FieldHash().Link())
+		}
+		if err := e.visit(cl.Cid, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isFileLeaf(pbnd dagpb.PBNode) (bool, error) {
+	if pbnd.FieldLinks().Length() > 0 {
+		return false, nil
+	}
+	if !pbnd.FieldData().Exists() {
+		return false, fmt.Errorf("not UnixFS: no Data field")
+	}
+	ufsData, err := data.DecodeUnixFSData(pbnd.FieldData().Must().Bytes())
+	if err != nil {
+		return false, err
+	}
+	dataType := ufsData.FieldDataType().Int()
+	return dataType == data.Data_File || dataType == data.Data_Raw, nil
+}
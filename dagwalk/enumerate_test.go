@@ -0,0 +1,131 @@
+package dagwalk_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode/dagwalk"
+	"github.com/ipfs/go-unixfsnode/mutable"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func enumerate(t *testing.T, ls *ipld.LinkSystem, root ipld.Link, opts ...dagwalk.EnumerateOption) map[cid.Cid]int {
+	out := make(map[cid.Cid]int)
+	err := dagwalk.Enumerate(context.Background(), ls, root, func(c cid.Cid, depth int) error {
+		_, dupe := out[c]
+		require.False(t, dupe, "reported twice: %s", c)
+		out[c] = depth
+		return nil
+	}, opts...)
+	require.NoError(t, err)
+	return out
+}
+
+func TestEnumerate(t *testing.T) {
+	ctx := context.Background()
+	storage := &cidlink.Memory{}
+	ls, loaded := countingLinkSystem(storage)
+
+	s, err := mutable.NewSession(ctx, ls, nil, mutable.WithChunker("size-1024"))
+	require.NoError(t, err)
+	require.NoError(t, s.Mkdir("/a/b", true))
+	require.NoError(t, s.Mkdir("/empty", false))
+	same := random.Bytes(30000)
+	for _, p := range []string{"/a/b/1", "/a/2", "/3"} {
+		require.NoError(t, s.WriteFile(p, bytes.NewReader(random.Bytes(30000))))
+	}
+	require.NoError(t, s.WriteFile("/a/b/same", bytes.NewReader(same)))
+	require.NoError(t, s.WriteFile("/same", bytes.NewReader(same)))
+	require.NoError(t, s.WriteFile("/small", bytes.NewReader([]byte("hello"))))
+	root, _, err := s.Flush()
+	require.NoError(t, err)
+	rootCid := root.(cidlink.Link).Cid
+
+	all := enumerate(t, ls, root)
+	require.Len(t, all, len(reachable(t, storage, rootCid)))
+	for c := range reachable(t, storage, rootCid) {
+		require.Contains(t, all, c)
+	}
+	require.Equal(t, 0, all[rootCid])
+	// the shared file is reported where it is first reached, under /a/b
+	require.Equal(t, 3, all[fileCid(t, same)])
+
+	loaded()
+	structure := enumerate(t, ls, root, dagwalk.WithFilter(dagwalk.StructureOnly))
+	for c := range loaded() {
+		require.Equal(t, uint64(cid.DagProtobuf), c.Prefix().Codec, "no raw leaves should be loaded")
+	}
+	leaves := enumerate(t, ls, root, dagwalk.WithFilter(dagwalk.LeavesOnly))
+	require.Len(t, structure, len(all)-len(leaves))
+	for c := range leaves {
+		require.NotContains(t, structure, c)
+	}
+	require.Contains(t, structure, rootCid)
+	require.Contains(t, leaves, fileCid(t, []byte("hello")))
+	for c, depth := range all {
+		if c.Prefix().Codec == cid.Raw {
+			require.Contains(t, leaves, c)
+			require.Equal(t, depth, leaves[c])
+		}
+	}
+
+	shallow := enumerate(t, ls, root, dagwalk.WithMaxDepth(1))
+	for c, depth := range all {
+		if depth <= 1 {
+			require.Contains(t, shallow, c)
+		}
+	}
+	require.Len(t, shallow, 6)
+	// but is still found at /same when the walk can't reach /a/b
+	require.Equal(t, 1, shallow[fileCid(t, same)])
+	shallowStructure := enumerate(t, ls, root, dagwalk.WithMaxDepth(1), dagwalk.WithFilter(dagwalk.StructureOnly))
+	// only the small file is a leaf at depth 1
+	require.Len(t, shallowStructure, 5)
+}
+
+func TestEnumerateChan(t *testing.T) {
+	ctx := context.Background()
+	storage := &cidlink.Memory{}
+	ls, _ := countingLinkSystem(storage)
+	s, err := mutable.NewSession(ctx, ls, nil, mutable.WithChunker("size-1024"))
+	require.NoError(t, err)
+	require.NoError(t, s.Mkdir("/a", false))
+	require.NoError(t, s.WriteFile("/a/file", bytes.NewReader(random.Bytes(100000))))
+	root, _, err := s.Flush()
+	require.NoError(t, err)
+	expected := enumerate(t, ls, root)
+
+	cids, errCh := dagwalk.EnumerateChan(ctx, ls, root)
+	got := make(map[cid.Cid]int)
+	for c := range cids {
+		got[c] = expected[c]
+	}
+	require.NoError(t, <-errCh)
+	require.Equal(t, expected, got)
+
+	// an error from the callback stops the walk
+	stop := errors.New("stop")
+	var count int
+	err = dagwalk.Enumerate(ctx, ls, root, func(cid.Cid, int) error {
+		count++
+		if count == 3 {
+			return stop
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, stop)
+	require.Equal(t, 3, count)
+
+	// as does cancelling the context
+	cctx, cancel := context.WithCancel(ctx)
+	cids, errCh = dagwalk.EnumerateChan(cctx, ls, root)
+	<-cids
+	cancel()
+	require.ErrorIs(t, <-errCh, context.Canceled)
+}
@@ -0,0 +1,68 @@
+package dagwalk
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// CarOrder calls cb with the CID of each block of the DAG under root in the
+// order a CAR of the whole DAG is expected to hold them: the root first, then
+// the blocks under each of its links in turn, depth first in link order. A
+// block reached more than once is reported, and walked, only where it is first
+// reached, so each block follows the block that links to it and a CAR written
+// in this order can be verified as it is streamed.
+//
+// Only dag-pb links are followed; blocks of any other codec are reported
+// without being loaded. Returning an error from cb stops the walk, and
+// CarOrder returns the error.
+func CarOrder(ctx context.Context, lsys *ipld.LinkSystem, root ipld.Link, cb func(c cid.Cid) error) error {
+	cl, ok := root.(cidlink.Link)
+	if !ok {
+		return fmt.Errorf("unsupported link type: %T", root)
+	}
+	lnkCtx := linking.LinkContext{Ctx: ctx}
+	seen := make(map[cid.Cid]struct{})
+	var visit func(c cid.Cid) error
+	visit = func(c cid.Cid) error {
+		if _, ok := seen[c]; ok {
+			return nil
+		}
+		seen[c] = struct{}{}
+		if err := cb(c); err != nil {
+			return err
+		}
+		if c.Prefix().Codec != cid.DagProtobuf {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		raw, err := lsys.LoadRaw(lnkCtx, cidlink.Link{Cid: c})
+		if err != nil {
+			return err
+		}
+		nb := dagpb.Type.PBNode.NewBuilder()
+		if err := dagpb.DecodeBytes(nb, raw); err != nil {
+			return fmt.Errorf("block %s: %w", c, err)
+		}
+		itr := nb.Build().(dagpb.PBNode).FieldLinks().Iterator()
+		for !itr.Done() {
+			_, lnk := itr.Next()
+			cl, ok := lnk.FieldHash().Link().(cidlink.Link)
+			if !ok {
+				return fmt.Errorf("unsupported link type: %T", lnk.FieldHash().Link())
+			}
+			if err := visit(cl.Cid); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return visit(cl.Cid)
+}
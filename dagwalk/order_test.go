@@ -0,0 +1,84 @@
+package dagwalk_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/dagwalk"
+	"github.com/ipfs/go-unixfsnode/mutable"
+	"github.com/ipld/go-car/v2"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCarOrder(t *testing.T) {
+	ctx := context.Background()
+	storage := &cidlink.Memory{}
+	ls, _ := countingLinkSystem(storage)
+
+	s, err := mutable.NewSession(ctx, ls, nil, mutable.WithChunker("size-1024"))
+	require.NoError(t, err)
+	require.NoError(t, s.Mkdir("/a/b", true))
+	same := random.Bytes(30000)
+	require.NoError(t, s.WriteFile("/a/b/same", bytes.NewReader(same)))
+	require.NoError(t, s.WriteFile("/a/other", bytes.NewReader(random.Bytes(20000))))
+	require.NoError(t, s.WriteFile("/same", bytes.NewReader(same)))
+	require.NoError(t, s.WriteFile("/zeros", bytes.NewReader(make([]byte, 10000))))
+	root, _, err := s.Flush()
+	require.NoError(t, err)
+	rootCid := root.(cidlink.Link).Cid
+
+	var got []cid.Cid
+	require.NoError(t, dagwalk.CarOrder(ctx, ls, root, func(c cid.Cid) error {
+		got = append(got, c)
+		return nil
+	}))
+	require.Equal(t, rootCid, got[0])
+	require.Len(t, got, len(reachable(t, storage, rootCid)))
+
+	// the same order go-car writes a CAR of the whole DAG in
+	var buf bytes.Buffer
+	_, err = car.TraverseV1(ctx, ls, rootCid, selectorparse.CommonSelector_ExploreAllRecursively, &buf)
+	require.NoError(t, err)
+	br, err := car.NewBlockReader(&buf)
+	require.NoError(t, err)
+	var expected []cid.Cid
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		expected = append(expected, blk.Cid())
+	}
+	require.Equal(t, expected, got)
+
+	// a LinkSystem that reifies UnixFS nodes walks the same blocks
+	rls := *ls
+	rls.NodeReifier = unixfsnode.Reify
+	var reified []cid.Cid
+	require.NoError(t, dagwalk.CarOrder(ctx, &rls, root, func(c cid.Cid) error {
+		reified = append(reified, c)
+		return nil
+	}))
+	require.Equal(t, got, reified)
+
+	stop := errors.New("stop")
+	var count int
+	err = dagwalk.CarOrder(ctx, ls, root, func(cid.Cid) error {
+		count++
+		if count == 3 {
+			return stop
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, stop)
+	require.Equal(t, 3, count)
+}
@@ -0,0 +1,308 @@
+// Package dagwalk walks the blocks of UnixFS DAGs without interpreting their
+// content, for tasks such as pinning and garbage collection that care about
+// which blocks a DAG is made of rather than what it represents.
+package dagwalk
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+type options struct {
+	skipDedupCheck bool
+}
+
+// Option configures Unreferenced.
+type Option func(*options)
+
+// SkipDedupCheck skips the check of shared subtrees for blocks which are also
+// referenced from the changed parts of the old DAG, such as a chunk that
+// appears in both a deleted file and a file that was left untouched. With this
+// option no block of a shared subtree is ever loaded, but such deduplicated
+// blocks will be reported as unreferenced even though the new DAG still uses
+// them, so the result must not be used to delete blocks unless the DAGs are
+// known not to contain duplicate content.
+func SkipDedupCheck() Option {
+	return func(o *options) {
+		o.skipDedupCheck = true
+	}
+}
+
+type link struct {
+	name string
+	cid  cid.Cid
+}
+
+type differ struct {
+	ctx    context.Context
+	lsys   *ipld.LinkSystem
+	lnkCtx linking.LinkContext
+	links  map[cid.Cid][]link
+
+	// blocks reached from the old and new roots outside of shared subtrees
+	oldRegion map[cid.Cid]struct{}
+	oldOrder  []cid.Cid
+	newRegion map[cid.Cid]struct{}
+	// roots of subtrees that appear at the same position in both DAGs
+	shared map[cid.Cid]struct{}
+}
+
+// Unreferenced returns the CIDs of blocks reachable from oldRoot but not from
+// newRoot, which are the candidates for garbage collection once newRoot
+// replaces oldRoot.
+//
+// The two DAGs are compared structurally: children of directory and HAMT
+// nodes are paired by name and children of file nodes by position, and any
+// pair with the same CID is a shared subtree that is not walked. This makes
+// the cost proportional to the size of the changes, as when newRoot was
+// produced by editing oldRoot. Changed subtrees are walked in full, in both
+// DAGs, so content that was moved rather than removed is not reported.
+//
+// Blocks of a changed part of the old DAG may still be referenced from inside
+// a shared subtree when the DAG contains duplicate content. To catch these,
+// the interior nodes of shared subtrees are walked until every candidate has
+// been accounted for, without loading any leaves; see SkipDedupCheck.
+func Unreferenced(ctx context.Context, lsys *ipld.LinkSystem, oldRoot, newRoot ipld.Link, opts ...Option) ([]cid.Cid, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	oldCl, ok := oldRoot.(cidlink.Link)
+	if !ok {
+		return nil, fmt.Errorf("unsupported link type: %T", oldRoot)
+	}
+	newCl, ok := newRoot.(cidlink.Link)
+	if !ok {
+		return nil, fmt.Errorf("unsupported link type: %T", newRoot)
+	}
+
+	d := &differ{
+		ctx:       ctx,
+		lsys:      lsys,
+		lnkCtx:    linking.LinkContext{Ctx: ctx},
+		links:     make(map[cid.Cid][]link),
+		oldRegion: make(map[cid.Cid]struct{}),
+		newRegion: make(map[cid.Cid]struct{}),
+		shared:    make(map[cid.Cid]struct{}),
+	}
+	if err := d.diff(oldCl.Cid, newCl.Cid); err != nil {
+		return nil, err
+	}
+
+	candidates := make(map[cid.Cid]struct{})
+	for c := range d.oldRegion {
+		_, inNew := d.newRegion[c]
+		_, isShared := d.shared[c]
+		if !inNew && !isShared {
+			candidates[c] = struct{}{}
+		}
+	}
+	if !o.skipDedupCheck && len(candidates) > 0 {
+		if err := d.excludeShared(candidates); err != nil {
+			return nil, err
+		}
+	}
+
+	unreferenced := make([]cid.Cid, 0, len(candidates))
+	for _, c := range d.oldOrder {
+		if _, ok := candidates[c]; ok {
+			unreferenced = append(unreferenced, c)
+		}
+	}
+	return unreferenced, nil
+}
+
+func (d *differ) diff(oldC, newC cid.Cid) error {
+	if oldC.Equals(newC) {
+		d.shared[oldC] = struct{}{}
+		return nil
+	}
+	d.addOld(oldC)
+	d.newRegion[newC] = struct{}{}
+	oldLinks, err := d.load(oldC)
+	if err != nil {
+		return err
+	}
+	newLinks, err := d.load(newC)
+	if err != nil {
+		return err
+	}
+
+	pairs, oldRest, newRest := pairLinks(oldLinks, newLinks)
+	for _, p := range pairs {
+		if err := d.diff(p[0], p[1]); err != nil {
+			return err
+		}
+	}
+	for _, c := range newRest {
+		if err := d.walk(c, d.newRegion, nil); err != nil {
+			return err
+		}
+	}
+	for _, c := range oldRest {
+		if err := d.walk(c, d.oldRegion, &d.oldOrder); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *differ) addOld(c cid.Cid) {
+	if _, ok := d.oldRegion[c]; !ok {
+		d.oldRegion[c] = struct{}{}
+		d.oldOrder = append(d.oldOrder, c)
+	}
+}
+
+// walk adds every block under c to region.
+func (d *differ) walk(c cid.Cid, region map[cid.Cid]struct{}, order *[]cid.Cid) error {
+	if _, ok := region[c]; ok {
+		return nil
+	}
+	region[c] = struct{}{}
+	if order != nil {
+		*order = append(*order, c)
+	}
+	links, err := d.load(c)
+	if err != nil {
+		return err
+	}
+	for _, l := range links {
+		if err := d.walk(l.cid, region, order); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// excludeShared removes candidates which are found inside shared subtrees,
+// stopping as soon as there are none left.
+func (d *differ) excludeShared(candidates map[cid.Cid]struct{}) error {
+	visited := make(map[cid.Cid]struct{})
+	var visit func(c cid.Cid) error
+	visit = func(c cid.Cid) error {
+		if len(candidates) == 0 {
+			return nil
+		}
+		if _, ok := visited[c]; ok {
+			return nil
+		}
+		visited[c] = struct{}{}
+		delete(candidates, c)
+		if c.Prefix().Codec != cid.DagProtobuf {
+			// only dag-pb blocks have links, so there is nothing to gain from
+			// loading anything else
+			return nil
+		}
+		links, err := d.load(c)
+		if err != nil {
+			return err
+		}
+		for _, l := range links {
+			if err := visit(l.cid); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for c := range d.shared {
+		if err := visit(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *differ) load(c cid.Cid) ([]link, error) {
+	if links, ok := d.links[c]; ok {
+		return links, nil
+	}
+	if c.Prefix().Codec != cid.DagProtobuf {
+		// leaves have no links, and needn't be loaded to know it
+		return nil, nil
+	}
+	if err := d.ctx.Err(); err != nil {
+		return nil, err
+	}
+	raw, err := d.lsys.LoadRaw(d.lnkCtx, cidlink.Link{Cid: c})
+	if err != nil {
+		return nil, err
+	}
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagpb.DecodeBytes(nb, raw); err != nil {
+		return nil, fmt.Errorf("block %s: %w", c, err)
+	}
+	pbnd := nb.Build().(dagpb.PBNode)
+	links := make([]link, 0, pbnd.FieldLinks().Length())
+	itr := pbnd.FieldLinks().Iterator()
+	for !itr.Done() {
+		_, lnk := itr.Next()
+		cl, ok := lnk.FieldHash().Link().(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("unsupported link type: %T", lnk.FieldHash().Link())
+		}
+		var name string
+		if lnk.FieldName().Exists() {
+			name = lnk.FieldName().Must().String()
+		}
+		links = append(links, link{name: name, cid: cl.Cid})
+	}
+	d.links[c] = links
+	return links, nil
+}
+
+// pairLinks pairs the links of two versions of a node, by name where every
+// link of both has a distinct name, as in directories and HAMT shards, and
+// otherwise by position, as in files. Links without a counterpart are
+// returned separately.
+func pairLinks(oldLinks, newLinks []link) (pairs [][2]cid.Cid, oldRest, newRest []cid.Cid) {
+	if byName, ok := namedLinks(newLinks); ok {
+		if _, ok := namedLinks(oldLinks); ok {
+			for _, l := range oldLinks {
+				if c, ok := byName[l.name]; ok {
+					pairs = append(pairs, [2]cid.Cid{l.cid, c})
+					delete(byName, l.name)
+				} else {
+					oldRest = append(oldRest, l.cid)
+				}
+			}
+			for _, l := range newLinks {
+				if _, ok := byName[l.name]; ok {
+					newRest = append(newRest, l.cid)
+				}
+			}
+			return pairs, oldRest, newRest
+		}
+	}
+	n := min(len(oldLinks), len(newLinks))
+	for i := 0; i < n; i++ {
+		pairs = append(pairs, [2]cid.Cid{oldLinks[i].cid, newLinks[i].cid})
+	}
+	for _, l := range oldLinks[n:] {
+		oldRest = append(oldRest, l.cid)
+	}
+	for _, l := range newLinks[n:] {
+		newRest = append(newRest, l.cid)
+	}
+	return pairs, oldRest, newRest
+}
+
+func namedLinks(links []link) (map[string]cid.Cid, bool) {
+	byName := make(map[string]cid.Cid, len(links))
+	for _, l := range links {
+		if l.name == "" {
+			return nil, false
+		}
+		if _, ok := byName[l.name]; ok {
+			return nil, false
+		}
+		byName[l.name] = l.cid
+	}
+	return byName, true
+}
@@ -0,0 +1,168 @@
+package dagwalk_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode/dagwalk"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/mutable"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+// countingLinkSystem returns a LinkSystem over storage and a function that
+// returns, and resets, the set of blocks loaded through it.
+func countingLinkSystem(storage *cidlink.Memory) (*ipld.LinkSystem, func() map[cid.Cid]struct{}) {
+	ls := cidlink.DefaultLinkSystem()
+	loaded := make(map[cid.Cid]struct{})
+	ls.StorageReadOpener = func(lc linking.LinkContext, l ipld.Link) (io.Reader, error) {
+		loaded[l.(cidlink.Link).Cid] = struct{}{}
+		return storage.OpenRead(lc, l)
+	}
+	ls.StorageWriteOpener = storage.OpenWrite
+	return &ls, func() map[cid.Cid]struct{} {
+		l := loaded
+		loaded = make(map[cid.Cid]struct{})
+		return l
+	}
+}
+
+// reachable returns every CID under root by brute force.
+func reachable(t *testing.T, storage *cidlink.Memory, root cid.Cid) map[cid.Cid]struct{} {
+	out := make(map[cid.Cid]struct{})
+	var walk func(c cid.Cid)
+	walk = func(c cid.Cid) {
+		if _, ok := out[c]; ok {
+			return
+		}
+		out[c] = struct{}{}
+		if c.Prefix().Codec != cid.DagProtobuf {
+			return
+		}
+		nb := dagpb.Type.PBNode.NewBuilder()
+		require.NoError(t, dagpb.DecodeBytes(nb, storage.Bag[string(c.Hash())]))
+		itr := nb.Build().(dagpb.PBNode).FieldLinks().Iterator()
+		for !itr.Done() {
+			_, lnk := itr.Next()
+			walk(lnk.FieldHash().Link().(cidlink.Link).Cid)
+		}
+	}
+	walk(root)
+	return out
+}
+
+// fileCid returns the CID content is imported under, with 1KiB chunks.
+func fileCid(t *testing.T, content []byte) cid.Cid {
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageWriteOpener = (&cidlink.Memory{}).OpenWrite
+	lnk, _, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls)
+	require.NoError(t, err)
+	return lnk.(cidlink.Link).Cid
+}
+
+func onlyIn(a, b map[cid.Cid]struct{}) map[cid.Cid]struct{} {
+	out := make(map[cid.Cid]struct{})
+	for c := range a {
+		if _, ok := b[c]; !ok {
+			out[c] = struct{}{}
+		}
+	}
+	return out
+}
+
+func asSet(cids []cid.Cid) map[cid.Cid]struct{} {
+	out := make(map[cid.Cid]struct{})
+	for _, c := range cids {
+		out[c] = struct{}{}
+	}
+	return out
+}
+
+func TestUnreferenced(t *testing.T) {
+	ctx := context.Background()
+	storage := &cidlink.Memory{}
+	ls, loaded := countingLinkSystem(storage)
+
+	s, err := mutable.NewSession(ctx, ls, nil, mutable.WithChunker("size-1024"))
+	require.NoError(t, err)
+	for _, p := range []string{"/a/b/c", "/a/d", "/e"} {
+		require.NoError(t, s.Mkdir(p, true))
+	}
+	content := make(map[string][]byte)
+	for _, p := range []string{"/a/b/c/1", "/a/b/c/2", "/a/d/3", "/e/4", "/e/5", "/6"} {
+		content[p] = random.Bytes(50000)
+		require.NoError(t, s.WriteFile(p, bytes.NewReader(content[p])))
+	}
+	oldRoot, _, err := s.Flush()
+	require.NoError(t, err)
+
+	require.NoError(t, s.Rm("/a/b/c/1", false))
+	require.NoError(t, s.WriteFile("/a/d/3", bytes.NewReader(random.Bytes(20000))))
+	require.NoError(t, s.Mv("/e/4", "/a/4"))
+	newRoot, _, err := s.Flush()
+	require.NoError(t, err)
+
+	expected := onlyIn(reachable(t, storage, oldRoot.(cidlink.Link).Cid), reachable(t, storage, newRoot.(cidlink.Link).Cid))
+	loaded()
+	got, err := dagwalk.Unreferenced(ctx, ls, oldRoot, newRoot)
+	require.NoError(t, err)
+	require.Equal(t, expected, asSet(got))
+	require.Len(t, got, len(expected))
+
+	for c := range loaded() {
+		require.Equal(t, uint64(cid.DagProtobuf), c.Prefix().Codec, "no leaves should be loaded")
+	}
+
+	// without duplicate content the result is the same when shared subtrees,
+	// such as the untouched file under /e, are never loaded
+	got, err = dagwalk.Unreferenced(ctx, ls, oldRoot, newRoot, dagwalk.SkipDedupCheck())
+	require.NoError(t, err)
+	require.Equal(t, expected, asSet(got))
+	five := fileCid(t, content["/e/5"])
+	require.Contains(t, reachable(t, storage, newRoot.(cidlink.Link).Cid), five)
+	require.NotContains(t, loaded(), five)
+}
+
+func TestUnreferencedDeduplicated(t *testing.T) {
+	ctx := context.Background()
+	storage := &cidlink.Memory{}
+	ls, loaded := countingLinkSystem(storage)
+
+	// two files starting with the same chunk
+	common := random.Bytes(1024)
+	s, err := mutable.NewSession(ctx, ls, nil, mutable.WithChunker("size-1024"))
+	require.NoError(t, err)
+	require.NoError(t, s.Mkdir("/keep", false))
+	x := append(append([]byte{}, common...), random.Bytes(5000)...)
+	require.NoError(t, s.WriteFile("/keep/x", bytes.NewReader(x)))
+	require.NoError(t, s.WriteFile("/y", bytes.NewReader(append(append([]byte{}, common...), random.Bytes(5000)...))))
+	oldRoot, _, err := s.Flush()
+	require.NoError(t, err)
+	require.NoError(t, s.Rm("/y", false))
+	newRoot, _, err := s.Flush()
+	require.NoError(t, err)
+
+	expected := onlyIn(reachable(t, storage, oldRoot.(cidlink.Link).Cid), reachable(t, storage, newRoot.(cidlink.Link).Cid))
+	loaded()
+	got, err := dagwalk.Unreferenced(ctx, ls, oldRoot, newRoot)
+	require.NoError(t, err)
+	require.Equal(t, expected, asSet(got))
+	for c := range loaded() {
+		require.Equal(t, uint64(cid.DagProtobuf), c.Prefix().Codec, "no leaves should be loaded")
+	}
+
+	// skipping the check reports the common chunk too, without loading /keep
+	got, err = dagwalk.Unreferenced(ctx, ls, oldRoot, newRoot, dagwalk.SkipDedupCheck())
+	require.NoError(t, err)
+	require.Len(t, got, len(expected)+1)
+	require.Contains(t, got, fileCid(t, common))
+	require.NotContains(t, loaded(), fileCid(t, x))
+}
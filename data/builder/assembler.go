@@ -0,0 +1,125 @@
+package builder
+
+import (
+	"errors"
+	"hash"
+
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/multiformats/go-multihash"
+)
+
+var errMissingEntryName = errors.New("directory entry has no name")
+
+// DirAssembler builds a directory from entries added one at a time, such as
+// while they are produced, rather than from a slice of links collected first.
+//
+// Entries are kept as links until the directory crosses the sharding
+// threshold (see WithShardingThreshold and WithShardingEntryCount), when they
+// are moved into a HAMT; from then on each entry is hashed and placed in the
+// HAMT as it's added. Memory is not bounded: the HAMT holds every entry until
+// Build, as no shard can be stored before all of the entries that may land in
+// it are known. Unlike BuildUnixFSDirectory, entries of the same name
+// are rejected with ErrDuplicateName whether or not the directory is sharded,
+// unless WithDuplicateNames is given.
+type DirAssembler struct {
+	ls   *ipld.LinkSystem
+	opts []DirectoryOption
+	o    directoryOptions
+
+	count   int
+	entries []dagpb.PBLink
+	names   map[string]struct{}
+	size    int
+
+	sharder *shard
+	hasher  hash.Hash
+}
+
+// NewDirAssembler creates a DirAssembler which stores the directory through ls.
+func NewDirAssembler(ls *ipld.LinkSystem, opts ...DirectoryOption) *DirAssembler {
+	return &DirAssembler{
+		ls:    ls,
+		opts:  opts,
+		o:     applyDirectoryOptions(opts),
+		names: make(map[string]struct{}),
+	}
+}
+
+// Add adds an entry named name, linking to the DAG at lnk of total size
+// size.
+func (da *DirAssembler) Add(name string, lnk ipld.Link, size uint64) error {
+	e, err := BuildUnixFSDirectoryEntry(name, int64(size), lnk)
+	if err != nil {
+		return err
+	}
+	return da.add(e)
+}
+
+// add adds the directory entry e, which must have a name.
+func (da *DirAssembler) add(e dagpb.PBLink) error {
+	if !e.Name.Exists() {
+		return errMissingEntryName
+	}
+	name, lnk := e.Name.Must().String(), e.Hash.Link()
+	if da.sharder != nil {
+		if err := da.addToShard(e); err != nil {
+			return err
+		}
+		da.count++
+		return nil
+	}
+	if _, ok := da.names[name]; ok && !da.o.allowDuplicates {
+		return ErrDuplicateName{Name: name}
+	}
+	da.names[name] = struct{}{}
+	da.entries = append(da.entries, e)
+	da.count++
+	da.size += estimateEntrySize(name, lnk)
+	if !da.o.needsSharding(len(da.entries), da.size) {
+		return nil
+	}
+
+	// upgrade to a HAMT
+	newHasher, err := shardHasher(multihash.MURMUR3X64_64)
+	if err != nil {
+		return err
+	}
+	sharder, err := newRootShard(da.o.shardFanout, multihash.MURMUR3X64_64, da.o)
+	if err != nil {
+		return err
+	}
+	da.sharder, da.hasher = sharder, newHasher()
+	for _, e := range da.entries {
+		if err := da.addToShard(e); err != nil {
+			return err
+		}
+	}
+	da.entries, da.names = nil, nil
+	return nil
+}
+
+func (da *DirAssembler) addToShard(e dagpb.PBLink) error {
+	da.hasher.Reset()
+	da.hasher.Write([]byte(e.Name.Must().String()))
+	return da.sharder.add(&hamtLink{da.hasher.Sum(nil), e})
+}
+
+// Len returns the number of entries added.
+func (da *DirAssembler) Len() int {
+	return da.count
+}
+
+// Sharded reports whether the directory has been upgraded to a HAMT.
+func (da *DirAssembler) Sharded() bool {
+	return da.sharder != nil
+}
+
+// Build stores the directory and returns a link to it and its total size. The
+// DirAssembler must not be used afterwards.
+func (da *DirAssembler) Build() (ipld.Link, uint64, error) {
+	if da.sharder != nil {
+		return da.sharder.serialize(da.ls)
+	}
+	return BuildUnixFSDirectory(da.entries, da.ls, da.opts...)
+}
@@ -0,0 +1,60 @@
+package builder
+
+import (
+	"testing"
+
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirAssembler(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	for _, cnt := range []int{0, 100, 8000} {
+		entries, err := mkEntries(cnt, &ls)
+		require.NoError(t, err)
+		expected, expectedSize, err := BuildUnixFSDirectory(entries, &ls)
+		require.NoError(t, err)
+
+		da := NewDirAssembler(&ls)
+		for _, e := range entries {
+			require.NoError(t, da.Add(e.Name.Must().String(), e.Hash.Link(), uint64(e.Tsize.Must().Int())))
+		}
+		require.Equal(t, cnt, da.Len())
+		require.Equal(t, estimateDirSize(entries) > shardSplitThreshold, da.Sharded())
+		lnk, size, err := da.Build()
+		require.NoError(t, err)
+		require.Equal(t, expected, lnk, cnt)
+		require.Equal(t, expectedSize, size, cnt)
+	}
+}
+
+func TestDirAssemblerDuplicateNames(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	entries, err := mkEntries(10, &ls)
+	require.NoError(t, err)
+	for _, opts := range [][]DirectoryOption{nil, {WithShardingEntryCount(5)}} {
+		da := NewDirAssembler(&ls, opts...)
+		for _, e := range entries {
+			require.NoError(t, da.Add(e.Name.Must().String(), e.Hash.Link(), uint64(e.Tsize.Must().Int())))
+		}
+		require.Equal(t, len(opts) > 0, da.Sharded())
+		err := da.Add("file 3", entries[0].Hash.Link(), 1)
+		require.ErrorIs(t, err, ErrDuplicateName{Name: "file 3"})
+
+		da = NewDirAssembler(&ls, append(opts, WithDuplicateNames())...)
+		for _, e := range entries {
+			require.NoError(t, da.Add(e.Name.Must().String(), e.Hash.Link(), uint64(e.Tsize.Must().Int())))
+		}
+		require.NoError(t, da.Add("file 3", entries[0].Hash.Link(), 1))
+		_, _, err = da.Build()
+		require.NoError(t, err)
+	}
+}
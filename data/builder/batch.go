@@ -0,0 +1,218 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// DefaultBatchBlocks and DefaultBatchBytes are the limits at which a
+// BatchWriter flushes, unless configured otherwise.
+const (
+	DefaultBatchBlocks = 256
+	DefaultBatchBytes  = 16 << 20
+)
+
+type batchOptions struct {
+	maxBlocks int
+	maxBytes  int
+	storage   BatchStorage
+}
+
+// BatchStorage is storage that can write many blocks in one call, such as a
+// boxo blockstore.
+type BatchStorage interface {
+	PutMany(ctx context.Context, blks []blocks.Block) error
+}
+
+// BatchOption configures a BatchWriter.
+type BatchOption func(*batchOptions)
+
+// WithMaxBatchBlocks sets the number of blocks a BatchWriter collects before
+// it flushes. A value of zero or less removes the limit.
+func WithMaxBatchBlocks(n int) BatchOption {
+	return func(o *batchOptions) {
+		o.maxBlocks = n
+	}
+}
+
+// WithMaxBatchBytes sets the total size of the blocks a BatchWriter collects
+// before it flushes. A value of zero or less removes the limit.
+func WithMaxBatchBytes(n int) BatchOption {
+	return func(o *batchOptions) {
+		o.maxBytes = n
+	}
+}
+
+// WithBatchStorage has a BatchWriter flush each batch with a single PutMany
+// call to s, rather than writing its blocks one at a time to the
+// StorageWriteOpener of the underlying LinkSystem.
+func WithBatchStorage(s BatchStorage) BatchOption {
+	return func(o *batchOptions) {
+		o.storage = s
+	}
+}
+
+// BatchWriter collects the blocks written through its LinkSystem in memory
+// until a number of blocks or bytes has been collected, or Flush is called,
+// and then flushes them together. A batch is written with one PutMany call to
+// the storage given by WithBatchStorage, if any; otherwise its blocks are
+// written in turn to the StorageWriteOpener of the underlying LinkSystem, which
+// defers the writes but doesn't make fewer of them. Blocks which have been
+// collected but not yet flushed can be read back through its LinkSystem.
+//
+// Flush must be called once building is complete, or the last blocks written
+// will be lost. Errors from writing a batch are returned from the Store call
+// that filled it, or from Flush. A BatchWriter is safe for concurrent use.
+type BatchWriter struct {
+	opts  batchOptions
+	write linking.BlockWriteOpener
+	read  linking.BlockReadOpener
+	ls    ipld.LinkSystem
+
+	lk sync.Mutex
+	// pending holds links in the order they were stored, and blocks their
+	// data keyed by the binary form of the link
+	pending []ipld.Link
+	blocks  map[string][]byte
+	size    int
+}
+
+// NewBatchWriter creates a BatchWriter that flushes to the storage of ls.
+func NewBatchWriter(ls *ipld.LinkSystem, opts ...BatchOption) *BatchWriter {
+	o := batchOptions{
+		maxBlocks: DefaultBatchBlocks,
+		maxBytes:  DefaultBatchBytes,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	bw := &BatchWriter{
+		opts:   o,
+		write:  ls.StorageWriteOpener,
+		read:   ls.StorageReadOpener,
+		blocks: make(map[string][]byte),
+	}
+	bw.ls = *ls
+	bw.ls.StorageWriteOpener = bw.openWrite
+	bw.ls.StorageReadOpener = bw.openRead
+	return bw
+}
+
+// LinkSystem returns a copy of the underlying LinkSystem that stores blocks
+// through the BatchWriter, for use with the builders.
+func (bw *BatchWriter) LinkSystem() *ipld.LinkSystem {
+	ls := bw.ls
+	return &ls
+}
+
+// Pending returns the number of blocks, and their total size, waiting to be
+// flushed.
+func (bw *BatchWriter) Pending() (int, int) {
+	bw.lk.Lock()
+	defer bw.lk.Unlock()
+	return len(bw.pending), bw.size
+}
+
+// Flush writes all pending blocks to the underlying storage, in the order they
+// were stored. If a write fails, the blocks not yet written remain pending;
+// with WithBatchStorage, that is all of them.
+func (bw *BatchWriter) Flush(ctx context.Context) error {
+	bw.lk.Lock()
+	defer bw.lk.Unlock()
+	return bw.flush(ctx)
+}
+
+func (bw *BatchWriter) flush(ctx context.Context) error {
+	if bw.opts.storage != nil {
+		return bw.putMany(ctx)
+	}
+	for len(bw.pending) > 0 {
+		lnk := bw.pending[0]
+		data := bw.blocks[lnk.Binary()]
+		w, commit, err := bw.write(ipld.LinkContext{Ctx: ctx})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		if err := commit(lnk); err != nil {
+			return err
+		}
+		delete(bw.blocks, lnk.Binary())
+		bw.size -= len(data)
+		bw.pending = bw.pending[1:]
+	}
+	bw.pending = nil
+	return nil
+}
+
+func (bw *BatchWriter) putMany(ctx context.Context) error {
+	if len(bw.pending) == 0 {
+		return nil
+	}
+	blks := make([]blocks.Block, 0, len(bw.pending))
+	for _, lnk := range bw.pending {
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return fmt.Errorf("unsupported link type: %T", lnk)
+		}
+		blk, err := blocks.NewBlockWithCid(bw.blocks[lnk.Binary()], cl.Cid)
+		if err != nil {
+			return err
+		}
+		blks = append(blks, blk)
+	}
+	if err := bw.opts.storage.PutMany(ctx, blks); err != nil {
+		return err
+	}
+	bw.pending = nil
+	bw.blocks = make(map[string][]byte)
+	bw.size = 0
+	return nil
+}
+
+func (bw *BatchWriter) openWrite(lnkCtx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+	var buf bytes.Buffer
+	return &buf, func(lnk ipld.Link) error {
+		bw.lk.Lock()
+		defer bw.lk.Unlock()
+		key := lnk.Binary()
+		if _, ok := bw.blocks[key]; ok {
+			return nil
+		}
+		bw.blocks[key] = buf.Bytes()
+		bw.pending = append(bw.pending, lnk)
+		bw.size += buf.Len()
+		if (bw.opts.maxBlocks > 0 && len(bw.pending) >= bw.opts.maxBlocks) ||
+			(bw.opts.maxBytes > 0 && bw.size >= bw.opts.maxBytes) {
+			ctx := lnkCtx.Ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			return bw.flush(ctx)
+		}
+		return nil
+	}, nil
+}
+
+func (bw *BatchWriter) openRead(lnkCtx linking.LinkContext, lnk ipld.Link) (io.Reader, error) {
+	bw.lk.Lock()
+	data, ok := bw.blocks[lnk.Binary()]
+	bw.lk.Unlock()
+	if ok {
+		return bytes.NewReader(data), nil
+	}
+	if bw.read == nil {
+		return nil, fmt.Errorf("block %s not found in batch, and no storage configured for reading", lnk)
+	}
+	return bw.read(lnkCtx, lnk)
+}
@@ -0,0 +1,161 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-test/random"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchWriter(t *testing.T) {
+	storage := &cidlink.Memory{}
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageReadOpener = storage.OpenRead
+	var writes int
+	ls.StorageWriteOpener = func(lc linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		writes++
+		return storage.OpenWrite(lc)
+	}
+
+	bw := NewBatchWriter(&ls, WithMaxBatchBlocks(10))
+	content := random.Bytes(1 << 20)
+	// 1MiB in 16KiB chunks is 64 leaves and a root
+	file, size, err := BuildUnixFSFile(bytes.NewReader(content), "size-16384", bw.LinkSystem())
+	require.NoError(t, err)
+	require.Equal(t, 60, writes)
+	blocks, pendingSize := bw.Pending()
+	require.Equal(t, 5, blocks)
+	require.Greater(t, pendingSize, 0)
+
+	// pending blocks can be read back before they are flushed
+	entry, err := BuildUnixFSDirectoryEntry("file", int64(size), file)
+	require.NoError(t, err)
+	dir, _, err := BuildUnixFSDirectory([]dagpb.PBLink{entry}, bw.LinkSystem())
+	require.NoError(t, err)
+	_, err = bw.LinkSystem().LoadRaw(ipld.LinkContext{}, dir)
+	require.NoError(t, err)
+	_, err = ls.LoadRaw(ipld.LinkContext{}, dir)
+	require.Error(t, err)
+
+	require.NoError(t, bw.Flush(context.Background()))
+	require.Equal(t, 66, writes)
+	blocks, pendingSize = bw.Pending()
+	require.Zero(t, blocks)
+	require.Zero(t, pendingSize)
+
+	unbatched, _, err := BuildUnixFSFile(bytes.NewReader(content), "size-16384", &ls)
+	require.NoError(t, err)
+	require.Equal(t, unbatched, file)
+	_, err = ls.LoadRaw(ipld.LinkContext{}, dir)
+	require.NoError(t, err)
+}
+
+func TestBatchWriterBytesAndErrors(t *testing.T) {
+	storage := &cidlink.Memory{}
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageReadOpener = storage.OpenRead
+	fail := errors.New("storage unavailable")
+	var failing bool
+	ls.StorageWriteOpener = func(lc linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		if failing {
+			return nil, nil, fail
+		}
+		return storage.OpenWrite(lc)
+	}
+
+	bw := NewBatchWriter(&ls, WithMaxBatchBlocks(0), WithMaxBatchBytes(100000))
+	_, _, err := BuildUnixFSFile(bytes.NewReader(random.Bytes(50000)), "size-16384", bw.LinkSystem())
+	require.NoError(t, err)
+	require.Empty(t, storage.Bag)
+	blocks, _ := bw.Pending()
+	require.Equal(t, 5, blocks)
+
+	// a failed flush keeps what it couldn't write
+	failing = true
+	_, _, err = BuildUnixFSFile(bytes.NewReader(random.Bytes(70000)), "size-16384", bw.LinkSystem())
+	require.ErrorIs(t, err, fail)
+	require.ErrorIs(t, bw.Flush(context.Background()), fail)
+	blocks, _ = bw.Pending()
+	require.Greater(t, blocks, 5)
+
+	failing = false
+	require.NoError(t, bw.Flush(context.Background()))
+	require.Len(t, storage.Bag, blocks)
+}
+
+// putManyStorage is BatchStorage over a cidlink.Memory, recording the size of
+// each batch it's given
+type putManyStorage struct {
+	cidlink.Memory
+	batches []int
+	fail    error
+	lastCtx context.Context
+}
+
+func (s *putManyStorage) PutMany(ctx context.Context, blks []blocks.Block) error {
+	if s.fail != nil {
+		return s.fail
+	}
+	s.batches = append(s.batches, len(blks))
+	s.lastCtx = ctx
+	for _, blk := range blks {
+		w, commit, err := s.OpenWrite(ipld.LinkContext{Ctx: ctx})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(blk.RawData()); err != nil {
+			return err
+		}
+		if err := commit(cidlink.Link{Cid: blk.Cid()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestBatchWriterPutMany(t *testing.T) {
+	storage := &putManyStorage{}
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = func(lc linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		t.Fatal("blocks must be written with PutMany")
+		return nil, nil, nil
+	}
+
+	bw := NewBatchWriter(&ls, WithMaxBatchBlocks(10), WithBatchStorage(storage))
+	content := random.Bytes(1 << 20)
+	file, _, err := BuildUnixFSFile(bytes.NewReader(content), "size-16384", bw.LinkSystem())
+	require.NoError(t, err)
+	require.Equal(t, []int{10, 10, 10, 10, 10, 10}, storage.batches)
+
+	// a failed batch is kept whole
+	storage.fail = errors.New("storage unavailable")
+	require.ErrorIs(t, bw.Flush(context.Background()), storage.fail)
+	pending, _ := bw.Pending()
+	require.Equal(t, 5, pending)
+
+	storage.fail = nil
+	require.NoError(t, bw.Flush(context.Background()))
+	require.Equal(t, []int{10, 10, 10, 10, 10, 10, 5}, storage.batches)
+	require.Len(t, storage.Bag, 65)
+	_, err = ls.LoadRaw(ipld.LinkContext{}, file)
+	require.NoError(t, err)
+
+	// a batch filled by a Store is flushed with the context of its LinkContext
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "store")
+	bw = NewBatchWriter(&ls, WithMaxBatchBlocks(1), WithBatchStorage(storage))
+	_, err = bw.LinkSystem().Store(ipld.LinkContext{Ctx: ctx}, DefaultLeafLinkPrototype, basicnode.NewBytes([]byte("hello")))
+	require.NoError(t, err)
+	require.Equal(t, "store", storage.lastCtx.Value(ctxKey{}))
+}
@@ -0,0 +1,78 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-test/random"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// discardLinkSystem hashes and encodes blocks but doesn't keep them, so that
+// benchmarks measure the builders rather than storage.
+func discardLinkSystem() *ipld.LinkSystem {
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageWriteOpener = func(linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		return io.Discard, func(ipld.Link) error { return nil }, nil
+	}
+	return &ls
+}
+
+func BenchmarkBuildUnixFSFile(b *testing.B) {
+	content := random.Bytes(16 << 20)
+	ls := discardLinkSystem()
+	b.SetBytes(int64(len(content)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := BuildUnixFSFile(bytes.NewReader(content), "size-1024", ls); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkEntries(b *testing.B, n int) []dagpb.PBLink {
+	ls := discardLinkSystem()
+	entries := make([]dagpb.PBLink, 0, n)
+	for i := 0; i < n; i++ {
+		lnk, sz, err := BuildUnixFSFile(bytes.NewReader([]byte(fmt.Sprintf("file %d", i))), "", ls)
+		if err != nil {
+			b.Fatal(err)
+		}
+		entry, err := BuildUnixFSDirectoryEntry(fmt.Sprintf("file-%d", i), int64(sz), lnk)
+		if err != nil {
+			b.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func BenchmarkBuildUnixFSDirectory(b *testing.B) {
+	entries := benchmarkEntries(b, 1000)
+	ls := discardLinkSystem()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := BuildUnixFSDirectory(entries, ls); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBuildUnixFSShardedDirectory(b *testing.B) {
+	entries := benchmarkEntries(b, 20000)
+	ls := discardLinkSystem()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := BuildUnixFSShardedDirectory(256, 0x22, entries, ls); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -20,9 +20,14 @@ import (
 //					Time(tb, time.Now())
 //				})
 //	  })
+//
+// The FileSize of File and Raw nodes is checked against their Data and
+// BlockSizes, and data.ErrInconsistentFileSize is returned if it doesn't match
+// their sum. Use SkipFileSizeValidation to build such nodes deliberately.
 func BuildUnixFS(fn func(*Builder)) (data.UnixFSData, error) {
+	var b *Builder
 	nd, err := qp.BuildMap(data.Type.UnixFSData, -1, func(ma ipld.MapAssembler) {
-		b := &Builder{MapAssembler: ma}
+		b = &Builder{MapAssembler: ma}
 		fn(b)
 		if !b.hasBlockSizes {
 			qp.MapEntry(ma, data.Field__BlockSizes, qp.List(0, func(ipld.ListAssembler) {}))
@@ -34,14 +39,51 @@ func BuildUnixFS(fn func(*Builder)) (data.UnixFSData, error) {
 	if err != nil {
 		return nil, err
 	}
-	return nd.(data.UnixFSData), nil
+	ufsData := nd.(data.UnixFSData)
+	if !b.skipFileSizeValidation {
+		if err := validateFileSize(ufsData); err != nil {
+			return nil, err
+		}
+	}
+	return ufsData, nil
 }
 
 // Builder is an interface for making UnixFS data nodes
 type Builder struct {
 	ipld.MapAssembler
-	hasDataType   bool
-	hasBlockSizes bool
+	hasDataType            bool
+	hasBlockSizes          bool
+	skipFileSizeValidation bool
+}
+
+// SkipFileSizeValidation lets BuildUnixFS build a File or Raw node whose
+// FileSize doesn't match its Data and BlockSizes, such as for tests of readers
+// given malformed files
+func SkipFileSizeValidation(b *Builder) {
+	b.skipFileSizeValidation = true
+}
+
+func validateFileSize(ufsData data.UnixFSData) error {
+	dataType := ufsData.FieldDataType().Int()
+	if dataType != data.Data_File && dataType != data.Data_Raw {
+		return nil
+	}
+	if !ufsData.FieldFileSize().Exists() {
+		return nil
+	}
+	var expected uint64
+	if ufsData.FieldData().Exists() {
+		expected = uint64(len(ufsData.FieldData().Must().Bytes()))
+	}
+	bsi := ufsData.FieldBlockSizes().Iterator()
+	for !bsi.Done() {
+		_, bs := bsi.Next()
+		expected += uint64(bs.Int())
+	}
+	if fileSize := uint64(ufsData.FieldFileSize().Must().Int()); fileSize != expected {
+		return data.ErrInconsistentFileSize{FileSize: fileSize, Expected: expected}
+	}
+	return nil
 }
 
 // DataType sets the default on a builder for a UnixFS node - default is File
@@ -126,6 +168,27 @@ func Time(ma TimeBuilder, t time.Time) {
 	FractionalNanoseconds(ma, int32(t.Nanosecond()))
 }
 
+// optionalMtime sets the modification time of a node to t, unless t is zero.
+// Nanoseconds are left out when there are none.
+func optionalMtime(b *Builder, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	Mtime(b, func(tb TimeBuilder) {
+		Seconds(tb, t.Unix())
+		if ns := t.Nanosecond(); ns != 0 {
+			FractionalNanoseconds(tb, int32(ns))
+		}
+	})
+}
+
+// optionalMode sets the mode of a node to *mode, unless mode is nil.
+func optionalMode(b *Builder, mode *int) {
+	if mode != nil {
+		Permissions(b, *mode)
+	}
+}
+
 // Seconds sets the seconds for a modification time
 func Seconds(ma TimeBuilder, seconds int64) {
 	qp.MapEntry(ma, data.Field__Seconds, qp.Int(seconds))
@@ -0,0 +1,273 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// HAMTCheck is the result of CheckCanonicalHAMT.
+type HAMTCheck struct {
+	// Canonical is whether the root is the one BuildUnixFSShardedDirectory
+	// builds for the same entries, fanout and hash function
+	Canonical bool
+	// Expected is the link to the canonical root, stored under the same link
+	// prototype as the root
+	Expected ipld.Link
+	// Entries is the number of entries found under the root
+	Entries int
+	// NonCanonical lists the shards laid out differently from the shard at
+	// the same position in the canonical HAMT
+	NonCanonical []NonCanonicalShard
+}
+
+// NonCanonicalShard is a shard of a HAMT that differs from its counterpart in
+// the canonical HAMT for the same entries.
+type NonCanonicalShard struct {
+	// Prefix is the index of the bucket taken at each level to reach the
+	// shard, empty for the root
+	Prefix []int
+	Link   ipld.Link
+	// Reason describes the first difference found
+	Reason string
+}
+
+// loadedShard is a shard of the HAMT being checked.
+type loadedShard struct {
+	pbn  dagpb.PBNode
+	ufsd data.UnixFSData
+}
+
+type hamtChecker struct {
+	ls      *ipld.LinkSystem
+	shards  map[ipld.Link]loadedShard
+	entries []dagpb.PBLink
+	names   map[string]bool
+	dups    bool
+	check   *HAMTCheck
+}
+
+// CheckCanonicalHAMT loads every shard of the sharded directory at root and
+// rebuilds the HAMT for the entries found, with the fanout, hash function,
+// mode and modification time of the root, to see whether the root is the canonical one
+// for its entries. A HAMT that isn't canonical, because entries are in the
+// wrong buckets or shards were left unmerged after removals, will not have
+// the same CID as one built afresh from the same entries. The shards
+// responsible are listed in the HAMTCheck.
+//
+// Nothing is stored through ls.
+func CheckCanonicalHAMT(root ipld.Link, ls *ipld.LinkSystem) (*HAMTCheck, error) {
+	hc := &hamtChecker{
+		ls:     ls,
+		shards: make(map[ipld.Link]loadedShard),
+		names:  make(map[string]bool),
+		check:  &HAMTCheck{},
+	}
+	rootShard, err := hc.collect(root)
+	if err != nil {
+		return nil, err
+	}
+	hc.check.Entries = len(hc.entries)
+
+	ufsd := rootShard.ufsd
+	if !ufsd.FieldFanout().Exists() || !ufsd.FieldHashType().Exists() {
+		return nil, fmt.Errorf("HAMT shard %s has no fanout or hash type", root)
+	}
+	o := directoryOptions{mtime: ufsd.ModTime(), allowDuplicates: hc.dups}
+	if ufsd.FieldMode().Exists() {
+		mode := int(ufsd.FieldMode().Must().Int())
+		o.mode = &mode
+	}
+	canon, err := newRootShard(int(ufsd.FieldFanout().Must().Int()), uint64(ufsd.FieldHashType().Must().Int()), o)
+	if err != nil {
+		return nil, err
+	}
+	if cl, ok := root.(cidlink.Link); ok {
+		canon.linkProto = cidlink.LinkPrototype{Prefix: cl.Prefix()}
+	}
+	newHasher, err := shardHasher(canon.hasher)
+	if err != nil {
+		return nil, err
+	}
+	hamtEntries := hashEntries(hc.entries, newHasher)
+	for i := range hamtEntries {
+		if err := canon.add(&hamtEntries[i]); err != nil {
+			return nil, err
+		}
+	}
+	hashOnly := *ls
+	hashOnly.StorageWriteOpener = func(linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		return io.Discard, func(ipld.Link) error { return nil }, nil
+	}
+	hc.check.Expected, _, err = canon.serialize(&hashOnly)
+	if err != nil {
+		return nil, err
+	}
+	hc.check.Canonical = hc.check.Expected.Binary() == root.Binary()
+
+	if err := hc.compare(root, nil, canon); err != nil {
+		return nil, err
+	}
+	return hc.check, nil
+}
+
+// collect loads the shard at lnk and those under it, gathering their entries.
+func (hc *hamtChecker) collect(lnk ipld.Link) (loadedShard, error) {
+	block, err := hc.ls.LoadRaw(ipld.LinkContext{}, lnk)
+	if err != nil {
+		return loadedShard{}, err
+	}
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagpb.DecodeBytes(nb, block); err != nil {
+		return loadedShard{}, fmt.Errorf("%s: %w", lnk, err)
+	}
+	pbn := nb.Build().(dagpb.PBNode)
+	if !pbn.FieldData().Exists() {
+		return loadedShard{}, fmt.Errorf("%s is not a HAMT shard", lnk)
+	}
+	ufsd, err := data.DecodeUnixFSData(pbn.FieldData().Must().Bytes())
+	if err != nil {
+		return loadedShard{}, err
+	}
+	if ufsd.FieldDataType().Int() != data.Data_HAMTShard {
+		return loadedShard{}, fmt.Errorf("%s is not a HAMT shard", lnk)
+	}
+	if !ufsd.FieldFanout().Exists() {
+		return loadedShard{}, fmt.Errorf("HAMT shard %s has no fanout", lnk)
+	}
+	shard := loadedShard{pbn, ufsd}
+	hc.shards[lnk] = shard
+
+	width := len(fmt.Sprintf("%X", ufsd.FieldFanout().Must().Int()-1))
+	itr := pbn.FieldLinks().Iterator()
+	for !itr.Done() {
+		_, pbLink := itr.Next()
+		if !pbLink.FieldName().Exists() {
+			return loadedShard{}, fmt.Errorf("HAMT shard %s has a link without a name", lnk)
+		}
+		name := pbLink.FieldName().Must().String()
+		if len(name) < width {
+			return loadedShard{}, fmt.Errorf("HAMT shard %s has a link named %q, shorter than its %d character prefix", lnk, name, width)
+		}
+		if len(name) == width {
+			if _, err := hc.collect(pbLink.FieldHash().Link()); err != nil {
+				return loadedShard{}, err
+			}
+			continue
+		}
+		var tsize int64
+		if pbLink.FieldTsize().Exists() {
+			tsize = pbLink.FieldTsize().Must().Int()
+		}
+		entry, err := BuildUnixFSDirectoryEntry(name[width:], tsize, pbLink.FieldHash().Link())
+		if err != nil {
+			return loadedShard{}, err
+		}
+		hc.dups = hc.dups || hc.names[name[width:]]
+		hc.names[name[width:]] = true
+		hc.entries = append(hc.entries, entry)
+	}
+	return shard, nil
+}
+
+// compare checks the shard at lnk against the canonical shard at its position,
+// and does the same for the child shards of both that are in the same place.
+func (hc *hamtChecker) compare(lnk ipld.Link, prefix []int, canon *shard) error {
+	loaded := hc.shards[lnk]
+	reason, children, err := hc.diff(loaded, canon)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		hc.check.NonCanonical = append(hc.check.NonCanonical, NonCanonicalShard{Prefix: prefix, Link: lnk, Reason: reason})
+	}
+	for _, child := range children {
+		childPrefix := append(append(make([]int, 0, len(prefix)+1), prefix...), child.bucket)
+		if err := hc.compare(child.link, childPrefix, canon.children[child.bucket].shard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// childShard is a link to a child shard, in the bucket it was found in.
+type childShard struct {
+	bucket int
+	link   ipld.Link
+}
+
+// diff returns the first difference between a loaded shard and the canonical
+// one, or "" if there is none, and the child shards of the loaded shard that
+// are also shards in the canonical HAMT.
+func (hc *hamtChecker) diff(loaded loadedShard, canon *shard) (string, []childShard, error) {
+	ufsd := loaded.ufsd
+	if fanout := ufsd.FieldFanout().Must().Int(); fanout != int64(canon.size) {
+		return fmt.Sprintf("fanout is %d, expected %d", fanout, canon.size), nil, nil
+	}
+	if !ufsd.FieldHashType().Exists() || uint64(ufsd.FieldHashType().Must().Int()) != canon.hasher {
+		return fmt.Sprintf("hash type isn't %#x", canon.hasher), nil, nil
+	}
+
+	var reason string
+	var children []childShard
+	found := make(map[int]bool)
+	itr := loaded.pbn.FieldLinks().Iterator()
+	for !itr.Done() {
+		_, pbLink := itr.Next()
+		if !pbLink.FieldName().Exists() {
+			return "", nil, fmt.Errorf("HAMT shard link has no name")
+		}
+		name := pbLink.FieldName().Must().String()
+		if len(name) < canon.width {
+			return "", nil, fmt.Errorf("HAMT link name %q is shorter than its %d character prefix", name, canon.width)
+		}
+		bucket, err := strconv.ParseUint(name[:canon.width], 16, 64)
+		if err != nil || bucket >= uint64(canon.size) {
+			return "", nil, fmt.Errorf("HAMT link name %q has an invalid bucket", name)
+		}
+		idx := int(bucket)
+		found[idx] = true
+		expected, ok := canon.children[idx]
+		if len(name) == canon.width {
+			if expected.shard == nil {
+				if reason == "" {
+					reason = fmt.Sprintf("bucket %d holds a shard, expected a single entry", idx)
+				}
+				continue
+			}
+			children = append(children, childShard{idx, pbLink.FieldHash().Link()})
+			continue
+		}
+		if reason != "" {
+			continue
+		}
+		switch {
+		case !ok:
+			reason = fmt.Sprintf("entry %q is in bucket %d, which should be empty", name[canon.width:], idx)
+		case expected.shard != nil:
+			reason = fmt.Sprintf("bucket %d holds entry %q, expected a shard", idx, name[canon.width:])
+		case expected.Name.Must().String() != name[canon.width:]:
+			reason = fmt.Sprintf("entry %q is in bucket %d, expected %q", name[canon.width:], idx, expected.Name.Must().String())
+		}
+	}
+	if reason == "" && len(found) != len(canon.children) {
+		reason = fmt.Sprintf("%d buckets are in use, expected %d", len(found), len(canon.children))
+	}
+	if reason == "" {
+		bm, err := canon.bitmap()
+		if err != nil {
+			return "", nil, err
+		}
+		if !ufsd.FieldData().Exists() || !bytes.Equal(ufsd.FieldData().Must().Bytes(), bm) {
+			reason = "bitfield doesn't match the buckets in use"
+		}
+	}
+	return reason, children, nil
+}
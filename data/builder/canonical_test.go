@@ -0,0 +1,118 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/hamt"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/spaolacci/murmur3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCanonicalHAMT(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	entries, err := mkEntries(2000, &ls)
+	require.NoError(t, err)
+	root, _, err := BuildUnixFSShardedDirectory(16, hamt.HashMurmur3, entries, &ls)
+	require.NoError(t, err)
+	blocks := len(storage.Bag)
+
+	check, err := CheckCanonicalHAMT(root, &ls)
+	require.NoError(t, err)
+	require.True(t, check.Canonical)
+	require.Equal(t, root, check.Expected)
+	require.Equal(t, 2000, check.Entries)
+	require.Empty(t, check.NonCanonical)
+	require.Equal(t, blocks, len(storage.Bag), "nothing should be stored")
+
+	// a single entry left in a child shard, as if its siblings had been
+	// removed without the shard being merged back into its parent
+	canon, err := newRootShard(256, hamt.HashMurmur3, directoryOptions{})
+	require.NoError(t, err)
+	h := murmur3.New64()
+	h.Write([]byte(entries[0].Name.Must().String()))
+	lnk := &hamtLink{h.Sum(nil), entries[0]}
+	child := &shard{
+		hasher:    canon.hasher,
+		size:      canon.size,
+		sizeLg2:   canon.sizeLg2,
+		width:     canon.width,
+		depth:     1,
+		linkProto: canon.linkProto,
+		children:  make(map[int]entry),
+	}
+	require.NoError(t, child.add(lnk))
+	bucket, err := lnk.hash.Slice(0, canon.sizeLg2)
+	require.NoError(t, err)
+	canon.children[bucket] = entry{child, nil, nil}
+	unmerged, _, err := canon.serialize(&ls)
+	require.NoError(t, err)
+
+	check, err = CheckCanonicalHAMT(unmerged, &ls)
+	require.NoError(t, err)
+	require.False(t, check.Canonical)
+	require.Equal(t, 1, check.Entries)
+	require.Len(t, check.NonCanonical, 1)
+	require.Empty(t, check.NonCanonical[0].Prefix)
+	require.Equal(t, unmerged, check.NonCanonical[0].Link)
+
+	expected, _, err := BuildUnixFSShardedDirectory(256, hamt.HashMurmur3, []dagpb.PBLink{entries[0]}, &ls)
+	require.NoError(t, err)
+	require.Equal(t, expected, check.Expected)
+
+	// a LinkSystem that reifies UnixFS nodes gives the same result
+	rls := ls
+	rls.NodeReifier = unixfsnode.Reify
+	reified, err := CheckCanonicalHAMT(unmerged, &rls)
+	require.NoError(t, err)
+	require.Equal(t, check, reified)
+}
+
+func TestCheckCanonicalHAMTMalformedLinks(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	entries, err := mkEntries(1, &ls)
+	require.NoError(t, err)
+	ufd, err := BuildUnixFS(func(b *Builder) {
+		DataType(b, data.Data_HAMTShard)
+		HashType(b, hamt.HashMurmur3)
+		Fanout(b, 256)
+		Data(b, []byte{1})
+	})
+	require.NoError(t, err)
+	shardWithLink := func(name *string) ipld.Link {
+		nd, err := qp.BuildMap(dagpb.Type.PBNode, -1, func(ma ipld.MapAssembler) {
+			qp.MapEntry(ma, "Data", qp.Bytes(data.EncodeUnixFSData(ufd)))
+			qp.MapEntry(ma, "Links", qp.List(-1, func(la ipld.ListAssembler) {
+				qp.ListEntry(la, qp.Map(-1, func(ma ipld.MapAssembler) {
+					qp.MapEntry(ma, "Hash", qp.Link(entries[0].Hash.Link()))
+					if name != nil {
+						qp.MapEntry(ma, "Name", qp.String(*name))
+					}
+				}))
+			}))
+		})
+		require.NoError(t, err)
+		lnk, err := ls.Store(ipld.LinkContext{}, fileLinkProto, nd)
+		require.NoError(t, err)
+		return lnk
+	}
+
+	_, err = CheckCanonicalHAMT(shardWithLink(nil), &ls)
+	require.Error(t, err)
+	short := "0"
+	_, err = CheckCanonicalHAMT(shardWithLink(&short), &ls)
+	require.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package builder
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	chunk "github.com/ipfs/boxo/chunker"
+)
+
+// ChunkerFactory creates a Splitter reading r, for the chunker string it was
+// selected by, so that parameters can be parsed from it.
+type ChunkerFactory func(r io.Reader, chunker string) (chunk.Splitter, error)
+
+var (
+	chunkersLk sync.RWMutex
+	chunkers   = map[string]ChunkerFactory{}
+)
+
+// RegisterChunker makes a custom splitter available wherever the builders take
+// a chunker string, such as BuildUnixFSFile and Profile.Chunker. The factory
+// is selected by chunker strings equal to name, or made of name followed by
+// "-" and parameters: registering "fastcdc" selects it for "fastcdc" and
+// "fastcdc-64k", unless "fastcdc-64k" is registered too, as the longest
+// registered name matching a chunker string is the one selected.
+//
+// The chunkers built in to boxo ("default", "size", "rabin" and "buzhash")
+// can't be replaced. Registering a name again replaces its factory.
+// RegisterChunker is usually called from an init function, but is safe to
+// call at any time.
+func RegisterChunker(name string, factory ChunkerFactory) error {
+	if name == "" || name == "default" || name == "size" || name == "buzhash" || strings.HasPrefix(name, "size-") || strings.HasPrefix(name, "rabin") {
+		return fmt.Errorf("chunker %q is built in and can't be registered", name)
+	}
+	if factory == nil {
+		return fmt.Errorf("chunker %q registered without a factory", name)
+	}
+	chunkersLk.Lock()
+	defer chunkersLk.Unlock()
+	chunkers[name] = factory
+	return nil
+}
+
+// ChunkerNames returns the names registered with RegisterChunker, sorted.
+func ChunkerNames() []string {
+	chunkersLk.RLock()
+	defer chunkersLk.RUnlock()
+	names := make([]string, 0, len(chunkers))
+	for name := range chunkers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newSplitter returns the Splitter selected by a chunker string, from the
+// registered chunkers or else those built in to boxo.
+func newSplitter(r io.Reader, chunker string) (chunk.Splitter, error) {
+	chunkersLk.RLock()
+	name := chunker
+	factory, ok := chunkers[name]
+	for !ok && strings.Contains(name, "-") {
+		name = name[:strings.LastIndex(name, "-")]
+		factory, ok = chunkers[name]
+	}
+	chunkersLk.RUnlock()
+	if !ok {
+		return chunk.FromString(r, chunker)
+	}
+	return factory(r, chunker)
+}
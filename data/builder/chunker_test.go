@@ -0,0 +1,58 @@
+package builder
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	chunk "github.com/ipfs/boxo/chunker"
+	"github.com/ipfs/go-test/random"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterChunker(t *testing.T) {
+	var selected []string
+	require.NoError(t, RegisterChunker("test-every", func(r io.Reader, chunker string) (chunk.Splitter, error) {
+		selected = append(selected, chunker)
+		size := 1024
+		if _, param, ok := strings.Cut(strings.TrimPrefix(chunker, "test-every"), "-"); ok {
+			var err error
+			if size, err = strconv.Atoi(param); err != nil {
+				return nil, err
+			}
+		}
+		return chunk.NewSizeSplitter(r, int64(size)), nil
+	}))
+	require.Contains(t, ChunkerNames(), "test-every")
+
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+	content := random.Bytes(10_000)
+
+	expected, _, err := BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls)
+	require.NoError(t, err)
+	lnk, _, err := BuildUnixFSFile(bytes.NewReader(content), "test-every", &ls)
+	require.NoError(t, err)
+	require.Equal(t, expected, lnk)
+	expected, _, err = BuildUnixFSFile(bytes.NewReader(content), "size-500", &ls)
+	require.NoError(t, err)
+	lnk, _, err = BuildUnixFSFile(bytes.NewReader(content), "test-every-500", &ls)
+	require.NoError(t, err)
+	require.Equal(t, expected, lnk)
+	require.Equal(t, []string{"test-every", "test-every-500"}, selected)
+
+	_, _, err = BuildUnixFSFile(bytes.NewReader(content), "test-every-x", &ls)
+	require.Error(t, err)
+	_, _, err = BuildUnixFSFile(bytes.NewReader(content), "test-never", &ls)
+	require.Error(t, err)
+
+	for _, name := range []string{"", "default", "size", "size-1024", "rabin", "rabin-1-2-3", "buzhash"} {
+		require.Error(t, RegisterChunker(name, chunk.FromString), name)
+	}
+	require.Error(t, RegisterChunker("test-nil", nil))
+}
@@ -0,0 +1,79 @@
+package builder
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// ConcatFiles builds a file whose content is the content of the files stored
+// under roots, one after another, and returns its root and total size. The
+// files are linked whole from the new File nodes, so none of their blocks are
+// copied or stored again; only the root block of each is loaded, to read its
+// size. Empty files are left out.
+//
+// The new nodes are arranged as BuildUnixFSFileFromLeaves arranges leaves,
+// with each file taking the place of a leaf, and the same options apply. The
+// resulting tree isn't balanced, and its CID differs from that of the same
+// content imported afresh.
+func ConcatFiles(roots []ipld.Link, ls *ipld.LinkSystem, opts ...FileOption) (ipld.Link, uint64, error) {
+	leaves := make([]LeafMeta, 0, len(roots))
+	for i, root := range roots {
+		leaf, err := fileRootMeta(root, ls)
+		if err != nil {
+			return nil, 0, fmt.Errorf("file %d: %w", i, err)
+		}
+		if leaf.Size > 0 {
+			leaves = append(leaves, leaf)
+		}
+	}
+	return BuildUnixFSFileFromLeaves(leaves, ls, opts...)
+}
+
+// fileRootMeta loads the root block of a stored file to describe the file as
+// a leaf of another.
+func fileRootMeta(root ipld.Link, ls *ipld.LinkSystem) (LeafMeta, error) {
+	cl, ok := root.(cidlink.Link)
+	if !ok {
+		return LeafMeta{}, fmt.Errorf("unsupported link type %T", root)
+	}
+	block, err := ls.LoadRaw(ipld.LinkContext{}, root)
+	if err != nil {
+		return LeafMeta{}, err
+	}
+	switch codec := cl.Cid.Prefix().Codec; codec {
+	case cid.Raw:
+		return LeafMeta{Link: root, Size: uint64(len(block))}, nil
+	case cid.DagProtobuf:
+	default:
+		return LeafMeta{}, fmt.Errorf("files must be raw or dag-pb, not codec 0x%x", codec)
+	}
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagpb.DecodeBytes(nb, block); err != nil {
+		return LeafMeta{}, err
+	}
+	pbn := nb.Build().(dagpb.PBNode)
+	if !pbn.FieldData().Exists() {
+		return LeafMeta{}, fmt.Errorf("not a unixfs node")
+	}
+	ufsd, err := data.DecodeUnixFSData(pbn.FieldData().Must().Bytes())
+	if err != nil {
+		return LeafMeta{}, err
+	}
+	switch dataType := ufsd.FieldDataType().Int(); dataType {
+	case data.Data_File, data.Data_Raw:
+	default:
+		return LeafMeta{}, fmt.Errorf("not a file, but a %s", data.DataTypeNames[dataType])
+	}
+	var size uint64
+	if ufsd.FieldFileSize().Exists() {
+		size = uint64(ufsd.FieldFileSize().Must().Int())
+	} else if ufsd.FieldData().Exists() {
+		size = uint64(len(ufsd.FieldData().Must().Bytes()))
+	}
+	return LeafMeta{Link: root, Size: size, StoredSize: pbTsize(block, pbn)}, nil
+}
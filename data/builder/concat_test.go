@@ -0,0 +1,65 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode/file"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcatFiles(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	var roots []ipld.Link
+	var expected []byte
+	var storedSize uint64
+	add := func(size int, opts ...FileOption) {
+		content := random.Bytes(size)
+		root, sz, err := BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls, opts...)
+		require.NoError(t, err)
+		roots = append(roots, root)
+		expected = append(expected, content...)
+		if size > 0 {
+			storedSize += sz
+		}
+	}
+	add(500)
+	add(10 * 1024)
+	add(0)
+	add(3000, WithLeafLinkPrototype(DefaultInteriorLinkPrototype))
+	add(200 * 1024)
+
+	blocks := len(storage.Bag)
+	root, size, err := ConcatFiles(roots, &ls, WithLinksPerBlock(3))
+	require.NoError(t, err)
+	// four files over three links per block: a root above two new nodes
+	require.Equal(t, blocks+3, len(storage.Bag))
+	require.Greater(t, size, storedSize)
+
+	pbn, err := ls.Load(ipld.LinkContext{}, root, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	ufn, err := file.NewUnixFSFile(context.Background(), pbn, &ls)
+	require.NoError(t, err)
+	out, err := ufn.AsBytes()
+	require.NoError(t, err)
+	require.Equal(t, expected, out)
+
+	// a single file is itself
+	single, _, err := ConcatFiles(roots[1:2], &ls)
+	require.NoError(t, err)
+	require.Equal(t, roots[1], single)
+
+	dir, _, err := BuildUnixFSDirectory(nil, &ls)
+	require.NoError(t, err)
+	_, _, err = ConcatFiles([]ipld.Link{roots[0], dir}, &ls)
+	require.ErrorContains(t, err, "file 1: not a file, but a Directory")
+}
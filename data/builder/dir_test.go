@@ -2,16 +2,21 @@ package builder
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"testing"
+	"testing/fstest"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-test/random"
 	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data"
 	dagpb "github.com/ipld/go-codec-dagpb"
 	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
@@ -180,6 +185,193 @@ func TestBuildUnixFSRecursive(t *testing.T) {
 	require.Equal(t, uint64(245), sz)
 }
 
+func TestBuildUnixFSRecursiveFS(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	// the tree of TestBuildUnixFSRecursive
+	fsys := fstest.MapFS{
+		"rootDir/a":   {Data: []byte("aaa")},
+		"rootDir/b/1": {Data: []byte("111")},
+		"rootDir/b/2": {Data: []byte("222")},
+		"rootDir/c":   {Data: []byte("ccc")},
+	}
+	lnk, sz, err := BuildUnixFSRecursiveFS(fsys, "rootDir", &ls)
+	require.NoError(t, err)
+	require.Equal(t, "bafybeihswl3f7pa7fueyayewcvr3clkdz7oetv4jolyejgw26p6l3qzlbm", lnk.String())
+	require.Equal(t, uint64(245), sz)
+
+	// symlinks are kept as symlinks by a file system that can read them, as
+	// they are by BuildUnixFSRecursive
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), []byte("aaa"), 0644))
+	require.NoError(t, os.Symlink("a", filepath.Join(dir, "link")))
+	expected, _, err := BuildUnixFSRecursive(dir, &ls)
+	require.NoError(t, err)
+	fsys = fstest.MapFS{
+		"a":    {Data: []byte("aaa")},
+		"link": {Data: []byte("a"), Mode: fs.ModeSymlink},
+	}
+	lnk, _, err = BuildUnixFSRecursiveFS(linkFS{fsys}, ".", &ls)
+	require.NoError(t, err)
+	require.Equal(t, expected, lnk)
+
+	_, _, err = BuildUnixFSRecursiveFS(fsys, "missing", &ls)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestBuildUnixFSRecursiveWithChunking(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	big := random.Bytes(300 << 10)
+	fsys := fstest.MapFS{
+		"tree/big.bin":   {Data: big},
+		"tree/small.txt": {Data: []byte("small")},
+	}
+	sizes := make(map[string]int64)
+	chunking := func(p string, size int64) (string, []FileOption) {
+		sizes[p] = size
+		if path.Ext(p) == ".bin" {
+			return "size-1024", []FileOption{WithLayout(Trickle)}
+		}
+		return "", nil
+	}
+	lnk, _, err := BuildUnixFSRecursiveFS(fsys, "tree", &ls, WithChunking(chunking))
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"tree/big.bin": int64(len(big)), "tree/small.txt": 5}, sizes)
+
+	bigLnk, bigSize, err := BuildUnixFSFile(bytes.NewReader(big), "size-1024", &ls, WithLayout(Trickle))
+	require.NoError(t, err)
+	bigEntry, err := BuildUnixFSDirectoryEntry("big.bin", int64(bigSize), bigLnk)
+	require.NoError(t, err)
+	smallEntry, err := mkEntry(bytes.NewBufferString("small"), "small.txt", &ls)
+	require.NoError(t, err)
+	expected, _, err := BuildUnixFSDirectory([]dagpb.PBLink{bigEntry, smallEntry}, &ls)
+	require.NoError(t, err)
+	require.Equal(t, expected, lnk)
+}
+
+func TestBuildUnixFSRecursiveOnBuilt(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	fsys := linkFS{fstest.MapFS{
+		"tree/a.txt":       {Data: []byte("hello")},
+		"tree/sub/b.bin":   {Data: random.Bytes(300 << 10)},
+		"tree/sub/link":    {Data: []byte("../a.txt"), Mode: fs.ModeSymlink},
+		"tree/sub/c/empty": {Data: []byte{}},
+	}}
+	var built []BuiltEntry
+	lnk, sz, err := BuildUnixFSRecursiveFS(fsys, "tree", &ls, WithOnBuilt(func(e BuiltEntry) error {
+		built = append(built, e)
+		return nil
+	}))
+	require.NoError(t, err)
+
+	paths := make([]string, 0, len(built))
+	byPath := make(map[string]BuiltEntry)
+	for _, e := range built {
+		paths = append(paths, e.Path)
+		byPath[e.Path] = e
+	}
+	require.Equal(t, []string{"tree/a.txt", "tree/sub/b.bin", "tree/sub/c/empty", "tree/sub/c", "tree/sub/link", "tree/sub", "tree"}, paths)
+	require.Equal(t, BuiltEntry{Path: "tree", Type: data.Data_Directory, Link: lnk, Size: 5 + 300<<10 + 8, StoredSize: sz}, byPath["tree"])
+	require.Equal(t, int64(data.Data_Symlink), byPath["tree/sub/link"].Type)
+	require.Equal(t, uint64(8), byPath["tree/sub/link"].Size)
+	require.Equal(t, uint64(300<<10), byPath["tree/sub/b.bin"].Size)
+	require.Greater(t, byPath["tree/sub/b.bin"].StoredSize, byPath["tree/sub/b.bin"].Size)
+	require.Equal(t, int64(data.Data_Directory), byPath["tree/sub/c"].Type)
+	require.Equal(t, uint64(0), byPath["tree/sub/c"].Size)
+
+	stop := errors.New("stop")
+	_, _, err = BuildUnixFSRecursiveFS(fsys, "tree", &ls, WithOnBuilt(func(e BuiltEntry) error {
+		if e.Path == "tree/sub" {
+			return stop
+		}
+		return nil
+	}))
+	require.ErrorIs(t, err, stop)
+}
+
+func TestBuildUnixFSRecursivePreservedModes(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	fsys := linkFS{fstest.MapFS{
+		"tree":        {Mode: fs.ModeDir | fs.ModeSticky | 0o777},
+		"tree/run":    {Data: []byte("#!/bin/sh"), Mode: fs.ModeSetuid | 0o755},
+		"tree/ro.txt": {Data: []byte("read only"), Mode: 0o444},
+		"tree/link":   {Data: []byte("ro.txt"), Mode: fs.ModeSymlink | 0o777},
+	}}
+	modes := func(opts ...DirectoryOption) map[string]fs.FileMode {
+		modes := make(map[string]fs.FileMode)
+		opts = append(opts, WithOnBuilt(func(e BuiltEntry) error {
+			if e.Link.(cidlink.Link).Prefix().Codec == cid.Raw {
+				modes[e.Path] = data.FilePermissionsDefault
+				return nil
+			}
+			nd, err := ls.Load(ipld.LinkContext{}, e.Link, dagpb.Type.PBNode)
+			require.NoError(t, err)
+			ufsd, err := data.DecodeUnixFSData(nd.(dagpb.PBNode).FieldData().Must().Bytes())
+			require.NoError(t, err)
+			modes[e.Path] = ufsd.FileMode()
+			return nil
+		}))
+		_, _, err := BuildUnixFSRecursiveFS(fsys, "tree", &ls, opts...)
+		require.NoError(t, err)
+		return modes
+	}
+
+	for name, mode := range modes(WithPreservedModes()) {
+		require.Equal(t, fsys.MapFS[name].Mode, mode, name)
+	}
+	// without the option the defaults for each type are assumed
+	require.Equal(t, map[string]fs.FileMode{
+		"tree":        fs.ModeDir | 0o755,
+		"tree/run":    0o644,
+		"tree/ro.txt": 0o644,
+		"tree/link":   fs.ModeSymlink,
+	}, modes())
+}
+
+// linkFS reads the symlinks of a MapFS, whose targets are the Data of their
+// files.
+type linkFS struct {
+	fstest.MapFS
+}
+
+func (l linkFS) ReadLink(name string) (string, error) {
+	f, ok := l.MapFS[name]
+	if !ok || f.Mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return string(f.Data), nil
+}
+
+func (l linkFS) Lstat(name string) (fs.FileInfo, error) {
+	if f, ok := l.MapFS[name]; ok && f.Mode&fs.ModeSymlink != 0 {
+		entries, err := fs.ReadDir(l.MapFS, path.Dir(name))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Name() == path.Base(name) {
+				return e.Info()
+			}
+		}
+	}
+	return fs.Stat(l.MapFS, name)
+}
+
 func TestBuildUnixFSRecursiveLargeSharded(t *testing.T) {
 	// only the top CID is of interest, but this tree is correct and can be used for future validation
 	fixture := fentry{
@@ -265,3 +457,132 @@ func mustCidDecode(s string) cid.Cid {
 	}
 	return c
 }
+
+func TestBuildUnixFSShardedDirectoryDuplicateNames(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	entries, err := mkEntries(100, &ls)
+	require.NoError(t, err)
+	dup, err := mkEntry(bytes.NewBufferString("dup"), "file 42", &ls)
+	require.NoError(t, err)
+	entries = append(entries, dup)
+
+	_, _, err = BuildUnixFSShardedDirectory(16, multihash.MURMUR3X64_64, entries, &ls)
+	var dupErr ErrDuplicateName
+	require.ErrorAs(t, err, &dupErr)
+	require.Equal(t, "file 42", dupErr.Name)
+
+	lnk, sz, err := BuildUnixFSShardedDirectory(16, multihash.MURMUR3X64_64, entries, &ls, WithDuplicateNames())
+	require.NoError(t, err)
+	var totStored int
+	for _, blk := range storage.Bag {
+		totStored += len(blk)
+	}
+	require.Equal(t, totStored, int(sz))
+
+	pbn, err := ls.Load(ipld.LinkContext{}, lnk, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	ufn, err := unixfsnode.Reify(ipld.LinkContext{}, pbn, &ls)
+	require.NoError(t, err)
+	names := make(map[string]int)
+	itr := ufn.MapIterator()
+	for !itr.Done() {
+		k, _, err := itr.Next()
+		require.NoError(t, err)
+		name, err := k.AsString()
+		require.NoError(t, err)
+		names[name]++
+	}
+	require.Len(t, names, 100)
+	require.Equal(t, 2, names["file 42"])
+}
+
+func TestBuildUnixFSShardedDirectoryParallelHashing(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	entries, err := mkEntries(10000, &ls)
+	require.NoError(t, err)
+	parallel, _, err := BuildUnixFSShardedDirectory(256, multihash.MURMUR3X64_64, entries, &ls)
+	require.NoError(t, err)
+
+	defer func(threshold int) { parallelHashThreshold = threshold }(parallelHashThreshold)
+	parallelHashThreshold = len(entries)
+	serial, _, err := BuildUnixFSShardedDirectory(256, multihash.MURMUR3X64_64, entries, &ls)
+	require.NoError(t, err)
+	require.Equal(t, serial, parallel)
+}
+
+func TestBuildUnixFSDirectoryShardingThreshold(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	entries, err := mkEntries(100, &ls)
+	require.NoError(t, err)
+	dataType := func(opts ...DirectoryOption) int64 {
+		lnk, _, err := BuildUnixFSDirectory(entries, &ls, opts...)
+		require.NoError(t, err)
+		pbn, err := ls.Load(ipld.LinkContext{}, lnk, dagpb.Type.PBNode)
+		require.NoError(t, err)
+		ufs, err := data.DecodeUnixFSData(pbn.(dagpb.PBNode).Data.Must().Bytes())
+		require.NoError(t, err)
+		return ufs.FieldDataType().Int()
+	}
+
+	size := estimateDirSize(entries)
+	require.Equal(t, data.Data_Directory, dataType())
+	require.Equal(t, data.Data_Directory, dataType(WithShardingThreshold(size)))
+	require.Equal(t, data.Data_HAMTShard, dataType(WithShardingThreshold(size-1)))
+	require.Equal(t, data.Data_Directory, dataType(WithShardingEntryCount(100)))
+	require.Equal(t, data.Data_HAMTShard, dataType(WithShardingEntryCount(99)))
+	require.Equal(t, data.Data_HAMTShard, dataType(WithShardingThreshold(-1), WithShardingEntryCount(99)))
+
+	many, err := mkEntries(50000, &ls)
+	require.NoError(t, err)
+	entries = many
+	require.Equal(t, data.Data_HAMTShard, dataType())
+	require.Equal(t, data.Data_Directory, dataType(WithShardingThreshold(-1)))
+}
+
+func TestBuildUnixFSDirectoryEntryForRoot(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	entries, err := mkEntries(50, &ls)
+	require.NoError(t, err)
+	file, fileSize, err := BuildUnixFSFile(bytes.NewReader(random.Bytes(100000)), "size-1024", &ls)
+	require.NoError(t, err)
+	raw, rawSize, err := BuildUnixFSFile(bytes.NewReader([]byte("raw")), "", &ls)
+	require.NoError(t, err)
+	dir, dirSize, err := BuildUnixFSDirectory(entries, &ls)
+	require.NoError(t, err)
+	shard, shardSize, err := BuildUnixFSShardedDirectory(16, multihash.MURMUR3X64_64, entries, &ls)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		root ipld.Link
+		size uint64
+	}{{file, fileSize}, {raw, rawSize}, {dir, dirSize}, {shard, shardSize}} {
+		loads := 0
+		counting := ls
+		counting.StorageReadOpener = func(lc ipld.LinkContext, l ipld.Link) (io.Reader, error) {
+			loads++
+			return storage.OpenRead(lc, l)
+		}
+		entry, err := BuildUnixFSDirectoryEntryForRoot("name", tc.root, &counting)
+		require.NoError(t, err)
+		require.Equal(t, 1, loads)
+		require.Equal(t, "name", entry.FieldName().Must().String())
+		require.Equal(t, tc.root, entry.FieldHash().Link())
+		require.Equal(t, int64(tc.size), entry.FieldTsize().Must().Int())
+	}
+}
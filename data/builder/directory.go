@@ -1,10 +1,12 @@
 package builder
 
 import (
-	"fmt"
+	"bytes"
+	"io"
 	"io/fs"
 	"os"
 	"path"
+	"time"
 
 	"github.com/ipfs/go-unixfsnode/data"
 	dagpb "github.com/ipld/go-codec-dagpb"
@@ -20,59 +22,163 @@ const shardSplitThreshold = 262144
 const defaultShardWidth = 256
 
 // BuildUnixFSRecursive returns a link pointing to the UnixFS node representing
-// the file or directory tree pointed to by `root`
-func BuildUnixFSRecursive(root string, ls *ipld.LinkSystem) (ipld.Link, uint64, error) {
-	info, err := os.Lstat(root)
+// the file or directory tree pointed to by `root`. opts are applied to every
+// directory in the tree. Special files, such as sockets and device nodes, are
+// handled according to WithSpecialFilePolicy.
+func BuildUnixFSRecursive(root string, ls *ipld.LinkSystem, opts ...DirectoryOption) (ipld.Link, uint64, error) {
+	return buildRecursive(osFS{}, root, ls, applyDirectoryOptions(opts), opts)
+}
+
+// ReadLinkFS is implemented by file systems that have symlinks, to read them
+// rather than follow them. It has the methods of fs.ReadLinkFS, added in Go
+// 1.25, so file systems implementing that implement this too.
+type ReadLinkFS interface {
+	fs.FS
+	// ReadLink returns the target of the symlink name.
+	ReadLink(name string) (string, error)
+	// Lstat returns a FileInfo describing name, without following it if it's
+	// a symlink.
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// BuildUnixFSRecursiveFS is BuildUnixFSRecursive for the tree at root in
+// fsys, such as an embed.FS, a zip.Reader or an fstest.MapFS, rather than in
+// the OS file system. root is a path as fs.FS expects, "." for the whole of
+// fsys. Files are listed and stated with fs.ReadDir and fs.Stat, which use
+// fs.ReadDirFS and fs.StatFS where fsys implements them. Symlinks are stored
+// as symlinks if fsys implements ReadLinkFS, and otherwise followed, as
+// fs.Stat does.
+func BuildUnixFSRecursiveFS(fsys fs.FS, root string, ls *ipld.LinkSystem, opts ...DirectoryOption) (ipld.Link, uint64, error) {
+	return buildRecursive(fsys, root, ls, applyDirectoryOptions(opts), opts)
+}
+
+// osFS is the OS file system, for BuildUnixFSRecursive. Unlike os.DirFS, it
+// takes any OS path, including absolute paths.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) ReadLink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+func (osFS) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func buildRecursive(fsys fs.FS, root string, ls *ipld.LinkSystem, o directoryOptions, opts []DirectoryOption) (ipld.Link, uint64, error) {
+	built, err := buildTree(fsys, root, ls, o, opts)
 	if err != nil {
 		return nil, 0, err
 	}
+	return built.Link, built.StoredSize, nil
+}
+
+// buildTree builds the file or directory tree at root, reporting each entry
+// built to o.onBuilt once it's done.
+func buildTree(fsys fs.FS, root string, ls *ipld.LinkSystem, o directoryOptions, opts []DirectoryOption) (BuiltEntry, error) {
+	var info fs.FileInfo
+	var err error
+	lfs, canReadLinks := fsys.(ReadLinkFS)
+	if canReadLinks {
+		info, err = lfs.Lstat(root)
+	} else {
+		info, err = fs.Stat(fsys, root)
+	}
+	if err != nil {
+		return BuiltEntry{}, err
+	}
 
+	built := BuiltEntry{Path: root, Type: data.Data_File}
 	m := info.Mode()
+	var dirOpts []DirectoryOption
+	var fileOpts []FileOption
+	var symlinkOpts []SymlinkOption
+	if o.preserveModes {
+		mode := data.ModeFromFileMode(m)
+		dirOpts = []DirectoryOption{WithDirectoryMode(mode)}
+		fileOpts = []FileOption{WithMode(mode)}
+		symlinkOpts = []SymlinkOption{WithSymlinkMode(mode)}
+	}
 	switch {
 	case m.IsDir():
-		var tsize uint64
-		entries, err := os.ReadDir(root)
+		entries, err := fs.ReadDir(fsys, root)
 		if err != nil {
-			return nil, 0, err
+			return BuiltEntry{}, err
 		}
 		lnks := make([]dagpb.PBLink, 0, len(entries))
 		for _, e := range entries {
-			lnk, sz, err := BuildUnixFSRecursive(path.Join(root, e.Name()), ls)
+			if o.specialFiles == SkipSpecialFiles && isSpecialFile(e.Type()) {
+				continue
+			}
+			child, err := buildTree(fsys, path.Join(root, e.Name()), ls, o, opts)
 			if err != nil {
-				return nil, 0, err
+				return BuiltEntry{}, err
 			}
-			tsize += sz
-			entry, err := BuildUnixFSDirectoryEntry(e.Name(), int64(sz), lnk)
+			built.Size += child.Size
+			entry, err := BuildUnixFSDirectoryEntry(e.Name(), int64(child.StoredSize), child.Link)
 			if err != nil {
-				return nil, 0, err
+				return BuiltEntry{}, err
 			}
 			lnks = append(lnks, entry)
 		}
-		return BuildUnixFSDirectory(lnks, ls)
-	case m.Type() == fs.ModeSymlink:
-		content, err := os.Readlink(root)
-		if err != nil {
-			return nil, 0, err
-		}
-		outLnk, sz, err := BuildUnixFSSymlink(content, ls)
+		built.Type = data.Data_Directory
+		built.Link, built.StoredSize, err = BuildUnixFSDirectory(lnks, ls, append(opts[:len(opts):len(opts)], dirOpts...)...)
+	case m.Type() == fs.ModeSymlink && canReadLinks:
+		content, err := lfs.ReadLink(root)
 		if err != nil {
-			return nil, 0, err
+			return BuiltEntry{}, err
 		}
-		return outLnk, sz, nil
+		built.Type = data.Data_Symlink
+		built.Size = uint64(len(content))
+		built.Link, built.StoredSize, err = BuildUnixFSSymlink(content, ls, symlinkOpts...)
 	case m.IsRegular():
-		fp, err := os.Open(root)
+		fp, err := fsys.Open(root)
 		if err != nil {
-			return nil, 0, err
+			return BuiltEntry{}, err
 		}
 		defer fp.Close()
-		outLnk, sz, err := BuildUnixFSFile(fp, "", ls)
-		if err != nil {
-			return nil, 0, err
+		var chunker string
+		if o.chunking != nil {
+			var chunkingOpts []FileOption
+			chunker, chunkingOpts = o.chunking(root, info.Size())
+			fileOpts = append(chunkingOpts[:len(chunkingOpts):len(chunkingOpts)], fileOpts...)
 		}
-		return outLnk, sz, nil
+		cr := &sizingReader{r: fp}
+		built.Link, built.StoredSize, err = BuildUnixFSFile(cr, chunker, ls, fileOpts...)
+		built.Size = cr.n
+	case o.specialFiles == EmptySpecialFiles:
+		built.Link, built.StoredSize, err = BuildUnixFSFile(bytes.NewReader(nil), "", ls, fileOpts...)
 	default:
-		return nil, 0, fmt.Errorf("cannot encode non regular file: %s", root)
+		return BuiltEntry{}, ErrSpecialFile{Path: root, Mode: m}
+	}
+	if err != nil {
+		return BuiltEntry{}, err
+	}
+	if o.onBuilt != nil {
+		if err := o.onBuilt(built); err != nil {
+			return BuiltEntry{}, err
+		}
 	}
+	return built, nil
+}
+
+// sizingReader counts the bytes read through it.
+type sizingReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (cr *sizingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += uint64(n)
+	return n, err
 }
 
 // estimateDirSize estimates if a directory is big enough that it warrents sharding.
@@ -81,27 +187,182 @@ func BuildUnixFSRecursive(root string, ls *ipld.LinkSystem) (ipld.Link, uint64,
 func estimateDirSize(entries []dagpb.PBLink) int {
 	s := 0
 	for _, e := range entries {
-		s += len(e.Name.Must().String())
-		lnk := e.Hash.Link()
-		cl, ok := lnk.(cidlink.Link)
-		if ok {
-			s += cl.ByteLen()
-		} else if lnk == nil {
-			s += 0
-		} else {
-			s += len(lnk.Binary())
-		}
+		s += estimateEntrySize(e.Name.Must().String(), e.Hash.Link())
+	}
+	return s
+}
+
+func estimateEntrySize(name string, lnk ipld.Link) int {
+	s := len(name)
+	cl, ok := lnk.(cidlink.Link)
+	if ok {
+		s += cl.ByteLen()
+	} else if lnk == nil {
+		s += 0
+	} else {
+		s += len(lnk.Binary())
 	}
 	return s
 }
 
+// DirectoryOption configures how a directory is built.
+type DirectoryOption func(*directoryOptions)
+
+type directoryOptions struct {
+	mode            *int
+	mtime           time.Time
+	preserveModes   bool
+	allowDuplicates bool
+	shardThreshold  int
+	shardEntries    int
+	shardFanout     int
+	specialFiles    SpecialFilePolicy
+	chunking        ChunkingFunc
+	onBuilt         func(BuiltEntry) error
+}
+
+// WithDirectoryMtime records t as the modification time of the directory, in
+// its root node.
+func WithDirectoryMtime(t time.Time) DirectoryOption {
+	return func(o *directoryOptions) {
+		o.mtime = t
+	}
+}
+
+// WithDirectoryMode records mode as the mode of the directory, in its root
+// node, as WithMode does for files.
+func WithDirectoryMode(mode int) DirectoryOption {
+	return func(o *directoryOptions) {
+		o.mode = &mode
+	}
+}
+
+// WithPreservedModes makes BuildUnixFSRecursive record the mode of each file,
+// directory and symlink as it is found on disk, converted with
+// data.ModeFromFileMode, including the setuid, setgid and sticky bits. Modes
+// are otherwise not recorded, and readers assume the defaults for each type.
+func WithPreservedModes() DirectoryOption {
+	return func(o *directoryOptions) {
+		o.preserveModes = true
+	}
+}
+
+// WithDuplicateNames allows a sharded directory to be built with more than
+// one entry of the same name, for producing deliberately ambiguous
+// directories such as test fixtures. Entries of the same name are linked side
+// by side from the same shard.
+func WithDuplicateNames() DirectoryOption {
+	return func(o *directoryOptions) {
+		o.allowDuplicates = true
+	}
+}
+
+// WithShardingThreshold sets the estimated size in bytes above which
+// BuildUnixFSDirectory builds a sharded directory, matching Kubo's
+// Import.UnixFSHAMTDirectorySizeThreshold (formerly HAMTShardingSize). The size
+// is estimated as the total length of the entries' names and CIDs. The default
+// is 256KiB; a negative threshold never shards by size.
+func WithShardingThreshold(bytes int) DirectoryOption {
+	return func(o *directoryOptions) {
+		o.shardThreshold = bytes
+	}
+}
+
+// WithShardingEntryCount makes BuildUnixFSDirectory build a sharded directory
+// when there are more than n entries, whatever their estimated size. Zero, the
+// default, sets no limit.
+func WithShardingEntryCount(n int) DirectoryOption {
+	return func(o *directoryOptions) {
+		o.shardEntries = n
+	}
+}
+
+// WithShardFanout sets the fanout of the sharded directories that
+// BuildUnixFSDirectory, DirAssembler and BuildUnixFSRecursive build when a
+// directory grows past the sharding threshold, matching Kubo's
+// Import.UnixFSHAMTDirectoryMaxFanout. It must be a power of two; the default
+// is 256.
+func WithShardFanout(fanout int) DirectoryOption {
+	return func(o *directoryOptions) {
+		o.shardFanout = fanout
+	}
+}
+
+// ChunkingFunc chooses how a regular file of a recursive build is stored,
+// given its path and size: the chunker string passed to BuildUnixFSFile, and
+// options for it, such as WithLayout. The path is that of the file as opened,
+// the root of the build joined with the names of the directories leading to
+// it.
+type ChunkingFunc func(path string, size int64) (chunker string, opts []FileOption)
+
+// WithChunking makes BuildUnixFSRecursive store each regular file as fn
+// chooses, so that media, small text files and huge binaries in one tree can
+// be chunked differently. Files are otherwise stored with the default chunker
+// and options.
+func WithChunking(fn ChunkingFunc) DirectoryOption {
+	return func(o *directoryOptions) {
+		o.chunking = fn
+	}
+}
+
+// BuiltEntry describes a file, directory or symlink built by
+// BuildUnixFSRecursive.
+type BuiltEntry struct {
+	// Path is the path of the entry as opened, as given to a ChunkingFunc
+	Path string
+	// Type is data.Data_File, data.Data_Directory or data.Data_Symlink.
+	// Special files stored as empty files are of type data.Data_File.
+	Type int64
+	Link ipld.Link
+	// Size is the number of bytes of a file, or of the target of a symlink.
+	// For a directory it is the total Size of the entries in it.
+	Size uint64
+	// StoredSize is the total size of the blocks of the entry, as given as the
+	// Tsize of the link to it from its parent directory.
+	StoredSize uint64
+}
+
+// WithOnBuilt makes BuildUnixFSRecursive call fn with each file, directory and
+// symlink once it has been built, so that a manifest of the tree or the
+// progress of the build can be reported without walking the DAG afterwards.
+// Entries are reported depth first, each directory after the entries in it,
+// ending with the root. An error returned by fn stops the build, and is
+// returned by BuildUnixFSRecursive.
+func WithOnBuilt(fn func(BuiltEntry) error) DirectoryOption {
+	return func(o *directoryOptions) {
+		o.onBuilt = fn
+	}
+}
+
+func applyDirectoryOptions(opts []DirectoryOption) directoryOptions {
+	o := directoryOptions{shardThreshold: shardSplitThreshold, shardFanout: defaultShardWidth}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// needsSharding reports whether a directory of count entries, of the given
+// estimated size, should be sharded.
+func (o directoryOptions) needsSharding(count, size int) bool {
+	if o.shardEntries > 0 && count > o.shardEntries {
+		return true
+	}
+	return o.shardThreshold >= 0 && size > o.shardThreshold
+}
+
 // BuildUnixFSDirectory creates a directory link over a collection of entries.
-func BuildUnixFSDirectory(entries []dagpb.PBLink, ls *ipld.LinkSystem) (ipld.Link, uint64, error) {
-	if estimateDirSize(entries) > shardSplitThreshold {
-		return BuildUnixFSShardedDirectory(defaultShardWidth, multihash.MURMUR3X64_64, entries, ls)
+// Directories too large for a single block are sharded, see
+// WithShardingThreshold and WithShardingEntryCount.
+func BuildUnixFSDirectory(entries []dagpb.PBLink, ls *ipld.LinkSystem, opts ...DirectoryOption) (ipld.Link, uint64, error) {
+	o := applyDirectoryOptions(opts)
+	if o.needsSharding(len(entries), estimateDirSize(entries)) {
+		return BuildUnixFSShardedDirectory(o.shardFanout, multihash.MURMUR3X64_64, entries, ls, opts...)
 	}
 	ufd, err := BuildUnixFS(func(b *Builder) {
 		DataType(b, data.Data_Directory)
+		optionalMode(b, o.mode)
+		optionalMtime(b, o.mtime)
 	})
 	if err != nil {
 		return nil, 0, err
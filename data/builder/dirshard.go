@@ -3,12 +3,17 @@ package builder
 import (
 	"fmt"
 	"hash"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	bitfield "github.com/ipfs/go-bitfield"
 	"github.com/ipfs/go-unixfsnode/data"
 	"github.com/ipfs/go-unixfsnode/hamt"
 	dagpb "github.com/ipld/go-codec-dagpb"
 	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/multiformats/go-multihash"
 	"github.com/spaolacci/murmur3"
 )
@@ -20,14 +25,23 @@ type shard struct {
 	sizeLg2 int
 	width   int
 	depth   int
+	// mode and mtime are only set on the root shard
+	mode  *int
+	mtime time.Time
+	// allowDuplicates keeps entries of the same name side by side
+	allowDuplicates bool
+	// linkProto is the link prototype the shards are stored under
+	linkProto cidlink.LinkPrototype
 
 	children map[int]entry
 }
 
-// a shard entry is either another shard, or a direct link.
+// a shard entry is either another shard, or a direct link. dups holds any
+// further links of the same name, which can't be told apart by their hash.
 type entry struct {
 	*shard
 	*hamtLink
+	dups []*hamtLink
 }
 
 // a hamtLink is a member of the hamt - the file/directory pointed to, but
@@ -37,60 +51,113 @@ type hamtLink struct {
 	dagpb.PBLink
 }
 
+// ErrDuplicateName is returned when a directory is built with more than one
+// entry of the same name. It is data.ErrDuplicateName, which reading a
+// directory with directory.RejectDuplicates also returns.
+type ErrDuplicateName = data.ErrDuplicateName
+
 // BuildUnixFSShardedDirectory will build a hamt of unixfs hamt shards encoing a directory with more entries
 // than is typically allowed to fit in a standard IPFS single-block unixFS directory.
-func BuildUnixFSShardedDirectory(size int, hasher uint64, entries []dagpb.PBLink, ls *ipld.LinkSystem) (ipld.Link, uint64, error) {
-	// hash the entries
-	var h hash.Hash
-	var err error
-	// TODO: use the multihash registry once murmur3 behavior is encoded there.
-	// https://github.com/multiformats/go-multihash/pull/150
-	if hasher == hamt.HashMurmur3 {
-		h = murmur3.New64()
-	} else {
-		h, err = multihash.GetHasher(hasher)
+//
+// Entries must have distinct names, or ErrDuplicateName is returned, unless
+// WithDuplicateNames is given.
+func BuildUnixFSShardedDirectory(size int, hasher uint64, entries []dagpb.PBLink, ls *ipld.LinkSystem, opts ...DirectoryOption) (ipld.Link, uint64, error) {
+	o := applyDirectoryOptions(opts)
+	newHasher, err := shardHasher(hasher)
+	if err != nil {
+		return nil, 0, err
+	}
+	hamtEntries := hashEntries(entries, newHasher)
+
+	sharder, err := newRootShard(size, hasher, o)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for i := range hamtEntries {
+		err := sharder.add(&hamtEntries[i])
 		if err != nil {
 			return nil, 0, err
 		}
 	}
-	hamtEntries := make([]hamtLink, 0, len(entries))
-	for _, e := range entries {
-		name := e.Name.Must().String()
-		h.Reset()
-		h.Write([]byte(name))
-		sum := h.Sum(nil)
-		hamtEntries = append(hamtEntries, hamtLink{
-			sum,
-			e,
-		})
-	}
 
+	return sharder.serialize(ls)
+}
+
+func newRootShard(size int, hasher uint64, o directoryOptions) (*shard, error) {
 	sizeLg2, err := logtwo(size)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
-
-	sharder := shard{
+	return &shard{
 		hasher:  hasher,
 		size:    size,
 		sizeLg2: sizeLg2,
 		width:   len(fmt.Sprintf("%X", size-1)),
 		depth:   0,
+		mode:    o.mode,
+		mtime:   o.mtime,
 
-		children: make(map[int]entry),
+		allowDuplicates: o.allowDuplicates,
+		linkProto:       fileLinkProto,
+		children:        make(map[int]entry),
+	}, nil
+}
+
+// parallelHashThreshold is the number of entries above which their names are
+// hashed concurrently.
+var parallelHashThreshold = 4096
+
+// shardHasher returns a constructor for the hash function the shard keys are
+// hashed with.
+func shardHasher(hasher uint64) (func() hash.Hash, error) {
+	// TODO: use the multihash registry once murmur3 behavior is encoded there.
+	// https://github.com/multiformats/go-multihash/pull/150
+	if hasher == hamt.HashMurmur3 {
+		return func() hash.Hash { return murmur3.New64() }, nil
 	}
+	if _, err := multihash.GetHasher(hasher); err != nil {
+		return nil, err
+	}
+	return func() hash.Hash {
+		h, _ := multihash.GetHasher(hasher)
+		return h
+	}, nil
+}
 
-	for _, entry := range hamtEntries {
-		err := sharder.add(entry)
-		if err != nil {
-			return nil, 0, err
+// hashEntries hashes the names of entries, spreading large directories across
+// a worker per CPU. The links are returned in the order of entries, so that
+// they are inserted into the shard in the same order however they were hashed.
+func hashEntries(entries []dagpb.PBLink, newHasher func() hash.Hash) []hamtLink {
+	hamtEntries := make([]hamtLink, len(entries))
+	hashRange := func(start, end int) {
+		h := newHasher()
+		for i := start; i < end; i++ {
+			h.Reset()
+			h.Write([]byte(entries[i].Name.Must().String()))
+			hamtEntries[i] = hamtLink{h.Sum(nil), entries[i]}
 		}
 	}
-
-	return sharder.serialize(ls)
+	workers := runtime.GOMAXPROCS(0)
+	if len(entries) <= parallelHashThreshold || workers == 1 {
+		hashRange(0, len(entries))
+		return hamtEntries
+	}
+	per := (len(entries) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(entries); start += per {
+		end := min(start+per, len(entries))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hashRange(start, end)
+		}()
+	}
+	wg.Wait()
+	return hamtEntries
 }
 
-func (s *shard) add(lnk hamtLink) error {
+func (s *shard) add(lnk *hamtLink) error {
 	// get the bucket for lnk
 	bucket, err := lnk.hash.Slice(s.depth*s.sizeLg2, s.sizeLg2)
 	if err != nil {
@@ -100,27 +167,41 @@ func (s *shard) add(lnk hamtLink) error {
 	current, ok := s.children[bucket]
 	if !ok {
 		// no bucket, make one with this entry
-		s.children[bucket] = entry{nil, &lnk}
+		s.children[bucket] = entry{nil, lnk, nil}
 		return nil
 	} else if current.shard != nil {
 		// existing shard, add this link to the shard
 		return current.shard.add(lnk)
+	} else if current.Name.Must().String() == lnk.Name.Must().String() {
+		// the same name hashes the same all the way down, so keep it here
+		if !s.allowDuplicates {
+			return ErrDuplicateName{Name: lnk.Name.Must().String()}
+		}
+		current.dups = append(current.dups, lnk)
+		s.children[bucket] = current
+		return nil
 	}
 	// make a shard for current and lnk
 	newShard := entry{
 		&shard{
-			hasher:   s.hasher,
-			size:     s.size,
-			sizeLg2:  s.sizeLg2,
-			width:    s.width,
-			depth:    s.depth + 1,
-			children: make(map[int]entry),
+			hasher:  s.hasher,
+			size:    s.size,
+			sizeLg2: s.sizeLg2,
+			width:   s.width,
+			depth:   s.depth + 1,
+
+			allowDuplicates: s.allowDuplicates,
+			linkProto:       s.linkProto,
+			children:        make(map[int]entry),
 		},
 		nil,
+		nil,
 	}
-	// add existing link from this bucket to the new shard
-	if err := newShard.add(*current.hamtLink); err != nil {
-		return err
+	// add existing links from this bucket to the new shard
+	for _, l := range append([]*hamtLink{current.hamtLink}, current.dups...) {
+		if err := newShard.add(l); err != nil {
+			return err
+		}
 	}
 	// replace bucket with shard
 	s.children[bucket] = newShard
@@ -129,7 +210,14 @@ func (s *shard) add(lnk hamtLink) error {
 }
 
 func (s *shard) formatLinkName(name string, idx int) string {
-	return fmt.Sprintf("%0*X%s", s.width, idx, name)
+	const hexDigits = "0123456789ABCDEF"
+	var sb strings.Builder
+	sb.Grow(s.width + len(name))
+	for shift := 4 * (s.width - 1); shift >= 0; shift -= 4 {
+		sb.WriteByte(hexDigits[(idx>>shift)&0xf])
+	}
+	sb.WriteString(name)
+	return sb.String()
 }
 
 // bitmap calculates the bitmap of which links in the shard are set.
@@ -158,6 +246,8 @@ func (s *shard) serialize(ls *ipld.LinkSystem) (ipld.Link, uint64, error) {
 		HashType(b, s.hasher)
 		Data(b, bm)
 		Fanout(b, uint64(s.size))
+		optionalMode(b, s.mode)
+		optionalMtime(b, s.mtime)
 	})
 	if err != nil {
 		return nil, 0, err
@@ -177,15 +267,17 @@ func (s *shard) serialize(ls *ipld.LinkSystem) (ipld.Link, uint64, error) {
 		return nil, 0, err
 	}
 
-	lnkBuilder := dagpb.Type.PBLinks.NewBuilder()
-	lnks, err := lnkBuilder.BeginList(int64(len(s.children)))
+	count := len(s.children)
+	for _, e := range s.children {
+		count += len(e.dups)
+	}
+	lnks, err := pbm.AssembleValue().BeginList(int64(count))
 	if err != nil {
 		return nil, 0, err
 	}
 	// sorting happens in codec-dagpb
 	var totalSize uint64
 	for idx, e := range s.children {
-		var lnk dagpb.PBLink
 		if e.shard != nil {
 			ipldLnk, sz, err := e.shard.serialize(ls)
 			if err != nil {
@@ -193,32 +285,28 @@ func (s *shard) serialize(ls *ipld.LinkSystem) (ipld.Link, uint64, error) {
 			}
 			totalSize += sz
 			fullName := s.formatLinkName("", idx)
-			lnk, err = BuildUnixFSDirectoryEntry(fullName, int64(sz), ipldLnk)
-			if err != nil {
+			if err := assembleLink(lnks.AssembleValue(), fullName, int64(sz), ipldLnk); err != nil {
 				return nil, 0, err
 			}
 		} else {
-			fullName := s.formatLinkName(e.Name.Must().String(), idx)
-			sz := e.Tsize.Must().Int()
-			totalSize += uint64(sz)
-			lnk, err = BuildUnixFSDirectoryEntry(fullName, sz, e.Hash.Link())
-		}
-		if err != nil {
-			return nil, 0, err
-		}
-		if err := lnks.AssembleValue().AssignNode(lnk); err != nil {
-			return nil, 0, err
+			for _, l := range append([]*hamtLink{e.hamtLink}, e.dups...) {
+				fullName := s.formatLinkName(l.Name.Must().String(), idx)
+				sz := l.Tsize.Must().Int()
+				totalSize += uint64(sz)
+				if err := assembleLink(lnks.AssembleValue(), fullName, sz, l.Hash.Link()); err != nil {
+					return nil, 0, err
+				}
+			}
 		}
 	}
 	if err := lnks.Finish(); err != nil {
 		return nil, 0, err
 	}
-	pbm.AssembleValue().AssignNode(lnkBuilder.Build())
 	if err := pbm.Finish(); err != nil {
 		return nil, 0, err
 	}
 	node := pbb.Build()
-	lnk, sz, err := sizedStore(ls, fileLinkProto, node)
+	lnk, sz, err := sizedStore(ls, s.linkProto, node)
 	if err != nil {
 		return nil, 0, err
 	}
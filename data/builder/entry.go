@@ -0,0 +1,52 @@
+package builder
+
+import (
+	"github.com/ipfs/go-cid"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// BuildUnixFSDirectoryEntryForRoot creates the link to an existing file or
+// directory, stored under root in ls, as it appears within a unixfs directory,
+// so that a DAG built earlier can be added to a new directory. Only the root
+// block is loaded: the Tsize is the size of the root block plus the Tsize of
+// each of its links, so it is only as accurate as the Tsizes the root
+// declares.
+func BuildUnixFSDirectoryEntryForRoot(name string, root ipld.Link, ls *ipld.LinkSystem) (dagpb.PBLink, error) {
+	size, err := rootTsize(root, ls)
+	if err != nil {
+		return nil, err
+	}
+	return BuildUnixFSDirectoryEntry(name, int64(size), root)
+}
+
+func rootTsize(root ipld.Link, ls *ipld.LinkSystem) (uint64, error) {
+	block, err := ls.LoadRaw(ipld.LinkContext{}, root)
+	if err != nil {
+		return 0, err
+	}
+	size := uint64(len(block))
+	if cl, ok := root.(cidlink.Link); !ok || cl.Cid.Prefix().Codec != cid.DagProtobuf {
+		return size, nil
+	}
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagpb.DecodeBytes(nb, block); err != nil {
+		return 0, err
+	}
+	return pbTsize(block, nb.Build().(dagpb.PBNode)), nil
+}
+
+// pbTsize is the size of a dag-pb block, decoded as pbn, plus the Tsize of
+// each of its links.
+func pbTsize(block []byte, pbn dagpb.PBNode) uint64 {
+	size := uint64(len(block))
+	itr := pbn.FieldLinks().Iterator()
+	for !itr.Done() {
+		_, lnk := itr.Next()
+		if lnk.FieldTsize().Exists() {
+			size += uint64(lnk.FieldTsize().Must().Int())
+		}
+	}
+	return size
+}
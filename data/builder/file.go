@@ -3,15 +3,14 @@ package builder
 import (
 	"fmt"
 	"io"
+	"time"
 
-	chunk "github.com/ipfs/boxo/chunker"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-unixfsnode/data"
 	dagpb "github.com/ipld/go-codec-dagpb"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
-	basicnode "github.com/ipld/go-ipld-prime/node/basic"
 	"github.com/multiformats/go-multicodec"
 	multihash "github.com/multiformats/go-multihash/core"
 
@@ -57,28 +56,121 @@ func (fs fileShards) byteSizes() []uint64 {
 // go-unixfsnode & ipld-prime data layout of nodes.
 // We make some assumptions in building files with this builder to reduce
 // complexity, namely:
-//   - we assume we are using CIDv1, which has implied that the leaf
+//   - by default we use CIDv1, which has implied that the leaf
 //     data nodes are stored as raw bytes.
 //     ref: https://github.com/ipfs/go-mfs/blob/1b1fd06cff048caabeddb02d4dbf22d2274c7971/file.go#L50
-func BuildUnixFSFile(r io.Reader, chunker string, ls *ipld.LinkSystem) (ipld.Link, uint64, error) {
-	src, err := chunk.FromString(r, chunker)
+//     WithLeafLinkPrototype and WithInteriorLinkPrototype change this.
+//
+// Memory use doesn't grow with the size of the file: one chunk is read,
+// hashed and stored at a time, and only the links of the nodes still being
+// built are kept, a few kilobytes for each level of the tree. WithReadAhead
+// lets reading overlap with storing, within a set bound.
+//
+// chunker is a chunker string as accepted by boxo's chunker.FromString, such
+// as "size-262144", or one selecting a chunker added with RegisterChunker.
+func BuildUnixFSFile(r io.Reader, chunker string, ls *ipld.LinkSystem, opts ...FileOption) (ipld.Link, uint64, error) {
+	src, err := newSplitter(r, chunker)
 	if err != nil {
 		return nil, 0, err
 	}
+	o := &fileOptions{
+		leafProto:     DefaultLeafLinkPrototype,
+		interiorProto: DefaultInteriorLinkPrototype,
+		linksPerBlock: DefaultLinksPerBlock,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if codec := o.interiorProto.Prefix.Codec; codec != cid.DagProtobuf {
+		return nil, 0, fmt.Errorf("interior nodes must be dag-pb, not codec 0x%x", codec)
+	}
+	if codec := o.leafProto.Prefix.Codec; codec != cid.Raw && codec != cid.DagProtobuf {
+		return nil, 0, fmt.Errorf("leaves must be raw or dag-pb, not codec 0x%x", codec)
+	}
+	if o.linksPerBlock < 2 {
+		return nil, 0, fmt.Errorf("links per block must be at least 2, not %d", o.linksPerBlock)
+	}
+	if o.readAhead > 0 {
+		ra := newReadAheadSplitter(src, o.readAhead)
+		defer ra.close()
+		src = ra
+	}
+	ps := &peekSplitter{Splitter: src}
+	switch o.layout {
+	case Balanced:
+	case Trickle:
+		return buildTrickle(ps, ls, o)
+	default:
+		return nil, 0, fmt.Errorf("unknown layout %d", o.layout)
+	}
 
+	return buildBalanced(&chunkLeaves{src: ps, ls: ls, o: o}, ls, o)
+}
+
+// leafSource supplies the leaves of a balanced file in order.
+type leafSource interface {
+	// next returns the next leaf, or one with a nil link if there are none
+	// left. top is set when the leaf may turn out to be the whole file.
+	next(top bool) (fileShardMeta, error)
+	// more reports whether next will return another leaf.
+	more() bool
+	// standalone reports whether a leaf that is the whole file can be its
+	// root, rather than needing a File node above it.
+	standalone(leaf fileShardMeta) bool
+}
+
+// chunkLeaves stores the chunks of a splitter as leaves.
+type chunkLeaves struct {
+	src *peekSplitter
+	ls  *ipld.LinkSystem
+	o   *fileOptions
+}
+
+func (cl *chunkLeaves) next(top bool) (fileShardMeta, error) {
+	leaf, err := cl.src.NextBytes()
+	if err != nil {
+		if err == io.EOF {
+			return fileShardMeta{}, nil
+		}
+		return fileShardMeta{}, err
+	}
+	return storeLeaf(cl.ls, cl.o, leaf, top && !cl.src.more())
+}
+
+func (cl *chunkLeaves) more() bool {
+	return cl.src.more()
+}
+
+func (cl *chunkLeaves) standalone(leaf fileShardMeta) bool {
+	// an encoded leaf can't stand alone as a file, readers need a File node
+	// above it to know it is encoded and how big it is, and a raw leaf has
+	// nowhere to keep a mode or modification time
+	codec := leaf.link.(cidlink.Link).Cid.Prefix().Codec
+	return codec == cid.DagProtobuf || (codec == cid.Raw && !cl.o.hasMetadata())
+}
+
+// buildBalanced stores a file in the balanced layout, over the leaves from
+// leaves.
+func buildBalanced(leaves leafSource, ls *ipld.LinkSystem, o *fileOptions) (ipld.Link, uint64, error) {
 	var prev fileShards
 	depth := 1
 	for {
-		next, err := fileTreeRecursive(depth, prev, src, ls)
+		next, err := fileTreeRecursive(depth, prev, true, leaves, ls, o)
 		if err != nil {
 			return nil, 0, err
 		}
 
 		if prev != nil && prev[0].link == next.link {
 			if next.link == nil {
-				node := basicnode.NewBytes([]byte{})
-				link, err := ls.Store(ipld.LinkContext{}, leafLinkProto, node)
-				return link, 0, err
+				lp := o.leafProto
+				if lp.Prefix.Codec != cid.DagProtobuf && o.hasMetadata() {
+					lp = o.interiorProto
+				}
+				empty, err := storePlainLeaf(ls, lp, data.Data_File, []byte{}, o.mode, o.mtime)
+				return empty.link, empty.storedSize, err
+			}
+			if depth == 2 && !leaves.standalone(next) {
+				return wrapLeaf(ls, o, next)
 			}
 			return next.link, next.storedSize, nil
 		}
@@ -88,7 +180,9 @@ func BuildUnixFSFile(r io.Reader, chunker string, ls *ipld.LinkSystem) (ipld.Lin
 	}
 }
 
-var fileLinkProto = cidlink.LinkPrototype{
+// DefaultInteriorLinkPrototype is the link prototype dag-pb nodes are stored
+// under: CIDv1, sha2-256.
+var DefaultInteriorLinkPrototype = cidlink.LinkPrototype{
 	Prefix: cid.Prefix{
 		Version:  1,
 		Codec:    uint64(multicodec.DagPb),
@@ -97,7 +191,9 @@ var fileLinkProto = cidlink.LinkPrototype{
 	},
 }
 
-var leafLinkProto = cidlink.LinkPrototype{
+// DefaultLeafLinkPrototype is the link prototype file leaves are stored under:
+// CIDv1 raw leaves, sha2-256.
+var DefaultLeafLinkPrototype = cidlink.LinkPrototype{
 	Prefix: cid.Prefix{
 		Version:  1,
 		Codec:    uint64(multicodec.Raw),
@@ -106,33 +202,228 @@ var leafLinkProto = cidlink.LinkPrototype{
 	},
 }
 
+var fileLinkProto = DefaultInteriorLinkPrototype
+
+// FileOption configures how BuildUnixFSFile stores a file.
+type FileOption func(*fileOptions)
+
+type fileOptions struct {
+	leafEncoder   LeafEncoder
+	readAhead     int
+	leafProto     cidlink.LinkPrototype
+	interiorProto cidlink.LinkPrototype
+	linksPerBlock int
+	layout        Layout
+	mode          *int
+	mtime         time.Time
+}
+
+// hasMetadata reports whether the root of the file records a mode or mtime.
+func (o *fileOptions) hasMetadata() bool {
+	return o.mode != nil || !o.mtime.IsZero()
+}
+
+// Layout is the shape of the tree the leaves of a file are arranged in.
+type Layout int
+
+const (
+	// Balanced fills every File node with links before adding a level above
+	// them, keeping all leaves at the same depth. It matches
+	// github.com/ipfs/boxo/ipld/unixfs/importer/balanced, and is the default.
+	Balanced Layout = iota
+	// Trickle links leaves directly from the root first and then adds
+	// progressively deeper subtrees, so the start of a file is reached quickly
+	// when streaming. It matches github.com/ipfs/boxo/ipld/unixfs/importer/trickle.
+	Trickle
+)
+
+// WithLayout sets the layout of the tree of File nodes.
+func WithLayout(l Layout) FileOption {
+	return func(o *fileOptions) {
+		o.layout = l
+	}
+}
+
+// WithMtime records t as the modification time of the file, in its root node.
+// A file that would be stored as a single raw leaf is given a File node above
+// the leaf to hold it.
+func WithMtime(t time.Time) FileOption {
+	return func(o *fileOptions) {
+		o.mtime = t
+	}
+}
+
+// WithMode records mode as the mode of the file, in its root node: the
+// permission bits, and the setuid, setgid and sticky bits, as converted from
+// an fs.FileMode by data.ModeFromFileMode. As with WithMtime, a file that
+// would be stored as a single raw leaf is given a File node above the leaf to
+// hold it.
+func WithMode(mode int) FileOption {
+	return func(o *fileOptions) {
+		o.mode = &mode
+	}
+}
+
+// WithLinksPerBlock sets the maximum number of children of each File node,
+// DefaultLinksPerBlock if not set.
+func WithLinksPerBlock(n int) FileOption {
+	return func(o *fileOptions) {
+		o.linksPerBlock = n
+	}
+}
+
+// WithLeafLinkPrototype sets the link prototype leaves are stored under. With
+// the raw codec, leaves hold only the content of the file; with dag-pb, each
+// leaf is a UnixFS File node holding its content, as with CIDv0 imports. Any
+// other codec is rejected. It has no effect on leaves stored by a LeafEncoder.
+func WithLeafLinkPrototype(lp cidlink.LinkPrototype) FileOption {
+	return func(o *fileOptions) {
+		o.leafProto = lp
+	}
+}
+
+// WithInteriorLinkPrototype sets the link prototype of the File nodes that
+// link to the leaves, such as to use CIDv0 or another hash function. The
+// codec must be dag-pb.
+func WithInteriorLinkPrototype(lp cidlink.LinkPrototype) FileOption {
+	return func(o *fileOptions) {
+		o.interiorProto = lp
+	}
+}
+
+// LeafEncoder transforms the content of a leaf before it is stored, such as by
+// encrypting or compressing it. It returns the bytes to store and the link
+// prototype to store them under; the codec of the prototype marks the leaf as
+// encoded, so it should be one that readers can map back to a decoder (see
+// file.WithLeafDecoder). A leaf may be stored unchanged by returning it with a
+// prototype for the raw codec.
+type LeafEncoder func(leaf []byte) ([]byte, cidlink.LinkPrototype, error)
+
+// WithLeafEncoder stores every leaf of the file through enc. File nodes record
+// the size of the original content of each leaf, so the file can still be
+// read and seeked by readers that can decode its leaves.
+func WithLeafEncoder(enc LeafEncoder) FileOption {
+	return func(o *fileOptions) {
+		o.leafEncoder = enc
+	}
+}
+
+// WithReadAhead reads chunks from the input in the background while earlier
+// chunks are hashed and stored, for inputs and storage that are both slow.
+// Reading stops once maxBytes of chunks are waiting to be stored, and resumes
+// as they are, so no more than maxBytes plus the chunk being read are held in
+// memory. The LinkSystem is only used from the calling goroutine.
+func WithReadAhead(maxBytes int) FileOption {
+	return func(o *fileOptions) {
+		o.readAhead = maxBytes
+	}
+}
+
+// storeLeaf stores the content of a leaf, encoding it first if required. A
+// leaf that is the whole file is its root, and carries the file's mode and
+// mtime if it is a dag-pb node.
+func storeLeaf(ls *ipld.LinkSystem, o *fileOptions, leaf []byte, root bool) (fileShardMeta, error) {
+	if o.leafEncoder == nil {
+		// dag-pb trickle leaves are typed Raw, where balanced ones are File
+		leafType := data.Data_File
+		if o.layout == Trickle {
+			leafType = data.Data_Raw
+		}
+		var mode *int
+		var mtime time.Time
+		if root {
+			mode, mtime = o.mode, o.mtime
+		}
+		return storePlainLeaf(ls, o.leafProto, leafType, leaf, mode, mtime)
+	}
+	block, lp, err := o.leafEncoder(leaf)
+	if err != nil {
+		return fileShardMeta{}, err
+	}
+	l, err := storeBlock(ls, lp, block)
+	if err != nil {
+		return fileShardMeta{}, err
+	}
+	return fileShardMeta{link: l, byteSize: uint64(len(leaf)), storedSize: uint64(len(block))}, nil
+}
+
+// storePlainLeaf stores the content of a leaf as a raw block, or in a dag-pb
+// node of the given UnixFS type, mode and mtime if lp is dag-pb.
+func storePlainLeaf(ls *ipld.LinkSystem, lp cidlink.LinkPrototype, leafType int64, leaf []byte, mode *int, mtime time.Time) (fileShardMeta, error) {
+	if lp.Prefix.Codec == cid.DagProtobuf {
+		node, err := BuildUnixFS(func(b *Builder) {
+			DataType(b, leafType)
+			if len(leaf) > 0 {
+				Data(b, leaf)
+			}
+			FileSize(b, uint64(len(leaf)))
+			optionalMode(b, mode)
+			optionalMtime(b, mtime)
+		})
+		if err != nil {
+			return fileShardMeta{}, err
+		}
+		pbn, err := packFileChildren(node, nil)
+		if err != nil {
+			return fileShardMeta{}, err
+		}
+		l, sz, err := sizedStore(ls, lp, pbn)
+		if err != nil {
+			return fileShardMeta{}, err
+		}
+		return fileShardMeta{link: l, byteSize: uint64(len(leaf)), storedSize: sz}, nil
+	}
+	// a raw block is its content, so raw leaves are stored without building a
+	// node to encode
+	l, err := storeBlock(ls, lp, leaf)
+	if err != nil {
+		return fileShardMeta{}, err
+	}
+	return fileShardMeta{link: l, byteSize: uint64(len(leaf)), storedSize: uint64(len(leaf))}, nil
+}
+
+// wrapLeaf stores a File node with a single leaf as its child.
+func wrapLeaf(ls *ipld.LinkSystem, o *fileOptions, leaf fileShardMeta) (ipld.Link, uint64, error) {
+	children := fileShards{leaf}
+	node, err := BuildUnixFS(func(b *Builder) {
+		FileSize(b, children.totalByteSize())
+		BlockSizes(b, children.byteSizes())
+		optionalMode(b, o.mode)
+		optionalMtime(b, o.mtime)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	pbn, err := packFileChildren(node, children)
+	if err != nil {
+		return nil, 0, err
+	}
+	link, sz, err := sizedStore(ls, o.interiorProto, pbn)
+	if err != nil {
+		return nil, 0, err
+	}
+	return link, leaf.storedSize + sz, nil
+}
+
 // fileTreeRecursive packs a file into chunks recursively, returning a root for
 // this level of recursion, the number of file bytes consumed for this level of
 // recursion and and the number of bytes used to store this level of recursion.
+// top is set for the levels built by BuildUnixFSFile itself, the last of which
+// is the root of the file.
 func fileTreeRecursive(
 	depth int,
 	children fileShards,
-	src chunk.Splitter,
+	top bool,
+	leaves leafSource,
 	ls *ipld.LinkSystem,
+	o *fileOptions,
 ) (fileShardMeta, error) {
 	if depth == 1 {
-		// file leaf, next chunk, encode as raw bytes, store and retuen
+		// file leaf, the next one from the source
 		if len(children) > 0 {
 			return fileShardMeta{}, fmt.Errorf("leaf nodes cannot have children")
 		}
-		leaf, err := src.NextBytes()
-		if err != nil {
-			if err == io.EOF {
-				return fileShardMeta{}, nil
-			}
-			return fileShardMeta{}, err
-		}
-		node := basicnode.NewBytes(leaf)
-		l, sz, err := sizedStore(ls, leafLinkProto, node)
-		if err != nil {
-			return fileShardMeta{}, err
-		}
-		return fileShardMeta{link: l, byteSize: uint64(len(leaf)), storedSize: sz}, nil
+		return leaves.next(top)
 	}
 
 	// depth > 1
@@ -140,12 +431,13 @@ func fileTreeRecursive(
 	if children == nil {
 		children = make(fileShards, 0)
 	}
+	given := len(children)
 
 	// fill up the links for this level, if we need to go beyond
-	// DefaultLinksPerBlock we'll end up back here making a parallel tree
-	for len(children) < DefaultLinksPerBlock {
+	// the links per block we'll end up back here making a parallel tree
+	for len(children) < o.linksPerBlock {
 		// descend down toward the leaves
-		next, err := fileTreeRecursive(depth-1, nil, src, ls)
+		next, err := fileTreeRecursive(depth-1, nil, false, leaves, ls, o)
 		if err != nil {
 			return fileShardMeta{}, err
 		} else if next.link == nil { // eof
@@ -157,15 +449,22 @@ func fileTreeRecursive(
 	if len(children) == 0 {
 		// empty case
 		return fileShardMeta{}, nil
-	} else if len(children) == 1 {
-		// degenerate case
+	} else if len(children) == 1 && given == 1 {
+		// degenerate case, nothing was added below the previous root. A new
+		// subtree with a single child still gets a node of its own, as it
+		// does in the balanced importer.
 		return children[0], nil
 	}
 
 	// make the unixfs node
+	root := top && !leaves.more()
 	node, err := BuildUnixFS(func(b *Builder) {
 		FileSize(b, children.totalByteSize())
 		BlockSizes(b, children.byteSizes())
+		if root {
+			optionalMode(b, o.mode)
+			optionalMtime(b, o.mtime)
+		}
 	})
 	if err != nil {
 		return fileShardMeta{}, err
@@ -175,7 +474,7 @@ func fileTreeRecursive(
 		return fileShardMeta{}, err
 	}
 
-	link, sz, err := sizedStore(ls, fileLinkProto, pbn)
+	link, sz, err := sizedStore(ls, o.interiorProto, pbn)
 	if err != nil {
 		return fileShardMeta{}, err
 	}
@@ -201,11 +500,7 @@ func packFileChildren(node data.UnixFSData, children fileShards) (datamodel.Node
 		return nil, err
 	}
 	for _, c := range children {
-		pbln, err := BuildUnixFSDirectoryEntry("", int64(c.storedSize), c.link)
-		if err != nil {
-			return nil, err
-		}
-		if err = pbl.AssembleValue().AssignNode(pbln); err != nil {
+		if err = assembleLink(pbl.AssembleValue(), "", int64(c.storedSize), c.link); err != nil {
 			return nil, err
 		}
 	}
@@ -227,40 +522,77 @@ func packFileChildren(node data.UnixFSData, children fileShards) (datamodel.Node
 // BuildUnixFSDirectoryEntry creates the link to a file or directory as it appears within a unixfs directory.
 func BuildUnixFSDirectoryEntry(name string, size int64, hash ipld.Link) (dagpb.PBLink, error) {
 	dpbl := dagpb.Type.PBLink.NewBuilder()
-	lma, err := dpbl.BeginMap(3)
-	if err != nil {
+	if err := assembleLink(dpbl, name, size, hash); err != nil {
 		return nil, err
 	}
+	return dpbl.Build().(dagpb.PBLink), nil
+}
+
+// assembleLink assembles a PBLink in place, such as directly into the Links
+// list of a node, without first building it as a node of its own.
+func assembleLink(na ipld.NodeAssembler, name string, size int64, hash ipld.Link) error {
+	lma, err := na.BeginMap(3)
+	if err != nil {
+		return err
+	}
 	if err = lma.AssembleKey().AssignString("Hash"); err != nil {
-		return nil, err
+		return err
 	}
 	if err = lma.AssembleValue().AssignLink(hash); err != nil {
-		return nil, err
+		return err
 	}
 	if err = lma.AssembleKey().AssignString("Name"); err != nil {
-		return nil, err
+		return err
 	}
 	if err = lma.AssembleValue().AssignString(name); err != nil {
-		return nil, err
+		return err
 	}
 	if err = lma.AssembleKey().AssignString("Tsize"); err != nil {
-		return nil, err
+		return err
 	}
 	if err = lma.AssembleValue().AssignInt(size); err != nil {
-		return nil, err
+		return err
 	}
-	if err = lma.Finish(); err != nil {
-		return nil, err
+	return lma.Finish()
+}
+
+// SymlinkOption configures how BuildUnixFSSymlink stores a symlink.
+type SymlinkOption func(*symlinkOptions)
+
+type symlinkOptions struct {
+	mode  *int
+	mtime time.Time
+}
+
+// WithSymlinkMode records the permission bits of mode for the symlink, which
+// has no mode otherwise.
+func WithSymlinkMode(mode int) SymlinkOption {
+	return func(o *symlinkOptions) {
+		o.mode = &mode
 	}
-	return dpbl.Build().(dagpb.PBLink), nil
 }
 
-// BuildUnixFSSymlink builds a symlink entry in a unixfs tree
-func BuildUnixFSSymlink(content string, ls *ipld.LinkSystem) (ipld.Link, uint64, error) {
+// WithSymlinkMtime records t as the modification time of the symlink.
+func WithSymlinkMtime(t time.Time) SymlinkOption {
+	return func(o *symlinkOptions) {
+		o.mtime = t
+	}
+}
+
+// BuildUnixFSSymlink builds a symlink entry in a unixfs tree. Like the other
+// builders, it returns the link to the stored node and the total size of the
+// DAG under it, which for a symlink is the size of its single block.
+func BuildUnixFSSymlink(content string, ls *ipld.LinkSystem, opts ...SymlinkOption) (ipld.Link, uint64, error) {
+	var o symlinkOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
 	// make the unixfs node.
 	node, err := BuildUnixFS(func(b *Builder) {
 		DataType(b, data.Data_Symlink)
 		Data(b, []byte(content))
+		optionalMode(b, o.mode)
+		optionalMtime(b, o.mtime)
 	})
 	if err != nil {
 		return nil, 0, err
@@ -3,15 +3,23 @@ package builder
 import (
 	"bytes"
 	"context"
+	"errors"
+	"io"
 	"strconv"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode/data"
 	"github.com/ipfs/go-unixfsnode/file"
 	dagpb "github.com/ipld/go-codec-dagpb"
 	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/require"
 )
 
@@ -106,3 +114,211 @@ func TestUnixFSFileRoundtrip(t *testing.T) {
 		t.Fatal("Not equal")
 	}
 }
+
+func TestUnixFSFileLeafEncoder(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	// reverse the bytes of each leaf, stored under an otherwise unused codec
+	const codec = 0x300001
+	lp := cidlink.LinkPrototype{Prefix: DefaultLeafLinkPrototype.Prefix}
+	lp.Codec = codec
+	reverse := func(b []byte) []byte {
+		out := make([]byte, len(b))
+		for i := range b {
+			out[len(b)-1-i] = b[i]
+		}
+		return out
+	}
+	enc := func(leaf []byte) ([]byte, cidlink.LinkPrototype, error) {
+		return reverse(leaf), lp, nil
+	}
+	dec := func(_ context.Context, _ cid.Cid, block []byte) ([]byte, error) {
+		return reverse(block), nil
+	}
+	ctx := file.WithLeafDecoder(context.Background(), codec, dec)
+
+	for _, size := range []int{100, 1 << 20} {
+		content := random.Bytes(size)
+		f, _, err := BuildUnixFSFile(bytes.NewReader(content), "size-4096", &ls, WithLeafEncoder(enc))
+		require.NoError(t, err)
+		fr, err := ls.Load(ipld.LinkContext{}, f, dagpb.Type.PBNode)
+		require.NoError(t, err)
+		ufn, err := file.NewUnixFSFile(ctx, fr, &ls)
+		require.NoError(t, err)
+		out, err := ufn.AsBytes()
+		require.NoError(t, err)
+		require.Equal(t, content, out)
+	}
+}
+
+func TestUnixFSFileLinkPrototypes(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	v0 := cidlink.LinkPrototype{Prefix: cid.Prefix{Version: 0, Codec: cid.DagProtobuf, MhType: multihash.SHA2_256, MhLength: 32}}
+	sha512Raw := cidlink.LinkPrototype{Prefix: DefaultLeafLinkPrototype.Prefix}
+	sha512Raw.MhType = multihash.SHA2_512
+	sha512Raw.MhLength = 64
+
+	for _, tc := range []struct {
+		name     string
+		leaf     cidlink.LinkPrototype
+		interior cidlink.LinkPrototype
+	}{
+		{"raw sha2-512 leaves, CIDv0 interior", sha512Raw, v0},
+		{"CIDv0 throughout", v0, v0},
+		{"dag-pb leaves, CIDv1 interior", DefaultInteriorLinkPrototype, DefaultInteriorLinkPrototype},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, size := range []int{0, 100, 100000} {
+				content := random.Bytes(size)
+				f, sz, err := BuildUnixFSFile(bytes.NewReader(content), "size-4096", &ls,
+					WithLeafLinkPrototype(tc.leaf), WithInteriorLinkPrototype(tc.interior))
+				require.NoError(t, err)
+
+				root := f.(cidlink.Link).Cid
+				if size <= 4096 {
+					// a single leaf is the file
+					require.Equal(t, tc.leaf.Prefix, root.Prefix())
+				}
+				if root.Prefix().Codec == cid.Raw {
+					out, err := ls.LoadRaw(ipld.LinkContext{}, f)
+					require.NoError(t, err)
+					require.True(t, bytes.Equal(content, out))
+					continue
+				}
+				fr, err := ls.Load(ipld.LinkContext{}, f, dagpb.Type.PBNode)
+				require.NoError(t, err)
+				if size > 4096 {
+					require.Equal(t, tc.interior.Prefix, root.Prefix())
+					links := fr.(dagpb.PBNode).Links
+					require.Positive(t, links.Length())
+					itr := links.Iterator()
+					for !itr.Done() {
+						_, lnk := itr.Next()
+						require.Equal(t, tc.leaf.Prefix, lnk.Hash.Link().(cidlink.Link).Cid.Prefix())
+					}
+				}
+				if tc.leaf.Codec == cid.DagProtobuf {
+					require.Greater(t, int(sz), size)
+				}
+
+				ufn, err := file.NewUnixFSFile(context.Background(), fr, &ls)
+				require.NoError(t, err)
+				out, err := ufn.AsBytes()
+				require.NoError(t, err)
+				require.True(t, bytes.Equal(content, out))
+			}
+		})
+	}
+
+	// leaves may only be raw or dag-pb, interior nodes only dag-pb
+	_, _, err := BuildUnixFSFile(bytes.NewReader([]byte("hello")), "", &ls,
+		WithInteriorLinkPrototype(DefaultLeafLinkPrototype))
+	require.Error(t, err)
+	dagCbor := cidlink.LinkPrototype{Prefix: DefaultLeafLinkPrototype.Prefix}
+	dagCbor.Codec = cid.DagCBOR
+	_, _, err = BuildUnixFSFile(bytes.NewReader([]byte("hello")), "", &ls, WithLeafLinkPrototype(dagCbor))
+	require.Error(t, err)
+}
+
+type countingReader struct {
+	r    io.Reader
+	read atomic.Int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.read.Add(int64(n))
+	return n, err
+}
+
+func TestUnixFSFileReadAhead(t *testing.T) {
+	content := random.Bytes(1 << 20)
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+	expected, expectedSize, err := BuildUnixFSFile(bytes.NewReader(content), "size-4096", &ls)
+	require.NoError(t, err)
+
+	const maxBytes = 5 * 4096
+	cr := &countingReader{r: bytes.NewReader(content)}
+	var stored, maxAhead int64
+	slowLs := ls
+	slowLs.StorageWriteOpener = func(lc linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		w, commit, err := storage.OpenWrite(lc)
+		return w, func(lnk ipld.Link) error {
+			time.Sleep(100 * time.Microsecond)
+			if lnk.(cidlink.Link).Cid.Prefix().Codec == cid.Raw {
+				stored += 4096
+			}
+			maxAhead = max(maxAhead, cr.read.Load()-stored)
+			return commit(lnk)
+		}, err
+	}
+	lnk, size, err := BuildUnixFSFile(cr, "size-4096", &slowLs, WithReadAhead(maxBytes))
+	require.NoError(t, err)
+	require.Equal(t, expected, lnk)
+	require.Equal(t, expectedSize, size)
+	// the chunk being stored, those waiting, and one being read
+	require.LessOrEqual(t, maxAhead, int64(maxBytes+2*4096))
+	require.Greater(t, maxAhead, int64(4096))
+
+	// errors from either side stop the build
+	fail := errors.New("fail")
+	_, _, err = BuildUnixFSFile(io.MultiReader(bytes.NewReader(content[:100000]), iotest.ErrReader(fail)), "size-4096", &ls, WithReadAhead(maxBytes))
+	require.ErrorIs(t, err, fail)
+	failingLs := ls
+	failingLs.StorageWriteOpener = func(linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		return nil, nil, fail
+	}
+	_, _, err = BuildUnixFSFile(bytes.NewReader(content), "size-4096", &failingLs, WithReadAhead(maxBytes))
+	require.ErrorIs(t, err, fail)
+}
+
+func TestBuildUnixFSSymlinkMetadata(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	plain, plainSize, err := BuildUnixFSSymlink("../target", &ls)
+	require.NoError(t, err)
+	mtime := time.Unix(1700000000, 5)
+	lnk, size, err := BuildUnixFSSymlink("../target", &ls, WithSymlinkMode(0o777), WithSymlinkMtime(mtime))
+	require.NoError(t, err)
+	require.NotEqual(t, plain, lnk)
+
+	for _, tc := range []struct {
+		lnk  ipld.Link
+		size uint64
+	}{{plain, plainSize}, {lnk, size}} {
+		// a symlink is a single block, so its size is that of the block
+		blk, err := ls.LoadRaw(ipld.LinkContext{}, tc.lnk)
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(blk)), tc.size)
+	}
+
+	nd, err := ls.Load(ipld.LinkContext{}, lnk, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	ufsData, err := data.DecodeUnixFSData(nd.(dagpb.PBNode).FieldData().Must().Bytes())
+	require.NoError(t, err)
+	require.Equal(t, data.Data_Symlink, ufsData.FieldDataType().Int())
+	require.Equal(t, "../target", string(ufsData.FieldData().Must().Bytes()))
+	require.Equal(t, 0o777, ufsData.Permissions())
+	require.Equal(t, mtime.Unix(), ufsData.FieldMtime().Must().FieldSeconds().Int())
+	require.Equal(t, int64(5), ufsData.FieldMtime().Must().FieldFractionalNanoseconds().Must().Int())
+
+	nd, err = ls.Load(ipld.LinkContext{}, plain, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	ufsData, err = data.DecodeUnixFSData(nd.(dagpb.PBNode).FieldData().Must().Bytes())
+	require.NoError(t, err)
+	require.False(t, ufsData.FieldMode().Exists())
+	require.False(t, ufsData.FieldMtime().Exists())
+}
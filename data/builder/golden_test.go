@@ -0,0 +1,54 @@
+package builder_test
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// goldenCIDs are the roots Kubo gives the golden corpus, as built by the boxo
+// importers it uses (ipfs add with the options of each profile).
+var goldenCIDs = testutil.GoldenTable{
+	"balanced-256k-rawleaves-cidv1": {
+		"empty":               "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku",
+		"one-byte":            "bafkreigrnpjc64mwyctq6sysvifssdcmjlhm2xlluniozscep667jq4btm",
+		"small":               "bafkreifjbyy4gj2jj73lxwq5dvysqe2g6deurwwtljikk2zj6uihjbkh5a",
+		"chunk-less-one":      "bafkreidkekit5viyw74qufcxykavrnormkyro5wwrtfddhvtfnxxs5nyxm",
+		"chunk":               "bafkreic74vehgcjkowwtjsjzgt3kxuet5dfyybptqw7f5o52g4jb5tqcda",
+		"chunk-plus-one":      "bafybeihpfpytaqwxrj77l2lxq7xz63qfznwkztb7e2chio6lw5bqe7vp7y",
+		"two-chunks-plus-one": "bafybeibtalcu7v7hgi343dikbitwfcsovmiza3ob6m6joasv7zzqbefqby",
+		"one-mib-odd":         "bafybeicru62ntn3d5ye7j3hqpcg33gbdxwqezxhou4i2tcahxqajuzvj2q",
+		"three-mib":           "bafybeift25icohdpla72b2qegcttpwdaakj3xd4s5k37xeojcpi3gdi2aa",
+	},
+	"trickle-legacy": {
+		"empty":               "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH",
+		"one-byte":            "QmRreDgwDtqDAvLqnR3c5W5mVVBewzTNKBhr8vhryDzxQj",
+		"small":               "Qmdqr8h9pAWGtnAMmQ7eaohQrZk6izRmmr8dMmijJibsre",
+		"chunk-less-one":      "QmbHQCjkAmcGhfkGG3CNHdZG8ZP1qbsV3jzpTXsntwJdPu",
+		"chunk":               "QmevwHuByvXy3mhmJ8nSVCXFz5ymBv5522xrQP7pqsbj5s",
+		"chunk-plus-one":      "QmSJ18XvHKFaR5jgTwgtqxVZS7TZcq6AQ7p69VkHCvk84w",
+		"two-chunks-plus-one": "QmXcgZiHLzmQRXuL62sE9ArJ4jpKJhXeRAoPgL74XHX54e",
+		"one-mib-odd":         "QmXuvQjyhRyXXqoJZMzwuJZJjs2VGA9hoTR4UFHzaMjNBZ",
+		"three-mib":           "QmY72UTQNHZjbmKH446LztkmQ88UpcstqruSCJCNjSeA2C",
+	},
+	"unixfs-v1-cidv0": {
+		"empty":               "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH",
+		"one-byte":            "QmXYh4zddPgvsVWGHtiWiqa62h4jq8GaQFupT3DxAA19Y3",
+		"small":               "QmPcWvjUvNGycYPNrXqRWZMHTWcK8Wduf4L58hWEfBScF4",
+		"chunk-less-one":      "QmbEzJb34JSet46K2fRicCHSSmrSRv3R3xMkoZwYH8AY6u",
+		"chunk":               "QmVe4Ny6paootoBLpuYJ5CzSF1ZJtqhg2nPRNFUeJGXYM5",
+		"chunk-plus-one":      "QmTK5C1C1CLEtftMANyUHNUx2TSUd3JjUWc7Q6eGK4ZnVZ",
+		"two-chunks-plus-one": "QmcAdhuqoQ42oiPxDxMGUK6tEm1MRpDFsgzfMxaZtaJBxX",
+		"one-mib-odd":         "QmfHNN5SVvgD7FFxEPKVafqqiGXJ44ZQFd9WbNPeJCokuq",
+		"three-mib":           "Qmef3hxgoDHSEghJLpUb5By8yq1oUbzxPzZoQZsGZwuMTt",
+	},
+}
+
+func TestGoldenCIDs(t *testing.T) {
+	mismatches, err := testutil.CheckGoldenCIDs(goldenCIDs)
+	require.NoError(t, err)
+	for _, m := range mismatches {
+		t.Error(m)
+	}
+}
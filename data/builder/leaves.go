@@ -0,0 +1,89 @@
+package builder
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// LeafMeta describes a leaf of a file that has already been stored.
+type LeafMeta struct {
+	// Link is the link to the leaf block.
+	Link ipld.Link
+	// Size is the number of bytes of the file the leaf holds.
+	Size uint64
+	// StoredSize is the size of the leaf block, given as the Tsize of links to
+	// it. If zero, Size is used, as it is for raw leaves.
+	StoredSize uint64
+}
+
+// BuildUnixFSFileFromLeaves builds the File nodes of a balanced file over
+// leaves that are already stored, such as by workers that chunk and store
+// content elsewhere, and returns the root of the file and its total size.
+// The leaves are given in the order of the content they hold, and aren't
+// loaded, so their sizes must be right.
+//
+// Leaves may be raw blocks, dag-pb File nodes, or blocks of any other codec
+// for readers with a decoder for it (see WithLeafEncoder). The options that
+// apply are those for the interior nodes: WithInteriorLinkPrototype,
+// WithLinksPerBlock and WithMtime; the layout must be Balanced. A file of a
+// single leaf is that leaf, unless it needs a File node above it as
+// BuildUnixFSFile would give it. An empty file is stored as it is by
+// BuildUnixFSFile.
+func BuildUnixFSFileFromLeaves(leaves []LeafMeta, ls *ipld.LinkSystem, opts ...FileOption) (ipld.Link, uint64, error) {
+	o := &fileOptions{
+		leafProto:     DefaultLeafLinkPrototype,
+		interiorProto: DefaultInteriorLinkPrototype,
+		linksPerBlock: DefaultLinksPerBlock,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if codec := o.interiorProto.Prefix.Codec; codec != cid.DagProtobuf {
+		return nil, 0, fmt.Errorf("interior nodes must be dag-pb, not codec 0x%x", codec)
+	}
+	if o.linksPerBlock < 2 {
+		return nil, 0, fmt.Errorf("links per block must be at least 2, not %d", o.linksPerBlock)
+	}
+	if o.layout != Balanced {
+		return nil, 0, fmt.Errorf("files can only be built from leaves in the balanced layout")
+	}
+	for i, l := range leaves {
+		if _, ok := l.Link.(cidlink.Link); !ok {
+			return nil, 0, fmt.Errorf("leaf %d: unsupported link type %T", i, l.Link)
+		}
+	}
+	return buildBalanced(&storedLeaves{leaves: leaves, o: o}, ls, o)
+}
+
+// storedLeaves supplies leaves that are already stored.
+type storedLeaves struct {
+	leaves []LeafMeta
+	o      *fileOptions
+}
+
+func (sl *storedLeaves) next(bool) (fileShardMeta, error) {
+	if len(sl.leaves) == 0 {
+		return fileShardMeta{}, nil
+	}
+	l := sl.leaves[0]
+	sl.leaves = sl.leaves[1:]
+	stored := l.StoredSize
+	if stored == 0 {
+		stored = l.Size
+	}
+	return fileShardMeta{link: l.Link, byteSize: l.Size, storedSize: stored}, nil
+}
+
+func (sl *storedLeaves) more() bool {
+	return len(sl.leaves) > 0
+}
+
+func (sl *storedLeaves) standalone(leaf fileShardMeta) bool {
+	// the leaf is already stored, so it can't be given the file's mode or
+	// mtime
+	codec := leaf.link.(cidlink.Link).Cid.Prefix().Codec
+	return (codec == cid.DagProtobuf || codec == cid.Raw) && !sl.o.hasMetadata()
+}
@@ -0,0 +1,57 @@
+package builder
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode/data"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildUnixFSFileFromLeaves(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	pbLeaves := WithLeafLinkPrototype(DefaultInteriorLinkPrototype)
+	mtime := WithMtime(time.Unix(1700000000, 0))
+	for _, size := range []int{0, 500, 1024, 200 * 1024} {
+		content := random.Bytes(size)
+		for _, opts := range [][]FileOption{nil, {pbLeaves}, {mtime}, {pbLeaves, mtime}, {WithLinksPerBlock(3)}} {
+			expected, expectedSize, err := BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls, opts...)
+			require.NoError(t, err)
+
+			o := &fileOptions{leafProto: DefaultLeafLinkPrototype}
+			for _, opt := range opts {
+				opt(o)
+			}
+			var leaves []LeafMeta
+			for off := 0; off < size; off += 1024 {
+				chunk := content[off:min(off+1024, size)]
+				leaf, err := storePlainLeaf(&ls, o.leafProto, data.Data_File, chunk, nil, time.Time{})
+				require.NoError(t, err)
+				leaves = append(leaves, LeafMeta{Link: leaf.link, Size: leaf.byteSize, StoredSize: leaf.storedSize})
+			}
+
+			root, rootSize, err := BuildUnixFSFileFromLeaves(leaves, &ls, opts...)
+			require.NoError(t, err)
+			if len(leaves) == 1 && o.leafProto.Prefix.Codec == cid.DagProtobuf && !o.mtime.IsZero() {
+				// a stored dag-pb leaf can't be given the mtime, so it's wrapped
+				require.NotEqual(t, expected, root)
+				continue
+			}
+			require.Equal(t, expected, root, size)
+			require.Equal(t, expectedSize, rootSize, size)
+		}
+	}
+
+	_, _, err := BuildUnixFSFileFromLeaves([]LeafMeta{{}}, &ls)
+	require.Error(t, err)
+	_, _, err = BuildUnixFSFileFromLeaves(nil, &ls, WithLayout(Trickle))
+	require.Error(t, err)
+}
@@ -0,0 +1,168 @@
+package builder
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// ManifestEntry is an entry of a manifest of a UnixFS directory tree: a file,
+// directory or symlink, by the path it's at and the CID of its DAG. Manifests
+// are listed by walk.Manifest, and built into a tree by
+// BuildUnixFSDirectoryFromManifest.
+type ManifestEntry struct {
+	// Path is the slash-separated path from the root, which is at ""
+	Path string `json:"path"`
+	// Type is data.Data_File, data.Data_Directory or data.Data_Symlink.
+	// Files stored as a single raw block are of type data.Data_File, and
+	// sharded directories of type data.Data_Directory.
+	Type int64 `json:"type"`
+	// Cid is the CID of the root block of the file, directory or symlink
+	Cid cid.Cid `json:"cid"`
+	// Size is the number of bytes of a file, or of the target of a symlink.
+	// It is 0 for directories.
+	Size uint64 `json:"size"`
+	// StoredSize is the size of the root block plus the Tsize of each of its
+	// links, as it's given as the Tsize of a link to the entry. A manifest
+	// may leave it 0 for BuildUnixFSDirectoryFromManifest to work it out
+	// from the root block.
+	StoredSize uint64 `json:"storedSize"`
+}
+
+// manifestDir is a directory of the tree described by a manifest, holding
+// the entries of the manifest within it.
+type manifestDir struct {
+	subdirs map[string]*manifestDir
+	links   map[string]ManifestEntry
+	// row is the entry listing the directory itself, if any
+	row *ManifestEntry
+}
+
+// BuildUnixFSDirectoryFromManifest builds a tree of directories holding the
+// DAGs listed in entries at their paths, and returns its root. The DAGs are
+// linked as they are: none of their blocks are stored again, and only the root
+// blocks of those without a StoredSize are loaded. Directories above the
+// entries are made as needed, and each is built by BuildUnixFSDirectory with
+// opts, so large ones are sharded.
+//
+// An entry of type data.Data_Directory is linked as it is only if no entries
+// are listed within it; otherwise it is rebuilt from those entries, like the
+// directories that aren't listed. The manifest of a whole tree, as listed by
+// walk.Manifest, so builds the tree again, including the root at "".
+//
+// Paths must be distinct, and no entry may be within the path of an entry
+// that isn't a directory. Empty, "." and ".." path segments are rejected.
+func BuildUnixFSDirectoryFromManifest(entries []ManifestEntry, ls *ipld.LinkSystem, opts ...DirectoryOption) (ipld.Link, uint64, error) {
+	root := &manifestDir{}
+	for _, e := range entries {
+		if err := root.add(e); err != nil {
+			return nil, 0, err
+		}
+	}
+	return root.build(ls, opts)
+}
+
+func (d *manifestDir) add(e ManifestEntry) error {
+	isDir := e.Type == data.Data_Directory
+	trimmed := strings.Trim(e.Path, "/")
+	var segments []string
+	if trimmed != "" || !isDir {
+		segments = strings.Split(trimmed, "/")
+	}
+	for _, seg := range segments {
+		if seg == "" || seg == "." || seg == ".." {
+			return fmt.Errorf("invalid path %q", e.Path)
+		}
+	}
+	parents := segments
+	if !isDir {
+		parents = segments[:len(segments)-1]
+	}
+	for i, seg := range parents {
+		if _, ok := d.links[seg]; ok {
+			return fmt.Errorf("%s is within the entry %s", e.Path, path.Join(segments[:i+1]...))
+		}
+		if d.subdirs == nil {
+			d.subdirs = make(map[string]*manifestDir)
+		}
+		sub, ok := d.subdirs[seg]
+		if !ok {
+			sub = &manifestDir{}
+			d.subdirs[seg] = sub
+		}
+		d = sub
+	}
+	if isDir {
+		if d.row != nil {
+			return fmt.Errorf("duplicate entry %s", e.Path)
+		}
+		d.row = &e
+		return nil
+	}
+	name := segments[len(segments)-1]
+	if _, ok := d.links[name]; ok {
+		return fmt.Errorf("duplicate entry %s", e.Path)
+	}
+	if _, ok := d.subdirs[name]; ok {
+		return fmt.Errorf("entries within %s, which is an entry", e.Path)
+	}
+	if d.links == nil {
+		d.links = make(map[string]ManifestEntry)
+	}
+	d.links[name] = e
+	return nil
+}
+
+func (d *manifestDir) build(ls *ipld.LinkSystem, opts []DirectoryOption) (ipld.Link, uint64, error) {
+	if d.row != nil && len(d.subdirs) == 0 && len(d.links) == 0 {
+		return manifestLink(*d.row, ls)
+	}
+	entries := make([]dagpb.PBLink, 0, len(d.subdirs)+len(d.links))
+	for name, sub := range d.subdirs {
+		lnk, size, err := sub.build(ls, opts)
+		if err != nil {
+			return nil, 0, err
+		}
+		entry, err := BuildUnixFSDirectoryEntry(name, int64(size), lnk)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	for name, e := range d.links {
+		lnk, size, err := manifestLink(e, ls)
+		if err != nil {
+			return nil, 0, err
+		}
+		entry, err := BuildUnixFSDirectoryEntry(name, int64(size), lnk)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	// the order of a sharded directory's entries can change its CID
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].FieldName().Must().String() < entries[j].FieldName().Must().String()
+	})
+	return BuildUnixFSDirectory(entries, ls, opts...)
+}
+
+// manifestLink returns the link to the DAG of e and its Tsize.
+func manifestLink(e ManifestEntry, ls *ipld.LinkSystem) (ipld.Link, uint64, error) {
+	lnk := cidlink.Link{Cid: e.Cid}
+	if e.StoredSize != 0 {
+		return lnk, e.StoredSize, nil
+	}
+	size, err := rootTsize(lnk, ls)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", e.Path, err)
+	}
+	return lnk, size, nil
+}
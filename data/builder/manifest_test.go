@@ -0,0 +1,79 @@
+package builder
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-test/random"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildUnixFSDirectoryFromManifest(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	file, fileSize, err := BuildUnixFSFile(bytes.NewReader(random.Bytes(100000)), "size-1024", &ls)
+	require.NoError(t, err)
+	raw, rawSize, err := BuildUnixFSFile(bytes.NewReader([]byte("raw")), "", &ls)
+	require.NoError(t, err)
+	entries, err := mkEntries(10, &ls)
+	require.NoError(t, err)
+	dir, dirSize, err := BuildUnixFSDirectory(entries, &ls)
+	require.NoError(t, err)
+
+	entry := func(name string, lnk ipld.Link, size uint64) dagpb.PBLink {
+		e, err := BuildUnixFSDirectoryEntry(name, int64(size), lnk)
+		require.NoError(t, err)
+		return e
+	}
+	opts := []DirectoryOption{WithShardingEntryCount(2)}
+	b, bSize, err := BuildUnixFSDirectory([]dagpb.PBLink{entry("file", file, fileSize)}, &ls, opts...)
+	require.NoError(t, err)
+	a, aSize, err := BuildUnixFSDirectory([]dagpb.PBLink{
+		entry("b", b, bSize),
+		entry("raw", raw, rawSize),
+		entry("dir", dir, dirSize),
+	}, &ls, opts...)
+	require.NoError(t, err)
+	expected, expectedSize, err := BuildUnixFSDirectory([]dagpb.PBLink{
+		entry("a", a, aSize),
+		entry("raw", raw, rawSize),
+	}, &ls, opts...)
+	require.NoError(t, err)
+
+	manifest := []ManifestEntry{
+		{Path: "a/b/file", Cid: file.(cidlink.Link).Cid, StoredSize: fileSize},
+		{Path: "/a/raw", Cid: raw.(cidlink.Link).Cid, StoredSize: rawSize},
+		{Path: "a/dir/", Cid: dir.(cidlink.Link).Cid, StoredSize: dirSize},
+		{Path: "raw", Cid: raw.(cidlink.Link).Cid},
+	}
+	loads := 0
+	counting := ls
+	counting.StorageReadOpener = func(lc ipld.LinkContext, l ipld.Link) (io.Reader, error) {
+		loads++
+		return storage.OpenRead(lc, l)
+	}
+	root, size, err := BuildUnixFSDirectoryFromManifest(manifest, &counting, opts...)
+	require.NoError(t, err)
+	require.Equal(t, expected, root)
+	require.Equal(t, expectedSize, size)
+	// only the entry without a size is loaded
+	require.Equal(t, 1, loads)
+
+	for _, bad := range [][]ManifestEntry{
+		{{Path: "a/../b", Cid: raw.(cidlink.Link).Cid}},
+		{{Path: "", Cid: raw.(cidlink.Link).Cid}},
+		{{Path: "a", Cid: raw.(cidlink.Link).Cid}, {Path: "a", Cid: raw.(cidlink.Link).Cid}},
+		{{Path: "a", Cid: dir.(cidlink.Link).Cid}, {Path: "a/b", Cid: raw.(cidlink.Link).Cid}},
+		{{Path: "a/b", Cid: raw.(cidlink.Link).Cid}, {Path: "a", Cid: dir.(cidlink.Link).Cid}},
+	} {
+		_, _, err := BuildUnixFSDirectoryFromManifest(bad, &ls)
+		require.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,76 @@
+package builder
+
+import (
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+)
+
+// BuildUnixFSMetadataData builds the Metadata message held in the Data of a
+// UnixFS Metadata node. An empty mimeType is left out.
+func BuildUnixFSMetadataData(mimeType string) (data.UnixFSMetadata, error) {
+	nd, err := qp.BuildMap(data.Type.UnixFSMetadata, -1, func(ma ipld.MapAssembler) {
+		if mimeType != "" {
+			qp.MapEntry(ma, data.Field__MimeType, qp.String(mimeType))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nd.(data.UnixFSMetadata), nil
+}
+
+// BuildUnixFSMetadata wraps the file or directory at target, of the given
+// total size, in a UnixFS Metadata node giving its MIME type. The Metadata node
+// has a single, unnamed link to target. Like the other builders, the link to
+// the stored node and its total size, including target, are returned.
+func BuildUnixFSMetadata(mimeType string, target ipld.Link, targetSize uint64, ls *ipld.LinkSystem) (ipld.Link, uint64, error) {
+	md, err := BuildUnixFSMetadataData(mimeType)
+	if err != nil {
+		return nil, 0, err
+	}
+	ufd, err := BuildUnixFS(func(b *Builder) {
+		DataType(b, data.Data_Metadata)
+		Data(b, data.EncodeUnixFSMetadata(md))
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	entry, err := BuildUnixFSDirectoryEntry("", int64(targetSize), target)
+	if err != nil {
+		return nil, 0, err
+	}
+	pbb := dagpb.Type.PBNode.NewBuilder()
+	pbm, err := pbb.BeginMap(2)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err = pbm.AssembleKey().AssignString("Data"); err != nil {
+		return nil, 0, err
+	}
+	if err = pbm.AssembleValue().AssignBytes(data.EncodeUnixFSData(ufd)); err != nil {
+		return nil, 0, err
+	}
+	if err = pbm.AssembleKey().AssignString("Links"); err != nil {
+		return nil, 0, err
+	}
+	lnks, err := pbm.AssembleValue().BeginList(1)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := lnks.AssembleValue().AssignNode(entry); err != nil {
+		return nil, 0, err
+	}
+	if err := lnks.Finish(); err != nil {
+		return nil, 0, err
+	}
+	if err := pbm.Finish(); err != nil {
+		return nil, 0, err
+	}
+	lnk, sz, err := sizedStore(ls, fileLinkProto, pbb.Build())
+	if err != nil {
+		return nil, 0, err
+	}
+	return lnk, targetSize + sz, nil
+}
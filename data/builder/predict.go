@@ -0,0 +1,167 @@
+package builder
+
+import (
+	"fmt"
+
+	bitfield "github.com/ipfs/go-bitfield"
+	"github.com/ipfs/go-unixfsnode/data"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ShardEntry is a directory entry as known to PredictShardedDirectory: its
+// name and the Tsize of the link to it.
+type ShardEntry struct {
+	Name  string
+	Tsize uint64
+}
+
+// PredictedShard is a shard block PredictShardedDirectory expects to be built.
+type PredictedShard struct {
+	// Depth is the level of the shard, 0 for the root
+	Depth int
+	// Links is the number of links in the shard, to entries and child shards
+	Links int
+	// Size is the size of the encoded block
+	Size int
+}
+
+// ShardPrediction is the HAMT a sharded directory would be built as.
+type ShardPrediction struct {
+	// Shards lists the shard blocks, the root first and then depth first in
+	// the order of their buckets
+	Shards []PredictedShard
+	// Depth is the number of levels of shards, 1 if every entry is linked from
+	// the root
+	Depth int
+	// Size is the Tsize of a link to the root: the sizes of the shards and the
+	// Tsizes of the entries
+	Size uint64
+}
+
+// MaxShardSize returns the size of the largest shard block.
+func (p ShardPrediction) MaxShardSize() int {
+	var size int
+	for _, s := range p.Shards {
+		size = max(size, s.Size)
+	}
+	return size
+}
+
+// PredictedCidLength is the length PredictShardedDirectory assumes for the
+// CIDs of entries and shards: CIDv1 with a 32 byte digest, such as sha2-256.
+const PredictedCidLength = 36
+
+// PredictShardedDirectory predicts the HAMT BuildUnixFSShardedDirectory would
+// build for entries with the given fanout and hash function, without building
+// or storing anything, for planning imports and tuning sharding thresholds.
+// Entries are placed by hashing their names just as they are when building,
+// so the number of shards, their depths and the number of links in each are
+// exact. Sizes are exact if every CID is PredictedCidLength bytes long, and
+// approximate otherwise, such as for CIDv0 entries.
+func PredictShardedDirectory(size int, hasher uint64, entries []ShardEntry) (ShardPrediction, error) {
+	sizeLg2, err := logtwo(size)
+	if err != nil {
+		return ShardPrediction{}, err
+	}
+	newHasher, err := shardHasher(hasher)
+	if err != nil {
+		return ShardPrediction{}, err
+	}
+	h := newHasher()
+	hashed := make([]predictedEntry, len(entries))
+	for i, e := range entries {
+		h.Reset()
+		h.Write([]byte(e.Name))
+		hashed[i] = predictedEntry{ShardEntry: e, hash: h.Sum(nil)}
+	}
+	sp := &shardPredictor{
+		size:    size,
+		sizeLg2: sizeLg2,
+		width:   len(fmt.Sprintf("%X", size-1)),
+		hasher:  hasher,
+	}
+	var p ShardPrediction
+	p.Size, err = sp.predict(&p, hashed, 0)
+	if err != nil {
+		return ShardPrediction{}, err
+	}
+	return p, nil
+}
+
+type predictedEntry struct {
+	ShardEntry
+	hash hashBits
+}
+
+type shardPredictor struct {
+	size    int
+	sizeLg2 int
+	width   int
+	hasher  uint64
+}
+
+// predict adds the shard holding entries at the given depth, and those below
+// it, to p, and returns the Tsize of the link to it.
+func (sp *shardPredictor) predict(p *ShardPrediction, entries []predictedEntry, depth int) (uint64, error) {
+	buckets := make(map[int][]predictedEntry)
+	for _, e := range entries {
+		bucket, err := e.hash.Slice(depth*sp.sizeLg2, sp.sizeLg2)
+		if err != nil {
+			return 0, err
+		}
+		buckets[bucket] = append(buckets[bucket], e)
+	}
+	p.Depth = max(p.Depth, depth+1)
+	idx := len(p.Shards)
+	p.Shards = append(p.Shards, PredictedShard{Depth: depth, Links: len(buckets)})
+
+	bm, err := bitfield.NewBitfield(sp.size)
+	if err != nil {
+		return 0, err
+	}
+	var linksSize int
+	var tsize uint64
+	for bucket := 0; bucket < sp.size; bucket++ {
+		children, ok := buckets[bucket]
+		if !ok {
+			continue
+		}
+		bm.SetBit(bucket)
+		if len(children) == 1 {
+			linksSize += predictedLinkSize(sp.width+len(children[0].Name), children[0].Tsize)
+			tsize += children[0].Tsize
+			continue
+		}
+		if children[0].Name == children[1].Name {
+			return 0, ErrDuplicateName{Name: children[0].Name}
+		}
+		childTsize, err := sp.predict(p, children, depth+1)
+		if err != nil {
+			return 0, err
+		}
+		linksSize += predictedLinkSize(sp.width, childTsize)
+		tsize += childTsize
+	}
+
+	ufd, err := BuildUnixFS(func(b *Builder) {
+		DataType(b, data.Data_HAMTShard)
+		HashType(b, sp.hasher)
+		Data(b, bm.Bytes())
+		Fanout(b, uint64(sp.size))
+	})
+	if err != nil {
+		return 0, err
+	}
+	blockSize := protowire.SizeTag(1) + protowire.SizeBytes(len(data.EncodeUnixFSData(ufd))) + linksSize
+	p.Shards[idx].Size = blockSize
+	return tsize + uint64(blockSize), nil
+}
+
+// predictedLinkSize is the size of a dag-pb link, within the Links of its
+// node, with a name of the given length.
+func predictedLinkSize(nameLen int, tsize uint64) int {
+	lnk := protowire.SizeTag(1) + protowire.SizeBytes(PredictedCidLength) +
+		protowire.SizeTag(2) + protowire.SizeBytes(nameLen) +
+		protowire.SizeTag(3) + protowire.SizeVarint(tsize)
+	return protowire.SizeTag(2) + protowire.SizeBytes(lnk)
+}
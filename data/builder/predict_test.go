@@ -0,0 +1,67 @@
+package builder
+
+import (
+	"sort"
+	"testing"
+
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPredictShardedDirectory(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+	entries, err := mkEntries(2000, &ls)
+	require.NoError(t, err)
+	shardEntries := make([]ShardEntry, 0, len(entries))
+	for _, e := range entries {
+		shardEntries = append(shardEntries, ShardEntry{Name: e.Name.Must().String(), Tsize: uint64(e.Tsize.Must().Int())})
+	}
+
+	for _, fanout := range []int{16, 256} {
+		// build into a store of its own, to see the shards stored
+		shardLs := cidlink.DefaultLinkSystem()
+		shards := cidlink.Memory{}
+		shardLs.StorageWriteOpener = shards.OpenWrite
+		_, sz, err := BuildUnixFSShardedDirectory(fanout, multihash.MURMUR3X64_64, entries, &shardLs)
+		require.NoError(t, err)
+
+		p, err := PredictShardedDirectory(fanout, multihash.MURMUR3X64_64, shardEntries)
+		require.NoError(t, err)
+		require.Len(t, p.Shards, len(shards.Bag))
+		require.Equal(t, sz, p.Size)
+		var stored, predicted []int
+		for _, blk := range shards.Bag {
+			stored = append(stored, len(blk))
+		}
+		for _, s := range p.Shards {
+			predicted = append(predicted, s.Size)
+		}
+		sort.Ints(stored)
+		sort.Ints(predicted)
+		require.Equal(t, stored, predicted)
+		require.Equal(t, stored[len(stored)-1], p.MaxShardSize())
+
+		require.Equal(t, 0, p.Shards[0].Depth)
+		var links int
+		for _, s := range p.Shards {
+			require.Less(t, s.Depth, p.Depth)
+			links += s.Links
+		}
+		// every shard but the root is linked to from its parent
+		require.Equal(t, len(entries)+len(p.Shards)-1, links)
+	}
+
+	p, err := PredictShardedDirectory(256, multihash.MURMUR3X64_64, shardEntries[:1])
+	require.NoError(t, err)
+	require.Equal(t, 1, p.Depth)
+	require.Len(t, p.Shards, 1)
+
+	_, err = PredictShardedDirectory(256, multihash.MURMUR3X64_64, append(shardEntries, shardEntries[42]))
+	require.ErrorIs(t, err, ErrDuplicateName{Name: "file 42"})
+	_, err = PredictShardedDirectory(100, multihash.MURMUR3X64_64, shardEntries)
+	require.Error(t, err)
+}
@@ -0,0 +1,148 @@
+package builder
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	multihash "github.com/multiformats/go-multihash/core"
+)
+
+// Profile bundles the settings that decide the CID a file or directory is
+// given, so that trees can be built with the same CIDs as another importer.
+type Profile struct {
+	// Chunker is the chunker string passed to BuildUnixFSFile.
+	Chunker string
+	Layout  Layout
+	// CidVersion is the CID version of the File nodes, and of dag-pb leaves.
+	CidVersion uint64
+	// RawLeaves stores leaves as CIDv1 raw blocks, whatever the CidVersion.
+	RawLeaves     bool
+	LinksPerBlock int
+	// ShardFanout is the fanout of sharded directories, see WithShardFanout.
+	// Zero leaves the default.
+	ShardFanout int
+	// ShardingThreshold is the estimated size above which directories are
+	// sharded, see WithShardingThreshold. Zero leaves the default.
+	ShardingThreshold int
+}
+
+// profiles are the named profiles known to LookupProfile, each matching the
+// CIDs given by Kubo (ipfs add) with the equivalent flags.
+var profiles = map[string]Profile{
+	// the defaults of ipfs add
+	"unixfs-v1-cidv0": {
+		Chunker:       "size-262144",
+		Layout:        Balanced,
+		CidVersion:    0,
+		LinksPerBlock: 174,
+
+		ShardFanout:       256,
+		ShardingThreshold: 256 << 10,
+	},
+	// ipfs add --cid-version=1, which implies --raw-leaves
+	"balanced-256k-rawleaves-cidv1": {
+		Chunker:       "size-262144",
+		Layout:        Balanced,
+		CidVersion:    1,
+		RawLeaves:     true,
+		LinksPerBlock: 174,
+
+		ShardFanout:       256,
+		ShardingThreshold: 256 << 10,
+	},
+	// ipfs add --trickle
+	"trickle-legacy": {
+		Chunker:       "size-262144",
+		Layout:        Trickle,
+		CidVersion:    0,
+		LinksPerBlock: 174,
+
+		ShardFanout:       256,
+		ShardingThreshold: 256 << 10,
+	},
+}
+
+// LookupProfile returns a copy of the built-in profile named name.
+func LookupProfile(name string) (Profile, error) {
+	p, ok := profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown build profile %q", name)
+	}
+	return p, nil
+}
+
+// ProfileNames returns the names of the built-in profiles, sorted.
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FileOptions returns the options BuildUnixFSFile needs to build files as
+// described by the profile.
+func (p Profile) FileOptions() []FileOption {
+	interior := cidlink.LinkPrototype{Prefix: cid.Prefix{
+		Version:  p.CidVersion,
+		Codec:    cid.DagProtobuf,
+		MhType:   multihash.SHA2_256,
+		MhLength: 32,
+	}}
+	leaf := interior
+	if p.RawLeaves {
+		leaf = DefaultLeafLinkPrototype
+	}
+	return []FileOption{
+		WithLayout(p.Layout),
+		WithLinksPerBlock(p.LinksPerBlock),
+		WithInteriorLinkPrototype(interior),
+		WithLeafLinkPrototype(leaf),
+	}
+}
+
+// DirectoryOptions returns the options the directory builders need to build
+// directories as described by the profile: its sharding settings, and, for
+// BuildUnixFSRecursive, the chunker and options of FileOptions for every file.
+func (p Profile) DirectoryOptions() []DirectoryOption {
+	var opts []DirectoryOption
+	if p.ShardFanout != 0 {
+		opts = append(opts, WithShardFanout(p.ShardFanout))
+	}
+	if p.ShardingThreshold != 0 {
+		opts = append(opts, WithShardingThreshold(p.ShardingThreshold))
+	}
+	fileOpts := p.FileOptions()
+	opts = append(opts, WithChunking(func(string, int64) (string, []FileOption) {
+		return p.Chunker, fileOpts
+	}))
+	return opts
+}
+
+// BuildUnixFSFileWithProfile builds a file with BuildUnixFSFile using the
+// chunker and options of the named profile. Further options are applied after
+// those of the profile.
+func BuildUnixFSFileWithProfile(r io.Reader, profile string, ls *ipld.LinkSystem, opts ...FileOption) (ipld.Link, uint64, error) {
+	p, err := LookupProfile(profile)
+	if err != nil {
+		return nil, 0, err
+	}
+	return BuildUnixFSFile(r, p.Chunker, ls, append(p.FileOptions(), opts...)...)
+}
+
+// BuildUnixFSDirectoryWithProfile builds a directory with BuildUnixFSDirectory
+// using the sharding settings of the named profile. Further options are applied
+// after those of the profile.
+func BuildUnixFSDirectoryWithProfile(entries []dagpb.PBLink, profile string, ls *ipld.LinkSystem, opts ...DirectoryOption) (ipld.Link, uint64, error) {
+	p, err := LookupProfile(profile)
+	if err != nil {
+		return nil, 0, err
+	}
+	return BuildUnixFSDirectory(entries, ls, append(p.DirectoryOptions(), opts...)...)
+}
@@ -0,0 +1,170 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	chunk "github.com/ipfs/boxo/chunker"
+	dag "github.com/ipfs/boxo/ipld/merkledag"
+	mdtest "github.com/ipfs/boxo/ipld/merkledag/test"
+	"github.com/ipfs/boxo/ipld/unixfs/importer/balanced"
+	h "github.com/ipfs/boxo/ipld/unixfs/importer/helpers"
+	"github.com/ipfs/boxo/ipld/unixfs/importer/trickle"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode/file"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// kuboCid imports content the way Kubo does with the settings of p.
+func kuboCid(t *testing.T, p Profile, content []byte) cid.Cid {
+	spl, err := chunk.FromString(bytes.NewReader(content), p.Chunker)
+	require.NoError(t, err)
+	prefix := dag.V0CidPrefix()
+	if p.CidVersion == 1 {
+		prefix = dag.V1CidPrefix()
+	}
+	params := h.DagBuilderParams{
+		Dagserv:    mdtest.Mock(),
+		RawLeaves:  p.RawLeaves,
+		Maxlinks:   p.LinksPerBlock,
+		CidBuilder: prefix,
+	}
+	db, err := params.New(spl)
+	require.NoError(t, err)
+	layout := balanced.Layout
+	if p.Layout == Trickle {
+		layout = trickle.Layout
+	}
+	nd, err := layout(db)
+	require.NoError(t, err)
+	return nd.Cid()
+}
+
+func checkProfile(t *testing.T, p Profile, content []byte) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	f, sz, err := BuildUnixFSFile(bytes.NewReader(content), p.Chunker, &ls, p.FileOptions()...)
+	require.NoError(t, err)
+	require.Equal(t, kuboCid(t, p, content), f.(cidlink.Link).Cid)
+
+	var totStored int
+	for _, blk := range storage.Bag {
+		totStored += len(blk)
+	}
+	require.Equal(t, totStored, int(sz))
+
+	if f.(cidlink.Link).Cid.Prefix().Codec == cid.Raw {
+		return
+	}
+	fr, err := ls.Load(ipld.LinkContext{}, f, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	ufn, err := file.NewUnixFSFile(context.Background(), fr, &ls)
+	require.NoError(t, err)
+	out, err := ufn.AsBytes()
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(content, out))
+}
+
+func TestProfilesMatchKubo(t *testing.T) {
+	for _, name := range ProfileNames() {
+		p, err := LookupProfile(name)
+		require.NoError(t, err)
+		t.Run(name, func(t *testing.T) {
+			for _, size := range []int{0, 1, 262144, 262145, 3 << 20} {
+				t.Run(fmt.Sprint(size), func(t *testing.T) {
+					checkProfile(t, p, random.Bytes(size))
+				})
+			}
+		})
+	}
+
+	// the deeper parts of each layout, with small enough chunks and nodes to
+	// reach them cheaply
+	for _, layout := range []Layout{Balanced, Trickle} {
+		for _, rawLeaves := range []bool{false, true} {
+			p := Profile{Chunker: "size-64", Layout: layout, CidVersion: 1, RawLeaves: rawLeaves, LinksPerBlock: 3}
+			t.Run(fmt.Sprintf("layout %d raw leaves %t", layout, rawLeaves), func(t *testing.T) {
+				for _, size := range []int{64, 65, 64 * 9, 64*27 + 1, 10000} {
+					checkProfile(t, p, random.Bytes(size))
+				}
+			})
+		}
+	}
+}
+
+func TestBuildUnixFSFileWithProfile(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	// well known CIDs of the empty file as added by Kubo
+	for name, expected := range map[string]string{
+		"unixfs-v1-cidv0":               "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH",
+		"trickle-legacy":                "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH",
+		"balanced-256k-rawleaves-cidv1": "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku",
+	} {
+		f, _, err := BuildUnixFSFileWithProfile(bytes.NewReader(nil), name, &ls)
+		require.NoError(t, err)
+		require.Equal(t, expected, f.(cidlink.Link).Cid.String(), name)
+	}
+
+	_, _, err := BuildUnixFSFileWithProfile(bytes.NewReader(nil), "no-such-profile", &ls)
+	require.ErrorContains(t, err, "no-such-profile")
+	_, _, err = BuildUnixFSFile(bytes.NewReader(nil), "", &ls, WithLinksPerBlock(1))
+	require.Error(t, err)
+}
+
+func TestProfileDirectoryOptions(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	f, sz, err := BuildUnixFSFile(bytes.NewReader([]byte("hello")), "", &ls)
+	require.NoError(t, err)
+	var entries []dagpb.PBLink
+	for i := 0; i < 100; i++ {
+		e, err := BuildUnixFSDirectoryEntry(fmt.Sprintf("file-%d", i), int64(sz), f)
+		require.NoError(t, err)
+		entries = append(entries, e)
+	}
+
+	// a small enough threshold shards with the profile's fanout
+	p := Profile{ShardFanout: 16, ShardingThreshold: 100}
+	dir, _, err := BuildUnixFSDirectory(entries, &ls, p.DirectoryOptions()...)
+	require.NoError(t, err)
+	expected, _, err := BuildUnixFSShardedDirectory(16, multihash.MURMUR3X64_64, entries, &ls)
+	require.NoError(t, err)
+	require.Equal(t, expected, dir)
+
+	// the built-in profiles shard as Kubo does, at 256KiB with a fanout of 256
+	dir, _, err = BuildUnixFSDirectoryWithProfile(entries, "unixfs-v1-cidv0", &ls)
+	require.NoError(t, err)
+	unsharded, _, err := BuildUnixFSDirectory(entries, &ls, WithShardingThreshold(-1))
+	require.NoError(t, err)
+	require.Equal(t, unsharded, dir)
+	dir, _, err = BuildUnixFSDirectoryWithProfile(entries, "unixfs-v1-cidv0", &ls, WithShardingThreshold(100))
+	require.NoError(t, err)
+	expected, _, err = BuildUnixFSShardedDirectory(256, multihash.MURMUR3X64_64, entries, &ls)
+	require.NoError(t, err)
+	require.Equal(t, expected, dir)
+
+	// the built-in profiles can't be changed through a lookup
+	p, err = LookupProfile("unixfs-v1-cidv0")
+	require.NoError(t, err)
+	p.ShardFanout = 8
+	p, err = LookupProfile("unixfs-v1-cidv0")
+	require.NoError(t, err)
+	require.Equal(t, 256, p.ShardFanout)
+}
@@ -0,0 +1,98 @@
+package builder
+
+import (
+	"io"
+	"sync"
+
+	chunk "github.com/ipfs/boxo/chunker"
+)
+
+type chunkResult struct {
+	chunk []byte
+	err   error
+}
+
+// readAheadSplitter reads chunks from a splitter in a separate goroutine,
+// keeping up to maxBytes of chunks that have been read but not yet consumed.
+// A chunk counts against the limit until the following one is requested, by
+// which time the builder has stored it.
+type readAheadSplitter struct {
+	src      chunk.Splitter
+	maxBytes int
+	chunks   chan chunkResult
+	last     int
+
+	lk       sync.Mutex
+	cond     *sync.Cond
+	inFlight int
+	closed   bool
+}
+
+func newReadAheadSplitter(src chunk.Splitter, maxBytes int) *readAheadSplitter {
+	ra := &readAheadSplitter{
+		src:      src,
+		maxBytes: maxBytes,
+		chunks:   make(chan chunkResult, 1),
+	}
+	ra.cond = sync.NewCond(&ra.lk)
+	go ra.run()
+	return ra
+}
+
+func (ra *readAheadSplitter) run() {
+	defer close(ra.chunks)
+	for {
+		c, err := ra.src.NextBytes()
+		ra.lk.Lock()
+		// a single chunk larger than the limit is let through on its own
+		for !ra.closed && ra.inFlight > 0 && ra.inFlight+len(c) > ra.maxBytes {
+			ra.cond.Wait()
+		}
+		if ra.closed {
+			ra.lk.Unlock()
+			return
+		}
+		ra.inFlight += len(c)
+		ra.lk.Unlock()
+		ra.chunks <- chunkResult{c, err}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (ra *readAheadSplitter) release(n int) {
+	ra.lk.Lock()
+	ra.inFlight -= n
+	ra.cond.Signal()
+	ra.lk.Unlock()
+}
+
+func (ra *readAheadSplitter) Reader() io.Reader {
+	return ra.src.Reader()
+}
+
+func (ra *readAheadSplitter) NextBytes() ([]byte, error) {
+	ra.release(ra.last)
+	ra.last = 0
+	res, ok := <-ra.chunks
+	if !ok {
+		return nil, io.EOF
+	}
+	ra.last = len(res.chunk)
+	return res.chunk, res.err
+}
+
+// close stops reading ahead. The goroutine exits once any read in progress
+// returns.
+func (ra *readAheadSplitter) close() {
+	ra.lk.Lock()
+	ra.closed = true
+	ra.cond.Signal()
+	ra.lk.Unlock()
+	// unblock a send of a chunk that will never be consumed
+	go func() {
+		for range ra.chunks {
+		}
+	}()
+}
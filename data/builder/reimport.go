@@ -0,0 +1,310 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/directory"
+	"github.com/ipfs/go-unixfsnode/hamt"
+	"github.com/ipfs/go-unixfsnode/iter"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+type reimportOptions struct {
+	chunker      string
+	contentHash  bool
+	specialFiles SpecialFilePolicy
+}
+
+// ReimportOption configures ReimportUnixFSRecursive.
+type ReimportOption func(*reimportOptions)
+
+// WithReimportChunker sets the chunker files are stored with. It should match
+// the chunker the previous root was built with, or files that are compared
+// by content will never match.
+func WithReimportChunker(chunker string) ReimportOption {
+	return func(o *reimportOptions) {
+		o.chunker = chunker
+	}
+}
+
+// WithContentHash compares files by their content rather than by their
+// modification time, for trees whose mtimes aren't meaningful, such as fresh
+// checkouts. Modification times aren't recorded in this mode, and a file of
+// the same size as before is read and hashed, without being stored, to see
+// whether it has changed.
+func WithContentHash() ReimportOption {
+	return func(o *reimportOptions) {
+		o.contentHash = true
+	}
+}
+
+// WithReimportSpecialFilePolicy sets what ReimportUnixFSRecursive does with
+// special files, as WithSpecialFilePolicy does for BuildUnixFSRecursive.
+func WithReimportSpecialFilePolicy(p SpecialFilePolicy) ReimportOption {
+	return func(o *reimportOptions) {
+		o.specialFiles = p
+	}
+}
+
+type reimporter struct {
+	ls       *ipld.LinkSystem
+	hashOnly *ipld.LinkSystem
+	opts     reimportOptions
+}
+
+// prevEntry is the link an entry had under the previous root, and the Tsize it
+// was linked with.
+type prevEntry struct {
+	link  ipld.Link
+	tsize uint64
+}
+
+// ReimportUnixFSRecursive builds the file or directory tree at root like
+// BuildUnixFSRecursive, reusing the DAGs of files, symlinks and directories
+// which haven't changed since prev was built from the same path. Only the
+// changed parts of the tree are read and stored, and the directories above
+// them rebuilt.
+//
+// Files are taken to be unchanged if they have the same size, mode and
+// modification time as recorded under prev, so modes, as converted by
+// data.ModeFromFileMode, and modification times are recorded in the nodes of
+// the files, directories and symlinks built; a prev built some other way is
+// rebuilt in full, as are entries linked without a Tsize. See WithContentHash
+// for comparing content instead. Links in prev must be loadable through ls.
+// A nil prev imports the whole tree.
+func ReimportUnixFSRecursive(prev ipld.Link, root string, ls *ipld.LinkSystem, opts ...ReimportOption) (ipld.Link, uint64, error) {
+	var o reimportOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	hashOnly := *ls
+	hashOnly.StorageWriteOpener = func(linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		return io.Discard, func(ipld.Link) error { return nil }, nil
+	}
+	ri := &reimporter{ls: ls, hashOnly: &hashOnly, opts: o}
+
+	var pe *prevEntry
+	if prev != nil {
+		size, err := rootTsize(prev, ri.ls)
+		if err != nil {
+			return nil, 0, err
+		}
+		pe = &prevEntry{link: prev, tsize: size}
+	}
+	lnk, size, _, err := ri.reimport(root, pe)
+	return lnk, size, err
+}
+
+// load returns the UnixFS data of a previous entry, if it was a dag-pb UnixFS
+// node. An error is returned only if the entry can't be loaded.
+func (ri *reimporter) load(prev *prevEntry) (dagpb.PBNode, data.UnixFSData, bool, error) {
+	if cl, ok := prevLink(prev); !ok || cl.Cid.Prefix().Codec != cid.DagProtobuf {
+		return nil, nil, false, nil
+	}
+	block, err := ri.ls.LoadRaw(ipld.LinkContext{}, prev.link)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagpb.DecodeBytes(nb, block); err != nil {
+		return nil, nil, false, err
+	}
+	pbn := nb.Build().(dagpb.PBNode)
+	if !pbn.FieldData().Exists() {
+		return nil, nil, false, nil
+	}
+	ufsd, err := data.DecodeUnixFSData(pbn.FieldData().Must().Bytes())
+	if err != nil {
+		return nil, nil, false, nil
+	}
+	return pbn, ufsd, true, nil
+}
+
+func prevLink(prev *prevEntry) (cidlink.Link, bool) {
+	if prev == nil {
+		return cidlink.Link{}, false
+	}
+	cl, ok := prev.link.(cidlink.Link)
+	return cl, ok
+}
+
+func (ri *reimporter) mtime(info fs.FileInfo) time.Time {
+	if ri.opts.contentHash {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// sameMode reports whether ufsd has mode, as converted by
+// data.ModeFromFileMode. A node without a mode has the default for its type.
+func sameMode(ufsd data.UnixFSData, mode int) bool {
+	return ufsd.Permissions() == mode
+}
+
+func sameMtime(ufsd data.UnixFSData, t time.Time) bool {
+	if !ufsd.FieldMtime().Exists() {
+		return t.IsZero()
+	}
+	mt := ufsd.FieldMtime().Must()
+	var nsecs int64
+	if mt.FieldFractionalNanoseconds().Exists() {
+		nsecs = mt.FieldFractionalNanoseconds().Must().Int()
+	}
+	return !t.IsZero() && mt.FieldSeconds().Int() == t.Unix() && nsecs == int64(t.Nanosecond())
+}
+
+// reimport returns the link to the entry at p and its Tsize, and whether it
+// is prev, unchanged.
+func (ri *reimporter) reimport(p string, prev *prevEntry) (ipld.Link, uint64, bool, error) {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	pbn, ufsd, hasPrev, err := ri.load(prev)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	m := info.Mode()
+	mode := data.ModeFromFileMode(m)
+	switch {
+	case m.IsDir():
+		var old map[string]dagpb.PBLink
+		if hasPrev {
+			old, err = ri.entries(pbn, ufsd)
+			if err != nil {
+				return nil, 0, false, err
+			}
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		reused := old != nil && sameMtime(ufsd, ri.mtime(info)) && sameMode(ufsd, mode)
+		lnks := make([]dagpb.PBLink, 0, len(entries))
+		for _, e := range entries {
+			if ri.opts.specialFiles == SkipSpecialFiles && isSpecialFile(e.Type()) {
+				continue
+			}
+			var childPrev *prevEntry
+			// without a Tsize the previous entry can't be linked to as it was,
+			// so it's imported again
+			if ol, ok := old[e.Name()]; ok && ol.FieldTsize().Exists() {
+				childPrev = &prevEntry{link: ol.FieldHash().Link(), tsize: uint64(ol.FieldTsize().Must().Int())}
+			}
+			lnk, sz, same, err := ri.reimport(path.Join(p, e.Name()), childPrev)
+			if err != nil {
+				return nil, 0, false, err
+			}
+			reused = reused && same
+			entry, err := BuildUnixFSDirectoryEntry(e.Name(), int64(sz), lnk)
+			if err != nil {
+				return nil, 0, false, err
+			}
+			lnks = append(lnks, entry)
+		}
+		if reused && len(lnks) == len(old) {
+			return prev.link, prev.tsize, true, nil
+		}
+		lnk, sz, err := BuildUnixFSDirectory(lnks, ri.ls, WithDirectoryMtime(ri.mtime(info)), WithDirectoryMode(mode))
+		return lnk, sz, false, err
+	case m.Type() == fs.ModeSymlink:
+		content, err := os.Readlink(p)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if hasPrev && ufsd.FieldDataType().Int() == data.Data_Symlink &&
+			ufsd.FieldData().Exists() && string(ufsd.FieldData().Must().Bytes()) == content && sameMode(ufsd, mode) {
+			return prev.link, prev.tsize, true, nil
+		}
+		lnk, sz, err := BuildUnixFSSymlink(content, ri.ls, WithSymlinkMode(mode))
+		return lnk, sz, false, err
+	case m.IsRegular():
+		var prevSize int64 = -1
+		if hasPrev && ufsd.FieldDataType().Int() == data.Data_File && ufsd.FieldFileSize().Exists() {
+			prevSize = ufsd.FieldFileSize().Must().Int()
+		} else if cl, ok := prevLink(prev); ok && cl.Cid.Prefix().Codec == cid.Raw {
+			// a raw leaf is its content
+			prevSize = int64(prev.tsize)
+		}
+		if prevSize == info.Size() && !ri.opts.contentHash && hasPrev && sameMtime(ufsd, info.ModTime()) && sameMode(ufsd, mode) {
+			return prev.link, prev.tsize, true, nil
+		}
+
+		fp, err := os.Open(p)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		defer fp.Close()
+		fileOpts := []FileOption{WithMtime(ri.mtime(info)), WithMode(mode)}
+		if prevSize == info.Size() && ri.opts.contentHash {
+			lnk, _, err := BuildUnixFSFile(fp, ri.opts.chunker, ri.hashOnly, fileOpts...)
+			if err != nil {
+				return nil, 0, false, err
+			}
+			if lnk == prev.link {
+				return prev.link, prev.tsize, true, nil
+			}
+			if _, err := fp.Seek(0, io.SeekStart); err != nil {
+				return nil, 0, false, err
+			}
+		}
+		lnk, sz, err := BuildUnixFSFile(fp, ri.opts.chunker, ri.ls, fileOpts...)
+		return lnk, sz, false, err
+	case ri.opts.specialFiles == EmptySpecialFiles:
+		fileOpts := []FileOption{WithMtime(ri.mtime(info)), WithMode(mode)}
+		lnk, sz, err := BuildUnixFSFile(bytes.NewReader(nil), "", ri.hashOnly, fileOpts...)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if hasPrev && lnk == prev.link {
+			return prev.link, prev.tsize, true, nil
+		}
+		lnk, sz, err = BuildUnixFSFile(bytes.NewReader(nil), "", ri.ls, fileOpts...)
+		return lnk, sz, false, err
+	default:
+		return nil, 0, false, ErrSpecialFile{Path: p, Mode: m}
+	}
+}
+
+// entries returns the links of a previous directory by name, or nil if it
+// wasn't a directory.
+func (ri *reimporter) entries(pbn dagpb.PBNode, ufsd data.UnixFSData) (map[string]dagpb.PBLink, error) {
+	var itr *iter.UnixFSDir__RawItr
+	switch ufsd.FieldDataType().Int() {
+	case data.Data_Directory:
+		nd, err := directory.NewUnixFSBasicDir(context.Background(), pbn, ufsd, ri.ls)
+		if err != nil {
+			return nil, err
+		}
+		itr = nd.(directory.UnixFSBasicDir).RawIterator()
+	case data.Data_HAMTShard:
+		nd, err := hamt.NewUnixFSHAMTShard(context.Background(), pbn, ufsd, ri.ls)
+		if err != nil {
+			return nil, err
+		}
+		itr = nd.(hamt.UnixFSHAMTShard).RawIterator()
+	default:
+		return nil, nil
+	}
+	out := make(map[string]dagpb.PBLink)
+	for !itr.Done() {
+		name, lnk, err := itr.Next()
+		if err != nil {
+			return nil, err
+		}
+		out[name] = lnk
+	}
+	return out, nil
+}
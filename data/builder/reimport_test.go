@@ -0,0 +1,172 @@
+package builder
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReimportUnixFSRecursive(t *testing.T) {
+	storage := &cidlink.Memory{}
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageReadOpener = storage.OpenRead
+	var writes int
+	ls.StorageWriteOpener = func(lc linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		writes++
+		return storage.OpenWrite(lc)
+	}
+
+	dir := t.TempDir()
+	write := func(name string, content []byte) {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, content, 0o644))
+	}
+	write("a/1", random.Bytes(1000))
+	write("a/2", random.Bytes(600000))
+	write("b/c/3", random.Bytes(10))
+	write("4", []byte("four"))
+	require.NoError(t, os.Symlink("4", filepath.Join(dir, "link")))
+
+	// reimports must agree with a full import of the tree as it is
+	check := func(prev ipld.Link, opts ...ReimportOption) (ipld.Link, int) {
+		writes = 0
+		root, size, err := ReimportUnixFSRecursive(prev, dir, &ls, opts...)
+		require.NoError(t, err)
+		n := writes
+		full, fullSize, err := ReimportUnixFSRecursive(nil, dir, &ls, opts...)
+		require.NoError(t, err)
+		require.Equal(t, full, root)
+		require.Equal(t, fullSize, size)
+		return root, n
+	}
+
+	root1, _ := check(nil)
+	root2, n := check(root1)
+	require.Equal(t, root1, root2)
+	require.Zero(t, n)
+
+	// a changed file is rebuilt along with the directories above it
+	write("b/c/3", random.Bytes(20))
+	root3, n := check(root2)
+	require.NotEqual(t, root2, root3)
+	require.Equal(t, 5, n, "leaf and File node, b/c, b and the root")
+
+	// touching a file changes its mtime, so it's rebuilt, unless content is
+	// compared instead
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "a", "1"), later, later))
+	root4, n := check(root3)
+	require.NotEqual(t, root3, root4)
+	require.Equal(t, 4, n, "leaf and File node, a and the root")
+
+	byContent, _ := check(nil, WithContentHash())
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "a", "1"), later, later.Add(time.Minute)))
+	byContent2, n := check(byContent, WithContentHash())
+	require.Equal(t, byContent, byContent2)
+	require.Zero(t, n)
+	write("a/1", random.Bytes(1000))
+	byContent3, n := check(byContent2, WithContentHash())
+	require.NotEqual(t, byContent2, byContent3)
+	require.Equal(t, 4, n, "leaf and File node, a and the root")
+
+	// a changed mode is recorded, though the mtime is the same
+	synced, _ := check(root4)
+	require.NoError(t, os.Chmod(filepath.Join(dir, "4"), 0o755))
+	withMode, n := check(synced)
+	require.NotEqual(t, synced, withMode)
+	require.Equal(t, 3, n, "leaf and File node, and the root")
+
+	// added, removed and retargeted entries
+	write("b/new", []byte("new"))
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "a")))
+	require.NoError(t, os.Remove(filepath.Join(dir, "link")))
+	require.NoError(t, os.Symlink("b", filepath.Join(dir, "link")))
+	root5, _ := check(withMode)
+	require.NotEqual(t, withMode, root5)
+
+	// a LinkSystem that reifies UnixFS nodes reads the previous tree the same
+	rls := ls
+	rls.NodeReifier = unixfsnode.Reify
+	writes = 0
+	again, _, err := ReimportUnixFSRecursive(root5, dir, &rls)
+	require.NoError(t, err)
+	require.Equal(t, root5, again)
+	require.Zero(t, writes)
+
+	// the previous root must be available
+	empty := cidlink.DefaultLinkSystem()
+	empty.StorageReadOpener = (&cidlink.Memory{}).OpenRead
+	_, _, err = ReimportUnixFSRecursive(root5, dir, &empty)
+	require.Error(t, err)
+}
+
+func TestReimportUnixFSRecursiveMissingTsize(t *testing.T) {
+	storage := &cidlink.Memory{}
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), random.Bytes(100), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b"), random.Bytes(100), 0o644))
+	root, _, err := ReimportUnixFSRecursive(nil, dir, &ls)
+	require.NoError(t, err)
+
+	// the same directory, with its links stripped of their Tsize
+	nd, err := ls.Load(ipld.LinkContext{}, root, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	pbn := nd.(dagpb.PBNode)
+	stripped, err := qp.BuildMap(dagpb.Type.PBNode, -1, func(ma ipld.MapAssembler) {
+		qp.MapEntry(ma, "Data", qp.Bytes(pbn.FieldData().Must().Bytes()))
+		qp.MapEntry(ma, "Links", qp.List(-1, func(la ipld.ListAssembler) {
+			links := pbn.FieldLinks().Iterator()
+			for !links.Done() {
+				_, l := links.Next()
+				qp.ListEntry(la, qp.Map(-1, func(ma ipld.MapAssembler) {
+					qp.MapEntry(ma, "Hash", qp.Link(l.FieldHash().Link()))
+					qp.MapEntry(ma, "Name", qp.String(l.FieldName().Must().String()))
+				}))
+			}
+		}))
+	})
+	require.NoError(t, err)
+	prev, err := ls.Store(ipld.LinkContext{}, fileLinkProto, stripped)
+	require.NoError(t, err)
+
+	reimported, _, err := ReimportUnixFSRecursive(prev, dir, &ls)
+	require.NoError(t, err)
+	require.Equal(t, root, reimported)
+}
+
+func TestReimportUnixFSRecursiveMissingBlock(t *testing.T) {
+	storage := &cidlink.Memory{}
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "a"), random.Bytes(100), 0o644))
+	root, _, err := ReimportUnixFSRecursive(nil, dir, &ls)
+	require.NoError(t, err)
+
+	// a block of prev that can't be loaded is an error, not a rebuild
+	nd, err := ls.Load(ipld.LinkContext{}, root, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	sub := nd.(dagpb.PBNode).FieldLinks().Lookup(0).FieldHash().Link().(cidlink.Link)
+	delete(storage.Bag, string(sub.Cid.Hash()))
+	_, _, err = ReimportUnixFSRecursive(root, dir, &ls)
+	require.Error(t, err)
+}
@@ -0,0 +1,48 @@
+package builder
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+// SpecialFilePolicy decides what BuildUnixFSRecursive does with files that
+// are neither regular files, directories nor symlinks, such as sockets, FIFOs
+// and device nodes, which UnixFS has no way to represent.
+type SpecialFilePolicy int
+
+const (
+	// RejectSpecialFiles fails the build with ErrSpecialFile. This is the
+	// default.
+	RejectSpecialFiles SpecialFilePolicy = iota
+	// SkipSpecialFiles leaves special files out of their directory. A special
+	// file given as the root is still rejected.
+	SkipSpecialFiles
+	// EmptySpecialFiles records special files as empty files, without reading
+	// from them.
+	EmptySpecialFiles
+)
+
+// ErrSpecialFile is returned when BuildUnixFSRecursive meets a special file
+// under RejectSpecialFiles.
+type ErrSpecialFile struct {
+	Path string
+	Mode fs.FileMode
+}
+
+func (e ErrSpecialFile) Error() string {
+	return fmt.Sprintf("cannot encode %s file: %s", e.Mode.Type(), e.Path)
+}
+
+// WithSpecialFilePolicy sets what BuildUnixFSRecursive does with special
+// files.
+func WithSpecialFilePolicy(p SpecialFilePolicy) DirectoryOption {
+	return func(o *directoryOptions) {
+		o.specialFiles = p
+	}
+}
+
+// isSpecialFile reports whether m is the mode of a file UnixFS can't
+// represent.
+func isSpecialFile(m fs.FileMode) bool {
+	return !m.IsDir() && !m.IsRegular() && m.Type() != fs.ModeSymlink
+}
@@ -0,0 +1,90 @@
+//go:build !windows
+
+package builder
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildUnixFSRecursiveSpecialFiles(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	withFifo := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(withFifo, "a"), []byte("aaa"), 0o644))
+	fifo := filepath.Join(withFifo, "fifo")
+	require.NoError(t, syscall.Mkfifo(fifo, 0o644))
+
+	withoutFifo := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(withoutFifo, "a"), []byte("aaa"), 0o644))
+
+	withEmpty := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(withEmpty, "a"), []byte("aaa"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(withEmpty, "fifo"), nil, 0o644))
+
+	_, _, err := BuildUnixFSRecursive(withFifo, &ls)
+	var special ErrSpecialFile
+	require.ErrorAs(t, err, &special)
+	require.Equal(t, fifo, special.Path)
+	require.Equal(t, fs.ModeNamedPipe, special.Mode.Type())
+
+	lnk, _, err := BuildUnixFSRecursive(withFifo, &ls, WithSpecialFilePolicy(SkipSpecialFiles))
+	require.NoError(t, err)
+	expected, _, err := BuildUnixFSRecursive(withoutFifo, &ls)
+	require.NoError(t, err)
+	require.Equal(t, expected, lnk)
+
+	lnk, _, err = BuildUnixFSRecursive(withFifo, &ls, WithSpecialFilePolicy(EmptySpecialFiles))
+	require.NoError(t, err)
+	expected, _, err = BuildUnixFSRecursive(withEmpty, &ls)
+	require.NoError(t, err)
+	require.Equal(t, expected, lnk)
+
+	// a special root can't be skipped
+	_, _, err = BuildUnixFSRecursive(fifo, &ls, WithSpecialFilePolicy(SkipSpecialFiles))
+	require.ErrorAs(t, err, &special)
+}
+
+func TestReimportUnixFSRecursiveSpecialFiles(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), []byte("aaa"), 0o644))
+	fifo := filepath.Join(dir, "fifo")
+	require.NoError(t, syscall.Mkfifo(fifo, 0o644))
+
+	_, _, err := ReimportUnixFSRecursive(nil, dir, &ls)
+	var special ErrSpecialFile
+	require.ErrorAs(t, err, &special)
+	require.Equal(t, fifo, special.Path)
+
+	for _, policy := range []SpecialFilePolicy{SkipSpecialFiles, EmptySpecialFiles} {
+		root, _, err := ReimportUnixFSRecursive(nil, dir, &ls, WithReimportSpecialFilePolicy(policy))
+		require.NoError(t, err)
+		again, _, err := ReimportUnixFSRecursive(root, dir, &ls, WithReimportSpecialFilePolicy(policy))
+		require.NoError(t, err)
+		require.Equal(t, root, again)
+
+		nd, err := ls.Load(ipld.LinkContext{}, root, dagpb.Type.PBNode)
+		require.NoError(t, err)
+		entries := 2
+		if policy == SkipSpecialFiles {
+			entries = 1
+		}
+		require.Equal(t, int64(entries), nd.(dagpb.PBNode).FieldLinks().Length())
+	}
+}
@@ -0,0 +1,159 @@
+package builder
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// SplitFile splits the file stored under root at offset into two files, the
+// bytes before offset and the bytes from it on, and returns the roots of
+// both. Subtrees of the file that fall wholly on one side of offset are
+// linked as they are; only the nodes on the path down to the leaf holding
+// offset are loaded, and only that leaf is stored again, as two leaves. An
+// offset of 0 or the size of the file gives an empty file on one side.
+//
+// The new nodes of each file are arranged as BuildUnixFSFileFromLeaves
+// arranges leaves, and the same options apply; the new leaves are stored with
+// the leaf link prototype. Files with leaves stored by a LeafEncoder can only
+// be split at the boundaries of their leaves.
+func SplitFile(root ipld.Link, offset uint64, ls *ipld.LinkSystem, opts ...FileOption) (ipld.Link, ipld.Link, error) {
+	o := &fileOptions{leafProto: DefaultLeafLinkPrototype}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if codec := o.leafProto.Prefix.Codec; codec != cid.Raw && codec != cid.DagProtobuf {
+		return nil, nil, fmt.Errorf("leaves must be raw or dag-pb, not codec 0x%x", codec)
+	}
+	meta, err := fileRootMeta(root, ls)
+	if err != nil {
+		return nil, nil, err
+	}
+	if offset > meta.Size {
+		return nil, nil, fmt.Errorf("offset %d is beyond the end of the file, of %d bytes", offset, meta.Size)
+	}
+	s := &fileSplitter{ls: ls, leafProto: o.leafProto}
+	if err := s.place(meta, offset); err != nil {
+		return nil, nil, err
+	}
+	head, _, err := BuildUnixFSFileFromLeaves(s.head, ls, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	tail, _, err := BuildUnixFSFileFromLeaves(s.tail, ls, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return head, tail, nil
+}
+
+// fileSplitter collects the leaves of the two halves of a file being split.
+type fileSplitter struct {
+	ls         *ipld.LinkSystem
+	leafProto  cidlink.LinkPrototype
+	head, tail []LeafMeta
+}
+
+// place adds a subtree of the file to the side of offset it falls on, offset
+// being relative to the start of the subtree, splitting it if it straddles
+// offset.
+func (s *fileSplitter) place(meta LeafMeta, offset uint64) error {
+	switch {
+	case meta.Size == 0:
+		return nil
+	case offset == 0:
+		s.tail = append(s.tail, meta)
+		return nil
+	case offset >= meta.Size:
+		s.head = append(s.head, meta)
+		return nil
+	}
+	return s.split(meta, offset)
+}
+
+// placeBytes stores content that isn't a block of its own, or is a leaf being
+// split, as new leaves on either side of offset.
+func (s *fileSplitter) placeBytes(content []byte, offset uint64) error {
+	offset = min(offset, uint64(len(content)))
+	for _, part := range []struct {
+		bytes []byte
+		side  *[]LeafMeta
+	}{{content[:offset], &s.head}, {content[offset:], &s.tail}} {
+		if len(part.bytes) == 0 {
+			continue
+		}
+		leaf, err := storePlainLeaf(s.ls, s.leafProto, data.Data_File, part.bytes, nil, time.Time{})
+		if err != nil {
+			return err
+		}
+		*part.side = append(*part.side, LeafMeta{Link: leaf.link, Size: leaf.byteSize, StoredSize: leaf.storedSize})
+	}
+	return nil
+}
+
+// split loads a subtree that straddles offset and places its children.
+func (s *fileSplitter) split(meta LeafMeta, offset uint64) error {
+	block, err := s.ls.LoadRaw(ipld.LinkContext{}, meta.Link)
+	if err != nil {
+		return err
+	}
+	switch codec := meta.Link.(cidlink.Link).Cid.Prefix().Codec; codec {
+	case cid.Raw:
+		return s.placeBytes(block, offset)
+	case cid.DagProtobuf:
+	default:
+		return fmt.Errorf("can't split within a leaf of codec 0x%x", codec)
+	}
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagpb.DecodeBytes(nb, block); err != nil {
+		return err
+	}
+	pbn := nb.Build().(dagpb.PBNode)
+	if !pbn.FieldData().Exists() {
+		return fmt.Errorf("%s: not a unixfs node", meta.Link)
+	}
+	ufsd, err := data.DecodeUnixFSData(pbn.FieldData().Must().Bytes())
+	if err != nil {
+		return err
+	}
+	if dataType := ufsd.FieldDataType().Int(); dataType != data.Data_File && dataType != data.Data_Raw {
+		return fmt.Errorf("%s: not a file, but a %s", meta.Link, data.DataTypeNames[dataType])
+	}
+
+	// content held in the node itself comes before that of its children
+	var pos uint64
+	if ufsd.FieldData().Exists() {
+		content := ufsd.FieldData().Must().Bytes()
+		if err := s.placeBytes(content, offset); err != nil {
+			return err
+		}
+		pos = uint64(len(content))
+	}
+	if pbn.FieldLinks().Length() != ufsd.FieldBlockSizes().Length() {
+		return fmt.Errorf("%s: %d links but %d block sizes", meta.Link, pbn.FieldLinks().Length(), ufsd.FieldBlockSizes().Length())
+	}
+	links := pbn.FieldLinks().Iterator()
+	sizes := ufsd.FieldBlockSizes().Iterator()
+	for !links.Done() {
+		_, lnk := links.Next()
+		_, bs := sizes.Next()
+		child := LeafMeta{Link: lnk.FieldHash().Link(), Size: uint64(bs.Int())}
+		if lnk.FieldTsize().Exists() {
+			child.StoredSize = uint64(lnk.FieldTsize().Must().Int())
+		}
+		var childOffset uint64
+		if offset > pos {
+			childOffset = offset - pos
+		}
+		if err := s.place(child, childOffset); err != nil {
+			return err
+		}
+		pos += child.Size
+	}
+	return nil
+}
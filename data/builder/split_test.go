@@ -0,0 +1,66 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode/file"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitFile(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	readFile := func(root ipld.Link) []byte {
+		if root.(cidlink.Link).Cid.Prefix().Codec == cid.Raw {
+			out, err := ls.LoadRaw(ipld.LinkContext{}, root)
+			require.NoError(t, err)
+			return out
+		}
+		pbn, err := ls.Load(ipld.LinkContext{}, root, dagpb.Type.PBNode)
+		require.NoError(t, err)
+		ufn, err := file.NewUnixFSFile(context.Background(), pbn, &ls)
+		require.NoError(t, err)
+		out, err := ufn.AsBytes()
+		require.NoError(t, err)
+		return out
+	}
+
+	content := random.Bytes(50 * 1024)
+	pbLeaves := WithLeafLinkPrototype(DefaultInteriorLinkPrototype)
+	for _, opts := range [][]FileOption{{WithLinksPerBlock(4)}, {WithLinksPerBlock(4), pbLeaves}} {
+		root, _, err := BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls, opts...)
+		require.NoError(t, err)
+		for _, offset := range []int{0, 1, 1024, 5000, 16 * 1024, 50*1024 - 1, 50 * 1024} {
+			blocks := len(storage.Bag)
+			head, tail, err := SplitFile(root, uint64(offset), &ls, opts...)
+			require.NoError(t, err)
+			require.True(t, bytes.Equal(content[:offset], readFile(head)), offset)
+			require.True(t, bytes.Equal(content[offset:], readFile(tail)), offset)
+			// at most two new leaves and the nodes above them, three levels
+			// deep on each side
+			require.LessOrEqual(t, len(storage.Bag)-blocks, 8, offset)
+		}
+	}
+
+	// splitting a single leaf
+	small := random.Bytes(100)
+	root, _, err := BuildUnixFSFile(bytes.NewReader(small), "size-1024", &ls)
+	require.NoError(t, err)
+	head, tail, err := SplitFile(root, 40, &ls)
+	require.NoError(t, err)
+	require.Equal(t, small[:40], readFile(head))
+	require.Equal(t, small[40:], readFile(tail))
+
+	_, _, err = SplitFile(root, 101, &ls)
+	require.ErrorContains(t, err, "beyond the end of the file")
+}
@@ -0,0 +1,59 @@
+package builder
+
+import (
+	"errors"
+	"io"
+
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+)
+
+// EntryIterator yields the entries of a directory one at a time, for
+// BuildUnixFSDirectoryFromIterator. Next returns io.EOF after the last entry;
+// any other error stops the build and is returned from it.
+type EntryIterator interface {
+	Next() (dagpb.PBLink, error)
+}
+
+// EntryIteratorFunc adapts a function to an EntryIterator.
+type EntryIteratorFunc func() (dagpb.PBLink, error)
+
+func (f EntryIteratorFunc) Next() (dagpb.PBLink, error) {
+	return f()
+}
+
+// EntriesFromChannel returns an EntryIterator over the entries received from
+// ch, ending when ch is closed.
+func EntriesFromChannel(ch <-chan dagpb.PBLink) EntryIterator {
+	return EntryIteratorFunc(func() (dagpb.PBLink, error) {
+		e, ok := <-ch
+		if !ok {
+			return nil, io.EOF
+		}
+		return e, nil
+	})
+}
+
+// BuildUnixFSDirectoryFromIterator builds a directory as BuildUnixFSDirectory
+// does, from entries read from an iterator, such as a database cursor or a
+// directory scan, rather than a slice. The entries are added to a
+// DirAssembler as they are read, so they are never gathered into a slice once
+// the directory is sharded, and entries of the same name are rejected with
+// ErrDuplicateName unless WithDuplicateNames is given. Every entry must have
+// a name.
+func BuildUnixFSDirectoryFromIterator(entries EntryIterator, ls *ipld.LinkSystem, opts ...DirectoryOption) (ipld.Link, uint64, error) {
+	da := NewDirAssembler(ls, opts...)
+	for {
+		e, err := entries.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := da.add(e); err != nil {
+			return nil, 0, err
+		}
+	}
+	return da.Build()
+}
@@ -0,0 +1,96 @@
+package builder
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	dagpb "github.com/ipld/go-codec-dagpb"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildUnixFSDirectoryFromIterator(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	for _, count := range []int{0, 10, 7000} {
+		entries, err := mkEntries(count, &ls)
+		require.NoError(t, err)
+		expected, expectedSize, err := BuildUnixFSDirectory(entries, &ls)
+		require.NoError(t, err)
+
+		ch := make(chan dagpb.PBLink)
+		go func() {
+			defer close(ch)
+			for _, e := range entries {
+				ch <- e
+			}
+		}()
+		lnk, size, err := BuildUnixFSDirectoryFromIterator(EntriesFromChannel(ch), &ls)
+		require.NoError(t, err)
+		require.Equal(t, expected, lnk, "%d entries", count)
+		require.Equal(t, expectedSize, size)
+	}
+
+	// a low threshold shards the directory part way through
+	entries, err := mkEntries(100, &ls)
+	require.NoError(t, err)
+	expected, _, err := BuildUnixFSDirectory(entries, &ls, WithShardingEntryCount(50))
+	require.NoError(t, err)
+	i := 0
+	lnk, _, err := BuildUnixFSDirectoryFromIterator(EntryIteratorFunc(func() (dagpb.PBLink, error) {
+		if i == len(entries) {
+			return nil, io.EOF
+		}
+		i++
+		return entries[i-1], nil
+	}), &ls, WithShardingEntryCount(50))
+	require.NoError(t, err)
+	require.Equal(t, expected, lnk)
+
+	failed := errors.New("scan failed")
+	_, _, err = BuildUnixFSDirectoryFromIterator(EntryIteratorFunc(func() (dagpb.PBLink, error) {
+		return nil, failed
+	}), &ls)
+	require.ErrorIs(t, err, failed)
+}
+
+func TestBuildUnixFSDirectoryFromIteratorInvalidEntries(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	entries, err := mkEntries(100, &ls)
+	require.NoError(t, err)
+	from := func(entries []dagpb.PBLink) EntryIterator {
+		return EntryIteratorFunc(func() (dagpb.PBLink, error) {
+			if len(entries) == 0 {
+				return nil, io.EOF
+			}
+			e := entries[0]
+			entries = entries[1:]
+			return e, nil
+		})
+	}
+
+	// duplicates are rejected before and after the directory is sharded
+	for _, at := range []int{10, 90} {
+		withDup := append(entries[:at:at], entries[0])
+		withDup = append(withDup, entries[at:]...)
+		_, _, err = BuildUnixFSDirectoryFromIterator(from(withDup), &ls, WithShardingEntryCount(50))
+		require.ErrorAs(t, err, new(ErrDuplicateName), "duplicate at %d", at)
+	}
+
+	nb := dagpb.Type.PBLink.NewBuilder()
+	ma, err := nb.BeginMap(1)
+	require.NoError(t, err)
+	require.NoError(t, ma.AssembleKey().AssignString("Hash"))
+	require.NoError(t, ma.AssembleValue().AssignLink(entries[0].Hash.Link()))
+	require.NoError(t, ma.Finish())
+	_, _, err = BuildUnixFSDirectoryFromIterator(from([]dagpb.PBLink{entries[0], nb.Build().(dagpb.PBLink)}), &ls)
+	require.Error(t, err)
+}
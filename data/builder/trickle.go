@@ -0,0 +1,89 @@
+package builder
+
+import (
+	"io"
+
+	"github.com/ipld/go-ipld-prime"
+)
+
+// trickleDepthRepeat is the number of subtrees of each depth added to a
+// trickle node once its own leaves are filled.
+// ref: https://github.com/ipfs/boxo/blob/v0.24.0/ipld/unixfs/importer/trickle/trickledag.go
+const trickleDepthRepeat = 4
+
+type trickleBuilder struct {
+	src *peekSplitter
+	ls  *ipld.LinkSystem
+	o   *fileOptions
+}
+
+// buildTrickle stores a file in the trickle layout. Unlike the balanced
+// layout, the root is always a File node, even for empty files or those that
+// fit in a single leaf.
+func buildTrickle(src *peekSplitter, ls *ipld.LinkSystem, o *fileOptions) (ipld.Link, uint64, error) {
+	tb := &trickleBuilder{src: src, ls: ls, o: o}
+	root, err := tb.fill(-1)
+	if err != nil {
+		return nil, 0, err
+	}
+	return root.link, root.storedSize, nil
+}
+
+// fill stores a node with up to the links per block leaves, followed by
+// trickleDepthRepeat subtrees of each depth from 1 up to, but not including,
+// maxDepth, or without limit if maxDepth is -1.
+func (tb *trickleBuilder) fill(maxDepth int) (fileShardMeta, error) {
+	var children fileShards
+	for len(children) < tb.o.linksPerBlock {
+		chunk, err := tb.src.NextBytes()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fileShardMeta{}, err
+		}
+		leaf, err := storeLeaf(tb.ls, tb.o, chunk, false)
+		if err != nil {
+			return fileShardMeta{}, err
+		}
+		children = append(children, leaf)
+	}
+
+subtrees:
+	for depth := 1; maxDepth == -1 || depth < maxDepth; depth++ {
+		for i := 0; i < trickleDepthRepeat; i++ {
+			if !tb.src.more() {
+				break subtrees
+			}
+			child, err := tb.fill(depth)
+			if err != nil {
+				return fileShardMeta{}, err
+			}
+			children = append(children, child)
+		}
+	}
+
+	node, err := BuildUnixFS(func(b *Builder) {
+		FileSize(b, children.totalByteSize())
+		BlockSizes(b, children.byteSizes())
+		if maxDepth == -1 {
+			optionalMode(b, tb.o.mode)
+			optionalMtime(b, tb.o.mtime)
+		}
+	})
+	if err != nil {
+		return fileShardMeta{}, err
+	}
+	pbn, err := packFileChildren(node, children)
+	if err != nil {
+		return fileShardMeta{}, err
+	}
+	link, sz, err := sizedStore(tb.ls, tb.o.interiorProto, pbn)
+	if err != nil {
+		return fileShardMeta{}, err
+	}
+	return fileShardMeta{
+		link:       link,
+		byteSize:   children.totalByteSize(),
+		storedSize: children.totalStoredSize() + sz,
+	}, nil
+}
@@ -1,13 +1,16 @@
 package builder
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"math/bits"
+	"sync"
 
+	chunk "github.com/ipfs/boxo/chunker"
 	"github.com/ipld/go-ipld-prime"
-	"github.com/ipld/go-ipld-prime/codec"
 	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
 )
 
 // Common code from go-unixfs/hamt/util.go
@@ -60,50 +63,81 @@ func logtwo(v int) (int, error) {
 	return lg2, nil
 }
 
+// encodeBuffers holds the buffers blocks are encoded into before they are
+// hashed and written, so that large imports don't allocate one per block.
+var encodeBuffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// sizedStore stores a node like ls.Store, also returning the size of the
+// encoded block.
 func sizedStore(ls *ipld.LinkSystem, lp datamodel.LinkPrototype, n datamodel.Node) (datamodel.Link, uint64, error) {
-	var byteCount int
-	lnk, err := wrappedLinkSystem(ls, func(bc int) {
-		byteCount = bc
-	}).Store(ipld.LinkContext{}, lp, n)
-	return lnk, uint64(byteCount), err
+	encoder, err := ls.EncoderChooser(lp)
+	if err != nil {
+		return nil, 0, linking.ErrLinkingSetup{Detail: "could not choose an encoder", Cause: err}
+	}
+	buf := encodeBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		encodeBuffers.Put(buf)
+	}()
+	if err := encoder(n, buf); err != nil {
+		return nil, 0, err
+	}
+	lnk, err := storeBlock(ls, lp, buf.Bytes())
+	if err != nil {
+		return nil, 0, err
+	}
+	return lnk, uint64(buf.Len()), nil
 }
 
-type byteCounter struct {
-	w  io.Writer
-	bc int
+// storeBlock hashes and stores an already encoded block.
+func storeBlock(ls *ipld.LinkSystem, lp datamodel.LinkPrototype, block []byte) (datamodel.Link, error) {
+	hasher, err := ls.HasherChooser(lp)
+	if err != nil {
+		return nil, linking.ErrLinkingSetup{Detail: "could not choose a hasher", Cause: err}
+	}
+	if ls.StorageWriteOpener == nil {
+		return nil, linking.ErrLinkingSetup{Detail: "no storage configured for writing", Cause: io.ErrClosedPipe}
+	}
+	w, commit, err := ls.StorageWriteOpener(ipld.LinkContext{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := hasher.Write(block); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(block); err != nil {
+		return nil, err
+	}
+	lnk := lp.BuildLink(hasher.Sum(nil))
+	return lnk, commit(lnk)
 }
 
-func (bc *byteCounter) Write(p []byte) (int, error) {
-	bc.bc += len(p)
-	return bc.w.Write(p)
+// peekSplitter reads ahead by one chunk so it can tell whether the splitter it
+// wraps has more to return.
+type peekSplitter struct {
+	chunk.Splitter
+	next   []byte
+	err    error
+	peeked bool
 }
 
-func wrappedLinkSystem(ls *ipld.LinkSystem, byteCountCb func(byteCount int)) *ipld.LinkSystem {
-	wrappedEncoder := func(encoder codec.Encoder) codec.Encoder {
-		return func(node datamodel.Node, writer io.Writer) error {
-			bc := byteCounter{w: writer}
-			err := encoder(node, &bc)
-			if err == nil {
-				byteCountCb(bc.bc)
-			}
-			return err
-		}
+// more reports whether NextBytes will return a chunk, or an error other than
+// io.EOF.
+func (ps *peekSplitter) more() bool {
+	if !ps.peeked {
+		ps.next, ps.err = ps.Splitter.NextBytes()
+		ps.peeked = true
 	}
-	wrappedEncoderChooser := func(lp datamodel.LinkPrototype) (codec.Encoder, error) {
-		encoder, err := ls.EncoderChooser(lp)
-		if err != nil {
-			return nil, err
-		}
-		return wrappedEncoder(encoder), nil
-	}
-	return &ipld.LinkSystem{
-		EncoderChooser:     wrappedEncoderChooser,
-		DecoderChooser:     ls.DecoderChooser,
-		HasherChooser:      ls.HasherChooser,
-		StorageWriteOpener: ls.StorageWriteOpener,
-		StorageReadOpener:  ls.StorageReadOpener,
-		TrustedStorage:     ls.TrustedStorage,
-		NodeReifier:        ls.NodeReifier,
-		KnownReifiers:      ls.KnownReifiers,
+	return ps.err != io.EOF
+}
+
+func (ps *peekSplitter) NextBytes() ([]byte, error) {
+	if !ps.peeked {
+		return ps.Splitter.NextBytes()
 	}
+	next, err := ps.next, ps.err
+	ps.next, ps.err, ps.peeked = nil, nil, false
+	return next, err
 }
@@ -0,0 +1,172 @@
+package builder
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+)
+
+// zipDir is a directory being assembled from the entries of a zip archive.
+type zipDir struct {
+	mode  *int
+	mtime time.Time
+	// listed is set once the directory has its own entry in the archive
+	listed bool
+	// files holds the files and symlinks of the directory, which are stored
+	// as they are read from the archive
+	files map[string]dagpb.PBLink
+	dirs  map[string]*zipDir
+}
+
+func newZipDir() *zipDir {
+	return &zipDir{files: make(map[string]dagpb.PBLink), dirs: make(map[string]*zipDir)}
+}
+
+// BuildUnixFSFromZip builds the tree of files and directories in a zip archive
+// as a UnixFS directory, returning a link to it and its total stored size.
+//
+// Paths are kept as they are in the archive. The modes of files, directories
+// and symlinks, converted by data.ModeFromFileMode, are recorded in their root
+// nodes, as are the modification times of files and directories. Directories
+// that aren't listed in the archive, but which hold entries that are, are
+// created without either. Files are streamed out of the archive one at a time and stored
+// with BuildUnixFSFile, using chunker and opts; symlinks are stored as UnixFS
+// symlinks. Directories are sharded as BuildUnixFSDirectory decides.
+//
+// Archives with entries whose paths are absolute or leave the archive, which
+// appear more than once, or which are neither regular files, directories nor
+// symlinks, are rejected.
+func BuildUnixFSFromZip(zr *zip.Reader, chunker string, ls *ipld.LinkSystem, opts ...FileOption) (ipld.Link, uint64, error) {
+	root := newZipDir()
+	for _, f := range zr.File {
+		if err := addZipEntry(root, f, chunker, ls, opts); err != nil {
+			return nil, 0, fmt.Errorf("zip entry %q: %w", f.Name, err)
+		}
+	}
+	return buildZipDir(root, ls)
+}
+
+func addZipEntry(root *zipDir, f *zip.File, chunker string, ls *ipld.LinkSystem, opts []FileOption) error {
+	mode := f.Mode()
+	name := strings.TrimSuffix(f.Name, "/")
+	if !fs.ValidPath(name) {
+		return fmt.Errorf("invalid path")
+	}
+	var parts []string
+	if name != "." {
+		parts = strings.Split(name, "/")
+	}
+
+	if mode.IsDir() {
+		d, err := zipMkdirAll(root, parts)
+		if err != nil {
+			return err
+		}
+		if d.listed {
+			return fmt.Errorf("duplicate entry")
+		}
+		dirMode := data.ModeFromFileMode(mode)
+		d.mode, d.mtime, d.listed = &dirMode, f.Modified, true
+		return nil
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("invalid path")
+	}
+	parent, err := zipMkdirAll(root, parts[:len(parts)-1])
+	if err != nil {
+		return err
+	}
+	base := parts[len(parts)-1]
+	if _, ok := parent.files[base]; ok {
+		return fmt.Errorf("duplicate entry")
+	} else if _, ok := parent.dirs[base]; ok {
+		return fmt.Errorf("already a directory")
+	}
+
+	var lnk ipld.Link
+	var size uint64
+	switch {
+	case mode.IsRegular():
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		fileOpts := append(opts[:len(opts):len(opts)], WithMtime(f.Modified), WithMode(data.ModeFromFileMode(mode)))
+		lnk, size, err = BuildUnixFSFile(rc, chunker, ls, fileOpts...)
+		if err != nil {
+			return err
+		}
+	case mode.Type() == fs.ModeSymlink:
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		target, err := io.ReadAll(rc)
+		if err != nil {
+			return err
+		}
+		lnk, size, err = BuildUnixFSSymlink(string(target), ls, WithSymlinkMode(data.ModeFromFileMode(mode)))
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("cannot encode %s file", mode.Type())
+	}
+
+	entry, err := BuildUnixFSDirectoryEntry(base, int64(size), lnk)
+	if err != nil {
+		return err
+	}
+	parent.files[base] = entry
+	return nil
+}
+
+// zipMkdirAll returns the directory at path, creating it and its parents as
+// needed.
+func zipMkdirAll(root *zipDir, path []string) (*zipDir, error) {
+	d := root
+	for _, name := range path {
+		if _, ok := d.files[name]; ok {
+			return nil, fmt.Errorf("%q is not a directory", name)
+		}
+		next, ok := d.dirs[name]
+		if !ok {
+			next = newZipDir()
+			d.dirs[name] = next
+		}
+		d = next
+	}
+	return d, nil
+}
+
+func buildZipDir(d *zipDir, ls *ipld.LinkSystem) (ipld.Link, uint64, error) {
+	entries := make([]dagpb.PBLink, 0, len(d.files)+len(d.dirs))
+	for _, e := range d.files {
+		entries = append(entries, e)
+	}
+	for name, sub := range d.dirs {
+		lnk, size, err := buildZipDir(sub, ls)
+		if err != nil {
+			return nil, 0, err
+		}
+		entry, err := BuildUnixFSDirectoryEntry(name, int64(size), lnk)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	dirOpts := []DirectoryOption{WithDirectoryMtime(d.mtime)}
+	if d.mode != nil {
+		dirOpts = append(dirOpts, WithDirectoryMode(*d.mode))
+	}
+	return BuildUnixFSDirectory(entries, ls, dirOpts...)
+}
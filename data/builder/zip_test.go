@@ -0,0 +1,156 @@
+package builder
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+type zipTestEntry struct {
+	name    string
+	content []byte
+	mode    fs.FileMode
+	mtime   time.Time
+}
+
+func mkZip(t *testing.T, entries []zipTestEntry) *zip.Reader {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate, Modified: e.mtime}
+		if e.mode != 0 {
+			hdr.SetMode(e.mode)
+		}
+		w, err := zw.CreateHeader(hdr)
+		require.NoError(t, err)
+		_, err = w.Write(e.content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	return zr
+}
+
+// zipLookup resolves path from root, returning the dag-pb node there.
+func zipLookup(t *testing.T, ls *ipld.LinkSystem, root ipld.Link, path ...string) dagpb.PBNode {
+	lnk := root
+	for _, name := range path {
+		pbn, err := ls.Load(ipld.LinkContext{}, lnk, dagpb.Type.PBNode)
+		require.NoError(t, err)
+		dir, err := unixfsnode.Reify(ipld.LinkContext{}, pbn, ls)
+		require.NoError(t, err)
+		nd, err := dir.LookupByString(name)
+		require.NoError(t, err)
+		lnk, err = nd.AsLink()
+		require.NoError(t, err)
+	}
+	pbn, err := ls.Load(ipld.LinkContext{}, lnk, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	return pbn.(dagpb.PBNode)
+}
+
+func zipMtime(t *testing.T, pbn dagpb.PBNode) time.Time {
+	ufs, err := data.DecodeUnixFSData(pbn.Data.Must().Bytes())
+	require.NoError(t, err)
+	if !ufs.FieldMtime().Exists() {
+		return time.Time{}
+	}
+	return time.Unix(ufs.FieldMtime().Must().FieldSeconds().Int(), 0)
+}
+
+func TestBuildUnixFSFromZip(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	dirTime := time.Date(2021, 3, 4, 5, 6, 8, 0, time.UTC)
+	fileTime := time.Date(2022, 1, 2, 3, 4, 6, 0, time.UTC)
+	big := random.Bytes(300000)
+	zr := mkZip(t, []zipTestEntry{
+		{name: "a/", mode: fs.ModeDir | 0o700, mtime: dirTime},
+		{name: "a/hello.txt", content: []byte("hello"), mtime: fileTime},
+		{name: "a/link", content: []byte("hello.txt"), mode: fs.ModeSymlink | 0o777, mtime: fileTime},
+		{name: "b/c/big.bin", content: big, mode: 0o755 | fs.ModeSetuid, mtime: fileTime},
+		{name: "empty", mtime: fileTime},
+	})
+
+	root, size, err := BuildUnixFSFromZip(zr, "size-65536", &ls)
+	require.NoError(t, err)
+	var stored int
+	for _, blk := range storage.Bag {
+		stored += len(blk)
+	}
+	require.Equal(t, stored, int(size))
+
+	require.True(t, zipMtime(t, zipLookup(t, &ls, root)).IsZero())
+	require.Equal(t, dirTime, zipMtime(t, zipLookup(t, &ls, root, "a")).UTC())
+	// b and b/c weren't in the archive
+	require.True(t, zipMtime(t, zipLookup(t, &ls, root, "b")).IsZero())
+	require.True(t, zipMtime(t, zipLookup(t, &ls, root, "b", "c")).IsZero())
+
+	// modes are kept, and unlisted directories have the default
+	for path, mode := range map[string]fs.FileMode{
+		"a":           fs.ModeDir | 0o700,
+		"a/hello.txt": 0o666,
+		"a/link":      fs.ModeSymlink | 0o777,
+		"b":           fs.ModeDir | 0o755,
+		"b/c/big.bin": 0o755 | fs.ModeSetuid,
+	} {
+		ufs, err := data.DecodeUnixFSData(zipLookup(t, &ls, root, strings.Split(path, "/")...).Data.Must().Bytes())
+		require.NoError(t, err)
+		require.Equal(t, mode, ufs.FileMode(), path)
+	}
+
+	for path, content := range map[string][]byte{
+		"a/hello.txt": []byte("hello"),
+		"b/c/big.bin": big,
+		"empty":       {},
+	} {
+		pbn := zipLookup(t, &ls, root, strings.Split(path, "/")...)
+		require.Equal(t, fileTime, zipMtime(t, pbn).UTC(), path)
+		f, err := unixfsnode.Reify(ipld.LinkContext{}, pbn, &ls)
+		require.NoError(t, err)
+		out, err := f.AsBytes()
+		require.NoError(t, err)
+		require.True(t, bytes.Equal(content, out), path)
+	}
+
+	link := zipLookup(t, &ls, root, "a", "link")
+	ufs, err := data.DecodeUnixFSData(link.Data.Must().Bytes())
+	require.NoError(t, err)
+	require.Equal(t, data.Data_Symlink, ufs.FieldDataType().Int())
+	require.Equal(t, []byte("hello.txt"), ufs.FieldData().Must().Bytes())
+}
+
+func TestBuildUnixFSFromZipRejects(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	for name, entries := range map[string][]zipTestEntry{
+		"escaping path": {{name: "../evil"}},
+		"absolute path": {{name: "/etc/passwd"}},
+		"duplicate":     {{name: "a/b"}, {name: "a/b"}},
+		"duplicate dir": {{name: "a/"}, {name: "a/b"}, {name: "a/", mode: fs.ModeDir | 0o700}},
+		"file as dir":   {{name: "a"}, {name: "a/b"}},
+		"dir as file":   {{name: "a/b"}, {name: "a"}},
+		"named pipe":    {{name: "fifo", mode: fs.ModeNamedPipe | 0o644}},
+	} {
+		_, _, err := BuildUnixFSFromZip(mkZip(t, entries), "", &ls)
+		require.Error(t, err, name)
+	}
+}
@@ -41,3 +41,24 @@ type ErrInvalidDataType struct {
 func (e ErrInvalidDataType) Error() string {
 	return fmt.Sprintf("type: %d is not valid", e.DataType)
 }
+
+// ErrInconsistentFileSize indicates UnixFS data whose FileSize is not the size
+// of its Data plus the sum of its BlockSizes.
+type ErrInconsistentFileSize struct {
+	FileSize uint64
+	Expected uint64
+}
+
+func (e ErrInconsistentFileSize) Error() string {
+	return fmt.Sprintf("file size %d does not match the size of the data and blocks, %d", e.FileSize, e.Expected)
+}
+
+// ErrDuplicateName indicates a directory with more than one entry of the same
+// name, whether found building it or reading it.
+type ErrDuplicateName struct {
+	Name string
+}
+
+func (e ErrDuplicateName) Error() string {
+	return fmt.Sprintf("duplicate directory entry name: %q", e.Name)
+}
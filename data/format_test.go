@@ -3,6 +3,7 @@ package data_test
 // adapted from https://github.com/ipfs/js-ipfs-unixfs/blob/master/packages/ipfs-unixfs/test/unixfs-format.spec.js
 
 import (
+	"bytes"
 	"io"
 	"os"
 	"path"
@@ -16,6 +17,7 @@ import (
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/fluent/qp"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 func loadFixture(name string) []byte {
@@ -124,6 +126,37 @@ func TestUnixfsFormat(t *testing.T) {
 		require.Equal(t, unmarshaled.FieldFileSize(), data.FieldFileSize())
 	})
 
+	t.Run("file size must match data and blocksizes", func(t *testing.T) {
+		ufsData, err := builder.BuildUnixFS(func(b *builder.Builder) {
+			builder.Data(b, []byte("batata"))
+			builder.BlockSizes(b, []uint64{256, 100})
+			builder.FileSize(b, 362)
+		})
+		require.NoError(t, err)
+		require.Equal(t, int64(362), ufsData.FieldFileSize().Must().Int())
+
+		_, err = builder.BuildUnixFS(func(b *builder.Builder) {
+			builder.BlockSizes(b, []uint64{256, 100})
+			builder.FileSize(b, 300)
+		})
+		require.ErrorIs(t, err, ErrInconsistentFileSize{FileSize: 300, Expected: 356})
+
+		_, err = builder.BuildUnixFS(func(b *builder.Builder) {
+			builder.DataType(b, Data_Raw)
+			builder.Data(b, []byte("bananas"))
+			builder.FileSize(b, 1)
+		})
+		require.ErrorIs(t, err, ErrInconsistentFileSize{FileSize: 1, Expected: 7})
+
+		ufsData, err = builder.BuildUnixFS(func(b *builder.Builder) {
+			builder.SkipFileSizeValidation(b)
+			builder.BlockSizes(b, []uint64{256, 100})
+			builder.FileSize(b, 300)
+		})
+		require.NoError(t, err)
+		require.Equal(t, int64(300), ufsData.FieldFileSize().Must().Int())
+	})
+
 	t.Run("mode", func(t *testing.T) {
 		mode, err := strconv.ParseInt("0555", 8, 32)
 		require.NoError(t, err)
@@ -317,6 +350,34 @@ func TestUnixfsFormat(t *testing.T) {
 		require.Equal(t, unmarshaled.FieldFileSize(), data.FieldFileSize())
 	})
 
+	t.Run("packed blocksizes", func(t *testing.T) {
+		fileType := protowire.AppendVarint(protowire.AppendTag(nil, Data_DataTypeWireNum, protowire.VarintType), uint64(Data_File))
+		packed := protowire.AppendTag(nil, Data_BlockSizesWireNum, protowire.BytesType)
+		packed = protowire.AppendBytes(packed, protowire.AppendVarint(protowire.AppendVarint(nil, 300), 5))
+		unpacked := protowire.AppendVarint(protowire.AppendTag(nil, Data_BlockSizesWireNum, protowire.VarintType), 7)
+
+		unmarshaled, err := DecodeUnixFSData(append(append([]byte(nil), fileType...), packed...))
+		require.NoError(t, err)
+		require.Equal(t, int64(2), unmarshaled.FieldBlockSizes().Length())
+		bs, err := unmarshaled.FieldBlockSizes().LookupByIndex(0)
+		require.NoError(t, err)
+		v, err := bs.AsInt()
+		require.NoError(t, err)
+		require.Equal(t, int64(300), v)
+		bs, err = unmarshaled.FieldBlockSizes().LookupByIndex(1)
+		require.NoError(t, err)
+		v, err = bs.AsInt()
+		require.NoError(t, err)
+		require.Equal(t, int64(5), v)
+
+		for _, twice := range [][][]byte{{packed, packed}, {packed, unpacked}, {unpacked, packed}} {
+			enc := append(append([]byte(nil), fileType...), twice[0]...)
+			enc = append(enc, twice[1]...)
+			_, err := DecodeUnixFSData(enc)
+			require.Error(t, err)
+		}
+	})
+
 	t.Run("invalid type", func(t *testing.T) {
 		_, err := builder.BuildUnixFS(func(b *builder.Builder) {
 			builder.DataType(b, 9999)
@@ -374,3 +435,191 @@ func TestInterop(t *testing.T) {
 		require.Equal(t, []byte{0x08, 0x02}, marshaled)
 	})
 }
+
+func TestInspect(t *testing.T) {
+	shard, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.DataType(b, Data_HAMTShard)
+		builder.Data(b, []byte{0x80, 0x05})
+		builder.HashType(b, 0x22)
+		builder.Fanout(b, 16)
+		builder.Permissions(b, 0o700)
+		builder.Mtime(b, func(tb builder.TimeBuilder) {
+			builder.Seconds(tb, 1700000000)
+			builder.FractionalNanoseconds(tb, 500)
+		})
+	})
+	require.NoError(t, err)
+	in, err := Inspect(EncodeUnixFSData(shard))
+	require.NoError(t, err)
+	require.Equal(t, Data_HAMTShard, in.DataType)
+	require.Equal(t, "HAMTShard", in.TypeName)
+	require.Equal(t, 2, in.DataLength)
+	require.Equal(t, "1000000000000101", in.Bitfield)
+	require.Equal(t, uint64(0x22), *in.HashType)
+	require.Equal(t, uint64(16), *in.Fanout)
+	require.Equal(t, uint64(0o700), *in.Mode)
+	require.Equal(t, time.Unix(1700000000, 500).UTC(), *in.Mtime)
+	require.Nil(t, in.FileSize)
+	require.Nil(t, in.BlockSizes)
+	require.Equal(t, `Type: HAMTShard (5)
+Data: 2 bytes
+HashType: 0x22
+Fanout: 16
+Bitfield: 1000000000000101 (3 set)
+Mode: 0700
+Mtime: 2023-11-14T22:13:20.0000005Z
+`, in.String())
+
+	// inconsistent fields are reported as they are
+	bad, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.SkipFileSizeValidation(b)
+		builder.DataType(b, Data_File)
+		builder.FileSize(b, 10)
+		builder.BlockSizes(b, []uint64{3, 4})
+		builder.Fanout(b, 256)
+	})
+	require.NoError(t, err)
+	in, err = Inspect(EncodeUnixFSData(bad))
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), *in.FileSize)
+	require.Equal(t, []uint64{3, 4}, in.BlockSizes)
+	require.Equal(t, uint64(256), *in.Fanout)
+	require.Empty(t, in.Bitfield)
+	require.Equal(t, `Type: File (2)
+Data: 0 bytes
+FileSize: 10
+BlockSizes: [3 4] (2 blocks, 7 bytes)
+Fanout: 256
+`, in.String())
+
+	_, err = Inspect([]byte{0xff})
+	require.Error(t, err)
+}
+
+func zeroCopyInputs(t testing.TB) [][]byte {
+	blockSizes := make([]uint64, 174)
+	var fileSize uint64
+	for i := range blockSizes {
+		blockSizes[i] = 262144 + uint64(i)
+		fileSize += blockSizes[i]
+	}
+	bigFile, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.DataType(b, Data_File)
+		builder.FileSize(b, fileSize)
+		builder.BlockSizes(b, blockSizes)
+		builder.Permissions(b, 0o644)
+		builder.Mtime(b, func(tb builder.TimeBuilder) {
+			builder.Seconds(tb, 1700000000)
+			builder.FractionalNanoseconds(tb, 500)
+		})
+	})
+	require.NoError(t, err)
+	shard, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.DataType(b, Data_HAMTShard)
+		builder.Data(b, make([]byte, 128))
+		builder.HashType(b, 0x22)
+		builder.Fanout(b, 1024)
+	})
+	require.NoError(t, err)
+	// block sizes packed into a single field, as some encoders write them
+	packed := protowire.AppendTag(nil, Data_DataTypeWireNum, protowire.VarintType)
+	packed = protowire.AppendVarint(packed, uint64(Data_File))
+	packed = protowire.AppendTag(packed, Data_BlockSizesWireNum, protowire.BytesType)
+	packed = protowire.AppendBytes(packed, protowire.AppendVarint(protowire.AppendVarint(nil, 300), 5))
+	return [][]byte{raw, directory, file, symlink, EncodeUnixFSData(bigFile), EncodeUnixFSData(shard), packed}
+}
+
+func TestDecodeUnixFSDataZeroCopy(t *testing.T) {
+	check := func(src []byte) {
+		expected, expectedErr := DecodeUnixFSData(src)
+		actual, err := DecodeUnixFSDataZeroCopy(src)
+		if expectedErr != nil {
+			require.Error(t, err, "decoding %x", src)
+			return
+		}
+		require.NoError(t, err, "decoding %x", src)
+		require.True(t, ipld.DeepEqual(expected, actual), "decoding %x", src)
+		require.Equal(t, EncodeUnixFSData(expected), EncodeUnixFSData(actual))
+	}
+	for _, src := range zeroCopyInputs(t) {
+		check(src)
+		// truncated and corrupted inputs fail, or not, as for DecodeUnixFSData
+		for i := range src {
+			check(src[:i])
+			corrupt := append([]byte(nil), src...)
+			corrupt[i] ^= 0xff
+			check(corrupt)
+		}
+		// repeated fields
+		check(append(append([]byte(nil), src...), src...))
+	}
+
+	src := append([]byte(nil), raw...)
+	nd, err := DecodeUnixFSDataZeroCopy(src)
+	require.NoError(t, err)
+	src[bytes.Index(src, []byte("Hello"))] = 'J'
+	require.Equal(t, []byte("Jello UnixFS\n"), nd.FieldData().Must().Bytes(), "data should reference the source")
+}
+
+func BenchmarkDecodeUnixFSData(b *testing.B) {
+	for _, decode := range []struct {
+		name string
+		fn   func([]byte) (UnixFSData, error)
+	}{
+		{"assembled", DecodeUnixFSData},
+		{"zero-copy", DecodeUnixFSDataZeroCopy},
+	} {
+		b.Run(decode.name, func(b *testing.B) {
+			inputs := zeroCopyInputs(b)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, src := range inputs {
+					if _, err := decode.fn(src); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestUnixFSDataJSON(t *testing.T) {
+	for _, src := range zeroCopyInputs(t) {
+		nd, err := DecodeUnixFSData(src)
+		require.NoError(t, err)
+		js, err := EncodeUnixFSDataToJSON(nd)
+		require.NoError(t, err)
+		decoded, err := DecodeUnixFSDataFromJSON(js)
+		require.NoError(t, err, string(js))
+		require.True(t, ipld.DeepEqual(nd, decoded), "round trip of %s", js)
+	}
+
+	nd, err := DecodeUnixFSData(file)
+	require.NoError(t, err)
+	js, err := EncodeUnixFSDataToJSON(nd)
+	require.NoError(t, err)
+	require.Equal(t, `{
+	"DataType": 2,
+	"Data": {
+		"/": {
+			"bytes": "SGVsbG8gVW5peEZTCg"
+		}
+	},
+	"FileSize": 13,
+	"BlockSizes": []
+}`, string(js))
+
+	// hand written, without BlockSizes
+	nd, err = DecodeUnixFSDataFromJSON([]byte(`{"DataType": 5, "Fanout": 256, "HashType": 34, "Mtime": {"Seconds": 1700000000}}`))
+	require.NoError(t, err)
+	require.Equal(t, Data_HAMTShard, nd.FieldDataType().Int())
+	require.Equal(t, int64(256), nd.FieldFanout().Must().Int())
+	require.Equal(t, int64(1700000000), nd.FieldMtime().Must().FieldSeconds().Int())
+	require.Zero(t, nd.FieldBlockSizes().Length())
+
+	_, err = DecodeUnixFSDataFromJSON([]byte(`{"DataType": 2, "Size": 3}`))
+	require.Error(t, err)
+	_, err = DecodeUnixFSDataFromJSON([]byte(`[2]`))
+	require.Error(t, err)
+}
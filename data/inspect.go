@@ -0,0 +1,124 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Inspection holds the fields of a UnixFS Data message, as found by Inspect,
+// in a form suited to printing when investigating malformed DAGs. Fields not
+// present in the message are nil.
+type Inspection struct {
+	DataType int64 `json:"dataType"`
+	// TypeName is the name of DataType, or empty if it's not a known type
+	TypeName string `json:"typeName,omitempty"`
+	// DataLength is the number of bytes of Data
+	DataLength int      `json:"dataLength"`
+	FileSize   *uint64  `json:"fileSize,omitempty"`
+	BlockSizes []uint64 `json:"blockSizes,omitempty"`
+	HashType   *uint64  `json:"hashType,omitempty"`
+	Fanout     *uint64  `json:"fanout,omitempty"`
+	// Bitfield is the Data of a HAMTShard as a string of bits, with the most
+	// significant bit of the first byte first
+	Bitfield string `json:"bitfield,omitempty"`
+	// Mode is the mode as stored, including any bits above the permissions
+	Mode  *uint64    `json:"mode,omitempty"`
+	Mtime *time.Time `json:"mtime,omitempty"`
+}
+
+// Inspect decodes the UnixFS Data message in src and returns its fields as
+// an Inspection. Data is decoded as it is for reading, so that an error is
+// returned for messages that can't be read, but the fields are not checked
+// against each other: a FileSize that doesn't match the BlockSizes, or a
+// Fanout on a File, is reported as it is.
+func Inspect(src []byte) (*Inspection, error) {
+	ufsd, err := DecodeUnixFSData(src)
+	if err != nil {
+		return nil, err
+	}
+	dataType := ufsd.FieldDataType().Int()
+	in := &Inspection{
+		DataType: dataType,
+		TypeName: DataTypeNames[dataType],
+	}
+	if ufsd.FieldData().Exists() {
+		d := ufsd.FieldData().Must().Bytes()
+		in.DataLength = len(d)
+		if dataType == Data_HAMTShard {
+			var bits strings.Builder
+			for _, b := range d {
+				fmt.Fprintf(&bits, "%08b", b)
+			}
+			in.Bitfield = bits.String()
+		}
+	}
+	if ufsd.FieldFileSize().Exists() {
+		v := uint64(ufsd.FieldFileSize().Must().Int())
+		in.FileSize = &v
+	}
+	itr := ufsd.FieldBlockSizes().Iterator()
+	for !itr.Done() {
+		_, bs := itr.Next()
+		in.BlockSizes = append(in.BlockSizes, uint64(bs.Int()))
+	}
+	if ufsd.FieldHashType().Exists() {
+		v := uint64(ufsd.FieldHashType().Must().Int())
+		in.HashType = &v
+	}
+	if ufsd.FieldFanout().Exists() {
+		v := uint64(ufsd.FieldFanout().Must().Int())
+		in.Fanout = &v
+	}
+	if ufsd.FieldMode().Exists() {
+		v := uint64(ufsd.FieldMode().Must().Int())
+		in.Mode = &v
+	}
+	if ufsd.FieldMtime().Exists() {
+		mtime := ufsd.FieldMtime().Must()
+		var nsecs int64
+		if mtime.FieldFractionalNanoseconds().Exists() {
+			nsecs = mtime.FieldFractionalNanoseconds().Must().Int()
+		}
+		t := time.Unix(mtime.FieldSeconds().Int(), nsecs).UTC()
+		in.Mtime = &t
+	}
+	return in, nil
+}
+
+// String prints the fields of the Inspection, one per line.
+func (in *Inspection) String() string {
+	var sb strings.Builder
+	typeName := in.TypeName
+	if typeName == "" {
+		typeName = "unknown"
+	}
+	fmt.Fprintf(&sb, "Type: %s (%d)\n", typeName, in.DataType)
+	fmt.Fprintf(&sb, "Data: %d bytes\n", in.DataLength)
+	if in.FileSize != nil {
+		fmt.Fprintf(&sb, "FileSize: %d\n", *in.FileSize)
+	}
+	if in.BlockSizes != nil {
+		var sum uint64
+		for _, bs := range in.BlockSizes {
+			sum += bs
+		}
+		fmt.Fprintf(&sb, "BlockSizes: %v (%d blocks, %d bytes)\n", in.BlockSizes, len(in.BlockSizes), sum)
+	}
+	if in.HashType != nil {
+		fmt.Fprintf(&sb, "HashType: %#x\n", *in.HashType)
+	}
+	if in.Fanout != nil {
+		fmt.Fprintf(&sb, "Fanout: %d\n", *in.Fanout)
+	}
+	if in.Bitfield != "" {
+		fmt.Fprintf(&sb, "Bitfield: %s (%d set)\n", in.Bitfield, strings.Count(in.Bitfield, "1"))
+	}
+	if in.Mode != nil {
+		fmt.Fprintf(&sb, "Mode: %#o\n", *in.Mode)
+	}
+	if in.Mtime != nil {
+		fmt.Fprintf(&sb, "Mtime: %s\n", in.Mtime.Format(time.RFC3339Nano))
+	}
+	return sb.String()
+}
@@ -0,0 +1,86 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// EncodeUnixFSDataToJSON encodes a UnixFSData node as indented dag-json, with
+// the fields named as in the schema, so that UnixFS headers can be read and
+// edited by hand in fixtures and bug reports. Data is encoded in the dag-json
+// form for bytes, {"/":{"bytes":"<base64>"}}, and absent optional fields are
+// left out. For example, the header of a small file is
+//
+//	{
+//		"DataType": 2,
+//		"Data": {
+//			"/": {
+//				"bytes": "SGVsbG8gVW5peEZTCg"
+//			}
+//		},
+//		"FileSize": 13,
+//		"BlockSizes": []
+//	}
+func EncodeUnixFSDataToJSON(node UnixFSData) ([]byte, error) {
+	var buf bytes.Buffer
+	// fields are kept in the order of the schema rather than sorted
+	enc := dagjson.EncodeOptions{EncodeLinks: true, EncodeBytes: true, MapSortMode: codec.MapSortMode_None}
+	if err := enc.Encode(node.Representation(), &buf); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", "\t"); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// DecodeUnixFSDataFromJSON decodes a UnixFSData node from the dag-json written
+// by EncodeUnixFSDataToJSON. BlockSizes may be left out when there are none;
+// otherwise the fields must match the schema. The node can be encoded for a
+// dag-pb Data field with EncodeUnixFSData.
+func DecodeUnixFSDataFromJSON(src []byte) (UnixFSData, error) {
+	nb := basicnode.Prototype.Map.NewBuilder()
+	if err := dagjson.Decode(nb, bytes.NewReader(src)); err != nil {
+		return nil, fmt.Errorf("decoding UnixFS data from JSON: %w", err)
+	}
+	decoded := nb.Build()
+	if decoded.Kind() != ipld.Kind_Map {
+		return nil, fmt.Errorf("decoding UnixFS data from JSON: expected a map, got %s", decoded.Kind())
+	}
+	nd, err := qp.BuildMap(Type.UnixFSData__Repr, -1, func(ma ipld.MapAssembler) {
+		itr := decoded.MapIterator()
+		for !itr.Done() {
+			k, v, err := itr.Next()
+			if err != nil {
+				panic(err)
+			}
+			key, err := k.AsString()
+			if err != nil {
+				panic(err)
+			}
+			// copied rather than assigned, as the assemblers of nested
+			// structs can't take a node of another type whole
+			qp.MapEntry(ma, key, func(na ipld.NodeAssembler) {
+				if err := datamodel.Copy(v, na); err != nil {
+					panic(err)
+				}
+			})
+		}
+		if bs, _ := decoded.LookupByString(Field__BlockSizes); bs == nil {
+			qp.MapEntry(ma, Field__BlockSizes, qp.List(0, func(ipld.ListAssembler) {}))
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decoding UnixFS data from JSON: %w", err)
+	}
+	return nd.(UnixFSData), nil
+}
@@ -1,5 +1,10 @@
 package data
 
+import (
+	"io/fs"
+	"time"
+)
+
 const FilePermissionsDefault = 0o0644
 const DirectorPerimissionsDefault = 0o0755
 const HAMTShardPerimissionsDefault = 0o0755
@@ -25,3 +30,91 @@ func DefaultPermissions(u UnixFSData) int {
 		return 0
 	}
 }
+
+// The bits of the UnixFS mode beyond the permissions for the owner, group and
+// others, as in POSIX. fs.FileMode holds them apart from its permission bits,
+// as fs.ModeSetuid, fs.ModeSetgid and fs.ModeSticky.
+const (
+	ModeSetuid = 0o4000
+	ModeSetgid = 0o2000
+	ModeSticky = 0o1000
+)
+
+// FileMode returns the mode of the node as an fs.FileMode: its Permissions,
+// including the setuid, setgid and sticky bits, with the type bits for its
+// DataType.
+func (u UnixFSData) FileMode() fs.FileMode {
+	return FileModeFromMode(u.FieldDataType().Int(), u.Permissions())
+}
+
+// ModTime returns the modification time of the node, or the zero time if it
+// has none.
+func (u UnixFSData) ModTime() time.Time {
+	if !u.FieldMtime().Exists() {
+		return time.Time{}
+	}
+	t := u.FieldMtime().Must()
+	var nsecs int64
+	if t.FieldFractionalNanoseconds().Exists() {
+		nsecs = t.FieldFractionalNanoseconds().Must().Int()
+	}
+	return time.Unix(t.FieldSeconds().Int(), nsecs)
+}
+
+// FileModeFromMode returns the fs.FileMode of a UnixFS node of the given
+// DataType with the given mode. Directories and HAMT shards are given
+// fs.ModeDir and symlinks fs.ModeSymlink, while files, raw nodes and metadata
+// are regular files.
+func FileModeFromMode(dataType int64, mode int) fs.FileMode {
+	m := fs.FileMode(mode) & fs.ModePerm
+	if mode&ModeSetuid != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&ModeSetgid != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&ModeSticky != 0 {
+		m |= fs.ModeSticky
+	}
+	switch dataType {
+	case Data_Directory, Data_HAMTShard:
+		m |= fs.ModeDir
+	case Data_Symlink:
+		m |= fs.ModeSymlink
+	}
+	return m
+}
+
+// ModeFromFileMode returns the UnixFS mode of m: its permission bits and its
+// setuid, setgid and sticky bits. The type bits of m are dropped, as UnixFS
+// records the type of a node in its DataType; see DataTypeFromFileMode.
+func ModeFromFileMode(m fs.FileMode) int {
+	mode := int(m & fs.ModePerm)
+	if m&fs.ModeSetuid != 0 {
+		mode |= ModeSetuid
+	}
+	if m&fs.ModeSetgid != 0 {
+		mode |= ModeSetgid
+	}
+	if m&fs.ModeSticky != 0 {
+		mode |= ModeSticky
+	}
+	return mode
+}
+
+// DataTypeFromFileMode returns the DataType a file of mode m is stored as:
+// Data_Directory for a directory, Data_Symlink for a symlink and Data_File for
+// a regular file. Other types of file, such as devices and named pipes, have
+// no UnixFS equivalent, and false is returned for them.
+func DataTypeFromFileMode(m fs.FileMode) (int64, bool) {
+	switch m.Type() {
+	case 0:
+		return Data_File, true
+	case fs.ModeDir:
+		return Data_Directory, true
+	case fs.ModeSymlink:
+		return Data_Symlink, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,51 @@
+package data_test
+
+import (
+	"io/fs"
+	"testing"
+
+	. "github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileModeConversion(t *testing.T) {
+	for _, tc := range []struct {
+		dataType int64
+		mode     int
+		fileMode fs.FileMode
+	}{
+		{Data_File, 0o644, 0o644},
+		{Data_Raw, 0o600, 0o600},
+		{Data_File, 0o4755, fs.ModeSetuid | 0o755},
+		{Data_File, 0o2711, fs.ModeSetgid | 0o711},
+		{Data_Directory, 0o1777, fs.ModeDir | fs.ModeSticky | 0o777},
+		{Data_HAMTShard, 0o7000, fs.ModeDir | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky},
+		{Data_Symlink, 0o777, fs.ModeSymlink | 0o777},
+	} {
+		require.Equal(t, tc.fileMode, FileModeFromMode(tc.dataType, tc.mode))
+		require.Equal(t, tc.mode, ModeFromFileMode(tc.fileMode))
+		dataType, ok := DataTypeFromFileMode(tc.fileMode)
+		require.True(t, ok)
+		if tc.dataType == Data_File || tc.dataType == Data_Directory || tc.dataType == Data_Symlink {
+			require.Equal(t, tc.dataType, dataType)
+		}
+	}
+	_, ok := DataTypeFromFileMode(fs.ModeNamedPipe | 0o644)
+	require.False(t, ok)
+	_, ok = DataTypeFromFileMode(fs.ModeDevice | fs.ModeCharDevice)
+	require.False(t, ok)
+
+	// the mode of a node, or the default for its type
+	ufsd, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.DataType(b, Data_Directory)
+		builder.Permissions(b, 0o2750)
+	})
+	require.NoError(t, err)
+	require.Equal(t, fs.ModeDir|fs.ModeSetgid|0o750, ufsd.FileMode())
+	ufsd, err = builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.DataType(b, Data_File)
+	})
+	require.NoError(t, err)
+	require.Equal(t, fs.FileMode(0o644), ufsd.FileMode())
+}
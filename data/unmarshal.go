@@ -88,6 +88,7 @@ func consumeUnixFSData(remaining []byte, ma ipld.MapAssembler) error {
 				if la != nil {
 					return errors.New("cannot build blocksizes twice")
 				}
+				packedBlockSizes = true
 				blockSizesBytes, n := protowire.ConsumeBytes(remaining)
 				if n < 0 {
 					return protowire.ParseError(n)
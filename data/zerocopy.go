@@ -0,0 +1,265 @@
+package data
+
+import (
+	"errors"
+	"math"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/schema"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// DecodeUnixFSDataZeroCopy decodes UnixFS data as DecodeUnixFSData does, but
+// fills in the node directly rather than assembling it field by field, so
+// that the only allocations are for the node itself, its block sizes and its
+// modification time. The Data of the node references src rather than a copy
+// of it, so src must not be modified while the node is in use; this is always
+// the case for the Data of a dag-pb node, as nodes are immutable.
+//
+// This is the decoder used for reification, where the UnixFS data of every
+// block loaded is decoded.
+func DecodeUnixFSDataZeroCopy(src []byte) (UnixFSData, error) {
+	nd := &_UnixFSData{}
+	if err := decodeUnixFSDataInto(src, nd); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+func decodeUnixFSDataInto(remaining []byte, nd *_UnixFSData) error {
+	var seen int
+	// seeField marks a field as seen, failing if it was seen already, as the
+	// schema doesn't allow fields to repeat
+	seeField := func(bit int, name *_String) error {
+		if seen&bit != 0 {
+			return ipld.ErrRepeatedMapKey{Key: name}
+		}
+		seen |= bit
+		return nil
+	}
+	var packedBlockSizes, unpackedBlockSizes bool
+	for len(remaining) != 0 {
+		fieldNum, wireType, n := protowire.ConsumeTag(remaining)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		remaining = remaining[n:]
+		switch fieldNum {
+		case Data_DataTypeWireNum:
+			if wireType != protowire.VarintType {
+				return ErrWrongWireType{"UnixFSData", Field__DataType, protowire.VarintType, wireType}
+			}
+			if err := seeField(fieldBit__UnixFSData_DataType, &fieldName__UnixFSData_DataType); err != nil {
+				return err
+			}
+			v, n := protowire.ConsumeVarint(remaining)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			remaining = remaining[n:]
+			nd.DataType.x = int64(v)
+		case Data_DataWireNum:
+			if wireType != protowire.BytesType {
+				return ErrWrongWireType{"UnixFSData", Field__Data, protowire.BytesType, wireType}
+			}
+			if err := seeField(fieldBit__UnixFSData_Data, &fieldName__UnixFSData_Data); err != nil {
+				return err
+			}
+			v, n := protowire.ConsumeBytes(remaining)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			remaining = remaining[n:]
+			nd.Data = _Bytes__Maybe{m: schema.Maybe_Value, v: _Bytes{v}}
+		case Data_FileSizeWireNum:
+			if wireType != protowire.VarintType {
+				return ErrWrongWireType{"UnixFSData", Field__FileSize, protowire.VarintType, wireType}
+			}
+			if err := seeField(fieldBit__UnixFSData_FileSize, &fieldName__UnixFSData_FileSize); err != nil {
+				return err
+			}
+			v, n := protowire.ConsumeVarint(remaining)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			remaining = remaining[n:]
+			nd.FileSize = _Int__Maybe{m: schema.Maybe_Value, v: _Int{int64(v)}}
+		case Data_BlockSizesWireNum:
+			switch wireType {
+			case protowire.VarintType:
+				if packedBlockSizes {
+					return errors.New("cannot build blocksizes twice")
+				}
+				unpackedBlockSizes = true
+				v, n := protowire.ConsumeVarint(remaining)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				remaining = remaining[n:]
+				nd.BlockSizes.x = append(nd.BlockSizes.x, _Int{int64(v)})
+			case protowire.BytesType:
+				if unpackedBlockSizes {
+					return errors.New("cannot build blocksizes twice")
+				}
+				if packedBlockSizes {
+					return ipld.ErrRepeatedMapKey{Key: &fieldName__UnixFSData_BlockSizes}
+				}
+				packedBlockSizes = true
+				packed, n := protowire.ConsumeBytes(remaining)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				remaining = remaining[n:]
+				if err := consumePackedBlockSizes(packed, &nd.BlockSizes); err != nil {
+					return err
+				}
+			default:
+				return ErrWrongWireType{"UnixFSData", Field__BlockSizes, protowire.VarintType, wireType}
+			}
+		case Data_HashTypeWireNum:
+			if wireType != protowire.VarintType {
+				return ErrWrongWireType{"UnixFSData", Field__HashType, protowire.VarintType, wireType}
+			}
+			if err := seeField(fieldBit__UnixFSData_HashType, &fieldName__UnixFSData_HashType); err != nil {
+				return err
+			}
+			v, n := protowire.ConsumeVarint(remaining)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			remaining = remaining[n:]
+			nd.HashType = _Int__Maybe{m: schema.Maybe_Value, v: _Int{int64(v)}}
+		case Data_FanoutWireNum:
+			if wireType != protowire.VarintType {
+				return ErrWrongWireType{"UnixFSData", Field__Fanout, protowire.VarintType, wireType}
+			}
+			if err := seeField(fieldBit__UnixFSData_Fanout, &fieldName__UnixFSData_Fanout); err != nil {
+				return err
+			}
+			v, n := protowire.ConsumeVarint(remaining)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			remaining = remaining[n:]
+			nd.Fanout = _Int__Maybe{m: schema.Maybe_Value, v: _Int{int64(v)}}
+		case Data_ModeWireNum:
+			if wireType != protowire.VarintType {
+				return ErrWrongWireType{"UnixFSData", Field__Mode, protowire.VarintType, wireType}
+			}
+			if err := seeField(fieldBit__UnixFSData_Mode, &fieldName__UnixFSData_Mode); err != nil {
+				return err
+			}
+			v, n := protowire.ConsumeVarint(remaining)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			if v > math.MaxUint32 {
+				return errors.New("mode should be a 32 bit value")
+			}
+			remaining = remaining[n:]
+			nd.Mode = _Int__Maybe{m: schema.Maybe_Value, v: _Int{int64(v)}}
+		case Data_MtimeWireNum:
+			if wireType != protowire.BytesType {
+				return ErrWrongWireType{"UnixFSData", Field__Mtime, protowire.BytesType, wireType}
+			}
+			if err := seeField(fieldBit__UnixFSData_Mtime, &fieldName__UnixFSData_Mtime); err != nil {
+				return err
+			}
+			v, n := protowire.ConsumeBytes(remaining)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			remaining = remaining[n:]
+			mtime := &_UnixTime{}
+			if err := decodeUnixTimeInto(v, mtime); err != nil {
+				return err
+			}
+			nd.Mtime = _UnixTime__Maybe{m: schema.Maybe_Value, v: mtime}
+		default:
+			n := protowire.ConsumeFieldValue(fieldNum, wireType, remaining)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			remaining = remaining[n:]
+		}
+	}
+	if seen&fieldBit__UnixFSData_DataType == 0 {
+		return ipld.ErrMissingRequiredField{Missing: []string{"DataType"}}
+	}
+	if nd.BlockSizes.x == nil {
+		nd.BlockSizes.x = []_Int{}
+	}
+	return nil
+}
+
+// consumePackedBlockSizes decodes packed block sizes, sizing the list from
+// the number of bytes ending a varint so that it is allocated once.
+func consumePackedBlockSizes(remaining []byte, bs *_BlockSizes) error {
+	var count int
+	for _, b := range remaining {
+		if b < 128 {
+			count++
+		}
+	}
+	bs.x = make([]_Int, 0, count)
+	for len(remaining) != 0 {
+		v, n := protowire.ConsumeVarint(remaining)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		remaining = remaining[n:]
+		bs.x = append(bs.x, _Int{int64(v)})
+	}
+	return nil
+}
+
+func decodeUnixTimeInto(remaining []byte, t *_UnixTime) error {
+	var seconds, nanos bool
+	for len(remaining) != 0 {
+		fieldNum, wireType, n := protowire.ConsumeTag(remaining)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		remaining = remaining[n:]
+		switch fieldNum {
+		case UnixTime_SecondsWireNum:
+			if wireType != protowire.VarintType {
+				return ErrWrongWireType{"UnixTime", Field__Seconds, protowire.VarintType, wireType}
+			}
+			if seconds {
+				return ipld.ErrRepeatedMapKey{Key: &fieldName__UnixTime_Seconds}
+			}
+			seconds = true
+			v, n := protowire.ConsumeVarint(remaining)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			remaining = remaining[n:]
+			t.Seconds.x = int64(v)
+		case UnixTime_FractionalNanosecondsWireNum:
+			if wireType != protowire.Fixed32Type {
+				return ErrWrongWireType{"UnixTime", Field__Nanoseconds, protowire.Fixed32Type, wireType}
+			}
+			if nanos {
+				return ipld.ErrRepeatedMapKey{Key: &fieldName__UnixTime_FractionalNanoseconds}
+			}
+			nanos = true
+			v, n := protowire.ConsumeFixed32(remaining)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			remaining = remaining[n:]
+			t.FractionalNanoseconds = _Int__Maybe{m: schema.Maybe_Value, v: _Int{int64(v)}}
+		default:
+			n := protowire.ConsumeFieldValue(fieldNum, wireType, remaining)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			remaining = remaining[n:]
+		}
+	}
+	if !seconds {
+		return ipld.ErrMissingRequiredField{Missing: []string{"Seconds"}}
+	}
+	return nil
+}
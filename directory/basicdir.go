@@ -18,14 +18,27 @@ var _ ipld.ADL = UnixFSBasicDir(nil)
 type UnixFSBasicDir = *_UnixFSBasicDir
 
 type _UnixFSBasicDir struct {
-	_substrate dagpb.PBNode
+	_substrate    dagpb.PBNode
+	policy        DuplicateNamePolicy
+	indexSegments bool
 }
 
+// NewUnixFSBasicDir creates a basic directory node from a dag-pb node and its
+// decoded UnixFS data. Entries of the same name are looked up according to the
+// DuplicateNamePolicy carried by ctx (see WithDuplicateNamePolicy), and
+// numeric segments looked up by index if ctx was made with
+// utils.WithIndexSegments.
 func NewUnixFSBasicDir(ctx context.Context, substrate dagpb.PBNode, nddata data.UnixFSData, _ *ipld.LinkSystem) (ipld.Node, error) {
 	if nddata.FieldDataType().Int() != data.Data_Directory {
 		return nil, data.ErrWrongNodeType{Expected: data.Data_Directory, Actual: nddata.FieldDataType().Int()}
 	}
-	return &_UnixFSBasicDir{_substrate: substrate}, nil
+	policy := duplicateNamePolicyFrom(ctx)
+	if policy == RejectDuplicates {
+		if err := checkDuplicateNames(substrate.FieldLinks()); err != nil {
+			return nil, err
+		}
+	}
+	return &_UnixFSBasicDir{_substrate: substrate, policy: policy, indexSegments: utils.IndexSegments(ctx)}, nil
 }
 
 func (n UnixFSBasicDir) Kind() ipld.Kind {
@@ -34,8 +47,7 @@ func (n UnixFSBasicDir) Kind() ipld.Kind {
 
 // LookupByString looks for the key in the list of links with a matching name
 func (n UnixFSBasicDir) LookupByString(key string) (ipld.Node, error) {
-	links := n._substrate.FieldLinks()
-	link := utils.Lookup(links, key)
+	link := n.lookup(key)
 	if link == nil {
 		return nil, schema.ErrNoSuchField{Type: nil /*TODO*/, Field: ipld.PathSegmentOfString(key)}
 	}
@@ -50,16 +62,32 @@ func (n UnixFSBasicDir) LookupByNode(key ipld.Node) (ipld.Node, error) {
 	return n.LookupByString(ks)
 }
 
+// LookupByIndex returns the link of the entry at idx, in the order of the
+// directory's links.
 func (n UnixFSBasicDir) LookupByIndex(idx int64) (ipld.Node, error) {
-	return n._substrate.LookupByIndex(idx)
+	links := n._substrate.FieldLinks()
+	if idx < 0 || idx >= links.Length() {
+		return nil, ipld.ErrNotExists{Segment: ipld.PathSegmentOfInt(idx)}
+	}
+	return links.Lookup(idx).FieldHash(), nil
 }
 
+// LookupBySegment looks the segment up as a name. If the directory was
+// created with a context made by utils.WithIndexSegments, a numeric segment
+// that is not a name is looked up as an index (see LookupByIndex); otherwise a
+// missing name is not found rather than resolving to another entry.
 func (n UnixFSBasicDir) LookupBySegment(seg ipld.PathSegment) (ipld.Node, error) {
-	return n.LookupByString(seg.String())
+	nd, err := n.LookupByString(seg.String())
+	if _, noSuchField := err.(schema.ErrNoSuchField); noSuchField && n.indexSegments {
+		if idx, ierr := seg.Index(); ierr == nil {
+			return n.LookupByIndex(idx)
+		}
+	}
+	return nd, err
 }
 
 func (n UnixFSBasicDir) MapIterator() ipld.MapIterator {
-	return iter.NewUnixFSDirMapIterator(&_UnixFSBasicDir__ListItr{n._substrate.Links.Iterator()}, nil)
+	return iter.NewUnixFSDirMapIterator(n.listItr(), nil)
 }
 
 // ListIterator returns an iterator which yields key-value pairs
@@ -129,11 +157,34 @@ func (n UnixFSBasicDir) Representation() ipld.Node {
 // Native map accessors
 
 func (n UnixFSBasicDir) Iterator() *iter.UnixFSDir__Itr {
-	return iter.NewUnixFSDirIterator(&_UnixFSBasicDir__ListItr{n._substrate.Links.Iterator()}, nil)
+	return iter.NewUnixFSDirIterator(n.listItr(), nil)
+}
+
+// RawIterator returns an iterator over the entries of the directory that
+// doesn't allocate nodes for them.
+func (n UnixFSBasicDir) RawIterator() *iter.UnixFSDir__RawItr {
+	return iter.NewUnixFSDirRawIterator(n.listItr(), 0)
 }
 
 func (n UnixFSBasicDir) Lookup(key dagpb.String) dagpb.Link {
-	return utils.Lookup(n._substrate.FieldLinks(), key.String())
+	return n.lookup(key.String())
+}
+
+// LookupAll returns the links of every entry named key, in link order,
+// whatever the directory's DuplicateNamePolicy.
+func (n UnixFSBasicDir) LookupAll(key string) []dagpb.Link {
+	return lookupAll(n._substrate.FieldLinks(), key)
+}
+
+func (n UnixFSBasicDir) lookup(key string) dagpb.Link {
+	if n.policy == LastWins {
+		matches := lookupAll(n._substrate.FieldLinks(), key)
+		if len(matches) == 0 {
+			return nil
+		}
+		return matches[len(matches)-1]
+	}
+	return utils.Lookup(n._substrate.FieldLinks(), key)
 }
 
 // direct access to the links and data
@@ -151,8 +202,13 @@ func (n UnixFSBasicDir) Substrate() ipld.Node {
 	return n._substrate
 }
 
+func (n UnixFSBasicDir) listItr() *_UnixFSBasicDir__ListItr {
+	return &_UnixFSBasicDir__ListItr{n._substrate.Links.Iterator(), n._substrate.Links.Length()}
+}
+
 type _UnixFSBasicDir__ListItr struct {
 	_substrate *dagpb.PBLinks__Itr
+	length     int64
 }
 
 func (itr *_UnixFSBasicDir__ListItr) Next() (int64, dagpb.PBLink, error) {
@@ -163,3 +219,7 @@ func (itr *_UnixFSBasicDir__ListItr) Next() (int64, dagpb.PBLink, error) {
 func (itr *_UnixFSBasicDir__ListItr) Done() bool {
 	return itr._substrate.Done()
 }
+
+func (itr *_UnixFSBasicDir__ListItr) Count() (int64, bool) {
+	return itr.length, true
+}
@@ -0,0 +1,97 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+)
+
+// DuplicateNamePolicy decides how a basic directory with more than one entry
+// of the same name is read. Such directories can't be made by go-unixfs or
+// Kubo, but dag-pb doesn't rule them out.
+type DuplicateNamePolicy int
+
+const (
+	// FirstWins looks names up to the first entry of the name, in the order of
+	// the directory's links. This is the default, and matches path resolution
+	// in go-unixfs and Kubo.
+	FirstWins DuplicateNamePolicy = iota
+	// LastWins looks names up to the last entry of the name.
+	LastWins
+	// RejectDuplicates fails reification of a directory with entries of the
+	// same name with ErrDuplicateName.
+	RejectDuplicates
+)
+
+func (p DuplicateNamePolicy) String() string {
+	switch p {
+	case FirstWins:
+		return "first-wins"
+	case LastWins:
+		return "last-wins"
+	case RejectDuplicates:
+		return "reject-duplicates"
+	default:
+		return fmt.Sprintf("DuplicateNamePolicy(%d)", int(p))
+	}
+}
+
+// ErrDuplicateName is returned when a directory read with RejectDuplicates
+// has more than one entry of the same name. It is data.ErrDuplicateName, which
+// the builders also return for directories built with duplicate names.
+type ErrDuplicateName = data.ErrDuplicateName
+
+type duplicateNamePolicyKey struct{}
+
+// WithDuplicateNamePolicy returns a context carrying p as the policy for
+// basic directories created with it, either directly or through reification
+// on a LinkSystem. Iteration still yields every entry, whatever the policy.
+func WithDuplicateNamePolicy(ctx context.Context, p DuplicateNamePolicy) context.Context {
+	return context.WithValue(ctx, duplicateNamePolicyKey{}, p)
+}
+
+func duplicateNamePolicyFrom(ctx context.Context) DuplicateNamePolicy {
+	if ctx == nil {
+		return FirstWins
+	}
+	p, _ := ctx.Value(duplicateNamePolicyKey{}).(DuplicateNamePolicy)
+	return p
+}
+
+// checkDuplicateNames returns ErrDuplicateName for the first name that
+// appears more than once in links.
+func checkDuplicateNames(links dagpb.PBLinks) error {
+	seen := make(map[string]struct{}, links.Length())
+	li := links.Iterator()
+	for !li.Done() {
+		_, next := li.Next()
+		name := ""
+		if next.FieldName().Exists() {
+			name = next.FieldName().Must().String()
+		}
+		if _, ok := seen[name]; ok {
+			return ErrDuplicateName{Name: name}
+		}
+		seen[name] = struct{}{}
+	}
+	return nil
+}
+
+// lookupAll returns the links of every entry named key, in link order.
+func lookupAll(links dagpb.PBLinks, key string) []dagpb.Link {
+	var matches []dagpb.Link
+	li := links.Iterator()
+	for !li.Done() {
+		_, next := li.Next()
+		name := ""
+		if next.FieldName().Exists() {
+			name = next.FieldName().Must().String()
+		}
+		if key == name {
+			matches = append(matches, next.FieldHash())
+		}
+	}
+	return matches
+}
@@ -0,0 +1,55 @@
+package directory
+
+import (
+	"github.com/ipfs/go-unixfsnode/iter"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+)
+
+// ReverseMapIterator iterates over the entries of the directory from the last
+// link to the first. dag-pb sorts links by name when encoding, so this is
+// reverse name order, and the last entries of a listing can be read without
+// reading the others.
+func (n UnixFSBasicDir) ReverseMapIterator() ipld.MapIterator {
+	return iter.NewUnixFSDirMapIterator(n.reverseListItr(), nil)
+}
+
+// ReverseIterator is the native form of ReverseMapIterator.
+func (n UnixFSBasicDir) ReverseIterator() *iter.UnixFSDir__Itr {
+	return iter.NewUnixFSDirIterator(n.reverseListItr(), nil)
+}
+
+// ReverseRawIterator is ReverseMapIterator without allocating nodes for
+// entries, as RawIterator is to MapIterator.
+func (n UnixFSBasicDir) ReverseRawIterator() *iter.UnixFSDir__RawItr {
+	return iter.NewUnixFSDirRawIterator(n.reverseListItr(), 0)
+}
+
+func (n UnixFSBasicDir) reverseListItr() *_UnixFSBasicDir__ReverseListItr {
+	links := n._substrate.FieldLinks()
+	return &_UnixFSBasicDir__ReverseListItr{links: links, next: links.Length() - 1}
+}
+
+// _UnixFSBasicDir__ReverseListItr yields the links of a directory from the
+// last, with their indexes among the links.
+type _UnixFSBasicDir__ReverseListItr struct {
+	links dagpb.PBLinks
+	next  int64
+}
+
+func (itr *_UnixFSBasicDir__ReverseListItr) Next() (int64, dagpb.PBLink, error) {
+	if itr.Done() {
+		return -1, nil, nil
+	}
+	idx := itr.next
+	itr.next--
+	return idx, itr.links.Lookup(idx), nil
+}
+
+func (itr *_UnixFSBasicDir__ReverseListItr) Done() bool {
+	return itr.next < 0
+}
+
+func (itr *_UnixFSBasicDir__ReverseListItr) Count() (int64, bool) {
+	return itr.links.Length(), true
+}
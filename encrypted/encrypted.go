@@ -0,0 +1,265 @@
+// Package encrypted stores UnixFS data with encrypted file content, and
+// optionally encrypted directory entry names, while keeping the standard
+// UnixFS structure of File, Directory and HAMTShard nodes.
+//
+// Leaves are encrypted with AES-256-GCM and stored under the aes-gcm-256
+// multicodec, which marks them as encrypted; everything else about the DAG,
+// including the sizes recorded in File nodes, is the same as for an
+// unencrypted import. The key from a KeyProvider is not used directly: an
+// encryption key and a separate key for deriving nonces are derived from it
+// with HKDF-SHA256. Encryption is deterministic: the nonce of a leaf or name
+// is derived from a keyed hash of its plaintext, so the same content imported
+// twice with the same key produces the same CIDs and deduplicates, at the cost
+// of revealing to anyone holding the blocks which leaves and names are equal.
+//
+// Reading is transparent once a KeyProvider is attached to a LinkSystem with
+// AddDecryptionToLinkSystem, or to the context of a file node with
+// WithKeyProvider.
+package encrypted
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/file"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multicodec"
+	multihash "github.com/multiformats/go-multihash/core"
+	"golang.org/x/crypto/hkdf"
+)
+
+// KeySize is the size of the keys returned by a KeyProvider.
+const KeySize = 32
+
+const nonceSize = 12
+
+// HKDF info strings for the keys derived from the key of a KeyProvider
+const (
+	encryptionKeyInfo = "go-unixfsnode encrypted aes-256-gcm key"
+	nonceKeyInfo      = "go-unixfsnode encrypted nonce hmac-sha256 key"
+)
+
+// domain separation for the keyed hash used to derive nonces
+const (
+	leafDomain byte = iota
+	nameDomain
+)
+
+type errorType string
+
+func (e errorType) Error() string {
+	return string(e)
+}
+
+const (
+	// ErrInvalidKey is returned when a KeyProvider returns a key that is not
+	// KeySize bytes long.
+	ErrInvalidKey errorType = "encryption key must be 32 bytes"
+	// ErrDecryptionFailed is returned when a leaf cannot be decrypted with the
+	// provided key.
+	ErrDecryptionFailed errorType = "failed to decrypt block"
+)
+
+// KeyProvider supplies the AES-256 key used to encrypt and decrypt a DAG.
+type KeyProvider interface {
+	Key(ctx context.Context) ([]byte, error)
+}
+
+// StaticKey is a KeyProvider that always returns the same key.
+type StaticKey []byte
+
+// Key returns the key.
+func (k StaticKey) Key(context.Context) ([]byte, error) {
+	return k, nil
+}
+
+// LeafLinkPrototype is the link prototype encrypted leaves are stored under.
+var LeafLinkPrototype = cidlink.LinkPrototype{
+	Prefix: cid.Prefix{
+		Version:  1,
+		Codec:    uint64(multicodec.AesGcm256),
+		MhType:   multihash.SHA2_256,
+		MhLength: 32,
+	},
+}
+
+type sealer struct {
+	// nonceKey keys the hash nonces are derived from
+	nonceKey []byte
+	aead     cipher.AEAD
+}
+
+func newSealer(ctx context.Context, kp KeyProvider) (*sealer, error) {
+	key, err := kp.Key(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	encKey, err := deriveKey(key, encryptionKeyInfo)
+	if err != nil {
+		return nil, err
+	}
+	nonceKey, err := deriveKey(key, nonceKeyInfo)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &sealer{nonceKey: nonceKey, aead: aead}, nil
+}
+
+// deriveKey derives a KeySize key for the use named by info from key.
+func deriveKey(key []byte, info string) ([]byte, error) {
+	derived := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(info)), derived); err != nil {
+		return nil, err
+	}
+	return derived, nil
+}
+
+// lazySealer makes a sealer from a KeyProvider the first time one is needed,
+// and keeps it, so that a file or directory fetches its key and sets up its
+// cipher once rather than for every leaf or name.
+type lazySealer struct {
+	kp KeyProvider
+	lk sync.Mutex
+	s  *sealer
+}
+
+func (l *lazySealer) get(ctx context.Context) (*sealer, error) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	if l.s != nil {
+		return l.s, nil
+	}
+	s, err := newSealer(ctx, l.kp)
+	if err != nil {
+		return nil, err
+	}
+	l.s = s
+	return s, nil
+}
+
+func (s *sealer) seal(domain byte, plaintext []byte) []byte {
+	mac := hmac.New(sha256.New, s.nonceKey)
+	mac.Write([]byte{domain})
+	mac.Write(plaintext)
+	nonce := mac.Sum(nil)[:nonceSize]
+	return s.aead.Seal(nonce, nonce, plaintext, []byte{domain})
+}
+
+func (s *sealer) open(domain byte, sealed []byte) ([]byte, error) {
+	if len(sealed) < nonceSize+s.aead.Overhead() {
+		return nil, ErrDecryptionFailed
+	}
+	plaintext, err := s.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte{domain})
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// LeafEncoder returns a builder.LeafEncoder that encrypts leaves with the key
+// from kp. The key is fetched once, when the encoder is created.
+func LeafEncoder(ctx context.Context, kp KeyProvider) (builder.LeafEncoder, error) {
+	s, err := newSealer(ctx, kp)
+	if err != nil {
+		return nil, err
+	}
+	return func(leaf []byte) ([]byte, cidlink.LinkPrototype, error) {
+		return s.seal(leafDomain, leaf), LeafLinkPrototype, nil
+	}, nil
+}
+
+// LeafDecoder returns a file.LeafDecoder that decrypts leaves with the key
+// from kp. The key is fetched when the first leaf is decoded, and kept for the
+// rest.
+func LeafDecoder(kp KeyProvider) file.LeafDecoder {
+	ls := &lazySealer{kp: kp}
+	return func(ctx context.Context, c cid.Cid, block []byte) ([]byte, error) {
+		s, err := ls.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := s.open(leafDomain, block)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c, err)
+		}
+		return plaintext, nil
+	}
+}
+
+// BuildFile imports the content of r as a UnixFS file with encrypted leaves.
+// It is builder.BuildUnixFSFile with an encrypting leaf encoder.
+func BuildFile(ctx context.Context, r io.Reader, chunker string, ls *ipld.LinkSystem, kp KeyProvider) (ipld.Link, uint64, error) {
+	enc, err := LeafEncoder(ctx, kp)
+	if err != nil {
+		return nil, 0, err
+	}
+	return builder.BuildUnixFSFile(r, chunker, ls, builder.WithLeafEncoder(enc))
+}
+
+// EncryptName encrypts a directory entry name. The result is unpadded
+// URL-safe base64, so it is a valid entry name in any directory.
+func EncryptName(ctx context.Context, kp KeyProvider, name string) (string, error) {
+	s, err := newSealer(ctx, kp)
+	if err != nil {
+		return "", err
+	}
+	return s.encryptName(name), nil
+}
+
+func (s *sealer) encryptName(name string) string {
+	return base64.RawURLEncoding.EncodeToString(s.seal(nameDomain, []byte(name)))
+}
+
+// DecryptName reverses EncryptName. It returns ErrDecryptionFailed if name
+// was not encrypted with the key from kp.
+func DecryptName(ctx context.Context, kp KeyProvider, name string) (string, error) {
+	s, err := newSealer(ctx, kp)
+	if err != nil {
+		return "", err
+	}
+	return s.decryptName(name)
+}
+
+func (s *sealer) decryptName(name string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(name)
+	if err != nil {
+		return "", ErrDecryptionFailed
+	}
+	plaintext, err := s.open(nameDomain, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// BuildDirectoryEntry is builder.BuildUnixFSDirectoryEntry with the name
+// encrypted by EncryptName.
+func BuildDirectoryEntry(ctx context.Context, kp KeyProvider, name string, size int64, hash ipld.Link) (dagpb.PBLink, error) {
+	encName, err := EncryptName(ctx, kp, name)
+	if err != nil {
+		return nil, err
+	}
+	return builder.BuildUnixFSDirectoryEntry(encName, size, hash)
+}
@@ -0,0 +1,246 @@
+package encrypted_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/encrypted"
+	"github.com/ipfs/go-unixfsnode/file"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func mkLinkSystem() (*ipld.LinkSystem, *cidlink.Memory) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := &cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+	return &ls, storage
+}
+
+func readAll(t *testing.T, ls *ipld.LinkSystem, lnk ipld.Link) []byte {
+	var proto datamodel.NodePrototype = dagpb.Type.PBNode
+	if lnk.(cidlink.Link).Cid.Prefix().Codec == cid.Raw {
+		proto = basicnode.Prototype.Bytes
+	}
+	nd, err := ls.Load(ipld.LinkContext{}, lnk, proto)
+	require.NoError(t, err)
+	lbn, ok := nd.(datamodel.LargeBytesNode)
+	if !ok {
+		byts, err := nd.AsBytes()
+		require.NoError(t, err)
+		return byts
+	}
+	rdr, err := lbn.AsLargeBytes()
+	require.NoError(t, err)
+	byts, err := io.ReadAll(rdr)
+	require.NoError(t, err)
+	return byts
+}
+
+func TestEncryptedFile(t *testing.T) {
+	ctx := context.Background()
+	ls, storage := mkLinkSystem()
+	key := encrypted.StaticKey(random.Bytes(encrypted.KeySize))
+
+	for _, size := range []int{10, 1 << 20} {
+		t.Run(fmt.Sprintf("size=%d", size), func(t *testing.T) {
+			content := random.Bytes(size)
+			root, _, err := encrypted.BuildFile(ctx, bytes.NewReader(content), "size-1024", ls, key)
+			require.NoError(t, err)
+			// even a single leaf file gets a File node, to mark the leaf as encrypted
+			require.Equal(t, uint64(cid.DagProtobuf), root.(cidlink.Link).Cid.Prefix().Codec)
+
+			// deterministic, so a second import produces the same root
+			again, _, err := encrypted.BuildFile(ctx, bytes.NewReader(content), "size-1024", ls, key)
+			require.NoError(t, err)
+			require.Equal(t, root, again)
+
+			// the plaintext isn't stored anywhere
+			for _, blk := range storage.Bag {
+				require.False(t, bytes.Contains(blk, content[:min(size, 64)]))
+			}
+
+			reading := *ls
+			encrypted.AddDecryptionToLinkSystem(&reading, key)
+			require.Equal(t, content, readAll(t, &reading, root))
+
+			// seeking only decrypts the leaves that are read
+			nd, err := reading.Load(ipld.LinkContext{}, root, dagpb.Type.PBNode)
+			require.NoError(t, err)
+			rdr, err := nd.(datamodel.LargeBytesNode).AsLargeBytes()
+			require.NoError(t, err)
+			_, err = rdr.Seek(int64(size/2), io.SeekStart)
+			require.NoError(t, err)
+			buf := make([]byte, min(size/2, 5000))
+			_, err = io.ReadFull(rdr, buf)
+			require.NoError(t, err)
+			require.Equal(t, content[size/2:size/2+len(buf)], buf)
+
+			// the wrong key can't read it
+			wrong := *ls
+			encrypted.AddDecryptionToLinkSystem(&wrong, encrypted.StaticKey(random.Bytes(encrypted.KeySize)))
+			nd, err = wrong.Load(ipld.LinkContext{}, root, dagpb.Type.PBNode)
+			require.NoError(t, err)
+			_, err = nd.AsBytes()
+			require.ErrorIs(t, err, encrypted.ErrDecryptionFailed)
+		})
+	}
+}
+
+func TestEncryptedFileWithContext(t *testing.T) {
+	ctx := context.Background()
+	ls, _ := mkLinkSystem()
+	key := encrypted.StaticKey(random.Bytes(encrypted.KeySize))
+	content := random.Bytes(100000)
+	root, _, err := encrypted.BuildFile(ctx, bytes.NewReader(content), "", ls, key)
+	require.NoError(t, err)
+
+	substrate, err := ls.Load(ipld.LinkContext{}, root, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	nd, err := file.NewUnixFSFile(encrypted.WithKeyProvider(ctx, key), substrate, ls)
+	require.NoError(t, err)
+	byts, err := nd.AsBytes()
+	require.NoError(t, err)
+	require.Equal(t, content, byts)
+}
+
+func TestEncryptedNames(t *testing.T) {
+	ctx := context.Background()
+	key := encrypted.StaticKey(random.Bytes(encrypted.KeySize))
+
+	for _, sharded := range []bool{false, true} {
+		t.Run(fmt.Sprintf("sharded=%t", sharded), func(t *testing.T) {
+			ls, _ := mkLinkSystem()
+			files := make(map[string][]byte)
+			var entries []dagpb.PBLink
+			for i := 0; i < 50; i++ {
+				name := fmt.Sprintf("file-%d.txt", i)
+				files[name] = random.Bytes(100)
+				lnk, sz, err := encrypted.BuildFile(ctx, bytes.NewReader(files[name]), "", ls, key)
+				require.NoError(t, err)
+				entry, err := encrypted.BuildDirectoryEntry(ctx, key, name, int64(sz), lnk)
+				require.NoError(t, err)
+				require.NotContains(t, entry.FieldName().Must().String(), "file")
+				entries = append(entries, entry)
+			}
+			// a name that isn't encrypted is presented as-is
+			plainLnk, plainSize, err := builder.BuildUnixFSFile(bytes.NewReader([]byte("plain")), "", ls)
+			require.NoError(t, err)
+			plain, err := builder.BuildUnixFSDirectoryEntry("README", int64(plainSize), plainLnk)
+			require.NoError(t, err)
+			entries = append(entries, plain)
+			files["README"] = []byte("plain")
+
+			var root ipld.Link
+			if sharded {
+				root, _, err = builder.BuildUnixFSShardedDirectory(16, multihash.MURMUR3X64_64, entries, ls)
+			} else {
+				root, _, err = builder.BuildUnixFSDirectory(entries, ls)
+			}
+			require.NoError(t, err)
+
+			reading := *ls
+			encrypted.AddDecryptionToLinkSystem(&reading, key)
+			dir, err := reading.Load(ipld.LinkContext{}, root, dagpb.Type.PBNode)
+			require.NoError(t, err)
+
+			seen := make(map[string]struct{})
+			itr := dir.MapIterator()
+			for !itr.Done() {
+				k, _, err := itr.Next()
+				require.NoError(t, err)
+				name, err := k.AsString()
+				require.NoError(t, err)
+				seen[name] = struct{}{}
+			}
+			require.Len(t, seen, len(files))
+
+			for name, content := range files {
+				require.Contains(t, seen, name)
+				child, err := dir.LookupByString(name)
+				require.NoError(t, err)
+				lnk, err := child.AsLink()
+				require.NoError(t, err)
+				require.Equal(t, content, readAll(t, &reading, lnk))
+			}
+			_, err = dir.LookupByString("missing")
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	ls, _ := mkLinkSystem()
+	_, _, err := encrypted.BuildFile(context.Background(), bytes.NewReader([]byte("hi")), "", ls, encrypted.StaticKey([]byte("short")))
+	require.ErrorIs(t, err, encrypted.ErrInvalidKey)
+}
+
+type countingKey struct {
+	encrypted.StaticKey
+	calls int
+}
+
+func (k *countingKey) Key(ctx context.Context) ([]byte, error) {
+	k.calls++
+	return k.StaticKey.Key(ctx)
+}
+
+func TestEncryptedFileKeyUse(t *testing.T) {
+	ctx := context.Background()
+	ls, storage := mkLinkSystem()
+	var leaves [][]byte
+	ls.StorageWriteOpener = func(lctx ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+		w, commit, err := storage.OpenWrite(lctx)
+		return w, func(lnk ipld.Link) error {
+			if err := commit(lnk); err != nil {
+				return err
+			}
+			if lnk.(cidlink.Link).Cid.Prefix().Codec == uint64(multicodec.AesGcm256) {
+				leaves = append(leaves, storage.Bag[string(lnk.(cidlink.Link).Cid.Hash())])
+			}
+			return nil
+		}, err
+	}
+	key := &countingKey{StaticKey: random.Bytes(encrypted.KeySize)}
+	content := random.Bytes(100000)
+	root, _, err := encrypted.BuildFile(ctx, bytes.NewReader(content), "size-1024", ls, key)
+	require.NoError(t, err)
+	require.Equal(t, 1, key.calls)
+	require.Greater(t, len(leaves), 1)
+
+	// the key is fetched once for the whole file, not for every leaf
+	key.calls = 0
+	substrate, err := ls.Load(ipld.LinkContext{}, root, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	nd, err := file.NewUnixFSFile(encrypted.WithKeyProvider(ctx, key), substrate, ls)
+	require.NoError(t, err)
+	byts, err := nd.AsBytes()
+	require.NoError(t, err)
+	require.Equal(t, content, byts)
+	require.Equal(t, 1, key.calls)
+
+	// leaves aren't encrypted with the key itself, but with one derived from it
+	block, err := aes.NewCipher(key.StaticKey)
+	require.NoError(t, err)
+	aead, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	for _, leaf := range leaves {
+		_, err = aead.Open(nil, leaf[:12], leaf[12:], []byte{0})
+		require.Error(t, err)
+	}
+}
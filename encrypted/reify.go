@@ -0,0 +1,127 @@
+package encrypted
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/directory"
+	"github.com/ipfs/go-unixfsnode/file"
+	"github.com/ipfs/go-unixfsnode/hamt"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/adl"
+	"github.com/ipld/go-ipld-prime/linking"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/schema"
+	"github.com/multiformats/go-multicodec"
+)
+
+// WithKeyProvider returns a context that lets file nodes created with it
+// decrypt leaves using the key from kp.
+func WithKeyProvider(ctx context.Context, kp KeyProvider) context.Context {
+	return file.WithLeafDecoder(ctx, uint64(multicodec.AesGcm256), LeafDecoder(kp))
+}
+
+// Reifier returns a NodeReifier that behaves like unixfsnode.Reify, but
+// decrypts file leaves and directory entry names using the key from kp.
+// Entry names that were not encrypted are presented unchanged.
+func Reifier(kp KeyProvider) linking.NodeReifier {
+	return func(lnkCtx linking.LinkContext, n ipld.Node, lsys *ipld.LinkSystem) (ipld.Node, error) {
+		ctx := lnkCtx.Ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		lnkCtx.Ctx = WithKeyProvider(ctx, kp)
+		nd, err := unixfsnode.Reify(lnkCtx, n, lsys)
+		if err != nil {
+			return nil, err
+		}
+		switch nd.(type) {
+		case directory.UnixFSBasicDir, hamt.UnixFSHAMTShard:
+			return &decryptedDir{Node: nd, ctx: lnkCtx.Ctx, sealer: &lazySealer{kp: kp}}, nil
+		default:
+			return nd, nil
+		}
+	}
+}
+
+// AddDecryptionToLinkSystem sets the NodeReifier of lsys, and the "unixfs"
+// reifier used by InterpretAs selector clauses, to Reifier(kp).
+func AddDecryptionToLinkSystem(lsys *ipld.LinkSystem, kp KeyProvider) {
+	unixfsnode.AddUnixFSReificationToLinkSystem(lsys)
+	lsys.NodeReifier = Reifier(kp)
+	lsys.KnownReifiers["unixfs"] = Reifier(kp)
+}
+
+// decryptedDir presents a directory with its entry names decrypted.
+type decryptedDir struct {
+	ipld.Node
+	ctx    context.Context
+	sealer *lazySealer
+}
+
+var _ adl.ADL = (*decryptedDir)(nil)
+
+func (d *decryptedDir) LookupByString(key string) (ipld.Node, error) {
+	s, err := d.sealer.get(d.ctx)
+	if err != nil {
+		return nil, err
+	}
+	nd, err := d.Node.LookupByString(s.encryptName(key))
+	if err == nil {
+		return nd, nil
+	}
+	var nsf schema.ErrNoSuchField
+	if !errors.As(err, &nsf) {
+		return nil, err
+	}
+	return d.Node.LookupByString(key)
+}
+
+func (d *decryptedDir) LookupByNode(key ipld.Node) (ipld.Node, error) {
+	ks, err := key.AsString()
+	if err != nil {
+		return nil, err
+	}
+	return d.LookupByString(ks)
+}
+
+func (d *decryptedDir) LookupBySegment(seg ipld.PathSegment) (ipld.Node, error) {
+	return d.LookupByString(seg.String())
+}
+
+func (d *decryptedDir) MapIterator() ipld.MapIterator {
+	return &decryptedMapIterator{d.Node.MapIterator(), d}
+}
+
+// Substrate returns the underlying dag-pb node.
+func (d *decryptedDir) Substrate() ipld.Node {
+	return d.Node.(adl.ADL).Substrate()
+}
+
+type decryptedMapIterator struct {
+	ipld.MapIterator
+	d *decryptedDir
+}
+
+func (itr *decryptedMapIterator) Next() (ipld.Node, ipld.Node, error) {
+	k, v, err := itr.MapIterator.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+	encName, err := k.AsString()
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := itr.d.sealer.get(itr.d.ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	name, err := s.decryptName(encName)
+	if errors.Is(err, ErrDecryptionFailed) {
+		return k, v, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return basicnode.NewString(name), v, nil
+}
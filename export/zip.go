@@ -0,0 +1,258 @@
+// Package export writes UnixFS DAGs out in other formats.
+package export
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/file"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+type zipOptions struct {
+	level    int
+	rootName string
+}
+
+// ZipOption configures WriteZip.
+type ZipOption func(*zipOptions)
+
+// WithCompressionLevel sets the level files are deflated at, from
+// flate.HuffmanOnly to flate.BestCompression. flate.NoCompression stores
+// files uncompressed. The default is flate.DefaultCompression.
+func WithCompressionLevel(level int) ZipOption {
+	return func(o *zipOptions) {
+		o.level = level
+	}
+}
+
+// WithRootName places the root in the archive under name. Without it, the
+// entries of a root directory are written at the top of the archive, and a
+// root file is named by its CID.
+func WithRootName(name string) ZipOption {
+	return func(o *zipOptions) {
+		o.rootName = name
+	}
+}
+
+type zipExporter struct {
+	ctx    context.Context
+	lsys   *ipld.LinkSystem
+	lnkCtx linking.LinkContext
+	zw     *zip.Writer
+	method uint16
+}
+
+// WriteZip writes the file or directory at root to w as a zip archive.
+//
+// Files are streamed into the archive one at a time. Their sizes are taken
+// from the UnixFS metadata, and writing fails if the content read doesn't
+// match. Modes and modification times are carried over where they are set,
+// and symlinks are written as zip symlinks. Entries whose names aren't a
+// single valid path element, and symlinks whose targets are absolute or lead
+// out of the archive, are rejected, so that the archive can't escape the
+// directory it's extracted into.
+func WriteZip(ctx context.Context, w io.Writer, lsys *ipld.LinkSystem, root ipld.Link, opts ...ZipOption) error {
+	o := zipOptions{level: flate.DefaultCompression}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.level < flate.HuffmanOnly || o.level > flate.BestCompression {
+		return fmt.Errorf("invalid compression level %d", o.level)
+	}
+	ze := &zipExporter{
+		ctx:    ctx,
+		lsys:   lsys,
+		lnkCtx: linking.LinkContext{Ctx: ctx},
+		zw:     zip.NewWriter(w),
+		method: zip.Deflate,
+	}
+	if o.level == flate.NoCompression {
+		ze.method = zip.Store
+	} else {
+		ze.zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, o.level)
+		})
+	}
+
+	name := o.rootName
+	if name != "" && !validName(name) {
+		return fmt.Errorf("invalid root name %q", name)
+	}
+	if err := ze.write(name, root, true); err != nil {
+		return err
+	}
+	return ze.zw.Close()
+}
+
+func validName(name string) bool {
+	return name != "." && fs.ValidPath(name) && !strings.Contains(name, "/")
+}
+
+func (ze *zipExporter) write(name string, lnk ipld.Link, root bool) error {
+	if err := ze.ctx.Err(); err != nil {
+		return err
+	}
+	cl, ok := lnk.(cidlink.Link)
+	if !ok {
+		return fmt.Errorf("%s: unsupported link type %T", name, lnk)
+	}
+	if cl.Cid.Prefix().Codec == cid.Raw {
+		block, err := ze.lsys.LoadRaw(ze.lnkCtx, lnk)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			name = cl.Cid.String()
+		}
+		hdr := &zip.FileHeader{Name: name, Method: ze.method, UncompressedSize64: uint64(len(block))}
+		hdr.SetMode(data.FilePermissionsDefault)
+		fw, err := ze.zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(block)
+		return err
+	}
+
+	block, err := ze.lsys.LoadRaw(ze.lnkCtx, lnk)
+	if err != nil {
+		return err
+	}
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagpb.DecodeBytes(nb, block); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	pbn := nb.Build().(dagpb.PBNode)
+	if !pbn.FieldData().Exists() {
+		return fmt.Errorf("%s: not a UnixFS node", name)
+	}
+	ufsd, err := data.DecodeUnixFSData(pbn.FieldData().Must().Bytes())
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	hdr := &zip.FileHeader{Name: name, Modified: ufsd.ModTime()}
+	mode := ufsd.FileMode()
+	if ufsd.FieldDataType().Int() == data.Data_Raw && !ufsd.FieldMode().Exists() {
+		// raw nodes have no default permissions of their own, but are files
+		mode = data.FileModeFromMode(data.Data_File, data.FilePermissionsDefault)
+	}
+
+	switch ufsd.FieldDataType().Int() {
+	case data.Data_Directory, data.Data_HAMTShard:
+		if name != "" {
+			hdr.Name += "/"
+			hdr.SetMode(mode)
+			if _, err := ze.zw.CreateHeader(hdr); err != nil {
+				return err
+			}
+		}
+		dir, err := unixfsnode.Reify(ze.lnkCtx, pbn, ze.lsys)
+		if err != nil {
+			return err
+		}
+		itr := dir.MapIterator()
+		for !itr.Done() {
+			k, v, err := itr.Next()
+			if err != nil {
+				return err
+			}
+			entry, err := k.AsString()
+			if err != nil {
+				return err
+			}
+			if !validName(entry) {
+				return fmt.Errorf("%s: invalid entry name %q", name, entry)
+			}
+			child, err := v.AsLink()
+			if err != nil {
+				return err
+			}
+			if err := ze.write(path.Join(name, entry), child, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	case data.Data_File, data.Data_Raw:
+		if root && name == "" {
+			hdr.Name = cl.Cid.String()
+		}
+		var size uint64
+		if ufsd.FieldFileSize().Exists() {
+			size = uint64(ufsd.FieldFileSize().Must().Int())
+		} else if ufsd.FieldData().Exists() {
+			size = uint64(len(ufsd.FieldData().Must().Bytes()))
+		}
+		hdr.Method = ze.method
+		hdr.UncompressedSize64 = size
+		hdr.SetMode(mode)
+		f, err := file.NewUnixFSFile(ze.ctx, pbn, ze.lsys)
+		if err != nil {
+			return err
+		}
+		r, err := f.AsLargeBytes()
+		if err != nil {
+			return err
+		}
+		fw, err := ze.zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(fw, r)
+		if err != nil {
+			return err
+		}
+		if uint64(n) != size {
+			return fmt.Errorf("%s: file is %d bytes, but its metadata says %d", hdr.Name, n, size)
+		}
+		return nil
+	case data.Data_Symlink:
+		if root && name == "" {
+			return fmt.Errorf("a symlink can't be the root without a name")
+		}
+		var target []byte
+		if ufsd.FieldData().Exists() {
+			target = ufsd.FieldData().Must().Bytes()
+		}
+		if escapes(name, string(target)) {
+			return fmt.Errorf("%s: symlink target %q leads out of the archive", name, target)
+		}
+		hdr.Method = zip.Store
+		if !ufsd.FieldMode().Exists() {
+			// symlinks are usually stored without a mode, as their
+			// permissions aren't used
+			mode |= 0o777
+		}
+		hdr.SetMode(mode)
+		fw, err := ze.zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(target)
+		return err
+	default:
+		return fmt.Errorf("%s: cannot export UnixFS %s node", name, data.DataTypeNames[ufsd.FieldDataType().Int()])
+	}
+}
+
+// escapes reports whether the target of the symlink at name is absolute, or
+// leads out of the top of the archive.
+func escapes(name, target string) bool {
+	if path.IsAbs(target) {
+		return true
+	}
+	p := path.Join(path.Dir(name), target)
+	return p == ".." || strings.HasPrefix(p, "../")
+}
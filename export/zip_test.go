@@ -0,0 +1,209 @@
+package export_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/export"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func entry(t *testing.T, name string, lnk ipld.Link, size uint64) dagpb.PBLink {
+	e, err := builder.BuildUnixFSDirectoryEntry(name, int64(size), lnk)
+	require.NoError(t, err)
+	return e
+}
+
+// storeUnixFS stores a dag-pb node with no links holding ufsd.
+func storeUnixFS(t *testing.T, ls *ipld.LinkSystem, ufsd data.UnixFSData) ipld.Link {
+	pbb := dagpb.Type.PBNode.NewBuilder()
+	pbm, err := pbb.BeginMap(2)
+	require.NoError(t, err)
+	require.NoError(t, pbm.AssembleKey().AssignString("Data"))
+	require.NoError(t, pbm.AssembleValue().AssignBytes(data.EncodeUnixFSData(ufsd)))
+	la, err := pbm.AssembleEntry("Links")
+	require.NoError(t, err)
+	links, err := la.BeginList(0)
+	require.NoError(t, err)
+	require.NoError(t, links.Finish())
+	require.NoError(t, pbm.Finish())
+	lnk, err := ls.Store(ipld.LinkContext{}, cidlink.LinkPrototype{Prefix: builder.DefaultInteriorLinkPrototype.Prefix}, pbb.Build())
+	require.NoError(t, err)
+	return lnk
+}
+
+func readZip(t *testing.T, b []byte) map[string]*zip.File {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	require.NoError(t, err)
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	return files
+}
+
+func zipContent(t *testing.T, f *zip.File) []byte {
+	rc, err := f.Open()
+	require.NoError(t, err)
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return b
+}
+
+func TestWriteZip(t *testing.T) {
+	ctx := context.Background()
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	mtime := time.Date(2023, 5, 6, 7, 8, 10, 0, time.UTC)
+	small := []byte("hello")
+	big := random.Bytes(500000)
+	smallLnk, smallSize, err := builder.BuildUnixFSFile(bytes.NewReader(small), "", &ls)
+	require.NoError(t, err)
+	bigLnk, bigSize, err := builder.BuildUnixFSFile(bytes.NewReader(big), "size-65536", &ls, builder.WithMtime(mtime))
+	require.NoError(t, err)
+	linkLnk, linkSize, err := builder.BuildUnixFSSymlink("../small", &ls)
+	require.NoError(t, err)
+
+	var shardEntries []dagpb.PBLink
+	for i := 0; i < 20; i++ {
+		shardEntries = append(shardEntries, entry(t, fmt.Sprintf("%d", i), smallLnk, smallSize))
+	}
+	shard, shardSize, err := builder.BuildUnixFSShardedDirectory(16, multihash.MURMUR3X64_64, shardEntries, &ls)
+	require.NoError(t, err)
+	sub, subSize, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{
+		entry(t, "big", bigLnk, bigSize),
+		entry(t, "link", linkLnk, linkSize),
+		entry(t, "shard", shard, shardSize),
+	}, &ls, builder.WithDirectoryMtime(mtime))
+	require.NoError(t, err)
+	root, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{
+		entry(t, "small", smallLnk, smallSize),
+		entry(t, "sub", sub, subSize),
+	}, &ls)
+	require.NoError(t, err)
+
+	for _, level := range []int{flate.NoCompression, flate.BestSpeed, flate.DefaultCompression} {
+		var buf bytes.Buffer
+		require.NoError(t, export.WriteZip(ctx, &buf, &ls, root, export.WithCompressionLevel(level)))
+		files := readZip(t, buf.Bytes())
+		require.Len(t, files, 25)
+
+		require.Equal(t, small, zipContent(t, files["small"]))
+		require.Equal(t, big, zipContent(t, files["sub/big"]))
+		require.Equal(t, uint64(len(big)), files["sub/big"].UncompressedSize64)
+		require.True(t, mtime.Equal(files["sub/big"].Modified))
+		if level == flate.NoCompression {
+			require.Equal(t, zip.Store, files["sub/big"].Method)
+		} else {
+			require.Equal(t, zip.Deflate, files["sub/big"].Method)
+		}
+		require.True(t, files["sub/"].Mode().IsDir())
+		require.True(t, mtime.Equal(files["sub/"].Modified))
+		require.Equal(t, fs.ModeSymlink, files["sub/link"].Mode().Type())
+		require.Equal(t, []byte("../small"), zipContent(t, files["sub/link"]))
+		require.True(t, files["sub/shard/"].Mode().IsDir())
+		for i := 0; i < 20; i++ {
+			require.Equal(t, small, zipContent(t, files[fmt.Sprintf("sub/shard/%d", i)]))
+		}
+	}
+
+	// a file on its own is named by its CID, unless a name is given
+	var buf bytes.Buffer
+	require.NoError(t, export.WriteZip(ctx, &buf, &ls, bigLnk))
+	require.Contains(t, readZip(t, buf.Bytes()), bigLnk.String())
+	buf.Reset()
+	require.NoError(t, export.WriteZip(ctx, &buf, &ls, root, export.WithRootName("top")))
+	files := readZip(t, buf.Bytes())
+	require.Contains(t, files, "top/")
+	require.Equal(t, small, zipContent(t, files["top/small"]))
+
+	// a LinkSystem that reifies UnixFS nodes writes the same archive
+	rls := ls
+	rls.NodeReifier = unixfsnode.Reify
+	var reified bytes.Buffer
+	require.NoError(t, export.WriteZip(ctx, &reified, &rls, root, export.WithRootName("top")))
+	require.Equal(t, buf.Bytes(), reified.Bytes())
+
+	require.Error(t, export.WriteZip(ctx, io.Discard, &ls, root, export.WithCompressionLevel(10)))
+	require.Error(t, export.WriteZip(ctx, io.Discard, &ls, root, export.WithRootName("../x")))
+}
+
+func TestWriteZipRejects(t *testing.T) {
+	ctx := context.Background()
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	// a file whose metadata claims more than it holds
+	ufsd, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.SkipFileSizeValidation(b)
+		builder.Data(b, []byte("hello"))
+		builder.FileSize(b, 10)
+	})
+	require.NoError(t, err)
+	short := storeUnixFS(t, &ls, ufsd)
+	err = export.WriteZip(ctx, io.Discard, &ls, short)
+	require.ErrorContains(t, err, "metadata")
+
+	// a name that would escape the directory the archive is extracted into
+	fileLnk, size, err := builder.BuildUnixFSFile(bytes.NewReader([]byte("x")), "", &ls)
+	require.NoError(t, err)
+	dir, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{entry(t, "..", fileLnk, size)}, &ls)
+	require.NoError(t, err)
+	require.Error(t, export.WriteZip(ctx, io.Discard, &ls, dir))
+
+	// symlinks leading out of the archive
+	for _, target := range []string{"/etc/passwd", "..", "../x", "sub/../../x"} {
+		linkLnk, linkSize, err := builder.BuildUnixFSSymlink(target, &ls)
+		require.NoError(t, err)
+		dir, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{entry(t, "link", linkLnk, linkSize)}, &ls)
+		require.NoError(t, err)
+		require.ErrorContains(t, export.WriteZip(ctx, io.Discard, &ls, dir), "leads out of the archive", target)
+	}
+}
+
+func TestWriteZipRawNode(t *testing.T) {
+	ctx := context.Background()
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	ufsd, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.DataType(b, data.Data_Raw)
+		builder.Data(b, []byte("raw"))
+	})
+	require.NoError(t, err)
+	raw := storeUnixFS(t, &ls, ufsd)
+	dir, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{entry(t, "raw", raw, 0)}, &ls)
+	require.NoError(t, err)
+
+	for root, name := range map[ipld.Link]string{raw: raw.String(), dir: "raw"} {
+		var buf bytes.Buffer
+		require.NoError(t, export.WriteZip(ctx, &buf, &ls, root))
+		files := readZip(t, buf.Bytes())
+		require.Contains(t, files, name)
+		require.Equal(t, fs.FileMode(data.FilePermissionsDefault), files[name].Mode())
+		require.Equal(t, []byte("raw"), zipContent(t, files[name]))
+	}
+}
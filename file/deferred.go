@@ -4,11 +4,15 @@ import (
 	"context"
 	"io"
 
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/instrument"
 	dagpb "github.com/ipld/go-codec-dagpb"
 	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
 )
 
-func newDeferredFileNode(ctx context.Context, lsys *ipld.LinkSystem, root ipld.Link) LargeBytesNode {
+func newDeferredFileNode(ctx context.Context, lsys *ipld.LinkSystem, root ipld.Link) *deferredFileNode {
 	dfn := deferredFileNode{
 		LargeBytesNode: nil,
 		root:           root,
@@ -25,13 +29,20 @@ type deferredFileNode struct {
 	root ipld.Link
 	lsys *ipld.LinkSystem
 	ctx  context.Context
+	// onResolve, if set, is called with the node once it has been loaded
+	onResolve func(LargeBytesNode)
 }
 
 func (d *deferredFileNode) resolve() error {
 	if d.lsys == nil {
 		return nil
 	}
-	target, err := d.lsys.Load(ipld.LinkContext{Ctx: d.ctx}, d.root, protoFor(d.root))
+	if cl, ok := d.root.(cidlink.Link); ok {
+		if dec := leafDecoderFor(d.ctx, cl.Cid); dec != nil {
+			return d.resolveEncoded(cl.Cid, dec)
+		}
+	}
+	target, err := instrument.Load(d.ctx, d.lsys, instrument.FileBlock, d.root, protoFor(d.root))
 	if err != nil {
 		return err
 	}
@@ -44,6 +55,27 @@ func (d *deferredFileNode) resolve() error {
 	d.root = nil
 	d.lsys = nil
 	d.ctx = nil
+	if d.onResolve != nil {
+		d.onResolve(asFSNode)
+		d.onResolve = nil
+	}
+	return nil
+}
+
+// resolveEncoded loads an encoded leaf as raw bytes and decodes it.
+func (d *deferredFileNode) resolveEncoded(c cid.Cid, dec LeafDecoder) error {
+	block, err := instrument.LoadRaw(d.ctx, d.lsys, instrument.FileBlock, d.root)
+	if err != nil {
+		return err
+	}
+	content, err := dec(d.ctx, c, block)
+	if err != nil {
+		return err
+	}
+	d.LargeBytesNode = &singleNodeFile{basicnode.NewBytes(content), basicnode.NewBytes(block)}
+	d.root = nil
+	d.lsys = nil
+	d.ctx = nil
 	return nil
 }
 
@@ -100,6 +132,14 @@ func (d *deferred) AsBytes() ([]byte, error) {
 	return d.deferredFileNode.AsBytes()
 }
 
+// Substrate loads the file and returns the node it was read from.
+func (d *deferred) Substrate() ipld.Node {
+	if err := d.deferredFileNode.resolve(); err != nil {
+		return nil
+	}
+	return d.deferredFileNode.Substrate()
+}
+
 func (d *deferred) AsBool() (bool, error) {
 	return false, ipld.ErrWrongKind{TypeName: "bool", MethodName: "AsBool", AppropriateKind: ipld.KindSet_JustBytes}
 }
@@ -13,10 +13,15 @@ import (
 // root of a unixfs File.
 // It provides a `bytes` view over the file, along with access to io.Reader streaming access
 // to file data.
+// See NewUnixFSFileWithPreloadAll for loading all of the file's blocks before it is returned.
 func NewUnixFSFile(ctx context.Context, substrate ipld.Node, lsys *ipld.LinkSystem) (LargeBytesNode, error) {
+	return newUnixFSFile(ctx, substrate, lsys, nil)
+}
+
+func newUnixFSFile(ctx context.Context, substrate ipld.Node, lsys *ipld.LinkSystem, preloadOpts *preloadOptions) (LargeBytesNode, error) {
 	if substrate.Kind() == ipld.Kind_Bytes {
 		// A raw / single-node file.
-		return &singleNodeFile{substrate}, nil
+		return &singleNodeFile{Node: substrate}, nil
 	}
 	// see if it's got children.
 	links, err := substrate.LookupByString("Links")
@@ -28,10 +33,18 @@ func NewUnixFSFile(ctx context.Context, substrate ipld.Node, lsys *ipld.LinkSyst
 		return newWrappedNode(substrate)
 	}
 
+	if preloadOpts != nil {
+		lsys, err = preload(ctx, substrate, lsys, preloadOpts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &shardNodeFile{
 		ctx:       ctx,
 		lsys:      lsys,
 		substrate: substrate,
+		cache:     newNodeCache(),
 	}, nil
 }
 
@@ -55,6 +68,12 @@ func NewUnixFSFileWithPreload(ctx context.Context, substrate ipld.Node, lsys *ip
 }
 
 // A LargeBytesNode is an ipld.Node that can be streamed over. It is guaranteed to have a Bytes type.
+//
+// The file nodes of this package are safe for concurrent use, so a server can share one node
+// between many requests. Each call to AsLargeBytes returns a new reader, with its own offset,
+// that shares the interior nodes loaded most recently with the other readers of the node. A
+// reader is not safe for concurrent use: each goroutine should call AsLargeBytes for a reader of
+// its own.
 type LargeBytesNode interface {
 	adl.ADL
 	AsLargeBytes() (io.ReadSeeker, error)
@@ -62,6 +81,9 @@ type LargeBytesNode interface {
 
 type singleNodeFile struct {
 	ipld.Node
+	// substrate is the node the file was read from, if it isn't Node itself,
+	// such as the dag-pb node of a single-block file
+	substrate ipld.Node
 }
 
 func (f *singleNodeFile) AsLargeBytes() (io.ReadSeeker, error) {
@@ -69,6 +91,9 @@ func (f *singleNodeFile) AsLargeBytes() (io.ReadSeeker, error) {
 }
 
 func (f *singleNodeFile) Substrate() datamodel.Node {
+	if f.substrate != nil {
+		return f.substrate
+	}
 	return f.Node
 }
 
@@ -3,10 +3,14 @@ package file_test
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-test/random"
 	"github.com/ipfs/go-unixfsnode"
 	"github.com/ipfs/go-unixfsnode/data/builder"
@@ -203,3 +207,377 @@ func testSeekIn1024ByteFile(t *testing.T, rs io.ReadSeeker) {
 		t.Fatalf("expected offset %d, got %d", 880, offset)
 	}
 }
+
+func TestSeekReusesInteriorNodes(t *testing.T) {
+	storage := cidlink.Memory{}
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageWriteOpener = storage.OpenWrite
+	content := random.Bytes(3 << 20)
+	// small chunks make a tree several levels deep
+	root, _, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-64", &ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interiorLoads := 0
+	ls.StorageReadOpener = func(lc ipld.LinkContext, l ipld.Link) (io.Reader, error) {
+		if l.(cidlink.Link).Cid.Prefix().Codec == cid.DagProtobuf {
+			interiorLoads++
+		}
+		return storage.OpenRead(lc, l)
+	}
+	substrate, err := ls.Load(ipld.LinkContext{}, root, dagpb.Type.PBNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fnd, err := file.NewUnixFSFile(context.Background(), substrate, &ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := fnd.AsLargeBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offsets := []int64{3 << 19, 1 << 20, 100, 2 << 20, 5000}
+	readAt := func() {
+		buf := make([]byte, 10000)
+		for _, off := range offsets {
+			if _, err := rdr.Seek(off, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadFull(rdr, buf); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(content[off:off+int64(len(buf))], buf) {
+				t.Fatalf("content mismatch at %d", off)
+			}
+		}
+	}
+	readAt()
+	if interiorLoads == 0 {
+		t.Fatal("expected interior nodes to be loaded")
+	}
+	interiorLoads = 0
+	readAt()
+	if interiorLoads != 0 {
+		t.Fatalf("expected no interior nodes to be loaded again, got %d", interiorLoads)
+	}
+}
+
+func TestPreloadAll(t *testing.T) {
+	storage := cidlink.Memory{}
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageWriteOpener = storage.OpenWrite
+	content := random.Bytes(1 << 20)
+	root, _, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-4096", &ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loads := 0
+	missing := make(map[cid.Cid]bool)
+	ls.StorageReadOpener = func(lc ipld.LinkContext, l ipld.Link) (io.Reader, error) {
+		c := l.(cidlink.Link).Cid
+		if missing[c] {
+			return nil, fmt.Errorf("missing %s", c)
+		}
+		loads++
+		return storage.OpenRead(lc, l)
+	}
+	substrate, err := ls.Load(ipld.LinkContext{}, root, dagpb.Type.PBNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := len(storage.Bag)
+
+	t.Run("cached", func(t *testing.T) {
+		loads = 0
+		fnd, err := file.NewUnixFSFileWithPreloadAll(context.Background(), substrate, &ls, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loads != blocks-1 {
+			t.Fatalf("expected %d blocks to be preloaded, got %d", blocks-1, loads)
+		}
+		loads = 0
+		rdr, err := fnd.AsLargeBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(content, got) {
+			t.Fatal("content mismatch")
+		}
+		if loads != 0 {
+			t.Fatalf("expected reads to be served from memory, got %d loads", loads)
+		}
+	})
+
+	t.Run("uncached", func(t *testing.T) {
+		loads = 0
+		fnd, err := file.NewUnixFSFileWithPreloadAll(context.Background(), substrate, &ls, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loads != blocks-1 {
+			t.Fatalf("expected %d blocks to be preloaded, got %d", blocks-1, loads)
+		}
+		loads = 0
+		rdr, err := fnd.AsLargeBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, rdr); err != nil {
+			t.Fatal(err)
+		}
+		if loads == 0 {
+			t.Fatal("expected reads to load from storage")
+		}
+	})
+
+	t.Run("missing blocks", func(t *testing.T) {
+		links := substrate.(dagpb.PBNode).FieldLinks()
+		first := links.Lookup(0).FieldHash().Link().(cidlink.Link).Cid
+		last := links.Lookup(links.Length() - 1).FieldHash().Link().(cidlink.Link).Cid
+		missing[first] = true
+		missing[last] = true
+		defer func() {
+			missing = make(map[cid.Cid]bool)
+		}()
+		_, err := file.NewUnixFSFileWithPreloadAll(context.Background(), substrate, &ls, true)
+		if err == nil {
+			t.Fatal("expected an error for missing blocks")
+		}
+		var errPreload *file.ErrPreload
+		if !errors.As(err, &errPreload) || errPreload.Cid != first {
+			t.Fatalf("expected a preload error for %s, got %v", first, err)
+		}
+		for _, c := range []cid.Cid{first, last} {
+			if !strings.Contains(err.Error(), c.String()) {
+				t.Fatalf("expected the error to mention %s: %v", c, err)
+			}
+		}
+	})
+}
+
+func TestPreloadAllRepeatedBlocks(t *testing.T) {
+	storage := cidlink.Memory{}
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageWriteOpener = storage.OpenWrite
+	// every leaf is the same block
+	content := bytes.Repeat(random.Bytes(1024), 100)
+	root, _, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loads := 0
+	ls.StorageReadOpener = func(lc ipld.LinkContext, l ipld.Link) (io.Reader, error) {
+		loads++
+		return storage.OpenRead(lc, l)
+	}
+	substrate, err := ls.Load(ipld.LinkContext{}, root, dagpb.Type.PBNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loads = 0
+	fnd, err := file.NewUnixFSFileWithPreloadAll(context.Background(), substrate, &ls, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loads != 1 {
+		t.Fatalf("expected the repeated leaf to be preloaded once, got %d loads", loads)
+	}
+	rdr, err := fnd.AsLargeBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(rdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, got) {
+		t.Fatal("content mismatch")
+	}
+	if loads != 1 {
+		t.Fatalf("expected reads to be served from memory, got %d loads", loads)
+	}
+}
+
+func TestConcurrentReaders(t *testing.T) {
+	storage := cidlink.Memory{}
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+	content := random.Bytes(1 << 20)
+	// small chunks and few links per block make a tree several levels deep
+	root, _, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls, builder.WithLinksPerBlock(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	substrate, err := ls.Load(ipld.LinkContext{}, root, dagpb.Type.PBNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fnd, err := file.NewUnixFSFile(context.Background(), substrate, &ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rdr, err := fnd.AsLargeBytes()
+			if err != nil {
+				errs <- err
+				return
+			}
+			for j := 0; j < 20; j++ {
+				off := int64((i*7919 + j*104729) % (len(content) - 5000))
+				if _, err := rdr.Seek(off, io.SeekStart); err != nil {
+					errs <- err
+					return
+				}
+				buf := make([]byte, 5000)
+				if _, err := io.ReadFull(rdr, buf); err != nil {
+					errs <- err
+					return
+				}
+				if !bytes.Equal(content[off:off+5000], buf) {
+					errs <- fmt.Errorf("content mismatch at %d", off)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestResume(t *testing.T) {
+	storage := cidlink.Memory{}
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+	content := random.Bytes(100 << 10)
+	root, _, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rdr, err := file.NewResumableReader(context.Background(), root, &ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := make([]byte, 50<<10)
+	if _, err := io.ReadFull(rdr, head); err != nil {
+		t.Fatal(err)
+	}
+	if rdr.Offset() != int64(len(head)) {
+		t.Fatalf("expected offset %d, got %d", len(head), rdr.Offset())
+	}
+	if len(rdr.Verified()) != 50 {
+		t.Fatalf("expected 50 verified leaves, got %d", len(rdr.Verified()))
+	}
+	token, err := rdr.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// resume with a fresh LinkSystem, as another process would
+	resumeLs := cidlink.DefaultLinkSystem()
+	leafLoads := make(map[cid.Cid]int)
+	resumeLs.StorageReadOpener = func(lc ipld.LinkContext, l ipld.Link) (io.Reader, error) {
+		if c := l.(cidlink.Link).Cid; c.Prefix().Codec == cid.Raw {
+			leafLoads[c]++
+		}
+		return storage.OpenRead(lc, l)
+	}
+	resumed, err := file.Resume(context.Background(), token, &resumeLs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tail, err := io.ReadAll(resumed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, append(head, tail...)) {
+		t.Fatal("resumed content does not match")
+	}
+	for _, c := range rdr.Verified() {
+		if leafLoads[c] != 0 {
+			t.Fatalf("leaf %s read before the token was loaded again", c)
+		}
+	}
+	if len(leafLoads) != 50 {
+		t.Fatalf("expected 50 leaves to be loaded, got %d", len(leafLoads))
+	}
+	if len(resumed.Verified()) != 100 {
+		t.Fatalf("expected 100 verified leaves, got %d", len(resumed.Verified()))
+	}
+
+	if _, err := file.Resume(context.Background(), []byte("not a token"), &resumeLs); err == nil {
+		t.Fatal("expected an invalid token to fail")
+	}
+}
+
+func TestProgressReader(t *testing.T) {
+	storage := cidlink.Memory{}
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+	content := random.Bytes(100 << 10)
+	root, storedSize, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reports []file.Progress
+	rdr, err := file.NewProgressReader(context.Background(), root, &ls, func(p file.Progress) {
+		reports = append(reports, p)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(rdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, got) {
+		t.Fatal("content does not match")
+	}
+	expected := file.Progress{Delivered: int64(len(content)), Size: int64(len(content)), Blocks: 101, Fetched: int64(storedSize)}
+	if p := rdr.Progress(); p != expected {
+		t.Fatalf("expected progress %+v, got %+v", expected, p)
+	}
+	if len(reports) == 0 || reports[len(reports)-1] != expected {
+		t.Fatalf("expected the last report to be %+v, got %+v", expected, reports)
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i].Delivered < reports[i-1].Delivered || reports[i].Blocks < reports[i-1].Blocks {
+			t.Fatalf("progress went backwards: %+v then %+v", reports[i-1], reports[i])
+		}
+	}
+
+	// reading part of the file again loads its leaves again
+	if _, err := rdr.Seek(int64(len(content))-2048, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(rdr); err != nil {
+		t.Fatal(err)
+	}
+	p := rdr.Progress()
+	if p.Delivered != int64(len(content))+2048 || p.Blocks <= expected.Blocks || p.Fetched <= expected.Fetched {
+		t.Fatalf("unexpected progress after reading again: %+v", p)
+	}
+}
@@ -0,0 +1,115 @@
+package file
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/ipfs/go-unixfsnode/data"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// field numbers of the dag-pb PBNode message
+const (
+	pbDataWireNum  protowire.Number = 1
+	pbLinksWireNum protowire.Number = 2
+)
+
+// ErrNotLeaf is returned by LeafDataSection for dag-pb nodes that have links,
+// or that are not UnixFS File or Raw nodes.
+type ErrNotLeaf struct {
+	Reason string
+}
+
+func (e ErrNotLeaf) Error() string {
+	return "not a UnixFS file leaf: " + e.Reason
+}
+
+// LeafDataSection locates the content of a dag-pb UnixFS leaf, a File or Raw
+// node without links, within its encoded block. The content is the length
+// bytes of block starting at offset, so a store that keeps blocks whole, such
+// as a CAR, can serve it without decoding the leaf again.
+//
+// block may be just the start of the block, as the content lies near the
+// start; io.ErrUnexpectedEOF is returned if it's too short to locate it.
+func LeafDataSection(block []byte) (offset int64, length int64, err error) {
+	// dag-pb encodes Links before Data, so a leaf starts with its Data
+	num, typ, n := protowire.ConsumeTag(block)
+	if n < 0 {
+		return 0, 0, protowire.ParseError(n)
+	}
+	if num == pbLinksWireNum {
+		return 0, 0, ErrNotLeaf{"node has links"}
+	}
+	if num != pbDataWireNum || typ != protowire.BytesType {
+		return 0, 0, fmt.Errorf("unexpected dag-pb field %d of wire type %d", num, typ)
+	}
+	pos := n
+	msgLen, n := protowire.ConsumeVarint(block[pos:])
+	if n < 0 {
+		return 0, 0, protowire.ParseError(n)
+	}
+	pos += n
+	if msgLen > uint64(math.MaxInt-pos) {
+		return 0, 0, fmt.Errorf("UnixFS data of %d bytes is too long", msgLen)
+	}
+	end := pos + int(msgLen)
+	if len(block) >= end && len(block) != end {
+		return 0, 0, ErrNotLeaf{"node has fields after its data"}
+	}
+
+	dataType := int64(-1)
+	dataOffset, dataLength := -1, 0
+	for pos < end && (dataType == -1 || dataOffset == -1) {
+		if pos >= len(block) {
+			return 0, 0, io.ErrUnexpectedEOF
+		}
+		num, typ, n := protowire.ConsumeTag(block[pos:])
+		if n < 0 {
+			return 0, 0, protowire.ParseError(n)
+		}
+		pos += n
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(block[pos:])
+			if n < 0 {
+				return 0, 0, protowire.ParseError(n)
+			}
+			pos += n
+			if num == data.Data_DataTypeWireNum {
+				dataType = int64(v)
+			}
+		case protowire.BytesType:
+			l, n := protowire.ConsumeVarint(block[pos:])
+			if n < 0 {
+				return 0, 0, protowire.ParseError(n)
+			}
+			pos += n
+			// only the start of the block may be given, so a field is
+			// checked against the end of the node rather than of block
+			if l > uint64(end-pos) {
+				return 0, 0, fmt.Errorf("UnixFS field overruns its node")
+			}
+			if num == data.Data_DataWireNum {
+				dataOffset, dataLength = pos, int(l)
+			}
+			pos += int(l)
+		default:
+			return 0, 0, fmt.Errorf("unexpected UnixFS field %d of wire type %d", num, typ)
+		}
+	}
+	if pos > end {
+		return 0, 0, fmt.Errorf("UnixFS field overruns its node")
+	}
+	if dataType == -1 {
+		return 0, 0, fmt.Errorf("UnixFS data has no type")
+	}
+	if dataType != data.Data_File && dataType != data.Data_Raw {
+		return 0, 0, ErrNotLeaf{fmt.Sprintf("node is of type %d", dataType)}
+	}
+	if dataOffset == -1 {
+		// a leaf without content, such as an empty file
+		return int64(end), 0, nil
+	}
+	return int64(dataOffset), int64(dataLength), nil
+}
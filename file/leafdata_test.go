@@ -0,0 +1,84 @@
+package file_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/file"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeafDataSection(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	block := func(content []byte, profile string) []byte {
+		root, _, err := builder.BuildUnixFSFileWithProfile(bytes.NewReader(content), profile, &ls)
+		require.NoError(t, err)
+		raw, err := ls.LoadRaw(ipld.LinkContext{}, root)
+		require.NoError(t, err)
+		return raw
+	}
+
+	for _, size := range []int{0, 1, 127, 128, 1000, 1 << 16} {
+		content := bytes.Repeat([]byte{'a'}, size)
+		blk := block(content, "unixfs-v1-cidv0")
+		offset, length, err := file.LeafDataSection(blk)
+		require.NoError(t, err)
+		require.Equal(t, content, blk[offset:offset+length])
+
+		// the start of the block is enough
+		prefixOffset, prefixLength, err := file.LeafDataSection(blk[:min(len(blk), 16)])
+		require.NoError(t, err)
+		require.Equal(t, offset, prefixOffset)
+		require.Equal(t, length, prefixLength)
+
+		_, _, err = file.LeafDataSection(blk[:2])
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	}
+
+	// a File node with links is not a leaf
+	var notLeaf file.ErrNotLeaf
+	blk := block(bytes.Repeat([]byte{'b'}, 1<<19), "unixfs-v1-cidv0")
+	_, _, err := file.LeafDataSection(blk)
+	require.ErrorAs(t, err, &notLeaf)
+
+	// nor is a directory
+	dir, _, err := builder.BuildUnixFSDirectory(nil, &ls)
+	require.NoError(t, err)
+	blk, err = ls.LoadRaw(ipld.LinkContext{}, dir)
+	require.NoError(t, err)
+	_, _, err = file.LeafDataSection(blk)
+	require.ErrorAs(t, err, &notLeaf)
+}
+
+func TestLeafDataSectionMalformedLength(t *testing.T) {
+	// a Data field whose length runs far past the end of the block
+	inner := []byte{0x12, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0x08, 0x02}
+	blk := append([]byte{0x0a, byte(len(inner))}, inner...)
+	_, _, err := file.LeafDataSection(blk)
+	require.Error(t, err)
+
+	// and an outer length that overflows
+	_, _, err = file.LeafDataSection([]byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0x08, 0x02})
+	require.Error(t, err)
+}
+
+func FuzzLeafDataSection(f *testing.F) {
+	f.Add([]byte{0x0a, 0x04, 0x08, 0x02, 0x12, 0x00})
+	f.Add([]byte{0x0a, 0x0c, 0x12, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0x08, 0x02})
+	f.Fuzz(func(t *testing.T, blk []byte) {
+		offset, length, err := file.LeafDataSection(blk)
+		if err != nil {
+			return
+		}
+		require.GreaterOrEqual(t, offset, int64(0))
+		require.GreaterOrEqual(t, length, int64(0))
+	})
+}
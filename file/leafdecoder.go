@@ -0,0 +1,41 @@
+package file
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+)
+
+// LeafDecoder recovers the content of a leaf block that was stored in an
+// encoded form, such as an encrypted or compressed leaf. It is given the CID
+// and the verified bytes of the block.
+type LeafDecoder func(ctx context.Context, c cid.Cid, block []byte) ([]byte, error)
+
+type leafDecodersKey struct{}
+
+// WithLeafDecoder returns a context carrying dec as the decoder for leaves
+// whose CID has the given codec. File nodes created with this context, either
+// directly or through reification on a LinkSystem, load such leaves as raw
+// blocks and read the content returned by dec. Decoders for other codecs
+// already carried by ctx are kept.
+func WithLeafDecoder(ctx context.Context, codec uint64, dec LeafDecoder) context.Context {
+	decoders := map[uint64]LeafDecoder{codec: dec}
+	for c, d := range leafDecodersFrom(ctx) {
+		if c != codec {
+			decoders[c] = d
+		}
+	}
+	return context.WithValue(ctx, leafDecodersKey{}, decoders)
+}
+
+func leafDecodersFrom(ctx context.Context) map[uint64]LeafDecoder {
+	if ctx == nil {
+		return nil
+	}
+	decoders, _ := ctx.Value(leafDecodersKey{}).(map[uint64]LeafDecoder)
+	return decoders
+}
+
+func leafDecoderFor(ctx context.Context, c cid.Cid) LeafDecoder {
+	return leafDecodersFrom(ctx)[c.Prefix().Codec]
+}
@@ -0,0 +1,148 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/instrument"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// NewUnixFSFileWithPreloadAll is NewUnixFSFile, loading every block of a
+// multi-block file before the node is returned. All of the blocks are loaded
+// even if some fail, and an error joining a *ErrPreload for each block that
+// couldn't be loaded is returned. A block linked more than once is loaded
+// once.
+//
+// If cache is set, the blocks are kept in memory with the file node, so that
+// reads of the file don't touch the LinkSystem's storage again. This holds
+// the whole file in memory for as long as the node is in use.
+func NewUnixFSFileWithPreloadAll(ctx context.Context, substrate ipld.Node, lsys *ipld.LinkSystem, cache bool) (LargeBytesNode, error) {
+	return newUnixFSFile(ctx, substrate, lsys, &preloadOptions{cache: cache})
+}
+
+type preloadOptions struct {
+	cache bool
+}
+
+// ErrPreload describes a block of a file that couldn't be loaded by
+// NewUnixFSFileWithPreloadAll.
+type ErrPreload struct {
+	Cid cid.Cid
+	Err error
+}
+
+func (e *ErrPreload) Error() string {
+	return fmt.Sprintf("preloading block %s: %s", e.Cid, e.Err)
+}
+
+func (e *ErrPreload) Unwrap() error {
+	return e.Err
+}
+
+// preload loads every block under the links of substrate, returning the
+// LinkSystem to read them with, which serves the blocks from memory if they
+// are cached.
+func preload(ctx context.Context, substrate ipld.Node, lsys *ipld.LinkSystem, o *preloadOptions) (*ipld.LinkSystem, error) {
+	var blocks map[cid.Cid][]byte
+	if o.cache {
+		blocks = make(map[cid.Cid][]byte)
+	}
+	seen := make(map[cid.Cid]struct{})
+	var errs []error
+	var walk func(ipld.Node)
+	walk = func(nd ipld.Node) {
+		links, err := nd.LookupByString("Links")
+		if err != nil {
+			return
+		}
+		li := links.ListIterator()
+		for !li.Done() {
+			_, lnk, err := li.Next()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			hash, err := lnk.LookupByString("Hash")
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			l, err := hash.AsLink()
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			cl, ok := l.(cidlink.Link)
+			if !ok {
+				errs = append(errs, fmt.Errorf("unsupported link type: %T", l))
+				continue
+			}
+			if _, ok := seen[cl.Cid]; ok {
+				continue
+			}
+			seen[cl.Cid] = struct{}{}
+			block, err := instrument.LoadRaw(ctx, lsys, instrument.FilePreload, l)
+			if err != nil {
+				errs = append(errs, &ErrPreload{Cid: cl.Cid, Err: err})
+				continue
+			}
+			if blocks != nil {
+				blocks[cl.Cid] = block
+			}
+			if cl.Cid.Prefix().Codec != cid.DagProtobuf {
+				continue
+			}
+			nb := dagpb.Type.PBNode.NewBuilder()
+			if err := dagpb.DecodeBytes(nb, block); err != nil {
+				errs = append(errs, &ErrPreload{Cid: cl.Cid, Err: err})
+				continue
+			}
+			walk(nb.Build())
+		}
+	}
+	walk(substrate)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if blocks == nil {
+		return lsys, nil
+	}
+
+	// the cached blocks were verified as they were loaded, so the LinkSystem
+	// trusts its storage, and any other block is verified here
+	cached := *lsys
+	cached.TrustedStorage = true
+	cached.StorageReadOpener = func(lnkCtx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("unsupported link type: %T", lnk)
+		}
+		if block, ok := blocks[cl.Cid]; ok {
+			return bytes.NewReader(block), nil
+		}
+		r, err := lsys.StorageReadOpener(lnkCtx, lnk)
+		if err != nil || lsys.TrustedStorage {
+			return r, err
+		}
+		block, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		sum, err := cl.Cid.Prefix().Sum(block)
+		if err != nil {
+			return nil, err
+		}
+		if !sum.Equals(cl.Cid) {
+			return nil, ipld.ErrHashMismatch{Actual: cidlink.Link{Cid: sum}, Expected: cl}
+		}
+		return bytes.NewReader(block), nil
+	}
+	return &cached, nil
+}
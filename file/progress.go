@@ -0,0 +1,132 @@
+package file
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"github.com/ipld/go-ipld-prime"
+)
+
+// Progress is how far a ProgressReader has got through a file.
+type Progress struct {
+	// Delivered is the number of bytes of the file returned by Read so far
+	Delivered int64
+	// Size is the size of the file
+	Size int64
+	// Blocks is the number of blocks loaded from storage so far, including
+	// the root of the file
+	Blocks int64
+	// Fetched is the number of bytes read from storage for those blocks.
+	// Fetched over Delivered is the read amplification of the read, from the
+	// interior nodes of the file and from blocks loaded more than once.
+	Fetched int64
+}
+
+// ProgressReader reads a file, reporting its Progress after each Read and
+// Seek, so that a download can show accurate progress, including the blocks
+// loaded on the way to the content. Like the readers of AsLargeBytes, it is
+// not safe for concurrent use.
+type ProgressReader struct {
+	rdr       io.ReadSeeker
+	fn        func(Progress)
+	offset    int64
+	size      int64
+	delivered int64
+	blocks    atomic.Int64
+	fetched   atomic.Int64
+}
+
+// NewProgressReader returns a ProgressReader for the file at root, reading
+// from its start, that calls fn with the progress of the read after each Read
+// and Seek. fn may be nil, in which case progress is only available from
+// Progress. Blocks are counted as they are read from the StorageReadOpener of
+// lsys, so blocks read from a cache in front of lsys are not counted.
+func NewProgressReader(ctx context.Context, root ipld.Link, lsys *ipld.LinkSystem, fn func(Progress)) (*ProgressReader, error) {
+	r := &ProgressReader{fn: fn}
+	counted := *lsys
+	counted.StorageReadOpener = r.count(lsys.StorageReadOpener)
+	substrate, err := counted.Load(ipld.LinkContext{Ctx: ctx}, root, protoFor(root))
+	if err != nil {
+		return nil, err
+	}
+	f, err := NewUnixFSFile(ctx, substrate, &counted)
+	if err != nil {
+		return nil, err
+	}
+	r.rdr, err = f.AsLargeBytes()
+	if err != nil {
+		return nil, err
+	}
+	if r.size, err = r.rdr.Seek(0, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	if _, err := r.rdr.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// count wraps the StorageReadOpener of the LinkSystem the file is read with,
+// to count the blocks and bytes read through it.
+func (r *ProgressReader) count(open ipld.BlockReadOpener) ipld.BlockReadOpener {
+	return func(lnkCtx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		rdr, err := open(lnkCtx, lnk)
+		if err != nil {
+			return nil, err
+		}
+		r.blocks.Add(1)
+		return &progressCountingReader{rdr, &r.fetched}, nil
+	}
+}
+
+type progressCountingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (cr *progressCountingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n.Add(int64(n))
+	return n, err
+}
+
+func (r *ProgressReader) Read(p []byte) (int, error) {
+	n, err := r.rdr.Read(p)
+	r.offset += int64(n)
+	r.delivered += int64(n)
+	r.report()
+	return n, err
+}
+
+// Seek sets the position of the next Read. Bytes read again after seeking
+// back count towards Delivered again.
+func (r *ProgressReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.rdr.Seek(offset, whence)
+	if err == nil {
+		r.offset = pos
+	}
+	r.report()
+	return pos, err
+}
+
+// Offset returns the position in the file of the next byte Read returns.
+func (r *ProgressReader) Offset() int64 {
+	return r.offset
+}
+
+// Progress returns the progress of the read so far.
+func (r *ProgressReader) Progress() Progress {
+	return Progress{
+		Delivered: r.delivered,
+		Size:      r.size,
+		Blocks:    r.blocks.Load(),
+		Fetched:   r.fetched.Load(),
+	}
+}
+
+func (r *ProgressReader) report() {
+	if r.fn != nil {
+		r.fn(r.Progress())
+	}
+}
@@ -0,0 +1,168 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+)
+
+// RangedBlockReader is an optional extension of BlockSectionReader for
+// storage that keeps all of its blocks in a single address space, such as the
+// data payload of a CAR.
+//
+// When the BlockSectionReader attached to a file node's context is a
+// RangedBlockReader, raw leaves of multi-block files that are stored one after
+// another are read together with a single ReadAt over the span they occupy,
+// rather than with a read per leaf. Bytes between the leaves, such as CAR
+// section headers, are read and discarded. Leaves are looked up with
+// BlockOffset only once the reader gets to them.
+type RangedBlockReader interface {
+	BlockSectionReader
+	// BlockOffset returns the position of the bytes of the block identified by
+	// the CID within the reader returned by Blocks, along with the size of the
+	// block.
+	BlockOffset(ctx context.Context, c cid.Cid) (offset int64, size int64, err error)
+	// Blocks returns a reader over the storage holding every block.
+	Blocks() io.ReaderAt
+}
+
+const (
+	// maxRangedGap is the most bytes that may lie between two leaves for them
+	// to be read together; enough for the CAR section header of a leaf
+	maxRangedGap = 128
+	// maxRangedSpan bounds the bytes read, and held, for a run of leaves
+	maxRangedSpan = 4 << 20
+)
+
+var errLeafSizeMismatch = errors.New("block section size does not match expected leaf size")
+
+type rangedLeaf struct {
+	c cid.Cid
+	// position of the leaf in the file content, relative to the run
+	start int64
+	size  int64
+	// position of the leaf in the storage, once it has been looked up
+	offset  int64
+	located bool
+}
+
+// rangedLeafReader reads a run of raw leaves from a RangedBlockReader. The
+// leaves are looked up in the storage only as they are read: the leaf at the
+// read position is looked up along with the leaves stored close after it, and
+// the span of storage holding them is read in one go.
+type rangedLeafReader struct {
+	ctx    context.Context
+	rbr    RangedBlockReader
+	leaves []rangedLeaf
+	size   int64
+	offset int64
+	// buf holds the storage from bufFrom to the end of leaf bufLast, the
+	// leaves from bufFirst being stored one after another
+	buf               []byte
+	bufFrom           int64
+	bufFirst, bufLast int
+}
+
+func newRangedLeafReader(ctx context.Context, rbr RangedBlockReader) *rangedLeafReader {
+	return &rangedLeafReader{ctx: ctx, rbr: rbr}
+}
+
+// add appends the leaf c, of the given size, to the run.
+func (r *rangedLeafReader) add(c cid.Cid, size int64) {
+	r.leaves = append(r.leaves, rangedLeaf{c: c, start: r.size, size: size})
+	r.size += size
+}
+
+// leafAt returns the index of the leaf holding the content at offset.
+func (r *rangedLeafReader) leafAt(offset int64) int {
+	return sort.Search(len(r.leaves), func(i int) bool {
+		return r.leaves[i].start+r.leaves[i].size > offset
+	})
+}
+
+// locate looks up where the i'th leaf is stored.
+func (r *rangedLeafReader) locate(i int) error {
+	leaf := &r.leaves[i]
+	if leaf.located {
+		return nil
+	}
+	offset, size, err := r.rbr.BlockOffset(r.ctx, leaf.c)
+	if err != nil {
+		return err
+	}
+	if size != leaf.size {
+		return errLeafSizeMismatch
+	}
+	leaf.offset, leaf.located = offset, true
+	return nil
+}
+
+// fill reads the storage from the read position, in the i'th leaf, to the end
+// of the last leaf stored close enough after it to be read along with it.
+func (r *rangedLeafReader) fill(i int) error {
+	if err := r.locate(i); err != nil {
+		return err
+	}
+	first := r.leaves[i]
+	last, end := i, first.offset+first.size
+	for j := i + 1; j < len(r.leaves); j++ {
+		// a leaf that can't be looked up fails when it's read
+		if r.locate(j) != nil {
+			break
+		}
+		next := r.leaves[j]
+		if next.offset < end || next.offset-end > maxRangedGap || next.offset+next.size-first.offset > maxRangedSpan {
+			break
+		}
+		last, end = j, next.offset+next.size
+	}
+	pos := first.offset + r.offset - first.start
+	buf := make([]byte, end-pos)
+	n, err := r.rbr.Blocks().ReadAt(buf, pos)
+	if n < len(buf) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	r.buf, r.bufFrom, r.bufFirst, r.bufLast = buf, pos, i, last
+	return nil
+}
+
+func (r *rangedLeafReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	i := r.leafAt(r.offset)
+	leaf := r.leaves[i]
+	if r.buf == nil || i < r.bufFirst || i > r.bufLast || leaf.offset+r.offset-leaf.start < r.bufFrom {
+		if err := r.fill(i); err != nil {
+			return 0, err
+		}
+		leaf = r.leaves[i]
+	}
+	at := leaf.offset + r.offset - leaf.start - r.bufFrom
+	n := copy(p, r.buf[at:at+leaf.start+leaf.size-r.offset])
+	r.offset += int64(n)
+	return n, nil
+}
+
+func (r *rangedLeafReader) Seek(offset int64, whence int) (int64, error) {
+	pos := r.offset
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos += offset
+	case io.SeekEnd:
+		pos = r.size + offset
+	}
+	if pos < 0 {
+		return 0, errors.New("negative offset")
+	}
+	r.offset = pos
+	return r.offset, nil
+}
@@ -0,0 +1,83 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-test/random"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// rangedBytes stores blocks one after another, with a gap between each.
+type rangedBytes struct {
+	storage []byte
+	offsets map[cid.Cid][2]int64
+	lookups int
+}
+
+func (rb *rangedBytes) put(block []byte) cid.Cid {
+	c, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.SHA2_256}.Sum(block)
+	if err != nil {
+		panic(err)
+	}
+	rb.storage = append(rb.storage, make([]byte, 40)...)
+	rb.offsets[c] = [2]int64{int64(len(rb.storage)), int64(len(block))}
+	rb.storage = append(rb.storage, block...)
+	return c
+}
+
+func (rb *rangedBytes) BlockSection(_ context.Context, c cid.Cid) (io.ReaderAt, int64, error) {
+	o := rb.offsets[c]
+	return io.NewSectionReader(bytes.NewReader(rb.storage), o[0], o[1]), o[1], nil
+}
+
+func (rb *rangedBytes) BlockOffset(_ context.Context, c cid.Cid) (int64, int64, error) {
+	rb.lookups++
+	o := rb.offsets[c]
+	return o[0], o[1], nil
+}
+
+func (rb *rangedBytes) Blocks() io.ReaderAt {
+	return bytes.NewReader(rb.storage)
+}
+
+func TestRangedLeafReader(t *testing.T) {
+	rb := &rangedBytes{offsets: make(map[cid.Cid][2]int64)}
+	const leafSize = 1 << 20
+	content := random.Bytes(16 * leafSize)
+	r := newRangedLeafReader(context.Background(), rb)
+	for i := 0; i < len(content); i += leafSize {
+		r.add(rb.put(content[i:i+leafSize]), leafSize)
+	}
+	require.Zero(t, rb.lookups)
+
+	// only the leaves read along with the first are looked up
+	buf := make([]byte, 10)
+	_, err := io.ReadFull(r, buf)
+	require.NoError(t, err)
+	require.Equal(t, content[:10], buf)
+	require.LessOrEqual(t, rb.lookups, maxRangedSpan/leafSize+1)
+
+	off, err := r.Seek(-leafSize, io.SeekEnd)
+	require.NoError(t, err)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	require.Equal(t, content[off:off+10], buf)
+
+	// a seek before the start fails without moving the reader
+	_, err = r.Seek(-1, io.SeekStart)
+	require.Error(t, err)
+	_, err = r.Seek(-int64(len(content))-1, io.SeekCurrent)
+	require.Error(t, err)
+	require.Equal(t, off+10, r.offset)
+
+	_, err = r.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, content, got)
+}
@@ -0,0 +1,178 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// ResumableReader reads a file from a position, keeping track of how far it
+// has read and which leaves of the file it has verified, so that the read can
+// be stopped and resumed later, possibly by another process, with a token
+// from Token. A resumed read starts where the last one stopped: only the
+// interior nodes on the way to that position are loaded again, not the blocks
+// before it.
+//
+// A leaf is verified once its block has been loaded and hashed against its
+// CID, whether or not the LinkSystem has TrustedStorage, so a client that
+// keeps the content it has read knows which leaves it needn't fetch again.
+type ResumableReader struct {
+	root cid.Cid
+	rdr  io.ReadSeeker
+	// offset is the position in the file of the next byte to read
+	offset int64
+
+	lk       sync.Mutex
+	verified []cid.Cid
+	seen     map[cid.Cid]struct{}
+}
+
+// resumeToken is the content of the tokens made by ResumableReader.Token.
+type resumeToken struct {
+	Root     cid.Cid   `json:"root"`
+	Offset   int64     `json:"offset"`
+	Verified []cid.Cid `json:"verified,omitempty"`
+}
+
+// NewResumableReader returns a ResumableReader for the file at root, reading
+// from its start.
+func NewResumableReader(ctx context.Context, root ipld.Link, lsys *ipld.LinkSystem) (*ResumableReader, error) {
+	cl, ok := root.(cidlink.Link)
+	if !ok {
+		return nil, fmt.Errorf("unsupported link type %T", root)
+	}
+	return newResumableReader(ctx, resumeToken{Root: cl.Cid}, lsys)
+}
+
+// Resume returns a ResumableReader continuing the read a token was taken
+// from, at the position it had reached.
+func Resume(ctx context.Context, token []byte, lsys *ipld.LinkSystem) (*ResumableReader, error) {
+	var tok resumeToken
+	if err := json.Unmarshal(token, &tok); err != nil {
+		return nil, fmt.Errorf("invalid resume token: %w", err)
+	}
+	if !tok.Root.Defined() || tok.Offset < 0 {
+		return nil, fmt.Errorf("invalid resume token")
+	}
+	return newResumableReader(ctx, tok, lsys)
+}
+
+func newResumableReader(ctx context.Context, tok resumeToken, lsys *ipld.LinkSystem) (*ResumableReader, error) {
+	r := &ResumableReader{
+		root:   tok.Root,
+		offset: tok.Offset,
+		seen:   make(map[cid.Cid]struct{}, len(tok.Verified)),
+	}
+	for _, c := range tok.Verified {
+		r.addVerified(c)
+	}
+	watched := *lsys
+	watched.StorageReadOpener = r.watch(lsys.StorageReadOpener)
+
+	rootLink := cidlink.Link{Cid: tok.Root}
+	substrate, err := watched.Load(ipld.LinkContext{Ctx: ctx}, rootLink, protoFor(rootLink))
+	if err != nil {
+		return nil, err
+	}
+	f, err := NewUnixFSFile(ctx, substrate, &watched)
+	if err != nil {
+		return nil, err
+	}
+	r.rdr, err = f.AsLargeBytes()
+	if err != nil {
+		return nil, err
+	}
+	if tok.Offset > 0 {
+		size, err := r.rdr.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		if tok.Offset > size {
+			return nil, fmt.Errorf("resume token is at offset %d, beyond the end of the file, of %d bytes", tok.Offset, size)
+		}
+		if _, err := r.rdr.Seek(tok.Offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// watch wraps the StorageReadOpener of the LinkSystem the file is read with,
+// to record the leaves loaded through it.
+func (r *ResumableReader) watch(open ipld.BlockReadOpener) ipld.BlockReadOpener {
+	return func(lnkCtx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		rdr, err := open(lnkCtx, lnk)
+		if err != nil {
+			return nil, err
+		}
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return rdr, nil
+		}
+		block, err := io.ReadAll(rdr)
+		if err != nil {
+			return nil, err
+		}
+		if isLeaf(cl.Cid, block) {
+			if c, err := cl.Cid.Prefix().Sum(block); err == nil && c.Equals(cl.Cid) {
+				r.addVerified(cl.Cid)
+			}
+		}
+		return bytes.NewReader(block), nil
+	}
+}
+
+// isLeaf reports whether a block of a file holds content rather than links.
+func isLeaf(c cid.Cid, block []byte) bool {
+	if c.Prefix().Codec != cid.DagProtobuf {
+		return true
+	}
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagpb.DecodeBytes(nb, block); err != nil {
+		return false
+	}
+	return nb.Build().(dagpb.PBNode).FieldLinks().Length() == 0
+}
+
+func (r *ResumableReader) addVerified(c cid.Cid) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	if _, ok := r.seen[c]; ok {
+		return
+	}
+	r.seen[c] = struct{}{}
+	r.verified = append(r.verified, c)
+}
+
+func (r *ResumableReader) Read(p []byte) (int, error) {
+	n, err := r.rdr.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+// Offset returns the position in the file of the next byte Read returns.
+func (r *ResumableReader) Offset() int64 {
+	return r.offset
+}
+
+// Verified returns the CIDs of the leaves verified so far, including those
+// verified by the reads this one resumes, in the order they were verified.
+func (r *ResumableReader) Verified() []cid.Cid {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return append([]cid.Cid(nil), r.verified...)
+}
+
+// Token returns an opaque token recording the root of the file, the position
+// reached and the leaves verified, for Resume.
+func (r *ResumableReader) Token() ([]byte, error) {
+	return json.Marshal(resumeToken{Root: r.root, Offset: r.offset, Verified: r.Verified()})
+}
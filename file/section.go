@@ -0,0 +1,106 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/ipfs/go-cid"
+)
+
+// BlockSectionReader is an optional interface for block storage that can read
+// arbitrary sections of a stored block without loading the whole block, such
+// as a CARv2 file with an index.
+//
+// When a BlockSectionReader is attached to the context used to construct a
+// file node (see WithBlockSectionReader), raw leaves of multi-block files are
+// read through it, so a Seek followed by a Read only reads the bytes that are
+// needed. Leaves read this way are not hashed, so the storage must be trusted
+// to return the correct content for a CID.
+type BlockSectionReader interface {
+	// BlockSection returns a reader over the bytes of the block identified by
+	// the CID, along with the size of the block.
+	BlockSection(ctx context.Context, c cid.Cid) (io.ReaderAt, int64, error)
+}
+
+type blockSectionReaderKey struct{}
+
+// WithBlockSectionReader returns a context carrying bsr. File nodes created
+// with this context, either directly or through reification on a LinkSystem,
+// will use bsr to read raw leaf blocks.
+func WithBlockSectionReader(ctx context.Context, bsr BlockSectionReader) context.Context {
+	return context.WithValue(ctx, blockSectionReaderKey{}, bsr)
+}
+
+func blockSectionReaderFrom(ctx context.Context) BlockSectionReader {
+	if ctx == nil {
+		return nil
+	}
+	bsr, _ := ctx.Value(blockSectionReaderKey{}).(BlockSectionReader)
+	return bsr
+}
+
+// sectionLeafReader reads a raw leaf through a BlockSectionReader, opening the
+// section on first use.
+type sectionLeafReader struct {
+	ctx    context.Context
+	bsr    BlockSectionReader
+	c      cid.Cid
+	size   int64
+	ra     io.ReaderAt
+	offset int64
+}
+
+func newSectionLeafReader(ctx context.Context, bsr BlockSectionReader, c cid.Cid, size int64) *sectionLeafReader {
+	return &sectionLeafReader{ctx: ctx, bsr: bsr, c: c, size: size}
+}
+
+func (r *sectionLeafReader) open() error {
+	if r.ra != nil {
+		return nil
+	}
+	ra, size, err := r.bsr.BlockSection(r.ctx, r.c)
+	if err != nil {
+		return err
+	}
+	if size != r.size {
+		return errLeafSizeMismatch
+	}
+	r.ra = ra
+	return nil
+}
+
+func (r *sectionLeafReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if err := r.open(); err != nil {
+		return 0, err
+	}
+	if remaining := r.size - r.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := r.ra.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *sectionLeafReader) Seek(offset int64, whence int) (int64, error) {
+	pos := r.offset
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos += offset
+	case io.SeekEnd:
+		pos = r.size + offset
+	}
+	if pos < 0 {
+		return 0, errors.New("negative offset")
+	}
+	r.offset = pos
+	return r.offset, nil
+}
@@ -0,0 +1,43 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+type bytesSections map[cid.Cid][]byte
+
+func (b bytesSections) BlockSection(_ context.Context, c cid.Cid) (io.ReaderAt, int64, error) {
+	return bytes.NewReader(b[c]), int64(len(b[c])), nil
+}
+
+func TestSectionLeafReaderSeek(t *testing.T) {
+	c := cid.MustParse("bafkqaaa")
+	content := []byte("0123456789")
+	r := newSectionLeafReader(context.Background(), bytesSections{c: content}, c, int64(len(content)))
+
+	off, err := r.Seek(4, io.SeekStart)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), off)
+
+	// a seek before the start fails without moving the reader
+	for _, s := range []struct {
+		offset int64
+		whence int
+	}{{-1, io.SeekStart}, {-5, io.SeekCurrent}, {-11, io.SeekEnd}} {
+		_, err = r.Seek(s.offset, s.whence)
+		require.Error(t, err)
+		off, err = r.Seek(0, io.SeekCurrent)
+		require.NoError(t, err)
+		require.Equal(t, int64(4), off)
+	}
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	require.Equal(t, content[4:7], buf)
+}
@@ -1,6 +1,7 @@
 package file
 
 import (
+	"container/list"
 	"context"
 	"io"
 	"sync"
@@ -23,6 +24,56 @@ type shardNodeFile struct {
 	// unixfs data unpacked from the substrate. access via .unpack()
 	metadata data.UnixFSData
 	unpackLk sync.Once
+
+	// interior nodes of the file loaded recently, shared by the whole tree
+	cache *nodeCache
+}
+
+// maxCachedNodes bounds the interior nodes kept loaded for a file, across its
+// whole tree.
+const maxCachedNodes = 64
+
+// nodeCache keeps the interior nodes of a file that were used most recently,
+// by link, so that seeking back into them doesn't load them again. They index
+// the leaves below them; leaves themselves are not kept.
+type nodeCache struct {
+	lk    sync.Mutex
+	order list.List // of *cachedNode, most recently used first
+	nodes map[ipld.Link]*list.Element
+}
+
+type cachedNode struct {
+	lnk  ipld.Link
+	node *shardNodeFile
+}
+
+func newNodeCache() *nodeCache {
+	return &nodeCache{nodes: make(map[ipld.Link]*list.Element)}
+}
+
+func (c *nodeCache) get(lnk ipld.Link) (*shardNodeFile, bool) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	e, ok := c.nodes[lnk]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedNode).node, true
+}
+
+func (c *nodeCache) put(lnk ipld.Link, node *shardNodeFile) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if e, ok := c.nodes[lnk]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.nodes[lnk] = c.order.PushFront(&cachedNode{lnk, node})
+	if c.order.Len() > maxCachedNodes {
+		oldest := c.order.Remove(c.order.Back()).(*cachedNode)
+		delete(c.nodes, oldest.lnk)
+	}
 }
 
 var _ adl.ADL = (*shardNodeFile)(nil)
@@ -40,6 +91,7 @@ func (s *shardNodeReader) makeReader() (io.Reader, error) {
 		return nil, err
 	}
 	readers := make([]io.Reader, 0)
+	var run *rangedLeafReader
 	lnkIter := links.ListIterator()
 	at := int64(0)
 	for !lnkIter.Done() {
@@ -64,10 +116,28 @@ func (s *shardNodeReader) makeReader() (io.Reader, error) {
 			if err != nil {
 				return nil, err
 			}
-			target := newDeferredFileNode(s.ctx, s.lsys, lnklnk)
-			tr, err = target.AsLargeBytes()
-			if err != nil {
-				return nil, err
+			if cl, ok := lnklnk.(cidlink.Link); ok && cl.Cid.Prefix().Codec == cid.Raw {
+				bsr := blockSectionReaderFrom(s.ctx)
+				if rbr, ok := bsr.(RangedBlockReader); ok {
+					// consecutive leaves are read by the same run, which
+					// looks them up as they are read
+					if run != nil {
+						run.add(cl.Cid, childSize)
+						at += childSize
+						continue
+					}
+					run = newRangedLeafReader(s.ctx, rbr)
+					run.add(cl.Cid, childSize)
+					tr = run
+				} else if bsr != nil {
+					tr = newSectionLeafReader(s.ctx, bsr, cl.Cid, childSize)
+				}
+			}
+			if tr == nil {
+				tr, err = s.childReader(lnklnk)
+				if err != nil {
+					return nil, err
+				}
 			}
 		}
 		// fastforward the first one if needed.
@@ -79,6 +149,9 @@ func (s *shardNodeReader) makeReader() (io.Reader, error) {
 		}
 		at += childSize
 		readers = append(readers, tr)
+		if tr != run {
+			run = nil
+		}
 	}
 	if len(readers) == 0 {
 		return nil, io.EOF
@@ -100,7 +173,7 @@ func (s *shardNodeFile) unpack() (data.UnixFSData, error) {
 			retErr = err
 			return
 		}
-		ud, err := data.DecodeUnixFSData(nodeDataBytes)
+		ud, err := data.DecodeUnixFSDataZeroCopy(nodeDataBytes)
 		if err != nil {
 			retErr = err
 			return
@@ -151,8 +224,7 @@ func (s *shardNodeFile) linkSize(lnk ipld.Node, position int) (int64, io.ReadSee
 	}
 
 	// open the link and get its size.
-	target := newDeferredFileNode(s.ctx, s.lsys, lnklnk)
-	tr, err := target.AsLargeBytes()
+	tr, err := s.childReader(lnklnk)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -165,6 +237,22 @@ func (s *shardNodeFile) linkSize(lnk ipld.Node, position int) (int64, io.ReadSee
 	return end, tr, err
 }
 
+// childReader returns a reader for the child lnk links to, reusing the
+// child node if it is an interior node that is still cached.
+func (s *shardNodeFile) childReader(lnk ipld.Link) (io.ReadSeeker, error) {
+	if child, ok := s.cache.get(lnk); ok {
+		return child.AsLargeBytes()
+	}
+	target := newDeferredFileNode(s.ctx, s.lsys, lnk)
+	target.onResolve = func(resolved LargeBytesNode) {
+		if child, ok := resolved.(*shardNodeFile); ok {
+			child.cache = s.cache
+			s.cache.put(lnk, child)
+		}
+	}
+	return target.AsLargeBytes()
+}
+
 func (s *shardNodeReader) Read(p []byte) (int, error) {
 	// build reader
 	if s.rdr == nil {
@@ -0,0 +1,37 @@
+package file
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeCacheBounded(t *testing.T) {
+	lnk := func(i int) cidlink.Link {
+		c, err := cid.V1Builder{Codec: cid.DagProtobuf, MhType: multihash.SHA2_256}.Sum([]byte(fmt.Sprint(i)))
+		require.NoError(t, err)
+		return cidlink.Link{Cid: c}
+	}
+	c := newNodeCache()
+	nodes := make([]*shardNodeFile, 2*maxCachedNodes)
+	for i := range nodes {
+		nodes[i] = &shardNodeFile{}
+		c.put(lnk(i), nodes[i])
+		// the first node stays cached as long as it's used
+		got, ok := c.get(lnk(0))
+		require.True(t, ok)
+		require.Same(t, nodes[0], got)
+	}
+	require.Equal(t, maxCachedNodes, c.order.Len())
+	require.Len(t, c.nodes, maxCachedNodes)
+
+	_, ok := c.get(lnk(1))
+	require.False(t, ok, "least recently used node should be evicted")
+	got, ok := c.get(lnk(len(nodes) - 1))
+	require.True(t, ok)
+	require.Same(t, nodes[len(nodes)-1], got)
+}
@@ -16,19 +16,21 @@ func newWrappedNode(substrate ipld.Node) (LargeBytesNode, error) {
 	if err != nil {
 		return nil, err
 	}
-	ufd, err := data.DecodeUnixFSData(dfb)
+	ufd, err := data.DecodeUnixFSDataZeroCopy(dfb)
 	if err != nil {
 		return nil, err
 	}
 
 	if ufd.Data.Exists() {
 		return &singleNodeFile{
-			Node: ufd.Data.Must(),
+			Node:      ufd.Data.Must(),
+			substrate: substrate,
 		}, nil
 	}
 
 	// an empty degenerate one.
 	return &singleNodeFile{
-		Node: basicnode.NewBytes(nil),
+		Node:      basicnode.NewBytes(nil),
+		substrate: substrate,
 	}, nil
 }
@@ -0,0 +1,81 @@
+// Package gateway has helpers for HTTP servers that serve UnixFS content, so
+// that servers built on this module agree on path resolution, caching and
+// range semantics.
+package gateway
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+)
+
+// ETag returns a strong entity tag, quoted as for the ETag header, for the
+// resource at path under root. Content under a CID never changes, so the tag
+// is derived from the request alone and can be computed before the path is
+// resolved. The path is normalized the way UnixFSPathSelectorBuilder reads it,
+// so paths that select the same resource get the same tag; with an empty path
+// the tag is the quoted CID, as gateways give for a resolved CID. format, if
+// not empty, is appended for responses in another format of the same
+// resource, such as "car" or "tar".
+func ETag(root cid.Cid, path string, format string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	sb.WriteString(root.String())
+	for _, seg := range datamodel.ParsePath(path).Segments() {
+		sb.WriteByte('/')
+		sb.WriteString(url.PathEscape(seg.String()))
+	}
+	if format != "" {
+		sb.WriteByte('.')
+		sb.WriteString(format)
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// MatchesETag reports whether the value of an If-None-Match header matches
+// etag, in which case a server can reply 304 Not Modified. Tags are compared
+// weakly, as RFC 9110 specifies for If-None-Match.
+func MatchesETag(ifNoneMatch string, etag string) bool {
+	ifNoneMatch = strings.TrimSpace(ifNoneMatch)
+	if ifNoneMatch == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// LastModified returns the modification time of a resolved UnixFS node, from
+// the mtime of UnixFS 1.5, truncated to the second resolution of HTTP dates.
+// It returns false if the node has no mtime, in which case no Last-Modified
+// header should be sent: content addressed by CID has no other time of its
+// own. n may be a dag-pb node or a node reified from one.
+func LastModified(n ipld.Node) (time.Time, bool) {
+	st, err := unixfsnode.Stat(n)
+	if err != nil {
+		return time.Time{}, false
+	}
+	mtime, err := st.LookupByString("Mtime")
+	if err != nil {
+		return time.Time{}, false
+	}
+	secs, err := mtime.LookupByString("Seconds")
+	if err != nil {
+		return time.Time{}, false
+	}
+	s, err := secs.AsInt()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(s, 0).UTC(), true
+}
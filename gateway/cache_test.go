@@ -0,0 +1,60 @@
+package gateway_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/gateway"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestETag(t *testing.T) {
+	root := cid.MustParse("bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi")
+	require.Equal(t, `"bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"`, gateway.ETag(root, "", ""))
+	require.Equal(t, `"bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi.car"`, gateway.ETag(root, "/", "car"))
+
+	etag := gateway.ETag(root, "/wiki/Café \"quoted\"", "")
+	require.Equal(t, `"bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi/wiki/Caf%C3%A9%20%22quoted%22"`, etag)
+	require.Equal(t, etag, gateway.ETag(root, "wiki//Café \"quoted\"/", ""))
+	require.NotEqual(t, etag, gateway.ETag(root, "/wiki/Café", ""))
+
+	require.True(t, gateway.MatchesETag(etag, etag))
+	require.True(t, gateway.MatchesETag(`"other", W/`+etag, etag))
+	require.True(t, gateway.MatchesETag("*", etag))
+	require.False(t, gateway.MatchesETag(`"other"`, etag))
+	require.False(t, gateway.MatchesETag("", etag))
+}
+
+func TestLastModified(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	load := func(lnk ipld.Link) ipld.Node {
+		nd, err := ls.Load(ipld.LinkContext{}, lnk, dagpb.Type.PBNode)
+		require.NoError(t, err)
+		reified, err := unixfsnode.Reify(ipld.LinkContext{}, nd, &ls)
+		require.NoError(t, err)
+		return reified
+	}
+
+	mtime := time.Date(2023, 11, 14, 22, 13, 20, 500, time.FixedZone("x", 3600))
+	withMtime, _, err := builder.BuildUnixFSFile(bytes.NewReader(make([]byte, 1<<19)), "", &ls, builder.WithMtime(mtime))
+	require.NoError(t, err)
+	lm, ok := gateway.LastModified(load(withMtime))
+	require.True(t, ok)
+	require.Equal(t, mtime.Truncate(time.Second).UTC(), lm)
+
+	withoutMtime, _, err := builder.BuildUnixFSDirectory(nil, &ls)
+	require.NoError(t, err)
+	_, ok = gateway.LastModified(load(withoutMtime))
+	require.False(t, ok)
+}
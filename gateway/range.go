@@ -0,0 +1,126 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+)
+
+// ErrMultipleRanges is returned by ParseRange for a header asking for more
+// than one range. Servers may answer such requests with the whole file.
+var ErrMultipleRanges = errors.New("multiple ranges are not supported")
+
+// ErrInvalidRange is returned by ParseRange for a Range header that can't be
+// parsed, which servers should ignore.
+type ErrInvalidRange struct {
+	Header string
+}
+
+func (e ErrInvalidRange) Error() string {
+	return fmt.Sprintf("invalid range header %q", e.Header)
+}
+
+// ErrRangeNotSatisfiable is returned by ParseRange when the range selects no
+// bytes of the file, which servers answer with 416 Range Not Satisfiable.
+type ErrRangeNotSatisfiable struct {
+	Size int64
+}
+
+func (e ErrRangeNotSatisfiable) Error() string {
+	return fmt.Sprintf("range not satisfiable for a file of %d bytes", e.Size)
+}
+
+// ContentRange returns the value of the Content-Range header to send with a
+// 416 response.
+func (e ErrRangeNotSatisfiable) ContentRange() string {
+	return fmt.Sprintf("bytes */%d", e.Size)
+}
+
+// Range is a range of bytes of a file, as requested by a Range header.
+type Range struct {
+	// Start is the offset of the first byte of the range.
+	Start int64
+	// End is the offset of the last byte of the range, which is included, as in
+	// a Content-Range header.
+	End int64
+	// Size is the size of the whole file.
+	Size int64
+}
+
+// Length returns the number of bytes in the range, the Content-Length of the
+// response.
+func (r Range) Length() int64 {
+	return r.End - r.Start + 1
+}
+
+// ContentRange returns the value of the Content-Range header of the response.
+func (r Range) ContentRange() string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.End, r.Size)
+}
+
+// Selector returns a selector for the file at path, under the root a traversal
+// starts from, that matches the file with just the range of its bytes, so
+// only the blocks holding them are loaded. It's built with
+// unixfsnode.UnixFSPathSelectorBuilder, so path is read by the same rules.
+func (r Range) Selector(path string) datamodel.Node {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	// the subset matcher's end is exclusive
+	target := ssb.ExploreInterpretAs("unixfs", ssb.MatcherSubset(r.Start, r.End+1))
+	return unixfsnode.UnixFSPathSelectorBuilder(path, target, false)
+}
+
+// ParseRange parses the value of a Range header for a file of the given size.
+// One range of bytes may be given, in any of the forms "bytes=first-last",
+// "bytes=first-" and "bytes=-suffixLength"; a last offset past the end of the
+// file is clamped to it.
+func ParseRange(header string, size int64) (Range, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok {
+		return Range{}, ErrInvalidRange{header}
+	}
+	if strings.Contains(spec, ",") {
+		return Range{}, ErrMultipleRanges
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return Range{}, ErrInvalidRange{header}
+	}
+	first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+	r := Range{Size: size}
+	if first == "" {
+		// the last bytes of the file
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix < 0 {
+			return Range{}, ErrInvalidRange{header}
+		}
+		if suffix == 0 || size == 0 {
+			return Range{}, ErrRangeNotSatisfiable{size}
+		}
+		r.Start, r.End = max(size-suffix, 0), size-1
+		return r, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return Range{}, ErrInvalidRange{header}
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return Range{}, ErrInvalidRange{header}
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return Range{}, ErrRangeNotSatisfiable{size}
+	}
+	r.Start, r.End = start, end
+	return r, nil
+}
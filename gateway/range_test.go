@@ -0,0 +1,95 @@
+package gateway_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/gateway"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	testCases := []struct {
+		header string
+		size   int64
+		want   gateway.Range
+		err    error
+	}{
+		{"bytes=0-499", 1000, gateway.Range{Start: 0, End: 499, Size: 1000}, nil},
+		{"bytes=500-", 1000, gateway.Range{Start: 500, End: 999, Size: 1000}, nil},
+		{"bytes=-100", 1000, gateway.Range{Start: 900, End: 999, Size: 1000}, nil},
+		{"bytes=-5000", 1000, gateway.Range{Start: 0, End: 999, Size: 1000}, nil},
+		{"bytes=900-5000", 1000, gateway.Range{Start: 900, End: 999, Size: 1000}, nil},
+		{" bytes= 10 - 20 ", 1000, gateway.Range{Start: 10, End: 20, Size: 1000}, nil},
+		{"bytes=1000-", 1000, gateway.Range{}, gateway.ErrRangeNotSatisfiable{Size: 1000}},
+		{"bytes=-0", 1000, gateway.Range{}, gateway.ErrRangeNotSatisfiable{Size: 1000}},
+		{"bytes=0-", 0, gateway.Range{}, gateway.ErrRangeNotSatisfiable{Size: 0}},
+		{"bytes=0-1,5-6", 1000, gateway.Range{}, gateway.ErrMultipleRanges},
+		{"bytes=20-10", 1000, gateway.Range{}, gateway.ErrInvalidRange{Header: "bytes=20-10"}},
+		{"bytes=abc", 1000, gateway.Range{}, gateway.ErrInvalidRange{Header: "bytes=abc"}},
+		{"items=0-1", 1000, gateway.Range{}, gateway.ErrInvalidRange{Header: "items=0-1"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.header, func(t *testing.T) {
+			r, err := gateway.ParseRange(tc.header, tc.size)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.want, r)
+		})
+	}
+
+	r, err := gateway.ParseRange("bytes=100-199", 1000)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), r.Length())
+	require.Equal(t, "bytes 100-199/1000", r.ContentRange())
+	require.Equal(t, "bytes */1000", gateway.ErrRangeNotSatisfiable{Size: 1000}.ContentRange())
+}
+
+func TestRangeSelector(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	content := random.Bytes(1 << 20)
+	f, size, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-4096", &ls)
+	require.NoError(t, err)
+	entry, err := builder.BuildUnixFSDirectoryEntry("file", int64(size), f)
+	require.NoError(t, err)
+	dir, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{entry}, &ls)
+	require.NoError(t, err)
+
+	r, err := gateway.ParseRange("bytes=10000-29999", int64(len(content)))
+	require.NoError(t, err)
+	sel, err := selector.CompileSelector(r.Selector("/file"))
+	require.NoError(t, err)
+
+	var loads int
+	ls.StorageReadOpener = func(lc ipld.LinkContext, l ipld.Link) (io.Reader, error) {
+		loads++
+		return storage.OpenRead(lc, l)
+	}
+	cfg := &traversal.Config{LinkSystem: ls}
+	unixfsnode.AddUnixFSReificationToTraversalConfig(cfg)
+	rootNode, err := cfg.LinkSystem.Load(ipld.LinkContext{}, dir, dagpb.Type.PBNode)
+	require.NoError(t, err)
+
+	var matched []byte
+	prog := traversal.Progress{Cfg: cfg}
+	require.NoError(t, prog.WalkMatching(rootNode, sel, func(_ traversal.Progress, n datamodel.Node) error {
+		matched, err = n.AsBytes()
+		return err
+	}))
+	require.Equal(t, content[r.Start:r.End+1], matched)
+	// the range spans 6 leaves, under a root and a directory
+	require.Less(t, loads, 20)
+}
@@ -0,0 +1,221 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/schema"
+	"github.com/multiformats/go-multicodec"
+)
+
+// ErrNoLink is returned by ResolvePath when the UnixFS node reached has no
+// entry named by the next segment of the path, with the Resolution up to that
+// node, which servers answer with 404 Not Found.
+type ErrNoLink struct {
+	Name string
+	Node cid.Cid
+}
+
+func (e ErrNoLink) Error() string {
+	return fmt.Sprintf("no link named %q under %s", e.Name, e.Node)
+}
+
+// ErrSymlinkLoop is returned by ResolvePath, when following symlinks, for a
+// path that goes through more symlinks than allowed or through a loop of
+// symlinks, as ELOOP is by file systems. It comes with the Resolution up to
+// the symlink that wasn't followed.
+type ErrSymlinkLoop struct {
+	// Path is the path, from the root, of the symlink that wasn't followed
+	Path string
+	// Hops is the number of symlinks reached, including the one that wasn't
+	// followed
+	Hops int
+}
+
+func (e ErrSymlinkLoop) Error() string {
+	return fmt.Sprintf("too many levels of symbolic links at %q, after %d", e.Path, e.Hops)
+}
+
+// ResolveOption configures how ResolvePath resolves a path.
+type ResolveOption func(*resolveOptions)
+
+type resolveOptions struct {
+	maxSymlinkHops int
+}
+
+// WithFollowSymlinks makes ResolvePath follow the symlinks it reaches through
+// a segment of the path, including the last, following no more than maxHops
+// of them. Linux follows up to 40. A relative target is resolved from the
+// directory holding the symlink and an absolute one from the root, and ".."
+// never leads above the root, so a symlink can't lead out of the DAG.
+// Gateways don't follow symlinks, leaving them to clients, so by default
+// ResolvePath doesn't either.
+func WithFollowSymlinks(maxHops int) ResolveOption {
+	return func(o *resolveOptions) {
+		o.maxSymlinkHops = maxHops
+	}
+}
+
+// Resolution is how far ResolvePath got along a path.
+type Resolution struct {
+	// Resolved is the part of the path that was resolved, from the root to Cid
+	Resolved datamodel.Path
+	// Cid is the CID of the last node resolved, the root if no segment was
+	Cid cid.Cid
+	// Node is the last node resolved, reified as UnixFS if it's dag-pb. It is
+	// nil if the node couldn't be loaded.
+	Node ipld.Node
+	// Remainder is the part of the path left after Resolved: the segments
+	// within Node if it isn't UnixFS, such as dag-cbor, for the caller to
+	// resolve as an IPLD path, or the segments from the one that wasn't found
+	Remainder datamodel.Path
+}
+
+// ResolvePath resolves the UnixFS path under root, one segment at a time, as
+// a gateway does for /ipfs/<root>/<path>. Segments are looked up as the names
+// of directory entries, including in sharded directories, but never as
+// indexes. Resolution stops at the first node that isn't dag-pb or raw,
+// leaving the rest of the path in the Remainder, so that the caller can
+// resolve it within that node, or redirect to where it leads.
+//
+// The Resolution is returned with any error, so that errors can report the
+// part of the path that resolved. If an entry isn't found, the error is an
+// ErrNoLink, and the Remainder starts with the segment that wasn't found.
+//
+// With WithFollowSymlinks, a symlink reached is replaced by its target in the
+// path, and resolution starts again from the root, so the Resolution is that
+// of the path with every symlink followed. Symlinks are followed until the
+// limit given or until the same path is reached twice, in which case the
+// error is an ErrSymlinkLoop.
+func ResolvePath(ctx context.Context, lsys *ipld.LinkSystem, root cid.Cid, path string, opts ...ResolveOption) (Resolution, error) {
+	var o resolveOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	segs := datamodel.ParsePath(path).Segments()
+	res := Resolution{Cid: root, Remainder: datamodel.NewPath(segs)}
+	var hops int
+	visited := map[string]bool{res.Remainder.String(): true}
+	for i := 0; ; i++ {
+		nd, err := loadReified(ctx, lsys, res.Cid)
+		if err != nil {
+			return res, err
+		}
+		res.Node = nd
+		if target, ok := symlinkTarget(nd); ok && i > 0 && o.maxSymlinkHops > 0 {
+			hops++
+			next := followSymlink(segs[:i-1], target, segs[i:])
+			if hops > o.maxSymlinkHops || visited[next.String()] {
+				return res, ErrSymlinkLoop{Path: res.Resolved.String(), Hops: hops}
+			}
+			visited[next.String()] = true
+			segs = next.Segments()
+			res = Resolution{Cid: root, Remainder: next}
+			i = -1
+			continue
+		}
+		if i == len(segs) {
+			return res, nil
+		}
+		if codec := multicodec.Code(res.Cid.Prefix().Codec); codec != multicodec.DagPb && codec != multicodec.Raw {
+			return res, nil
+		}
+
+		name := segs[i].String()
+		child, err := lookup(nd, name)
+		if err != nil {
+			return res, err
+		}
+		if child == nil {
+			return res, ErrNoLink{Name: name, Node: res.Cid}
+		}
+		res.Cid = child.(cidlink.Link).Cid
+		res.Node = nil
+		res.Resolved = datamodel.NewPath(segs[:i+1])
+		res.Remainder = datamodel.NewPath(segs[i+1:])
+	}
+}
+
+// lookup returns the link of the entry called name in a reified UnixFS node,
+// or nil if there is no such entry, including when the node isn't a
+// directory.
+func lookup(nd ipld.Node, name string) (ipld.Link, error) {
+	if nd.Kind() != ipld.Kind_Map {
+		return nil, nil
+	}
+	entry, err := nd.LookupByString(name)
+	if err != nil {
+		var noSuchField schema.ErrNoSuchField
+		var notExists datamodel.ErrNotExists
+		if errors.As(err, &noSuchField) || errors.As(err, &notExists) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	lnk, err := entry.AsLink()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := lnk.(cidlink.Link); !ok {
+		return nil, fmt.Errorf("entry %q is not a CID link", name)
+	}
+	return lnk, nil
+}
+
+// symlinkTarget returns the target of n if it is a UnixFS symlink.
+func symlinkTarget(n ipld.Node) (string, bool) {
+	pbn, ok := unixfsnode.Substrate(n).(dagpb.PBNode)
+	if !ok || !pbn.FieldData().Exists() {
+		return "", false
+	}
+	ufsData, err := data.DecodeUnixFSData(pbn.FieldData().Must().Bytes())
+	if err != nil || ufsData.FieldDataType().Int() != data.Data_Symlink || !ufsData.FieldData().Exists() {
+		return "", false
+	}
+	return string(ufsData.FieldData().Must().Bytes()), true
+}
+
+// followSymlink returns the path that a symlink in the directory at dir,
+// reached with rest left to resolve, leads to, cleaned of "." and "..".
+func followSymlink(dir []datamodel.PathSegment, target string, rest []datamodel.PathSegment) datamodel.Path {
+	var segs []datamodel.PathSegment
+	if !strings.HasPrefix(target, "/") {
+		segs = append(segs, dir...)
+	}
+	for _, name := range strings.Split(target, "/") {
+		switch name {
+		case "", ".":
+		case "..":
+			if len(segs) > 0 {
+				segs = segs[:len(segs)-1]
+			}
+		default:
+			segs = append(segs, datamodel.PathSegmentOfString(name))
+		}
+	}
+	return datamodel.NewPath(append(segs, rest...))
+}
+
+// loadReified loads the node of c, reifying it as UnixFS if it's dag-pb.
+func loadReified(ctx context.Context, lsys *ipld.LinkSystem, c cid.Cid) (ipld.Node, error) {
+	lctx := ipld.LinkContext{Ctx: ctx}
+	proto, err := dagpb.AddSupportToChooser(basicnode.Chooser)(cidlink.Link{Cid: c}, lctx)
+	if err != nil {
+		return nil, err
+	}
+	nd, err := lsys.Load(lctx, cidlink.Link{Cid: c}, proto)
+	if err != nil {
+		return nil, err
+	}
+	return unixfsnode.Reify(lctx, nd, lsys)
+}
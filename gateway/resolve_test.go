@@ -0,0 +1,182 @@
+package gateway_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/gateway"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolvePath(t *testing.T) {
+	ctx := context.Background()
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	entry := func(name string, lnk ipld.Link, size uint64) dagpb.PBLink {
+		e, err := builder.BuildUnixFSDirectoryEntry(name, int64(size), lnk)
+		require.NoError(t, err)
+		return e
+	}
+
+	file, fileSize, err := builder.BuildUnixFSFile(bytes.NewReader([]byte("hello")), "", &ls)
+	require.NoError(t, err)
+	shardEntries := make([]dagpb.PBLink, 0, 100)
+	for i := 0; i < 100; i++ {
+		shardEntries = append(shardEntries, entry(fmt.Sprintf("file %d", i), file, fileSize))
+	}
+	shard, shardSize, err := builder.BuildUnixFSShardedDirectory(16, 0x22, shardEntries, &ls)
+	require.NoError(t, err)
+
+	cbor, err := qp.BuildMap(basicnode.Prototype.Any, 1, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "x", qp.Map(1, func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "y", qp.Int(1))
+		}))
+	})
+	require.NoError(t, err)
+	cborLnk, err := ls.Store(ipld.LinkContext{}, cidlink.LinkPrototype{Prefix: cid.Prefix{
+		Version:  1,
+		Codec:    uint64(multicodec.DagCbor),
+		MhType:   uint64(multicodec.Sha2_256),
+		MhLength: -1,
+	}}, cbor)
+	require.NoError(t, err)
+
+	root, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{
+		entry("docs", shard, shardSize),
+		entry("data", cborLnk, 0),
+		entry("hello.txt", file, fileSize),
+	}, &ls)
+	require.NoError(t, err)
+	rootCid := root.(cidlink.Link).Cid
+	fileCid := file.(cidlink.Link).Cid
+
+	res, err := gateway.ResolvePath(ctx, &ls, rootCid, "")
+	require.NoError(t, err)
+	require.Equal(t, rootCid, res.Cid)
+	require.Equal(t, 0, res.Resolved.Len())
+	require.Equal(t, 0, res.Remainder.Len())
+	require.Equal(t, datamodel.Kind_Map, res.Node.Kind())
+
+	res, err = gateway.ResolvePath(ctx, &ls, rootCid, "/docs/file 42")
+	require.NoError(t, err)
+	require.Equal(t, fileCid, res.Cid)
+	require.Equal(t, "docs/file 42", res.Resolved.String())
+	require.Equal(t, 0, res.Remainder.Len())
+	content, err := res.Node.AsBytes()
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(content))
+
+	// a dag-cbor tail is left for the caller
+	res, err = gateway.ResolvePath(ctx, &ls, rootCid, "data/x/y")
+	require.NoError(t, err)
+	require.Equal(t, cborLnk.(cidlink.Link).Cid, res.Cid)
+	require.Equal(t, "data", res.Resolved.String())
+	require.Equal(t, "x/y", res.Remainder.String())
+	require.Equal(t, cbor, res.Node)
+
+	// a missing entry in a sharded directory
+	res, err = gateway.ResolvePath(ctx, &ls, rootCid, "docs/missing/more")
+	require.ErrorIs(t, err, gateway.ErrNoLink{Name: "missing", Node: shard.(cidlink.Link).Cid})
+	require.Equal(t, shard.(cidlink.Link).Cid, res.Cid)
+	require.Equal(t, "docs", res.Resolved.String())
+	require.Equal(t, "missing/more", res.Remainder.String())
+
+	// entries are not looked up by index, and files have no entries
+	_, err = gateway.ResolvePath(ctx, &ls, rootCid, "0")
+	require.ErrorIs(t, err, gateway.ErrNoLink{Name: "0", Node: rootCid})
+	res, err = gateway.ResolvePath(ctx, &ls, rootCid, "hello.txt/x")
+	require.ErrorIs(t, err, gateway.ErrNoLink{Name: "x", Node: fileCid})
+	require.Equal(t, "hello.txt", res.Resolved.String())
+
+	// a block that can't be loaded
+	missing, err := cid.Prefix{Version: 1, Codec: uint64(multicodec.DagPb), MhType: uint64(multicodec.Sha2_256), MhLength: -1}.Sum([]byte("missing"))
+	require.NoError(t, err)
+	res, err = gateway.ResolvePath(ctx, &ls, missing, "a")
+	require.Error(t, err)
+	require.Nil(t, res.Node)
+	require.Equal(t, "a", res.Remainder.String())
+}
+
+func TestResolvePathSymlinks(t *testing.T) {
+	ctx := context.Background()
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	entry := func(name string, lnk ipld.Link, size uint64) dagpb.PBLink {
+		e, err := builder.BuildUnixFSDirectoryEntry(name, int64(size), lnk)
+		require.NoError(t, err)
+		return e
+	}
+	symlink := func(name, target string) dagpb.PBLink {
+		lnk, size, err := builder.BuildUnixFSSymlink(target, &ls)
+		require.NoError(t, err)
+		return entry(name, lnk, size)
+	}
+
+	file, fileSize, err := builder.BuildUnixFSFile(bytes.NewReader([]byte("hello")), "", &ls)
+	require.NoError(t, err)
+	sub, subSize, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{
+		entry("hello.txt", file, fileSize),
+		symlink("up", ".."),
+		symlink("self", "self"),
+		symlink("escape", "../../../sub/hello.txt"),
+	}, &ls)
+	require.NoError(t, err)
+	root, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{
+		entry("sub", sub, subSize),
+		symlink("link", "sub/hello.txt"),
+		symlink("dir", "/sub"),
+		symlink("ping", "pong"),
+		symlink("pong", "ping"),
+	}, &ls)
+	require.NoError(t, err)
+	rootCid := root.(cidlink.Link).Cid
+	fileCid := file.(cidlink.Link).Cid
+
+	// symlinks are not followed by default
+	res, err := gateway.ResolvePath(ctx, &ls, rootCid, "link")
+	require.NoError(t, err)
+	require.NotEqual(t, fileCid, res.Cid)
+	res, err = gateway.ResolvePath(ctx, &ls, rootCid, "dir/hello.txt")
+	require.ErrorIs(t, err, gateway.ErrNoLink{Name: "hello.txt", Node: res.Cid})
+	require.Equal(t, "dir", res.Resolved.String())
+
+	follow := gateway.WithFollowSymlinks(40)
+	for path, expected := range map[string]string{
+		"link":                 "sub/hello.txt",
+		"dir/hello.txt":        "sub/hello.txt",
+		"sub/up/link":          "sub/hello.txt",
+		"sub/escape":           "sub/hello.txt",
+		"dir/up/dir/hello.txt": "sub/hello.txt",
+	} {
+		res, err := gateway.ResolvePath(ctx, &ls, rootCid, path, follow)
+		require.NoError(t, err, path)
+		require.Equal(t, fileCid, res.Cid, path)
+		require.Equal(t, expected, res.Resolved.String(), path)
+	}
+
+	for _, path := range []string{"ping", "sub/self", "ping/more"} {
+		_, err := gateway.ResolvePath(ctx, &ls, rootCid, path, follow)
+		var loop gateway.ErrSymlinkLoop
+		require.ErrorAs(t, err, &loop, path)
+	}
+	_, err = gateway.ResolvePath(ctx, &ls, rootCid, "dir/up/dir/hello.txt", gateway.WithFollowSymlinks(2))
+	require.ErrorIs(t, err, gateway.ErrSymlinkLoop{Path: "dir", Hops: 3})
+}
@@ -0,0 +1,111 @@
+package hamt
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// BatchLoader is an optional interface for block storage that can fetch many
+// blocks in one request, such as a remote blockstore where each request costs
+// a round trip.
+//
+// When a BatchLoader is attached to the context used to construct a shard (see
+// WithBatchLoader), the child shards of a shard are requested together the
+// first time the shard is fully traversed, by iteration or by counting its
+// entries, rather than one at a time as each is reached. Lookups still load
+// only the shards on the way to the key.
+type BatchLoader interface {
+	// LoadBlocks returns the raw bytes of the blocks it has for the CIDs given.
+	// Blocks it doesn't have are left out of the result rather than causing an
+	// error; they are then loaded through the LinkSystem one at a time.
+	LoadBlocks(ctx context.Context, cids []cid.Cid) (map[cid.Cid][]byte, error)
+}
+
+type batchLoaderKey struct{}
+
+// WithBatchLoader returns a context carrying bl. HAMT shards created with this
+// context, either directly or through reification on a LinkSystem, will use bl
+// to load their child shards. Blocks returned by bl are decoded, and verified
+// unless the LinkSystem has TrustedStorage, as if they were read from the
+// LinkSystem's storage.
+func WithBatchLoader(ctx context.Context, bl BatchLoader) context.Context {
+	return context.WithValue(ctx, batchLoaderKey{}, bl)
+}
+
+func batchLoaderFrom(ctx context.Context) BatchLoader {
+	if ctx == nil {
+		return nil
+	}
+	bl, _ := ctx.Value(batchLoaderKey{}).(BatchLoader)
+	return bl
+}
+
+// prefetchChildren loads the child shards of n that aren't cached yet with
+// the context's BatchLoader, if there is one. It does nothing after its first
+// call on a shard.
+func (n UnixFSHAMTShard) prefetchChildren() error {
+	if n.prefetched {
+		return nil
+	}
+	n.prefetched = true
+	bl := batchLoaderFrom(n.ctx)
+	if bl == nil {
+		return nil
+	}
+
+	maxPadLen := maxPadLength(n.data)
+	var cids []cid.Cid
+	var links []dagpb.PBLink
+	itr := n.FieldLinks().Iterator()
+	for !itr.Done() {
+		_, pbLink := itr.Next()
+		isValue, err := isValueLink(pbLink, maxPadLen)
+		if err != nil {
+			return err
+		}
+		if isValue {
+			continue
+		}
+		cl, ok := pbLink.FieldHash().Link().(cidlink.Link)
+		if !ok {
+			continue
+		}
+		if _, ok := n.shardCache[cl]; ok {
+			continue
+		}
+		cids = append(cids, cl.Cid)
+		links = append(links, pbLink)
+	}
+	if len(cids) == 0 {
+		return nil
+	}
+
+	blocks, err := bl.LoadBlocks(n.ctx, cids)
+	if err != nil {
+		return err
+	}
+	batched := *n.lsys
+	batched.StorageReadOpener = func(lnkCtx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		if cl, ok := lnk.(cidlink.Link); ok {
+			if blk, ok := blocks[cl.Cid]; ok {
+				return bytes.NewReader(blk), nil
+			}
+		}
+		return n.lsys.StorageReadOpener(lnkCtx, lnk)
+	}
+	for i, pbLink := range links {
+		if _, ok := blocks[cids[i]]; !ok {
+			continue
+		}
+		if _, err := n.loadChildFrom(&batched, pbLink); err != nil {
+			return err
+		}
+	}
+	return nil
+}
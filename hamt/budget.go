@@ -0,0 +1,43 @@
+package hamt
+
+import (
+	"context"
+	"fmt"
+)
+
+type lookupBudgetKey struct{}
+
+// WithLookupBudget returns a context carrying a limit on the number of blocks
+// a single lookup by name may load. HAMT shards created with this context,
+// either directly or through reification on a LinkSystem, fail a lookup with
+// ErrLookupBudgetExceeded rather than load more than max child shards. Shards
+// already loaded by earlier lookups or iteration don't count against the
+// budget. This bounds the work done looking names up in untrusted, possibly
+// maliciously deep, shards.
+func WithLookupBudget(ctx context.Context, max int) context.Context {
+	return context.WithValue(ctx, lookupBudgetKey{}, max)
+}
+
+// lookupBudget returns the number of blocks a lookup may load, or nil if
+// there is no limit.
+func lookupBudget(ctx context.Context) *int {
+	if ctx == nil {
+		return nil
+	}
+	max, ok := ctx.Value(lookupBudgetKey{}).(int)
+	if !ok {
+		return nil
+	}
+	return &max
+}
+
+// ErrLookupBudgetExceeded is returned by lookups that would load more blocks
+// than allowed by WithLookupBudget.
+type ErrLookupBudgetExceeded struct {
+	Key    string
+	Budget int
+}
+
+func (e ErrLookupBudgetExceeded) Error() string {
+	return fmt.Sprintf("looking up %q would load more than %d blocks", e.Key, e.Budget)
+}
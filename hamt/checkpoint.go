@@ -0,0 +1,110 @@
+package hamt
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ipfs/go-unixfsnode/iter"
+	"github.com/ipld/go-ipld-prime"
+)
+
+// CheckpointIterator is a MapIterator over the entries of a HAMT whose
+// position can be saved with Checkpoint, so that a long enumeration can be
+// picked up again later, possibly by another process, with ResumeIterator.
+type CheckpointIterator struct {
+	ipld.MapIterator
+	list *_UnixFSShardedDir__ListItr
+}
+
+// checkpoint is the content of the tokens made by CheckpointIterator.Checkpoint.
+type checkpoint struct {
+	// Path is the number of links taken from each shard on the way to the
+	// current position, starting from the root
+	Path []int64 `json:"path"`
+	// Index is the number of entries returned so far
+	Index int64 `json:"index"`
+}
+
+// CheckpointIterator returns an iterator over the entries of the HAMT, as
+// MapIterator does, starting from the first entry.
+func (n UnixFSHAMTShard) CheckpointIterator() *CheckpointIterator {
+	return n.newCheckpointIterator(n.newListItr())
+}
+
+// ResumeIterator returns an iterator continuing the iteration a token was
+// taken from, with Checkpoint, at the entry after the last one it returned.
+// The token must come from an iterator over the same HAMT. Only the shards on
+// the way to the position are loaded to resume.
+func (n UnixFSHAMTShard) ResumeIterator(token []byte) (*CheckpointIterator, error) {
+	var cp checkpoint
+	if err := json.Unmarshal(token, &cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint: %w", err)
+	}
+	if len(cp.Path) == 0 || cp.Index < 0 {
+		return nil, fmt.Errorf("invalid checkpoint")
+	}
+	list := n.newListItr()
+	list.total = cp.Index
+	itr := list
+	for depth, taken := range cp.Path {
+		if taken < 0 || taken > itr.nd.FieldLinks().Length() {
+			return nil, fmt.Errorf("invalid checkpoint: shard at depth %d has no link %d", depth, taken)
+		}
+		for itr.taken < taken {
+			itr._substrate.Next()
+			itr.taken++
+		}
+		if depth == len(cp.Path)-1 {
+			break
+		}
+		// the last link taken is the child shard being iterated
+		if taken == 0 {
+			return nil, fmt.Errorf("invalid checkpoint: no shard taken at depth %d", depth)
+		}
+		pbLink := itr.nd.FieldLinks().Lookup(taken - 1)
+		isValue, err := isValueLink(pbLink, itr.maxPadLen)
+		if err != nil {
+			return nil, err
+		}
+		if isValue {
+			return nil, fmt.Errorf("invalid checkpoint: link %d at depth %d is not a shard", taken-1, depth)
+		}
+		child, err := itr.nd.loadChild(pbLink)
+		if err != nil {
+			return nil, err
+		}
+		itr.childIter = &_UnixFSShardedDir__ListItr{
+			_substrate: child._substrate.FieldLinks().Iterator(),
+			nd:         child,
+			maxPadLen:  maxPadLength(child.data),
+		}
+		itr = itr.childIter
+	}
+	return n.newCheckpointIterator(list), nil
+}
+
+func (n UnixFSHAMTShard) newListItr() *_UnixFSShardedDir__ListItr {
+	return &_UnixFSShardedDir__ListItr{
+		_substrate: n.FieldLinks().Iterator(),
+		maxPadLen:  maxPadLength(n.data),
+		nd:         n,
+	}
+}
+
+func (n UnixFSHAMTShard) newCheckpointIterator(list *_UnixFSShardedDir__ListItr) *CheckpointIterator {
+	st := stringTransformer{maxPadLen: list.maxPadLen}
+	return &CheckpointIterator{
+		MapIterator: iter.NewUnixFSDirMapIterator(list, st.transformNameNode),
+		list:        list,
+	}
+}
+
+// Checkpoint returns an opaque token recording the position of the iterator,
+// for ResumeIterator.
+func (itr *CheckpointIterator) Checkpoint() ([]byte, error) {
+	cp := checkpoint{Index: itr.list.total}
+	for l := itr.list; l != nil; l = l.childIter {
+		cp.Path = append(cp.Path, l.taken)
+	}
+	return json.Marshal(cp)
+}
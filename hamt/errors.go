@@ -1,6 +1,13 @@
 package hamt
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+)
 
 type errorType string
 
@@ -38,3 +45,114 @@ type ErrInvalidLinkName struct {
 func (e ErrInvalidLinkName) Error() string {
 	return fmt.Sprintf("invalid link name '%s'", e.Name)
 }
+
+// ErrUnsupportedHashType indicates a HAMT node's hash function is not murmur3.
+// It matches ErrInvalidHashType with errors.Is.
+type ErrUnsupportedHashType struct {
+	HashType uint64
+}
+
+func (e ErrUnsupportedHashType) Error() string {
+	return fmt.Sprintf("unsupported HAMT hash type 0x%x: %s", e.HashType, ErrInvalidHashType)
+}
+
+func (e ErrUnsupportedHashType) Is(target error) bool {
+	return target == ErrInvalidHashType
+}
+
+// ErrInvalidFanout indicates a HAMT node's fanout is not a power of two, or
+// is outside the range of widths that can be read. It matches
+// ErrHAMTSizeInvalid with errors.Is.
+type ErrInvalidFanout struct {
+	Fanout int64
+}
+
+func (e ErrInvalidFanout) Error() string {
+	return fmt.Sprintf("invalid HAMT fanout %d: must be a power of two from %d to %d", e.Fanout, minimumHamtWidth, MaxWidth)
+}
+
+func (e ErrInvalidFanout) Is(target error) bool {
+	return target == ErrHAMTSizeInvalid
+}
+
+// ErrInvalidBitfield indicates a HAMT node's bitfield has more bytes than its
+// fanout has bits for.
+type ErrInvalidBitfield struct {
+	Length int
+	Fanout int64
+}
+
+func (e ErrInvalidBitfield) Error() string {
+	return fmt.Sprintf("HAMT bitfield of %d bytes is too long for a fanout of %d", e.Length, e.Fanout)
+}
+
+// ErrBitfieldLinkCount indicates the bits set in a HAMT node's bitfield can't
+// account for its links: there are more bits set than links, or links but no
+// bits set.
+type ErrBitfieldLinkCount struct {
+	Set   int
+	Links int64
+}
+
+func (e ErrBitfieldLinkCount) Error() string {
+	return fmt.Sprintf("HAMT bitfield has %d bits set for %d links", e.Set, e.Links)
+}
+
+// ErrMissingShard indicates a shard below the root of a HAMT could not be
+// loaded. Every key whose hash begins with the bucket indexes in Prefix is
+// stored under the missing shard, so once its block has been fetched, a
+// lookup or iteration over those keys can be retried.
+type ErrMissingShard struct {
+	// Cid is the CID of the shard that could not be loaded.
+	Cid cid.Cid
+	// Prefix holds the index of the bucket taken at each level of the HAMT,
+	// from the root down to the missing shard.
+	Prefix []int
+	// Fanout is the number of buckets at each level of the HAMT.
+	Fanout int
+	// Err is the error the shard failed to load with.
+	Err error
+}
+
+func (e ErrMissingShard) Error() string {
+	return fmt.Sprintf("could not load HAMT shard %s at prefix %s: %s", e.Cid, e.PrefixString(), e.Err)
+}
+
+func (e ErrMissingShard) Unwrap() error {
+	return e.Err
+}
+
+// NotFound reports whether the shard failed to load because its block was not
+// found, matching the NotFound method of go-ipld-format's ErrNotFound.
+func (e ErrMissingShard) NotFound() bool {
+	var nf interface{ NotFound() bool }
+	return errors.As(e.Err, &nf) && nf.NotFound()
+}
+
+// PrefixString formats Prefix the way the HAMT names links to shards, as
+// padded hex digits, with levels separated by "/".
+func (e ErrMissingShard) PrefixString() string {
+	width := len(fmt.Sprintf("%X", e.Fanout-1))
+	parts := make([]string, len(e.Prefix))
+	for i, idx := range e.Prefix {
+		parts[i] = fmt.Sprintf("%0*X", width, idx)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Covers reports whether key is stored under the missing shard, if it is in
+// the HAMT at all.
+func (e ErrMissingShard) Covers(key string) bool {
+	if err := checkLogTwo(e.Fanout); err != nil {
+		return false
+	}
+	log2 := bits.TrailingZeros(uint(e.Fanout))
+	hv := &hashBits{b: hash([]byte(key))}
+	for _, idx := range e.Prefix {
+		next, err := hv.Next(log2)
+		if err != nil || next != idx {
+			return false
+		}
+	}
+	return true
+}
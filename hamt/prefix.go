@@ -0,0 +1,83 @@
+package hamt
+
+import (
+	"strings"
+
+	"github.com/ipfs/go-unixfsnode/iter"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+)
+
+// PrefixIterator returns an iterator over the entries of the HAMT whose names
+// start with prefix, in the order MapIterator returns them, for listings such
+// as autocompletion over very large directories. Entries that don't match are
+// skipped without building nodes for them.
+//
+// Entries are placed in a HAMT by the hash of their whole name, and names
+// sharing a prefix hash no closer together than any others, so no shard can
+// be ruled out from its position in the HAMT: every shard is still loaded,
+// with the context's BatchLoader if there is one. Done looks ahead for the
+// next match, so it can load shards too. As with MapIterator, a shard that
+// can't be loaded is reported by Next as an ErrMissingShard, and iteration
+// continues with the shard after it.
+func (n UnixFSHAMTShard) PrefixIterator(prefix string) ipld.MapIterator {
+	list := n.newListItr()
+	st := stringTransformer{maxPadLen: list.maxPadLen}
+	return iter.NewUnixFSDirMapIterator(&prefixListItr{list: list, prefix: prefix}, st.transformNameNode)
+}
+
+// prefixListItr yields the links of list naming entries that start with
+// prefix.
+type prefixListItr struct {
+	list   *_UnixFSShardedDir__ListItr
+	prefix string
+	total  int64
+	// next is the next matching link, found ahead of Next by Done
+	next dagpb.PBLink
+	// err is an error met looking for the next match, returned by Next
+	err error
+}
+
+func (itr *prefixListItr) Next() (int64, dagpb.PBLink, error) {
+	itr.advance()
+	if itr.err != nil {
+		err := itr.err
+		itr.err = nil
+		return -1, nil, err
+	}
+	if itr.next == nil {
+		return -1, nil, nil
+	}
+	next := itr.next
+	itr.next = nil
+	total := itr.total
+	itr.total++
+	return total, next, nil
+}
+
+func (itr *prefixListItr) Done() bool {
+	itr.advance()
+	return itr.next == nil && itr.err == nil
+}
+
+// advance looks for the next match, unless there is one or an error waiting
+// to be returned.
+func (itr *prefixListItr) advance() {
+	for itr.next == nil && itr.err == nil && !itr.list.Done() {
+		_, next, err := itr.list.Next()
+		if err != nil {
+			itr.err = err
+			return
+		}
+		if next == nil {
+			return
+		}
+		if !next.FieldName().Exists() {
+			continue
+		}
+		name := next.FieldName().Must().String()
+		if len(name) >= itr.list.maxPadLen && strings.HasPrefix(name[itr.list.maxPadLen:], itr.prefix) {
+			itr.next = next
+		}
+	}
+}
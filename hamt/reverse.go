@@ -0,0 +1,129 @@
+package hamt
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/ipfs/go-unixfsnode/iter"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+)
+
+// ReverseSortedIterator returns an iterator over the entries of the HAMT in
+// reverse order of their names, compared bytewise. If limit is positive, only
+// the limit entries with the greatest names are returned, the last page of a
+// listing by name, and no more than that many links are kept while reading.
+//
+// Entries are placed in a HAMT by the hash of their names, so every shard is
+// read before the first entry is returned, with the context's BatchLoader if
+// there is one, as for PrefixIterator. Only the links of the entries are kept
+// until they are returned; no node is built for an entry left out. A shard
+// that can't be loaded is reported by Next as an ErrMissingShard before any
+// entry, and the entries below it are left out.
+func (n UnixFSHAMTShard) ReverseSortedIterator(limit int) ipld.MapIterator {
+	list := n.newListItr()
+	st := stringTransformer{maxPadLen: list.maxPadLen}
+	return iter.NewUnixFSDirMapIterator(&reverseSortedListItr{list: list, limit: limit}, st.transformNameNode)
+}
+
+// reverseSortedListItr yields the links of list by name, from the last, once
+// it has read them all.
+type reverseSortedListItr struct {
+	list  *_UnixFSShardedDir__ListItr
+	limit int
+	read  bool
+	// errs are the errors met reading list, returned first
+	errs  []error
+	links []dagpb.PBLink
+	total int64
+}
+
+func (itr *reverseSortedListItr) Next() (int64, dagpb.PBLink, error) {
+	itr.readAll()
+	if len(itr.errs) > 0 {
+		err := itr.errs[0]
+		itr.errs = itr.errs[1:]
+		return -1, nil, err
+	}
+	if len(itr.links) == 0 {
+		return -1, nil, nil
+	}
+	next := itr.links[0]
+	itr.links = itr.links[1:]
+	total := itr.total
+	itr.total++
+	return total, next, nil
+}
+
+func (itr *reverseSortedListItr) Done() bool {
+	itr.readAll()
+	return len(itr.errs) == 0 && len(itr.links) == 0
+}
+
+// Count returns the number of entries to be returned once they have been
+// read, after the first call to Done or Next.
+func (itr *reverseSortedListItr) Count() (int64, bool) {
+	if !itr.read {
+		return 0, false
+	}
+	return itr.total + int64(len(itr.links)), true
+}
+
+// readAll reads every link from list, keeping the greatest names.
+func (itr *reverseSortedListItr) readAll() {
+	if itr.read {
+		return
+	}
+	itr.read = true
+	kept := &linkHeap{maxPadLen: itr.list.maxPadLen}
+	for !itr.list.Done() {
+		_, next, err := itr.list.Next()
+		if err != nil {
+			itr.errs = append(itr.errs, err)
+			continue
+		}
+		if next == nil {
+			break
+		}
+		if itr.limit <= 0 {
+			kept.links = append(kept.links, next)
+			continue
+		}
+		if len(kept.links) < itr.limit {
+			heap.Push(kept, next)
+		} else if kept.name(next) > kept.name(kept.links[0]) {
+			kept.links[0] = next
+			heap.Fix(kept, 0)
+		}
+	}
+	sort.Sort(sort.Reverse(kept))
+	itr.links = kept.links
+}
+
+// linkHeap is a min-heap of the links of HAMT entries by name, without their
+// bucket prefixes.
+type linkHeap struct {
+	links     []dagpb.PBLink
+	maxPadLen int
+}
+
+func (h *linkHeap) name(lnk dagpb.PBLink) string {
+	if !lnk.FieldName().Exists() {
+		return ""
+	}
+	name := lnk.FieldName().Must().String()
+	if len(name) < h.maxPadLen {
+		return name
+	}
+	return name[h.maxPadLen:]
+}
+
+func (h *linkHeap) Len() int           { return len(h.links) }
+func (h *linkHeap) Less(i, j int) bool { return h.name(h.links[i]) < h.name(h.links[j]) }
+func (h *linkHeap) Swap(i, j int)      { h.links[i], h.links[j] = h.links[j], h.links[i] }
+func (h *linkHeap) Push(x any)         { h.links = append(h.links, x.(dagpb.PBLink)) }
+func (h *linkHeap) Pop() any {
+	last := h.links[len(h.links)-1]
+	h.links = h.links[:len(h.links)-1]
+	return last
+}
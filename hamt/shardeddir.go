@@ -3,12 +3,17 @@ package hamt
 import (
 	"context"
 	"fmt"
+	"strconv"
 
 	bitfield "github.com/ipfs/go-bitfield"
+	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/instrument"
 	"github.com/ipfs/go-unixfsnode/iter"
+	"github.com/ipfs/go-unixfsnode/utils"
 	dagpb "github.com/ipld/go-codec-dagpb"
 	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/schema"
 )
 
@@ -33,12 +38,20 @@ type _UnixFSHAMTShard struct {
 	bitfield     bitfield.Bitfield
 	shardCache   map[ipld.Link]*_UnixFSHAMTShard
 	cachedLength int64
+	// prefix is the index of the bucket taken at each level to reach this
+	// shard, empty for the root
+	prefix []int
+	// prefetched is set once child shards have been requested from a
+	// BatchLoader
+	prefetched bool
 }
 
 // NewUnixFSHAMTShard attempts to construct a UnixFSHAMTShard node from the base protobuf node plus
-// a decoded UnixFSData structure
+// a decoded UnixFSData structure. The header of the shard is validated first: the hash type must
+// be murmur3, the fanout a power of two from 8 to 1024, and the bitfield must fit the fanout and
+// have a bit set for each bucket with links.
 func NewUnixFSHAMTShard(ctx context.Context, substrate dagpb.PBNode, data data.UnixFSData, lsys *ipld.LinkSystem) (ipld.Node, error) {
-	if err := validateHAMTData(data); err != nil {
+	if err := ValidateHAMTData(data); err != nil {
 		return nil, err
 	}
 	shardCache := make(map[ipld.Link]*_UnixFSHAMTShard, substrate.FieldLinks().Length())
@@ -46,6 +59,10 @@ func NewUnixFSHAMTShard(ctx context.Context, substrate dagpb.PBNode, data data.U
 	if err != nil {
 		return nil, err
 	}
+	// a bucket holds more than one link only when entries share a name
+	if set, links := bf.Ones(), substrate.FieldLinks().Length(); int64(set) > links || (set == 0 && links > 0) {
+		return nil, ErrBitfieldLinkCount{Set: set, Links: links}
+	}
 	return &_UnixFSHAMTShard{
 		ctx:          ctx,
 		_substrate:   substrate,
@@ -59,6 +76,7 @@ func NewUnixFSHAMTShard(ctx context.Context, substrate dagpb.PBNode, data data.U
 
 // NewUnixFSHAMTShardWithPreload attempts to construct a UnixFSHAMTShard node from the base protobuf node plus
 // a decoded UnixFSData structure, and then iterate through and load the full set of hamt shards.
+// If ctx carries a BatchLoader, the children of each shard are loaded with a single request.
 func NewUnixFSHAMTShardWithPreload(ctx context.Context, substrate dagpb.PBNode, data data.UnixFSData, lsys *ipld.LinkSystem) (ipld.Node, error) {
 	n, err := NewUnixFSHAMTShard(ctx, substrate, data, lsys)
 	if err != nil {
@@ -84,13 +102,19 @@ func (n UnixFSHAMTShard) Kind() ipld.Kind {
 	return n._substrate.Kind()
 }
 
-// LookupByString looks for the key in the list of links with a matching name
+// LookupByString looks for the key in the list of links with a matching name.
+// If a shard on the way to the key can't be loaded, an ErrMissingShard is
+// returned. If the shard was created with a context from WithLookupBudget,
+// an ErrLookupBudgetExceeded is returned if the key is further away than the
+// budget allows.
 func (n *_UnixFSHAMTShard) LookupByString(key string) (ipld.Node, error) {
 	hv := &hashBits{b: hash([]byte(key))}
-	return n.lookup(key, hv)
+	return n.lookup(key, hv, lookupBudget(n.ctx))
 }
 
-func (n UnixFSHAMTShard) lookup(key string, hv *hashBits) (dagpb.Link, error) {
+// lookup finds the key below n, loading no more than *budget child shards
+// unless budget is nil.
+func (n UnixFSHAMTShard) lookup(key string, hv *hashBits, budget *int) (dagpb.Link, error) {
 	log2 := log2Size(n.data)
 	maxPadLen := maxPadLength(n.data)
 	childIndex, err := hv.Next(log2)
@@ -112,11 +136,17 @@ func (n UnixFSHAMTShard) lookup(key string, hv *hashBits) (dagpb.Link, error) {
 				return pbLink.FieldHash(), nil
 			}
 		} else {
+			if _, cached := n.shardCache[pbLink.FieldHash().Link()]; budget != nil && !cached {
+				if *budget <= 0 {
+					return nil, ErrLookupBudgetExceeded{Key: key, Budget: *lookupBudget(n.ctx)}
+				}
+				*budget--
+			}
 			childNd, err := n.loadChild(pbLink)
 			if err != nil {
 				return nil, err
 			}
-			return childNd.lookup(key, hv)
+			return childNd.lookup(key, hv, budget)
 		}
 	}
 	return nil, schema.ErrNoSuchField{Type: nil /*TODO*/, Field: ipld.PathSegmentOfString(key)}
@@ -129,6 +159,10 @@ func AttemptHAMTShardFromNode(ctx context.Context, nd ipld.Node, lsys *ipld.Link
 	if ok {
 		return hnd, nil
 	}
+	// unwrap nodes from other reifiers that wrap a shard
+	if a, ok := nd.(ipld.ADL); ok {
+		nd = a.Substrate()
+	}
 	pbnd, ok := nd.(dagpb.PBNode)
 	if !ok {
 		return nil, fmt.Errorf("hamt.AttemptHAMTShardFromNode: %w", ErrNotProtobuf)
@@ -136,7 +170,7 @@ func AttemptHAMTShardFromNode(ctx context.Context, nd ipld.Node, lsys *ipld.Link
 	if !pbnd.FieldData().Exists() {
 		return nil, fmt.Errorf("hamt.AttemptHAMTShardFromNode: %w", ErrNotUnixFSNode)
 	}
-	data, err := data.DecodeUnixFSData(pbnd.FieldData().Must().Bytes())
+	data, err := data.DecodeUnixFSDataZeroCopy(pbnd.FieldData().Must().Bytes())
 	if err != nil {
 		return nil, err
 	}
@@ -148,18 +182,35 @@ func AttemptHAMTShardFromNode(ctx context.Context, nd ipld.Node, lsys *ipld.Link
 }
 
 func (n UnixFSHAMTShard) loadChild(pbLink dagpb.PBLink) (UnixFSHAMTShard, error) {
+	return n.loadChildFrom(n.lsys, pbLink)
+}
+
+// loadChildFrom loads a child shard with lsys, which may differ from the
+// shard's own LinkSystem in where it reads blocks from.
+func (n UnixFSHAMTShard) loadChildFrom(lsys *ipld.LinkSystem, pbLink dagpb.PBLink) (UnixFSHAMTShard, error) {
 	cached, ok := n.shardCache[pbLink.FieldHash().Link()]
 	if ok {
 		return cached, nil
 	}
-	nd, err := n.lsys.Load(ipld.LinkContext{Ctx: n.ctx}, pbLink.FieldHash().Link(), dagpb.Type.PBNode)
+	maxPadLen := maxPadLength(n.data)
+	childIndex, err := strconv.ParseUint(pbLink.FieldName().Must().String()[:maxPadLen], 16, 64)
 	if err != nil {
-		return nil, err
+		return nil, ErrInvalidLinkName{pbLink.FieldName().Must().String()}
+	}
+	prefix := append(append(make([]int, 0, len(n.prefix)+1), n.prefix...), int(childIndex))
+	nd, err := instrument.Load(n.ctx, lsys, instrument.HAMTShard, pbLink.FieldHash().Link(), dagpb.Type.PBNode)
+	if err != nil {
+		var c cid.Cid
+		if cl, ok := pbLink.FieldHash().Link().(cidlink.Link); ok {
+			c = cl.Cid
+		}
+		return nil, ErrMissingShard{Cid: c, Prefix: prefix, Fanout: int(n.data.FieldFanout().Must().Int()), Err: err}
 	}
 	und, err := AttemptHAMTShardFromNode(n.ctx, nd, n.lsys)
 	if err != nil {
 		return nil, err
 	}
+	und.prefix = prefix
 	n.shardCache[pbLink.FieldHash().Link()] = und
 	return und, nil
 }
@@ -172,14 +223,49 @@ func (n UnixFSHAMTShard) LookupByNode(key ipld.Node) (ipld.Node, error) {
 	return n.LookupByString(ks)
 }
 
+// LookupByIndex returns the link of the entry at idx, in the order entries
+// are iterated. Shards are loaded up to the one holding the entry.
 func (n UnixFSHAMTShard) LookupByIndex(idx int64) (ipld.Node, error) {
-	return n._substrate.LookupByIndex(idx)
+	if idx < 0 || (n.cachedLength != -1 && idx >= n.cachedLength) {
+		return nil, ipld.ErrNotExists{Segment: ipld.PathSegmentOfInt(idx)}
+	}
+	itr := &_UnixFSShardedDir__ListItr{
+		_substrate: n.FieldLinks().Iterator(),
+		maxPadLen:  maxPadLength(n.data),
+		nd:         n,
+	}
+	for !itr.Done() {
+		i, pbLink, err := itr.Next()
+		if err != nil {
+			return nil, err
+		}
+		if pbLink == nil {
+			break
+		}
+		if i == idx {
+			return pbLink.FieldHash(), nil
+		}
+	}
+	return nil, ipld.ErrNotExists{Segment: ipld.PathSegmentOfInt(idx)}
 }
 
+// LookupBySegment looks the segment up as a name. If the shard was created
+// with a context made by utils.WithIndexSegments, a numeric segment that is
+// not a name is looked up as an index (see LookupByIndex); otherwise a missing
+// name is not found rather than resolving to another entry.
 func (n UnixFSHAMTShard) LookupBySegment(seg ipld.PathSegment) (ipld.Node, error) {
-	return n.LookupByString(seg.String())
+	nd, err := n.LookupByString(seg.String())
+	if _, noSuchField := err.(schema.ErrNoSuchField); noSuchField && utils.IndexSegments(n.ctx) {
+		if idx, ierr := seg.Index(); ierr == nil {
+			return n.LookupByIndex(idx)
+		}
+	}
+	return nd, err
 }
 
+// MapIterator iterates over the entries of every shard of the HAMT. If a shard
+// can't be loaded, Next returns an ErrMissingShard for it, and iteration
+// continues with the shard after it.
 func (n UnixFSHAMTShard) MapIterator() ipld.MapIterator {
 	maxPadLen := maxPadLength(n.data)
 	listItr := &_UnixFSShardedDir__ListItr{
@@ -197,6 +283,8 @@ type _UnixFSShardedDir__ListItr struct {
 	nd         UnixFSHAMTShard
 	maxPadLen  int
 	total      int64
+	// taken is the number of links taken from _substrate so far
+	taken int64
 }
 
 func (itr *_UnixFSShardedDir__ListItr) Next() (int64, dagpb.PBLink, error) {
@@ -217,7 +305,11 @@ func (itr *_UnixFSShardedDir__ListItr) next() (dagpb.PBLink, error) {
 		if itr._substrate.Done() {
 			return nil, nil
 		}
+		if err := itr.nd.prefetchChildren(); err != nil {
+			return nil, err
+		}
 		_, next := itr._substrate.Next()
+		itr.taken++
 		isValue, err := isValueLink(next, itr.maxPadLen)
 		if err != nil {
 			return nil, err
@@ -251,6 +343,14 @@ func (itr *_UnixFSShardedDir__ListItr) Done() bool {
 	return itr.childIter == nil && itr._substrate.Done()
 }
 
+// Count returns the number of entries of the HAMT if Length has counted them.
+func (itr *_UnixFSShardedDir__ListItr) Count() (int64, bool) {
+	if itr.nd.cachedLength == -1 {
+		return 0, false
+	}
+	return itr.nd.cachedLength, true
+}
+
 // ListIterator returns an iterator which yields key-value pairs
 // traversing the node.
 // If the node kind is anything other than a list, nil will be returned.
@@ -268,6 +368,9 @@ func (n UnixFSHAMTShard) length() (int64, error) {
 	if n.cachedLength != -1 {
 		return n.cachedLength, nil
 	}
+	if err := n.prefetchChildren(); err != nil {
+		return 0, err
+	}
 	maxPadLen := maxPadLength(n.data)
 	total := int64(0)
 	itr := n.FieldLinks().Iterator()
@@ -297,6 +400,10 @@ func (n UnixFSHAMTShard) length() (int64, error) {
 
 // Length returns the length of a list, or the number of entries in a map,
 // or -1 if the node is not of list nor map kind.
+//
+// Counting the entries loads every shard, but the count is kept on the node so
+// only the first call does this work. If a shard can't be loaded, 0 is
+// returned and nothing is kept.
 func (n UnixFSHAMTShard) Length() int64 {
 	len, err := n.length()
 	if err != nil {
@@ -365,9 +472,22 @@ func (n UnixFSHAMTShard) Iterator() *iter.UnixFSDir__Itr {
 	return iter.NewUnixFSDirIterator(listItr, st.transformNameNode)
 }
 
+// RawIterator returns an iterator over the entries of the whole sharded
+// directory that doesn't allocate nodes for them. Child shards are still
+// loaded as the iterator reaches them.
+func (n UnixFSHAMTShard) RawIterator() *iter.UnixFSDir__RawItr {
+	maxPadLen := maxPadLength(n.data)
+	listItr := &_UnixFSShardedDir__ListItr{
+		_substrate: n.FieldLinks().Iterator(),
+		maxPadLen:  maxPadLen,
+		nd:         n,
+	}
+	return iter.NewUnixFSDirRawIterator(listItr, maxPadLen)
+}
+
 func (n UnixFSHAMTShard) Lookup(key dagpb.String) dagpb.Link {
 	hv := &hashBits{b: hash([]byte(key.String()))}
-	link, err := n.lookup(key.String(), hv)
+	link, err := n.lookup(key.String(), hv, lookupBudget(n.ctx))
 	if err != nil {
 		return nil
 	}
@@ -3,11 +3,13 @@ package hamt_test
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"os"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,8 +17,12 @@ import (
 	mdtest "github.com/ipfs/boxo/ipld/merkledag/test"
 	ft "github.com/ipfs/boxo/ipld/unixfs"
 	legacy "github.com/ipfs/boxo/ipld/unixfs/hamt"
+	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/data/builder"
 	"github.com/ipfs/go-unixfsnode/hamt"
+	"github.com/ipfs/go-unixfsnode/iter"
 	"github.com/ipld/go-car/v2/storage"
 	dagpb "github.com/ipld/go-codec-dagpb"
 	"github.com/ipld/go-ipld-prime"
@@ -170,6 +176,193 @@ func TestIterator(t *testing.T) {
 	require.NoError(t, assertLinksEqual(linksA, linksB))
 }
 
+func TestRawIterator(t *testing.T) {
+	ds, lsys := mockDag()
+	_, s, err := makeDir(ds, 300)
+	require.NoError(t, err)
+	ctx := context.Background()
+	legacyNode, err := s.Node()
+	require.NoError(t, err)
+	nd, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: legacyNode.Cid()}, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	hamtShard, err := hamt.AttemptHAMTShardFromNode(ctx, nd, lsys)
+	require.NoError(t, err)
+
+	var linksA, linksB []*format.Link
+	itr := hamtShard.Iterator()
+	for !itr.Done() {
+		name, link := itr.Next()
+		linksA = append(linksA, &format.Link{Name: name.String(), Cid: link.Link().(cidlink.Link).Cid})
+	}
+	rawItr := hamtShard.RawIterator()
+	for !rawItr.Done() {
+		name, link, err := rawItr.Next()
+		require.NoError(t, err)
+		linksB = append(linksB, &format.Link{Name: name, Cid: link.FieldHash().Link().(cidlink.Link).Cid})
+	}
+	require.Len(t, linksB, 300)
+	require.NoError(t, assertLinksEqual(linksA, linksB))
+	_, _, err = rawItr.Next()
+	require.ErrorAs(t, err, &ipld.ErrIteratorOverread{})
+
+	// both load the same child shards, but the raw iterator doesn't allocate
+	// for each entry on top of that
+	allocs := func(iterate func()) float64 {
+		return testing.AllocsPerRun(10, iterate)
+	}
+	nodeAllocs := allocs(func() {
+		itr := hamtShard.Iterator()
+		for !itr.Done() {
+			itr.Next()
+		}
+	})
+	rawAllocs := allocs(func() {
+		itr := hamtShard.RawIterator()
+		for !itr.Done() {
+			itr.Next()
+		}
+	})
+	require.Less(t, rawAllocs, nodeAllocs-300)
+}
+
+func TestLengthMemoized(t *testing.T) {
+	ds, lsys := mockDag()
+	_, s, err := makeDir(ds, 1000)
+	require.NoError(t, err)
+	ctx := context.Background()
+	legacyNode, err := s.Node()
+	require.NoError(t, err)
+	nd, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: legacyNode.Cid()}, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	hamtShard, err := hamt.AttemptHAMTShardFromNode(ctx, nd, lsys)
+	require.NoError(t, err)
+
+	var loads int
+	opener := lsys.StorageReadOpener
+	lsys.StorageReadOpener = func(lnkCtx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		loads++
+		return opener(lnkCtx, lnk)
+	}
+	require.Equal(t, int64(1000), hamtShard.Length())
+	require.Greater(t, loads, 0)
+
+	loads = 0
+	for i := 0; i < 10; i++ {
+		require.Equal(t, int64(1000), hamtShard.Length())
+	}
+	require.Zero(t, loads)
+}
+
+type countingBatchLoader struct {
+	ds      format.DAGService
+	batches int
+	blocks  int
+}
+
+func (bl *countingBatchLoader) LoadBlocks(ctx context.Context, cids []cid.Cid) (map[cid.Cid][]byte, error) {
+	bl.batches++
+	blocks := make(map[cid.Cid][]byte, len(cids))
+	for _, c := range cids {
+		nd, err := bl.ds.Get(ctx, c)
+		if err != nil {
+			continue
+		}
+		blocks[c] = nd.RawData()
+		bl.blocks++
+	}
+	return blocks, nil
+}
+
+func TestBatchLoader(t *testing.T) {
+	ds, lsys := mockDag()
+	names, s, err := makeDir(ds, 1000)
+	require.NoError(t, err)
+	legacyNode, err := s.Node()
+	require.NoError(t, err)
+	nd, err := lsys.Load(ipld.LinkContext{Ctx: context.Background()}, cidlink.Link{Cid: legacyNode.Cid()}, dagpb.Type.PBNode)
+	require.NoError(t, err)
+
+	var loads int
+	opener := lsys.StorageReadOpener
+	lsys.StorageReadOpener = func(lnkCtx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		loads++
+		return opener(lnkCtx, lnk)
+	}
+
+	t.Run("preload", func(t *testing.T) {
+		loads = 0
+		bl := &countingBatchLoader{ds: ds}
+		ctx := hamt.WithBatchLoader(context.Background(), bl)
+		ufsData, err := data.DecodeUnixFSData(nd.(dagpb.PBNode).FieldData().Must().Bytes())
+		require.NoError(t, err)
+		n, err := hamt.NewUnixFSHAMTShardWithPreload(ctx, nd.(dagpb.PBNode), ufsData, lsys)
+		require.NoError(t, err)
+		require.Equal(t, int64(1000), n.Length())
+		require.Zero(t, loads)
+		require.Greater(t, bl.blocks, bl.batches)
+	})
+
+	t.Run("iterate", func(t *testing.T) {
+		loads = 0
+		bl := &countingBatchLoader{ds: ds}
+		ctx := hamt.WithBatchLoader(context.Background(), bl)
+		hamtShard, err := hamt.AttemptHAMTShardFromNode(ctx, nd, lsys)
+		require.NoError(t, err)
+		var listed []string
+		itr := hamtShard.Iterator()
+		for !itr.Done() {
+			k, _ := itr.Next()
+			listed = append(listed, k.String())
+		}
+		sort.Strings(listed)
+		expected := append([]string(nil), names...)
+		sort.Strings(expected)
+		require.Equal(t, expected, listed)
+		require.Zero(t, loads)
+		require.NotZero(t, bl.batches)
+	})
+
+	t.Run("missing blocks", func(t *testing.T) {
+		loads = 0
+		bl := &countingBatchLoader{ds: dag.NewDAGService(mdtest.Bserv())}
+		ctx := hamt.WithBatchLoader(context.Background(), bl)
+		hamtShard, err := hamt.AttemptHAMTShardFromNode(ctx, nd, lsys)
+		require.NoError(t, err)
+		require.Equal(t, int64(1000), hamtShard.Length())
+		require.Greater(t, loads, 0)
+		require.Zero(t, bl.blocks)
+	})
+}
+
+func TestStats(t *testing.T) {
+	ds, lsys := mockDag()
+	_, s, err := makeDir(ds, 1000)
+	require.NoError(t, err)
+	legacyNode, err := s.Node()
+	require.NoError(t, err)
+
+	st, err := hamt.Stats(context.Background(), cidlink.Link{Cid: legacyNode.Cid()}, lsys)
+	require.NoError(t, err)
+	require.Equal(t, 256, st.Fanout)
+	require.Equal(t, 1000, st.Entries)
+	require.Greater(t, st.Shards, 1)
+	require.Equal(t, len(st.ShardsByDepth)-1, st.MaxDepth)
+	var shards, entries int
+	for d := range st.ShardsByDepth {
+		shards += st.ShardsByDepth[d]
+		entries += st.EntriesByDepth[d]
+	}
+	require.Equal(t, st.Shards, shards)
+	require.Equal(t, st.Entries, entries)
+	require.Equal(t, 1, st.ShardsByDepth[0])
+	require.Greater(t, st.AvgDepth, 0.0)
+	require.LessOrEqual(t, st.AvgDepth, float64(st.MaxDepth))
+	require.Greater(t, st.MinFill, 0.0)
+	require.LessOrEqual(t, st.MinFill, st.AvgFill)
+	require.LessOrEqual(t, st.AvgFill, st.MaxFill)
+	require.LessOrEqual(t, st.MaxFill, 1.0)
+}
+
 func TestLoadFailsFromNonShard(t *testing.T) {
 	ds, lsys := mockDag()
 	ctx := context.Background()
@@ -289,3 +482,356 @@ func TestIncompleteShardedIteration(t *testing.T) {
 	req.Contains(blockNotFound, "/wiki/ICloud_Drive")
 	req.Contains(blockNotFound, "/wiki/Édouard_Bamberger")
 }
+
+func TestMissingShard(t *testing.T) {
+	ds, lsys := mockDag()
+	names, s, err := makeDir(ds, 1000)
+	require.NoError(t, err)
+	ctx := context.Background()
+	legacyNode, err := s.Node()
+	require.NoError(t, err)
+	nd, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: legacyNode.Cid()}, dagpb.Type.PBNode)
+	require.NoError(t, err)
+
+	// remove the first child shard of the root
+	var missing dagpb.PBLink
+	itr := nd.(dagpb.PBNode).FieldLinks().Iterator()
+	for !itr.Done() {
+		_, lnk := itr.Next()
+		if len(lnk.FieldName().Must().String()) == 2 {
+			missing = lnk
+			break
+		}
+	}
+	require.NotNil(t, missing)
+	missingCid := missing.FieldHash().Link().(cidlink.Link).Cid
+	require.NoError(t, ds.Remove(ctx, missingCid))
+	var prefix int
+	_, err = fmt.Sscanf(missing.FieldName().Must().String(), "%X", &prefix)
+	require.NoError(t, err)
+
+	hamtShard, err := hamt.AttemptHAMTShardFromNode(ctx, nd, lsys)
+	require.NoError(t, err)
+	var missed int
+	for _, d := range names {
+		_, err := hamtShard.LookupByString(d)
+		var ms hamt.ErrMissingShard
+		if !errors.As(err, &ms) {
+			require.NoError(t, err)
+			continue
+		}
+		missed++
+		require.Equal(t, missingCid, ms.Cid)
+		require.Equal(t, []int{prefix}, ms.Prefix)
+		require.Equal(t, 256, ms.Fanout)
+		require.Equal(t, missing.FieldName().Must().String(), ms.PrefixString())
+		require.True(t, ms.NotFound())
+		require.True(t, ms.Covers(d))
+	}
+	require.NotZero(t, missed)
+
+	var iterMissed int
+	mi := hamtShard.MapIterator()
+	for !mi.Done() {
+		_, _, err := mi.Next()
+		var ms hamt.ErrMissingShard
+		if errors.As(err, &ms) {
+			require.Equal(t, missingCid, ms.Cid)
+			iterMissed++
+			continue
+		}
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, iterMissed)
+}
+
+func TestMalformedShardHeaders(t *testing.T) {
+	_, lsys := mockDag()
+	ctx := context.Background()
+
+	shardNode := func(t *testing.T, hashType, fanout uint64, bitfield []byte, links int) (dagpb.PBNode, data.UnixFSData) {
+		ufd, err := builder.BuildUnixFS(func(b *builder.Builder) {
+			builder.DataType(b, data.Data_HAMTShard)
+			builder.HashType(b, hashType)
+			builder.Fanout(b, fanout)
+			builder.Data(b, bitfield)
+		})
+		require.NoError(t, err)
+		target := cidlink.Link{Cid: ft.EmptyDirNode().Cid()}
+		nd, err := qp.BuildMap(dagpb.Type.PBNode, -1, func(ma ipld.MapAssembler) {
+			qp.MapEntry(ma, "Data", qp.Bytes(data.EncodeUnixFSData(ufd)))
+			qp.MapEntry(ma, "Links", qp.List(-1, func(la ipld.ListAssembler) {
+				for i := 0; i < links; i++ {
+					qp.ListEntry(la, qp.Map(-1, func(ma ipld.MapAssembler) {
+						qp.MapEntry(ma, "Hash", qp.Link(target))
+						qp.MapEntry(ma, "Name", qp.String(fmt.Sprintf("%02Xname%d", i, i)))
+					}))
+				}
+			}))
+		})
+		require.NoError(t, err)
+		return nd.(dagpb.PBNode), ufd
+	}
+
+	testCases := []struct {
+		name     string
+		hashType uint64
+		fanout   uint64
+		bitfield []byte
+		links    int
+		err      error
+	}{
+		{"sha2-256", 0x12, 256, []byte{1}, 1, hamt.ErrUnsupportedHashType{HashType: 0x12}},
+		{"zero fanout", hamt.HashMurmur3, 0, []byte{1}, 1, hamt.ErrInvalidFanout{Fanout: 0}},
+		{"fanout not a power of two", hamt.HashMurmur3, 100, []byte{1}, 1, hamt.ErrInvalidFanout{Fanout: 100}},
+		{"fanout too small", hamt.HashMurmur3, 4, []byte{1}, 1, hamt.ErrInvalidFanout{Fanout: 4}},
+		{"fanout too large", hamt.HashMurmur3, 1 << 11, []byte{1}, 1, hamt.ErrInvalidFanout{Fanout: 1 << 11}},
+		{"bitfield too long", hamt.HashMurmur3, 16, []byte{1, 0, 0}, 1, hamt.ErrInvalidBitfield{Length: 3, Fanout: 16}},
+		{"more bits than links", hamt.HashMurmur3, 256, []byte{3}, 1, hamt.ErrBitfieldLinkCount{Set: 2, Links: 1}},
+		{"links without bits", hamt.HashMurmur3, 256, []byte{}, 2, hamt.ErrBitfieldLinkCount{Set: 0, Links: 2}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pbn, ufd := shardNode(t, tc.hashType, tc.fanout, tc.bitfield, tc.links)
+			_, err := hamt.NewUnixFSHAMTShard(ctx, pbn, ufd, lsys)
+			require.Equal(t, tc.err, err)
+			_, err = hamt.AttemptHAMTShardFromNode(ctx, pbn, lsys)
+			require.Equal(t, tc.err, err)
+		})
+	}
+
+	// the typed errors still match the errors previously returned
+	require.ErrorIs(t, hamt.ErrUnsupportedHashType{HashType: 0x12}, hamt.ErrInvalidHashType)
+	require.ErrorIs(t, hamt.ErrInvalidFanout{Fanout: 100}, hamt.ErrHAMTSizeInvalid)
+
+	pbn, ufd := shardNode(t, hamt.HashMurmur3, 256, []byte{1}, 1)
+	_, err := hamt.NewUnixFSHAMTShard(ctx, pbn, ufd, lsys)
+	require.NoError(t, err)
+}
+
+func TestLookupBudget(t *testing.T) {
+	ds, lsys := mockDag()
+	names, s, err := makeDirWidth(ds, 1000, 8)
+	require.NoError(t, err)
+	legacyNode, err := s.Node()
+	require.NoError(t, err)
+	nd, err := lsys.Load(ipld.LinkContext{Ctx: context.Background()}, cidlink.Link{Cid: legacyNode.Cid()}, dagpb.Type.PBNode)
+	require.NoError(t, err)
+
+	var loads int
+	opener := lsys.StorageReadOpener
+	lsys.StorageReadOpener = func(lnkCtx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		loads++
+		return opener(lnkCtx, lnk)
+	}
+
+	for _, budget := range []int{2, 3} {
+		ctx := hamt.WithLookupBudget(context.Background(), budget)
+		var found, exceeded int
+		for _, name := range names {
+			hamtShard, err := hamt.AttemptHAMTShardFromNode(ctx, nd, lsys)
+			require.NoError(t, err)
+			loads = 0
+			_, err = hamtShard.LookupByString(name)
+			require.LessOrEqual(t, loads, budget)
+			if err == nil {
+				found++
+				continue
+			}
+			require.ErrorIs(t, err, hamt.ErrLookupBudgetExceeded{Key: name, Budget: budget})
+			exceeded++
+		}
+		require.NotZero(t, found)
+		require.NotZero(t, exceeded)
+	}
+
+	// shards already loaded don't count against the budget
+	hamtShard, err := hamt.AttemptHAMTShardFromNode(hamt.WithLookupBudget(context.Background(), 0), nd, lsys)
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), hamtShard.Length())
+	loads = 0
+	for _, name := range names {
+		_, err := hamtShard.LookupByString(name)
+		require.NoError(t, err)
+	}
+	require.Zero(t, loads)
+}
+
+func TestCheckpointIterator(t *testing.T) {
+	ds, lsys := mockDag()
+	// a narrow fanout makes shards several levels deep
+	_, s, err := makeDirWidth(ds, 1000, 16)
+	require.NoError(t, err)
+	ctx := context.Background()
+	legacyNode, err := s.Node()
+	require.NoError(t, err)
+	loadShard := func() hamt.UnixFSHAMTShard {
+		nd, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: legacyNode.Cid()}, dagpb.Type.PBNode)
+		require.NoError(t, err)
+		hamtShard, err := hamt.AttemptHAMTShardFromNode(ctx, nd, lsys)
+		require.NoError(t, err)
+		return hamtShard
+	}
+	collect := func(itr ipld.MapIterator, max int) []string {
+		var names []string
+		for !itr.Done() && len(names) < max {
+			k, _, err := itr.Next()
+			require.NoError(t, err)
+			name, err := k.AsString()
+			require.NoError(t, err)
+			names = append(names, name)
+		}
+		return names
+	}
+	all := collect(loadShard().MapIterator(), 1000)
+	require.Len(t, all, 1000)
+
+	for _, stop := range []int{0, 1, 17, 500, 999, 1000} {
+		itr := loadShard().CheckpointIterator()
+		names := collect(itr, stop)
+		token, err := itr.Checkpoint()
+		require.NoError(t, err)
+
+		resumed, err := loadShard().ResumeIterator(token)
+		require.NoError(t, err)
+		names = append(names, collect(resumed, 1000)...)
+		require.Equal(t, all, names, "resuming after %d entries", stop)
+	}
+
+	_, err = loadShard().ResumeIterator([]byte(`{"path":[100000],"index":0}`))
+	require.Error(t, err)
+	_, err = loadShard().ResumeIterator([]byte("not a checkpoint"))
+	require.Error(t, err)
+}
+
+func TestPrefixIterator(t *testing.T) {
+	ds, lsys := mockDag()
+	_, s, err := makeDirWidth(ds, 1000, 16)
+	require.NoError(t, err)
+	ctx := context.Background()
+	legacyNode, err := s.Node()
+	require.NoError(t, err)
+	nd, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: legacyNode.Cid()}, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	hamtShard, err := hamt.AttemptHAMTShardFromNode(ctx, nd, lsys)
+	require.NoError(t, err)
+
+	collect := func(itr ipld.MapIterator) map[string]ipld.Link {
+		entries := make(map[string]ipld.Link)
+		for !itr.Done() {
+			k, v, err := itr.Next()
+			require.NoError(t, err)
+			name, err := k.AsString()
+			require.NoError(t, err)
+			lnk, err := v.AsLink()
+			require.NoError(t, err)
+			entries[name] = lnk
+		}
+		return entries
+	}
+	all := collect(hamtShard.MapIterator())
+	for _, prefix := range []string{"", "DIRNAME12", "DIRNAME999", "DIRNAME9999", "other"} {
+		expected := make(map[string]ipld.Link)
+		for name, lnk := range all {
+			if strings.HasPrefix(name, prefix) {
+				expected[name] = lnk
+			}
+		}
+		require.Equal(t, expected, collect(hamtShard.PrefixIterator(prefix)), "prefix %q", prefix)
+	}
+	require.Len(t, collect(hamtShard.PrefixIterator("DIRNAME12")), 11)
+
+	itr := hamtShard.PrefixIterator("other")
+	require.True(t, itr.Done())
+	_, _, err = itr.Next()
+	require.ErrorIs(t, err, ipld.ErrIteratorOverread{})
+}
+
+func TestReverseSortedIterator(t *testing.T) {
+	ds, lsys := mockDag()
+	_, s, err := makeDirWidth(ds, 1000, 16)
+	require.NoError(t, err)
+	ctx := context.Background()
+	legacyNode, err := s.Node()
+	require.NoError(t, err)
+	nd, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: legacyNode.Cid()}, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	hamtShard, err := hamt.AttemptHAMTShardFromNode(ctx, nd, lsys)
+	require.NoError(t, err)
+
+	collect := func(itr ipld.MapIterator) ([]string, map[string]ipld.Link) {
+		var names []string
+		links := make(map[string]ipld.Link)
+		for !itr.Done() {
+			k, v, err := itr.Next()
+			require.NoError(t, err)
+			name, err := k.AsString()
+			require.NoError(t, err)
+			lnk, err := v.AsLink()
+			require.NoError(t, err)
+			names = append(names, name)
+			links[name] = lnk
+		}
+		return names, links
+	}
+	names, all := collect(hamtShard.MapIterator())
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	reversed, links := collect(hamtShard.ReverseSortedIterator(0))
+	require.Equal(t, names, reversed)
+	require.Equal(t, all, links)
+	last, _ := collect(hamtShard.ReverseSortedIterator(10))
+	require.Equal(t, names[:10], last)
+	last, _ = collect(hamtShard.ReverseSortedIterator(5000))
+	require.Equal(t, names, last)
+
+	itr := hamtShard.ReverseSortedIterator(1)
+	require.False(t, itr.Done())
+	_, _, err = itr.Next()
+	require.NoError(t, err)
+	require.True(t, itr.Done())
+	_, _, err = itr.Next()
+	require.ErrorIs(t, err, ipld.ErrIteratorOverread{})
+}
+
+func TestIteratorIndexAndTotal(t *testing.T) {
+	ds, lsys := mockDag()
+	_, s, err := makeDirWidth(ds, 500, 16)
+	require.NoError(t, err)
+	ctx := context.Background()
+	legacyNode, err := s.Node()
+	require.NoError(t, err)
+	nd, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: legacyNode.Cid()}, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	hamtShard, err := hamt.AttemptHAMTShardFromNode(ctx, nd, lsys)
+	require.NoError(t, err)
+
+	// the total isn't known until the entries have been counted
+	itr := hamtShard.RawIterator()
+	_, ok := itr.Total()
+	require.False(t, ok)
+	require.Equal(t, int64(-1), itr.Index())
+	for i := int64(0); !itr.Done(); i++ {
+		_, _, err := itr.Next()
+		require.NoError(t, err)
+		require.Equal(t, i, itr.Index())
+	}
+	require.Equal(t, int64(499), itr.Index())
+
+	require.Equal(t, int64(500), hamtShard.Length())
+	mapItr := hamtShard.MapIterator().(*iter.UnixFSDir__MapItr)
+	total, ok := mapItr.Total()
+	require.True(t, ok)
+	require.Equal(t, int64(500), total)
+	_, _, err = mapItr.Next()
+	require.NoError(t, err)
+	require.Equal(t, int64(0), mapItr.Index())
+
+	reversed := hamtShard.ReverseSortedIterator(10).(*iter.UnixFSDir__MapItr)
+	_, ok = reversed.Total()
+	require.False(t, ok)
+	require.False(t, reversed.Done())
+	total, ok = reversed.Total()
+	require.True(t, ok)
+	require.Equal(t, int64(10), total)
+}
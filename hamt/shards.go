@@ -0,0 +1,42 @@
+package hamt
+
+import (
+	"github.com/ipld/go-ipld-prime"
+)
+
+// WalkShards calls fn for each child shard below n, depth first in link
+// order, with the link to the shard, the shard itself, and its depth below n,
+// starting at 1 for the direct children of n. n itself is not passed to fn.
+// Returning an error from fn stops the walk, and WalkShards returns the error.
+func (n UnixFSHAMTShard) WalkShards(fn func(lnk ipld.Link, shard UnixFSHAMTShard, depth int) error) error {
+	return n.walkShards(fn, 1)
+}
+
+func (n UnixFSHAMTShard) walkShards(fn func(ipld.Link, UnixFSHAMTShard, int) error, depth int) error {
+	if err := n.prefetchChildren(); err != nil {
+		return err
+	}
+	maxPadLen := maxPadLength(n.data)
+	itr := n.FieldLinks().Iterator()
+	for !itr.Done() {
+		_, pbLink := itr.Next()
+		isValue, err := isValueLink(pbLink, maxPadLen)
+		if err != nil {
+			return err
+		}
+		if isValue {
+			continue
+		}
+		child, err := n.loadChild(pbLink)
+		if err != nil {
+			return err
+		}
+		if err := fn(pbLink.FieldHash().Link(), child, depth); err != nil {
+			return err
+		}
+		if err := child.walkShards(fn, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
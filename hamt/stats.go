@@ -0,0 +1,107 @@
+package hamt
+
+import (
+	"context"
+
+	"github.com/ipfs/go-unixfsnode/instrument"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+)
+
+// ShardStats describes how the entries of a sharded directory are spread over
+// its shards. Depths count from the root shard at 0.
+type ShardStats struct {
+	// Fanout is the fanout of the root shard
+	Fanout int `json:"fanout"`
+	// Shards is the number of shards, including the root
+	Shards int `json:"shards"`
+	// Entries is the number of directory entries
+	Entries int `json:"entries"`
+	// MaxDepth is the depth of the deepest shard
+	MaxDepth int `json:"maxDepth"`
+	// AvgDepth is the mean depth of the shards holding the entries, i.e. the
+	// number of child shards loaded by a typical lookup
+	AvgDepth float64 `json:"avgDepth"`
+	// MinFill, AvgFill and MaxFill describe the share of buckets in use in a
+	// shard, from 0 to 1, over all of the shards
+	MinFill float64 `json:"minFill"`
+	AvgFill float64 `json:"avgFill"`
+	MaxFill float64 `json:"maxFill"`
+	// ShardsByDepth is the number of shards at each depth
+	ShardsByDepth []int `json:"shardsByDepth"`
+	// EntriesByDepth is the number of entries held by shards at each depth
+	EntriesByDepth []int `json:"entriesByDepth"`
+}
+
+// Stats loads every shard of the sharded directory at root and returns
+// ShardStats for it. Shards are loaded as for iteration, so a BatchLoader
+// carried by ctx is used. An ErrMissingShard is returned if a shard can't be
+// loaded.
+func Stats(ctx context.Context, root ipld.Link, lsys *ipld.LinkSystem) (*ShardStats, error) {
+	nd, err := instrument.Load(ctx, lsys, instrument.HAMTShard, root, dagpb.Type.PBNode)
+	if err != nil {
+		return nil, err
+	}
+	shard, err := AttemptHAMTShardFromNode(ctx, nd, lsys)
+	if err != nil {
+		return nil, err
+	}
+	st := &ShardStats{
+		Fanout:  int(shard.data.FieldFanout().Must().Int()),
+		MinFill: 1,
+	}
+	var fillSum float64
+	var depthSum int
+	if err := shardStats(shard, 0, st, &fillSum, &depthSum); err != nil {
+		return nil, err
+	}
+	st.AvgFill = fillSum / float64(st.Shards)
+	if st.Entries > 0 {
+		st.AvgDepth = float64(depthSum) / float64(st.Entries)
+	}
+	return st, nil
+}
+
+func shardStats(n UnixFSHAMTShard, depth int, st *ShardStats, fillSum *float64, depthSum *int) error {
+	st.Shards++
+	if depth > st.MaxDepth {
+		st.MaxDepth = depth
+	}
+	if len(st.ShardsByDepth) <= depth {
+		st.ShardsByDepth = append(st.ShardsByDepth, 0)
+		st.EntriesByDepth = append(st.EntriesByDepth, 0)
+	}
+	st.ShardsByDepth[depth]++
+
+	fill := float64(n.bitfield.Ones()) / float64(n.data.FieldFanout().Must().Int())
+	*fillSum += fill
+	st.MinFill = min(st.MinFill, fill)
+	st.MaxFill = max(st.MaxFill, fill)
+
+	if err := n.prefetchChildren(); err != nil {
+		return err
+	}
+	maxPadLen := maxPadLength(n.data)
+	itr := n.FieldLinks().Iterator()
+	for !itr.Done() {
+		_, pbLink := itr.Next()
+		isValue, err := isValueLink(pbLink, maxPadLen)
+		if err != nil {
+			return err
+		}
+		if isValue {
+			st.Entries++
+			st.EntriesByDepth[depth]++
+			*depthSum += depth
+			continue
+		}
+		child, err := n.loadChild(pbLink)
+		if err != nil {
+			return err
+		}
+		if err := shardStats(child, depth+1, st, fillSum, depthSum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
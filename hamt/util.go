@@ -57,14 +57,21 @@ func (hb *hashBits) next(i int) int {
 
 }
 
-func validateHAMTData(nd data.UnixFSData) error {
+// ValidateHAMTData checks the header of a HAMT shard: the data type, the hash
+// type, which must be murmur3, the fanout, which must be a power of two from 8
+// to MaxWidth, and the length of the bitfield. It does not check the bitfield
+// against the links of the shard.
+func ValidateHAMTData(nd data.UnixFSData) error {
 	if nd.FieldDataType().Int() != data.Data_HAMTShard {
 		return data.ErrWrongNodeType{Expected: data.Data_HAMTShard, Actual: nd.FieldDataType().Int()}
 	}
 
-	if !nd.FieldHashType().Exists() || uint64(nd.FieldHashType().Must().Int()) != HashMurmur3 {
+	if !nd.FieldHashType().Exists() {
 		return ErrInvalidHashType
 	}
+	if hashType := uint64(nd.FieldHashType().Must().Int()); hashType != HashMurmur3 {
+		return ErrUnsupportedHashType{HashType: hashType}
+	}
 
 	if !nd.FieldData().Exists() {
 		return ErrNoDataField
@@ -73,8 +80,15 @@ func validateHAMTData(nd data.UnixFSData) error {
 	if !nd.FieldFanout().Exists() {
 		return ErrNoFanoutField
 	}
-	if err := checkLogTwo(int(nd.FieldFanout().Must().Int())); err != nil {
-		return err
+	fanout := nd.FieldFanout().Must().Int()
+	if fanout < minimumHamtWidth || fanout > MaxWidth || checkLogTwo(int(fanout)) != nil {
+		return ErrInvalidFanout{Fanout: fanout}
+	}
+
+	// the bitfield is stored without leading zero bytes, so it may be shorter
+	// than the fanout, but never longer
+	if length := len(nd.FieldData().Must().Bytes()); length > int(fanout/8) {
+		return ErrInvalidBitfield{Length: length, Fanout: fanout}
 	}
 
 	return nil
@@ -88,13 +102,15 @@ func maxPadLength(nd data.UnixFSData) int {
 	return len(fmt.Sprintf("%X", nd.FieldFanout().Must().Int()-1))
 }
 
-const maximumHamtWidth = 1 << 10
+// MaxWidth is the widest fanout of a HAMT shard that will be read.
+const MaxWidth = 1 << 10
+
+// a bitfield of fewer than 8 bits can't be stored
+const minimumHamtWidth = 1 << 3
 
+// bitField reads the bitfield of a shard whose data has been validated.
 func bitField(nd data.UnixFSData) (bitfield.Bitfield, error) {
 	fanout := int(nd.FieldFanout().Must().Int())
-	if fanout > maximumHamtWidth {
-		return nil, fmt.Errorf("hamt witdh (%d) exceed maximum allowed (%d)", fanout, maximumHamtWidth)
-	}
 	bf, err := bitfield.NewBitfield(fanout)
 	if err != nil {
 		return nil, err
@@ -120,6 +136,16 @@ func hash(val []byte) []byte {
 	return h.Sum(nil)
 }
 
+// Bucket returns the index of the bucket that name is placed in at the given
+// depth of a HAMT, the root being at depth 0, whose shards have a fanout of
+// 1<<bitWidth. It returns false if the hash of name is used up before that
+// depth, which no valid HAMT reaches.
+func Bucket(name string, depth int, bitWidth int) (int, bool) {
+	hv := &hashBits{b: hash([]byte(name)), consumed: depth * bitWidth}
+	idx, err := hv.Next(bitWidth)
+	return idx, err == nil
+}
+
 func isValueLink(pbLink dagpb.PBLink, maxPadLen int) (bool, error) {
 	if !pbLink.FieldName().Exists() {
 		return false, ErrMissingLinkName
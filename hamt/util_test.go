@@ -4,6 +4,8 @@ package hamt
 
 import (
 	"testing"
+
+	"github.com/spaolacci/murmur3"
 )
 
 func TestHashBitsEvenSizes(t *testing.T) {
@@ -64,3 +66,35 @@ func TestHashBitsUneven(t *testing.T) {
 		t.Fatalf("expected 20269, but got %b (%d)", v, v)
 	}
 }
+
+func TestBucket(t *testing.T) {
+	name := "bucket"
+	h := murmur3.New64()
+	h.Write([]byte(name))
+	sum := h.Sum(nil)
+
+	for _, tc := range []struct {
+		depth, bitWidth int
+		expected        int
+	}{
+		{0, 8, int(sum[0])},
+		{1, 8, int(sum[1])},
+		{7, 8, int(sum[7])},
+		{1, 4, int(sum[0] & 0xf)},
+		{0, 10, int(sum[0])<<2 | int(sum[1]>>6)},
+		{1, 10, int(sum[1]&0x3f)<<4 | int(sum[2]>>4)},
+	} {
+		bucket, ok := Bucket(name, tc.depth, tc.bitWidth)
+		if !ok || bucket != tc.expected {
+			t.Fatalf("expected bucket %d at depth %d of width %d, got %d (%v)", tc.expected, tc.depth, tc.bitWidth, bucket, ok)
+		}
+	}
+
+	// the 64 bit hash is used up
+	if _, ok := Bucket(name, 8, 8); ok {
+		t.Fatal("expected depth 8 of width 8 to be too deep")
+	}
+	if _, ok := Bucket(name, 6, 10); ok {
+		t.Fatal("expected depth 6 of width 10 to be too deep")
+	}
+}
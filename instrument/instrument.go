@@ -0,0 +1,143 @@
+// Package instrument hooks into the blocks loaded by the file and HAMT
+// directory ADLs of this module. Loads can be reported, with their size, the
+// time taken and why they were made, so that operators can observe read
+// amplification and slow storage in production, and failed loads can be
+// retried. Plain directories load no blocks of their own.
+package instrument
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// Purpose is why an ADL loaded a block. It is meant for use as a metric label.
+type Purpose string
+
+const (
+	// FileBlock is a node or leaf of a multi-block file, loaded to read it
+	FileBlock Purpose = "file"
+	// FilePreload is a block of a file loaded ahead of reading, see
+	// file.NewUnixFSFileWithPreloadAll
+	FilePreload Purpose = "file-preload"
+	// HAMTShard is a shard of a sharded directory, loaded for a lookup, for
+	// iteration or for stats
+	HAMTShard Purpose = "hamt-shard"
+)
+
+// BlockLoad describes a block loaded by an ADL.
+type BlockLoad struct {
+	Cid cid.Cid
+	// Size is the number of bytes read from storage for the block
+	Size int
+	// Duration is the time taken to read, verify and decode the block
+	Duration time.Duration
+	Purpose  Purpose
+	// Err is the error the load failed with, if it did
+	Err error
+}
+
+// Observer is told of each block loaded by the ADLs of this module. It is
+// called from the goroutine that made the load, so it must be safe for
+// concurrent use and should return quickly.
+type Observer interface {
+	BlockLoaded(ctx context.Context, load BlockLoad)
+}
+
+// ObserverFunc is an Observer that calls itself.
+type ObserverFunc func(ctx context.Context, load BlockLoad)
+
+// BlockLoaded calls f.
+func (f ObserverFunc) BlockLoaded(ctx context.Context, load BlockLoad) {
+	f(ctx, load)
+}
+
+type observerKey struct{}
+
+// WithObserver returns a context carrying o. Files and HAMT shards created with
+// this context, either directly or through reification on a LinkSystem,
+// report every block they load to o.
+func WithObserver(ctx context.Context, o Observer) context.Context {
+	return context.WithValue(ctx, observerKey{}, o)
+}
+
+func observerFrom(ctx context.Context) Observer {
+	if ctx == nil {
+		return nil
+	}
+	o, _ := ctx.Value(observerKey{}).(Observer)
+	return o
+}
+
+// Load loads lnk with lsys, as lsys.Load does, reporting the load to the
+// context's Observer, if there is one, as being for purpose, and retrying it
+// as the context's RetryPolicy decides.
+func Load(ctx context.Context, lsys *ipld.LinkSystem, purpose Purpose, lnk ipld.Link, np datamodel.NodePrototype) (ipld.Node, error) {
+	return retry(ctx, linkCid(lnk), func() (ipld.Node, error) {
+		o := observerFrom(ctx)
+		if o == nil {
+			return lsys.Load(ipld.LinkContext{Ctx: ctx}, lnk, np)
+		}
+		counted, size := counting(lsys)
+		start := time.Now()
+		nd, err := counted.Load(ipld.LinkContext{Ctx: ctx}, lnk, np)
+		o.BlockLoaded(ctx, BlockLoad{Cid: linkCid(lnk), Size: *size, Duration: time.Since(start), Purpose: purpose, Err: err})
+		return nd, err
+	})
+}
+
+// LoadRaw loads the bytes of lnk with lsys, as lsys.LoadRaw does, reporting
+// and retrying the load as Load does.
+func LoadRaw(ctx context.Context, lsys *ipld.LinkSystem, purpose Purpose, lnk ipld.Link) ([]byte, error) {
+	return retry(ctx, linkCid(lnk), func() ([]byte, error) {
+		o := observerFrom(ctx)
+		if o == nil {
+			return lsys.LoadRaw(ipld.LinkContext{Ctx: ctx}, lnk)
+		}
+		start := time.Now()
+		block, err := lsys.LoadRaw(ipld.LinkContext{Ctx: ctx}, lnk)
+		o.BlockLoaded(ctx, BlockLoad{Cid: linkCid(lnk), Size: len(block), Duration: time.Since(start), Purpose: purpose, Err: err})
+		return block, err
+	})
+}
+
+func linkCid(lnk ipld.Link) cid.Cid {
+	if cl, ok := lnk.(cidlink.Link); ok {
+		return cl.Cid
+	}
+	return cid.Undef
+}
+
+// counting returns a copy of lsys that counts the bytes read from its
+// storage.
+func counting(lsys *ipld.LinkSystem) (*ipld.LinkSystem, *int) {
+	size := new(int)
+	if lsys.StorageReadOpener == nil {
+		return lsys, size
+	}
+	counted := *lsys
+	counted.StorageReadOpener = func(lnkCtx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		r, err := lsys.StorageReadOpener(lnkCtx, lnk)
+		if err != nil {
+			return nil, err
+		}
+		return &countingReader{r: r, n: size}, nil
+	}
+	return &counted, size
+}
+
+type countingReader struct {
+	r io.Reader
+	n *int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	*cr.n += n
+	return n, err
+}
@@ -0,0 +1,106 @@
+package instrument_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/file"
+	"github.com/ipfs/go-unixfsnode/instrument"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+type recorder struct {
+	lk    sync.Mutex
+	loads []instrument.BlockLoad
+}
+
+func (r *recorder) BlockLoaded(_ context.Context, load instrument.BlockLoad) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.loads = append(r.loads, load)
+}
+
+func TestObserver(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	content := random.Bytes(10 * 1024)
+	f, _, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls)
+	require.NoError(t, err)
+
+	var entries []dagpb.PBLink
+	for i := 0; i < 100; i++ {
+		e, err := builder.BuildUnixFSDirectoryEntry(fmt.Sprintf("%03d", i), 10, f)
+		require.NoError(t, err)
+		entries = append(entries, e)
+	}
+	dir, _, err := builder.BuildUnixFSShardedDirectory(16, multihash.MURMUR3X64_64, entries, &ls)
+	require.NoError(t, err)
+
+	r := &recorder{}
+	ctx := instrument.WithObserver(context.Background(), r)
+
+	// reading the file loads each of its ten leaves
+	root, err := ls.Load(ipld.LinkContext{Ctx: ctx}, f, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	ufn, err := file.NewUnixFSFile(ctx, root, &ls)
+	require.NoError(t, err)
+	rdr, err := ufn.AsLargeBytes()
+	require.NoError(t, err)
+	out, err := io.ReadAll(rdr)
+	require.NoError(t, err)
+	require.Equal(t, content, out)
+	require.Len(t, r.loads, 10)
+	leaves := make(map[cid.Cid]bool)
+	for _, load := range r.loads {
+		require.Equal(t, instrument.FileBlock, load.Purpose)
+		require.Equal(t, 1024, load.Size)
+		require.NoError(t, load.Err)
+		leaves[load.Cid] = true
+	}
+	require.Len(t, leaves, 10)
+
+	// iterating the directory loads its shards
+	r.loads = nil
+	reifying := ls
+	reifying.NodeReifier = unixfsnode.Reify
+	nd, err := reifying.Load(ipld.LinkContext{Ctx: ctx}, dir, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), nd.Length())
+	require.NotEmpty(t, r.loads)
+	for _, load := range r.loads {
+		require.Equal(t, instrument.HAMTShard, load.Purpose)
+		require.Positive(t, load.Size)
+		require.Equal(t, len(storage.Bag[string(load.Cid.Hash())]), load.Size)
+	}
+
+	// failed loads are reported too
+	r.loads = nil
+	broken := ls
+	broken.StorageReadOpener = func(lc ipld.LinkContext, l ipld.Link) (io.Reader, error) {
+		if l == f {
+			return storage.OpenRead(lc, l)
+		}
+		return nil, fmt.Errorf("not here")
+	}
+	ufn, err = file.NewUnixFSFile(ctx, root, &broken)
+	require.NoError(t, err)
+	_, err = ufn.AsBytes()
+	require.Error(t, err)
+	require.Len(t, r.loads, 1)
+	require.Error(t, r.loads[0].Err)
+}
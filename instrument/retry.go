@@ -0,0 +1,99 @@
+package instrument
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// RetryPolicy decides whether a block load that failed is tried again, for
+// LinkSystems backed by networked storage where loads can fail for a while
+// and then succeed.
+type RetryPolicy interface {
+	// Retry is called when attempt, counted from 1, at loading c failed with
+	// err. It returns how long to wait before trying again, or false to give
+	// up and return err to the reader.
+	Retry(ctx context.Context, c cid.Cid, attempt int, err error) (time.Duration, bool)
+}
+
+// RetryFunc is a RetryPolicy that calls itself.
+type RetryFunc func(ctx context.Context, c cid.Cid, attempt int, err error) (time.Duration, bool)
+
+// Retry calls f.
+func (f RetryFunc) Retry(ctx context.Context, c cid.Cid, attempt int, err error) (time.Duration, bool) {
+	return f(ctx, c, attempt, err)
+}
+
+type retryKey struct{}
+
+// WithRetryPolicy returns a context carrying p. Files and HAMT shards created
+// with this context, either directly or through reification on a LinkSystem,
+// retry the block loads that fail as p decides. Every attempt is reported to
+// the context's Observer. A load isn't retried once the context is done.
+func WithRetryPolicy(ctx context.Context, p RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryKey{}, p)
+}
+
+func retryPolicyFrom(ctx context.Context) RetryPolicy {
+	if ctx == nil {
+		return nil
+	}
+	p, _ := ctx.Value(retryKey{}).(RetryPolicy)
+	return p
+}
+
+// Backoff returns a RetryPolicy that retries loads that failed with a
+// transient error, as reported by IsTransient, until maxAttempts have been
+// made. It waits initial before the first retry, doubling the wait after each
+// retry up to max.
+func Backoff(maxAttempts int, initial, max time.Duration) RetryPolicy {
+	return RetryFunc(func(_ context.Context, _ cid.Cid, attempt int, err error) (time.Duration, bool) {
+		if attempt >= maxAttempts || !IsTransient(err) {
+			return 0, false
+		}
+		wait := initial
+		for i := 1; i < attempt && wait < max; i++ {
+			wait *= 2
+		}
+		return min(wait, max), true
+	})
+}
+
+// IsTransient reports whether err may go away if the load is tried again: a
+// block that wasn't found, as reported by the NotFound method of
+// go-ipld-format's ErrNotFound, or a timeout.
+func IsTransient(err error) bool {
+	var nf interface{ NotFound() bool }
+	if errors.As(err, &nf) && nf.NotFound() {
+		return true
+	}
+	var te interface{ Timeout() bool }
+	if errors.As(err, &te) && te.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// retry calls load until it succeeds or the context's RetryPolicy gives up.
+func retry[T any](ctx context.Context, c cid.Cid, load func() (T, error)) (T, error) {
+	p := retryPolicyFrom(ctx)
+	for attempt := 1; ; attempt++ {
+		v, err := load()
+		if err == nil || p == nil || ctx.Err() != nil {
+			return v, err
+		}
+		wait, ok := p.Retry(ctx, c, attempt, err)
+		if !ok {
+			return v, err
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return v, err
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package instrument_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/file"
+	"github.com/ipfs/go-unixfsnode/instrument"
+	"github.com/ipfs/go-unixfsnode/testutil"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	content := random.Bytes(10 * 1024)
+	f, _, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls)
+	require.NoError(t, err)
+	root, err := ls.Load(ipld.LinkContext{}, f, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	leaf := root.(dagpb.PBNode).FieldLinks().Lookup(3).FieldHash().Link().(cidlink.Link).Cid
+
+	read := func(ctx context.Context, faults ...testutil.Fault) ([]byte, error) {
+		faulty := testutil.FaultyLinkSystem(ls, faults...)
+		ufn, err := file.NewUnixFSFile(ctx, root, &faulty)
+		require.NoError(t, err)
+		return ufn.AsBytes()
+	}
+	notFound := format.ErrNotFound{Cid: leaf}
+	backoff := instrument.Backoff(3, time.Millisecond, 5*time.Millisecond)
+
+	// without a policy the first failure is returned
+	_, err = read(context.Background(), testutil.FailFirst(1, notFound, leaf))
+	require.ErrorIs(t, err, notFound)
+
+	// transient errors are retried, and every attempt is reported
+	r := &recorder{}
+	ctx := instrument.WithObserver(instrument.WithRetryPolicy(context.Background(), backoff), r)
+	out, err := read(ctx, testutil.FailFirst(2, notFound, leaf))
+	require.NoError(t, err)
+	require.Equal(t, content, out)
+	var attempts []error
+	for _, load := range r.loads {
+		if load.Cid == leaf {
+			attempts = append(attempts, load.Err)
+		}
+	}
+	require.Len(t, attempts, 3)
+	require.Error(t, attempts[0])
+	require.Error(t, attempts[1])
+	require.NoError(t, attempts[2])
+
+	// until the policy gives up
+	_, err = read(ctx, testutil.FailFirst(3, notFound, leaf))
+	require.ErrorIs(t, err, notFound)
+
+	// other errors aren't retried
+	var calls int
+	counting := instrument.RetryFunc(func(ctx context.Context, c cid.Cid, attempt int, err error) (time.Duration, bool) {
+		calls++
+		return backoff.Retry(ctx, c, attempt, err)
+	})
+	_, err = read(instrument.WithRetryPolicy(context.Background(), counting), testutil.FailFirst(1, nil, leaf))
+	require.ErrorIs(t, err, testutil.ErrInjectedFault)
+	require.Equal(t, 1, calls)
+
+	// nor are loads once the context is done
+	cancelled, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelling := instrument.RetryFunc(func(context.Context, cid.Cid, int, error) (time.Duration, bool) {
+		cancel()
+		return time.Hour, true
+	})
+	_, err = read(instrument.WithRetryPolicy(cancelled, cancelling), testutil.FailFirst(1, notFound, leaf))
+	require.ErrorIs(t, err, notFound)
+}
+
+func TestBackoff(t *testing.T) {
+	backoff := instrument.Backoff(5, 10*time.Millisecond, 35*time.Millisecond)
+	transient := format.ErrNotFound{}
+	for attempt, expected := range []time.Duration{10, 20, 35, 35} {
+		wait, ok := backoff.Retry(context.Background(), cid.Undef, attempt+1, transient)
+		require.True(t, ok)
+		require.Equal(t, expected*time.Millisecond, wait)
+	}
+	_, ok := backoff.Retry(context.Background(), cid.Undef, 5, transient)
+	require.False(t, ok)
+	_, ok = backoff.Retry(context.Background(), cid.Undef, 1, errors.New("permanent"))
+	require.False(t, ok)
+
+	require.True(t, instrument.IsTransient(context.DeadlineExceeded))
+	require.False(t, instrument.IsTransient(context.Canceled))
+}
@@ -1,6 +1,8 @@
 package iter
 
 import (
+	"fmt"
+
 	dagpb "github.com/ipld/go-codec-dagpb"
 	"github.com/ipld/go-ipld-prime"
 )
@@ -11,10 +13,48 @@ type pbLinkItr interface {
 	Done() bool
 }
 
+// linkCounter is implemented by pbLinkItrs that know how many links they
+// yield in all without reading them.
+type linkCounter interface {
+	Count() (int64, bool)
+}
+
+// position tracks the index of the last entry an iterator returned.
+type position struct {
+	index int64
+}
+
+// Index returns the index of the entry last returned by Next: its position
+// among the links of a basic directory, whichever way it is iterated, or the
+// number of entries returned before it from a sharded directory. It is -1
+// until Next returns an entry.
+func (p *position) Index() int64 {
+	return p.index
+}
+
+// total returns the number of entries itr yields in all, and whether that is
+// known without reading them all, for Total.
+func total(itr pbLinkItr) (int64, bool) {
+	if lc, ok := itr.(linkCounter); ok {
+		return lc.Count()
+	}
+	return 0, false
+}
+
 type TransformNameFunc func(dagpb.String) dagpb.String
 
+// emptyName is the key of links without a name; nodes are immutable, so one
+// is shared by all iterators.
+var emptyName = func() dagpb.String {
+	nb := dagpb.Type.String.NewBuilder()
+	if err := nb.AssignString(""); err != nil {
+		panic(err)
+	}
+	return nb.Build().(dagpb.String)
+}()
+
 func NewUnixFSDirMapIterator(itr pbLinkItr, transformName TransformNameFunc) ipld.MapIterator {
-	return &UnixFSDir__MapItr{itr, transformName}
+	return &UnixFSDir__MapItr{_substrate: itr, transformName: transformName, position: position{-1}}
 }
 
 // UnixFSDir__MapItr throught the links as if they were a map
@@ -22,16 +62,18 @@ func NewUnixFSDirMapIterator(itr pbLinkItr, transformName TransformNameFunc) ipl
 type UnixFSDir__MapItr struct {
 	_substrate    pbLinkItr
 	transformName TransformNameFunc
+	position
 }
 
 func (itr *UnixFSDir__MapItr) Next() (k ipld.Node, v ipld.Node, err error) {
-	_, next, err := itr._substrate.Next()
+	idx, next, err := itr._substrate.Next()
 	if err != nil {
 		return nil, nil, err
 	}
 	if next == nil {
 		return nil, nil, ipld.ErrIteratorOverread{}
 	}
+	itr.index = idx
 	if next.FieldName().Exists() {
 		name := next.FieldName().Must()
 		if itr.transformName != nil {
@@ -39,35 +81,38 @@ func (itr *UnixFSDir__MapItr) Next() (k ipld.Node, v ipld.Node, err error) {
 		}
 		return name, &IterLink{next}, nil
 	}
-	nb := dagpb.Type.String.NewBuilder()
-	err = nb.AssignString("")
-	if err != nil {
-		return nil, nil, err
-	}
-	s := nb.Build()
-	return s, &IterLink{next}, nil
+	return emptyName, &IterLink{next}, nil
 }
 
 func (itr *UnixFSDir__MapItr) Done() bool {
 	return itr._substrate.Done()
 }
 
+// Total returns the number of entries the iterator yields in all, and true,
+// if that is known without reading them: for basic directories, and for
+// sharded directories whose Length has already been counted.
+func (itr *UnixFSDir__MapItr) Total() (int64, bool) {
+	return total(itr._substrate)
+}
+
 type UnixFSDir__Itr struct {
 	_substrate    pbLinkItr
 	transformName TransformNameFunc
+	position
 }
 
 func NewUnixFSDirIterator(itr pbLinkItr, transformName TransformNameFunc) *UnixFSDir__Itr {
-	return &UnixFSDir__Itr{itr, transformName}
+	return &UnixFSDir__Itr{_substrate: itr, transformName: transformName, position: position{-1}}
 }
 func (itr *UnixFSDir__Itr) Next() (k dagpb.String, v dagpb.Link) {
-	_, next, err := itr._substrate.Next()
+	idx, next, err := itr._substrate.Next()
 	if err != nil {
 		return nil, nil
 	}
 	if next == nil {
 		return nil, nil
 	}
+	itr.index = idx
 	if next.FieldName().Exists() {
 		name := next.FieldName().Must()
 		if itr.transformName != nil {
@@ -75,15 +120,62 @@ func (itr *UnixFSDir__Itr) Next() (k dagpb.String, v dagpb.Link) {
 		}
 		return name, next.FieldHash()
 	}
-	nb := dagpb.Type.String.NewBuilder()
-	err = nb.AssignString("")
+	return emptyName, next.FieldHash()
+}
+
+func (itr *UnixFSDir__Itr) Done() bool {
+	return itr._substrate.Done()
+}
+
+// Total is as for UnixFSDir__MapItr.
+func (itr *UnixFSDir__Itr) Total() (int64, bool) {
+	return total(itr._substrate)
+}
+
+// UnixFSDir__RawItr iterates through the links of a directory without building
+// a node for each entry, for consumers that enumerate very large directories.
+// Names are returned as plain strings and links as the PBLinks of the
+// directory's own blocks, so iterating a basic directory allocates nothing.
+type UnixFSDir__RawItr struct {
+	_substrate pbLinkItr
+	prefixLen  int
+	position
+}
+
+// NewUnixFSDirRawIterator creates a UnixFSDir__RawItr. The first prefixLen
+// bytes of each name are dropped, as for the bucket prefix of HAMT links.
+func NewUnixFSDirRawIterator(itr pbLinkItr, prefixLen int) *UnixFSDir__RawItr {
+	return &UnixFSDir__RawItr{_substrate: itr, prefixLen: prefixLen, position: position{-1}}
+}
+
+// Next returns the name and link of the next entry; links without a name have
+// the name "". The CID of the entry is
+// link.FieldHash().Link().(cidlink.Link).Cid, and its size link.FieldTsize().
+func (itr *UnixFSDir__RawItr) Next() (string, dagpb.PBLink, error) {
+	idx, next, err := itr._substrate.Next()
 	if err != nil {
-		return nil, nil
+		return "", nil, err
+	}
+	if next == nil {
+		return "", nil, ipld.ErrIteratorOverread{}
 	}
-	s := nb.Build()
-	return s.(dagpb.String), next.FieldHash()
+	if !next.FieldName().Exists() {
+		itr.index = idx
+		return "", next, nil
+	}
+	name := next.FieldName().Must().String()
+	if len(name) < itr.prefixLen {
+		return "", nil, fmt.Errorf("link name %q is shorter than its %d byte prefix", name, itr.prefixLen)
+	}
+	itr.index = idx
+	return name[itr.prefixLen:], next, nil
 }
 
-func (itr *UnixFSDir__Itr) Done() bool {
+func (itr *UnixFSDir__RawItr) Done() bool {
 	return itr._substrate.Done()
 }
+
+// Total is as for UnixFSDir__MapItr.
+func (itr *UnixFSDir__RawItr) Total() (int64, bool) {
+	return total(itr._substrate)
+}
@@ -0,0 +1,63 @@
+package unixfsnode
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// UnwrapMetadata reads a UnixFS Metadata node, as built by
+// builder.BuildUnixFSMetadata, returning the reified node it wraps and the
+// MIME type it gives, which may be empty. n may be a dag-pb node or a node
+// reified from one. The wrapped node is loaded from lsys with ctx.
+func UnwrapMetadata(ctx context.Context, n ipld.Node, lsys *ipld.LinkSystem) (ipld.Node, string, error) {
+	n = Substrate(n)
+	pbn, ok := n.(dagpb.PBNode)
+	if !ok {
+		return nil, "", fmt.Errorf("cannot unwrap a node of kind %s as UnixFS metadata", n.Kind())
+	}
+	if !pbn.FieldData().Exists() {
+		return nil, "", fmt.Errorf("cannot unwrap a dag-pb node without UnixFS data")
+	}
+	ufsData, err := data.DecodeUnixFSData(pbn.FieldData().Must().Bytes())
+	if err != nil {
+		return nil, "", err
+	}
+	if ufsData.FieldDataType().Int() != data.Data_Metadata {
+		return nil, "", data.ErrWrongNodeType{Expected: data.Data_Metadata, Actual: ufsData.FieldDataType().Int()}
+	}
+	var mimeType string
+	if ufsData.FieldData().Exists() {
+		md, err := data.DecodeUnixFSMetadata(ufsData.FieldData().Must().Bytes())
+		if err != nil {
+			return nil, "", err
+		}
+		if md.FieldMimeType().Exists() {
+			mimeType = md.FieldMimeType().Must().String()
+		}
+	}
+	if links := pbn.FieldLinks().Length(); links != 1 {
+		return nil, "", fmt.Errorf("UnixFS metadata must have a single link, not %d", links)
+	}
+	lnk := pbn.FieldLinks().Lookup(0).FieldHash().Link()
+	var proto ipld.NodePrototype = basicnode.Prototype.Any
+	if cl, ok := lnk.(cidlink.Link); ok && cl.Cid.Prefix().Codec == cid.DagProtobuf {
+		proto = dagpb.Type.PBNode
+	}
+	lnkCtx := ipld.LinkContext{Ctx: ctx}
+	target, err := lsys.Load(lnkCtx, lnk, proto)
+	if err != nil {
+		return nil, "", err
+	}
+	target, err = Reify(lnkCtx, target, lsys)
+	if err != nil {
+		return nil, "", err
+	}
+	return target, mimeType, nil
+}
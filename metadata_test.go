@@ -0,0 +1,68 @@
+package unixfsnode_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/file"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata(t *testing.T) {
+	ctx := context.Background()
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	content := strings.Repeat("hello metadata ", 1000)
+	f, fsize, err := builder.BuildUnixFSFile(strings.NewReader(content), "size-1024", &ls)
+	require.NoError(t, err)
+	md, size, err := builder.BuildUnixFSMetadata("text/plain", f, fsize, &ls)
+	require.NoError(t, err)
+	require.Greater(t, size, fsize)
+
+	nd, err := ls.Load(ipld.LinkContext{Ctx: ctx}, md, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	reified, err := unixfsnode.Reify(ipld.LinkContext{Ctx: ctx}, nd, &ls)
+	require.NoError(t, err)
+	st, err := unixfsnode.Stat(reified)
+	require.NoError(t, err)
+	typ, err := st.LookupByString("Type")
+	require.NoError(t, err)
+	typeName, err := typ.AsString()
+	require.NoError(t, err)
+	require.Equal(t, "Metadata", typeName)
+
+	target, mimeType, err := unixfsnode.UnwrapMetadata(ctx, reified, &ls)
+	require.NoError(t, err)
+	require.Equal(t, "text/plain", mimeType)
+	rs, err := target.(file.LargeBytesNode).AsLargeBytes()
+	require.NoError(t, err)
+	got, err := io.ReadAll(rs)
+	require.NoError(t, err)
+	require.Equal(t, content, string(got))
+
+	// a Metadata node without a MIME type
+	md, _, err = builder.BuildUnixFSMetadata("", f, fsize, &ls)
+	require.NoError(t, err)
+	nd, err = ls.Load(ipld.LinkContext{Ctx: ctx}, md, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	_, mimeType, err = unixfsnode.UnwrapMetadata(ctx, nd, &ls)
+	require.NoError(t, err)
+	require.Empty(t, mimeType)
+
+	// only Metadata nodes can be unwrapped
+	nd, err = ls.Load(ipld.LinkContext{Ctx: ctx}, f, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	_, _, err = unixfsnode.UnwrapMetadata(ctx, nd, &ls)
+	require.ErrorIs(t, err, data.ErrWrongNodeType{Expected: data.Data_Metadata, Actual: data.Data_File})
+}
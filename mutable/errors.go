@@ -0,0 +1,25 @@
+package mutable
+
+type errorType string
+
+func (e errorType) Error() string {
+	return string(e)
+}
+
+const (
+	// ErrNotDirectory indicates a path traversed through, or an operation
+	// expected, a node that is not a UnixFS directory
+	ErrNotDirectory errorType = "not a directory"
+	// ErrIsDirectory indicates an operation expected a file but found a
+	// directory
+	ErrIsDirectory errorType = "is a directory"
+	// ErrDirectoryNotEmpty indicates an attempt to non-recursively remove a
+	// directory that still has entries
+	ErrDirectoryNotEmpty errorType = "directory not empty"
+	// ErrInvalidPath indicates a path that can not be operated on, such as the
+	// root of the session, or moving a directory beneath itself
+	ErrInvalidPath errorType = "invalid path"
+	// ErrRootMismatch indicates a Patch was applied to a root other than the
+	// one it was made from, or did not produce the root it was made to
+	ErrRootMismatch errorType = "root does not match the patch"
+)
@@ -0,0 +1,338 @@
+package mutable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+)
+
+// File is a copy-on-write handle for editing an existing UnixFS file. Only the
+// interior nodes of the file are loaded when it is opened; leaves are loaded
+// as they are written to, and only modified leaves are written as new blocks
+// on Flush. Every other leaf of the original file is reused as-is, but the
+// interior nodes are all rebuilt over the leaves, in the balanced layout,
+// so a file of another layout is rewritten as a balanced one.
+//
+// A File is not safe for concurrent use.
+type File struct {
+	ctx  context.Context
+	ls   *ipld.LinkSystem
+	opts options
+
+	root     ipld.Link
+	rootSize uint64
+	size     uint64
+	segments []*segment
+	dirty    bool
+}
+
+// segment is a contiguous range of the file's content. A clean segment is an
+// unmodified leaf of the stored file; a dirty segment holds its content in
+// memory until it is flushed.
+type segment struct {
+	link  ipld.Link
+	size  uint64
+	tsize uint64
+	data  []byte
+	dirty bool
+}
+
+// OpenFile opens the UnixFS file at root for editing. If root is nil, a new
+// empty file is created.
+func OpenFile(ctx context.Context, ls *ipld.LinkSystem, root ipld.Link, opts ...Option) (*File, error) {
+	f := &File{ctx: ctx, ls: ls, opts: applyOptions(opts)}
+	if f.opts.leafSize <= 0 {
+		return nil, fmt.Errorf("invalid leaf size: %d", f.opts.leafSize)
+	}
+	if root == nil {
+		f.dirty = true
+		return f, nil
+	}
+	size, tsize, err := f.collect(root)
+	if err != nil {
+		return nil, err
+	}
+	f.root = root
+	f.rootSize = tsize
+	f.size = size
+	return f, nil
+}
+
+// collect walks the interior nodes of the file DAG under lnk, appending a
+// segment for each leaf, and returns the content size and cumulative block
+// size of the DAG.
+func (f *File) collect(lnk ipld.Link) (uint64, uint64, error) {
+	raw, err := f.ls.LoadRaw(ipld.LinkContext{Ctx: f.ctx}, lnk)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !isDagPB(lnk) {
+		f.segments = append(f.segments, &segment{link: lnk, size: uint64(len(raw)), tsize: uint64(len(raw))})
+		return uint64(len(raw)), uint64(len(raw)), nil
+	}
+	pbnd, ufsData, err := decodeFileNode(raw)
+	if err != nil {
+		return 0, 0, err
+	}
+	var inline []byte
+	if ufsData.FieldData().Exists() {
+		inline = ufsData.FieldData().Must().Bytes()
+	}
+	links := pbnd.FieldLinks()
+	if links.Length() == 0 {
+		f.segments = append(f.segments, &segment{link: lnk, size: uint64(len(inline)), tsize: uint64(len(raw))})
+		return uint64(len(inline)), uint64(len(raw)), nil
+	}
+	if ufsData.FieldBlockSizes().Length() != links.Length() {
+		return 0, 0, fmt.Errorf("file node has %d blocksizes for %d links", ufsData.FieldBlockSizes().Length(), links.Length())
+	}
+	size := uint64(len(inline))
+	tsize := uint64(len(raw))
+	if len(inline) > 0 {
+		// data stored alongside links will be moved to a leaf of its own
+		f.segments = append(f.segments, &segment{size: size, data: inline, dirty: true})
+	}
+	itr := links.Iterator()
+	for !itr.Done() {
+		idx, pbLink := itr.Next()
+		child := pbLink.FieldHash().Link()
+		bs, err := ufsData.FieldBlockSizes().LookupByIndex(idx)
+		if err != nil {
+			return 0, 0, err
+		}
+		childSize, err := bs.AsInt()
+		if err != nil {
+			return 0, 0, err
+		}
+		if !isDagPB(child) && pbLink.FieldTsize().Exists() {
+			// raw leaves need not be loaded until they are modified
+			childTsize := uint64(pbLink.FieldTsize().Must().Int())
+			f.segments = append(f.segments, &segment{link: child, size: uint64(childSize), tsize: childTsize})
+			size += uint64(childSize)
+			tsize += childTsize
+			continue
+		}
+		cs, ct, err := f.collect(child)
+		if err != nil {
+			return 0, 0, err
+		}
+		size += cs
+		tsize += ct
+	}
+	return size, tsize, nil
+}
+
+func decodeFileNode(raw []byte) (dagpb.PBNode, data.UnixFSData, error) {
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagpb.DecodeBytes(nb, raw); err != nil {
+		return nil, nil, err
+	}
+	pbnd := nb.Build().(dagpb.PBNode)
+	if !pbnd.FieldData().Exists() {
+		return nil, nil, errors.New("dag-pb node is not a UnixFS node")
+	}
+	ufsData, err := data.DecodeUnixFSData(pbnd.FieldData().Must().Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	if dt := ufsData.FieldDataType().Int(); dt != data.Data_File && dt != data.Data_Raw {
+		return nil, nil, data.ErrWrongNodeType{Expected: data.Data_File, Actual: dt}
+	}
+	return pbnd, ufsData, nil
+}
+
+// Size returns the current size of the file, including unflushed changes.
+func (f *File) Size() uint64 {
+	return f.size
+}
+
+// load reads the content of a clean segment into memory so it can be modified.
+func (f *File) load(seg *segment) error {
+	if seg.dirty {
+		return nil
+	}
+	raw, err := f.ls.LoadRaw(ipld.LinkContext{Ctx: f.ctx}, seg.link)
+	if err != nil {
+		return err
+	}
+	content := raw
+	if isDagPB(seg.link) {
+		_, ufsData, err := decodeFileNode(raw)
+		if err != nil {
+			return err
+		}
+		content = nil
+		if ufsData.FieldData().Exists() {
+			content = ufsData.FieldData().Must().Bytes()
+		}
+	}
+	if uint64(len(content)) != seg.size {
+		return fmt.Errorf("leaf %s has %d bytes, expected %d", seg.link, len(content), seg.size)
+	}
+	seg.data = append([]byte(nil), content...)
+	seg.dirty = true
+	return nil
+}
+
+// ReadAt implements io.ReaderAt over the current content of the file.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if uint64(off) >= f.size {
+		return 0, io.EOF
+	}
+	var n int
+	var pos uint64
+	for _, seg := range f.segments {
+		segEnd := pos + seg.size
+		if n < len(p) && segEnd > uint64(off)+uint64(n) {
+			start := uint64(off) + uint64(n) - pos
+			content := seg.data
+			if !seg.dirty {
+				// read without retaining the leaf
+				clean := *seg
+				if err := f.load(&clean); err != nil {
+					return n, err
+				}
+				content = clean.data
+			}
+			n += copy(p[n:], content[start:])
+		}
+		pos = segEnd
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt writes p at the given offset, extending the file with zeros if the
+// offset is beyond its current end.
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	end := uint64(off) + uint64(len(p))
+	if end > f.size {
+		if err := f.extend(end); err != nil {
+			return 0, err
+		}
+	}
+	var pos uint64
+	for _, seg := range f.segments {
+		segEnd := pos + seg.size
+		if segEnd > uint64(off) && pos < end {
+			if err := f.load(seg); err != nil {
+				return 0, err
+			}
+			start := max(uint64(off), pos)
+			copy(seg.data[start-pos:], p[start-uint64(off):min(end, segEnd)-uint64(off)])
+		}
+		pos = segEnd
+	}
+	if len(p) > 0 {
+		f.dirty = true
+	}
+	return len(p), nil
+}
+
+// Truncate changes the size of the file, discarding content beyond the new
+// size or extending the file with zeros.
+func (f *File) Truncate(size int64) error {
+	if size < 0 {
+		return errors.New("negative size")
+	}
+	newSize := uint64(size)
+	if newSize == f.size {
+		return nil
+	}
+	f.dirty = true
+	if newSize > f.size {
+		return f.extend(newSize)
+	}
+	var pos uint64
+	for i, seg := range f.segments {
+		segEnd := pos + seg.size
+		if segEnd >= newSize {
+			if segEnd > newSize {
+				if err := f.load(seg); err != nil {
+					return err
+				}
+				seg.data = seg.data[:newSize-pos]
+				seg.size = newSize - pos
+			}
+			f.segments = f.segments[:i+1]
+			if seg.size == 0 {
+				f.segments = f.segments[:i]
+			}
+			break
+		}
+		pos = segEnd
+	}
+	f.size = newSize
+	return nil
+}
+
+// extend grows the file to newSize with zeros, filling the last leaf up to
+// the leaf size before adding new leaves.
+func (f *File) extend(newSize uint64) error {
+	leafSize := uint64(f.opts.leafSize)
+	if len(f.segments) > 0 {
+		last := f.segments[len(f.segments)-1]
+		if last.size < leafSize {
+			if err := f.load(last); err != nil {
+				return err
+			}
+			grow := min(leafSize-last.size, newSize-f.size)
+			last.data = append(last.data, make([]byte, grow)...)
+			last.size += grow
+			f.size += grow
+		}
+	}
+	for f.size < newSize {
+		grow := min(leafSize, newSize-f.size)
+		f.segments = append(f.segments, &segment{size: grow, data: make([]byte, grow), dirty: true})
+		f.size += grow
+	}
+	f.dirty = true
+	return nil
+}
+
+// Flush stores any modified leaves and rebuilds the interior nodes linking
+// them together with the unmodified leaves, and returns the link to, and total
+// size of, the new root of the file. Flushing an unmodified file returns its
+// original root.
+func (f *File) Flush() (ipld.Link, uint64, error) {
+	if !f.dirty {
+		return f.root, f.rootSize, nil
+	}
+	leaves := make([]builder.LeafMeta, 0, len(f.segments))
+	for _, seg := range f.segments {
+		if seg.dirty {
+			lnk, err := f.ls.Store(ipld.LinkContext{Ctx: f.ctx}, builder.DefaultLeafLinkPrototype, basicnode.NewBytes(seg.data))
+			if err != nil {
+				return nil, 0, err
+			}
+			seg.link = lnk
+			seg.tsize = uint64(len(seg.data))
+			seg.data = nil
+			seg.dirty = false
+		}
+		leaves = append(leaves, builder.LeafMeta{Link: seg.link, Size: seg.size, StoredSize: seg.tsize})
+	}
+	lnk, tsize, err := builder.BuildUnixFSFileFromLeaves(leaves, f.ls)
+	if err != nil {
+		return nil, 0, err
+	}
+	f.root, f.rootSize = lnk, tsize
+	f.dirty = false
+	return f.root, f.rootSize, nil
+}
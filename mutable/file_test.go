@@ -0,0 +1,153 @@
+package mutable_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/file"
+	"github.com/ipfs/go-unixfsnode/mutable"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, ls *ipld.LinkSystem, root ipld.Link) []byte {
+	nd, err := ls.Load(ipld.LinkContext{}, root, protoChooser(root))
+	require.NoError(t, err)
+	ufsFile, err := file.NewUnixFSFile(context.Background(), nd, ls)
+	require.NoError(t, err)
+	rs, err := ufsFile.AsLargeBytes()
+	require.NoError(t, err)
+	byts, err := io.ReadAll(rs)
+	require.NoError(t, err)
+	return byts
+}
+
+func TestFileWriteAt(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	content := random.Bytes(1 << 20)
+	root, size, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls)
+	require.NoError(t, err)
+
+	f, err := mutable.OpenFile(context.Background(), &ls, root, mutable.WithLeafSize(1024))
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(content)), f.Size())
+
+	// an unmodified file flushes to the same root
+	same, sameSize, err := f.Flush()
+	require.NoError(t, err)
+	require.Equal(t, root, same)
+	require.Equal(t, size, sameSize)
+
+	blocksBefore := len(storage.Bag)
+	patch := []byte("some new content which spans a leaf boundary")
+	n, err := f.WriteAt(patch, 5000)
+	require.NoError(t, err)
+	require.Equal(t, len(patch), n)
+	copy(content[5000:], patch)
+
+	buf := make([]byte, 100)
+	_, err = f.ReadAt(buf, 4990)
+	require.NoError(t, err)
+	require.Equal(t, content[4990:5090], buf)
+
+	newRoot, newSize, err := f.Flush()
+	require.NoError(t, err)
+	require.NotEqual(t, root, newRoot)
+	require.Equal(t, content, readFile(t, &ls, newRoot))
+	// two new leaves, plus a new interior node on the path to each and a new
+	// root, all other blocks are reused
+	require.LessOrEqual(t, len(storage.Bag)-blocksBefore, 5)
+
+	// the result is identical to importing the modified content from scratch
+	expected, expectedSize, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls)
+	require.NoError(t, err)
+	require.Equal(t, expected, newRoot)
+	require.Equal(t, expectedSize, newSize)
+}
+
+func TestFileFlushBalanced(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	// a full File node, and one more leaf once the file is extended
+	content := random.Bytes(builder.DefaultLinksPerBlock * 1024)
+	root, _, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls)
+	require.NoError(t, err)
+	f, err := mutable.OpenFile(context.Background(), &ls, root, mutable.WithLeafSize(1024))
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{1}, int64(len(content)))
+	require.NoError(t, err)
+	content = append(content, 1)
+
+	lnk, size, err := f.Flush()
+	require.NoError(t, err)
+	expected, expectedSize, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls)
+	require.NoError(t, err)
+	require.Equal(t, expected, lnk)
+	require.Equal(t, expectedSize, size)
+}
+
+func TestFileTruncateAndExtend(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	content := random.Bytes(10000)
+	root, _, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls)
+	require.NoError(t, err)
+
+	f, err := mutable.OpenFile(context.Background(), &ls, root, mutable.WithLeafSize(1024))
+	require.NoError(t, err)
+	require.NoError(t, f.Truncate(3000))
+	require.Equal(t, uint64(3000), f.Size())
+	got, _, err := f.Flush()
+	require.NoError(t, err)
+	require.Equal(t, content[:3000], readFile(t, &ls, got))
+
+	// writing past the end fills the gap with zeros
+	_, err = f.WriteAt([]byte("tail"), 5000)
+	require.NoError(t, err)
+	expected := append(append(append([]byte{}, content[:3000]...), make([]byte, 2000)...), []byte("tail")...)
+	got, _, err = f.Flush()
+	require.NoError(t, err)
+	require.Equal(t, expected, readFile(t, &ls, got))
+
+	require.NoError(t, f.Truncate(0))
+	got, size, err := f.Flush()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), size)
+	require.Empty(t, readFile(t, &ls, got))
+}
+
+func TestFileNew(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	f, err := mutable.OpenFile(context.Background(), &ls, nil)
+	require.NoError(t, err)
+	content := random.Bytes(1 << 20)
+	for off := 0; off < len(content); off += 100000 {
+		_, err = f.WriteAt(content[off:min(off+100000, len(content))], int64(off))
+		require.NoError(t, err)
+	}
+	got, size, err := f.Flush()
+	require.NoError(t, err)
+	expected, expectedSize, err := builder.BuildUnixFSFile(bytes.NewReader(content), "", &ls)
+	require.NoError(t, err)
+	require.Equal(t, expected, got)
+	require.Equal(t, expectedSize, size)
+}
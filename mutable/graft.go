@@ -0,0 +1,98 @@
+package mutable
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+
+	"github.com/ipld/go-ipld-prime"
+)
+
+// Graft links the DAG at lnk, of cumulative size tsize, into the Session at
+// the given path, replacing any file already there. The parent directory must
+// exist. The DAG is linked to as it is, without being loaded, so it may be a
+// file or a directory from any root, and its blocks are shared with that
+// root.
+func (s *Session) Graft(p string, lnk ipld.Link, tsize uint64) error {
+	parts := splitPath(p)
+	if len(parts) == 0 {
+		return &fs.PathError{Op: "graft", Path: p, Err: ErrInvalidPath}
+	}
+	along, err := s.walk(parts[:len(parts)-1], false)
+	if err != nil {
+		return &fs.PathError{Op: "graft", Path: p, Err: err}
+	}
+	parent := along.dir()
+	name := parts[len(parts)-1]
+	if existing, ok := parent.entries[name]; ok {
+		if isDir, err := s.isDir(existing); err != nil {
+			return &fs.PathError{Op: "graft", Path: p, Err: err}
+		} else if isDir {
+			return &fs.PathError{Op: "graft", Path: p, Err: fs.ErrExist}
+		}
+	}
+	parent.entries[name] = &entry{link: lnk, tsize: tsize}
+	along.markDirty()
+	return nil
+}
+
+// Cp copies the file or directory at srcPath under the directory src to
+// dstPath under the directory dst, and returns the link to, and total size of,
+// the new dst root. As with Session.Mv, if dstPath is an existing directory
+// the entry is copied inside it, keeping its name. If srcPath is the root of
+// src, the whole of src is copied, and dstPath must not be an existing
+// directory.
+//
+// Only the directories along srcPath are loaded from src, and only those
+// along dstPath are written; the copied DAG itself is neither loaded nor
+// written. A sharded directory along either path is loaded whole, every shard
+// of it, and a sharded directory along dstPath is rebuilt whole, so the cost
+// of copying into or out of a large HAMT grows with the number of its
+// entries, not the depth of the path. Shards whose entries are unchanged come
+// out with the same CIDs, but are still written again.
+func Cp(ctx context.Context, ls *ipld.LinkSystem, src ipld.Link, srcPath string, dst ipld.Link, dstPath string, opts ...Option) (ipld.Link, uint64, error) {
+	from, err := NewSession(ctx, ls, src, opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+	srcParts := splitPath(srcPath)
+	copied, err := from.lookup(srcParts)
+	if err != nil {
+		return nil, 0, &fs.PathError{Op: "cp", Path: srcPath, Err: err}
+	}
+	if copied.link == nil {
+		// src was nil, so there is nothing to copy
+		return nil, 0, &fs.PathError{Op: "cp", Path: srcPath, Err: fs.ErrNotExist}
+	}
+
+	to, err := NewSession(ctx, ls, dst, opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+	dstParts := splitPath(dstPath)
+	if len(srcParts) > 0 {
+		dstParts, err = to.into(dstParts, srcParts[len(srcParts)-1])
+		if err != nil {
+			return nil, 0, &fs.PathError{Op: "cp", Path: dstPath, Err: err}
+		}
+	}
+	if err := to.Graft(strings.Join(dstParts, "/"), copied.link, copied.tsize); err != nil {
+		return nil, 0, err
+	}
+	return to.Flush()
+}
+
+// Mv moves the file or directory at srcPath to dstPath under the directory
+// root, as Session.Mv does, and returns the link to, and total size of, the
+// new root. Only the directories along the two paths are written, though, as
+// with Cp, a sharded directory along either path is loaded and rebuilt whole.
+func Mv(ctx context.Context, ls *ipld.LinkSystem, root ipld.Link, srcPath, dstPath string, opts ...Option) (ipld.Link, uint64, error) {
+	s, err := NewSession(ctx, ls, root, opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := s.Mv(srcPath, dstPath); err != nil {
+		return nil, 0, err
+	}
+	return s.Flush()
+}
@@ -0,0 +1,79 @@
+package mutable_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/ipfs/go-unixfsnode/mutable"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCpAndMv(t *testing.T) {
+	ctx := context.Background()
+	ls := mkLinkSystem()
+	var writes int
+	storageWrite := ls.StorageWriteOpener
+	ls.StorageWriteOpener = func(lctx ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+		writes++
+		return storageWrite(lctx)
+	}
+	build := func(files map[string]string, dirs ...string) ipld.Link {
+		s, err := mutable.NewSession(ctx, &ls, nil)
+		require.NoError(t, err)
+		for _, dir := range dirs {
+			require.NoError(t, s.Mkdir(dir, true))
+		}
+		for p, content := range files {
+			require.NoError(t, s.WriteFile(p, bytes.NewBufferString(content)))
+		}
+		root, _, err := s.Flush()
+		require.NoError(t, err)
+		return root
+	}
+	src := build(map[string]string{"/sub/hello.txt": "hello", "/top.txt": "top"}, "/sub")
+	dst := build(map[string]string{"/x/y/old.txt": "old"}, "/x/y", "/z")
+
+	// copying into an existing directory keeps the name, and only writes the
+	// directories along the destination path
+	writes = 0
+	root, _, err := mutable.Cp(ctx, &ls, src, "/sub/hello.txt", dst, "/x/y")
+	require.NoError(t, err)
+	require.Equal(t, 3, writes)
+	require.Equal(t, []byte("hello"), readPath(t, &ls, root, "x", "y", "hello.txt"))
+	require.Equal(t, []byte("old"), readPath(t, &ls, root, "x", "y", "old.txt"))
+
+	// copying a directory to a new name, and the whole of a root
+	root, _, err = mutable.Cp(ctx, &ls, src, "sub", root, "z/copied")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), readPath(t, &ls, root, "z", "copied", "hello.txt"))
+	root, _, err = mutable.Cp(ctx, &ls, src, "/", root, "/all")
+	require.NoError(t, err)
+	require.Equal(t, []byte("top"), readPath(t, &ls, root, "all", "top.txt"))
+	require.Equal(t, []byte("hello"), readPath(t, &ls, root, "all", "sub", "hello.txt"))
+
+	_, _, err = mutable.Cp(ctx, &ls, src, "/missing", root, "/x")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, _, err = mutable.Cp(ctx, &ls, src, "/", root, "/x")
+	require.ErrorIs(t, err, fs.ErrExist)
+	_, _, err = mutable.Cp(ctx, &ls, src, "/top.txt", root, "/nope/top.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	// the source is unchanged
+	require.Equal(t, []byte("hello"), readPath(t, &ls, src, "sub", "hello.txt"))
+
+	root, _, err = mutable.Mv(ctx, &ls, root, "/x/y/old.txt", "/z")
+	require.NoError(t, err)
+	require.Equal(t, []byte("old"), readPath(t, &ls, root, "z", "old.txt"))
+	nd, err := ls.Load(ipld.LinkContext{}, root, protoChooser(root))
+	require.NoError(t, err)
+	nd, err = nd.LookupByString("x")
+	require.NoError(t, err)
+	lnk, err := nd.AsLink()
+	require.NoError(t, err)
+	_, _, err = mutable.Mv(ctx, &ls, lnk, "/y/old.txt", "/old.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -0,0 +1,37 @@
+package mutable
+
+import chunk "github.com/ipfs/boxo/chunker"
+
+type options struct {
+	chunker  string
+	leafSize int
+}
+
+// Option configures a Session or File
+type Option func(*options)
+
+func applyOptions(opts []Option) options {
+	o := options{leafSize: int(chunk.DefaultBlockSize)}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithChunker sets the chunker used by Session.WriteFile, in the format
+// accepted by builder.BuildUnixFSFile. The default chunker is used if this is
+// not set.
+func WithChunker(chunker string) Option {
+	return func(o *options) {
+		o.chunker = chunker
+	}
+}
+
+// WithLeafSize sets the maximum size of the leaf blocks a File creates when
+// new data is written to it. Leaves that are not modified keep their existing
+// size. The default is the default chunker's block size.
+func WithLeafSize(size int) Option {
+	return func(o *options) {
+		o.leafSize = size
+	}
+}
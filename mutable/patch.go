@@ -0,0 +1,314 @@
+package mutable
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// PatchVersion is the version of the patch format made by Diff and Encode.
+const PatchVersion = 1
+
+// PatchAction is what a PatchOp does to the entry at its path.
+type PatchAction string
+
+const (
+	// PatchAdd links Cid at a path where there is no entry
+	PatchAdd PatchAction = "add"
+	// PatchRemove removes the entry at a path, with everything below it
+	PatchRemove PatchAction = "remove"
+	// PatchModify replaces the entry at a path with Cid, or the root itself
+	// if the path is empty
+	PatchModify PatchAction = "modify"
+)
+
+// PatchOp is a single change made by a Patch.
+type PatchOp struct {
+	Action PatchAction `json:"op"`
+	// Path is the slash-separated path of the entry from the root, which is
+	// at ""
+	Path string `json:"path"`
+	// Cid is the root of the file or directory added, or the replacement for
+	// a modified entry. It is undefined for removals.
+	Cid cid.Cid `json:"cid"`
+	// Tsize is the cumulative size of the DAG at Cid, as given in the link to
+	// it
+	Tsize uint64 `json:"tsize,omitempty"`
+}
+
+// Patch is a set of changes to a UnixFS directory tree, as made by Diff. A
+// patch refers to the files and directories it adds by CID, without their
+// content, so a party that already has most of the blocks of the new tree
+// needs only the patch and the blocks of the DAGs it adds to apply it.
+type Patch struct {
+	Version int `json:"version"`
+	// From is the root the patch applies to, undefined for an empty
+	// directory, and To the root it produces
+	From cid.Cid   `json:"from"`
+	To   cid.Cid   `json:"to"`
+	Ops  []PatchOp `json:"ops"`
+}
+
+// Diff compares the UnixFS directories from and to and returns the patch that
+// turns from into to when given to ApplyPatch. If from is nil it is taken to
+// be an empty directory.
+//
+// Entries whose links are the same are not loaded, so only the directories
+// that differ are read, including the shards of sharded directories. A
+// directory present in both is compared entry by entry, as long as
+// rewriting it with the entries of to, keeping its mode, mtime and sharding
+// as ApplyPatch does, gives the directory in to. Otherwise, as when its
+// metadata changed, and for any other entry that changed, including a file
+// replaced by a directory, the whole entry is replaced with a single
+// PatchModify. Ops are in order of path, with the entries of a directory
+// sorted by name.
+func Diff(ctx context.Context, ls *ipld.LinkSystem, from, to ipld.Link) (Patch, error) {
+	if to == nil {
+		return Patch{}, fmt.Errorf("no root to diff to")
+	}
+	a, err := NewSession(ctx, ls, from)
+	if err != nil {
+		return Patch{}, err
+	}
+	b, err := NewSession(ctx, ls, to)
+	if err != nil {
+		return Patch{}, err
+	}
+	// directories are rebuilt only to see what they would be linked as
+	dry := *ls
+	dry.StorageWriteOpener = func(ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+		return io.Discard, func(ipld.Link) error { return nil }, nil
+	}
+	d := differ{from: a, to: b, dry: &Session{ctx: ctx, ls: &dry}}
+	patch := Patch{Version: PatchVersion}
+	if patch.From, err = linkCid(from); err != nil {
+		return Patch{}, err
+	}
+	if patch.To, err = linkCid(to); err != nil {
+		return Patch{}, err
+	}
+	if _, _, err := d.diff("", a.root, b.root); err != nil {
+		return Patch{}, err
+	}
+	patch.Ops = d.ops
+	return patch, nil
+}
+
+type differ struct {
+	from, to *Session
+	// dry rebuilds directories without storing them
+	dry *Session
+	ops []PatchOp
+}
+
+// diff compares the directories at p, and returns the link to, and size of,
+// the directory ApplyPatch writes at p given the ops added.
+func (d *differ) diff(p string, from, to *entry) (ipld.Link, uint64, error) {
+	if err := d.from.loadDir(from); err != nil {
+		return nil, 0, &fs.PathError{Op: "diff", Path: p, Err: err}
+	}
+	if err := d.to.loadDir(to); err != nil {
+		return nil, 0, &fs.PathError{Op: "diff", Path: p, Err: err}
+	}
+	start := len(d.ops)
+	names := make([]string, 0, len(from.dir.entries)+len(to.dir.entries))
+	for name := range from.dir.entries {
+		names = append(names, name)
+	}
+	for name := range to.dir.entries {
+		if _, ok := from.dir.entries[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	// the entries of the directory once patched
+	patched := make(map[string]*entry, len(to.dir.entries))
+	for _, name := range names {
+		entryPath := path.Join(p, name)
+		a, inFrom := from.dir.entries[name]
+		b, inTo := to.dir.entries[name]
+		if inTo {
+			patched[name] = b
+		}
+		switch {
+		case !inTo:
+			d.ops = append(d.ops, PatchOp{Action: PatchRemove, Path: entryPath})
+		case !inFrom:
+			if err := d.link(PatchAdd, entryPath, b); err != nil {
+				return nil, 0, err
+			}
+		case a.link.String() == b.link.String():
+		default:
+			aDir, err := d.from.isDir(a)
+			if err != nil {
+				return nil, 0, &fs.PathError{Op: "diff", Path: entryPath, Err: err}
+			}
+			bDir, err := d.to.isDir(b)
+			if err != nil {
+				return nil, 0, &fs.PathError{Op: "diff", Path: entryPath, Err: err}
+			}
+			if aDir && bDir {
+				lnk, tsize, err := d.diff(entryPath, a, b)
+				if err != nil {
+					return nil, 0, err
+				}
+				patched[name] = &entry{link: lnk, tsize: tsize}
+				continue
+			}
+			if err := d.link(PatchModify, entryPath, b); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	rebuilt := &entry{dir: &dir{entries: patched, dirty: true, meta: from.dir.meta}}
+	if err := d.dry.flush(rebuilt); err != nil {
+		return nil, 0, &fs.PathError{Op: "diff", Path: p, Err: err}
+	}
+	if rebuilt.link.String() == to.link.String() {
+		return rebuilt.link, rebuilt.tsize, nil
+	}
+	// patching the entries wouldn't give the directory in to, so replace it
+	d.ops = d.ops[:start]
+	if err := d.link(PatchModify, p, to); err != nil {
+		return nil, 0, err
+	}
+	return to.link, to.tsize, nil
+}
+
+func (d *differ) link(action PatchAction, p string, e *entry) error {
+	c, err := linkCid(e.link)
+	if err != nil {
+		return &fs.PathError{Op: "diff", Path: p, Err: err}
+	}
+	d.ops = append(d.ops, PatchOp{Action: action, Path: p, Cid: c, Tsize: e.tsize})
+	return nil
+}
+
+// linkCid returns the CID lnk links to, or an undefined CID if lnk is nil.
+func linkCid(lnk ipld.Link) (cid.Cid, error) {
+	if lnk == nil {
+		return cid.Undef, nil
+	}
+	cl, ok := lnk.(cidlink.Link)
+	if !ok {
+		return cid.Undef, fmt.Errorf("unsupported link type %T", lnk)
+	}
+	return cl.Cid, nil
+}
+
+// Encode serializes the patch, as JSON, for DecodePatch.
+func (p Patch) Encode() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// DecodePatch deserializes a patch serialized with Encode, checking that it is
+// of a supported version and that each of its ops is well formed.
+func DecodePatch(b []byte) (Patch, error) {
+	var p Patch
+	if err := json.Unmarshal(b, &p); err != nil {
+		return Patch{}, err
+	}
+	if err := p.validate(); err != nil {
+		return Patch{}, err
+	}
+	return p, nil
+}
+
+func (p Patch) validate() error {
+	if p.Version != PatchVersion {
+		return fmt.Errorf("unsupported patch version %d", p.Version)
+	}
+	if !p.To.Defined() {
+		return fmt.Errorf("patch has no resulting root")
+	}
+	for _, op := range p.Ops {
+		if len(splitPath(op.Path)) == 0 && op.Action != PatchModify {
+			return &fs.PathError{Op: string(op.Action), Path: op.Path, Err: ErrInvalidPath}
+		}
+		switch op.Action {
+		case PatchAdd, PatchModify:
+			if !op.Cid.Defined() {
+				return &fs.PathError{Op: string(op.Action), Path: op.Path, Err: fmt.Errorf("missing cid")}
+			}
+		case PatchRemove:
+		default:
+			return fmt.Errorf("unknown patch op %q", op.Action)
+		}
+	}
+	return nil
+}
+
+// ApplyPatch applies the ops of patch to the UnixFS directory root, in order,
+// and returns the link to, and total size of, the new root, which is
+// patch.To. root must be patch.From, or nil if that is undefined, or
+// ErrRootMismatch is returned; it is also returned if the ops don't produce
+// patch.To, as when the patch has been tampered with.
+//
+// As with Cp, the DAGs added are linked to without being loaded, and only the
+// directories along the paths of the ops are loaded and written. Directories
+// written keep their mode, mtime and sharding, as Session.Flush does.
+func ApplyPatch(ctx context.Context, ls *ipld.LinkSystem, root ipld.Link, patch Patch, opts ...Option) (ipld.Link, uint64, error) {
+	if err := patch.validate(); err != nil {
+		return nil, 0, err
+	}
+	if c, err := linkCid(root); err != nil {
+		return nil, 0, err
+	} else if !c.Equals(patch.From) {
+		return nil, 0, fmt.Errorf("%w: patch applies to %s, not %s", ErrRootMismatch, patch.From, c)
+	}
+	s, err := NewSession(ctx, ls, root, opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, op := range patch.Ops {
+		parts := splitPath(op.Path)
+		if len(parts) == 0 {
+			// a modify of the root itself
+			s.root = &entry{link: cidlink.Link{Cid: op.Cid}, tsize: op.Tsize}
+			continue
+		}
+		existing, err := s.lookup(parts)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, 0, &fs.PathError{Op: string(op.Action), Path: op.Path, Err: err}
+		}
+		switch op.Action {
+		case PatchAdd:
+			if existing != nil {
+				return nil, 0, &fs.PathError{Op: string(op.Action), Path: op.Path, Err: fs.ErrExist}
+			}
+		default:
+			if existing == nil {
+				return nil, 0, &fs.PathError{Op: string(op.Action), Path: op.Path, Err: fs.ErrNotExist}
+			}
+			if err := s.Rm(op.Path, true); err != nil {
+				return nil, 0, err
+			}
+		}
+		if op.Action == PatchRemove {
+			continue
+		}
+		if err := s.Graft(op.Path, cidlink.Link{Cid: op.Cid}, op.Tsize); err != nil {
+			return nil, 0, err
+		}
+	}
+	lnk, tsize, err := s.Flush()
+	if err != nil {
+		return nil, 0, err
+	}
+	if c, err := linkCid(lnk); err != nil {
+		return nil, 0, err
+	} else if !c.Equals(patch.To) {
+		return nil, 0, fmt.Errorf("%w: patch produced %s, expected %s", ErrRootMismatch, c, patch.To)
+	}
+	return lnk, tsize, nil
+}
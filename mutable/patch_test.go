@@ -0,0 +1,206 @@
+package mutable_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/mutable"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffAndApplyPatch(t *testing.T) {
+	ctx := context.Background()
+	ls := mkLinkSystem()
+
+	s, err := mutable.NewSession(ctx, &ls, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.Mkdir("/a/b", true))
+	require.NoError(t, s.Mkdir("/gone", true))
+	for p, content := range map[string]string{
+		"/a/b/same.txt":    "same",
+		"/a/b/changed.txt": "before",
+		"/a/removed.txt":   "removed",
+		"/gone/file.txt":   "gone",
+		"/becomes-dir":     "file",
+		"/top.txt":         "top",
+	} {
+		require.NoError(t, s.WriteFile(p, bytes.NewBufferString(content)))
+	}
+	from, _, err := s.Flush()
+	require.NoError(t, err)
+
+	require.NoError(t, s.WriteFile("/a/b/changed.txt", bytes.NewBufferString("after")))
+	require.NoError(t, s.Rm("/a/removed.txt", false))
+	require.NoError(t, s.Rm("/gone", true))
+	require.NoError(t, s.Rm("/becomes-dir", false))
+	require.NoError(t, s.Mkdir("/becomes-dir", false))
+	require.NoError(t, s.WriteFile("/becomes-dir/inner.txt", bytes.NewBufferString("inner")))
+	require.NoError(t, s.Mkdir("/new/dir", true))
+	require.NoError(t, s.WriteFile("/new/dir/file.txt", bytes.NewBufferString("new")))
+	to, _, err := s.Flush()
+	require.NoError(t, err)
+
+	patch, err := mutable.Diff(ctx, &ls, from, to)
+	require.NoError(t, err)
+	var ops []string
+	for _, op := range patch.Ops {
+		ops = append(ops, string(op.Action)+" "+op.Path)
+	}
+	require.Equal(t, []string{
+		"modify a/b/changed.txt",
+		"remove a/removed.txt",
+		"modify becomes-dir",
+		"remove gone",
+		"add new",
+	}, ops)
+
+	encoded, err := patch.Encode()
+	require.NoError(t, err)
+	decoded, err := mutable.DecodePatch(encoded)
+	require.NoError(t, err)
+	require.Equal(t, patch, decoded)
+
+	root, _, err := mutable.ApplyPatch(ctx, &ls, from, decoded)
+	require.NoError(t, err)
+	require.Equal(t, to, root)
+	require.Equal(t, []byte("new"), readPath(t, &ls, root, "new", "dir", "file.txt"))
+
+	// the patch no longer applies once applied
+	_, _, err = mutable.ApplyPatch(ctx, &ls, root, patch)
+	require.ErrorIs(t, err, mutable.ErrRootMismatch)
+
+	// nor does a tampered one
+	tampered := patch
+	tampered.Ops = append([]mutable.PatchOp(nil), patch.Ops...)
+	tampered.Ops[0].Cid = tampered.Ops[len(tampered.Ops)-1].Cid
+	_, _, err = mutable.ApplyPatch(ctx, &ls, from, tampered)
+	require.ErrorIs(t, err, mutable.ErrRootMismatch)
+
+	patch, err = mutable.Diff(ctx, &ls, to, to)
+	require.NoError(t, err)
+	require.Empty(t, patch.Ops)
+
+	// from nothing, everything is added
+	patch, err = mutable.Diff(ctx, &ls, nil, to)
+	require.NoError(t, err)
+	require.Len(t, patch.Ops, 4)
+	root, _, err = mutable.ApplyPatch(ctx, &ls, nil, patch)
+	require.NoError(t, err)
+	require.Equal(t, to, root)
+	_, _, err = mutable.ApplyPatch(ctx, &ls, to, patch)
+	require.ErrorIs(t, err, mutable.ErrRootMismatch)
+
+	toField := `"to":{"/":"` + patch.To.String() + `"}`
+	_, err = mutable.DecodePatch([]byte(`{"version":2,` + toField + `,"ops":[]}`))
+	require.Error(t, err)
+	_, err = mutable.DecodePatch([]byte(`{"version":1,"ops":[]}`))
+	require.Error(t, err)
+	_, err = mutable.DecodePatch([]byte(`{"version":1,` + toField + `,"ops":[{"op":"add","path":"x","cid":null}]}`))
+	require.Error(t, err)
+	_, err = mutable.DecodePatch([]byte(`{"version":1,` + toField + `,"ops":[{"op":"remove","path":"/"}]}`))
+	require.ErrorIs(t, err, mutable.ErrInvalidPath)
+}
+
+func TestDiffDirectoryMetadata(t *testing.T) {
+	ctx := context.Background()
+	ls := mkLinkSystem()
+
+	var entries []dagpb.PBLink
+	for i := 0; i < 3; i++ {
+		f, size, err := builder.BuildUnixFSFile(bytes.NewBufferString(fmt.Sprintf("file %d", i)), "", &ls)
+		require.NoError(t, err)
+		e, err := builder.BuildUnixFSDirectoryEntry(fmt.Sprintf("file %d", i), int64(size), f)
+		require.NoError(t, err)
+		entries = append(entries, e)
+	}
+	tree := func(opts ...builder.DirectoryOption) ipld.Link {
+		sub, size, err := builder.BuildUnixFSDirectory(entries, &ls, opts...)
+		require.NoError(t, err)
+		e, err := builder.BuildUnixFSDirectoryEntry("sub", int64(size), sub)
+		require.NoError(t, err)
+		root, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{e}, &ls, opts...)
+		require.NoError(t, err)
+		return root
+	}
+	from := tree(builder.WithDirectoryMtime(time.Unix(1000, 0)), builder.WithDirectoryMode(0o700))
+	to := tree(builder.WithDirectoryMtime(time.Unix(2000, 0)), builder.WithDirectoryMode(0o700))
+
+	// the entries are the same, but the root differs, so it is replaced
+	patch, err := mutable.Diff(ctx, &ls, from, to)
+	require.NoError(t, err)
+	require.Len(t, patch.Ops, 1)
+	require.Equal(t, mutable.PatchModify, patch.Ops[0].Action)
+	require.Equal(t, "", patch.Ops[0].Path)
+	root, _, err := mutable.ApplyPatch(ctx, &ls, from, patch)
+	require.NoError(t, err)
+	require.Equal(t, to, root)
+
+	// a change below keeps the mode and mtime of the directories rewritten
+	s, err := mutable.NewSession(ctx, &ls, from)
+	require.NoError(t, err)
+	require.NoError(t, s.WriteFile("/sub/file 1", bytes.NewBufferString("changed")))
+	changed, _, err := s.Flush()
+	require.NoError(t, err)
+	patch, err = mutable.Diff(ctx, &ls, from, changed)
+	require.NoError(t, err)
+	require.Len(t, patch.Ops, 1)
+	require.Equal(t, "sub/file 1", patch.Ops[0].Path)
+	root, _, err = mutable.ApplyPatch(ctx, &ls, from, patch)
+	require.NoError(t, err)
+	require.Equal(t, changed, root)
+}
+
+func TestDiffShardedDirectory(t *testing.T) {
+	ctx := context.Background()
+	ls := mkLinkSystem()
+
+	var entries []dagpb.PBLink
+	for i := 0; i < 500; i++ {
+		name := fmt.Sprintf("file %d", i)
+		f, size, err := builder.BuildUnixFSFile(bytes.NewBufferString(name), "", &ls)
+		require.NoError(t, err)
+		e, err := builder.BuildUnixFSDirectoryEntry(name, int64(size), f)
+		require.NoError(t, err)
+		entries = append(entries, e)
+	}
+	from, _, err := builder.BuildUnixFSShardedDirectory(16, multihash.MURMUR3X64_64, entries[:400], &ls)
+	require.NoError(t, err)
+	to, _, err := builder.BuildUnixFSShardedDirectory(16, multihash.MURMUR3X64_64, entries[100:], &ls)
+	require.NoError(t, err)
+
+	patch, err := mutable.Diff(ctx, &ls, from, to)
+	require.NoError(t, err)
+	var adds, removes int
+	for _, op := range patch.Ops {
+		switch op.Action {
+		case mutable.PatchAdd:
+			adds++
+		case mutable.PatchRemove:
+			removes++
+		default:
+			t.Fatalf("unexpected op %s %s", op.Action, op.Path)
+		}
+	}
+	require.Equal(t, 100, adds)
+	require.Equal(t, 100, removes)
+	root, _, err := mutable.ApplyPatch(ctx, &ls, from, patch)
+	require.NoError(t, err)
+	require.Equal(t, to, root)
+
+	// a different fanout can't be reached by patching the entries
+	wider, _, err := builder.BuildUnixFSShardedDirectory(256, multihash.MURMUR3X64_64, entries[100:], &ls)
+	require.NoError(t, err)
+	patch, err = mutable.Diff(ctx, &ls, from, wider)
+	require.NoError(t, err)
+	require.Len(t, patch.Ops, 1)
+	root, _, err = mutable.ApplyPatch(ctx, &ls, from, patch)
+	require.NoError(t, err)
+	require.Equal(t, wider, root)
+}
@@ -0,0 +1,542 @@
+// Package mutable provides a mutable, MFS-like view over a UnixFS directory
+// tree. Changes are made in memory against a Session and are only written to
+// the underlying LinkSystem, producing a new root, when the Session is
+// flushed. Subtrees that are never modified are never loaded or rewritten.
+//
+// File provides the same copy-on-write behaviour for the content of a single
+// UnixFS file, and Cp and Mv apply a single edit to a root, including copying
+// a sub-DAG from one root into another. Diff records the changes between two
+// roots as a serializable Patch, which ApplyPatch applies to a root.
+package mutable
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// Session holds an in-memory, mutable copy of a UnixFS directory tree.
+type Session struct {
+	ctx context.Context
+	ls  *ipld.LinkSystem
+	// pbls is a copy of ls without reification, for reading raw dag-pb
+	pbls ipld.LinkSystem
+	opts options
+	root *entry
+}
+
+// entry is a named member of a directory. Until a directory entry is
+// traversed for modification it is represented only by its link; once loaded,
+// dir holds its (possibly modified) entries and link is only valid while the
+// directory is not dirty.
+type entry struct {
+	link  ipld.Link
+	tsize uint64
+	dir   *dir
+}
+
+type dir struct {
+	entries map[string]*entry
+	dirty   bool
+	// meta is the UnixFS Data of the directory as loaded, whose mode, mtime
+	// and sharding are kept when it is written again; nil for a new directory
+	meta data.UnixFSData
+}
+
+// NewSession creates a Session rooted at the UnixFS directory pointed to by
+// root. If root is nil the Session starts with an empty directory.
+func NewSession(ctx context.Context, ls *ipld.LinkSystem, root ipld.Link, opts ...Option) (*Session, error) {
+	s := &Session{ctx: ctx, ls: ls, pbls: *ls, opts: applyOptions(opts)}
+	s.pbls.NodeReifier = nil
+	if root == nil {
+		s.root = &entry{dir: &dir{entries: make(map[string]*entry), dirty: true}}
+		return s, nil
+	}
+	tsize, err := s.rootSize(root)
+	if err != nil {
+		return nil, err
+	}
+	s.root = &entry{link: root, tsize: tsize}
+	if err := s.loadDir(s.root); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// rootSize calculates the cumulative size of the DAG under lnk from the size
+// of its root block and the Tsize of each of its links.
+func (s *Session) rootSize(lnk ipld.Link) (uint64, error) {
+	raw, err := s.ls.LoadRaw(ipld.LinkContext{Ctx: s.ctx}, lnk)
+	if err != nil {
+		return 0, err
+	}
+	size := uint64(len(raw))
+	if !isDagPB(lnk) {
+		return size, nil
+	}
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagpb.DecodeBytes(nb, raw); err != nil {
+		return 0, err
+	}
+	itr := nb.Build().(dagpb.PBNode).FieldLinks().Iterator()
+	for !itr.Done() {
+		_, l := itr.Next()
+		if l.FieldTsize().Exists() {
+			size += uint64(l.FieldTsize().Must().Int())
+		}
+	}
+	return size, nil
+}
+
+// Mkdir creates an empty directory at the given path. If parents is true,
+// any missing parent directories are also created and it is not an error for
+// the directory to already exist.
+func (s *Session) Mkdir(p string, parents bool) error {
+	parts := splitPath(p)
+	if len(parts) == 0 {
+		if parents {
+			return nil
+		}
+		return &fs.PathError{Op: "mkdir", Path: p, Err: fs.ErrExist}
+	}
+	along, err := s.walk(parts[:len(parts)-1], parents)
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: p, Err: err}
+	}
+	parent := along.dir()
+	name := parts[len(parts)-1]
+	if existing, ok := parent.entries[name]; ok {
+		if parents {
+			if err := s.loadDir(existing); err != nil {
+				return &fs.PathError{Op: "mkdir", Path: p, Err: err}
+			}
+			return nil
+		}
+		return &fs.PathError{Op: "mkdir", Path: p, Err: fs.ErrExist}
+	}
+	parent.entries[name] = newDirEntry()
+	along.markDirty()
+	return nil
+}
+
+// WriteFile imports the contents of r as a UnixFS file at the given path,
+// replacing any existing file at that path. The parent directory must exist.
+func (s *Session) WriteFile(p string, r io.Reader) error {
+	parts := splitPath(p)
+	if len(parts) == 0 {
+		return &fs.PathError{Op: "write", Path: p, Err: ErrIsDirectory}
+	}
+	along, err := s.walk(parts[:len(parts)-1], false)
+	if err != nil {
+		return &fs.PathError{Op: "write", Path: p, Err: err}
+	}
+	parent := along.dir()
+	name := parts[len(parts)-1]
+	if existing, ok := parent.entries[name]; ok {
+		if isDir, err := s.isDir(existing); err != nil {
+			return &fs.PathError{Op: "write", Path: p, Err: err}
+		} else if isDir {
+			return &fs.PathError{Op: "write", Path: p, Err: ErrIsDirectory}
+		}
+	}
+	lnk, size, err := builder.BuildUnixFSFile(r, s.opts.chunker, s.ls)
+	if err != nil {
+		return &fs.PathError{Op: "write", Path: p, Err: err}
+	}
+	parent.entries[name] = &entry{link: lnk, tsize: size}
+	along.markDirty()
+	return nil
+}
+
+// Rm removes the file or directory at the given path. Non-empty directories
+// are only removed if recursive is true.
+func (s *Session) Rm(p string, recursive bool) error {
+	parts := splitPath(p)
+	if len(parts) == 0 {
+		return &fs.PathError{Op: "rm", Path: p, Err: ErrInvalidPath}
+	}
+	along, err := s.walk(parts[:len(parts)-1], false)
+	if err != nil {
+		return &fs.PathError{Op: "rm", Path: p, Err: err}
+	}
+	parent := along.dir()
+	name := parts[len(parts)-1]
+	existing, ok := parent.entries[name]
+	if !ok {
+		return &fs.PathError{Op: "rm", Path: p, Err: fs.ErrNotExist}
+	}
+	if !recursive {
+		isDir, err := s.isDir(existing)
+		if err != nil {
+			return &fs.PathError{Op: "rm", Path: p, Err: err}
+		}
+		if isDir {
+			if err := s.loadDir(existing); err != nil {
+				return &fs.PathError{Op: "rm", Path: p, Err: err}
+			}
+			if len(existing.dir.entries) > 0 {
+				return &fs.PathError{Op: "rm", Path: p, Err: ErrDirectoryNotEmpty}
+			}
+		}
+	}
+	delete(parent.entries, name)
+	along.markDirty()
+	return nil
+}
+
+// Mv moves the file or directory at src to dst. If dst is an existing
+// directory, src is moved inside it, keeping its name; otherwise src is
+// renamed to dst, replacing any file already there.
+func (s *Session) Mv(src, dst string) error {
+	srcParts := splitPath(src)
+	dstParts := splitPath(dst)
+	if len(srcParts) == 0 {
+		return &fs.PathError{Op: "mv", Path: src, Err: ErrInvalidPath}
+	}
+	moving, err := s.lookup(srcParts)
+	if err != nil {
+		return &fs.PathError{Op: "mv", Path: src, Err: err}
+	}
+	srcName := srcParts[len(srcParts)-1]
+
+	dstParts, err = s.into(dstParts, srcName)
+	if err != nil {
+		return &fs.PathError{Op: "mv", Path: dst, Err: err}
+	}
+	if len(dstParts) == 0 {
+		return &fs.PathError{Op: "mv", Path: dst, Err: ErrInvalidPath}
+	}
+	if isPrefix(srcParts, dstParts) {
+		if len(srcParts) == len(dstParts) {
+			// moving onto itself
+			return nil
+		}
+		return &fs.PathError{Op: "mv", Path: dst, Err: ErrInvalidPath}
+	}
+	dstAlong, err := s.walk(dstParts[:len(dstParts)-1], false)
+	if err != nil {
+		return &fs.PathError{Op: "mv", Path: dst, Err: err}
+	}
+	dstParent := dstAlong.dir()
+	dstName := dstParts[len(dstParts)-1]
+	if existing, ok := dstParent.entries[dstName]; ok {
+		if isDir, err := s.isDir(existing); err != nil {
+			return &fs.PathError{Op: "mv", Path: dst, Err: err}
+		} else if isDir {
+			return &fs.PathError{Op: "mv", Path: dst, Err: fs.ErrExist}
+		}
+	}
+	srcAlong, err := s.walk(srcParts[:len(srcParts)-1], false)
+	if err != nil {
+		return &fs.PathError{Op: "mv", Path: src, Err: err}
+	}
+	delete(srcAlong.dir().entries, srcName)
+	dstParent.entries[dstName] = moving
+	srcAlong.markDirty()
+	dstAlong.markDirty()
+	return nil
+}
+
+// Flush writes every modified directory to the LinkSystem and returns the
+// link to, and total size of, the new root directory. Flushing an unmodified
+// Session returns the original root.
+func (s *Session) Flush() (ipld.Link, uint64, error) {
+	if err := s.flush(s.root); err != nil {
+		return nil, 0, err
+	}
+	return s.root.link, s.root.tsize, nil
+}
+
+func (s *Session) flush(e *entry) error {
+	if e.dir == nil || !e.dir.dirty {
+		return nil
+	}
+	names := make([]string, 0, len(e.dir.entries))
+	for name := range e.dir.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	links := make([]dagpb.PBLink, 0, len(names))
+	for _, name := range names {
+		child := e.dir.entries[name]
+		if err := s.flush(child); err != nil {
+			return err
+		}
+		pbLink, err := builder.BuildUnixFSDirectoryEntry(name, int64(child.tsize), child.link)
+		if err != nil {
+			return err
+		}
+		links = append(links, pbLink)
+	}
+	lnk, size, err := s.store(e.dir, links)
+	if err != nil {
+		return err
+	}
+	e.link = lnk
+	e.tsize = size
+	e.dir.dirty = false
+	return nil
+}
+
+// store writes a directory with the given links, keeping the mode and mtime
+// it was loaded with, and, if it was sharded, its fanout and hash function. A
+// new directory is built as builder.BuildUnixFSDirectory builds it by default.
+func (s *Session) store(d *dir, links []dagpb.PBLink) (ipld.Link, uint64, error) {
+	if d.meta == nil {
+		return builder.BuildUnixFSDirectory(links, s.ls)
+	}
+	var opts []builder.DirectoryOption
+	if d.meta.FieldMode().Exists() {
+		opts = append(opts, builder.WithDirectoryMode(int(d.meta.FieldMode().Must().Int())))
+	}
+	if mtime := d.meta.ModTime(); !mtime.IsZero() {
+		opts = append(opts, builder.WithDirectoryMtime(mtime))
+	}
+	if d.meta.FieldDataType().Int() != data.Data_HAMTShard {
+		return builder.BuildUnixFSDirectory(links, s.ls, opts...)
+	}
+	if !d.meta.FieldHashType().Exists() {
+		return nil, 0, fmt.Errorf("HAMT shard missing hash type")
+	}
+	fanout := int(d.meta.FieldFanout().Must().Int())
+	hasher := uint64(d.meta.FieldHashType().Must().Int())
+	return builder.BuildUnixFSShardedDirectory(fanout, hasher, links, s.ls, opts...)
+}
+
+// walk descends through the named directories from the root and returns the
+// entries along the way, from the root to the last. Nothing is marked dirty,
+// as the caller may yet fail to modify the last directory; it marks the path
+// with markDirty once it has. If create is set, missing directories are
+// created, and the path to each is marked dirty as it is.
+func (s *Session) walk(parts []string, create bool) (dirPath, error) {
+	current := s.root
+	if err := s.loadDir(current); err != nil {
+		return nil, err
+	}
+	along := dirPath{current}
+	for _, name := range parts {
+		next, ok := current.dir.entries[name]
+		if !ok {
+			if !create {
+				return nil, fs.ErrNotExist
+			}
+			next = newDirEntry()
+			current.dir.entries[name] = next
+			along.markDirty()
+		}
+		if err := s.loadDir(next); err != nil {
+			return nil, err
+		}
+		along = append(along, next)
+		current = next
+	}
+	return along, nil
+}
+
+// dirPath is the directory entries along a path, from the root.
+type dirPath []*entry
+
+// dir returns the last directory of the path.
+func (p dirPath) dir() *dir {
+	return p[len(p)-1].dir
+}
+
+// markDirty marks every directory along the path as dirty, so that they are
+// written on Flush.
+func (p dirPath) markDirty() {
+	for _, e := range p {
+		e.dir.dirty = true
+	}
+}
+
+// into returns the path an entry called name is placed at when moved or
+// copied to parts: inside the directory at parts if there is one, otherwise
+// parts itself.
+func (s *Session) into(parts []string, name string) ([]string, error) {
+	target, err := s.lookup(parts)
+	if err != nil {
+		return parts, nil
+	}
+	isDir, err := s.isDir(target)
+	if err != nil {
+		return nil, err
+	}
+	if isDir {
+		return append(parts[:len(parts):len(parts)], name), nil
+	}
+	return parts, nil
+}
+
+// lookup finds the entry at the given path without marking anything dirty.
+func (s *Session) lookup(parts []string) (*entry, error) {
+	current := s.root
+	for _, name := range parts {
+		if err := s.loadDir(current); err != nil {
+			return nil, err
+		}
+		next, ok := current.dir.entries[name]
+		if !ok {
+			return nil, fs.ErrNotExist
+		}
+		current = next
+	}
+	return current, nil
+}
+
+func newDirEntry() *entry {
+	return &entry{dir: &dir{entries: make(map[string]*entry), dirty: true}}
+}
+
+// isDir reports whether the entry is a directory, loading just the entry's
+// root block if it has not already been loaded.
+func (s *Session) isDir(e *entry) (bool, error) {
+	if e.dir != nil {
+		return true, nil
+	}
+	_, ufsData, err := s.loadPB(e.link)
+	if err != nil {
+		return false, err
+	}
+	if ufsData == nil {
+		return false, nil
+	}
+	dt := ufsData.FieldDataType().Int()
+	return dt == data.Data_Directory || dt == data.Data_HAMTShard, nil
+}
+
+func (s *Session) loadPB(lnk ipld.Link) (dagpb.PBNode, data.UnixFSData, error) {
+	if !isDagPB(lnk) {
+		// e.g. a raw leaf
+		return nil, nil, nil
+	}
+	nd, err := s.pbls.Load(ipld.LinkContext{Ctx: s.ctx}, lnk, dagpb.Type.PBNode)
+	if err != nil {
+		return nil, nil, err
+	}
+	pbnd, ok := nd.(dagpb.PBNode)
+	if !ok {
+		return nil, nil, fmt.Errorf("expected a dag-pb node")
+	}
+	if !pbnd.FieldData().Exists() {
+		return pbnd, nil, nil
+	}
+	ufsData, err := data.DecodeUnixFSData(pbnd.Data.Must().Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	return pbnd, ufsData, nil
+}
+
+// loadDir populates the entries of a directory entry from its link, if not
+// already loaded, reading through all shards of a HAMT directory.
+func (s *Session) loadDir(e *entry) error {
+	if e.dir != nil {
+		return nil
+	}
+	pbnd, ufsData, err := s.loadPB(e.link)
+	if err != nil {
+		return err
+	}
+	if ufsData == nil {
+		return ErrNotDirectory
+	}
+	d := &dir{entries: make(map[string]*entry), meta: ufsData}
+	switch ufsData.FieldDataType().Int() {
+	case data.Data_Directory:
+		itr := pbnd.FieldLinks().Iterator()
+		for !itr.Done() {
+			_, lnk := itr.Next()
+			if !lnk.FieldName().Exists() {
+				return fmt.Errorf("directory link missing name")
+			}
+			d.entries[lnk.FieldName().Must().String()] = pbLinkEntry(lnk)
+		}
+	case data.Data_HAMTShard:
+		if err := s.loadShard(d, pbnd, ufsData); err != nil {
+			return err
+		}
+	default:
+		return ErrNotDirectory
+	}
+	e.dir = d
+	return nil
+}
+
+func (s *Session) loadShard(d *dir, pbnd dagpb.PBNode, ufsData data.UnixFSData) error {
+	if !ufsData.FieldFanout().Exists() {
+		return fmt.Errorf("HAMT shard missing fanout")
+	}
+	padLen := len(fmt.Sprintf("%X", ufsData.FieldFanout().Must().Int()-1))
+	itr := pbnd.FieldLinks().Iterator()
+	for !itr.Done() {
+		_, lnk := itr.Next()
+		if !lnk.FieldName().Exists() {
+			return fmt.Errorf("HAMT shard link missing name")
+		}
+		name := lnk.FieldName().Must().String()
+		if len(name) < padLen {
+			return fmt.Errorf("invalid HAMT shard link name '%s'", name)
+		}
+		if len(name) > padLen {
+			d.entries[name[padLen:]] = pbLinkEntry(lnk)
+			continue
+		}
+		childPB, childData, err := s.loadPB(lnk.FieldHash().Link())
+		if err != nil {
+			return err
+		}
+		if childData == nil || childData.FieldDataType().Int() != data.Data_HAMTShard {
+			return fmt.Errorf("HAMT shard child was not a shard")
+		}
+		if err := s.loadShard(d, childPB, childData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pbLinkEntry(lnk dagpb.PBLink) *entry {
+	var tsize uint64
+	if lnk.FieldTsize().Exists() {
+		tsize = uint64(lnk.FieldTsize().Must().Int())
+	}
+	return &entry{link: lnk.FieldHash().Link(), tsize: tsize}
+}
+
+func isDagPB(lnk ipld.Link) bool {
+	cl, ok := lnk.(cidlink.Link)
+	return ok && cl.Cid.Prefix().Codec == cid.DagProtobuf
+}
+
+// splitPath splits a slash separated path, relative to the root of the
+// Session, into its components.
+func splitPath(p string) []string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func isPrefix(prefix, parts []string) bool {
+	if len(prefix) > len(parts) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != parts[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,224 @@
+package mutable_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/iotest"
+
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/mutable"
+	"github.com/ipfs/go-unixfsnode/testutil"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/stretchr/testify/require"
+)
+
+func mkLinkSystem() ipld.LinkSystem {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+	ls.NodeReifier = unixfsnode.Reify
+	return ls
+}
+
+func protoChooser(lnk ipld.Link) ipld.NodePrototype {
+	np, err := dagpb.AddSupportToChooser(func(ipld.Link, ipld.LinkContext) (ipld.NodePrototype, error) {
+		return basicnode.Prototype.Any, nil
+	})(lnk, ipld.LinkContext{})
+	if err != nil {
+		panic(err)
+	}
+	return np
+}
+
+func readPath(t *testing.T, ls *ipld.LinkSystem, root ipld.Link, segments ...string) []byte {
+	nd, err := ls.Load(ipld.LinkContext{}, root, protoChooser(root))
+	require.NoError(t, err)
+	for _, seg := range segments {
+		nd, err = nd.LookupByString(seg)
+		require.NoError(t, err)
+		lnk, err := nd.AsLink()
+		require.NoError(t, err)
+		nd, err = ls.Load(ipld.LinkContext{}, lnk, protoChooser(lnk))
+		require.NoError(t, err)
+	}
+	if lbn, ok := nd.(interface{ AsLargeBytes() (io.ReadSeeker, error) }); ok {
+		rs, err := lbn.AsLargeBytes()
+		require.NoError(t, err)
+		byts, err := io.ReadAll(rs)
+		require.NoError(t, err)
+		return byts
+	}
+	byts, err := nd.AsBytes()
+	require.NoError(t, err)
+	return byts
+}
+
+func TestSessionBasicOperations(t *testing.T) {
+	ls := mkLinkSystem()
+	s, err := mutable.NewSession(context.Background(), &ls, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Mkdir("/a/b", true))
+	require.NoError(t, s.WriteFile("/a/b/hello.txt", bytes.NewBufferString("hello")))
+	require.NoError(t, s.WriteFile("/a/world.txt", bytes.NewBufferString("world")))
+	require.ErrorIs(t, s.Mkdir("/a", false), fs.ErrExist)
+	require.ErrorIs(t, s.WriteFile("/nope/file", bytes.NewBufferString("x")), fs.ErrNotExist)
+	require.ErrorIs(t, s.WriteFile("/a/b", bytes.NewBufferString("x")), mutable.ErrIsDirectory)
+
+	root, _, err := s.Flush()
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), readPath(t, &ls, root, "a", "b", "hello.txt"))
+	require.Equal(t, []byte("world"), readPath(t, &ls, root, "a", "world.txt"))
+
+	// move a file into an existing directory, then rename a directory
+	require.NoError(t, s.Mv("/a/world.txt", "/a/b"))
+	require.NoError(t, s.Mv("/a/b", "/c"))
+	require.ErrorIs(t, s.Mv("/c", "/c/d"), mutable.ErrInvalidPath)
+	require.ErrorIs(t, s.Rm("/c", false), mutable.ErrDirectoryNotEmpty)
+	require.NoError(t, s.Rm("/c/hello.txt", false))
+
+	root2, _, err := s.Flush()
+	require.NoError(t, err)
+	require.NotEqual(t, root, root2)
+	require.Equal(t, []byte("world"), readPath(t, &ls, root2, "c", "world.txt"))
+	nd, err := ls.Load(ipld.LinkContext{}, root2, protoChooser(root2))
+	require.NoError(t, err)
+	_, err = nd.LookupByString("a")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), nd.Length())
+
+	// a fresh session over the flushed root sees the same tree and flushes
+	// back to the same root without changes
+	s2, err := mutable.NewSession(context.Background(), &ls, root2)
+	require.NoError(t, err)
+	root3, size3, err := s2.Flush()
+	require.NoError(t, err)
+	require.Equal(t, root2, root3)
+	_, size2, err := s.Flush()
+	require.NoError(t, err)
+	require.Equal(t, size2, size3)
+
+	require.NoError(t, s2.Rm("/c", true))
+	require.NoError(t, s2.Rm("/a", false))
+	root4, _, err := s2.Flush()
+	require.NoError(t, err)
+	nd, err = ls.Load(ipld.LinkContext{}, root4, protoChooser(root4))
+	require.NoError(t, err)
+	require.Equal(t, int64(0), nd.Length())
+}
+
+func TestSessionShardedDirectory(t *testing.T) {
+	ls := mkLinkSystem()
+	rnd := random.NewSeededRand(1234)
+	dir := testutil.GenerateDirectory(t, &ls, rnd, 4<<20, true)
+
+	s, err := mutable.NewSession(context.Background(), &ls, cidlink.Link{Cid: dir.Root})
+	require.NoError(t, err)
+	var moved testutil.DirEntry
+	for _, child := range dir.Children {
+		if len(child.Children) == 0 {
+			moved = child
+			break
+		}
+	}
+	require.NotEmpty(t, moved.Path)
+
+	// failed operations don't cause anything to be rewritten
+	require.ErrorIs(t, s.Rm("/missing", false), fs.ErrNotExist)
+	require.ErrorIs(t, s.Mv("/missing", "/elsewhere"), fs.ErrNotExist)
+	require.ErrorIs(t, s.WriteFile("/missing/file", bytes.NewBufferString("x")), fs.ErrNotExist)
+	require.ErrorIs(t, s.Mkdir(moved.Path, false), fs.ErrExist)
+	for _, child := range dir.Children {
+		if len(child.Children) > 0 {
+			require.ErrorIs(t, s.Graft(child.Path, cidlink.Link{Cid: moved.Root}, 0), fs.ErrExist)
+			break
+		}
+	}
+	unchanged, _, err := s.Flush()
+	require.NoError(t, err)
+	require.Equal(t, cidlink.Link{Cid: dir.Root}, unchanged)
+
+	require.NoError(t, s.Mkdir("/new", false))
+	require.NoError(t, s.Mv(moved.Path, "/new/moved"))
+
+	root, _, err := s.Flush()
+	require.NoError(t, err)
+	require.Equal(t, moved.Content, readPath(t, &ls, root, "new", "moved"))
+
+	got := testutil.ToDirEntry(t, ls, root.(cidlink.Link).Cid, true)
+	require.Len(t, got.Children, len(dir.Children))
+	for _, child := range got.Children {
+		if child.Path == moved.Path {
+			t.Fatal("moved entry still present at its original path")
+		}
+	}
+}
+
+func TestSessionNotDirectory(t *testing.T) {
+	ls := mkLinkSystem()
+	s, err := mutable.NewSession(context.Background(), &ls, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.WriteFile("/file", bytes.NewBufferString("content")))
+	err = s.Mkdir("/file/sub", true)
+	require.True(t, errors.Is(err, mutable.ErrNotDirectory))
+	root, _, err := s.Flush()
+	require.NoError(t, err)
+
+	file, err := ls.Load(ipld.LinkContext{}, root, protoChooser(root))
+	require.NoError(t, err)
+	fileNd, err := file.LookupByString("file")
+	require.NoError(t, err)
+	fileLnk, err := fileNd.AsLink()
+	require.NoError(t, err)
+	_, err = mutable.NewSession(context.Background(), &ls, fileLnk)
+	require.ErrorIs(t, err, mutable.ErrNotDirectory)
+}
+
+func TestSessionWriteFileError(t *testing.T) {
+	ls := mkLinkSystem()
+	s, err := mutable.NewSession(context.Background(), &ls, nil)
+	require.NoError(t, err)
+	readErr := errors.New("read failed")
+	err = s.WriteFile("/broken", iotest.ErrReader(readErr))
+	var pathErr *fs.PathError
+	require.ErrorAs(t, err, &pathErr)
+	require.Equal(t, "write", pathErr.Op)
+	require.Equal(t, "/broken", pathErr.Path)
+	require.ErrorIs(t, err, readErr)
+}
+
+func TestSessionNamelessLink(t *testing.T) {
+	ls := mkLinkSystem()
+	leaf, err := ls.Store(ipld.LinkContext{}, builder.DefaultLeafLinkPrototype, basicnode.NewBytes([]byte("content")))
+	require.NoError(t, err)
+	ufsd, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.DataType(b, data.Data_Directory)
+	})
+	require.NoError(t, err)
+	dir, err := qp.BuildMap(dagpb.Type.PBNode, 2, func(ma ipld.MapAssembler) {
+		qp.MapEntry(ma, "Data", qp.Bytes(data.EncodeUnixFSData(ufsd)))
+		qp.MapEntry(ma, "Links", qp.List(1, func(la ipld.ListAssembler) {
+			qp.ListEntry(la, qp.Map(1, func(ma ipld.MapAssembler) {
+				qp.MapEntry(ma, "Hash", qp.Link(leaf))
+			}))
+		}))
+	})
+	require.NoError(t, err)
+	root, err := ls.Store(ipld.LinkContext{}, builder.DefaultInteriorLinkPrototype, dir)
+	require.NoError(t, err)
+
+	_, err = mutable.NewSession(context.Background(), &ls, root)
+	require.ErrorContains(t, err, "missing name")
+}
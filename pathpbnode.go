@@ -49,7 +49,7 @@ func (n PathedPBNode) LookupBySegment(seg ipld.PathSegment) (ipld.Node, error) {
 }
 
 func (n PathedPBNode) MapIterator() ipld.MapIterator {
-	return iter.NewUnixFSDirMapIterator(&_PathedPBNode__ListItr{n._substrate.Links.Iterator()}, nil)
+	return iter.NewUnixFSDirMapIterator(&_PathedPBNode__ListItr{n._substrate.Links.Iterator(), n._substrate.Links.Length()}, nil)
 }
 
 // ListIterator returns an iterator which yields key-value pairs
@@ -119,7 +119,7 @@ func (n PathedPBNode) Representation() ipld.Node {
 // Native map accessors
 
 func (n PathedPBNode) Iterator() *iter.UnixFSDir__Itr {
-	return iter.NewUnixFSDirIterator(&_PathedPBNode__ListItr{n._substrate.Links.Iterator()}, nil)
+	return iter.NewUnixFSDirIterator(&_PathedPBNode__ListItr{n._substrate.Links.Iterator(), n._substrate.Links.Length()}, nil)
 }
 
 func (n PathedPBNode) Lookup(key dagpb.String) dagpb.Link {
@@ -143,6 +143,7 @@ func (n PathedPBNode) Substrate() ipld.Node {
 
 type _PathedPBNode__ListItr struct {
 	_substrate *dagpb.PBLinks__Itr
+	length     int64
 }
 
 func (itr *_PathedPBNode__ListItr) Next() (int64, dagpb.PBLink, error) {
@@ -153,3 +154,7 @@ func (itr *_PathedPBNode__ListItr) Next() (int64, dagpb.PBLink, error) {
 func (itr *_PathedPBNode__ListItr) Done() bool {
 	return itr._substrate.Done()
 }
+
+func (itr *_PathedPBNode__ListItr) Count() (int64, bool) {
+	return itr.length, true
+}
@@ -0,0 +1,211 @@
+// Package piece prepares UnixFS DAGs for Filecoin storage deals, building
+// them so that their CAR serialization exactly fills a piece.
+package piece
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/dagwalk"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multihash"
+)
+
+// MinPaddedSize is the smallest padded piece size.
+const MinPaddedSize = 128
+
+// PaddingLinkPrototype is the prototype of padding blocks: CIDv1, raw,
+// sha2-256.
+var PaddingLinkPrototype = cidlink.LinkPrototype{Prefix: cid.Prefix{
+	Version:  1,
+	Codec:    cid.Raw,
+	MhType:   multihash.SHA2_256,
+	MhLength: -1,
+}}
+
+// Layout describes a DAG built to fill a piece, and the CAR it's written as.
+//
+// The CAR is a CARv1 with two roots, Root and Padding. It holds every block of
+// the DAG under Root once, followed by the padding block, so that it's exactly
+// UnpaddedSize bytes long. The padding block is a raw block of zeros which
+// nothing links to; naming it as the second root lets anything reading the CAR
+// recognise it and leave it out of the payload.
+type Layout struct {
+	// Root is the root of the DAG.
+	Root cid.Cid
+	// Padding is the padding block.
+	Padding cid.Cid
+	// PayloadSize is the size of the CAR without the padding block.
+	PayloadSize uint64
+	// UnpaddedSize is the size of the CAR, the capacity of the piece before
+	// fr32 padding.
+	UnpaddedSize uint64
+	// PaddedSize is the size of the piece, a power of two.
+	PaddedSize uint64
+}
+
+// BuildFile builds a UnixFS file from r like builder.BuildUnixFSFile, along
+// with a padding block that makes its CAR exactly fill the smallest piece that
+// will hold it. The DAG and the padding block are stored through ls, which
+// must be able to load them again for WriteCAR.
+//
+// The padding block makes the CAR a varint, a CID and its data longer, so a
+// CAR that would leave a gap too small for it, or of a size its varint can't
+// land on exactly, is placed in the next piece size up.
+func BuildFile(r io.Reader, chunker string, ls *ipld.LinkSystem, opts ...builder.FileOption) (Layout, error) {
+	rec := &recorder{sizes: make(map[cid.Cid]uint64)}
+	recLs := *ls
+	recLs.StorageWriteOpener = rec.writeOpener(ls.StorageWriteOpener)
+
+	root, _, err := builder.BuildUnixFSFile(r, chunker, &recLs, opts...)
+	if err != nil {
+		return Layout{}, err
+	}
+	rootCid := root.(cidlink.Link).Cid
+
+	placeholder, err := PaddingLinkPrototype.Sum(nil)
+	if err != nil {
+		return Layout{}, err
+	}
+	payload, err := headerSize([]cid.Cid{rootCid, placeholder})
+	if err != nil {
+		return Layout{}, err
+	}
+	for c, size := range rec.sizes {
+		payload += sectionSize(c.ByteLen(), size)
+	}
+
+	padded, unpadded := uint64(MinPaddedSize), unpaddedSize(MinPaddedSize)
+	for {
+		if unpadded >= payload {
+			if n, ok := paddingSize(unpadded-payload, placeholder.ByteLen()); ok {
+				pad, err := ls.Store(ipld.LinkContext{}, PaddingLinkPrototype, basicnode.NewBytes(make([]byte, n)))
+				if err != nil {
+					return Layout{}, err
+				}
+				return Layout{
+					Root:         rootCid,
+					Padding:      pad.(cidlink.Link).Cid,
+					PayloadSize:  payload,
+					UnpaddedSize: unpadded,
+					PaddedSize:   padded,
+				}, nil
+			}
+		}
+		if bits.LeadingZeros64(padded) == 0 {
+			return Layout{}, fmt.Errorf("payload of %d bytes is too large for any piece", payload)
+		}
+		padded <<= 1
+		unpadded = unpaddedSize(padded)
+	}
+}
+
+// WriteCAR writes the CAR described by l to w, loading its blocks through ls.
+func (l Layout) WriteCAR(ctx context.Context, w io.Writer, ls *ipld.LinkSystem) error {
+	car, err := storage.NewWritable(w, []cid.Cid{l.Root, l.Padding}, carv2.WriteAsCarV1(true))
+	if err != nil {
+		return err
+	}
+	lnkCtx := linking.LinkContext{Ctx: ctx}
+	put := func(c cid.Cid) error {
+		block, err := ls.LoadRaw(lnkCtx, cidlink.Link{Cid: c})
+		if err != nil {
+			return err
+		}
+		return car.Put(ctx, c.KeyString(), block)
+	}
+	err = dagwalk.Enumerate(ctx, ls, cidlink.Link{Cid: l.Root}, func(c cid.Cid, _ int) error {
+		return put(c)
+	})
+	if err != nil {
+		return err
+	}
+	if err := put(l.Padding); err != nil {
+		return err
+	}
+	return car.Finalize()
+}
+
+// unpaddedSize is the number of bytes a piece of the given padded size holds
+// before fr32 padding, which adds two bits to every 254.
+func unpaddedSize(padded uint64) uint64 {
+	return padded - padded/128
+}
+
+func headerSize(roots []cid.Cid) (uint64, error) {
+	var cw countingWriter
+	car, err := storage.NewWritable(&cw, roots, carv2.WriteAsCarV1(true))
+	if err != nil {
+		return 0, err
+	}
+	if err := car.Finalize(); err != nil {
+		return 0, err
+	}
+	return uint64(cw), nil
+}
+
+// sectionSize is the size of a block's section of a CAR: the varint length of
+// its CID and data, then the CID and data.
+func sectionSize(cidLen int, size uint64) uint64 {
+	n := uint64(cidLen) + size
+	return uint64(varintLen(n)) + n
+}
+
+func varintLen(n uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], n)
+}
+
+// paddingSize finds the size of padding block whose section exactly fills gap,
+// if there is one.
+func paddingSize(gap uint64, cidLen int) (uint64, bool) {
+	for v := 1; v <= binary.MaxVarintLen64; v++ {
+		if gap < uint64(cidLen+v) {
+			return 0, false
+		}
+		n := gap - uint64(cidLen+v)
+		if varintLen(uint64(cidLen)+n) == v {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+type countingWriter uint64
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	*cw += countingWriter(len(p))
+	return len(p), nil
+}
+
+// recorder records the size of each block stored through a LinkSystem.
+type recorder struct {
+	sizes map[cid.Cid]uint64
+}
+
+func (rec *recorder) writeOpener(next linking.BlockWriteOpener) linking.BlockWriteOpener {
+	return func(lc linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		w, commit, err := next(lc)
+		if err != nil {
+			return nil, nil, err
+		}
+		var cw countingWriter
+		return io.MultiWriter(w, &cw), func(lnk ipld.Link) error {
+			if err := commit(lnk); err != nil {
+				return err
+			}
+			rec.sizes[lnk.(cidlink.Link).Cid] = uint64(cw)
+			return nil
+		}, nil
+	}
+}
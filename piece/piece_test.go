@@ -0,0 +1,72 @@
+package piece
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/bits"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	carv2 "github.com/ipld/go-car/v2"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildFile(t *testing.T) {
+	ctx := context.Background()
+	for _, size := range []int{0, 100, 5000, 100000, 1 << 20, 3000000} {
+		ls := cidlink.DefaultLinkSystem()
+		store := cidlink.Memory{}
+		ls.StorageReadOpener = store.OpenRead
+		ls.StorageWriteOpener = store.OpenWrite
+
+		content := random.Bytes(size)
+		l, err := BuildFile(bytes.NewReader(content), "size-65536", &ls, builder.WithLeafLinkPrototype(builder.DefaultLeafLinkPrototype))
+		require.NoError(t, err)
+		require.Equal(t, 1, bits.OnesCount64(l.PaddedSize), size)
+		require.Equal(t, l.PaddedSize/128*127, l.UnpaddedSize, size)
+		require.Less(t, l.PayloadSize, l.UnpaddedSize, size)
+		// a smaller piece wouldn't have held the CAR and its padding block
+		if l.PaddedSize > MinPaddedSize {
+			require.Less(t, unpaddedSize(l.PaddedSize/2), l.PayloadSize+37, size)
+		}
+
+		var buf bytes.Buffer
+		require.NoError(t, l.WriteCAR(ctx, &buf, &ls))
+		require.Equal(t, l.UnpaddedSize, uint64(buf.Len()), size)
+
+		br, err := carv2.NewBlockReader(&buf)
+		require.NoError(t, err)
+		require.Equal(t, []cid.Cid{l.Root, l.Padding}, br.Roots)
+		var last []byte
+		var blocks int
+		for {
+			blk, err := br.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			last = blk.RawData()
+			blocks++
+		}
+		require.Equal(t, len(store.Bag), blocks, size)
+		require.Equal(t, make([]byte, len(last)), last, size)
+	}
+}
+
+func TestPaddingSize(t *testing.T) {
+	for gap := uint64(37); gap < 20000; gap++ {
+		n, ok := paddingSize(gap, 36)
+		if !ok {
+			// only a gap the varint can't land on has no padding block
+			require.Contains(t, []uint64{129, 16386}, gap)
+			continue
+		}
+		require.Equal(t, gap, sectionSize(36, n))
+	}
+	_, ok := paddingSize(36, 36)
+	require.False(t, ok)
+}
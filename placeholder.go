@@ -0,0 +1,34 @@
+package unixfsnode
+
+import (
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/schema"
+)
+
+var _ ipld.Node = UnknownTypeNode(nil)
+var _ schema.TypedNode = UnknownTypeNode(nil)
+var _ ipld.ADL = UnknownTypeNode(nil)
+
+// UnknownTypeNode is a placeholder for a UnixFS node of a type this package
+// doesn't know, such as one added by a newer implementation, returned by
+// reifiers configured with WithUnknownTypePlaceholders. Like a PathedPBNode, it
+// can be pathed through by link name, so traversals can carry on through it,
+// and it also gives the node's type and UnixFS data so that consumers can
+// decide what to do with it.
+type UnknownTypeNode = *_UnknownTypeNode
+
+type _UnknownTypeNode struct {
+	_PathedPBNode
+	data data.UnixFSData
+}
+
+// DataType returns the UnixFS type of the node.
+func (n UnknownTypeNode) DataType() int64 {
+	return n.data.FieldDataType().Int()
+}
+
+// UnixFSData returns the decoded UnixFS data of the node.
+func (n UnknownTypeNode) UnixFSData() data.UnixFSData {
+	return n.data
+}
@@ -15,43 +15,59 @@ import (
 // Reify looks at an ipld Node and tries to interpret it as a UnixFSNode
 // if successful, it returns the UnixFSNode
 func Reify(lnkCtx ipld.LinkContext, maybePBNodeRoot ipld.Node, lsys *ipld.LinkSystem) (ipld.Node, error) {
-	return doReify(lnkCtx, maybePBNodeRoot, lsys, true)
+	return doReify(lnkCtx, maybePBNodeRoot, lsys, true, &reifyOptions{})
 }
 
 // nonLazyReify works like reify but will load all of a directory or file as it reaches them.
 func nonLazyReify(lnkCtx ipld.LinkContext, maybePBNodeRoot ipld.Node, lsys *ipld.LinkSystem) (ipld.Node, error) {
-	return doReify(lnkCtx, maybePBNodeRoot, lsys, false)
+	return doReify(lnkCtx, maybePBNodeRoot, lsys, false, &reifyOptions{})
 }
 
-func doReify(lnkCtx ipld.LinkContext, maybePBNodeRoot ipld.Node, lsys *ipld.LinkSystem, lazy bool) (ipld.Node, error) {
+func doReify(lnkCtx ipld.LinkContext, maybePBNodeRoot ipld.Node, lsys *ipld.LinkSystem, lazy bool, o *reifyOptions) (ipld.Node, error) {
 	pbNode, ok := maybePBNodeRoot.(dagpb.PBNode)
 	if !ok {
 		return maybePBNodeRoot, nil
 	}
+	if links := pbNode.FieldLinks().Length(); o.maxLinks > 0 && links > o.maxLinks {
+		return nil, ErrTooManyLinks{Links: links, Max: o.maxLinks}
+	}
 	if !pbNode.FieldData().Exists() {
 		// no data field, therefore, not UnixFS
 		return defaultReifier(lnkCtx.Ctx, pbNode, lsys)
 	}
-	data, err := data.DecodeUnixFSData(pbNode.Data.Must().Bytes())
+	data, err := data.DecodeUnixFSDataZeroCopy(pbNode.Data.Must().Bytes())
 	if err != nil {
+		if o.mode == strictMode {
+			return nil, fmt.Errorf("decoding UnixFS data: %w", err)
+		}
 		// we could not decode the UnixFS data, therefore, not UnixFS
 		return defaultReifier(lnkCtx.Ctx, pbNode, lsys)
 	}
-	var builder reifyTypeFunc
-	if lazy {
-		builder, ok = lazyReifyFuncs[data.FieldDataType().Int()]
-	} else {
-		builder, ok = reifyFuncs[data.FieldDataType().Int()]
+	builder, ok := o.overrides[data.FieldDataType().Int()]
+	if !ok {
+		if lazy {
+			builder, ok = lazyReifyFuncs[data.FieldDataType().Int()]
+		} else {
+			builder, ok = reifyFuncs[data.FieldDataType().Int()]
+		}
 	}
 	if !ok {
+		if o.placeholders {
+			return &_UnknownTypeNode{_PathedPBNode{_substrate: pbNode}, data}, nil
+		}
+		if o.mode == tolerantMode {
+			return defaultReifier(lnkCtx.Ctx, pbNode, lsys)
+		}
 		return nil, fmt.Errorf("no reification for this UnixFS node type")
 	}
-	return builder(lnkCtx.Ctx, pbNode, data, lsys)
+	nd, err := builder(lnkCtx.Ctx, pbNode, data, lsys)
+	if err != nil && o.mode == tolerantMode {
+		return defaultReifier(lnkCtx.Ctx, pbNode, lsys)
+	}
+	return nd, err
 }
 
-type reifyTypeFunc func(context.Context, dagpb.PBNode, data.UnixFSData, *ipld.LinkSystem) (ipld.Node, error)
-
-var reifyFuncs = map[int64]reifyTypeFunc{
+var reifyFuncs = map[int64]TypeReifier{
 	data.Data_File:      unixFSFileReifierWithPreload,
 	data.Data_Metadata:  defaultUnixFSReifier,
 	data.Data_Raw:       unixFSFileReifier,
@@ -59,7 +75,7 @@ var reifyFuncs = map[int64]reifyTypeFunc{
 	data.Data_Directory: directory.NewUnixFSBasicDir,
 	data.Data_HAMTShard: hamt.NewUnixFSHAMTShardWithPreload,
 }
-var lazyReifyFuncs = map[int64]reifyTypeFunc{
+var lazyReifyFuncs = map[int64]TypeReifier{
 	data.Data_File:      unixFSFileReifier,
 	data.Data_Metadata:  defaultUnixFSReifier,
 	data.Data_Raw:       unixFSFileReifier,
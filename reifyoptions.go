@@ -0,0 +1,154 @@
+package unixfsnode
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+)
+
+// TypeReifier builds the node for a dag-pb node of one UnixFS type, given its
+// decoded UnixFS data. directory.NewUnixFSBasicDir and hamt.NewUnixFSHAMTShard
+// are TypeReifiers.
+type TypeReifier func(ctx context.Context, substrate dagpb.PBNode, ufsData data.UnixFSData, lsys *ipld.LinkSystem) (ipld.Node, error)
+
+type reifyMode int
+
+const (
+	defaultMode reifyMode = iota
+	strictMode
+	tolerantMode
+)
+
+type reifyOptions struct {
+	mode         reifyMode
+	placeholders bool
+	names        map[string]bool
+	maxLinks     int64
+	overrides    map[int64]TypeReifier
+}
+
+// ReifyOption configures the reifiers returned by KnownReifiersWithOptions.
+type ReifyOption func(*reifyOptions)
+
+// WithStrictReification makes reification fail for dag-pb nodes whose Data
+// can't be decoded as UnixFS, rather than treating them as plain dag-pb
+// nodes.
+func WithStrictReification() ReifyOption {
+	return func(o *reifyOptions) {
+		o.mode = strictMode
+	}
+}
+
+// WithTolerantReification makes reification treat UnixFS nodes that can't be
+// reified, such as shards with invalid headers or nodes of unknown types, as
+// plain dag-pb nodes that can be pathed through by link name, rather than
+// failing.
+func WithTolerantReification() ReifyOption {
+	return func(o *reifyOptions) {
+		o.mode = tolerantMode
+	}
+}
+
+// WithUnknownTypePlaceholders makes reification return an UnknownTypeNode
+// for UnixFS nodes of a type this package doesn't know, rather than failing,
+// so that DAGs made by newer implementations can still be traversed. This
+// takes precedence over WithTolerantReification for unknown types.
+func WithUnknownTypePlaceholders() ReifyOption {
+	return func(o *reifyOptions) {
+		o.placeholders = true
+	}
+}
+
+// WithReifierNames registers only the named reifiers, out of "unixfs" and
+// "unixfs-preload". Other names are ignored.
+func WithReifierNames(names ...string) ReifyOption {
+	return func(o *reifyOptions) {
+		o.names = make(map[string]bool, len(names))
+		for _, name := range names {
+			o.names[name] = true
+		}
+	}
+}
+
+// WithMaxLinks makes reification fail with ErrTooManyLinks for dag-pb nodes
+// with more than max links, bounding the work done for a single block of an
+// untrusted DAG. 0, the default, means no limit.
+func WithMaxLinks(max int64) ReifyOption {
+	return func(o *reifyOptions) {
+		o.maxLinks = max
+	}
+}
+
+// WithTypeReifier uses r to reify nodes of the given UnixFS type, in place of
+// the reifier this package has for it, both lazily and with preloading.
+func WithTypeReifier(dataType int64, r TypeReifier) ReifyOption {
+	return func(o *reifyOptions) {
+		if o.overrides == nil {
+			o.overrides = make(map[int64]TypeReifier)
+		}
+		o.overrides[dataType] = r
+	}
+}
+
+// WithStructureOnly leaves files as plain dag-pb nodes rather than reifying
+// them as bytes, so that traversals interested only in the shape of a DAG can
+// still see, count and follow the links inside multi-block files. Directories
+// and shards are reified as usual.
+func WithStructureOnly() ReifyOption {
+	return func(o *reifyOptions) {
+		WithTypeReifier(data.Data_File, defaultUnixFSReifier)(o)
+		WithTypeReifier(data.Data_Raw, defaultUnixFSReifier)(o)
+	}
+}
+
+// ErrTooManyLinks is returned by reifiers configured with WithMaxLinks for
+// nodes with more links than allowed.
+type ErrTooManyLinks struct {
+	Links int64
+	Max   int64
+}
+
+func (e ErrTooManyLinks) Error() string {
+	return fmt.Sprintf("node has %d links, more than the %d allowed", e.Links, e.Max)
+}
+
+// KnownReifiersWithOptions returns the reifiers provided by this package, as
+// KnownReifiers does, configured by opts.
+func KnownReifiersWithOptions(opts ...ReifyOption) map[string]linking.NodeReifier {
+	o := &reifyOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	reifiers := map[string]linking.NodeReifier{
+		"unixfs": func(lnkCtx ipld.LinkContext, n ipld.Node, lsys *ipld.LinkSystem) (ipld.Node, error) {
+			return doReify(lnkCtx, n, lsys, true, o)
+		},
+		"unixfs-preload": func(lnkCtx ipld.LinkContext, n ipld.Node, lsys *ipld.LinkSystem) (ipld.Node, error) {
+			return doReify(lnkCtx, n, lsys, false, o)
+		},
+	}
+	if o.names != nil {
+		for name := range reifiers {
+			if !o.names[name] {
+				delete(reifiers, name)
+			}
+		}
+	}
+	return reifiers
+}
+
+// AddUnixFSReificationToLinkSystemWithOptions adds the reifiers returned by
+// KnownReifiersWithOptions to a LinkSystem, so that reification can be
+// tailored to each LinkSystem.
+func AddUnixFSReificationToLinkSystemWithOptions(lsys *ipld.LinkSystem, opts ...ReifyOption) {
+	if lsys.KnownReifiers == nil {
+		lsys.KnownReifiers = make(map[string]linking.NodeReifier)
+	}
+	for name, reifier := range KnownReifiersWithOptions(opts...) {
+		lsys.KnownReifiers[name] = reifier
+	}
+}
@@ -0,0 +1,175 @@
+package unixfsnode_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/directory"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestReifyOptions(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	f, size, err := builder.BuildUnixFSFile(strings.NewReader("hello"), "", &ls)
+	require.NoError(t, err)
+	entry, err := builder.BuildUnixFSDirectoryEntry("hello", int64(size), f)
+	require.NoError(t, err)
+	dir, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{entry, entry, entry}, &ls)
+	require.NoError(t, err)
+	dirNode, err := ls.Load(ipld.LinkContext{}, dir, dagpb.Type.PBNode)
+	require.NoError(t, err)
+
+	pbNode := func(dataBytes []byte) dagpb.PBNode {
+		nd, err := qp.BuildMap(dagpb.Type.PBNode, 2, func(ma ipld.MapAssembler) {
+			qp.MapEntry(ma, "Links", qp.List(1, func(la ipld.ListAssembler) {
+				qp.ListEntry(la, qp.Node(entry))
+			}))
+			qp.MapEntry(ma, "Data", qp.Bytes(dataBytes))
+		})
+		require.NoError(t, err)
+		return nd.(dagpb.PBNode)
+	}
+	// not UnixFS data at all
+	garbage := pbNode([]byte{0xff, 0xff, 0xff})
+	// a shard with a fanout that isn't a power of two
+	badShard, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.DataType(b, data.Data_HAMTShard)
+		builder.HashType(b, 0x22)
+		builder.Fanout(b, 100)
+		builder.Data(b, []byte{0x01})
+	})
+	require.NoError(t, err)
+	invalid := pbNode(data.EncodeUnixFSData(badShard))
+
+	reify := func(name string, n ipld.Node, opts ...unixfsnode.ReifyOption) (ipld.Node, error) {
+		reifier, ok := unixfsnode.KnownReifiersWithOptions(opts...)[name]
+		require.True(t, ok)
+		return reifier(ipld.LinkContext{Ctx: context.Background()}, n, &ls)
+	}
+
+	t.Run("names", func(t *testing.T) {
+		reifiers := unixfsnode.KnownReifiersWithOptions(unixfsnode.WithReifierNames("unixfs"))
+		require.Len(t, reifiers, 1)
+		require.Contains(t, reifiers, "unixfs")
+
+		lsys := cidlink.DefaultLinkSystem()
+		unixfsnode.AddUnixFSReificationToLinkSystemWithOptions(&lsys, unixfsnode.WithReifierNames("unixfs-preload", "other"))
+		require.Len(t, lsys.KnownReifiers, 1)
+		require.Contains(t, lsys.KnownReifiers, "unixfs-preload")
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		nd, err := reify("unixfs", garbage)
+		require.NoError(t, err)
+		require.IsType(t, unixfsnode.PathedPBNode(nil), nd)
+		_, err = reify("unixfs", garbage, unixfsnode.WithStrictReification())
+		require.Error(t, err)
+	})
+
+	t.Run("tolerant", func(t *testing.T) {
+		for _, name := range []string{"unixfs", "unixfs-preload"} {
+			_, err := reify(name, invalid)
+			require.Error(t, err)
+			nd, err := reify(name, invalid, unixfsnode.WithTolerantReification())
+			require.NoError(t, err)
+			require.IsType(t, unixfsnode.PathedPBNode(nil), nd)
+			lnk, err := nd.LookupByString("hello")
+			require.NoError(t, err)
+			require.NotNil(t, lnk)
+		}
+	})
+
+	t.Run("placeholders", func(t *testing.T) {
+		// the builder won't make a node of a type it doesn't know
+		future := protowire.AppendTag(nil, data.Data_DataTypeWireNum, protowire.VarintType)
+		future = protowire.AppendVarint(future, 42)
+		future = protowire.AppendTag(future, data.Data_DataWireNum, protowire.BytesType)
+		future = protowire.AppendBytes(future, []byte("from the future"))
+		unknown := pbNode(future)
+		for _, name := range []string{"unixfs", "unixfs-preload"} {
+			_, err := reify(name, unknown)
+			require.Error(t, err)
+			for _, opts := range [][]unixfsnode.ReifyOption{
+				{unixfsnode.WithUnknownTypePlaceholders()},
+				{unixfsnode.WithUnknownTypePlaceholders(), unixfsnode.WithTolerantReification()},
+			} {
+				nd, err := reify(name, unknown, opts...)
+				require.NoError(t, err)
+				placeholder, ok := nd.(unixfsnode.UnknownTypeNode)
+				require.True(t, ok)
+				require.Equal(t, int64(42), placeholder.DataType())
+				require.Equal(t, []byte("from the future"), placeholder.UnixFSData().FieldData().Must().Bytes())
+				require.Equal(t, unknown, placeholder.Substrate())
+				lnk, err := nd.LookupByString("hello")
+				require.NoError(t, err)
+				require.NotNil(t, lnk)
+			}
+			// known types are reified as usual
+			nd, err := reify(name, dirNode, unixfsnode.WithUnknownTypePlaceholders())
+			require.NoError(t, err)
+			require.IsType(t, directory.UnixFSBasicDir(nil), nd)
+		}
+	})
+
+	t.Run("max links", func(t *testing.T) {
+		_, err := reify("unixfs", dirNode, unixfsnode.WithMaxLinks(3))
+		require.NoError(t, err)
+		_, err = reify("unixfs", dirNode, unixfsnode.WithMaxLinks(2))
+		require.ErrorIs(t, err, unixfsnode.ErrTooManyLinks{Links: 3, Max: 2})
+	})
+
+	t.Run("type reifier", func(t *testing.T) {
+		nd, err := reify("unixfs", dirNode)
+		require.NoError(t, err)
+		require.IsType(t, directory.UnixFSBasicDir(nil), nd)
+
+		var called bool
+		custom := func(ctx context.Context, substrate dagpb.PBNode, ufsData data.UnixFSData, lsys *ipld.LinkSystem) (ipld.Node, error) {
+			called = true
+			return substrate, nil
+		}
+		nd, err = reify("unixfs-preload", dirNode, unixfsnode.WithTypeReifier(data.Data_Directory, custom))
+		require.NoError(t, err)
+		require.True(t, called)
+		require.Equal(t, dirNode, nd)
+	})
+}
+
+func TestReifyStructureOnly(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	f, _, err := builder.BuildUnixFSFile(strings.NewReader(strings.Repeat("hello", 1000)), "size-1000", &ls)
+	require.NoError(t, err)
+	fileNode, err := ls.Load(ipld.LinkContext{}, f, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	require.EqualValues(t, 5, fileNode.(dagpb.PBNode).FieldLinks().Length())
+
+	for _, name := range []string{"unixfs", "unixfs-preload"} {
+		reifier := unixfsnode.KnownReifiersWithOptions()[name]
+		nd, err := reifier(ipld.LinkContext{Ctx: context.Background()}, fileNode, &ls)
+		require.NoError(t, err)
+		require.Equal(t, ipld.Kind_Bytes, nd.Kind())
+
+		reifier = unixfsnode.KnownReifiersWithOptions(unixfsnode.WithStructureOnly())[name]
+		nd, err = reifier(ipld.LinkContext{Ctx: context.Background()}, fileNode, &ls)
+		require.NoError(t, err)
+		require.IsType(t, unixfsnode.PathedPBNode(nil), nd)
+		require.EqualValues(t, 5, nd.(unixfsnode.PathedPBNode).FieldLinks().Length())
+	}
+}
@@ -3,9 +3,12 @@ package unixfsnode
 import (
 	"io"
 
+	dagpb "github.com/ipld/go-codec-dagpb"
 	"github.com/ipld/go-ipld-prime"
+	_ "github.com/ipld/go-ipld-prime/codec/raw"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/ipld/go-ipld-prime/traversal"
 	"github.com/ipld/go-ipld-prime/traversal/selector"
@@ -80,15 +83,57 @@ func BytesConsumingMatcher(p traversal.Progress, n datamodel.Node) error {
 	return nil
 }
 
-// AddUnixFSReificationToLinkSystem will add both unixfs and unixfs-preload
-// reifiers to a LinkSystem. This is primarily useful for traversals that use
-// an interpretAs clause, such as Match* selectors in this package.
+// KnownReifiers returns the reifiers provided by this package, keyed by the
+// name an interpretAs selector clause uses to select them: "unixfs" and
+// "unixfs-preload". The returned map is a copy and may be modified. Use
+// KnownReifiersWithOptions to configure the reifiers.
+func KnownReifiers() map[string]linking.NodeReifier {
+	return map[string]linking.NodeReifier{
+		"unixfs":         Reify,
+		"unixfs-preload": nonLazyReify,
+	}
+}
+
+// AddUnixFSReificationToLinkSystem will add all of the KnownReifiers to a
+// LinkSystem. This is primarily useful for traversals that use an interpretAs
+// clause, such as Match* selectors in this package. Use
+// AddUnixFSReificationToLinkSystemWithOptions to configure the reifiers.
 func AddUnixFSReificationToLinkSystem(lsys *ipld.LinkSystem) {
 	if lsys.KnownReifiers == nil {
 		lsys.KnownReifiers = make(map[string]linking.NodeReifier)
 	}
-	lsys.KnownReifiers["unixfs"] = Reify
-	lsys.KnownReifiers["unixfs-preload"] = nonLazyReify
+	for name, reifier := range KnownReifiers() {
+		lsys.KnownReifiers[name] = reifier
+	}
+}
+
+// AddUnixFSReificationToTraversalConfig prepares a traversal.Config for
+// traversals of UnixFS data with the selectors in this package. It adds the
+// KnownReifiers to the config's LinkSystem, fills in any of its encoder,
+// decoder and hasher choosers that are unset with those of
+// cidlink.DefaultLinkSystem, and sets a LinkTargetNodePrototypeChooser that
+// loads dag-pb blocks as dagpb.PBNode, if the config has none. Only a
+// StorageReadOpener then needs to be provided.
+//
+// go-ipld-prime has no global registry for reifiers; the dag-pb and raw codecs
+// are registered in its multicodec registry when this package is imported.
+func AddUnixFSReificationToTraversalConfig(cfg *traversal.Config) {
+	defaults := cidlink.DefaultLinkSystem()
+	if cfg.LinkSystem.EncoderChooser == nil {
+		cfg.LinkSystem.EncoderChooser = defaults.EncoderChooser
+	}
+	if cfg.LinkSystem.DecoderChooser == nil {
+		cfg.LinkSystem.DecoderChooser = defaults.DecoderChooser
+	}
+	if cfg.LinkSystem.HasherChooser == nil {
+		cfg.LinkSystem.HasherChooser = defaults.HasherChooser
+	}
+	AddUnixFSReificationToLinkSystem(&cfg.LinkSystem)
+	if cfg.LinkTargetNodePrototypeChooser == nil {
+		cfg.LinkTargetNodePrototypeChooser = dagpb.AddSupportToChooser(func(ipld.Link, linking.LinkContext) (ipld.NodePrototype, error) {
+			return basicnode.Prototype.Any, nil
+		})
+	}
 }
 
 // UnixFSPathSelector creates a selector for IPLD path to a UnixFS resource if
@@ -0,0 +1,120 @@
+package unixfsnode
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// Stat describes a UnixFS node as a map, giving tools one way to inspect
+// files, directories, HAMT shards and symlinks. The map has the entries:
+//
+//   - "Type": the UnixFS type name, as found in data.DataTypeNames
+//   - "Size": the size of the content for files and symlinks, or the total
+//     size of the DAG for directories and shards
+//   - "BlockSizes": the number of block sizes, i.e. children, of a file
+//   - "Mode": the permission bits, the default for the type if none are set
+//   - "Mtime": a map of "Seconds" and, if set, "FractionalNanoseconds"; only
+//     present if the node has a modification time
+//   - "Fanout": the fanout of a HAMT shard; only present for shards
+//
+// n may be a dag-pb node or a node reified from one, such as by Reify. Nodes
+// that are plain bytes, such as raw leaves, are described as "Raw" with the
+// size of their bytes.
+func Stat(n ipld.Node) (ipld.Node, error) {
+	n = Substrate(n)
+	pbn, ok := n.(dagpb.PBNode)
+	if !ok {
+		if n.Kind() != ipld.Kind_Bytes {
+			return nil, fmt.Errorf("cannot stat a node of kind %s", n.Kind())
+		}
+		byts, err := n.AsBytes()
+		if err != nil {
+			return nil, err
+		}
+		return qp.BuildMap(basicnode.Prototype.Map, 3, func(ma ipld.MapAssembler) {
+			qp.MapEntry(ma, "Type", qp.String(data.DataTypeNames[data.Data_Raw]))
+			qp.MapEntry(ma, "Size", qp.Int(int64(len(byts))))
+			qp.MapEntry(ma, "BlockSizes", qp.Int(0))
+		})
+	}
+	if !pbn.FieldData().Exists() {
+		return nil, fmt.Errorf("cannot stat a dag-pb node without UnixFS data")
+	}
+	ufsData, err := data.DecodeUnixFSData(pbn.FieldData().Must().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	dataType := ufsData.FieldDataType().Int()
+	typeName, ok := data.DataTypeNames[dataType]
+	if !ok {
+		return nil, data.ErrInvalidDataType{DataType: dataType}
+	}
+
+	var size int64
+	switch dataType {
+	case data.Data_File, data.Data_Raw:
+		if ufsData.FieldFileSize().Exists() {
+			size = ufsData.FieldFileSize().Must().Int()
+		} else if ufsData.FieldData().Exists() {
+			size = int64(len(ufsData.FieldData().Must().Bytes()))
+		}
+	case data.Data_Symlink:
+		if ufsData.FieldData().Exists() {
+			size = int64(len(ufsData.FieldData().Must().Bytes()))
+		}
+	default:
+		size, err = dagSize(pbn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entries := int64(4)
+	if ufsData.FieldMtime().Exists() {
+		entries++
+	}
+	if dataType == data.Data_HAMTShard && ufsData.FieldFanout().Exists() {
+		entries++
+	}
+	return qp.BuildMap(basicnode.Prototype.Map, entries, func(ma ipld.MapAssembler) {
+		qp.MapEntry(ma, "Type", qp.String(typeName))
+		qp.MapEntry(ma, "Size", qp.Int(size))
+		qp.MapEntry(ma, "BlockSizes", qp.Int(ufsData.FieldBlockSizes().Length()))
+		qp.MapEntry(ma, "Mode", qp.Int(int64(ufsData.Permissions())))
+		if ufsData.FieldMtime().Exists() {
+			mtime := ufsData.FieldMtime().Must()
+			qp.MapEntry(ma, "Mtime", qp.Map(-1, func(ma ipld.MapAssembler) {
+				qp.MapEntry(ma, "Seconds", qp.Int(mtime.FieldSeconds().Int()))
+				if mtime.FieldFractionalNanoseconds().Exists() {
+					qp.MapEntry(ma, "FractionalNanoseconds", qp.Int(mtime.FieldFractionalNanoseconds().Must().Int()))
+				}
+			}))
+		}
+		if dataType == data.Data_HAMTShard && ufsData.FieldFanout().Exists() {
+			qp.MapEntry(ma, "Fanout", qp.Int(ufsData.FieldFanout().Must().Int()))
+		}
+	})
+}
+
+// dagSize returns the size of the encoded node plus the sizes its links give
+// for their targets.
+func dagSize(pbn dagpb.PBNode) (int64, error) {
+	byts, err := ipld.Encode(pbn, dagpb.Encode)
+	if err != nil {
+		return 0, err
+	}
+	size := int64(len(byts))
+	links := pbn.FieldLinks().Iterator()
+	for !links.Done() {
+		_, lnk := links.Next()
+		if lnk.FieldTsize().Exists() {
+			size += lnk.FieldTsize().Must().Int()
+		}
+	}
+	return size, nil
+}
@@ -0,0 +1,100 @@
+package unixfsnode_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStat(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	stat := func(lnk ipld.Link) map[string]interface{} {
+		nd, err := ls.Load(ipld.LinkContext{}, lnk, dagpb.Type.PBNode)
+		require.NoError(t, err)
+		reified, err := unixfsnode.Reify(ipld.LinkContext{}, nd, &ls)
+		require.NoError(t, err)
+		st, err := unixfsnode.Stat(reified)
+		require.NoError(t, err)
+		out := make(map[string]interface{})
+		mi := st.MapIterator()
+		for !mi.Done() {
+			k, v, err := mi.Next()
+			require.NoError(t, err)
+			ks, err := k.AsString()
+			require.NoError(t, err)
+			if v.Kind() == ipld.Kind_String {
+				out[ks], err = v.AsString()
+			} else if v.Kind() == ipld.Kind_Int {
+				out[ks], err = v.AsInt()
+			} else {
+				secs, serr := v.LookupByString("Seconds")
+				require.NoError(t, serr)
+				out[ks], err = secs.AsInt()
+			}
+			require.NoError(t, err)
+		}
+		return out
+	}
+
+	mtime := time.Unix(1700000000, 0)
+	content := random.Bytes(1 << 20)
+	f, size, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-262144", &ls, builder.WithMtime(mtime))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"Type":       "File",
+		"Size":       int64(len(content)),
+		"BlockSizes": int64(4),
+		"Mode":       int64(0o644),
+		"Mtime":      mtime.Unix(),
+	}, stat(f))
+
+	entry, err := builder.BuildUnixFSDirectoryEntry("file", int64(size), f)
+	require.NoError(t, err)
+	dir, dirSize, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{entry}, &ls)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"Type":       "Directory",
+		"Size":       int64(dirSize),
+		"BlockSizes": int64(0),
+		"Mode":       int64(0o755),
+	}, stat(dir))
+
+	var entries []dagpb.PBLink
+	for i := 0; i < 100; i++ {
+		e, err := builder.BuildUnixFSDirectoryEntry(fmt.Sprintf("file%d", i), int64(size), f)
+		require.NoError(t, err)
+		entries = append(entries, e)
+	}
+	shard, shardSize, err := builder.BuildUnixFSShardedDirectory(16, multihash.MURMUR3X64_64, entries, &ls)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"Type":       "HAMTShard",
+		"Size":       int64(shardSize),
+		"BlockSizes": int64(0),
+		"Mode":       int64(0o755),
+		"Fanout":     int64(16),
+	}, stat(shard))
+
+	sym, _, err := builder.BuildUnixFSSymlink("../target", &ls)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"Type":       "Symlink",
+		"Size":       int64(len("../target")),
+		"BlockSizes": int64(0),
+		"Mode":       int64(0),
+	}, stat(sym))
+}
@@ -0,0 +1,160 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// Dedup describes how blocks are shared between a number of DAGs.
+type Dedup struct {
+	// Roots describes each DAG, in the order the roots were given
+	Roots []RootDedup `json:"roots"`
+	// TotalBlocks is the number of distinct blocks across all of the DAGs
+	TotalBlocks int `json:"totalBlocks"`
+	// TotalBytes is the total size of the distinct blocks across all of the
+	// DAGs, i.e. the space needed to store all of them together
+	TotalBytes uint64 `json:"totalBytes"`
+	// SharedBlocks is the number of distinct blocks that appear in more than
+	// one of the DAGs
+	SharedBlocks int `json:"sharedBlocks"`
+	// SharedBytes is the total size of the blocks counted in SharedBlocks
+	SharedBytes uint64 `json:"sharedBytes"`
+}
+
+// RootDedup describes the blocks of one DAG compared to the others.
+type RootDedup struct {
+	Root cid.Cid `json:"root"`
+	// Blocks is the number of distinct blocks in this DAG
+	Blocks int `json:"blocks"`
+	// Bytes is the total size of the distinct blocks in this DAG
+	Bytes uint64 `json:"bytes"`
+	// UniqueBlocks is the number of blocks in this DAG and no other
+	UniqueBlocks int `json:"uniqueBlocks"`
+	// UniqueBytes is the total size of the blocks counted in UniqueBlocks,
+	// i.e. the space needed to store this DAG next to the others
+	UniqueBytes uint64 `json:"uniqueBytes"`
+}
+
+// blockRefs records, for a block, which of the DAGs being compared reach it
+type blockRefs struct {
+	size  int
+	links []cid.Cid
+	// the number of DAGs that reach this block, and the last to do so
+	roots int
+	last  int
+}
+
+// CompareDedup walks the DAGs under each of roots and reports how many blocks
+// and bytes they share. Every block is loaded at most once, however many of
+// the DAGs reach it. All blocks must be available from the LinkSystem.
+//
+// Only dag-pb links are followed; blocks of any other codec, including raw
+// leaves, are treated as leaves.
+func CompareDedup(ctx context.Context, lsys *ipld.LinkSystem, roots ...ipld.Link) (*Dedup, error) {
+	refs := make(map[cid.Cid]*blockRefs)
+	lnkCtx := linking.LinkContext{Ctx: ctx}
+	for i, root := range roots {
+		cl, ok := root.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("unsupported link type: %T", root)
+		}
+		if err := markReachable(ctx, lnkCtx, lsys, refs, cl.Cid, i); err != nil {
+			return nil, err
+		}
+	}
+
+	dd := &Dedup{Roots: make([]RootDedup, len(roots))}
+	for i, root := range roots {
+		dd.Roots[i].Root = root.(cidlink.Link).Cid
+	}
+	// a second pass over the DAGs gives each root its own counts
+	for i := range roots {
+		counted := make(map[cid.Cid]struct{})
+		stack := []cid.Cid{dd.Roots[i].Root}
+		for len(stack) > 0 {
+			c := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if _, ok := counted[c]; ok {
+				continue
+			}
+			counted[c] = struct{}{}
+			br := refs[c]
+			dd.Roots[i].Blocks++
+			dd.Roots[i].Bytes += uint64(br.size)
+			if br.roots == 1 {
+				dd.Roots[i].UniqueBlocks++
+				dd.Roots[i].UniqueBytes += uint64(br.size)
+			}
+			stack = append(stack, br.links...)
+		}
+	}
+	for _, br := range refs {
+		dd.TotalBlocks++
+		dd.TotalBytes += uint64(br.size)
+		if br.roots > 1 {
+			dd.SharedBlocks++
+			dd.SharedBytes += uint64(br.size)
+		}
+	}
+	return dd, nil
+}
+
+func markReachable(ctx context.Context, lnkCtx linking.LinkContext, lsys *ipld.LinkSystem, refs map[cid.Cid]*blockRefs, root cid.Cid, idx int) error {
+	stack := []cid.Cid{root}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		br, ok := refs[c]
+		if ok && br.roots > 0 && br.last == idx {
+			continue
+		}
+		if !ok {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			raw, err := lsys.LoadRaw(lnkCtx, cidlink.Link{Cid: c})
+			if err != nil {
+				return err
+			}
+			links, err := blockLinks(c, raw)
+			if err != nil {
+				return err
+			}
+			br = &blockRefs{size: len(raw), links: links}
+			refs[c] = br
+		}
+		br.roots++
+		br.last = idx
+		stack = append(stack, br.links...)
+	}
+	return nil
+}
+
+// blockLinks returns the CIDs a block links to, if it is dag-pb.
+func blockLinks(c cid.Cid, raw []byte) ([]cid.Cid, error) {
+	if c.Prefix().Codec != cid.DagProtobuf {
+		return nil, nil
+	}
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagpb.DecodeBytes(nb, raw); err != nil {
+		return nil, fmt.Errorf("block %s: %w", c, err)
+	}
+	pbnd := nb.Build().(dagpb.PBNode)
+	links := make([]cid.Cid, 0, pbnd.FieldLinks().Length())
+	itr := pbnd.FieldLinks().Iterator()
+	for !itr.Done() {
+		_, lnk := itr.Next()
+		cl, ok := lnk.FieldHash().Link().(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("unsupported link type: %T", lnk.FieldHash().Link())
+		}
+		links = append(links, cl.Cid)
+	}
+	return links, nil
+}
@@ -0,0 +1,149 @@
+// Package stats summarises the shape and size of UnixFS DAGs.
+package stats
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// RawLeaf is the NodeTypes key used to count raw codec blocks; dag-pb blocks
+// are counted under their UnixFS type name, as found in data.DataTypeNames.
+const RawLeaf = "RawLeaf"
+
+// Stats describes a complete UnixFS DAG.
+type Stats struct {
+	// Blocks is the number of blocks in the DAG, counting a block once for
+	// every link that reaches it
+	Blocks int `json:"blocks"`
+	// UniqueBlocks is the number of distinct blocks in the DAG
+	UniqueBlocks int `json:"uniqueBlocks"`
+	// StoredBytes is the total size of the distinct blocks in the DAG, i.e. the
+	// space needed to store it
+	StoredBytes uint64 `json:"storedBytes"`
+	// LogicalBytes is the total size of file content in the DAG, counting a
+	// file once for every path at which it appears
+	LogicalBytes uint64 `json:"logicalBytes"`
+	// Depth is the number of links on the longest path from the root to a
+	// leaf; a DAG of a single block has a depth of 0
+	Depth int `json:"depth"`
+	// LargestBlock is the size of the largest block in the DAG
+	LargestBlock int `json:"largestBlock"`
+	// NodeTypes counts distinct blocks by UnixFS type name, with raw codec
+	// blocks counted as RawLeaf
+	NodeTypes map[string]int `json:"nodeTypes"`
+}
+
+// subtree caches what has been learned about the DAG under a block so that
+// repeated references to it need not be walked again
+type subtree struct {
+	blocks       int
+	logicalBytes uint64
+	depth        int
+}
+
+type walker struct {
+	ctx    context.Context
+	lsys   *ipld.LinkSystem
+	stats  *Stats
+	seen   map[cid.Cid]subtree
+	lnkCtx linking.LinkContext
+}
+
+// Collect walks every block of the DAG under root and returns Stats for it.
+// All blocks must be available from the LinkSystem.
+func Collect(ctx context.Context, lsys *ipld.LinkSystem, root ipld.Link) (*Stats, error) {
+	cl, ok := root.(cidlink.Link)
+	if !ok {
+		return nil, fmt.Errorf("unsupported link type: %T", root)
+	}
+	w := &walker{
+		ctx:    ctx,
+		lsys:   lsys,
+		stats:  &Stats{NodeTypes: make(map[string]int)},
+		seen:   make(map[cid.Cid]subtree),
+		lnkCtx: linking.LinkContext{Ctx: ctx},
+	}
+	st, err := w.walk(cl.Cid)
+	if err != nil {
+		return nil, err
+	}
+	w.stats.Blocks = st.blocks
+	w.stats.LogicalBytes = st.logicalBytes
+	w.stats.Depth = st.depth
+	return w.stats, nil
+}
+
+func (w *walker) walk(c cid.Cid) (subtree, error) {
+	if st, ok := w.seen[c]; ok {
+		return st, nil
+	}
+	if err := w.ctx.Err(); err != nil {
+		return subtree{}, err
+	}
+	raw, err := w.lsys.LoadRaw(w.lnkCtx, cidlink.Link{Cid: c})
+	if err != nil {
+		return subtree{}, err
+	}
+	w.stats.UniqueBlocks++
+	w.stats.StoredBytes += uint64(len(raw))
+	if len(raw) > w.stats.LargestBlock {
+		w.stats.LargestBlock = len(raw)
+	}
+
+	st := subtree{blocks: 1}
+	switch c.Prefix().Codec {
+	case cid.Raw:
+		w.stats.NodeTypes[RawLeaf]++
+		st.logicalBytes = uint64(len(raw))
+	case cid.DagProtobuf:
+		nb := dagpb.Type.PBNode.NewBuilder()
+		if err := dagpb.DecodeBytes(nb, raw); err != nil {
+			return subtree{}, err
+		}
+		pbnd := nb.Build().(dagpb.PBNode)
+		if !pbnd.FieldData().Exists() {
+			return subtree{}, fmt.Errorf("block %s is not UnixFS: no Data field", c)
+		}
+		ufsData, err := data.DecodeUnixFSData(pbnd.FieldData().Must().Bytes())
+		if err != nil {
+			return subtree{}, fmt.Errorf("block %s is not UnixFS: %w", c, err)
+		}
+		dataType := ufsData.FieldDataType().Int()
+		typeName, ok := data.DataTypeNames[dataType]
+		if !ok {
+			return subtree{}, data.ErrInvalidDataType{DataType: dataType}
+		}
+		w.stats.NodeTypes[typeName]++
+		if (dataType == data.Data_File || dataType == data.Data_Raw) && ufsData.FieldData().Exists() {
+			st.logicalBytes = uint64(len(ufsData.FieldData().Must().Bytes()))
+		}
+		itr := pbnd.FieldLinks().Iterator()
+		for !itr.Done() {
+			_, lnk := itr.Next()
+			cl, ok := lnk.FieldHash().Link().(cidlink.Link)
+			if !ok {
+				return subtree{}, fmt.Errorf("unsupported link type: %T", lnk.FieldHash().Link())
+			}
+			child, err := w.walk(cl.Cid)
+			if err != nil {
+				return subtree{}, err
+			}
+			st.blocks += child.blocks
+			st.logicalBytes += child.logicalBytes
+			if child.depth+1 > st.depth {
+				st.depth = child.depth + 1
+			}
+		}
+	default:
+		return subtree{}, fmt.Errorf("block %s has unsupported codec 0x%x", c, c.Prefix().Codec)
+	}
+	w.seen[c] = st
+	return st, nil
+}
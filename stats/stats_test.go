@@ -0,0 +1,138 @@
+package stats_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/stats"
+	"github.com/ipfs/go-unixfsnode/testutil"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollect(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	dir, err := testutil.UnixFSDirectory(ls, 4<<20,
+		testutil.WithRandReader(random.NewSeededRand(1234)),
+		testutil.WithDuplicateContent(20),
+	)
+	require.NoError(t, err)
+
+	var logical uint64
+	unique := make(map[string]struct{})
+	var walk func(testutil.DirEntry)
+	walk = func(e testutil.DirEntry) {
+		logical += uint64(len(e.Content))
+		for _, c := range e.SelfCids {
+			unique[c.KeyString()] = struct{}{}
+		}
+		for _, child := range e.Children {
+			walk(child)
+		}
+	}
+	walk(dir)
+
+	st, err := stats.Collect(context.Background(), &ls, cidlink.Link{Cid: dir.Root})
+	require.NoError(t, err)
+	require.Equal(t, logical, st.LogicalBytes)
+	require.Equal(t, len(unique), st.UniqueBlocks)
+	require.Equal(t, len(storage.Bag), st.UniqueBlocks)
+	var stored uint64
+	var largest int
+	for _, blk := range storage.Bag {
+		stored += uint64(len(blk))
+		if len(blk) > largest {
+			largest = len(blk)
+		}
+	}
+	require.Equal(t, stored, st.StoredBytes)
+	require.Equal(t, largest, st.LargestBlock)
+	require.Greater(t, st.Blocks, st.UniqueBlocks)
+	require.GreaterOrEqual(t, st.Depth, 2)
+	require.Greater(t, st.NodeTypes["Directory"], 0)
+	require.Greater(t, st.NodeTypes[stats.RawLeaf], 0)
+}
+
+func TestCollectSingleFile(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	content := random.Bytes(1 << 20)
+	f, _, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls)
+	require.NoError(t, err)
+	entry, err := builder.BuildUnixFSDirectoryEntry("file", 0, f)
+	require.NoError(t, err)
+	root, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{entry, entry}, &ls)
+	require.NoError(t, err)
+
+	st, err := stats.Collect(context.Background(), &ls, root)
+	require.NoError(t, err)
+	// 1024 leaves under 6 intermediate nodes and one root, under a directory
+	// which references it twice
+	require.Equal(t, 1024+7+1, st.UniqueBlocks)
+	require.Equal(t, 2*(1024+7)+1, st.Blocks)
+	require.Equal(t, uint64(2<<20), st.LogicalBytes)
+	require.Equal(t, 3, st.Depth)
+	require.Equal(t, 7, st.NodeTypes["File"])
+	require.Equal(t, 1, st.NodeTypes["Directory"])
+	require.Equal(t, 1024, st.NodeTypes[stats.RawLeaf])
+}
+
+func TestCompareDedup(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	entry := func(name string, size int) dagpb.PBLink {
+		lnk, sz, err := builder.BuildUnixFSFile(bytes.NewReader(random.Bytes(size)), "size-1024", &ls)
+		require.NoError(t, err)
+		e, err := builder.BuildUnixFSDirectoryEntry(name, int64(sz), lnk)
+		require.NoError(t, err)
+		return e
+	}
+	a, b, c := entry("a", 10000), entry("b", 20000), entry("c", 30000)
+	dir1, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{a, b}, &ls)
+	require.NoError(t, err)
+	dir2, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{b, c}, &ls)
+	require.NoError(t, err)
+
+	st1, err := stats.Collect(context.Background(), &ls, dir1)
+	require.NoError(t, err)
+	st2, err := stats.Collect(context.Background(), &ls, dir2)
+	require.NoError(t, err)
+	shared, err := stats.Collect(context.Background(), &ls, b.FieldHash().Link())
+	require.NoError(t, err)
+
+	dd, err := stats.CompareDedup(context.Background(), &ls, dir1, dir2)
+	require.NoError(t, err)
+	require.Len(t, dd.Roots, 2)
+	require.Equal(t, len(storage.Bag), dd.TotalBlocks)
+	require.Equal(t, shared.UniqueBlocks, dd.SharedBlocks)
+	require.Equal(t, shared.StoredBytes, dd.SharedBytes)
+	require.Equal(t, st1.UniqueBlocks, dd.Roots[0].Blocks)
+	require.Equal(t, st1.StoredBytes, dd.Roots[0].Bytes)
+	require.Equal(t, st2.UniqueBlocks, dd.Roots[1].Blocks)
+	require.Equal(t, st2.StoredBytes, dd.Roots[1].Bytes)
+	require.Equal(t, st1.UniqueBlocks-shared.UniqueBlocks, dd.Roots[0].UniqueBlocks)
+	require.Equal(t, st1.StoredBytes-shared.StoredBytes, dd.Roots[0].UniqueBytes)
+	require.Equal(t, st2.UniqueBlocks-shared.UniqueBlocks, dd.Roots[1].UniqueBlocks)
+	require.Equal(t, st2.StoredBytes-shared.StoredBytes, dd.Roots[1].UniqueBytes)
+	require.Equal(t, dd.TotalBytes, dd.Roots[0].UniqueBytes+dd.Roots[1].UniqueBytes+dd.SharedBytes)
+
+	// a root compared with itself shares everything
+	dd, err = stats.CompareDedup(context.Background(), &ls, dir1, dir1)
+	require.NoError(t, err)
+	require.Equal(t, st1.UniqueBlocks, dd.SharedBlocks)
+	require.Zero(t, dd.Roots[0].UniqueBlocks)
+}
@@ -0,0 +1,35 @@
+package unixfsnode
+
+import (
+	"github.com/ipld/go-ipld-prime"
+)
+
+// SubstrateNode is implemented by every node reified from a dag-pb node:
+// files, directories, sharded directories, symlinks and other PathedPBNodes,
+// and UnknownTypeNode placeholders. Its methods are those of ipld.ADL.
+type SubstrateNode interface {
+	ipld.Node
+	// Substrate returns the node as it is encoded, for re-serialization or for
+	// writing to a CAR: the dag-pb node, or the bytes of a raw block. A file
+	// whose root block hasn't been loaded yet is loaded, and Substrate returns
+	// nil if it can't be.
+	Substrate() ipld.Node
+}
+
+// Substrate returns the node n was reified from, unwrapping nodes reified from
+// other reified nodes, such as decrypted directories, down to the encoded
+// node. Nodes that weren't reified, including dag-pb nodes and raw blocks,
+// are returned as they are.
+func Substrate(n ipld.Node) ipld.Node {
+	for {
+		sn, ok := n.(SubstrateNode)
+		if !ok {
+			return n
+		}
+		sub := sn.Substrate()
+		if sub == nil || sub == n {
+			return n
+		}
+		n = sub
+	}
+}
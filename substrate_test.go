@@ -0,0 +1,71 @@
+package unixfsnode_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubstrate(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	// with an mtime, a single-block file is a dag-pb node rather than a raw leaf
+	single, singleSize, err := builder.BuildUnixFSFile(bytes.NewReader([]byte("hello")), "", &ls, builder.WithMtime(time.Unix(1700000000, 0)))
+	require.NoError(t, err)
+	multi, _, err := builder.BuildUnixFSFile(bytes.NewReader(random.Bytes(1<<20)), "size-262144", &ls)
+	require.NoError(t, err)
+	symlink, _, err := builder.BuildUnixFSSymlink("target", &ls)
+	require.NoError(t, err)
+	var entries []dagpb.PBLink
+	for i := 0; i < 50; i++ {
+		e, err := builder.BuildUnixFSDirectoryEntry(fmt.Sprintf("file%d", i), int64(singleSize), single)
+		require.NoError(t, err)
+		entries = append(entries, e)
+	}
+	dir, _, err := builder.BuildUnixFSDirectory(entries, &ls)
+	require.NoError(t, err)
+	shard, _, err := builder.BuildUnixFSShardedDirectory(16, multihash.MURMUR3X64_64, entries, &ls)
+	require.NoError(t, err)
+
+	for name, lnk := range map[string]ipld.Link{
+		"single-block file": single,
+		"multi-block file":  multi,
+		"symlink":           symlink,
+		"directory":         dir,
+		"sharded directory": shard,
+	} {
+		t.Run(name, func(t *testing.T) {
+			nd, err := ls.Load(ipld.LinkContext{}, lnk, dagpb.Type.PBNode)
+			require.NoError(t, err)
+			reified, err := unixfsnode.Reify(ipld.LinkContext{}, nd, &ls)
+			require.NoError(t, err)
+			sn, ok := reified.(unixfsnode.SubstrateNode)
+			require.True(t, ok, "%T is not a SubstrateNode", reified)
+			require.Equal(t, nd, sn.Substrate())
+
+			// the substrate re-encodes to the block it was loaded from
+			block, err := ls.LoadRaw(ipld.LinkContext{}, lnk)
+			require.NoError(t, err)
+			var buf bytes.Buffer
+			require.NoError(t, dagpb.Encode(unixfsnode.Substrate(reified), &buf))
+			require.Equal(t, block, buf.Bytes())
+		})
+	}
+
+	nd, err := ls.Load(ipld.LinkContext{}, dir, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	require.Equal(t, nd, unixfsnode.Substrate(nd))
+}
@@ -0,0 +1,64 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/testutil"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnixFSDirectoryFromMap(t *testing.T) {
+	files := map[string][]byte{
+		"a.txt":           []byte("a"),
+		"b/c.txt":         []byte("see"),
+		"b/d/e.txt":       make([]byte, 5000),
+		"b/empty.txt":     nil,
+		"empty/":          nil,
+		"f/g/h/deep.bin":  {0x01, 0x02},
+		"f/g/sibling.txt": []byte("sibling"),
+	}
+
+	for _, bitwidth := range []int{0, 2} {
+		ls := cidlink.DefaultLinkSystem()
+		storage := cidlink.Memory{}
+		ls.StorageReadOpener = storage.OpenRead
+		ls.StorageWriteOpener = storage.OpenWrite
+		ls.NodeReifier = unixfsnode.Reify
+
+		entry, err := testutil.UnixFSDirectoryFromMap(ls, files, testutil.WithChunker("size-1024"), testutil.WithShardBitwidth(bitwidth))
+		require.NoError(t, err)
+		testutil.CompareDirEntries(t, entry, testutil.ToDirEntry(t, ls, entry.Root, true))
+
+		// the same files always make the same DAG
+		again, err := testutil.UnixFSDirectoryFromMap(ls, files, testutil.WithChunker("size-1024"), testutil.WithShardBitwidth(bitwidth))
+		require.NoError(t, err)
+		require.Equal(t, entry.Root, again.Root)
+
+		found := make(map[string]testutil.DirEntry)
+		var walk func(testutil.DirEntry)
+		walk = func(de testutil.DirEntry) {
+			if de.Children == nil {
+				found[de.Path] = de
+			}
+			for _, child := range de.Children {
+				walk(child)
+			}
+		}
+		walk(entry)
+		require.Len(t, found, 6)
+		for p, content := range files {
+			if p != "empty/" {
+				require.Equal(t, len(content), len(found["/"+p].Content))
+			}
+		}
+		// five leaves and a root
+		require.Len(t, found["/b/d/e.txt"].SelfCids, 6)
+	}
+
+	_, err := testutil.UnixFSDirectoryFromMap(cidlink.DefaultLinkSystem(), map[string][]byte{"a": nil, "a/b": nil})
+	require.Error(t, err)
+	_, err = testutil.UnixFSDirectoryFromMap(cidlink.DefaultLinkSystem(), map[string][]byte{"a/../b": nil})
+	require.Error(t, err)
+}
@@ -0,0 +1,28 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipfs/go-unixfsnode/testutil/namegen"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadDirEntryStableOrder(t *testing.T) {
+	lsys, _ := memoryLinkSystem()
+	lsys.NodeReifier = unixfsnode.Reify
+	dir, err := testutil.UnixFSDirectory(lsys, 1<<20, testutil.WithRandReader(namegen.NewSeededReader(3)), testutil.WithShardBitwidth(4))
+	require.NoError(t, err)
+
+	serial, err := testutil.LoadDirEntry(lsys, dir.Root, "", true, testutil.WithLoadParallelism(1))
+	require.NoError(t, err)
+	testutil.CompareDirEntries(t, dir, serial)
+	for _, n := range []int{2, 8, 64} {
+		for i := 0; i < 5; i++ {
+			parallel, err := testutil.LoadDirEntry(lsys, dir.Root, "", true, testutil.WithLoadParallelism(n))
+			require.NoError(t, err)
+			require.Equal(t, serial, parallel, "loaded with %d goroutines", n)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/directory"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuplicateNamePolicy(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+	unixfsnode.AddUnixFSReificationToLinkSystem(&ls)
+
+	var files []ipld.Link
+	for _, content := range []string{"first", "second", "other"} {
+		f, _, err := builder.BuildUnixFSFile(strings.NewReader(content), "", &ls)
+		require.NoError(t, err)
+		files = append(files, f)
+	}
+	var entries []dagpb.PBLink
+	for i, name := range []string{"dup", "dup", "other"} {
+		e, err := builder.BuildUnixFSDirectoryEntry(name, 0, files[i])
+		require.NoError(t, err)
+		entries = append(entries, e)
+	}
+	dir, _, err := builder.BuildUnixFSDirectory(entries, &ls)
+	require.NoError(t, err)
+
+	load := func(ctx context.Context) (directory.UnixFSBasicDir, error) {
+		nd, err := ls.Load(ipld.LinkContext{Ctx: ctx}, dir, dagpb.Type.PBNode)
+		if err != nil {
+			return nil, err
+		}
+		nd, err = unixfsnode.Reify(ipld.LinkContext{Ctx: ctx}, nd, &ls)
+		if err != nil {
+			return nil, err
+		}
+		return nd.(directory.UnixFSBasicDir), nil
+	}
+
+	for _, tc := range []struct {
+		policy directory.DuplicateNamePolicy
+		want   ipld.Link
+	}{
+		{directory.FirstWins, files[0]},
+		{directory.LastWins, files[1]},
+	} {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			ctx := directory.WithDuplicateNamePolicy(context.Background(), tc.policy)
+			d, err := load(ctx)
+			require.NoError(t, err)
+			v, err := d.LookupByString("dup")
+			require.NoError(t, err)
+			lnk, err := v.AsLink()
+			require.NoError(t, err)
+			require.Equal(t, tc.want, lnk)
+			v, err = d.LookupByString("other")
+			require.NoError(t, err)
+			lnk, err = v.AsLink()
+			require.NoError(t, err)
+			require.Equal(t, files[2], lnk)
+			require.Equal(t, int64(3), d.Length())
+		})
+	}
+
+	t.Run("default", func(t *testing.T) {
+		d, err := load(context.Background())
+		require.NoError(t, err)
+		v, err := d.LookupByString("dup")
+		require.NoError(t, err)
+		lnk, err := v.AsLink()
+		require.NoError(t, err)
+		require.Equal(t, files[0], lnk)
+
+		all := d.LookupAll("dup")
+		require.Len(t, all, 2)
+		require.Equal(t, files[0], all[0].Link())
+		require.Equal(t, files[1], all[1].Link())
+		require.Empty(t, d.LookupAll("missing"))
+	})
+
+	t.Run(directory.RejectDuplicates.String(), func(t *testing.T) {
+		_, err := load(directory.WithDuplicateNamePolicy(context.Background(), directory.RejectDuplicates))
+		require.ErrorIs(t, err, directory.ErrDuplicateName{Name: "dup"})
+		// reading and building report duplicates with the same type
+		var dupErr builder.ErrDuplicateName
+		require.ErrorAs(t, err, &dupErr)
+		require.Equal(t, "dup", dupErr.Name)
+	})
+}
@@ -0,0 +1,75 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/file"
+	"github.com/ipfs/go-unixfsnode/testutil"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultyLinkSystem(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	entry, err := testutil.UnixFSFile(ls, 10*1024, testutil.WithRandReader(random.NewSeededRand(1)), testutil.WithChunker("size-1024"))
+	require.NoError(t, err)
+	// the root is stored last, after its leaves
+	require.Len(t, entry.SelfCids, 11)
+	leaf := entry.SelfCids[3]
+
+	read := func(ctx context.Context, lsys linking.LinkSystem) ([]byte, error) {
+		nd, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, entry.Link(), dagpb.Type.PBNode)
+		if err != nil {
+			return nil, err
+		}
+		ufn, err := unixfsnode.Reify(ipld.LinkContext{Ctx: ctx}, nd, &lsys)
+		if err != nil {
+			return nil, err
+		}
+		rs, err := ufn.(file.LargeBytesNode).AsLargeBytes()
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(rs)
+	}
+
+	got, err := read(context.Background(), testutil.FaultyLinkSystem(ls))
+	require.NoError(t, err)
+	require.Equal(t, entry.Content, got)
+
+	_, err = read(context.Background(), testutil.FaultyLinkSystem(ls, testutil.NotFound(leaf)))
+	require.ErrorIs(t, err, format.ErrNotFound{Cid: leaf})
+
+	_, err = read(context.Background(), testutil.FaultyLinkSystem(ls, testutil.CorruptBytes(leaf)))
+	require.Error(t, err)
+
+	faulty := testutil.FaultyLinkSystem(ls, testutil.FailAfter(5, nil))
+	_, err = read(context.Background(), faulty)
+	require.ErrorIs(t, err, testutil.ErrInjectedFault)
+	// the loads are used up
+	_, err = faulty.Load(ipld.LinkContext{}, entry.Link(), dagpb.Type.PBNode)
+	require.ErrorIs(t, err, testutil.ErrInjectedFault)
+
+	injected := errors.New("leaf went away")
+	_, err = read(context.Background(), testutil.FaultyLinkSystem(ls, testutil.FailAfter(0, injected, leaf)))
+	require.ErrorIs(t, err, injected)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = read(ctx, testutil.FaultyLinkSystem(ls, testutil.SlowRead(time.Minute, leaf)))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
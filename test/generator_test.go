@@ -0,0 +1,228 @@
+package test
+
+import (
+	"context"
+	"math/bits"
+	"path"
+	"testing"
+
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/hamt"
+	"github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipfs/go-unixfsnode/testutil/namegen"
+	"github.com/ipfs/go-unixfsnode/verify"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func memoryLinkSystem() (ipld.LinkSystem, *cidlink.Memory) {
+	storage := &cidlink.Memory{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.StorageReadOpener = storage.OpenRead
+	lsys.StorageWriteOpener = storage.OpenWrite
+	lsys.TrustedStorage = true
+	return lsys, storage
+}
+
+// requireStored checks that every block of entry is in storage.
+func requireStored(t *testing.T, storage *cidlink.Memory, entry testutil.DirEntry) {
+	t.Helper()
+	for _, c := range entry.SelfCids {
+		_, ok := storage.Bag[string(c.Hash())]
+		require.True(t, ok, "%s of %s is missing", c, entry.Path)
+	}
+	for _, child := range entry.Children {
+		requireStored(t, storage, child)
+	}
+}
+
+func TestOptionsReused(t *testing.T) {
+	opts := []testutil.Option{
+		testutil.WithRandReader(namegen.NewSeededReader(1)),
+		testutil.WithChunker("size-256"),
+		testutil.WithDuplicateContent(50),
+		testutil.WithTargetBlocks(200),
+	}
+	for i := 0; i < 2; i++ {
+		lsys, storage := memoryLinkSystem()
+		dir, err := testutil.UnixFSDirectory(lsys, 1<<16, opts...)
+		require.NoError(t, err)
+		// nothing is carried over from the tree generated before
+		requireStored(t, storage, dir)
+		require.Greater(t, len(storage.Bag), 50)
+	}
+}
+
+func TestFullWidthShard(t *testing.T) {
+	lsys, _ := memoryLinkSystem()
+	lsys.NodeReifier = unixfsnode.Reify
+	dir, err := testutil.FullWidthShard(lsys, testutil.WithRandReader(namegen.NewSeededReader(4)))
+	require.NoError(t, err)
+	testutil.CompareDirEntries(t, dir, testutil.ToDirEntry(t, lsys, dir.Root, true))
+
+	// a single shard, with an entry in every bucket
+	require.Len(t, dir.SelfCids, 1)
+	require.Len(t, dir.Children, testutil.MaxShardWidth)
+	log2 := bits.TrailingZeros(testutil.MaxShardWidth)
+	buckets := make(map[int]bool)
+	for _, child := range dir.Children {
+		bucket, ok := hamt.Bucket(path.Base(child.Path), 0, log2)
+		require.True(t, ok)
+		buckets[bucket] = true
+	}
+	require.Len(t, buckets, testutil.MaxShardWidth)
+}
+
+func TestDefaultChildGeneratorPaths(t *testing.T) {
+	lsys, _ := memoryLinkSystem()
+	lsys.NodeReifier = unixfsnode.Reify
+	dir, err := testutil.UnixFSDirectory(lsys, 1<<20, testutil.WithRandReader(namegen.NewSeededReader(2)))
+	require.NoError(t, err)
+	testutil.CompareDirEntries(t, dir, testutil.ToDirEntry(t, lsys, dir.Root, true))
+
+	// every entry is named, and its path is its parent's path joined with its
+	// name exactly once
+	var nested bool
+	var check func(parent testutil.DirEntry)
+	check = func(parent testutil.DirEntry) {
+		names := make(map[string]bool)
+		for _, child := range parent.Children {
+			name := path.Base(child.Path)
+			require.NotEmpty(t, name)
+			require.Equal(t, parent.Path+"/"+name, child.Path)
+			require.False(t, names[name], "duplicate name %q in %q", name, parent.Path)
+			names[name] = true
+			if child.Children != nil {
+				nested = true
+				check(child)
+			}
+		}
+	}
+	check(dir)
+	require.True(t, nested)
+}
+
+func TestMaxDepthReached(t *testing.T) {
+	var depthOf func(entry testutil.DirEntry) int
+	depthOf = func(entry testutil.DirEntry) int {
+		depth := 1
+		for _, child := range entry.Children {
+			if child.Children != nil {
+				if d := 1 + depthOf(child); d > depth {
+					depth = d
+				}
+			}
+		}
+		return depth
+	}
+	for depth := 1; depth <= 6; depth++ {
+		for seed := uint64(0); seed < 4; seed++ {
+			for _, opts := range [][]testutil.Option{
+				{testutil.WithMaxDepth(depth)},
+				{testutil.WithMaxDepth(depth), testutil.WithTargetBlocks(20), testutil.WithChunker("size-256")},
+			} {
+				lsys, storage := memoryLinkSystem()
+				opts = append(opts, testutil.WithRandReader(namegen.NewSeededReader(seed)))
+				// a small target, so that the depth is reached beyond it
+				dir, err := testutil.UnixFSDirectory(lsys, 4096, opts...)
+				require.NoError(t, err)
+				requireStored(t, storage, dir)
+				require.Equal(t, depth, depthOf(dir), "seed %d", seed)
+			}
+		}
+	}
+}
+
+func TestMalformedNodes(t *testing.T) {
+	// each fixture breaks exactly one thing, which is the one violation found
+	expected := map[testutil.Malformation]struct {
+		kind    verify.ViolationKind
+		message string
+	}{
+		testutil.MalformedBitfield:        {verify.InvalidHAMT, "bits set for"},
+		testutil.MalformedBlockSizes:      {verify.BlockSizesMismatch, "blocksizes and data sum to"},
+		testutil.MalformedBlockSizesCount: {verify.BlockSizesMismatch, "blocksizes for"},
+		testutil.MalformedFanout:          {verify.InvalidHAMT, "invalid HAMT fanout"},
+		testutil.MalformedOversizedFanout: {verify.InvalidHAMT, "invalid HAMT fanout"},
+		testutil.MalformedHashType:        {verify.InvalidHAMT, hamt.ErrInvalidHashType.Error()},
+		testutil.MalformedTruncatedData:   {verify.InvalidUnixFSData, ""},
+		testutil.MalformedShardLinkName:   {verify.InvalidHAMT, "invalid link name"},
+	}
+	for _, kind := range testutil.AllMalformations {
+		t.Run(kind.String(), func(t *testing.T) {
+			for seed := uint64(0); seed < 8; seed++ {
+				lsys, _ := memoryLinkSystem()
+				c, err := testutil.MalformedNode(lsys, kind, testutil.WithRandReader(namegen.NewSeededReader(seed)))
+				require.NoError(t, err)
+
+				nd, err := lsys.Load(ipld.LinkContext{}, cidlink.Link{Cid: c}, dagpb.Type.PBNode)
+				require.NoError(t, err)
+				_, err = data.DecodeUnixFSData(nd.(dagpb.PBNode).FieldData().Must().Bytes())
+				if kind == testutil.MalformedTruncatedData {
+					require.Error(t, err, "seed %d", seed)
+				} else {
+					require.NoError(t, err, "seed %d", seed)
+				}
+
+				report, err := verify.Verify(context.Background(), &lsys, cidlink.Link{Cid: c})
+				require.NoError(t, err)
+				require.Len(t, report.Violations, 1, "seed %d: %v", seed, report.Violations)
+				violation := report.Violations[0]
+				require.Equal(t, expected[kind].kind, violation.Kind, violation.String())
+				require.Contains(t, violation.Message, expected[kind].message)
+			}
+		})
+	}
+}
+
+func TestNestedShardedDirectory(t *testing.T) {
+	lsys, storage := memoryLinkSystem()
+	lsys.NodeReifier = unixfsnode.Reify
+	dir, err := testutil.NestedShardedDirectory(lsys, 3, 20, testutil.WithRandReader(namegen.NewSeededReader(5)))
+	require.NoError(t, err)
+	requireStored(t, storage, dir)
+	testutil.CompareDirEntries(t, dir, testutil.ToDirEntry(t, lsys, dir.Root, true))
+
+	// every directory, the nested ones included, is a multi-level HAMT of
+	// the same small width
+	var dirs int
+	var fanout int64
+	var check func(entry testutil.DirEntry, level int)
+	check = func(entry testutil.DirEntry, level int) {
+		dirs++
+		nd, err := lsys.Load(ipld.LinkContext{}, cidlink.Link{Cid: entry.Root}, dagpb.Type.PBNode)
+		require.NoError(t, err)
+		pbnd := nd.(ipld.ADL).Substrate().(dagpb.PBNode)
+		ufsData, err := data.DecodeUnixFSData(pbnd.FieldData().Must().Bytes())
+		require.NoError(t, err)
+		require.Equal(t, int64(data.Data_HAMTShard), ufsData.FieldDataType().Int(), entry.Path)
+		if fanout == 0 {
+			fanout = ufsData.FieldFanout().Must().Int()
+			require.Less(t, fanout, int64(256))
+		}
+		require.Equal(t, fanout, ufsData.FieldFanout().Must().Int(), entry.Path)
+		require.Greater(t, len(entry.SelfCids), 1, entry.Path)
+
+		var subdirs int
+		for _, child := range entry.Children {
+			if child.Children != nil {
+				subdirs++
+				check(child, level+1)
+			}
+		}
+		if level < 3 {
+			require.Equal(t, 2, subdirs, entry.Path)
+		} else {
+			require.Zero(t, subdirs, entry.Path)
+		}
+	}
+	check(dir, 1)
+	require.Equal(t, 7, dirs)
+
+	report, err := verify.Verify(context.Background(), &lsys, cidlink.Link{Cid: dir.Root})
+	require.NoError(t, err)
+	require.True(t, report.OK(), "%v", report.Violations)
+}
@@ -0,0 +1,57 @@
+package test
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipfs/go-unixfsnode/testutil/namegen"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostileNames(t *testing.T) {
+	rnd := random.NewSeededRand(1)
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		name, err := namegen.RandomFileName(rnd, namegen.WithHostileNames())
+		require.NoError(t, err)
+		require.NotContains(t, name, "/")
+		require.NotContains(t, []string{"", ".", ".."}, name)
+		require.LessOrEqual(t, len(name), 255)
+		require.True(t, utf8.ValidString(name), name)
+		seen["space"] = seen["space"] || strings.Contains(name, " ")
+		seen["combining"] = seen["combining"] || strings.Contains(name, "\u0301")
+		seen["long"] = seen["long"] || len(name) > 250
+		seen["dot"] = seen["dot"] || strings.HasPrefix(name, ".")
+		seen["control"] = seen["control"] || strings.ContainsAny(name, "\t\n\r")
+	}
+	require.Len(t, seen, 5)
+	for kind, ok := range seen {
+		require.True(t, ok, kind)
+	}
+
+	for _, bitwidth := range []int{0, 2} {
+		ls := cidlink.DefaultLinkSystem()
+		storage := cidlink.Memory{}
+		ls.StorageReadOpener = storage.OpenRead
+		ls.StorageWriteOpener = storage.OpenWrite
+		ls.NodeReifier = unixfsnode.Reify
+
+		dir, err := testutil.UnixFSDirectory(ls, 1<<18,
+			testutil.WithRandReader(random.NewSeededRand(2)),
+			testutil.WithShardBitwidth(bitwidth),
+			testutil.WithHostileNames())
+		require.NoError(t, err)
+		testutil.CompareDirEntries(t, dir, testutil.ToDirEntry(t, ls, dir.Root, true))
+
+		nested, err := testutil.NestedShardedDirectory(ls, 2, 20,
+			testutil.WithRandReader(random.NewSeededRand(3)),
+			testutil.WithHostileNames())
+		require.NoError(t, err)
+		testutil.CompareDirEntries(t, nested, testutil.ToDirEntry(t, ls, nested.Root, true))
+	}
+}
@@ -0,0 +1,113 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/utils"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupByIndex(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+	unixfsnode.AddUnixFSReificationToLinkSystem(&ls)
+
+	var entries []dagpb.PBLink
+	files := make(map[string]ipld.Link)
+	for i := 0; i < 100; i++ {
+		// entry "7" is named like an index but is not the entry at 7
+		name := fmt.Sprintf("file-%d", i)
+		if i == 20 {
+			name = "7"
+		}
+		f, size, err := builder.BuildUnixFSFile(strings.NewReader(name), "", &ls)
+		require.NoError(t, err)
+		files[name] = f
+		e, err := builder.BuildUnixFSDirectoryEntry(name, int64(size), f)
+		require.NoError(t, err)
+		entries = append(entries, e)
+	}
+	basic, _, err := builder.BuildUnixFSDirectory(entries, &ls)
+	require.NoError(t, err)
+	sharded, _, err := builder.BuildUnixFSShardedDirectory(16, multihash.MURMUR3X64_64, entries, &ls)
+	require.NoError(t, err)
+
+	for name, root := range map[string]ipld.Link{"basic": basic, "sharded": sharded} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			pbn, err := ls.Load(ipld.LinkContext{Ctx: ctx}, root, dagpb.Type.PBNode)
+			require.NoError(t, err)
+			nd, err := unixfsnode.Reify(ipld.LinkContext{Ctx: ctx}, pbn, &ls)
+			require.NoError(t, err)
+
+			// entries by index are in iteration order
+			var names []string
+			mi := nd.MapIterator()
+			for !mi.Done() {
+				k, _, err := mi.Next()
+				require.NoError(t, err)
+				ks, err := k.AsString()
+				require.NoError(t, err)
+				names = append(names, ks)
+			}
+			require.Len(t, names, 100)
+			for i, name := range names {
+				v, err := nd.LookupByIndex(int64(i))
+				require.NoError(t, err)
+				lnk, err := v.AsLink()
+				require.NoError(t, err)
+				require.Equal(t, files[name], lnk)
+			}
+			_, err = nd.LookupByIndex(100)
+			require.ErrorAs(t, err, &ipld.ErrNotExists{})
+			_, err = nd.LookupByIndex(-1)
+			require.ErrorAs(t, err, &ipld.ErrNotExists{})
+
+			// segments are names, even numeric ones
+			v, err := nd.LookupBySegment(ipld.PathSegmentOfString("7"))
+			require.NoError(t, err)
+			lnk, err := v.AsLink()
+			require.NoError(t, err)
+			require.Equal(t, files["7"], lnk)
+
+			// a numeric segment that isn't a name is not found, and doesn't
+			// resolve to the entry at that index
+			_, err = nd.LookupBySegment(ipld.PathSegmentOfString("8"))
+			require.Error(t, err)
+
+			_, err = nd.LookupBySegment(ipld.PathSegmentOfString("nope"))
+			require.Error(t, err)
+
+			// with index segments, a numeric segment that isn't a name is
+			// the entry at that index, and names still win
+			ictx := utils.WithIndexSegments(ctx)
+			nd, err = unixfsnode.Reify(ipld.LinkContext{Ctx: ictx}, pbn, &ls)
+			require.NoError(t, err)
+			v, err = nd.LookupBySegment(ipld.PathSegmentOfString("7"))
+			require.NoError(t, err)
+			lnk, err = v.AsLink()
+			require.NoError(t, err)
+			require.Equal(t, files["7"], lnk)
+			v, err = nd.LookupBySegment(ipld.PathSegmentOfString("8"))
+			require.NoError(t, err)
+			lnk, err = v.AsLink()
+			require.NoError(t, err)
+			require.Equal(t, files[names[8]], lnk)
+			_, err = nd.LookupBySegment(ipld.PathSegmentOfString("100"))
+			require.ErrorAs(t, err, &ipld.ErrNotExists{})
+			_, err = nd.LookupBySegment(ipld.PathSegmentOfString("nope"))
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/directory"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBasicDirReverseIteration(t *testing.T) {
+	ctx := context.Background()
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	var entries []dagpb.PBLink
+	var names []string
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("file %02d", i)
+		f, size, err := builder.BuildUnixFSFile(strings.NewReader(name), "", &ls)
+		require.NoError(t, err)
+		e, err := builder.BuildUnixFSDirectoryEntry(name, int64(size), f)
+		require.NoError(t, err)
+		entries = append(entries, e)
+		names = append(names, name)
+	}
+	dir, _, err := builder.BuildUnixFSDirectory(entries, &ls)
+	require.NoError(t, err)
+	nd, err := ls.Load(ipld.LinkContext{Ctx: ctx}, dir, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	nd, err = unixfsnode.Reify(ipld.LinkContext{Ctx: ctx}, nd, &ls)
+	require.NoError(t, err)
+	d := nd.(directory.UnixFSBasicDir)
+
+	var forward []string
+	itr := d.MapIterator()
+	for !itr.Done() {
+		k, _, err := itr.Next()
+		require.NoError(t, err)
+		name, err := k.AsString()
+		require.NoError(t, err)
+		forward = append(forward, name)
+	}
+	require.Equal(t, names, forward)
+
+	var reversed []string
+	ritr := d.ReverseMapIterator()
+	for !ritr.Done() {
+		k, v, err := ritr.Next()
+		require.NoError(t, err)
+		name, err := k.AsString()
+		require.NoError(t, err)
+		lnk, err := v.AsLink()
+		require.NoError(t, err)
+		expected, err := d.LookupByString(name)
+		require.NoError(t, err)
+		expectedLnk, err := expected.AsLink()
+		require.NoError(t, err)
+		require.Equal(t, expectedLnk, lnk)
+		reversed = append(reversed, name)
+	}
+	_, _, err = ritr.Next()
+	require.ErrorIs(t, err, ipld.ErrIteratorOverread{})
+	require.Len(t, reversed, len(names))
+	for i, name := range reversed {
+		require.Equal(t, names[len(names)-1-i], name)
+	}
+
+	// the last three, without reading the rest
+	var last []string
+	nitr := d.ReverseIterator()
+	for i := 0; i < 3 && !nitr.Done(); i++ {
+		k, _ := nitr.Next()
+		last = append(last, k.String())
+	}
+	require.Equal(t, []string{"file 19", "file 18", "file 17"}, last)
+	raw := d.ReverseRawIterator()
+	total, ok := raw.Total()
+	require.True(t, ok)
+	require.Equal(t, int64(20), total)
+	name, _, err := raw.Next()
+	require.NoError(t, err)
+	require.Equal(t, "file 19", name)
+	require.Equal(t, int64(19), raw.Index())
+
+	// indexes are those of the links, as for LookupByIndex
+	fitr := d.Iterator()
+	require.Equal(t, int64(-1), fitr.Index())
+	for !fitr.Done() {
+		k, v := fitr.Next()
+		byIndex, err := d.LookupByIndex(fitr.Index())
+		require.NoError(t, err)
+		require.Equal(t, v, byIndex, k.String())
+	}
+}
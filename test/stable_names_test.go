@@ -0,0 +1,51 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipfs/go-unixfsnode/testutil/namegen"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStableNames(t *testing.T) {
+	// these must not change: fixtures depend on them
+	expected := []string{
+		"Beatrice", "Splendiferous.xml",
+		"G", "bēorhall.jpg",
+		"Snuffleupagus", "juxtapositionally.docx",
+		"brackle", "bo.pdf",
+	}
+	r := namegen.NewSeededReader(42)
+	var names []string
+	for i := 0; i < 4; i++ {
+		dir, err := namegen.RandomDirectoryName(r, namegen.WithStableNames(namegen.StableV1))
+		require.NoError(t, err)
+		file, err := namegen.RandomFileName(r, namegen.WithStableNames(namegen.StableV1))
+		require.NoError(t, err)
+		names = append(names, dir, file)
+	}
+	require.Equal(t, expected, names)
+
+	_, err := namegen.RandomFileName(r, namegen.WithStableNames(1000))
+	require.Error(t, err)
+
+	var roots []string
+	for i := 0; i < 2; i++ {
+		ls := cidlink.DefaultLinkSystem()
+		storage := cidlink.Memory{}
+		ls.StorageReadOpener = storage.OpenRead
+		ls.StorageWriteOpener = storage.OpenWrite
+		ls.NodeReifier = unixfsnode.Reify
+
+		dir, err := testutil.UnixFSDirectory(ls, 1<<16,
+			testutil.WithRandReader(namegen.NewSeededReader(7)),
+			testutil.WithStableNames(namegen.StableV1))
+		require.NoError(t, err)
+		testutil.CompareDirEntries(t, dir, testutil.ToDirEntry(t, ls, dir.Root, true))
+		roots = append(roots, dir.Root.String())
+	}
+	require.Equal(t, roots[0], roots[1])
+}
@@ -0,0 +1,60 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddUnixFSReificationToTraversalConfig(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageWriteOpener = storage.OpenWrite
+	dir, err := testutil.UnixFSDirectory(ls, 1<<20, testutil.WithRandReader(random.NewSeededRand(0xdeadbeef)))
+	require.NoError(t, err)
+
+	var target testutil.DirEntry
+	var find func(de testutil.DirEntry) bool
+	find = func(de testutil.DirEntry) bool {
+		if de.Content != nil && strings.Count(de.Path, "/") > 1 {
+			target = de
+			return true
+		}
+		for _, child := range de.Children {
+			if find(child) {
+				return true
+			}
+		}
+		return false
+	}
+	require.True(t, find(dir), "no nested file in fixture")
+
+	// nothing but storage is set up by hand
+	cfg := &traversal.Config{}
+	cfg.LinkSystem.StorageReadOpener = storage.OpenRead
+	unixfsnode.AddUnixFSReificationToTraversalConfig(cfg)
+
+	sel, err := selector.CompileSelector(unixfsnode.UnixFSPathSelector(target.Path))
+	require.NoError(t, err)
+	proto, err := cfg.LinkTargetNodePrototypeChooser(dir.Link(), linking.LinkContext{})
+	require.NoError(t, err)
+	root, err := cfg.LinkSystem.Load(linking.LinkContext{}, dir.Link(), proto)
+	require.NoError(t, err)
+
+	var matched []byte
+	err = traversal.Progress{Cfg: cfg}.WalkMatching(root, sel, func(p traversal.Progress, n datamodel.Node) error {
+		matched, err = n.AsBytes()
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, target.Content, matched)
+}
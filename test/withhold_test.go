@@ -0,0 +1,63 @@
+package test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipfs/go-unixfsnode/testutil/namegen"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithheldBlocks(t *testing.T) {
+	opts := func(extra ...testutil.Option) []testutil.Option {
+		return append([]testutil.Option{
+			testutil.WithRandReader(namegen.NewSeededReader(2)),
+			testutil.WithChunker("size-256"),
+			testutil.WithDuplicateContent(30),
+		}, extra...)
+	}
+	fullLsys, full := memoryLinkSystem()
+	expected, err := testutil.UnixFSDirectory(fullLsys, 1<<16, opts()...)
+	require.NoError(t, err)
+
+	lsys, storage := memoryLinkSystem()
+	var withheld []cid.Cid
+	dir, err := testutil.UnixFSDirectory(lsys, 1<<16, opts(testutil.WithWithheldBlocks(20, &withheld))...)
+	require.NoError(t, err)
+	// the same DAG is generated, less the withheld blocks
+	require.Equal(t, expected, dir)
+	require.NotEmpty(t, withheld)
+	require.Equal(t, len(full.Bag), len(storage.Bag)+len(withheld))
+	seen := make(map[cid.Cid]bool)
+	for _, c := range withheld {
+		require.False(t, seen[c], "%s reported twice", c)
+		seen[c] = true
+		_, ok := storage.Bag[string(c.Hash())]
+		require.False(t, ok, "%s was stored", c)
+	}
+
+	// withholding exactly the root
+	lsys, storage = memoryLinkSystem()
+	withheld = nil
+	root := expected.Root
+	dir, err = testutil.UnixFSDirectory(lsys, 1<<16, opts(testutil.WithWithholdFunc(func(c cid.Cid) bool {
+		return c == root
+	}, &withheld))...)
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{root}, withheld)
+	require.Equal(t, len(full.Bag)-1, len(storage.Bag))
+	_, err = lsys.LoadRaw(ipld.LinkContext{}, cidlink.Link{Cid: dir.Root})
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// a file, which may be withheld as a whole
+	lsys, storage = memoryLinkSystem()
+	withheld = nil
+	file, err := testutil.UnixFSFile(lsys, 10000, opts(testutil.WithWithheldBlocks(100, &withheld))...)
+	require.NoError(t, err)
+	require.ElementsMatch(t, file.SelfCids, withheld)
+	require.Empty(t, storage.Bag)
+}
@@ -0,0 +1,141 @@
+package testutil
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// UnixFSFileFromBytes packages content into UnixFS structure, stored in the
+// provided LinkSystem, and returns a DirEntry representation of the file. It
+// is the same as UnixFSFile, but with content chosen by the caller. The
+// WithChunker and WithWithheldBlocks options apply.
+func UnixFSFileFromBytes(lsys linking.LinkSystem, content []byte, opts ...Option) (DirEntry, error) {
+	o := applyOptions(opts)
+	return fileFromBytes(o.withholder.wrap(lsys), content, o.chunker)
+}
+
+func fileFromBytes(lsys linking.LinkSystem, content []byte, chunker string) (DirEntry, error) {
+	cids := make([]cid.Cid, 0)
+	var undo func()
+	lsys.StorageWriteOpener, undo = cidCollector(lsys, &cids)
+	defer undo()
+	root, size, err := builder.BuildUnixFSFile(bytes.NewReader(content), chunker, &lsys)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	return DirEntry{
+		Path:     "",
+		Content:  content,
+		Root:     root.(cidlink.Link).Cid,
+		SelfCids: cids,
+		TSize:    uint64(size),
+	}, nil
+}
+
+// UnixFSDirectoryFromMap builds a UnixFS directory holding exactly the files
+// in the files map, keyed by slash-separated paths relative to the directory,
+// storing the blocks in the provided LinkSystem and returning a DirEntry
+// representation of the directory. Directories between the root and each file
+// are created as needed; a path ending in "/" makes an empty directory, and
+// its content is ignored. Unlike UnixFSDirectory, nothing is random, so tests
+// can build precise trees whose content and shape they know in advance.
+//
+// The WithChunker, WithShardBitwidth, WithDirname and WithWithheldBlocks
+// options apply. Where WithShardBitwidth is set, every directory is sharded.
+func UnixFSDirectoryFromMap(lsys linking.LinkSystem, files map[string][]byte, opts ...Option) (DirEntry, error) {
+	o := applyOptions(opts)
+	lsys = o.withholder.wrap(lsys)
+
+	root := &contentDir{}
+	for p, content := range files {
+		isDir := strings.HasSuffix(p, "/")
+		segments := strings.Split(strings.Trim(p, "/"), "/")
+		for _, seg := range segments {
+			if seg == "" || seg == "." || seg == ".." {
+				return DirEntry{}, fmt.Errorf("invalid path %q", p)
+			}
+		}
+		dir := root
+		if !isDir {
+			segments = segments[:len(segments)-1]
+		}
+		for _, seg := range segments {
+			if _, ok := dir.files[seg]; ok {
+				return DirEntry{}, fmt.Errorf("path %q is both a file and a directory", p)
+			}
+			dir = dir.subdir(seg)
+		}
+		if isDir {
+			continue
+		}
+		name := path.Base(p)
+		if _, ok := dir.dirs[name]; ok {
+			return DirEntry{}, fmt.Errorf("path %q is both a file and a directory", p)
+		}
+		if dir.files == nil {
+			dir.files = make(map[string][]byte)
+		}
+		dir.files[name] = content
+	}
+	return root.pack(lsys, o, o.dirname)
+}
+
+// contentDir is a directory of the tree given to UnixFSDirectoryFromMap.
+type contentDir struct {
+	dirs  map[string]*contentDir
+	files map[string][]byte
+}
+
+func (d *contentDir) subdir(name string) *contentDir {
+	if d.dirs == nil {
+		d.dirs = make(map[string]*contentDir)
+	}
+	sub, ok := d.dirs[name]
+	if !ok {
+		sub = &contentDir{}
+		d.dirs[name] = sub
+	}
+	return sub
+}
+
+func (d *contentDir) pack(lsys linking.LinkSystem, o *options, dir string) (DirEntry, error) {
+	children := make([]DirEntry, 0, len(d.dirs)+len(d.files))
+	for _, name := range sortedKeys(d.dirs) {
+		child, err := d.dirs[name].pack(lsys, o, dir+"/"+name)
+		if err != nil {
+			return DirEntry{}, err
+		}
+		children = append(children, child)
+	}
+	for _, name := range sortedKeys(d.files) {
+		child, err := fileFromBytes(lsys, d.files[name], o.chunker)
+		if err != nil {
+			return DirEntry{}, err
+		}
+		child.Path = dir + "/" + name
+		children = append(children, child)
+	}
+	dirEntry, err := packDirectory(lsys, children, o.shardBitwidth)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	dirEntry.Path = dir
+	return dirEntry, nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,246 @@
+package testutil
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/hamt"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+// The generators in this file produce pathological, but valid, UnixFS DAG
+// shapes that are useful for testing the resource limits of consumers.
+
+// MaxShardWidth is the widest HAMT fanout that the hamt package will read.
+const MaxShardWidth = hamt.MaxWidth
+
+var dagPbLinkProto = cidlink.LinkPrototype{Prefix: cid.Prefix{
+	Version:  1,
+	Codec:    uint64(multicodec.DagPb),
+	MhType:   multihash.SHA2_256,
+	MhLength: 32,
+}}
+
+// DeepDirectory generates a root directory with a chain of `depth` nested
+// directories beneath it, each containing only a single child directory, with
+// a small random file at the bottom of the chain. The WithShardBitwidth option
+// will cause every directory in the chain to be built as a HAMT.
+func DeepDirectory(lsys linking.LinkSystem, depth int, opts ...Option) (DirEntry, error) {
+	o := applyOptions(opts)
+	prefix := o.dirname + strings.Repeat("/d", depth)
+	current, err := UnixFSFile(lsys, 32, opts...)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	current.Path = prefix + "/file"
+	for i := depth; i >= 0; i-- {
+		dir, err := packDirectory(lsys, []DirEntry{current}, o.shardBitwidth)
+		if err != nil {
+			return DirEntry{}, err
+		}
+		dir.Path = prefix[:len(o.dirname)+i*2]
+		current = dir
+	}
+	return current, nil
+}
+
+// DeepFile generates a file with `size` random bytes stored in a single raw
+// leaf, wrapped by a chain of `depth` single-child dag-pb File nodes. Such a
+// file is valid UnixFS but will never be produced by a standard chunker and
+// forces readers to descend `depth` blocks to reach any content.
+func DeepFile(lsys linking.LinkSystem, depth int, size int, opts ...Option) (DirEntry, error) {
+	leaf, err := UnixFSFile(lsys, size, append(opts, WithChunker(fmt.Sprintf("size-%d", size+1)))...)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	var lnk ipld.Link = cidlink.Link{Cid: leaf.Root}
+	tsize := leaf.TSize
+	cids := leaf.SelfCids
+	for i := 0; i < depth; i++ {
+		ufd, err := builder.BuildUnixFS(func(b *builder.Builder) {
+			builder.FileSize(b, uint64(size))
+			builder.BlockSizes(b, []uint64{uint64(size)})
+		})
+		if err != nil {
+			return DirEntry{}, err
+		}
+		pbLink, err := builder.BuildUnixFSDirectoryEntry("", int64(tsize), lnk)
+		if err != nil {
+			return DirEntry{}, err
+		}
+		nd, err := qp.BuildMap(dagpb.Type.PBNode, 2, func(ma ipld.MapAssembler) {
+			qp.MapEntry(ma, "Links", qp.List(1, func(la ipld.ListAssembler) {
+				qp.ListEntry(la, qp.Node(pbLink))
+			}))
+			qp.MapEntry(ma, "Data", qp.Bytes(data.EncodeUnixFSData(ufd)))
+		})
+		if err != nil {
+			return DirEntry{}, err
+		}
+		var blockSize uint64
+		lnk, blockSize, err = storeCounted(lsys, nd)
+		if err != nil {
+			return DirEntry{}, err
+		}
+		tsize += blockSize
+		cids = append(cids, lnk.(cidlink.Link).Cid)
+	}
+	return DirEntry{
+		Path:     leaf.Path,
+		Content:  leaf.Content,
+		Root:     lnk.(cidlink.Link).Cid,
+		SelfCids: cids,
+		TSize:    tsize,
+	}, nil
+}
+
+// EmptyFilesDirectory generates a single directory containing `count`
+// zero-byte files. All of the files share the same (empty) content and
+// therefore the same CID. The WithShardBitwidth option can be used to build
+// the directory as a HAMT.
+func EmptyFilesDirectory(lsys linking.LinkSystem, count int, opts ...Option) (DirEntry, error) {
+	o := applyOptions(opts)
+	empty, err := UnixFSFile(lsys, 0, opts...)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	children := make([]DirEntry, 0, count)
+	for i := 0; i < count; i++ {
+		child := empty
+		child.Path = fmt.Sprintf("%s/empty-%08d", o.dirname, i)
+		children = append(children, child)
+	}
+	dir, err := packDirectory(lsys, children, o.shardBitwidth)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	dir.Path = o.dirname
+	return dir, nil
+}
+
+// LongNamesDirectory generates a single directory containing `count` small
+// random files, each with a name of `nameLength` bytes. The WithShardBitwidth
+// option can be used to build the directory as a HAMT.
+func LongNamesDirectory(lsys linking.LinkSystem, count int, nameLength int, opts ...Option) (DirEntry, error) {
+	o := applyOptions(opts)
+	children := make([]DirEntry, 0, count)
+	for i := 0; i < count; i++ {
+		child, err := UnixFSFile(lsys, 16, opts...)
+		if err != nil {
+			return DirEntry{}, err
+		}
+		suffix := fmt.Sprintf("-%08d", i)
+		if nameLength < len(suffix)+1 {
+			return DirEntry{}, fmt.Errorf("name length must be at least %d", len(suffix)+1)
+		}
+		child.Path = o.dirname + "/" + strings.Repeat("n", nameLength-len(suffix)) + suffix
+		children = append(children, child)
+	}
+	dir, err := packDirectory(lsys, children, o.shardBitwidth)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	dir.Path = o.dirname
+	return dir, nil
+}
+
+// FullWidthShard generates a HAMT sharded directory with a fanout of
+// MaxShardWidth where every bucket of the root shard is occupied by exactly
+// one direct entry, producing the widest possible single shard node. Each
+// entry is a small random file.
+func FullWidthShard(lsys linking.LinkSystem, opts ...Option) (DirEntry, error) {
+	o := applyOptions(opts)
+	names, err := fillBuckets(o.randReader, MaxShardWidth)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	children := make([]DirEntry, 0, len(names))
+	dirLinks := make([]dagpb.PBLink, 0, len(names))
+	for _, name := range names {
+		child, err := UnixFSFile(lsys, 16, opts...)
+		if err != nil {
+			return DirEntry{}, err
+		}
+		child.Path = o.dirname + "/" + name
+		children = append(children, child)
+		lnk, err := builder.BuildUnixFSDirectoryEntry(name, int64(child.TSize), cidlink.Link{Cid: child.Root})
+		if err != nil {
+			return DirEntry{}, err
+		}
+		dirLinks = append(dirLinks, lnk)
+	}
+	cids := make([]cid.Cid, 0)
+	var undo func()
+	lsys.StorageWriteOpener, undo = cidCollector(lsys, &cids)
+	defer undo()
+	root, size, err := builder.BuildUnixFSShardedDirectory(MaxShardWidth, multihash.MURMUR3X64_64, dirLinks, &lsys)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	return DirEntry{
+		Path:     o.dirname,
+		Root:     root.(cidlink.Link).Cid,
+		SelfCids: cids,
+		TSize:    size,
+		Children: children,
+	}, nil
+}
+
+// fillBuckets finds a set of names, one for each of the `width` buckets of a
+// HAMT root shard, such that no two names collide at the root.
+func fillBuckets(randReader io.Reader, width int) ([]string, error) {
+	log2 := 0
+	for 1<<log2 < width {
+		log2++
+	}
+	names := make([]string, width)
+	var filled int
+	buf := make([]byte, 8)
+	for i := 0; filled < width; i++ {
+		if _, err := io.ReadFull(randReader, buf); err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("%x", buf)
+		bucket, _ := hamt.Bucket(name, 0, log2)
+		if names[bucket] == "" {
+			names[bucket] = name
+			filled++
+		}
+	}
+	return names, nil
+}
+
+func storeCounted(lsys linking.LinkSystem, nd ipld.Node) (ipld.Link, uint64, error) {
+	var size uint64
+	swo := lsys.StorageWriteOpener
+	lsys.StorageWriteOpener = func(lc linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		w, c, err := swo(lc)
+		if err != nil {
+			return nil, nil, err
+		}
+		return writeCounter{w, &size}, c, nil
+	}
+	lnk, err := lsys.Store(linking.LinkContext{}, dagPbLinkProto, nd)
+	return lnk, size, err
+}
+
+type writeCounter struct {
+	w     io.Writer
+	count *uint64
+}
+
+func (wc writeCounter) Write(p []byte) (int, error) {
+	n, err := wc.w.Write(p)
+	*wc.count += uint64(n)
+	return n, err
+}
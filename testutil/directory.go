@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"sync"
 	"testing"
 
 	"github.com/ipfs/go-cid"
@@ -54,43 +55,113 @@ func ToDirEntry(t *testing.T, linkSys linking.LinkSystem, rootCid cid.Cid, expec
 // This is useful when representing a sub-DAG of a larger DAG where you want
 // to make direct comparisons.
 func ToDirEntryFrom(t *testing.T, linkSys linking.LinkSystem, rootCid cid.Cid, rootPath string, expectFull bool) DirEntry {
+	de, err := LoadDirEntry(linkSys, rootCid, rootPath, expectFull)
+	require.NoError(t, err)
+	return de
+}
+
+// LoadDirEntry is the error-returning equivalent of ToDirEntryFrom, suitable
+// for use outside of tests. It builds a DirEntry tree representing the file
+// and directory structure found in the LinkSystem starting at rootCid, with
+// all paths prefixed by rootPath. If expectFull is false, blocks that are not
+// found in the LinkSystem will result in an empty DirEntry rather than an
+// error.
+//
+// The entries of directories are loaded in parallel, by as many goroutines as
+// WithLoadParallelism allows, and are kept in the order of the directory, so
+// the result is the same however many are used. Calls to the
+// StorageReadOpener of the LinkSystem are serialized, so it needn't be safe
+// for concurrent use.
+func LoadDirEntry(linkSys linking.LinkSystem, rootCid cid.Cid, rootPath string, expectFull bool, opts ...Option) (DirEntry, error) {
+	o := applyOptions(opts)
+	var mu sync.Mutex
+	open := linkSys.StorageReadOpener
+	linkSys.StorageReadOpener = func(lc linking.LinkContext, l ipld.Link) (io.Reader, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return open(lc, l)
+	}
+	// the caller of loadDirEntry holds no slot, so a directory that can't get
+	// one for a child loads it itself rather than waiting
+	slots := make(chan struct{}, max(o.loadParallelism-1, 0))
+	return loadDirEntry(linkSys, rootCid, rootPath, expectFull, slots)
+}
+
+func loadDirEntry(linkSys linking.LinkSystem, rootCid cid.Cid, rootPath string, expectFull bool, slots chan struct{}) (DirEntry, error) {
 	var proto datamodel.NodePrototype = dagpb.Type.PBNode
 	isDagPb := rootCid.Prefix().Codec == cid.DagProtobuf
 	if !isDagPb {
 		proto = basicnode.Prototype.Any
 	}
 	node, err := linkSys.Load(linking.LinkContext{Ctx: context.TODO()}, cidlink.Link{Cid: rootCid}, proto)
-	if expectFull {
-		require.NoError(t, err)
-	} else if err != nil {
-		if e, ok := err.(interface{ NotFound() bool }); ok && e.NotFound() {
-			return DirEntry{}
+	if err != nil {
+		if e, ok := err.(interface{ NotFound() bool }); !expectFull && ok && e.NotFound() {
+			return DirEntry{}, nil
 		}
-		require.NoError(t, err)
+		return DirEntry{}, err
 	}
 
 	if node.Kind() == ipld.Kind_Bytes { // is a file
 		byts, err := node.AsBytes()
-		require.NoError(t, err)
+		if err != nil {
+			return DirEntry{}, err
+		}
 		return DirEntry{
 			Path:    rootPath,
 			Content: byts,
 			Root:    rootCid,
-		}
+		}, nil
 	}
 
 	children := make([]DirEntry, 0)
 	if isDagPb {
 		// else is likely a directory
+		type childRef struct {
+			name string
+			cid  cid.Cid
+		}
+		var refs []childRef
 		for itr := node.MapIterator(); !itr.Done(); {
 			k, v, err := itr.Next()
-			require.NoError(t, err)
+			if err != nil {
+				return DirEntry{}, err
+			}
 			childName, err := k.AsString()
-			require.NoError(t, err)
+			if err != nil {
+				return DirEntry{}, err
+			}
 			childLink, err := v.AsLink()
-			require.NoError(t, err)
-			child := ToDirEntryFrom(t, linkSys, childLink.(cidlink.Link).Cid, rootPath+"/"+childName, expectFull)
-			children = append(children, child)
+			if err != nil {
+				return DirEntry{}, err
+			}
+			refs = append(refs, childRef{childName, childLink.(cidlink.Link).Cid})
+		}
+		children = make([]DirEntry, len(refs))
+		errs := make([]error, len(refs))
+		var wg sync.WaitGroup
+		for i, ref := range refs {
+			load := func() {
+				children[i], errs[i] = loadDirEntry(linkSys, ref.cid, rootPath+"/"+ref.name, expectFull, slots)
+			}
+			select {
+			case slots <- struct{}{}:
+				wg.Add(1)
+				go func() {
+					defer func() {
+						<-slots
+						wg.Done()
+					}()
+					load()
+				}()
+			default:
+				load()
+			}
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return DirEntry{}, err
+			}
 		}
 	} else {
 		// not a dag-pb node, let's pretend it is but using IPLD pathing rules
@@ -100,43 +171,67 @@ func ToDirEntryFrom(t *testing.T, linkSys linking.LinkSystem, rootCid cid.Cid, r
 				if err != nil {
 					return err
 				}
-				child := ToDirEntryFrom(t, linkSys, l.(cidlink.Link).Cid, rootPath+"/"+prog.Path.String(), expectFull)
+				child, err := loadDirEntry(linkSys, l.(cidlink.Link).Cid, rootPath+"/"+prog.Path.String(), expectFull, slots)
+				if err != nil {
+					return err
+				}
 				children = append(children, child)
 			}
 			return nil
 		})
-		require.NoError(t, err)
+		if err != nil {
+			return DirEntry{}, err
+		}
 	}
 
 	return DirEntry{
 		Path:     rootPath,
 		Root:     rootCid,
 		Children: children,
-	}
+	}, nil
 }
 
 // CompareDirEntries is a safe, recursive comparison between two DirEntry
 // values. It doesn't strictly require child ordering to match, but it does
 // require that all children exist and match, in some order.
 func CompareDirEntries(t *testing.T, a, b DirEntry) {
-	// t.Log("CompareDirEntries", a.Path, b.Path) // TODO: remove this
-	require.Equal(t, a.Path, b.Path)
-	require.Equal(t, a.Root.String(), b.Root.String(), a.Path+" root mismatch")
+	require.NoError(t, MatchDirEntries(a, b))
+}
+
+// MatchDirEntries is the error-returning equivalent of CompareDirEntries. It
+// returns an error describing the first mismatch found between the two
+// DirEntry trees, or nil if they match.
+func MatchDirEntries(a, b DirEntry) error {
+	if a.Path != b.Path {
+		return fmt.Errorf("path mismatch %s <> %s", a.Path, b.Path)
+	}
+	if a.Root.String() != b.Root.String() {
+		return fmt.Errorf("%s root mismatch %s <> %s", a.Path, a.Root, b.Root)
+	}
 	hashA := sha256.Sum256(a.Content)
 	hashB := sha256.Sum256(b.Content)
-	require.Equal(t, hex.EncodeToString(hashA[:]), hex.EncodeToString(hashB[:]), a.Path+"content hash mismatch")
-	require.Equal(t, len(a.Children), len(b.Children), fmt.Sprintf("%s child length mismatch %d <> %d", a.Path, len(a.Children), len(b.Children)))
+	if hashA != hashB {
+		return fmt.Errorf("%s content hash mismatch %s <> %s", a.Path, hex.EncodeToString(hashA[:]), hex.EncodeToString(hashB[:]))
+	}
+	if len(a.Children) != len(b.Children) {
+		return fmt.Errorf("%s child length mismatch %d <> %d", a.Path, len(a.Children), len(b.Children))
+	}
 	for i := range a.Children {
 		// not necessarily in order
 		var found bool
 		for j := range b.Children {
 			if a.Children[i].Path == b.Children[j].Path {
 				found = true
-				CompareDirEntries(t, a.Children[i], b.Children[j])
+				if err := MatchDirEntries(a.Children[i], b.Children[j]); err != nil {
+					return err
+				}
 			}
 		}
-		require.True(t, found, fmt.Sprintf("@ path [%s], a's child [%s] not found in b", a.Path, a.Children[i].Path))
+		if !found {
+			return fmt.Errorf("@ path [%s], a's child [%s] not found in b", a.Path, a.Children[i].Path)
+		}
 	}
+	return nil
 }
 
 // WrapContent embeds the content we want in some random nested content such
@@ -144,24 +239,41 @@ func CompareDirEntries(t *testing.T, a, b DirEntry) {
 // content will be the only thing under the path. If false, there will be
 // content before and after the wrapped content at each point in the path.
 func WrapContent(t *testing.T, rndReader io.Reader, lsys *ipld.LinkSystem, content DirEntry, wrapPath string, exclusive bool) DirEntry {
+	want, err := WrapDirEntry(*lsys, rndReader, content, wrapPath, exclusive)
+	require.NoError(t, err)
+	return want
+}
+
+// WrapDirEntry is the error-returning equivalent of WrapContent.
+func WrapDirEntry(lsys linking.LinkSystem, rndReader io.Reader, content DirEntry, wrapPath string, exclusive bool) (DirEntry, error) {
 	want := content
 	ps := datamodel.ParsePath(wrapPath)
 	for ps.Len() > 0 {
 		de := []DirEntry{}
 		if !exclusive {
-			before := GenerateDirectory(t, lsys, rndReader, 4<<10, false)
+			before, err := RandomDirectory(lsys, rndReader, 4<<10, "", false)
+			if err != nil {
+				return DirEntry{}, err
+			}
 			before.Path = "!before"
 			de = append(de, before)
 		}
 		want.Path = ps.Last().String()
 		de = append(de, want)
 		if !exclusive {
-			after := GenerateDirectory(t, lsys, rndReader, 4<<11, true)
+			after, err := RandomDirectory(lsys, rndReader, 4<<11, "", true)
+			if err != nil {
+				return DirEntry{}, err
+			}
 			after.Path = "~after"
 			de = append(de, after)
 		}
-		want = BuildDirectory(t, lsys, de, false)
+		var err error
+		want, err = PackDirectory(lsys, de, false)
+		if err != nil {
+			return DirEntry{}, err
+		}
 		ps = ps.Pop()
 	}
-	return want
+	return want, nil
 }
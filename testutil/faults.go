@@ -0,0 +1,184 @@
+package testutil
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// ErrInjectedFault is the error returned by loads failed with FailAfter when
+// no error is given.
+var ErrInjectedFault = errors.New("injected fault")
+
+// Fault is a fault to inject into the reads of a LinkSystem with
+// FaultyLinkSystem.
+type Fault func(*faultSet)
+
+type faultRule struct {
+	// the CIDs the rule applies to, or nil for every CID
+	cids     map[cid.Cid]struct{}
+	notFound bool
+	delay    time.Duration
+	corrupt  bool
+	// loads are allowed until failAfter reaches zero, then fail with err
+	failAfter *int
+	// the first failFirst loads fail with err, and the rest are allowed
+	failFirst *int
+	err       error
+}
+
+type faultSet struct {
+	lk    sync.Mutex
+	rules []*faultRule
+}
+
+func (f *faultSet) add(rule *faultRule, cids []cid.Cid) {
+	if len(cids) > 0 {
+		rule.cids = make(map[cid.Cid]struct{}, len(cids))
+		for _, c := range cids {
+			rule.cids[c] = struct{}{}
+		}
+	}
+	f.rules = append(f.rules, rule)
+}
+
+// NotFound makes loads of the given CIDs fail with go-ipld-format's
+// ErrNotFound, as if the blocks were missing.
+func NotFound(cids ...cid.Cid) Fault {
+	return func(f *faultSet) {
+		f.add(&faultRule{notFound: true}, cids)
+	}
+}
+
+// SlowRead delays loads of the given CIDs, or of every block if none are
+// given, by delay. A load whose context is cancelled while it's delayed fails
+// with the context's error.
+func SlowRead(delay time.Duration, cids ...cid.Cid) Fault {
+	return func(f *faultSet) {
+		f.add(&faultRule{delay: delay}, cids)
+	}
+}
+
+// CorruptBytes flips the bits of the last byte of the given blocks as they
+// are read, so they fail hash verification, or decode to something else if
+// the LinkSystem has TrustedStorage.
+func CorruptBytes(cids ...cid.Cid) Fault {
+	return func(f *faultSet) {
+		f.add(&faultRule{corrupt: true}, cids)
+	}
+}
+
+// FailAfter lets n loads of the given CIDs, or of any block if none are
+// given, succeed, then fails every load after them with err, or with
+// ErrInjectedFault if err is nil. Loads are counted across all of the CIDs.
+func FailAfter(n int, err error, cids ...cid.Cid) Fault {
+	if err == nil {
+		err = ErrInjectedFault
+	}
+	return func(f *faultSet) {
+		remaining := n
+		f.add(&faultRule{failAfter: &remaining, err: err}, cids)
+	}
+}
+
+// FailFirst fails the first n loads of the given CIDs, or of any block if none
+// are given, with err, or with ErrInjectedFault if err is nil, and lets every
+// load after them succeed, as storage that is briefly unavailable would. Loads
+// are counted across all of the CIDs.
+func FailFirst(n int, err error, cids ...cid.Cid) Fault {
+	if err == nil {
+		err = ErrInjectedFault
+	}
+	return func(f *faultSet) {
+		remaining := n
+		f.add(&faultRule{failFirst: &remaining, err: err}, cids)
+	}
+}
+
+// FaultyLinkSystem returns lsys with faults injected into the reads of its
+// StorageReadOpener, so that error paths of the file, directory and HAMT ADLs
+// can be exercised on chosen blocks. Faults apply in the order given; a load
+// that fails is not passed on to lsys.
+func FaultyLinkSystem(lsys linking.LinkSystem, faults ...Fault) linking.LinkSystem {
+	f := &faultSet{}
+	for _, fault := range faults {
+		fault(f)
+	}
+	sro := lsys.StorageReadOpener
+	lsys.StorageReadOpener = func(lnkCtx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		c := lnk.(cidlink.Link).Cid
+		var corrupt bool
+		for _, rule := range f.rules {
+			if _, ok := rule.cids[c]; rule.cids != nil && !ok {
+				continue
+			}
+			switch {
+			case rule.notFound:
+				return nil, format.ErrNotFound{Cid: c}
+			case rule.delay > 0:
+				if err := sleep(lnkCtx, rule.delay); err != nil {
+					return nil, err
+				}
+			case rule.corrupt:
+				corrupt = true
+			case rule.failAfter != nil:
+				if !f.take(rule.failAfter) {
+					return nil, rule.err
+				}
+			case rule.failFirst != nil:
+				if f.take(rule.failFirst) {
+					return nil, rule.err
+				}
+			}
+		}
+		r, err := sro(lnkCtx, lnk)
+		if err != nil || !corrupt {
+			return r, err
+		}
+		byts, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(byts) > 0 {
+			byts = append([]byte(nil), byts...)
+			byts[len(byts)-1] ^= 0xff
+		}
+		return bytes.NewReader(byts), nil
+	}
+	return lsys
+}
+
+// take counts a load against the count of a FailAfter or FailFirst rule,
+// returning false once the count has run out.
+func (f *faultSet) take(count *int) bool {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	if *count <= 0 {
+		return false
+	}
+	*count--
+	return true
+}
+
+func sleep(lnkCtx ipld.LinkContext, d time.Duration) error {
+	if lnkCtx.Ctx == nil {
+		time.Sleep(d)
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-lnkCtx.Ctx.Done():
+		return lnkCtx.Ctx.Err()
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"io"
 	"math/big"
+	"runtime"
 	"sort"
 	"strings"
 
@@ -20,15 +21,27 @@ import (
 )
 
 type options struct {
-	randReader     io.Reader
-	shardBitwidth  int
-	chunker        string
-	dirname        string
-	childGenerator ChildGeneratorFn
-	shardThisDir   bool // a private option used internally to randomly switch on sharding at this current level
+	randReader       io.Reader
+	shardBitwidth    int
+	chunker          string
+	dirname          string
+	childGenerator   ChildGeneratorFn
+	duplicateChance  int
+	duplicateContent *[]DirEntry
+	targetBlocks     int
+	blockCount       *int
+	maxDepth         int
+	withhold         func(cid.Cid) bool
+	withheld         *[]cid.Cid
+	withholder       *withholder
+	nameOpts         []namegen.Option
+	loadParallelism  int
+	shardThisDir     bool // a private option used internally to randomly switch on sharding at this current level
+	depth            int  // a private option used internally to track the depth of the current level
+	reachDepth       bool // a private option used internally to mark the directories that must reach maxDepth
 }
 
-// Option is a functional option for the Generate* functions.
+// Option is a functional option for the Generate* functions and LoadDirEntry.
 type Option func(*options)
 
 // WithRandReader sets the random reader used by the Generate* functions.
@@ -81,6 +94,71 @@ func WithChildGenerator(childGenerator ChildGeneratorFn) Option {
 	}
 }
 
+// WithDuplicateContent causes UnixFSDirectory to make approximately
+// `percent`% of the files it generates share identical content (and therefore
+// identical sub-DAGs) with a previously generated file somewhere in the same
+// tree. This is useful for exercising dedup-aware traversal, writing CARs
+// without duplicate blocks, and block-count accounting. The DirEntry for a
+// duplicated file will have the same Root, SelfCids and TSize as the original.
+func WithDuplicateContent(percent int) Option {
+	return func(o *options) {
+		o.duplicateChance = percent
+	}
+}
+
+// WithTargetBlocks switches UnixFSDirectory from targeting a total byte size
+// to targeting an approximate total number of blocks across the whole
+// generated DAG, for tests that care about graph shape rather than payload
+// size. The targetSize argument to UnixFSDirectory is then only used to bound
+// the size of individual files; combine with a small WithChunker size to
+// produce many-block files. Duplicated files (see WithDuplicateContent) count
+// toward the block total as if they were distinct.
+func WithTargetBlocks(blocks int) Option {
+	return func(o *options) {
+		o.targetBlocks = blocks
+	}
+}
+
+// WithMaxDepth sets the depth of directory nesting produced by
+// UnixFSDirectory, where a depth of 1 will produce only a single directory
+// containing files. No directory is nested deeper than depth, and at least one
+// path reaches it exactly, with directories made beyond the size or block
+// target where needed to get there. By default depth is unlimited.
+func WithMaxDepth(depth int) Option {
+	return func(o *options) {
+		o.maxDepth = depth
+	}
+}
+
+// WithHostileNames gives about half of the files and directories generated
+// awkward names, as namegen.WithHostileNames does, to exercise the handling of
+// names with spaces, combining and control characters, metacharacters and
+// the like.
+func WithHostileNames() Option {
+	return func(o *options) {
+		o.nameOpts = append(o.nameOpts, namegen.WithHostileNames())
+	}
+}
+
+// WithStableNames picks the names of files and directories with the given
+// version of namegen's stable name generation, as namegen.WithStableNames
+// does, so that a tree generated from namegen.NewSeededReader has the same
+// paths in every release.
+func WithStableNames(version int) Option {
+	return func(o *options) {
+		o.nameOpts = append(o.nameOpts, namegen.WithStableNames(version))
+	}
+}
+
+// WithLoadParallelism sets the number of goroutines LoadDirEntry uses at most
+// to load the entries of directories. By default it is runtime.GOMAXPROCS(0);
+// a value of 1 loads them one at a time.
+func WithLoadParallelism(n int) Option {
+	return func(o *options) {
+		o.loadParallelism = n
+	}
+}
+
 // shardThisDir is a private internal option
 func shardThisDir(b bool) Option {
 	return func(o *options) {
@@ -88,16 +166,54 @@ func shardThisDir(b bool) Option {
 	}
 }
 
+// atDepth is a private internal option
+func atDepth(depth int) Option {
+	return func(o *options) {
+		o.depth = depth
+	}
+}
+
+// reachDepth is a private internal option
+func reachDepth(b bool) Option {
+	return func(o *options) {
+		o.reachDepth = b
+	}
+}
+
+// sharedWith is a private internal option, used so that the directories and
+// files generated within a tree share its pool of duplicate content, block
+// count and withheld blocks, rather than starting their own
+func sharedWith(parent *options) Option {
+	return func(o *options) {
+		o.duplicateContent = parent.duplicateContent
+		o.blockCount = parent.blockCount
+		o.withholder = parent.withholder
+	}
+}
+
 func applyOptions(opts []Option) *options {
 	o := &options{
-		randReader:    rand.Reader,
-		shardBitwidth: 0,
-		chunker:       "size-256144",
-		shardThisDir:  true,
+		randReader:      rand.Reader,
+		shardBitwidth:   0,
+		chunker:         "size-256144",
+		shardThisDir:    true,
+		reachDepth:      true,
+		loadParallelism: runtime.GOMAXPROCS(0),
 	}
 	for _, opt := range opts {
 		opt(o)
 	}
+	// state is made here rather than by the options, so that an option can be
+	// used for more than one tree
+	if o.duplicateChance > 0 && o.duplicateContent == nil {
+		o.duplicateContent = &[]DirEntry{}
+	}
+	if o.targetBlocks > 0 && o.blockCount == nil {
+		o.blockCount = new(int)
+	}
+	if o.withhold != nil && o.withholder == nil {
+		o.withholder = newWithholder(o.withhold, o.withheld)
+	}
 	return o
 }
 
@@ -106,6 +222,7 @@ func applyOptions(opts []Option) *options {
 // the file.
 func UnixFSFile(lsys linking.LinkSystem, size int, opt ...Option) (DirEntry, error) {
 	o := applyOptions(opt)
+	lsys = o.withholder.wrap(lsys)
 	delimited := io.LimitReader(o.randReader, int64(size))
 	var buf bytes.Buffer
 	buf.Grow(size)
@@ -138,33 +255,69 @@ func UnixFSFile(lsys linking.LinkSystem, size int, opt ...Option) (DirEntry, err
 //
 // If the WithChildGenerator option is not set, the targetSize will be
 // ignored and all sizing control will be delegated to the child generator.
+//
+// If the WithTargetBlocks option is set, generation will aim for an
+// approximate total number of blocks rather than the targetSize.
 func UnixFSDirectory(lsys linking.LinkSystem, targetSize int, opts ...Option) (DirEntry, error) {
 	o := applyOptions(opts)
+	lsys = o.withholder.wrap(lsys)
 
 	var curSize int
+	var finished bool
 	targetFileSize := targetSize / 16
 
 	children := make([]DirEntry, 0)
 
+	countBlocks := func(entry DirEntry) {
+		if o.blockCount != nil {
+			*o.blockCount += len(entry.SelfCids)
+		}
+	}
+	remaining := func() int {
+		if o.targetBlocks > 0 {
+			return o.targetBlocks - *o.blockCount
+		}
+		return targetSize - curSize
+	}
+
 	childGenerator := func(name string) (*DirEntry, error) {
-		for curSize < targetSize {
+		for !finished && remaining() > 0 {
 			switch rndInt(o.randReader, 6) {
 			case 0: // 1 in 6 chance of finishing this directory if not at root
 				if o.dirname != "" && len(children) > 0 {
-					curSize = targetSize // not really, but we're done with this directory
+					finished = true
 				} // else at the root we don't get to finish early
 			case 1: // 1 in 6 chance of making a new directory
-				if targetSize-curSize <= 1024 { // don't make tiny directories
+				if o.maxDepth > 0 && o.depth+1 >= o.maxDepth {
 					continue
 				}
-				so := append(opts, WithDirname(o.dirname+"/"+name), shardThisDir(rndInt(o.randReader, 6) == 0))
-				child, err := UnixFSDirectory(lsys, targetSize-curSize, so...)
+				if o.targetBlocks > 0 {
+					if remaining() <= 2 { // don't make tiny directories
+						continue
+					}
+				} else if targetSize-curSize <= 1024 { // don't make tiny directories
+					continue
+				}
+				childTarget := targetSize - curSize
+				if o.targetBlocks > 0 {
+					childTarget = targetSize
+				}
+				so := append(append([]Option{}, opts...), WithDirname(name), shardThisDir(rndInt(o.randReader, 6) == 0), atDepth(o.depth+1), reachDepth(false), sharedWith(o))
+				child, err := UnixFSDirectory(lsys, childTarget, so...)
 				if err != nil {
 					return nil, err
 				}
-				children = append(children, child)
 				curSize += int(child.TSize)
+				return &child, nil
 			default: // 4 in 6 chance of making a new file
+				if o.duplicateChance > 0 && len(*o.duplicateContent) > 0 && rndInt(o.randReader, 100) < o.duplicateChance {
+					pool := *o.duplicateContent
+					entry := pool[rndInt(o.randReader, len(pool))]
+					entry.Path = name
+					curSize += len(entry.Content)
+					countBlocks(entry)
+					return &entry, nil
+				}
 				var size int
 				for size == 0 { // don't make empty files
 					sizeB, err := rand.Int(o.randReader, big.NewInt(int64(targetFileSize)))
@@ -172,17 +325,20 @@ func UnixFSDirectory(lsys linking.LinkSystem, targetSize int, opts ...Option) (D
 						return nil, err
 					}
 					size = int(sizeB.Int64())
-					if size > targetSize-curSize {
+					if o.targetBlocks == 0 && size > targetSize-curSize {
 						size = targetSize - curSize
 					}
 				}
-				entry, err := UnixFSFile(lsys, size, opts...)
+				entry, err := UnixFSFile(lsys, size, append(append([]Option{}, opts...), sharedWith(o))...)
 				if err != nil {
 					return nil, err
 				}
-				var name string
-				entry.Path = o.dirname + "/" + name
+				entry.Path = name
 				curSize += size
+				countBlocks(entry)
+				if o.duplicateContent != nil {
+					*o.duplicateContent = append(*o.duplicateContent, entry)
+				}
 				return &entry, nil
 			}
 		}
@@ -197,7 +353,7 @@ func UnixFSDirectory(lsys linking.LinkSystem, targetSize int, opts ...Option) (D
 		var name string
 		for {
 			var err error
-			name, err = namegen.RandomDirectoryName(o.randReader)
+			name, err = namegen.RandomDirectoryName(o.randReader, o.nameOpts...)
 			if err != nil {
 				return DirEntry{}, err
 			}
@@ -216,6 +372,31 @@ func UnixFSDirectory(lsys linking.LinkSystem, targetSize int, opts ...Option) (D
 		children = append(children, *child)
 	}
 
+	// make sure one path reaches the depth asked for
+	if o.childGenerator == nil && o.maxDepth > 0 && o.reachDepth && nestedDepth(children) < o.maxDepth-o.depth-1 {
+		var name string
+		for {
+			var err error
+			name, err = namegen.RandomDirectoryName(o.randReader, o.nameOpts...)
+			if err != nil {
+				return DirEntry{}, err
+			}
+			if !isDupe(children, name) {
+				break
+			}
+		}
+		so := append(append([]Option{}, opts...), WithDirname(o.dirname+"/"+name), shardThisDir(rndInt(o.randReader, 6) == 0), atDepth(o.depth+1), reachDepth(true), sharedWith(o))
+		childTarget := targetSize - curSize
+		if o.targetBlocks > 0 {
+			childTarget = targetSize
+		}
+		child, err := UnixFSDirectory(lsys, childTarget, so...)
+		if err != nil {
+			return DirEntry{}, err
+		}
+		children = append(children, child)
+	}
+
 	var shardBitwidth int
 	if o.shardThisDir {
 		shardBitwidth = o.shardBitwidth
@@ -224,6 +405,7 @@ func UnixFSDirectory(lsys linking.LinkSystem, targetSize int, opts ...Option) (D
 	if err != nil {
 		return DirEntry{}, err
 	}
+	countBlocks(dirEntry)
 	dirEntry.Path = o.dirname
 	return dirEntry, nil
 }
@@ -268,6 +450,22 @@ func GenerateDirectoryFrom(
 	dir string,
 	sharded bool,
 ) DirEntry {
+	dirEntry, err := RandomDirectory(*linkSys, randReader, targetSize, dir, sharded)
+	require.NoError(t, err)
+	return dirEntry
+}
+
+// RandomDirectory is the error-returning equivalent of GenerateDirectoryFrom,
+// suitable for use outside of tests, such as in benchmarks, fuzzers and other
+// tooling. Given the same randReader input, it will produce the same output as
+// GenerateDirectoryFrom.
+func RandomDirectory(
+	lsys linking.LinkSystem,
+	randReader io.Reader,
+	targetSize int,
+	dir string,
+	sharded bool,
+) (DirEntry, error) {
 	var curSize int
 	targetFileSize := targetSize / 16
 	children := make([]DirEntry, 0)
@@ -285,31 +483,42 @@ func GenerateDirectoryFrom(
 			for {
 				var err error
 				newDir, err = namegen.RandomDirectoryName(randReader)
-				require.NoError(t, err)
+				if err != nil {
+					return DirEntry{}, err
+				}
 				if !isDupe(children, newDir) {
 					break
 				}
 			}
 			sharded := rndInt(randReader, 6) == 0
-			child := GenerateDirectoryFrom(t, linkSys, randReader, targetSize-curSize, dir+"/"+newDir, sharded)
+			child, err := RandomDirectory(lsys, randReader, targetSize-curSize, dir+"/"+newDir, sharded)
+			if err != nil {
+				return DirEntry{}, err
+			}
 			children = append(children, child)
 			curSize += int(child.TSize)
 		default: // 4 in 6 chance of making a new file
 			var size int
 			for size == 0 { // don't make empty files
 				sizeB, err := rand.Int(randReader, big.NewInt(int64(targetFileSize)))
-				require.NoError(t, err)
+				if err != nil {
+					return DirEntry{}, err
+				}
 				size = int(sizeB.Int64())
 				if size > targetSize-curSize {
 					size = targetSize - curSize
 				}
 			}
-			entry := GenerateFile(t, linkSys, randReader, size)
+			entry, err := UnixFSFile(lsys, size, WithRandReader(randReader))
+			if err != nil {
+				return DirEntry{}, err
+			}
 			var name string
 			for {
-				var err error
 				name, err = namegen.RandomFileName(randReader)
-				require.NoError(t, err)
+				if err != nil {
+					return DirEntry{}, err
+				}
 				if !isDupe(children, name) {
 					break
 				}
@@ -319,9 +528,12 @@ func GenerateDirectoryFrom(
 			children = append(children, entry)
 		}
 	}
-	dirEntry := BuildDirectory(t, linkSys, children, sharded)
+	dirEntry, err := PackDirectory(lsys, children, sharded)
+	if err != nil {
+		return DirEntry{}, err
+	}
 	dirEntry.Path = dir
-	return dirEntry
+	return dirEntry, nil
 }
 
 // BuildDirectory builds a directory from the given children, storing the
@@ -330,17 +542,27 @@ func GenerateDirectoryFrom(
 // sharded (with a low "width" to maximise the chance of collisions and
 // therefore greater depth for smaller number of files).
 //
-// This function will be deprecated in a future release. Currently there is no
-// direct replacement.
+// This function will be deprecated in a future release, use PackDirectory()
+// instead.
 func BuildDirectory(t require.TestingT, linkSys *linking.LinkSystem, children []DirEntry, sharded bool) DirEntry {
+	dirEnt, err := PackDirectory(*linkSys, children, sharded)
+	require.NoError(t, err)
+	return dirEnt
+}
+
+// PackDirectory is the error-returning equivalent of BuildDirectory. It builds
+// a directory from the given children, storing the blocks in the provided
+// LinkSystem and returns a DirEntry representation of the directory. If
+// sharded is true, the root directory will be built as HAMT sharded (with a
+// low "width" to maximise the chance of collisions and therefore greater depth
+// for smaller number of files).
+func PackDirectory(lsys linking.LinkSystem, children []DirEntry, sharded bool) (DirEntry, error) {
 	var bitWidth int
 	if sharded {
 		// fanout of 16, quite small to increase collision probability so we actually get sharding
 		bitWidth = 4
 	}
-	dirEnt, err := packDirectory(*linkSys, children, bitWidth)
-	require.NoError(t, err)
-	return dirEnt
+	return packDirectory(lsys, children, bitWidth)
 }
 
 func packDirectory(lsys linking.LinkSystem, children []DirEntry, bitWidth int) (DirEntry, error) {
@@ -391,6 +613,20 @@ func packDirectory(lsys linking.LinkSystem, children []DirEntry, bitWidth int) (
 	}, nil
 }
 
+// nestedDepth returns the number of levels of directories nested in entries.
+func nestedDepth(entries []DirEntry) int {
+	var depth int
+	for _, e := range entries {
+		if e.Children == nil {
+			continue
+		}
+		if d := 1 + nestedDepth(e.Children); d > depth {
+			depth = d
+		}
+	}
+	return depth
+}
+
 func rndInt(randReader io.Reader, max int) int {
 	coin, err := rand.Int(randReader, big.NewInt(int64(max)))
 	if err != nil {
@@ -0,0 +1,144 @@
+// Command genfixtures regenerates the CAR fixtures used by the tests of this
+// module from code, so that they can be changed, or new ones added, without
+// crafting the files by hand. Run it from the root of the module:
+//
+//	go run ./testutil/genfixtures
+//
+// Each fixture is written as a CARv1 holding the whole DAG of its root, in
+// the order go-car traverses it. Pass -check to compare the generated CARs to
+// those on disk instead of writing them, failing if any differ.
+//
+// hamt/fixtures/wikipedia-cryptographic-hash-function.car is not generated:
+// it is an extract of a real sharded directory, a Wikipedia mirror, made with
+// a path query, and can't be derived from anything in this module.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipld/go-car/v2"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/multiformats/go-multihash"
+)
+
+// fixture builds a DAG into a LinkSystem and returns its root.
+type fixture struct {
+	// path is relative to the root of the module; a "%s" in it is replaced
+	// with the root CID
+	path  string
+	build func(ls *ipld.LinkSystem) (ipld.Link, error)
+}
+
+var fixtures = []fixture{
+	// a single block CIDv0 file, as made by ipfs add
+	{"file/fixtures/%s.car", helloWorldFile},
+	// a CIDv0 directory holding the file as b.txt
+	{"file/fixtures/%s.car", helloWorldDir},
+}
+
+var v0 = cidlink.LinkPrototype{Prefix: cid.Prefix{
+	Version:  0,
+	Codec:    cid.DagProtobuf,
+	MhType:   multihash.SHA2_256,
+	MhLength: 32,
+}}
+
+func helloWorldFile(ls *ipld.LinkSystem) (ipld.Link, error) {
+	lnk, _, err := builder.BuildUnixFSFileWithProfile(strings.NewReader("hello world\n"), "unixfs-v1-cidv0", ls)
+	return lnk, err
+}
+
+func helloWorldDir(ls *ipld.LinkSystem) (ipld.Link, error) {
+	f, size, err := builder.BuildUnixFSFileWithProfile(strings.NewReader("hello world\n"), "unixfs-v1-cidv0", ls)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := builder.BuildUnixFSDirectoryEntry("b.txt", int64(size), f)
+	if err != nil {
+		return nil, err
+	}
+	ufd, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.DataType(b, data.Data_Directory)
+	})
+	if err != nil {
+		return nil, err
+	}
+	nd, err := qp.BuildMap(dagpb.Type.PBNode, 2, func(ma ipld.MapAssembler) {
+		qp.MapEntry(ma, "Links", qp.List(1, func(la ipld.ListAssembler) {
+			qp.ListEntry(la, qp.Node(entry))
+		}))
+		qp.MapEntry(ma, "Data", qp.Bytes(data.EncodeUnixFSData(ufd)))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ls.Store(ipld.LinkContext{}, v0, nd)
+}
+
+func main() {
+	check := flag.Bool("check", false, "compare the generated fixtures to those on disk rather than writing them")
+	flag.Parse()
+
+	var failed bool
+	for _, f := range fixtures {
+		path, car, err := generate(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", f.path, err)
+			os.Exit(1)
+		}
+		if *check {
+			existing, err := os.ReadFile(path)
+			if err != nil || !bytes.Equal(existing, car) {
+				fmt.Fprintf(os.Stderr, "%s: differs from the generated fixture\n", path)
+				failed = true
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(path, car, 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(path)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func generate(f fixture) (string, []byte, error) {
+	ls := cidlink.DefaultLinkSystem()
+	store := cidlink.Memory{}
+	ls.StorageReadOpener = store.OpenRead
+	ls.StorageWriteOpener = store.OpenWrite
+	root, err := f.build(&ls)
+	if err != nil {
+		return "", nil, err
+	}
+	rootCid := root.(cidlink.Link).Cid
+	var buf bytes.Buffer
+	if _, err := car.TraverseV1(context.Background(), &ls, rootCid, selectorparse.CommonSelector_ExploreAllRecursively, &buf); err != nil {
+		return "", nil, err
+	}
+	path := f.path
+	if strings.Contains(path, "%s") {
+		path = fmt.Sprintf(path, rootCid)
+	}
+	return path, buf.Bytes(), nil
+}
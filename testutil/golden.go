@@ -0,0 +1,114 @@
+package testutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// GoldenInput is a file of the golden corpus, Size bytes of content derived
+// from its Name.
+type GoldenInput struct {
+	Name string
+	Size int
+}
+
+// Content returns the content of the input. It's generated by hashing the
+// name with a counter rather than read from a random source, so that it
+// never changes with the version of a dependency.
+func (gi GoldenInput) Content() []byte {
+	out := make([]byte, 0, gi.Size+sha256.Size)
+	var counter [8]byte
+	for i := uint64(0); len(out) < gi.Size; i++ {
+		binary.BigEndian.PutUint64(counter[:], i)
+		sum := sha256.Sum256(append([]byte(gi.Name), counter[:]...))
+		out = append(out, sum[:]...)
+	}
+	return out[:gi.Size]
+}
+
+// GoldenCorpus is the set of files whose CIDs are locked in by golden tables,
+// chosen around the edges of the default chunk size and of single-block
+// files.
+var GoldenCorpus = []GoldenInput{
+	{Name: "empty", Size: 0},
+	{Name: "one-byte", Size: 1},
+	{Name: "small", Size: 1000},
+	{Name: "chunk-less-one", Size: 262143},
+	{Name: "chunk", Size: 262144},
+	{Name: "chunk-plus-one", Size: 262145},
+	{Name: "two-chunks-plus-one", Size: 2*262144 + 1},
+	{Name: "one-mib-odd", Size: 1<<20 + 17},
+	{Name: "three-mib", Size: 3 << 20},
+}
+
+// GoldenTable maps the name of a build profile (see builder.ProfileNames), and
+// the name of an input of GoldenCorpus, to the CID of its root.
+type GoldenTable map[string]map[string]string
+
+// GoldenMismatch is a root that differs from the one in a GoldenTable.
+type GoldenMismatch struct {
+	Profile  string
+	Input    string
+	Expected string
+	Actual   string
+}
+
+func (gm GoldenMismatch) String() string {
+	return fmt.Sprintf("%s/%s: expected %s, got %s", gm.Profile, gm.Input, gm.Expected, gm.Actual)
+}
+
+// GoldenCIDs builds every input of GoldenCorpus under each of the named
+// profiles and returns the roots, such as to regenerate a table after a
+// deliberate change.
+func GoldenCIDs(profiles []string) (GoldenTable, error) {
+	table := make(GoldenTable, len(profiles))
+	for _, profile := range profiles {
+		table[profile] = make(map[string]string, len(GoldenCorpus))
+		for _, input := range GoldenCorpus {
+			ls := cidlink.DefaultLinkSystem()
+			storage := cidlink.Memory{}
+			ls.StorageReadOpener = storage.OpenRead
+			ls.StorageWriteOpener = storage.OpenWrite
+			root, _, err := builder.BuildUnixFSFileWithProfile(bytes.NewReader(input.Content()), profile, &ls)
+			if err != nil {
+				return nil, fmt.Errorf("%s/%s: %w", profile, input.Name, err)
+			}
+			table[profile][input.Name] = root.String()
+		}
+	}
+	return table, nil
+}
+
+// CheckGoldenCIDs builds the corpus under each profile in table and returns
+// the roots that don't match it. Every input of GoldenCorpus must have an
+// entry for each profile, so that new inputs can't go unchecked.
+func CheckGoldenCIDs(table GoldenTable) ([]GoldenMismatch, error) {
+	profiles := make([]string, 0, len(table))
+	for profile := range table {
+		profiles = append(profiles, profile)
+	}
+	sort.Strings(profiles)
+	actual, err := GoldenCIDs(profiles)
+	if err != nil {
+		return nil, err
+	}
+	var mismatches []GoldenMismatch
+	for _, profile := range profiles {
+		for _, input := range GoldenCorpus {
+			expected, ok := table[profile][input.Name]
+			if !ok {
+				return nil, fmt.Errorf("%s/%s: no golden CID", profile, input.Name)
+			}
+			if got := actual[profile][input.Name]; got != expected {
+				mismatches = append(mismatches, GoldenMismatch{Profile: profile, Input: input.Name, Expected: expected, Actual: got})
+			}
+		}
+	}
+	return mismatches, nil
+}
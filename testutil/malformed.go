@@ -0,0 +1,288 @@
+package testutil
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/hamt"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// Malformation describes a way in which a generated UnixFS block is
+// structurally invalid.
+type Malformation int
+
+const (
+	// MalformedBitfield is a HAMT shard whose bitfield does not agree with
+	// the number of links it contains.
+	MalformedBitfield Malformation = iota
+	// MalformedBlockSizes is a multi-block file whose BlockSizes do not sum to
+	// its FileSize.
+	MalformedBlockSizes
+	// MalformedBlockSizesCount is a multi-block file with a different number
+	// of BlockSizes than it has links.
+	MalformedBlockSizesCount
+	// MalformedFanout is a HAMT shard whose Fanout is not a power of two.
+	MalformedFanout
+	// MalformedOversizedFanout is a HAMT shard whose Fanout is a power of two
+	// but larger than hamt.MaxWidth.
+	MalformedOversizedFanout
+	// MalformedHashType is a HAMT shard that declares a hash function other
+	// than murmur3.
+	MalformedHashType
+	// MalformedTruncatedData is a file whose UnixFS Data field has been
+	// truncated part-way through a protobuf field.
+	MalformedTruncatedData
+	// MalformedShardLinkName is a HAMT shard with a link whose name is too
+	// short to contain the bucket index prefix.
+	MalformedShardLinkName
+)
+
+// AllMalformations lists every Malformation that can be generated.
+var AllMalformations = []Malformation{
+	MalformedBitfield,
+	MalformedBlockSizes,
+	MalformedBlockSizesCount,
+	MalformedFanout,
+	MalformedOversizedFanout,
+	MalformedHashType,
+	MalformedTruncatedData,
+	MalformedShardLinkName,
+}
+
+func (m Malformation) String() string {
+	switch m {
+	case MalformedBitfield:
+		return "bad bitfield"
+	case MalformedBlockSizes:
+		return "blocksizes/filesize mismatch"
+	case MalformedBlockSizesCount:
+		return "blocksizes/links count mismatch"
+	case MalformedFanout:
+		return "non power of two fanout"
+	case MalformedOversizedFanout:
+		return "oversized fanout"
+	case MalformedHashType:
+		return "unsupported hash type"
+	case MalformedTruncatedData:
+		return "truncated data"
+	case MalformedShardLinkName:
+		return "short shard link name"
+	default:
+		return fmt.Sprintf("unknown malformation (%d)", int(m))
+	}
+}
+
+// RandomMalformedNode generates a structurally invalid UnixFS block of a
+// randomly selected Malformation, stores it (and any valid children it links
+// to) in the provided LinkSystem and returns its CID along with the
+// Malformation that was applied. The WithRandReader option controls both
+// the selection and the content of the generated block.
+func RandomMalformedNode(lsys linking.LinkSystem, opts ...Option) (cid.Cid, Malformation, error) {
+	o := applyOptions(opts)
+	kind := AllMalformations[rndInt(o.randReader, len(AllMalformations))]
+	c, err := MalformedNode(lsys, kind, opts...)
+	return c, kind, err
+}
+
+// MalformedNode generates a structurally invalid UnixFS block exhibiting the
+// given Malformation, stores it (and any valid children it links to) in the
+// provided LinkSystem and returns its CID. These blocks are intended to be
+// used to check that reification and ADL code fails gracefully.
+func MalformedNode(lsys linking.LinkSystem, kind Malformation, opts ...Option) (cid.Cid, error) {
+	o := applyOptions(opts)
+	switch kind {
+	case MalformedBitfield:
+		return malformedShard(lsys, o, 256, func(b *builder.Builder, buckets []int) {
+			used := make(map[int]bool, len(buckets))
+			for _, bucket := range buckets {
+				used[bucket] = true
+			}
+			// set more bits than there are links, in otherwise empty buckets
+			set := append([]int(nil), buckets...)
+			target := len(buckets) + 1 + rndInt(o.randReader, 8)
+			for i := 0; len(set) < target; i++ {
+				if !used[i] {
+					set = append(set, i)
+				}
+			}
+			builder.Data(b, shardBitfield(256, set))
+			builder.HashType(b, multihash.MURMUR3X64_64)
+			builder.Fanout(b, 256)
+		}, false)
+	case MalformedFanout:
+		// entries are laid out for a fanout of 128, and the declared fanout
+		// shares its bucket prefix length, so only the fanout itself is wrong
+		return malformedShard(lsys, o, 128, func(b *builder.Builder, buckets []int) {
+			builder.Data(b, shardBitfield(128, buckets))
+			builder.HashType(b, multihash.MURMUR3X64_64)
+			builder.Fanout(b, uint64(129+rndInt(o.randReader, 127)))
+		}, false)
+	case MalformedOversizedFanout:
+		fanout := hamt.MaxWidth << (1 + rndInt(o.randReader, 4))
+		return malformedShard(lsys, o, fanout, func(b *builder.Builder, buckets []int) {
+			builder.Data(b, shardBitfield(fanout, buckets))
+			builder.HashType(b, multihash.MURMUR3X64_64)
+			builder.Fanout(b, uint64(fanout))
+		}, false)
+	case MalformedHashType:
+		return malformedShard(lsys, o, 256, func(b *builder.Builder, buckets []int) {
+			builder.Data(b, shardBitfield(256, buckets))
+			builder.HashType(b, multihash.SHA2_256)
+			builder.Fanout(b, 256)
+		}, false)
+	case MalformedShardLinkName:
+		return malformedShard(lsys, o, 256, func(b *builder.Builder, buckets []int) {
+			builder.Data(b, shardBitfield(256, buckets))
+			builder.HashType(b, multihash.MURMUR3X64_64)
+			builder.Fanout(b, 256)
+		}, true)
+	case MalformedBlockSizes:
+		return malformedFile(lsys, o, func(b *builder.Builder, sizes []uint64) {
+			builder.FileSize(b, sum(sizes)+1+uint64(rndInt(o.randReader, 1024)))
+			builder.BlockSizes(b, sizes)
+		}, nil)
+	case MalformedBlockSizesCount:
+		return malformedFile(lsys, o, func(b *builder.Builder, sizes []uint64) {
+			// the BlockSizes that remain still sum to the FileSize
+			builder.FileSize(b, sum(sizes[:len(sizes)-1]))
+			builder.BlockSizes(b, sizes[:len(sizes)-1])
+		}, nil)
+	case MalformedTruncatedData:
+		return malformedFile(lsys, o, func(b *builder.Builder, sizes []uint64) {
+			// inline data gives us a length-delimited field to truncate
+			inline := make([]byte, 64)
+			builder.FileSize(b, sum(sizes)+uint64(len(inline)))
+			builder.BlockSizes(b, sizes)
+			builder.Data(b, inline)
+		}, func(enc []byte) []byte {
+			// chop somewhere inside the Data field, which starts at byte 2
+			return enc[:3+rndInt(o.randReader, 60)]
+		})
+	default:
+		return cid.Undef, fmt.Errorf("unknown malformation: %d", int(kind))
+	}
+}
+
+func sum(sizes []uint64) uint64 {
+	var total uint64
+	for _, s := range sizes {
+		total += s
+	}
+	return total
+}
+
+// shardBitfield returns the bitfield of a shard with the given fanout that
+// has the given buckets set.
+func shardBitfield(fanout int, buckets []int) []byte {
+	bf := make([]byte, fanout/8)
+	for _, i := range buckets {
+		bf[len(bf)-1-i/8] |= 1 << (i % 8)
+	}
+	return bf
+}
+
+// malformedShard builds a single-level HAMT shard node with a handful of
+// small file children, using fn to write the (possibly invalid) HAMT fields.
+// Entries are named and placed in the buckets they hash to in a shard of the
+// given fanout, and fn is passed those buckets. If shortName is set, the
+// first link name is truncated to a single character.
+func malformedShard(lsys linking.LinkSystem, o *options, fanout int, fn func(b *builder.Builder, buckets []int), shortName bool) (cid.Cid, error) {
+	count := 2 + rndInt(o.randReader, 6)
+	log2 := bits.TrailingZeros(uint(fanout))
+	padLen := len(fmt.Sprintf("%X", fanout-1))
+	links := make([]dagpb.PBLink, 0, count)
+	buckets := make([]int, 0, count)
+	used := make(map[int]bool, count)
+	for i := 0; len(links) < count; i++ {
+		name := fmt.Sprintf("entry%d", i)
+		bucket, _ := hamt.Bucket(name, 0, log2)
+		if used[bucket] {
+			continue
+		}
+		used[bucket] = true
+		child, err := UnixFSFile(lsys, 16, WithRandReader(o.randReader))
+		if err != nil {
+			return cid.Undef, err
+		}
+		name = fmt.Sprintf("%0*X%s", padLen, bucket, name)
+		if shortName && len(links) == 0 {
+			name = name[:1]
+		}
+		lnk, err := builder.BuildUnixFSDirectoryEntry(name, int64(child.TSize), cidlink.Link{Cid: child.Root})
+		if err != nil {
+			return cid.Undef, err
+		}
+		links = append(links, lnk)
+		buckets = append(buckets, bucket)
+	}
+	ufd, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.DataType(b, data.Data_HAMTShard)
+		fn(b, buckets)
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+	return storeMalformed(lsys, links, data.EncodeUnixFSData(ufd))
+}
+
+// malformedFile builds a multi-block file node over a handful of small raw
+// leaves, using fn to write the (possibly invalid) file fields. If truncate
+// is set, it is applied to the encoded UnixFS Data before storing.
+func malformedFile(lsys linking.LinkSystem, o *options, fn func(b *builder.Builder, sizes []uint64), truncate func([]byte) []byte) (cid.Cid, error) {
+	count := 2 + rndInt(o.randReader, 6)
+	links := make([]dagpb.PBLink, 0, count)
+	sizes := make([]uint64, 0, count)
+	for i := 0; i < count; i++ {
+		size := 1 + rndInt(o.randReader, 256)
+		child, err := UnixFSFile(lsys, size, WithRandReader(o.randReader))
+		if err != nil {
+			return cid.Undef, err
+		}
+		lnk, err := builder.BuildUnixFSDirectoryEntry("", int64(child.TSize), cidlink.Link{Cid: child.Root})
+		if err != nil {
+			return cid.Undef, err
+		}
+		links = append(links, lnk)
+		sizes = append(sizes, uint64(size))
+	}
+	ufd, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.SkipFileSizeValidation(b)
+		fn(b, sizes)
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+	enc := data.EncodeUnixFSData(ufd)
+	if truncate != nil {
+		enc = truncate(enc)
+	}
+	return storeMalformed(lsys, links, enc)
+}
+
+func storeMalformed(lsys linking.LinkSystem, links []dagpb.PBLink, dataBytes []byte) (cid.Cid, error) {
+	nd, err := qp.BuildMap(dagpb.Type.PBNode, 2, func(ma ipld.MapAssembler) {
+		qp.MapEntry(ma, "Links", qp.List(int64(len(links)), func(la ipld.ListAssembler) {
+			for _, l := range links {
+				qp.ListEntry(la, qp.Node(l))
+			}
+		}))
+		qp.MapEntry(ma, "Data", qp.Bytes(dataBytes))
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+	lnk, err := lsys.Store(linking.LinkContext{}, dagPbLinkProto, nd)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return lnk.(cidlink.Link).Cid, nil
+}
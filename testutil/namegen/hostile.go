@@ -0,0 +1,107 @@
+package namegen
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxNameLength is the longest name most filesystems allow, in bytes.
+const maxNameLength = 255
+
+// hostile transforms a name into an awkward one. Each is given the random
+// source, for transforms that make choices of their own.
+var hostile = []func(r io.Reader, name string) (string, error){
+	// spaces within, before and after
+	func(r io.Reader, name string) (string, error) {
+		other, err := randomWord(r)
+		return name + " " + other, err
+	},
+	func(_ io.Reader, name string) (string, error) { return " " + name, nil },
+	func(_ io.Reader, name string) (string, error) { return name + " ", nil },
+	func(_ io.Reader, _ string) (string, error) { return "   ", nil },
+	// combining characters, so the name isn't in normalization form C
+	func(_ io.Reader, name string) (string, error) {
+		var sb strings.Builder
+		for _, c := range name {
+			sb.WriteRune(c)
+			sb.WriteRune('\u0301')
+		}
+		return sb.String(), nil
+	},
+	func(_ io.Reader, name string) (string, error) { return "e\u0301" + name, nil },
+	// the longest name allowed
+	func(r io.Reader, name string) (string, error) {
+		for len(name) < maxNameLength {
+			other, err := randomWord(r)
+			if err != nil {
+				return "", err
+			}
+			name += other
+		}
+		// cut on a character boundary
+		for len(name) > maxNameLength || !utf8.ValidString(name) {
+			name = name[:len(name)-1]
+		}
+		return name, nil
+	},
+	// dots
+	func(_ io.Reader, name string) (string, error) { return "." + name, nil },
+	func(_ io.Reader, name string) (string, error) { return ".." + name, nil },
+	func(_ io.Reader, name string) (string, error) { return name + ".", nil },
+	func(_ io.Reader, _ string) (string, error) { return "...", nil },
+	// leading dashes, read as flags by command line tools
+	func(_ io.Reader, name string) (string, error) { return "-" + name, nil },
+	func(_ io.Reader, name string) (string, error) { return "--" + name, nil },
+	// metacharacters of shells, URLs and other filesystems
+	func(r io.Reader, name string) (string, error) {
+		i, err := getRandomIndex(r, len(metacharacters))
+		return name + metacharacters[i], err
+	},
+	func(r io.Reader, name string) (string, error) {
+		i, err := getRandomIndex(r, len(metacharacters))
+		return metacharacters[i] + name, err
+	},
+	// control and invisible characters
+	func(r io.Reader, name string) (string, error) {
+		i, err := getRandomIndex(r, len(invisible))
+		if err != nil {
+			return "", err
+		}
+		at, err := getRandomIndex(r, len(name)+1)
+		if err != nil {
+			return "", err
+		}
+		for at < len(name) && !utf8.RuneStart(name[at]) {
+			at--
+		}
+		return name[:at] + invisible[i] + name[at:], nil
+	},
+	// characters outside the basic multilingual plane
+	func(_ io.Reader, name string) (string, error) { return name + "\U0001F600", nil },
+	// names reserved on Windows
+	func(r io.Reader, _ string) (string, error) {
+		i, err := getRandomIndex(r, len(reserved))
+		return reserved[i], err
+	},
+}
+
+var metacharacters = []string{
+	"\\", "\"", "'", "`", "*", "?", "[", "]", "{", "}", "$", "~", "!", "&", ";", "|",
+	"<", ">", ":", "#", "%", "%2F", "%00", "+", "=", "@",
+}
+
+var invisible = []string{
+	"\t", "\n", "\r", "\x1b", "\x7f", "\u00a0", "\u200b", "\u200d", "\u202e", "\ufeff",
+}
+
+var reserved = []string{"CON", "PRN", "AUX", "NUL", "COM1", "LPT1", "con.txt", "nul.json"}
+
+// maybeHostile returns name or, half of the time, a hostile version of it.
+func maybeHostile(r io.Reader, name string) (string, error) {
+	i, err := getRandomIndex(r, 2*len(hostile))
+	if err != nil || i >= len(hostile) {
+		return name, err
+	}
+	return hostile[i](r, name)
+}
@@ -18,36 +18,88 @@ func getRandomIndex(r io.Reader, max int) (int, error) {
 	return int(n % uint32(max)), nil
 }
 
+// Option configures the names generated.
+type Option func(*options)
+
+type options struct {
+	hostile bool
+	// stable is the version of stable name generation, or 0 for none
+	stable int
+}
+
+// WithHostileNames makes about half of the names generated awkward ones, for
+// testing how names are handled, escaped and compared: names with spaces,
+// combining characters, control and invisible characters, shell and URL
+// metacharacters, leading and trailing dots, and names of 255 bytes. Names
+// never contain "/", and are never empty, "." or "..".
+func WithHostileNames() Option {
+	return func(o *options) {
+		o.hostile = true
+	}
+}
+
+func applyOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func randomWord(r io.Reader) (string, error) {
+	return pick(r, words)
+}
+
+func pick(r io.Reader, list []string) (string, error) {
+	index, err := getRandomIndex(r, len(list))
+	if err != nil {
+		return "", err
+	}
+	return list[index], nil
+}
+
 // RandomDirectoryName returns a random directory name from the provided word list.
-func RandomDirectoryName(r io.Reader) (string, error) {
-	index, err := getRandomIndex(r, len(words))
+func RandomDirectoryName(r io.Reader, opts ...Option) (string, error) {
+	o := applyOptions(opts)
+	d, err := o.dictionary()
 	if err != nil {
 		return "", err
 	}
-	return words[index], nil
+	name, err := pick(r, d.words)
+	if err != nil {
+		return "", err
+	}
+	if o.hostile {
+		return maybeHostile(r, name)
+	}
+	return name, nil
 }
 
 // RandomFileName returns a random file name with an extension from the provided word list and common extensions.
-func RandomFileName(r io.Reader) (string, error) {
-	wordIndex, err := getRandomIndex(r, len(words))
+func RandomFileName(r io.Reader, opts ...Option) (string, error) {
+	o := applyOptions(opts)
+	d, err := o.dictionary()
 	if err != nil {
 		return "", err
 	}
-	ext, err := RandomFileExtension(r)
+	word, err := pick(r, d.words)
 	if err != nil {
 		return "", err
 	}
-	return words[wordIndex] + ext, nil
+	ext, err := pick(r, d.extensions)
+	if err != nil {
+		return "", err
+	}
+	if o.hostile {
+		return maybeHostile(r, word+ext)
+	}
+	return word + ext, nil
 }
 
 // RandomFileExtension returns a random file extension, including '.'. This may
 // also return an empty string.
 func RandomFileExtension(r io.Reader) (string, error) {
-	index, err := getRandomIndex(r, len(extensions))
-	if err != nil {
-		return "", err
-	}
-	return extensions[index], nil
+	return pick(r, extensions)
 }
 
 const wordData = `jabberwocky Snark whiffling borogoves mome raths brillig slithy toves outgrabe
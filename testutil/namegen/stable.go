@@ -0,0 +1,99 @@
+package namegen
+
+import (
+	_ "embed"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// StableV1 is the first version of stable name generation, for use with
+// WithStableNames.
+//
+// In version 1, each name is made from big-endian uint32 values read from the
+// reader in turn, each taken modulo the length of the list it picks from. A
+// directory name is a word from words_v1.txt, split on white space. A file
+// name is such a word followed by one of the extensions "", ".txt", ".pdf",
+// ".docx", ".png", ".jpg", ".csv", ".json" and ".xml", in that order, picked
+// with a second value. Neither list will change.
+const StableV1 = 1
+
+//go:embed words_v1.txt
+var wordDataV1 string
+
+// dictionary is a versioned, frozen set of lists to pick names from.
+type dictionary struct {
+	words      []string
+	extensions []string
+}
+
+var stable = map[int]dictionary{
+	StableV1: {
+		words:      strings.Fields(wordDataV1),
+		extensions: []string{"", ".txt", ".pdf", ".docx", ".png", ".jpg", ".csv", ".json", ".xml"},
+	},
+}
+
+// WithStableNames picks names as the given version of stable name generation
+// does, such as StableV1, rather than from the current word list, which may
+// change between releases. Given the same bytes to read, a version always
+// returns the same names, so that fixtures generated from a seed, with
+// NewSeededReader, keep their paths. Generating a name with an unknown
+// version is an error.
+//
+// Hostile names, with WithHostileNames, are not part of a stable version.
+func WithStableNames(version int) Option {
+	return func(o *options) {
+		o.stable = version
+	}
+}
+
+// dictionary returns the lists to pick names from.
+func (o options) dictionary() (dictionary, error) {
+	if o.stable == 0 {
+		return dictionary{words: words, extensions: extensions}, nil
+	}
+	d, ok := stable[o.stable]
+	if !ok {
+		return dictionary{}, fmt.Errorf("namegen: unknown stable names version %d", o.stable)
+	}
+	return d, nil
+}
+
+// NewSeededReader returns an endless reader of pseudo-random bytes from seed,
+// for generating the same names with WithStableNames wherever and whenever
+// they are generated. The bytes are the outputs of SplitMix64 from seed, each
+// written big-endian, and will not change.
+func NewSeededReader(seed uint64) io.Reader {
+	return &splitMix64{state: seed}
+}
+
+type splitMix64 struct {
+	state uint64
+	buf   [8]byte
+	// off is the offset of the next unread byte in buf
+	off int
+}
+
+func (s *splitMix64) Read(p []byte) (int, error) {
+	var n int
+	for n < len(p) {
+		if s.off == 0 || s.off == len(s.buf) {
+			binary.BigEndian.PutUint64(s.buf[:], s.next())
+			s.off = 0
+		}
+		c := copy(p[n:], s.buf[s.off:])
+		s.off += c
+		n += c
+	}
+	return n, nil
+}
+
+func (s *splitMix64) next() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
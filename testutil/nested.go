@@ -0,0 +1,82 @@
+package testutil
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-unixfsnode/testutil/namegen"
+	"github.com/ipld/go-ipld-prime/linking"
+)
+
+// defaultNestedShardBitwidth is used by NestedShardedDirectory where
+// WithShardBitwidth is not set, it's small enough that modest numbers of
+// entries will produce multi-level shards.
+const defaultNestedShardBitwidth = 3
+
+// NestedShardedDirectory generates a directory tree where every directory,
+// at every level, is HAMT sharded with a small width. Each directory contains
+// entriesPerLevel small random files, and every directory above the bottom
+// level also contains two sharded subdirectories, to a total depth of
+// `levels` directories. This gives traversal code coverage of sharded
+// directories nested inside sharded directories, rather than only ever seeing
+// a sharded root.
+//
+// The WithShardBitwidth option can be used to control the shard width; by
+// default a small width is used so that each HAMT has multiple levels of
+// shards for relatively small numbers of entries.
+func NestedShardedDirectory(lsys linking.LinkSystem, levels int, entriesPerLevel int, opts ...Option) (DirEntry, error) {
+	o := applyOptions(opts)
+	bitwidth := o.shardBitwidth
+	if bitwidth == 0 {
+		bitwidth = defaultNestedShardBitwidth
+	}
+	return nestedShardedDirectory(lsys, o, bitwidth, o.dirname, levels, entriesPerLevel)
+}
+
+func nestedShardedDirectory(lsys linking.LinkSystem, o *options, bitwidth int, dir string, levels int, entries int) (DirEntry, error) {
+	children := make([]DirEntry, 0, entries+2)
+	nextName := func(isDir bool) (string, error) {
+		genName := namegen.RandomFileName
+		if isDir {
+			genName = namegen.RandomDirectoryName
+		}
+		name, err := genName(o.randReader, o.nameOpts...)
+		if err != nil {
+			return "", err
+		}
+		if isDupe(children, name) {
+			name = fmt.Sprintf("%d-%s", len(children), name)
+		}
+		return name, nil
+	}
+	if levels > 1 {
+		for i := 0; i < 2; i++ {
+			name, err := nextName(true)
+			if err != nil {
+				return DirEntry{}, err
+			}
+			child, err := nestedShardedDirectory(lsys, o, bitwidth, dir+"/"+name, levels-1, entries)
+			if err != nil {
+				return DirEntry{}, err
+			}
+			children = append(children, child)
+		}
+	}
+	for i := 0; i < entries; i++ {
+		name, err := nextName(false)
+		if err != nil {
+			return DirEntry{}, err
+		}
+		child, err := UnixFSFile(lsys, 1+rndInt(o.randReader, 1024), WithRandReader(o.randReader), WithChunker(o.chunker))
+		if err != nil {
+			return DirEntry{}, err
+		}
+		child.Path = dir + "/" + name
+		children = append(children, child)
+	}
+	dirEntry, err := packDirectory(lsys, children, bitwidth)
+	if err != nil {
+		return DirEntry{}, err
+	}
+	dirEntry.Path = dir
+	return dirEntry, nil
+}
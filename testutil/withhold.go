@@ -0,0 +1,84 @@
+package testutil
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// withholder decides which generated blocks are left out of the LinkSystem.
+// Decisions are remembered, so a block that is generated more than once is
+// always treated the same way and only reported once.
+type withholder struct {
+	withhold func(cid.Cid) bool
+	decided  map[cid.Cid]bool
+	withheld *[]cid.Cid
+}
+
+// WithWithheldBlocks causes UnixFSFile and UnixFSDirectory to build the full
+// DAG, but to leave approximately `percent`% of its blocks out of the
+// LinkSystem, appending the CIDs of the blocks withheld to *withheld. This
+// produces partial DAGs for exercising missing-block errors and enumeration
+// that tolerates gaps. Blocks are chosen by their CID rather than from the
+// random reader, so the DAG generated, and the DirEntry describing it, are
+// the same as they would be without this option; the root may be withheld
+// like any other block.
+func WithWithheldBlocks(percent int, withheld *[]cid.Cid) Option {
+	return WithWithholdFunc(func(c cid.Cid) bool {
+		digest := c.Hash()
+		return int(binary.BigEndian.Uint16(digest[len(digest)-2:]))%100 < percent
+	}, withheld)
+}
+
+// WithWithholdFunc is like WithWithheldBlocks, but withholds exactly the
+// blocks for which withhold returns true, such as the root, all leaves, or a
+// list of CIDs chosen in advance.
+func WithWithholdFunc(withhold func(c cid.Cid) bool, withheld *[]cid.Cid) Option {
+	return func(o *options) {
+		o.withhold = withhold
+		o.withheld = withheld
+	}
+}
+
+func newWithholder(withhold func(cid.Cid) bool, withheld *[]cid.Cid) *withholder {
+	return &withholder{
+		withhold: withhold,
+		decided:  make(map[cid.Cid]bool),
+		withheld: withheld,
+	}
+}
+
+// wrap returns lsys with its writes filtered by the withholder. Blocks are
+// withheld by not committing them, so they are never stored.
+func (w *withholder) wrap(lsys linking.LinkSystem) linking.LinkSystem {
+	if w == nil {
+		return lsys
+	}
+	swo := lsys.StorageWriteOpener
+	lsys.StorageWriteOpener = func(linkCtx ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+		wr, commit, err := swo(linkCtx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return wr, func(lnk ipld.Link) error {
+			c := lnk.(cidlink.Link).Cid
+			withhold, ok := w.decided[c]
+			if !ok {
+				withhold = w.withhold(c)
+				w.decided[c] = withhold
+				if withhold && w.withheld != nil {
+					*w.withheld = append(*w.withheld, c)
+				}
+			}
+			if withhold {
+				return nil
+			}
+			return commit(lnk)
+		}, nil
+	}
+	return lsys
+}
@@ -0,0 +1,55 @@
+package trustless
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// WriteCAR writes a CARv1 with root as its root to w, holding exactly the
+// blocks that Verify needs to prove the UnixFS path from root, and the byte
+// range if one is given, in the order it needs them, each once. Blocks are
+// loaded from lsys and written as they are loaded, so the CAR is streamed
+// rather than assembled in memory. It is the server side of Verify: a
+// stream written by WriteCAR verifies with the same arguments.
+func WriteCAR(ctx context.Context, lsys *ipld.LinkSystem, root cid.Cid, path string, byteRange *ByteRange, w io.Writer) error {
+	car, err := storage.NewWritable(w, []cid.Cid{root}, carv2.WriteAsCarV1(true))
+	if err != nil {
+		return err
+	}
+	written := make(map[cid.Cid]struct{})
+	open := func(lnkCtx linking.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		block, err := lsys.LoadRaw(lnkCtx, lnk)
+		if err != nil {
+			return nil, err
+		}
+		c := lnk.(cidlink.Link).Cid
+		if _, ok := written[c]; !ok {
+			if err := car.Put(ctx, c.KeyString(), block); err != nil {
+				return nil, err
+			}
+			written[c] = struct{}{}
+		}
+		return bytes.NewReader(block), nil
+	}
+	err = traversePath(ctx, open, root, path, unixfsnode.MatchUnixFSEntitySelector, func(_ cid.Cid, n datamodel.Node) error {
+		// load what Verify loads for the target, without keeping the content
+		if lbn, ok := n.(datamodel.LargeBytesNode); ok {
+			return readRange(io.Discard, lbn, byteRange)
+		}
+		return collect(&Result{}, n, byteRange)
+	})
+	if err != nil {
+		return err
+	}
+	return car.Finalize()
+}
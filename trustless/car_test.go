@@ -0,0 +1,58 @@
+package trustless_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/trustless"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCAR(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	for _, tc := range []struct {
+		path      string
+		byteRange *trustless.ByteRange
+	}{
+		{"", nil},
+		{"file", nil},
+		{"file", &trustless.ByteRange{From: 10000, To: 20000}},
+		{"file", &trustless.ByteRange{From: -5000, To: 1 << 30}},
+		{"link", nil},
+		{"sharded", nil},
+		{"sharded" + f.sharded.Children[0].Path, nil},
+	} {
+		var buf bytes.Buffer
+		require.NoError(t, trustless.WriteCAR(ctx, &f.ls, f.root, tc.path, tc.byteRange, &buf))
+
+		var expected, written []cid.Cid
+		for _, blk := range *f.stream(t, tc.path, tc.byteRange) {
+			expected = append(expected, blk.Cid())
+		}
+		cr, err := carv2.NewBlockReader(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		require.Equal(t, []cid.Cid{f.root}, cr.Roots)
+		for {
+			blk, err := cr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			written = append(written, blk.Cid())
+		}
+		require.Equal(t, expected, written, tc.path)
+
+		cr, err = carv2.NewBlockReader(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		_, err = trustless.Verify(ctx, f.root, tc.path, tc.byteRange, cr)
+		require.NoError(t, err, tc.path)
+	}
+
+	var buf bytes.Buffer
+	require.Error(t, trustless.WriteCAR(ctx, &f.ls, f.root, "missing", nil, &buf))
+}
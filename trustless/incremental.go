@@ -0,0 +1,434 @@
+package trustless
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/hamt"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// ErrNotVerified is returned by IncrementalReader.ReadAt when part of the
+// requested range has not been received and verified yet.
+var ErrNotVerified = errors.New("range has not been verified yet")
+
+// ErrPendingFull is returned by IncrementalReader.AddBlock for a block that
+// no verified block links to yet, when holding it would take the blocks held
+// past the reader's pending limit.
+var ErrPendingFull = errors.New("too many bytes of blocks waiting for their parents")
+
+// DefaultPendingLimit is the number of bytes of blocks an IncrementalReader
+// holds while waiting for their parents, unless WithPendingLimit is given.
+const DefaultPendingLimit = 64 << 20
+
+// IncrementalReader accepts the blocks of a UnixFS DAG in any order as they
+// arrive from an untrusted source, and exposes the parts of the file, or
+// entries of the directory, that have been verified so far.
+//
+// Every block is hashed against its CID when it is added. A block is only
+// slotted into the DAG once a verified parent links to it; blocks that arrive
+// before their parent are held until the parent arrives, up to a limit in
+// bytes, beyond which AddBlock returns ErrPendingFull, so that a source can't
+// grow the reader without bound with blocks that are never linked.
+//
+// IncrementalReader is safe for concurrent use, so blocks may be added by one
+// goroutine while another reads verified content.
+type IncrementalReader struct {
+	lk sync.Mutex
+
+	root cid.Cid
+	// have holds the blocks placed in the DAG, and pending those no verified
+	// block links to yet
+	have         map[cid.Cid][]byte
+	pending      map[cid.Cid][]byte
+	pendingBytes int64
+	pendingLimit int64
+	wanted       map[cid.Cid][]slot
+	segments     []segment
+	entries      []Entry
+	size         int64
+	rootSeen     bool
+	isDir        bool
+}
+
+// slot is a position in the DAG that a block is expected to fill.
+type slot struct {
+	// offset of the block's content within the file
+	offset int64
+	// expected content size of the block, or -1 if unknown
+	size int64
+	// the block is expected to be a HAMT shard of a directory
+	shard bool
+}
+
+type segment struct {
+	offset int64
+	data   []byte
+}
+
+// IncrementalOption configures an IncrementalReader.
+type IncrementalOption func(*IncrementalReader)
+
+// WithPendingLimit sets the number of bytes of blocks held while waiting for
+// their parents, DefaultPendingLimit by default. With a limit of zero, blocks
+// must arrive after their parents; a negative limit holds any number.
+func WithPendingLimit(bytes int64) IncrementalOption {
+	return func(r *IncrementalReader) {
+		r.pendingLimit = bytes
+	}
+}
+
+// NewIncrementalReader creates an IncrementalReader for the UnixFS DAG with
+// the given root.
+func NewIncrementalReader(root cid.Cid, opts ...IncrementalOption) *IncrementalReader {
+	r := &IncrementalReader{
+		root:         root,
+		have:         make(map[cid.Cid][]byte),
+		pending:      make(map[cid.Cid][]byte),
+		pendingLimit: DefaultPendingLimit,
+		wanted:       map[cid.Cid][]slot{root: {{size: -1}}},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// AddBlock hashes the block and, if it belongs to the DAG, slots it into its
+// position, or holds it until its parent arrives. Blocks which have already
+// been added are ignored. An error is returned if the block does not match
+// its CID, if it cannot be placed in the position its parent expects, or,
+// as ErrPendingFull, if it can't be held.
+func (r *IncrementalReader) AddBlock(blk blocks.Block) error {
+	c := blk.Cid()
+	sum, err := c.Prefix().Sum(blk.RawData())
+	if err != nil {
+		return err
+	}
+	if !sum.Equals(c) {
+		return ipld.ErrHashMismatch{Actual: cidlink.Link{Cid: sum}, Expected: cidlink.Link{Cid: c}}
+	}
+
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	if _, ok := r.have[c]; ok {
+		return nil
+	}
+	if _, ok := r.pending[c]; ok {
+		return nil
+	}
+	slots, ok := r.wanted[c]
+	if !ok {
+		size := int64(len(blk.RawData()))
+		if r.pendingLimit >= 0 && r.pendingBytes+size > r.pendingLimit {
+			return fmt.Errorf("%s: %w", c, ErrPendingFull)
+		}
+		r.pending[c] = blk.RawData()
+		r.pendingBytes += size
+		return nil
+	}
+	r.have[c] = blk.RawData()
+	delete(r.wanted, c)
+	return r.place(c, blk.RawData(), slots)
+}
+
+// Consume adds every block from the stream until it is exhausted.
+func (r *IncrementalReader) Consume(stream BlockReader) error {
+	for {
+		blk, err := stream.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := r.AddBlock(blk); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *IncrementalReader) want(c cid.Cid, s slot) error {
+	if raw, ok := r.have[c]; ok {
+		return r.place(c, raw, []slot{s})
+	}
+	if raw, ok := r.pending[c]; ok {
+		delete(r.pending, c)
+		r.pendingBytes -= int64(len(raw))
+		r.have[c] = raw
+		return r.place(c, raw, []slot{s})
+	}
+	r.wanted[c] = append(r.wanted[c], s)
+	return nil
+}
+
+func (r *IncrementalReader) place(c cid.Cid, raw []byte, slots []slot) error {
+	isRoot := c.Equals(r.root) && !r.rootSeen
+	if isRoot {
+		r.rootSeen = true
+	}
+
+	if c.Prefix().Codec == cid.Raw {
+		for _, s := range slots {
+			if s.shard {
+				return fmt.Errorf("%s: expected a HAMT shard, got a raw block", c)
+			}
+			if s.size >= 0 && s.size != int64(len(raw)) {
+				return fmt.Errorf("%s: raw block is %d bytes, parent expects %d", c, len(raw), s.size)
+			}
+			r.addSegment(s.offset, raw)
+		}
+		if isRoot {
+			r.size = int64(len(raw))
+		}
+		return nil
+	}
+	if c.Prefix().Codec != cid.DagProtobuf {
+		return fmt.Errorf("%s: unsupported codec 0x%x", c, c.Prefix().Codec)
+	}
+
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagpb.DecodeBytes(nb, raw); err != nil {
+		return fmt.Errorf("%s: %w", c, err)
+	}
+	pbn := nb.Build().(dagpb.PBNode)
+	if !pbn.FieldData().Exists() {
+		return fmt.Errorf("%s: %w", c, hamt.ErrNoDataField)
+	}
+	ufsData, err := data.DecodeUnixFSData(pbn.FieldData().Must().Bytes())
+	if err != nil {
+		return fmt.Errorf("%s: %w", c, err)
+	}
+
+	switch dataType := ufsData.FieldDataType().Int(); dataType {
+	case data.Data_File, data.Data_Raw, data.Data_Symlink:
+		if isRoot {
+			r.size = fileSize(ufsData)
+		}
+		for _, s := range slots {
+			if s.shard {
+				return fmt.Errorf("%s: expected a HAMT shard, got %s", c, data.DataTypeNames[dataType])
+			}
+			if err := r.placeFile(c, pbn, ufsData, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	case data.Data_Directory:
+		if !isRoot {
+			return fmt.Errorf("%s: unexpected directory inside a file or HAMT", c)
+		}
+		r.isDir = true
+		return r.addEntries(pbn, 0)
+	case data.Data_HAMTShard:
+		if !isRoot {
+			for _, s := range slots {
+				if !s.shard {
+					return fmt.Errorf("%s: unexpected HAMT shard inside a file", c)
+				}
+			}
+		}
+		if !ufsData.FieldFanout().Exists() {
+			return fmt.Errorf("%s: %w", c, hamt.ErrNoFanoutField)
+		}
+		r.isDir = true
+		fanout := ufsData.FieldFanout().Must().Int()
+		return r.addEntries(pbn, len(fmt.Sprintf("%X", fanout-1)))
+	default:
+		return fmt.Errorf("%s: %w", c, data.ErrInvalidDataType{DataType: dataType})
+	}
+}
+
+func fileSize(ufsData data.UnixFSData) int64 {
+	if ufsData.FieldFileSize().Exists() {
+		return ufsData.FieldFileSize().Must().Int()
+	}
+	if ufsData.FieldData().Exists() {
+		return int64(len(ufsData.FieldData().Must().Bytes()))
+	}
+	return 0
+}
+
+func (r *IncrementalReader) placeFile(c cid.Cid, pbn dagpb.PBNode, ufsData data.UnixFSData, s slot) error {
+	if s.size >= 0 && s.size != fileSize(ufsData) {
+		return fmt.Errorf("%s: file node has size %d, parent expects %d", c, fileSize(ufsData), s.size)
+	}
+	offset := s.offset
+	if ufsData.FieldData().Exists() {
+		inline := ufsData.FieldData().Must().Bytes()
+		r.addSegment(offset, inline)
+		offset += int64(len(inline))
+	}
+	if pbn.FieldLinks().Length() != ufsData.FieldBlockSizes().Length() {
+		return fmt.Errorf("%s: %d links but %d block sizes", c, pbn.FieldLinks().Length(), ufsData.FieldBlockSizes().Length())
+	}
+	links := pbn.FieldLinks().Iterator()
+	sizes := ufsData.FieldBlockSizes().Iterator()
+	for !links.Done() {
+		_, lnk := links.Next()
+		_, size := sizes.Next()
+		child, err := linkCid(lnk)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c, err)
+		}
+		if err := r.want(child, slot{offset: offset, size: size.Int()}); err != nil {
+			return err
+		}
+		offset += size.Int()
+	}
+	return nil
+}
+
+func (r *IncrementalReader) addEntries(pbn dagpb.PBNode, padLen int) error {
+	links := pbn.FieldLinks().Iterator()
+	for !links.Done() {
+		_, lnk := links.Next()
+		name := ""
+		if lnk.FieldName().Exists() {
+			name = lnk.FieldName().Must().String()
+		}
+		child, err := linkCid(lnk)
+		if err != nil {
+			return err
+		}
+		if padLen > 0 {
+			if len(name) < padLen {
+				return hamt.ErrInvalidLinkName{Name: name}
+			}
+			if len(name) == padLen {
+				if err := r.want(child, slot{shard: true}); err != nil {
+					return err
+				}
+				continue
+			}
+			name = name[padLen:]
+		}
+		r.entries = append(r.entries, Entry{Name: name, Cid: child})
+	}
+	return nil
+}
+
+func (r *IncrementalReader) addSegment(offset int64, byts []byte) {
+	if len(byts) == 0 {
+		return
+	}
+	i := sort.Search(len(r.segments), func(i int) bool { return r.segments[i].offset >= offset })
+	if i < len(r.segments) && r.segments[i].offset == offset {
+		return
+	}
+	r.segments = append(r.segments, segment{})
+	copy(r.segments[i+1:], r.segments[i:])
+	r.segments[i] = segment{offset: offset, data: byts}
+}
+
+// Size returns the size of the file, which is known once the root block has
+// been received.
+func (r *IncrementalReader) Size() (int64, bool) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return r.size, r.rootSeen
+}
+
+// IsDirectory reports whether the root has been received and is a directory.
+func (r *IncrementalReader) IsDirectory() bool {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return r.isDir
+}
+
+// Done reports whether every block of the DAG has been received and verified.
+func (r *IncrementalReader) Done() bool {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return r.rootSeen && len(r.wanted) == 0
+}
+
+// Missing returns the CIDs of blocks that are known to be part of the DAG but
+// have not been received yet.
+func (r *IncrementalReader) Missing() []cid.Cid {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	missing := make([]cid.Cid, 0, len(r.wanted))
+	for c := range r.wanted {
+		missing = append(missing, c)
+	}
+	return missing
+}
+
+// Entries returns the directory entries verified so far. For HAMT sharded
+// directories the entries are in the order their shards were received.
+func (r *IncrementalReader) Entries() []Entry {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return append([]Entry(nil), r.entries...)
+}
+
+// VerifiedRanges returns the byte ranges of the file which have been verified,
+// in order and with adjacent ranges merged.
+func (r *IncrementalReader) VerifiedRanges() []ByteRange {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	var ranges []ByteRange
+	for _, s := range r.segments {
+		end := s.offset + int64(len(s.data))
+		if n := len(ranges); n > 0 && ranges[n-1].To >= s.offset {
+			ranges[n-1].To = max(ranges[n-1].To, end)
+			continue
+		}
+		ranges = append(ranges, ByteRange{From: s.offset, To: end})
+	}
+	return ranges
+}
+
+// ReadAt reads verified bytes of the file starting at off. If any of the
+// requested bytes have not been verified yet, it reads up to the first such
+// byte and returns ErrNotVerified.
+func (r *IncrementalReader) ReadAt(p []byte, off int64) (int, error) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if r.rootSeen && off >= r.size {
+		return 0, io.EOF
+	}
+	if r.rootSeen && off+int64(len(p)) > r.size {
+		p = p[:r.size-off]
+		n, err := r.readAt(p, off)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	return r.readAt(p, off)
+}
+
+func (r *IncrementalReader) readAt(p []byte, off int64) (int, error) {
+	// find the last segment starting at or before off
+	i := sort.Search(len(r.segments), func(i int) bool { return r.segments[i].offset > off }) - 1
+	var n int
+	for ; n < len(p) && i >= 0 && i < len(r.segments); i++ {
+		s := r.segments[i]
+		pos := off + int64(n)
+		if s.offset > pos {
+			break
+		}
+		end := s.offset + int64(len(s.data))
+		if end <= pos {
+			continue
+		}
+		n += copy(p[n:], s.data[pos-s.offset:])
+	}
+	if n < len(p) {
+		return n, ErrNotVerified
+	}
+	return n, nil
+}
@@ -0,0 +1,180 @@
+package trustless_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipfs/go-unixfsnode/trustless"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func storedBlocks(t *testing.T, storage *cidlink.Memory, cids []cid.Cid) []blocks.Block {
+	var blks []blocks.Block
+	for _, c := range cids {
+		blk, err := blocks.NewBlockWithCid(storage.Bag[string(c.Hash())], c)
+		require.NoError(t, err)
+		blks = append(blks, blk)
+	}
+	return blks
+}
+
+func TestIncrementalReaderFile(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := &cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	content := random.Bytes(200 << 10)
+	var cids []cid.Cid
+	ls.StorageWriteOpener = func(lc ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+		w, commit, err := storage.OpenWrite(lc)
+		return w, func(l ipld.Link) error {
+			cids = append(cids, l.(cidlink.Link).Cid)
+			return commit(l)
+		}, err
+	}
+	root, _, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls)
+	require.NoError(t, err)
+	blks := storedBlocks(t, storage, cids)
+	rand.New(rand.NewSource(1234)).Shuffle(len(blks), func(i, j int) { blks[i], blks[j] = blks[j], blks[i] })
+
+	r := trustless.NewIncrementalReader(root.(cidlink.Link).Cid)
+	_, known := r.Size()
+	require.False(t, known)
+	buf := make([]byte, 10)
+	_, err = r.ReadAt(buf, 0)
+	require.ErrorIs(t, err, trustless.ErrNotVerified)
+
+	// leaves that arrive before their parents are not verified content yet
+	var added int
+	for ; added < len(blks)/2; added++ {
+		require.NoError(t, r.AddBlock(blks[added]))
+	}
+	var verified int64
+	for _, br := range r.VerifiedRanges() {
+		got := make([]byte, br.To-br.From)
+		_, err := r.ReadAt(got, br.From)
+		if err != nil {
+			require.ErrorIs(t, err, io.EOF)
+		}
+		require.Equal(t, content[br.From:br.To], got)
+		verified += br.To - br.From
+	}
+	require.False(t, r.Done())
+	require.NotEmpty(t, r.Missing())
+
+	for ; added < len(blks); added++ {
+		require.NoError(t, r.AddBlock(blks[added]))
+		// duplicates are ignored
+		require.NoError(t, r.AddBlock(blks[added]))
+	}
+	require.True(t, r.Done())
+	require.Empty(t, r.Missing())
+	size, known := r.Size()
+	require.True(t, known)
+	require.Equal(t, int64(len(content)), size)
+	require.Equal(t, []trustless.ByteRange{{From: 0, To: int64(len(content))}}, r.VerifiedRanges())
+	require.Greater(t, int64(len(content)), verified)
+
+	got := make([]byte, len(content)+10)
+	n, err := r.ReadAt(got, 0)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, len(content), n)
+	require.Equal(t, content, got[:n])
+	require.False(t, r.IsDirectory())
+}
+
+func TestIncrementalReaderDirectory(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := &cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	dir := testutil.GenerateDirectory(t, &ls, random.NewSeededRand(1234), 1<<20, true)
+
+	r := trustless.NewIncrementalReader(dir.Root)
+	// feed the directory's own blocks in reverse, so shards arrive before
+	// their parents
+	var blks []blocks.Block
+	for _, blk := range storedBlocks(t, storage, dir.SelfCids) {
+		blks = append([]blocks.Block{blk}, blks...)
+	}
+	stream := sliceReader(blks)
+	require.NoError(t, r.Consume(&stream))
+	require.True(t, r.Done())
+	require.True(t, r.IsDirectory())
+
+	names := make(map[string]cid.Cid)
+	for _, e := range r.Entries() {
+		names[e.Name] = e.Cid
+	}
+	require.Len(t, names, len(dir.Children))
+	for _, child := range dir.Children {
+		require.Equal(t, child.Root, names[child.Path[1:]])
+	}
+}
+
+func TestIncrementalReaderRejectsCorruptBlock(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := &cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	root, _, err := builder.BuildUnixFSFile(bytes.NewReader(random.Bytes(10)), "", &ls)
+	require.NoError(t, err)
+	c := root.(cidlink.Link).Cid
+	corrupt, err := blocks.NewBlockWithCid([]byte("not the content"), c)
+	require.NoError(t, err)
+
+	r := trustless.NewIncrementalReader(c)
+	var herr ipld.ErrHashMismatch
+	require.ErrorAs(t, r.AddBlock(corrupt), &herr)
+	require.False(t, r.Done())
+}
+
+func TestIncrementalReaderPendingLimit(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := &cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	var cids []cid.Cid
+	ls.StorageWriteOpener = func(lc ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+		w, commit, err := storage.OpenWrite(lc)
+		return w, func(l ipld.Link) error {
+			cids = append(cids, l.(cidlink.Link).Cid)
+			return commit(l)
+		}, err
+	}
+	content := random.Bytes(10 << 10)
+	root, _, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls)
+	require.NoError(t, err)
+	blks := storedBlocks(t, storage, cids)
+
+	// leaves are stored before the root, and only four fit while waiting
+	r := trustless.NewIncrementalReader(root.(cidlink.Link).Cid, trustless.WithPendingLimit(4<<10))
+	for _, blk := range blks[:4] {
+		require.NoError(t, r.AddBlock(blk))
+	}
+	require.ErrorIs(t, r.AddBlock(blks[4]), trustless.ErrPendingFull)
+
+	// once the root links to them, they no longer count against the limit
+	require.NoError(t, r.AddBlock(blks[len(blks)-1]))
+	for _, blk := range blks[4 : len(blks)-1] {
+		require.NoError(t, r.AddBlock(blk))
+	}
+	require.True(t, r.Done())
+
+	// blocks that nothing links to are never accepted beyond the limit
+	r = trustless.NewIncrementalReader(root.(cidlink.Link).Cid, trustless.WithPendingLimit(0))
+	require.ErrorIs(t, r.AddBlock(blks[0]), trustless.ErrPendingFull)
+	require.NoError(t, r.AddBlock(blks[len(blks)-1]))
+	require.NoError(t, r.AddBlock(blks[0]))
+}
@@ -0,0 +1,344 @@
+package trustless
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/hamt"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// position is the kind of place in a UnixFS DAG a block is expected to fill.
+type position int
+
+const (
+	// the root, or the target of a directory entry: any UnixFS node
+	entryPosition position = iota
+	// a child of a File node
+	filePosition
+	// a child shard of a HAMT
+	shardPosition
+)
+
+// expected is a block the stream is expected to contain, and where it goes.
+type expected struct {
+	cid cid.Cid
+	pos position
+	// content size a file child must have
+	size int64
+	// fanout and hash type a child shard must share with its parent
+	fanout   int64
+	hashType int64
+}
+
+// placed records what a block turned out to be, so that a block that appears
+// in several places can be checked against each without being sent again.
+type placed struct {
+	dataType int64 // -1 for raw blocks
+	size     int64
+	fanout   int64
+	hashType int64
+}
+
+// Ingester checks a stream of blocks claimed to be a whole UnixFS DAG, such as
+// a CAR exported by another implementation, in the depth-first order in which
+// a traversal of the DAG visits its blocks. Each block is hashed against the
+// link that leads to it, and checked against its place in the structure as it
+// arrives: the sizes of file leaves and File nodes against the block sizes of
+// their parents, child shards against the fanout and hash function of their
+// parents, and directories only where directory entries lead.
+//
+// A block that appears more than once in the DAG may be sent again, or left
+// out after the first time. Ingester is not safe for concurrent use.
+type Ingester struct {
+	stack  []expected
+	placed map[cid.Cid]placed
+	blocks int
+	bytes  uint64
+}
+
+// NewIngester creates an Ingester for the UnixFS DAG with the given root.
+func NewIngester(root cid.Cid) *Ingester {
+	return &Ingester{
+		stack:  []expected{{cid: root, pos: entryPosition, size: -1}},
+		placed: make(map[cid.Cid]placed),
+	}
+}
+
+// Next returns the CID of the block the stream should contain next, or false
+// if the DAG is complete.
+func (in *Ingester) Next() (cid.Cid, bool) {
+	// a repeated block that doesn't fit its place stays on the stack, and is
+	// reported by Add
+	_ = in.skipPlaced()
+	if len(in.stack) == 0 {
+		return cid.Undef, false
+	}
+	return in.stack[len(in.stack)-1].cid, true
+}
+
+// Complete reports whether every block of the DAG has been received.
+func (in *Ingester) Complete() bool {
+	_, more := in.Next()
+	return !more
+}
+
+// Blocks returns the number of distinct blocks received.
+func (in *Ingester) Blocks() int {
+	return in.blocks
+}
+
+// Bytes returns the total size of the distinct blocks received.
+func (in *Ingester) Bytes() uint64 {
+	return in.bytes
+}
+
+// skipPlaced pops expected blocks that were already received elsewhere in the
+// DAG, checking that they also fit their place here.
+func (in *Ingester) skipPlaced() error {
+	for len(in.stack) > 0 {
+		exp := in.stack[len(in.stack)-1]
+		p, ok := in.placed[exp.cid]
+		if !ok {
+			return nil
+		}
+		if err := p.fits(exp); err != nil {
+			return fmt.Errorf("%s: %w", exp.cid, err)
+		}
+		in.stack = in.stack[:len(in.stack)-1]
+	}
+	return nil
+}
+
+// Add checks the next block of the stream. A block that was already received
+// is ignored, any other must be the one Next returns. An error is returned if
+// the block is out of order, doesn't match its CID, or doesn't fit its place
+// in the DAG; the Ingester shouldn't be used after an error.
+func (in *Ingester) Add(blk blocks.Block) error {
+	c := blk.Cid()
+	if _, ok := in.placed[c]; ok {
+		return nil
+	}
+	if err := in.skipPlaced(); err != nil {
+		return err
+	}
+	if len(in.stack) == 0 {
+		return ErrExtraneousBlocks
+	}
+	exp := in.stack[len(in.stack)-1]
+	if !c.Equals(exp.cid) {
+		return ErrUnexpectedBlock{Expected: exp.cid, Received: c}
+	}
+	sum, err := c.Prefix().Sum(blk.RawData())
+	if err != nil {
+		return err
+	}
+	if !sum.Equals(c) {
+		return ipld.ErrHashMismatch{Actual: cidlink.Link{Cid: sum}, Expected: cidlink.Link{Cid: c}}
+	}
+	in.stack = in.stack[:len(in.stack)-1]
+
+	p, children, err := in.decode(c, blk.RawData())
+	if err != nil {
+		return fmt.Errorf("%s: %w", c, err)
+	}
+	if err := p.fits(exp); err != nil {
+		return fmt.Errorf("%s: %w", c, err)
+	}
+	in.placed[c] = p
+	in.blocks++
+	in.bytes += uint64(len(blk.RawData()))
+	// the first child is visited first, so it goes on top
+	for i := len(children) - 1; i >= 0; i-- {
+		in.stack = append(in.stack, children[i])
+	}
+	return nil
+}
+
+func (p placed) fits(exp expected) error {
+	switch exp.pos {
+	case filePosition:
+		if p.dataType != -1 && p.dataType != data.Data_File && p.dataType != data.Data_Raw {
+			return fmt.Errorf("expected part of a file, got %s", data.DataTypeNames[p.dataType])
+		}
+		if p.size != exp.size {
+			return fmt.Errorf("file part has size %d, parent expects %d", p.size, exp.size)
+		}
+	case shardPosition:
+		if p.dataType != data.Data_HAMTShard {
+			return fmt.Errorf("expected a HAMT shard")
+		}
+		if p.fanout != exp.fanout || p.hashType != exp.hashType {
+			return fmt.Errorf("HAMT shard has fanout %d and hash type 0x%x, parent has %d and 0x%x", p.fanout, p.hashType, exp.fanout, exp.hashType)
+		}
+	}
+	return nil
+}
+
+// decode checks a block on its own, and returns what it is and the blocks it
+// links to.
+func (in *Ingester) decode(c cid.Cid, raw []byte) (placed, []expected, error) {
+	switch c.Prefix().Codec {
+	case cid.Raw:
+		return placed{dataType: -1, size: int64(len(raw))}, nil, nil
+	case cid.DagProtobuf:
+	default:
+		return placed{}, nil, fmt.Errorf("unsupported codec 0x%x", c.Prefix().Codec)
+	}
+
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagpb.DecodeBytes(nb, raw); err != nil {
+		return placed{}, nil, err
+	}
+	pbn := nb.Build().(dagpb.PBNode)
+	if !pbn.FieldData().Exists() {
+		return placed{}, nil, hamt.ErrNoDataField
+	}
+	ufsData, err := data.DecodeUnixFSData(pbn.FieldData().Must().Bytes())
+	if err != nil {
+		return placed{}, nil, err
+	}
+	dataType := ufsData.FieldDataType().Int()
+	p := placed{dataType: dataType}
+	var children []expected
+	links := pbn.FieldLinks().Iterator()
+
+	switch dataType {
+	case data.Data_File, data.Data_Raw:
+		p.size = fileSize(ufsData)
+		if pbn.FieldLinks().Length() != ufsData.FieldBlockSizes().Length() {
+			return placed{}, nil, fmt.Errorf("%d links but %d block sizes", pbn.FieldLinks().Length(), ufsData.FieldBlockSizes().Length())
+		}
+		var total int64
+		if ufsData.FieldData().Exists() {
+			total = int64(len(ufsData.FieldData().Must().Bytes()))
+		}
+		sizes := ufsData.FieldBlockSizes().Iterator()
+		for !links.Done() {
+			_, lnk := links.Next()
+			_, size := sizes.Next()
+			child, err := linkCid(lnk)
+			if err != nil {
+				return placed{}, nil, err
+			}
+			children = append(children, expected{cid: child, pos: filePosition, size: size.Int()})
+			total += size.Int()
+		}
+		if total != p.size {
+			return placed{}, nil, fmt.Errorf("file size is %d, but its data and block sizes add up to %d", p.size, total)
+		}
+	case data.Data_Directory:
+		for !links.Done() {
+			_, lnk := links.Next()
+			child, err := linkCid(lnk)
+			if err != nil {
+				return placed{}, nil, err
+			}
+			children = append(children, expected{cid: child, pos: entryPosition, size: -1})
+		}
+	case data.Data_HAMTShard:
+		if !ufsData.FieldFanout().Exists() {
+			return placed{}, nil, hamt.ErrNoFanoutField
+		}
+		if !ufsData.FieldHashType().Exists() {
+			return placed{}, nil, fmt.Errorf("'HashType' field not present")
+		}
+		p.fanout = ufsData.FieldFanout().Must().Int()
+		p.hashType = ufsData.FieldHashType().Must().Int()
+		padLen := len(fmt.Sprintf("%X", p.fanout-1))
+		for !links.Done() {
+			_, lnk := links.Next()
+			child, err := linkCid(lnk)
+			if err != nil {
+				return placed{}, nil, err
+			}
+			if !lnk.FieldName().Exists() {
+				return placed{}, nil, hamt.ErrMissingLinkName
+			}
+			name := lnk.FieldName().Must().String()
+			switch {
+			case len(name) < padLen:
+				return placed{}, nil, hamt.ErrInvalidLinkName{Name: name}
+			case len(name) == padLen:
+				children = append(children, expected{cid: child, pos: shardPosition, fanout: p.fanout, hashType: p.hashType})
+			default:
+				children = append(children, expected{cid: child, pos: entryPosition, size: -1})
+			}
+		}
+	case data.Data_Symlink:
+		if pbn.FieldLinks().Length() > 0 {
+			return placed{}, nil, fmt.Errorf("symlink has links")
+		}
+	case data.Data_Metadata:
+		if n := pbn.FieldLinks().Length(); n != 1 {
+			return placed{}, nil, fmt.Errorf("metadata must have a single link, not %d", n)
+		}
+		child, err := linkCid(pbn.FieldLinks().Lookup(0))
+		if err != nil {
+			return placed{}, nil, err
+		}
+		children = append(children, expected{cid: child, pos: entryPosition, size: -1})
+	default:
+		return placed{}, nil, data.ErrInvalidDataType{DataType: dataType}
+	}
+	return p, children, nil
+}
+
+func linkCid(lnk dagpb.PBLink) (cid.Cid, error) {
+	cl, ok := lnk.FieldHash().Link().(cidlink.Link)
+	if !ok {
+		return cid.Undef, fmt.Errorf("unsupported link type: %T", lnk.FieldHash().Link())
+	}
+	return cl.Cid, nil
+}
+
+// IngestResult describes a DAG checked by Ingest.
+type IngestResult struct {
+	// Blocks is the number of distinct blocks in the DAG.
+	Blocks int
+	// Bytes is the total size of the distinct blocks in the DAG.
+	Bytes uint64
+}
+
+// Ingest consumes the stream with an Ingester, calling onBlock, if set, with
+// each block once it has been checked, such as to store it. It returns
+// ErrMissingBlock if the stream ends before the DAG is complete, and
+// ErrExtraneousBlocks if blocks other than repeats follow the end of the DAG.
+func Ingest(ctx context.Context, root cid.Cid, stream BlockReader, onBlock func(blocks.Block) error) (*IngestResult, error) {
+	in := NewIngester(root)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		blk, err := stream.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		before := in.Blocks()
+		if err := in.Add(blk); err != nil {
+			return nil, err
+		}
+		if onBlock != nil && in.Blocks() > before {
+			if err := onBlock(blk); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := in.skipPlaced(); err != nil {
+		return nil, err
+	}
+	if next, more := in.Next(); more {
+		return nil, ErrMissingBlock{Cid: next}
+	}
+	return &IngestResult{Blocks: in.Blocks(), Bytes: in.Bytes()}, nil
+}
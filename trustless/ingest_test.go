@@ -0,0 +1,159 @@
+package trustless_test
+
+import (
+	"context"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/dagwalk"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/trustless"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/stretchr/testify/require"
+)
+
+// dfsBlocks returns the blocks of the DAG under root in depth-first order.
+func dfsBlocks(t *testing.T, ls *ipld.LinkSystem, storage *cidlink.Memory, root cid.Cid) []blocks.Block {
+	var cids []cid.Cid
+	err := dagwalk.Enumerate(context.Background(), ls, cidlink.Link{Cid: root}, func(c cid.Cid, _ int) error {
+		cids = append(cids, c)
+		return nil
+	})
+	require.NoError(t, err)
+	return storedBlocks(t, storage, cids)
+}
+
+func TestIngest(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	blks := dfsBlocks(t, &f.ls, f.storage, f.root)
+
+	var received []blocks.Block
+	stream := sliceReader(append([]blocks.Block(nil), blks...))
+	res, err := trustless.Ingest(ctx, f.root, &stream, func(blk blocks.Block) error {
+		received = append(received, blk)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, len(blks), res.Blocks)
+	require.Equal(t, blks, received)
+	var size uint64
+	for _, blk := range blks {
+		size += uint64(len(blk.RawData()))
+	}
+	require.Equal(t, size, res.Bytes)
+
+	// blocks that were already received may be repeated
+	repeated := sliceReader(append(append(append([]blocks.Block(nil), blks[:5]...), blks[1], blks[0]), blks[5:]...))
+	res, err = trustless.Ingest(ctx, f.root, &repeated, nil)
+	require.NoError(t, err)
+	require.Equal(t, len(blks), res.Blocks)
+
+	in := trustless.NewIngester(f.root)
+	for i, blk := range blks {
+		next, ok := in.Next()
+		require.True(t, ok)
+		require.Equal(t, blk.Cid(), next)
+		require.False(t, in.Complete())
+		require.NoError(t, in.Add(blk), i)
+	}
+	require.True(t, in.Complete())
+	require.ErrorIs(t, in.Add(blocks.NewBlock([]byte("extra"))), trustless.ErrExtraneousBlocks)
+}
+
+func TestIngestRejects(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	blks := dfsBlocks(t, &f.ls, f.storage, f.root)
+
+	truncated := sliceReader(append([]blocks.Block(nil), blks[:len(blks)-1]...))
+	_, err := trustless.Ingest(ctx, f.root, &truncated, nil)
+	var missing trustless.ErrMissingBlock
+	require.ErrorAs(t, err, &missing)
+	require.Equal(t, blks[len(blks)-1].Cid(), missing.Cid)
+
+	swapped := sliceReader(append([]blocks.Block(nil), blks...))
+	swapped[3], swapped[4] = swapped[4], swapped[3]
+	_, err = trustless.Ingest(ctx, f.root, &swapped, nil)
+	var unexpected trustless.ErrUnexpectedBlock
+	require.ErrorAs(t, err, &unexpected)
+	require.Equal(t, blks[3].Cid(), unexpected.Expected)
+
+	corrupt := sliceReader(append([]blocks.Block(nil), blks...))
+	corrupt[2], err = blocks.NewBlockWithCid([]byte("corrupt"), blks[2].Cid())
+	require.NoError(t, err)
+	_, err = trustless.Ingest(ctx, f.root, &corrupt, nil)
+	require.ErrorAs(t, err, &ipld.ErrHashMismatch{})
+
+	extra := sliceReader(append(append([]blocks.Block(nil), blks...), blocks.NewBlock([]byte("extra"))))
+	_, err = trustless.Ingest(ctx, f.root, &extra, nil)
+	require.ErrorIs(t, err, trustless.ErrExtraneousBlocks)
+
+	// a File node whose block sizes don't match its leaves
+	ls := cidlink.DefaultLinkSystem()
+	storage := &cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+	leaf, err := ls.Store(ipld.LinkContext{}, builder.DefaultLeafLinkPrototype, basicnode.NewBytes([]byte("hello")))
+	require.NoError(t, err)
+	ufsd, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.DataType(b, data.Data_File)
+		builder.FileSize(b, 12)
+		builder.BlockSizes(b, []uint64{6, 6})
+	})
+	require.NoError(t, err)
+	pbb := dagpb.Type.PBNode.NewBuilder()
+	pbm, err := pbb.BeginMap(2)
+	require.NoError(t, err)
+	require.NoError(t, pbm.AssembleKey().AssignString("Data"))
+	require.NoError(t, pbm.AssembleValue().AssignBytes(data.EncodeUnixFSData(ufsd)))
+	la, err := pbm.AssembleEntry("Links")
+	require.NoError(t, err)
+	links, err := la.BeginList(2)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		lnk, err := builder.BuildUnixFSDirectoryEntry("", 5, leaf)
+		require.NoError(t, err)
+		require.NoError(t, links.AssembleValue().AssignNode(lnk))
+	}
+	require.NoError(t, links.Finish())
+	require.NoError(t, pbm.Finish())
+	root, err := ls.Store(ipld.LinkContext{}, builder.DefaultInteriorLinkPrototype, pbb.Build())
+	require.NoError(t, err)
+	rootCid := root.(cidlink.Link).Cid
+	bad := sliceReader(storedBlocks(t, storage, []cid.Cid{rootCid, leaf.(cidlink.Link).Cid}))
+	_, err = trustless.Ingest(ctx, rootCid, &bad, nil)
+	require.ErrorContains(t, err, "parent expects 6")
+}
+
+func TestIngestMetadata(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	md, mdSize, err := builder.BuildUnixFSMetadata("application/octet-stream", cidlink.Link{Cid: f.file}, uint64(len(f.content)), &f.ls)
+	require.NoError(t, err)
+	entry, err := builder.BuildUnixFSDirectoryEntry("file", int64(mdSize), md)
+	require.NoError(t, err)
+	root, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{entry}, &f.ls)
+	require.NoError(t, err)
+	rootCid := root.(cidlink.Link).Cid
+	blks := dfsBlocks(t, &f.ls, f.storage, rootCid)
+	require.Equal(t, md.(cidlink.Link).Cid, blks[1].Cid())
+	require.Equal(t, f.file, blks[2].Cid())
+
+	stream := sliceReader(append([]blocks.Block(nil), blks...))
+	res, err := trustless.Ingest(ctx, rootCid, &stream, nil)
+	require.NoError(t, err)
+	require.Equal(t, len(blks), res.Blocks)
+
+	// the target of the Metadata node is required
+	truncated := sliceReader(append([]blocks.Block(nil), blks[:2]...))
+	_, err = trustless.Ingest(ctx, rootCid, &truncated, nil)
+	var missing trustless.ErrMissingBlock
+	require.ErrorAs(t, err, &missing)
+	require.Equal(t, f.file, missing.Cid)
+}
@@ -0,0 +1,53 @@
+package trustless
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// ProofBlocks returns the blocks that prove the UnixFS path from root resolves
+// to an entity, for inclusion proofs or for pinning just the path: the root
+// block, the blocks of the directories and HAMT shards passed through to
+// resolve each segment, and the root block of the entity itself, in the order
+// they are loaded, each once. No more of the entity is included; the blocks
+// of a multi-block file, or the entries of a directory, are left out. The last
+// block is that of the entity.
+//
+// Blocks are loaded from lsys, and verified against their CIDs unless lsys
+// has TrustedStorage.
+func ProofBlocks(ctx context.Context, lsys *ipld.LinkSystem, root cid.Cid, path string) ([]blocks.Block, error) {
+	var proof []blocks.Block
+	seen := make(map[cid.Cid]struct{})
+	open := func(lnkCtx linking.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		raw, err := lsys.LoadRaw(lnkCtx, lnk)
+		if err != nil {
+			return nil, err
+		}
+		c := lnk.(cidlink.Link).Cid
+		if _, ok := seen[c]; !ok {
+			blk, err := blocks.NewBlockWithCid(raw, c)
+			if err != nil {
+				return nil, err
+			}
+			proof = append(proof, blk)
+			seen[c] = struct{}{}
+		}
+		return bytes.NewReader(raw), nil
+	}
+	err := traversePath(ctx, open, root, path, unixfsnode.MatchUnixFSSelector, func(cid.Cid, datamodel.Node) error {
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
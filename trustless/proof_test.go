@@ -0,0 +1,52 @@
+package trustless_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/trustless"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProofBlocks(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	child := f.sharded.Children[0]
+	for _, tc := range []struct {
+		path   string
+		target cid.Cid
+		// min is the fewest blocks expected, as HAMT lookups load a varying
+		// number of shards
+		min, max int
+	}{
+		{"", f.root, 1, 1},
+		{"file", f.file, 2, 2},
+		{"sharded", f.sharded.Root, 2, 2},
+		{"sharded" + child.Path, child.Root, 3, 10},
+	} {
+		proof, err := trustless.ProofBlocks(ctx, &f.ls, f.root, tc.path)
+		require.NoError(t, err, tc.path)
+		require.GreaterOrEqual(t, len(proof), tc.min, tc.path)
+		require.LessOrEqual(t, len(proof), tc.max, tc.path)
+		require.Equal(t, f.root, proof[0].Cid())
+		require.Equal(t, tc.target, proof[len(proof)-1].Cid(), tc.path)
+		// each block is linked from the one before it
+		for i := 1; i < len(proof); i++ {
+			nb := dagpb.Type.PBNode.NewBuilder()
+			require.NoError(t, dagpb.DecodeBytes(nb, proof[i-1].RawData()))
+			var linked bool
+			itr := nb.Build().(dagpb.PBNode).FieldLinks().Iterator()
+			for !itr.Done() {
+				_, l := itr.Next()
+				linked = linked || l.FieldHash().Link().(cidlink.Link).Cid.Equals(proof[i].Cid())
+			}
+			require.True(t, linked, "block %d of %q is not linked from the one before it", i, tc.path)
+		}
+	}
+
+	_, err := trustless.ProofBlocks(ctx, &f.ls, f.root, "missing")
+	require.Error(t, err)
+}
@@ -0,0 +1,324 @@
+// Package trustless verifies block streams, such as the CAR responses of a
+// trustless IPFS gateway, against the UnixFS path and byte range that were
+// requested, returning only content that has been proven by the stream. Whole
+// DAGs, such as exports from other implementations, can be checked with
+// Ingest. WriteCAR produces the streams Verify checks, and ProofBlocks the
+// blocks proving a path alone.
+package trustless
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+)
+
+// BlockReader is a stream of blocks, in the order they should be consumed.
+// Next returns io.EOF once the stream is exhausted. A *car.BlockReader from
+// github.com/ipld/go-car/v2 satisfies this interface.
+type BlockReader interface {
+	Next() (blocks.Block, error)
+}
+
+// ByteRange selects a range of bytes of a file. From is inclusive and To is
+// exclusive; either may be negative, in which case it is counted back from the
+// end of the file, as with the MatcherSubset selector.
+type ByteRange struct {
+	From int64
+	To   int64
+}
+
+// bounds resolves the range against a file of the given length, returning
+// false if no bytes of the file are selected.
+func (br ByteRange) bounds(length int64) (int64, int64, bool) {
+	from, to := br.From, br.To
+	if to < 0 {
+		to = length + to
+	} else if length < to {
+		to = length
+	}
+	if from < 0 {
+		from = max(length+from, 0)
+	}
+	if from > to || from >= length {
+		return 0, 0, false
+	}
+	return from, to, true
+}
+
+// Entry is a single entry of a verified directory listing.
+type Entry struct {
+	Name string
+	Cid  cid.Cid
+}
+
+// Result is the content proven by a block stream.
+type Result struct {
+	// Target is the CID of the entity at the end of the path.
+	Target cid.Cid
+	// Content holds the bytes of the file, or requested range of the file, if
+	// the path resolved to a file. It holds the link target if the path
+	// resolved to a symlink.
+	Content []byte
+	// Entries holds the directory listing if the path resolved to a directory.
+	Entries []Entry
+	// Blocks is the number of distinct blocks consumed from the stream.
+	Blocks int
+}
+
+// ErrMissingBlock is returned when the stream ended before a block required to
+// prove the path was received.
+type ErrMissingBlock struct {
+	Cid cid.Cid
+}
+
+func (e ErrMissingBlock) Error() string {
+	return fmt.Sprintf("block stream ended before block %s was received", e.Cid)
+}
+
+// ErrUnexpectedBlock is returned when the stream contains a block other than
+// the one required next to prove the path.
+type ErrUnexpectedBlock struct {
+	Expected cid.Cid
+	Received cid.Cid
+}
+
+func (e ErrUnexpectedBlock) Error() string {
+	return fmt.Sprintf("unexpected block %s in stream, expected %s", e.Received, e.Expected)
+}
+
+// ErrExtraneousBlocks is returned when blocks remain in the stream after the
+// path has been proven.
+var ErrExtraneousBlocks = errors.New("block stream contains blocks that are not part of the requested path")
+
+// Verify consumes blocks from the stream and checks that they prove the UnixFS
+// path from root, and the byte range if one is given. Blocks must arrive in
+// the depth-first order in which a traversal of the path visits them; blocks
+// that were already received may be repeated, but no other blocks are allowed.
+// Every block is hashed and checked against the link that requested it.
+//
+// If the path resolves to a directory, the returned Result contains its
+// listing and byteRange is ignored. If it resolves to a file, the returned
+// Result contains the requested bytes.
+func Verify(ctx context.Context, root cid.Cid, path string, byteRange *ByteRange, stream BlockReader) (*Result, error) {
+	bs := &blockStream{stream: stream, seen: make(map[cid.Cid][]byte)}
+	res := &Result{}
+	err := traversePath(ctx, bs.open, root, path, unixfsnode.MatchUnixFSEntitySelector, func(target cid.Cid, n datamodel.Node) error {
+		res.Target = target
+		return collect(res, n, byteRange)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := bs.drain(); err != nil {
+		return nil, err
+	}
+	res.Blocks = len(bs.seen)
+	return res, nil
+}
+
+// traversePath loads the blocks of the UnixFS path from root through open,
+// and calls visit with the entity the path resolves to and its CID. The
+// blocks loaded by targetSel, the selector applied to the entity, and by visit
+// are also loaded through open.
+func traversePath(ctx context.Context, open linking.BlockReadOpener, root cid.Cid, path string, targetSel builder.SelectorSpec, visit func(cid.Cid, datamodel.Node) error) error {
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.TrustedStorage = false
+	lsys.StorageReadOpener = open
+	lsys.NodeReifier = unixfsnode.Reify
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+
+	sel, err := selector.CompileSelector(unixfsnode.UnixFSPathSelectorBuilder(path, targetSel, false))
+	if err != nil {
+		return err
+	}
+
+	rootLink := cidlink.Link{Cid: root}
+	protoChooser := dagpb.AddSupportToChooser(basicnode.Chooser)
+	proto, err := protoChooser(rootLink, linking.LinkContext{Ctx: ctx})
+	if err != nil {
+		return err
+	}
+	rootNode, err := lsys.Load(linking.LinkContext{Ctx: ctx}, rootLink, proto)
+	if err != nil {
+		return err
+	}
+
+	var matched bool
+	prog := traversal.Progress{
+		Cfg: &traversal.Config{
+			Ctx:                            ctx,
+			LinkSystem:                     lsys,
+			LinkTargetNodePrototypeChooser: protoChooser,
+		},
+	}
+	err = prog.WalkMatching(rootNode, sel, func(p traversal.Progress, n datamodel.Node) error {
+		if matched {
+			return nil
+		}
+		matched = true
+		target := root
+		if p.LastBlock.Link != nil {
+			target = p.LastBlock.Link.(cidlink.Link).Cid
+		}
+		return visit(target, n)
+	})
+	if err != nil {
+		return err
+	}
+	if !matched {
+		return fmt.Errorf("path %q was not found under %s", path, root)
+	}
+	return nil
+}
+
+func collect(res *Result, n datamodel.Node, byteRange *ByteRange) error {
+	switch n.Kind() {
+	case datamodel.Kind_Map:
+		if target, ok := symlinkTarget(n); ok {
+			res.Content = target
+			return nil
+		}
+		mi := n.MapIterator()
+		for !mi.Done() {
+			k, v, err := mi.Next()
+			if err != nil {
+				return err
+			}
+			name, err := k.AsString()
+			if err != nil {
+				return err
+			}
+			lnk, err := v.AsLink()
+			if err != nil {
+				return err
+			}
+			res.Entries = append(res.Entries, Entry{Name: name, Cid: lnk.(cidlink.Link).Cid})
+		}
+		return nil
+	case datamodel.Kind_Bytes:
+		if lbn, ok := n.(datamodel.LargeBytesNode); ok {
+			content := bytes.NewBuffer([]byte{})
+			if err := readRange(content, lbn, byteRange); err != nil {
+				return err
+			}
+			res.Content = content.Bytes()
+			return nil
+		}
+		byts, err := n.AsBytes()
+		if err != nil {
+			return err
+		}
+		res.Content = byts
+		return nil
+	default:
+		return nil
+	}
+}
+
+// readRange copies the bytes of the range of a file to w, or the whole file
+// if byteRange is nil.
+func readRange(w io.Writer, lbn datamodel.LargeBytesNode, byteRange *ByteRange) error {
+	rdr, err := lbn.AsLargeBytes()
+	if err != nil {
+		return err
+	}
+	if byteRange == nil {
+		_, err = io.Copy(w, rdr)
+		return err
+	}
+	// seek to the range the same way a MatcherSubset selector would,
+	// so only the blocks covering it are loaded
+	length, err := rdr.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	from, to, ok := byteRange.bounds(length)
+	if !ok {
+		return nil
+	}
+	if _, err := rdr.Seek(from, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.CopyN(w, rdr, to-from)
+	return err
+}
+
+// symlinkTarget returns the target of n if it is a UnixFS symlink, which is
+// reified as a plain dag-pb node.
+func symlinkTarget(n datamodel.Node) ([]byte, bool) {
+	pbn, ok := unixfsnode.Substrate(n).(dagpb.PBNode)
+	if !ok || !pbn.FieldData().Exists() {
+		return nil, false
+	}
+	ufsData, err := data.DecodeUnixFSData(pbn.FieldData().Must().Bytes())
+	if err != nil || ufsData.FieldDataType().Int() != data.Data_Symlink || !ufsData.FieldData().Exists() {
+		return nil, false
+	}
+	return ufsData.FieldData().Must().Bytes(), true
+}
+
+// blockStream serves loads from the stream, requiring each newly loaded block
+// to be the next one in the stream. Blocks are remembered once received so
+// that repeated loads, and repeated blocks in the stream, are accepted.
+type blockStream struct {
+	stream BlockReader
+	seen   map[cid.Cid][]byte
+}
+
+func (bs *blockStream) open(_ linking.LinkContext, lnk ipld.Link) (io.Reader, error) {
+	want := lnk.(cidlink.Link).Cid
+	if byts, ok := bs.seen[want]; ok {
+		return bytes.NewReader(byts), nil
+	}
+	for {
+		blk, err := bs.stream.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, ErrMissingBlock{Cid: want}
+			}
+			return nil, err
+		}
+		got := blk.Cid()
+		if _, ok := bs.seen[got]; ok {
+			continue
+		}
+		if !got.Equals(want) {
+			return nil, ErrUnexpectedBlock{Expected: want, Received: got}
+		}
+		bs.seen[got] = blk.RawData()
+		return bytes.NewReader(blk.RawData()), nil
+	}
+}
+
+// drain checks that the remainder of the stream only repeats blocks that have
+// already been received.
+func (bs *blockStream) drain() error {
+	for {
+		blk, err := bs.stream.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if _, ok := bs.seen[blk.Cid()]; !ok {
+			return ErrExtraneousBlocks
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package trustless_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipfs/go-unixfsnode/trustless"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	sb "github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/stretchr/testify/require"
+)
+
+type sliceReader []blocks.Block
+
+func (s *sliceReader) Next() (blocks.Block, error) {
+	if len(*s) == 0 {
+		return nil, io.EOF
+	}
+	blk := (*s)[0]
+	*s = (*s)[1:]
+	return blk, nil
+}
+
+type fixture struct {
+	ls      linking.LinkSystem
+	storage *cidlink.Memory
+	root    cid.Cid
+	file    cid.Cid
+	content []byte
+	sharded testutil.DirEntry
+}
+
+func newFixture(t *testing.T) fixture {
+	ls := cidlink.DefaultLinkSystem()
+	storage := &cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	content := random.Bytes(100 << 10)
+	file, fileSize, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-4096", &ls)
+	require.NoError(t, err)
+	fileEntry, err := builder.BuildUnixFSDirectoryEntry("file", int64(fileSize), file)
+	require.NoError(t, err)
+	sharded := testutil.GenerateDirectory(t, &ls, random.NewSeededRand(1234), 1<<16, true)
+	shardedEntry, err := builder.BuildUnixFSDirectoryEntry("sharded", int64(sharded.TSize), sharded.Link())
+	require.NoError(t, err)
+	link, linkSize, err := builder.BuildUnixFSSymlink("file", &ls)
+	require.NoError(t, err)
+	linkEntry, err := builder.BuildUnixFSDirectoryEntry("link", int64(linkSize), link)
+	require.NoError(t, err)
+	root, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{fileEntry, linkEntry, shardedEntry}, &ls)
+	require.NoError(t, err)
+
+	return fixture{ls: ls, storage: storage, root: root.(cidlink.Link).Cid, file: file.(cidlink.Link).Cid, content: content, sharded: sharded}
+}
+
+// stream produces the blocks a trustless gateway would send for the path and
+// range, by recording the blocks loaded by a selector traversal.
+func (f fixture) stream(t *testing.T, path string, byteRange *trustless.ByteRange) *sliceReader {
+	var blks sliceReader
+	seen := make(map[cid.Cid]struct{})
+	ls := f.ls
+	ls.StorageReadOpener = func(lc linking.LinkContext, l datamodel.Link) (io.Reader, error) {
+		c := l.(cidlink.Link).Cid
+		byts, ok := f.storage.Bag[string(c.Hash())]
+		if !ok {
+			return nil, errors.New("not found")
+		}
+		if _, ok := seen[c]; !ok {
+			seen[c] = struct{}{}
+			blk, err := blocks.NewBlockWithCid(byts, c)
+			require.NoError(t, err)
+			blks = append(blks, blk)
+		}
+		return bytes.NewReader(byts), nil
+	}
+	ls.NodeReifier = unixfsnode.Reify
+	unixfsnode.AddUnixFSReificationToLinkSystem(&ls)
+
+	target := unixfsnode.MatchUnixFSEntitySelector
+	if byteRange != nil {
+		ssb := sb.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+		target = ssb.ExploreInterpretAs("unixfs", ssb.MatcherSubset(byteRange.From, byteRange.To))
+	}
+	sel, err := selector.CompileSelector(unixfsnode.UnixFSPathSelectorBuilder(path, target, false))
+	require.NoError(t, err)
+	rootNode, err := ls.Load(linking.LinkContext{}, cidlink.Link{Cid: f.root}, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	prog := traversal.Progress{Cfg: &traversal.Config{
+		LinkSystem:                     ls,
+		LinkTargetNodePrototypeChooser: dagpb.AddSupportToChooser(basicnode.Chooser),
+	}}
+	require.NoError(t, prog.WalkMatching(rootNode, sel, unixfsnode.BytesConsumingMatcher))
+	return &blks
+}
+
+func TestVerifyFile(t *testing.T) {
+	f := newFixture(t)
+	stream := f.stream(t, "file", nil)
+	n := len(*stream)
+
+	res, err := trustless.Verify(context.Background(), f.root, "file", nil, stream)
+	require.NoError(t, err)
+	require.Equal(t, f.content, res.Content)
+	require.Empty(t, res.Entries)
+	require.Equal(t, n, res.Blocks)
+}
+
+func TestVerifySymlink(t *testing.T) {
+	f := newFixture(t)
+	res, err := trustless.Verify(context.Background(), f.root, "link", nil, f.stream(t, "link", nil))
+	require.NoError(t, err)
+	require.Equal(t, []byte("file"), res.Content)
+	require.Empty(t, res.Entries)
+}
+
+func TestVerifyRange(t *testing.T) {
+	f := newFixture(t)
+	for _, br := range []trustless.ByteRange{
+		{From: 10000, To: 20000},
+		{From: 0, To: 1},
+		{From: -5000, To: 1 << 30},
+		{From: 50000, To: -100},
+	} {
+		stream := f.stream(t, "file", &br)
+		n := len(*stream)
+		// only the blocks covering the range should be sent
+		require.Less(t, n, len(f.content)/4096)
+
+		res, err := trustless.Verify(context.Background(), f.root, "/file/", &br, stream)
+		require.NoError(t, err)
+		from, to := br.From, br.To
+		if from < 0 {
+			from += int64(len(f.content))
+		}
+		if to < 0 {
+			to += int64(len(f.content))
+		}
+		to = min(to, int64(len(f.content)))
+		require.Equal(t, f.content[from:to], res.Content)
+		require.Equal(t, n, res.Blocks)
+	}
+}
+
+func TestVerifyDirectory(t *testing.T) {
+	f := newFixture(t)
+
+	res, err := trustless.Verify(context.Background(), f.root, "", nil, f.stream(t, "", nil))
+	require.NoError(t, err)
+	require.Equal(t, f.root, res.Target)
+	require.Len(t, res.Entries, 3)
+	require.Equal(t, "file", res.Entries[0].Name)
+	require.Equal(t, "link", res.Entries[1].Name)
+	require.Equal(t, "sharded", res.Entries[2].Name)
+	require.Equal(t, f.sharded.Root, res.Entries[2].Cid)
+
+	res, err = trustless.Verify(context.Background(), f.root, "sharded", nil, f.stream(t, "sharded", nil))
+	require.NoError(t, err)
+	require.Equal(t, f.sharded.Root, res.Target)
+	require.Len(t, res.Entries, len(f.sharded.Children))
+	got := make(map[cid.Cid]struct{})
+	for _, e := range res.Entries {
+		got[e.Cid] = struct{}{}
+	}
+	for _, child := range f.sharded.Children {
+		require.Contains(t, got, child.Root)
+	}
+
+	// a path that resolves through the sharded directory
+	child := f.sharded.Children[0]
+	path := "sharded" + child.Path
+	res, err = trustless.Verify(context.Background(), f.root, path, nil, f.stream(t, path, nil))
+	require.NoError(t, err)
+	require.Equal(t, child.Root, res.Target)
+}
+
+func TestVerifyRejects(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+
+	t.Run("missing block", func(t *testing.T) {
+		stream := f.stream(t, "file", nil)
+		missing := (*stream)[len(*stream)-1].Cid()
+		*stream = (*stream)[:len(*stream)-1]
+		_, err := trustless.Verify(ctx, f.root, "file", nil, stream)
+		var merr trustless.ErrMissingBlock
+		require.ErrorAs(t, err, &merr)
+		require.Equal(t, missing, merr.Cid)
+	})
+
+	t.Run("out of order", func(t *testing.T) {
+		stream := f.stream(t, "file", nil)
+		(*stream)[2], (*stream)[3] = (*stream)[3], (*stream)[2]
+		_, err := trustless.Verify(ctx, f.root, "file", nil, stream)
+		var uerr trustless.ErrUnexpectedBlock
+		require.ErrorAs(t, err, &uerr)
+	})
+
+	t.Run("extra block", func(t *testing.T) {
+		stream := f.stream(t, "file", &trustless.ByteRange{From: 0, To: 10})
+		extra := f.stream(t, "file", &trustless.ByteRange{From: 50000, To: 50010})
+		*stream = append(*stream, (*extra)[len(*extra)-1])
+		_, err := trustless.Verify(ctx, f.root, "file", &trustless.ByteRange{From: 0, To: 10}, stream)
+		require.ErrorIs(t, err, trustless.ErrExtraneousBlocks)
+	})
+
+	t.Run("corrupt block", func(t *testing.T) {
+		stream := f.stream(t, "file", nil)
+		last := (*stream)[len(*stream)-1]
+		byts := append([]byte{}, last.RawData()...)
+		byts[0] ^= 0xff
+		corrupt, err := blocks.NewBlockWithCid(byts, last.Cid())
+		require.NoError(t, err)
+		(*stream)[len(*stream)-1] = corrupt
+		_, err = trustless.Verify(ctx, f.root, "file", nil, stream)
+		var herr ipld.ErrHashMismatch
+		require.True(t, errors.As(err, &herr), "expected hash mismatch, got %v", err)
+	})
+
+	t.Run("duplicates accepted", func(t *testing.T) {
+		stream := f.stream(t, "file", nil)
+		*stream = append(*stream, (*stream)[0], (*stream)[1])
+		res, err := trustless.Verify(ctx, f.root, "file", nil, stream)
+		require.NoError(t, err)
+		require.Equal(t, f.content, res.Content)
+	})
+
+	t.Run("missing path", func(t *testing.T) {
+		_, err := trustless.Verify(ctx, f.root, "nope", nil, f.stream(t, "nope", nil))
+		require.Error(t, err)
+	})
+}
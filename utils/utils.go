@@ -1,6 +1,10 @@
 package utils
 
-import dagpb "github.com/ipld/go-codec-dagpb"
+import (
+	"context"
+
+	dagpb "github.com/ipld/go-codec-dagpb"
+)
 
 // Lookup finds a name key in a list of dag pb links
 func Lookup(links dagpb.PBLinks, key string) dagpb.Link {
@@ -17,3 +21,25 @@ func Lookup(links dagpb.PBLinks, key string) dagpb.Link {
 	}
 	return nil
 }
+
+type indexSegmentsKey struct{}
+
+// WithIndexSegments returns a context with which directories and HAMT shards,
+// created directly or through reification on a LinkSystem, resolve a numeric
+// path segment that is not the name of an entry to the entry at that index,
+// in link order, as their LookupByIndex does. This lets tooling that paths
+// into lists by index walk directories, but means a path with a numeric name
+// that is missing reaches another entry rather than failing, so it is off by
+// default.
+func WithIndexSegments(ctx context.Context) context.Context {
+	return context.WithValue(ctx, indexSegmentsKey{}, true)
+}
+
+// IndexSegments reports whether ctx was made with WithIndexSegments.
+func IndexSegments(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	on, _ := ctx.Value(indexSegmentsKey{}).(bool)
+	return on
+}
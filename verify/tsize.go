@@ -0,0 +1,195 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// TsizeDiscrepancy describes a link whose Tsize is not the size of the DAG it
+// points to
+type TsizeDiscrepancy struct {
+	// Path is the UnixFS path, relative to the root, at which the link was
+	// found
+	Path   string  `json:"path"`
+	Parent cid.Cid `json:"parent"`
+	// Name is the name of the link, including the bucket prefix of links in
+	// sharded directories
+	Name string  `json:"name"`
+	Cid  cid.Cid `json:"cid"`
+	// Tsize is the Tsize of the link, nil if it has none
+	Tsize *uint64 `json:"tsize"`
+	// Size is the cumulative size of the blocks of the DAG linked to
+	Size uint64 `json:"size"`
+}
+
+func (d TsizeDiscrepancy) String() string {
+	if d.Tsize == nil {
+		return fmt.Sprintf("link %q from %s at %q has no Tsize, DAG size is %d", d.Name, d.Parent, d.Path, d.Size)
+	}
+	return fmt.Sprintf("link %q from %s at %q has Tsize %d, DAG size is %d", d.Name, d.Parent, d.Path, *d.Tsize, d.Size)
+}
+
+// TsizeReport is the result of checking the Tsizes of a DAG
+type TsizeReport struct {
+	Root cid.Cid `json:"root"`
+	// Blocks is the number of unique blocks that were loaded
+	Blocks int `json:"blocks"`
+	// Size is the cumulative size of the DAG under the root, as the Tsize of a
+	// link to it should be. It is a lower bound if blocks are missing.
+	Size          uint64             `json:"size"`
+	Discrepancies []TsizeDiscrepancy `json:"discrepancies"`
+	// Missing lists the blocks that could not be loaded or decoded. The sizes
+	// of the DAGs above them are unknown, so the links to those DAGs are not
+	// checked.
+	Missing []MissingBlock `json:"missing"`
+}
+
+// OK returns true if the DAG was complete and every Tsize was correct
+func (r *TsizeReport) OK() bool {
+	return len(r.Discrepancies) == 0 && len(r.Missing) == 0
+}
+
+// tsizeResult is the size of a DAG, and whether all of it was loaded
+type tsizeResult struct {
+	size     uint64
+	complete bool
+}
+
+type tsizeChecker struct {
+	ctx    context.Context
+	lsys   *ipld.LinkSystem
+	report *TsizeReport
+	seen   map[cid.Cid]tsizeResult
+}
+
+// CheckTsizes walks the DAG under root and checks that the Tsize of every
+// dag-pb link is the cumulative size of the blocks of the DAG it points to:
+// the size of the block linked to plus the sizes of the DAGs it links to,
+// counting blocks linked to more than once each time. This is the property
+// builders most often get wrong when propagating sizes up a DAG. Links
+// without a Tsize are reported too.
+//
+// Unlike Verify, the DAG needn't be valid UnixFS: any dag-pb DAG can be
+// checked. Blocks of other codecs count towards the sizes of the DAGs
+// linking to them, but are not walked. An error is only returned if the
+// check could not be performed at all, such as for a root link that is not a
+// CID or a cancelled context.
+func CheckTsizes(ctx context.Context, lsys *ipld.LinkSystem, root ipld.Link) (*TsizeReport, error) {
+	cl, ok := root.(cidlink.Link)
+	if !ok {
+		return nil, fmt.Errorf("unsupported link type: %T", root)
+	}
+	tc := &tsizeChecker{
+		ctx:    ctx,
+		lsys:   lsys,
+		report: &TsizeReport{Root: cl.Cid},
+		seen:   make(map[cid.Cid]tsizeResult),
+	}
+	res, err := tc.check("", cl.Cid)
+	if err != nil {
+		return nil, err
+	}
+	tc.report.Size = res.size
+	return tc.report, nil
+}
+
+// check returns the size of the DAG at c, checking the links within it.
+func (tc *tsizeChecker) check(path string, c cid.Cid) (tsizeResult, error) {
+	if res, ok := tc.seen[c]; ok {
+		return res, nil
+	}
+	if err := tc.ctx.Err(); err != nil {
+		return tsizeResult{}, err
+	}
+	raw, err := tc.lsys.LoadRaw(linking.LinkContext{Ctx: tc.ctx}, cidlink.Link{Cid: c})
+	if err != nil {
+		tc.report.Missing = append(tc.report.Missing, MissingBlock{Path: path, Cid: c, Error: err.Error()})
+		tc.seen[c] = tsizeResult{}
+		return tsizeResult{}, nil
+	}
+	tc.report.Blocks++
+	res := tsizeResult{size: uint64(len(raw)), complete: true}
+	if c.Prefix().Codec != cid.DagProtobuf {
+		tc.seen[c] = res
+		return res, nil
+	}
+	nb := dagpb.Type.PBNode.NewBuilder()
+	if err := dagpb.DecodeBytes(nb, raw); err != nil {
+		tc.report.Missing = append(tc.report.Missing, MissingBlock{Path: path, Cid: c, Error: err.Error()})
+		tc.seen[c] = tsizeResult{}
+		return tsizeResult{}, nil
+	}
+	pbnd := nb.Build().(dagpb.PBNode)
+	childPath := childPathFunc(path, pbnd)
+
+	itr := pbnd.FieldLinks().Iterator()
+	for !itr.Done() {
+		_, lnk := itr.Next()
+		lcl, ok := lnk.FieldHash().Link().(cidlink.Link)
+		if !ok {
+			return tsizeResult{}, fmt.Errorf("unsupported link type: %T", lnk.FieldHash().Link())
+		}
+		var name string
+		if lnk.FieldName().Exists() {
+			name = lnk.FieldName().Must().String()
+		}
+		p := childPath(name)
+		child, err := tc.check(p, lcl.Cid)
+		if err != nil {
+			return tsizeResult{}, err
+		}
+		res.size += child.size
+		res.complete = res.complete && child.complete
+		if !child.complete {
+			continue
+		}
+		if !lnk.FieldTsize().Exists() {
+			tc.report.Discrepancies = append(tc.report.Discrepancies, TsizeDiscrepancy{Path: p, Parent: c, Name: name, Cid: lcl.Cid, Size: child.size})
+			continue
+		}
+		if tsize := uint64(lnk.FieldTsize().Must().Int()); tsize != child.size {
+			tc.report.Discrepancies = append(tc.report.Discrepancies, TsizeDiscrepancy{Path: p, Parent: c, Name: name, Cid: lcl.Cid, Tsize: &tsize, Size: child.size})
+		}
+	}
+	tc.seen[c] = res
+	return res, nil
+}
+
+// childPathFunc returns a function giving the UnixFS path of the DAG reached
+// by a link of the given name from the node at path. Entries of directories
+// and sharded directories extend the path, without the bucket prefix for the
+// latter, while the links of files and of shards to child shards don't.
+func childPathFunc(path string, pbnd dagpb.PBNode) func(name string) string {
+	samePath := func(string) string { return path }
+	if !pbnd.FieldData().Exists() {
+		return samePath
+	}
+	ufsData, err := data.DecodeUnixFSData(pbnd.FieldData().Must().Bytes())
+	if err != nil {
+		return samePath
+	}
+	switch ufsData.FieldDataType().Int() {
+	case data.Data_Directory:
+		return func(name string) string { return path + "/" + name }
+	case data.Data_HAMTShard:
+		if !ufsData.FieldFanout().Exists() {
+			return samePath
+		}
+		padLen := len(fmt.Sprintf("%X", ufsData.FieldFanout().Must().Int()-1))
+		return func(name string) string {
+			if len(name) <= padLen {
+				return path
+			}
+			return path + "/" + name[padLen:]
+		}
+	default:
+		return samePath
+	}
+}
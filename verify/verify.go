@@ -0,0 +1,475 @@
+// Package verify provides an fsck-style checker for complete UnixFS DAGs.
+//
+// Verify walks every block reachable from a root and checks that each one
+// decodes, that its UnixFS metadata is consistent with the blocks it links to,
+// and that sharded directories obey the HAMT invariants. Rather than stopping
+// at the first problem, every violation found is recorded in a Report.
+//
+// CheckTsizes does only the Tsize checks, for any dag-pb DAG, reporting each
+// link whose Tsize doesn't match the DAG it points to.
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+
+	bitfield "github.com/ipfs/go-bitfield"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/hamt"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// ViolationKind classifies a Violation
+type ViolationKind string
+
+const (
+	// HashMismatch indicates a block's content does not match its CID
+	HashMismatch ViolationKind = "hash-mismatch"
+	// UnsupportedCodec indicates a block uses a codec other than dag-pb or raw
+	UnsupportedCodec ViolationKind = "unsupported-codec"
+	// InvalidDagPB indicates a dag-pb block could not be decoded
+	InvalidDagPB ViolationKind = "invalid-dag-pb"
+	// InvalidUnixFSData indicates a dag-pb block had a missing or undecodable
+	// UnixFS Data field
+	InvalidUnixFSData ViolationKind = "invalid-unixfs-data"
+	// BlockSizesMismatch indicates a file's BlockSizes are inconsistent with
+	// its FileSize, its links, or the content of its children
+	BlockSizesMismatch ViolationKind = "blocksizes-mismatch"
+	// TsizeMismatch indicates a link's Tsize does not match the cumulative
+	// size of the DAG it points to
+	TsizeMismatch ViolationKind = "tsize-mismatch"
+	// InvalidHAMT indicates a sharded directory breaks a HAMT invariant
+	InvalidHAMT ViolationKind = "invalid-hamt"
+	// InvalidDirectory indicates a basic directory has unnamed or duplicate
+	// entries
+	InvalidDirectory ViolationKind = "invalid-directory"
+	// UnexpectedType indicates a node of a UnixFS type that is not valid at
+	// its position in the DAG, such as a directory inside a file
+	UnexpectedType ViolationKind = "unexpected-type"
+)
+
+// Violation describes a single problem found in a DAG
+type Violation struct {
+	// Path is the UnixFS path, relative to the root, at which the offending
+	// block was reached
+	Path    string        `json:"path"`
+	Cid     cid.Cid       `json:"cid"`
+	Kind    ViolationKind `json:"kind"`
+	Message string        `json:"message"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s (%s) at %q: %s", v.Kind, v.Cid, v.Path, v.Message)
+}
+
+// MissingBlock describes a block that is linked to but could not be loaded
+type MissingBlock struct {
+	Path  string  `json:"path"`
+	Cid   cid.Cid `json:"cid"`
+	Error string  `json:"error"`
+}
+
+// Report is the result of verifying a DAG
+type Report struct {
+	Root cid.Cid `json:"root"`
+	// Blocks is the number of unique blocks that were loaded and checked
+	Blocks     int            `json:"blocks"`
+	Violations []Violation    `json:"violations"`
+	Missing    []MissingBlock `json:"missing"`
+}
+
+// OK returns true if the DAG was complete and no violations were found
+func (r *Report) OK() bool {
+	return len(r.Violations) == 0 && len(r.Missing) == 0
+}
+
+// result is what is known about a verified subtree
+type result struct {
+	// tsize is the cumulative size of all blocks in the subtree
+	tsize uint64
+	// contentSize is the number of file bytes in the subtree
+	contentSize uint64
+	dataType    int64
+	// complete is false if the subtree had missing blocks, in which case
+	// tsize and contentSize are lower bounds
+	complete bool
+}
+
+type verifier struct {
+	ctx    context.Context
+	lsys   *ipld.LinkSystem
+	report *Report
+	seen   map[cid.Cid]result
+}
+
+// Verify walks the entire DAG under root, checking each block, and returns a
+// Report of all violations and missing blocks. An error is only returned if
+// verification could not be performed at all, such as for a root link that is
+// not a CID.
+func Verify(ctx context.Context, lsys *ipld.LinkSystem, root ipld.Link) (*Report, error) {
+	cl, ok := root.(cidlink.Link)
+	if !ok {
+		return nil, fmt.Errorf("unsupported link type: %T", root)
+	}
+	v := &verifier{
+		ctx:    ctx,
+		lsys:   lsys,
+		report: &Report{Root: cl.Cid},
+		seen:   make(map[cid.Cid]result),
+	}
+	if _, err := v.verifyLink("", cl.Cid); err != nil {
+		return nil, err
+	}
+	return v.report, nil
+}
+
+func (v *verifier) violation(path string, c cid.Cid, kind ViolationKind, format string, args ...interface{}) {
+	v.report.Violations = append(v.report.Violations, Violation{
+		Path:    path,
+		Cid:     c,
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// load fetches and decodes a dag-pb or raw block, recording it as missing or
+// in violation as appropriate. A nil node with a nil raw slice means the block
+// could not be checked further.
+func (v *verifier) load(path string, c cid.Cid) (dagpb.PBNode, []byte, error) {
+	if err := v.ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	raw, err := v.lsys.LoadRaw(linking.LinkContext{Ctx: v.ctx}, cidlink.Link{Cid: c})
+	if err != nil {
+		if errors.As(err, &linking.ErrHashMismatch{}) {
+			v.violation(path, c, HashMismatch, "%s", err)
+		} else {
+			v.report.Missing = append(v.report.Missing, MissingBlock{Path: path, Cid: c, Error: err.Error()})
+		}
+		return nil, nil, nil
+	}
+	v.report.Blocks++
+	switch c.Prefix().Codec {
+	case cid.Raw:
+		return nil, raw, nil
+	case cid.DagProtobuf:
+		nb := dagpb.Type.PBNode.NewBuilder()
+		if err := dagpb.DecodeBytes(nb, raw); err != nil {
+			v.violation(path, c, InvalidDagPB, "%s", err)
+			return nil, raw, nil
+		}
+		return nb.Build().(dagpb.PBNode), raw, nil
+	default:
+		v.violation(path, c, UnsupportedCodec, "codec 0x%x", c.Prefix().Codec)
+		return nil, raw, nil
+	}
+}
+
+func (v *verifier) verifyLink(path string, c cid.Cid) (result, error) {
+	if res, ok := v.seen[c]; ok {
+		return res, nil
+	}
+	res, err := v.verifyBlock(path, c)
+	if err != nil {
+		return result{}, err
+	}
+	v.seen[c] = res
+	return res, nil
+}
+
+func (v *verifier) verifyBlock(path string, c cid.Cid) (result, error) {
+	pbnd, raw, err := v.load(path, c)
+	if err != nil {
+		return result{}, err
+	}
+	res := result{tsize: uint64(len(raw)), dataType: -1, complete: raw != nil}
+	if pbnd == nil {
+		if raw != nil && c.Prefix().Codec == cid.Raw {
+			res.contentSize = uint64(len(raw))
+			res.dataType = data.Data_Raw
+		} else {
+			res.complete = false
+		}
+		return res, nil
+	}
+	if !pbnd.FieldData().Exists() {
+		v.violation(path, c, InvalidUnixFSData, "dag-pb node has no Data field")
+		res.complete = false
+		return res, nil
+	}
+	ufsData, err := data.DecodeUnixFSData(pbnd.FieldData().Must().Bytes())
+	if err != nil {
+		v.violation(path, c, InvalidUnixFSData, "%s", err)
+		res.complete = false
+		return res, nil
+	}
+	res.dataType = ufsData.FieldDataType().Int()
+	switch res.dataType {
+	case data.Data_File, data.Data_Raw:
+		return v.verifyFile(path, c, pbnd, ufsData, res)
+	case data.Data_Directory:
+		return v.verifyDirectory(path, c, pbnd, res)
+	case data.Data_HAMTShard:
+		return v.verifyShard(path, c, pbnd, ufsData, res, 0)
+	case data.Data_Symlink:
+		if pbnd.FieldLinks().Length() > 0 {
+			v.violation(path, c, UnexpectedType, "%s node has links", data.DataTypeNames[res.dataType])
+		}
+		return res, nil
+	case data.Data_Metadata:
+		return v.verifyMetadata(path, c, pbnd, res)
+	default:
+		v.violation(path, c, InvalidUnixFSData, "unknown data type %d", res.dataType)
+		return res, nil
+	}
+}
+
+// verifyChild verifies the block a link points to and checks the link's
+// Tsize against it.
+func (v *verifier) verifyChild(path string, parent cid.Cid, lnk dagpb.PBLink) (result, error) {
+	cl, ok := lnk.FieldHash().Link().(cidlink.Link)
+	if !ok {
+		return result{}, fmt.Errorf("unsupported link type: %T", lnk.FieldHash().Link())
+	}
+	res, err := v.verifyLink(path, cl.Cid)
+	if err != nil {
+		return result{}, err
+	}
+	if res.complete && lnk.FieldTsize().Exists() {
+		if tsize := uint64(lnk.FieldTsize().Must().Int()); tsize != res.tsize {
+			v.violation(path, parent, TsizeMismatch, "link to %s has Tsize %d, DAG size is %d", cl.Cid, tsize, res.tsize)
+		}
+	}
+	return res, nil
+}
+
+func (v *verifier) verifyFile(path string, c cid.Cid, pbnd dagpb.PBNode, ufsData data.UnixFSData, res result) (result, error) {
+	var dataLen uint64
+	if ufsData.FieldData().Exists() {
+		dataLen = uint64(len(ufsData.FieldData().Must().Bytes()))
+	}
+	blockSizes := ufsData.FieldBlockSizes()
+	links := pbnd.FieldLinks()
+	if blockSizes.Length() != links.Length() {
+		v.violation(path, c, BlockSizesMismatch, "%d blocksizes for %d links", blockSizes.Length(), links.Length())
+	}
+	var sum uint64
+	itr := blockSizes.Iterator()
+	for !itr.Done() {
+		_, bs := itr.Next()
+		sum += uint64(bs.Int())
+	}
+	if ufsData.FieldFileSize().Exists() {
+		if fileSize := uint64(ufsData.FieldFileSize().Must().Int()); fileSize != sum+dataLen {
+			v.violation(path, c, BlockSizesMismatch, "filesize is %d, blocksizes and data sum to %d", fileSize, sum+dataLen)
+		}
+	}
+	res.contentSize = dataLen
+	linkItr := links.Iterator()
+	for !linkItr.Done() {
+		idx, lnk := linkItr.Next()
+		child, err := v.verifyChild(path, c, lnk)
+		if err != nil {
+			return result{}, err
+		}
+		res.tsize += child.tsize
+		res.contentSize += child.contentSize
+		res.complete = res.complete && child.complete
+		if !child.complete {
+			continue
+		}
+		if child.dataType != data.Data_File && child.dataType != data.Data_Raw {
+			v.violation(path, c, UnexpectedType, "file links to a %s node", typeName(child.dataType))
+			continue
+		}
+		if idx < blockSizes.Length() {
+			bs, _ := blockSizes.LookupByIndex(idx)
+			if expected, _ := bs.AsInt(); uint64(expected) != child.contentSize {
+				v.violation(path, c, BlockSizesMismatch, "blocksize %d is %d, child content is %d bytes", idx, expected, child.contentSize)
+			}
+		}
+	}
+	return res, nil
+}
+
+// verifyMetadata checks that a Metadata node wraps exactly one target and
+// verifies the target in its place.
+func (v *verifier) verifyMetadata(path string, c cid.Cid, pbnd dagpb.PBNode, res result) (result, error) {
+	if links := pbnd.FieldLinks().Length(); links != 1 {
+		v.violation(path, c, UnexpectedType, "Metadata node has %d links, expected 1", links)
+		return res, nil
+	}
+	child, err := v.verifyChild(path, c, pbnd.FieldLinks().Lookup(0))
+	if err != nil {
+		return result{}, err
+	}
+	res.tsize += child.tsize
+	res.contentSize = child.contentSize
+	res.complete = res.complete && child.complete
+	return res, nil
+}
+
+func (v *verifier) verifyDirectory(path string, c cid.Cid, pbnd dagpb.PBNode, res result) (result, error) {
+	names := make(map[string]struct{})
+	itr := pbnd.FieldLinks().Iterator()
+	for !itr.Done() {
+		_, lnk := itr.Next()
+		if !lnk.FieldName().Exists() {
+			v.violation(path, c, InvalidDirectory, "directory entry has no name")
+			continue
+		}
+		name := lnk.FieldName().Must().String()
+		if _, ok := names[name]; ok {
+			v.violation(path, c, InvalidDirectory, "duplicate entry name %q", name)
+		}
+		names[name] = struct{}{}
+		child, err := v.verifyChild(path+"/"+name, c, lnk)
+		if err != nil {
+			return result{}, err
+		}
+		res.tsize += child.tsize
+		res.complete = res.complete && child.complete
+	}
+	return res, nil
+}
+
+func (v *verifier) verifyShard(path string, c cid.Cid, pbnd dagpb.PBNode, ufsData data.UnixFSData, res result, depth int) (result, error) {
+	bf, err := v.shardBitfield(ufsData)
+	if err != nil {
+		v.violation(path, c, InvalidHAMT, "%s", err)
+		// without a usable header the entries can't be checked
+		res.complete = false
+		return res, nil
+	}
+	fanout := ufsData.FieldFanout().Must().Int()
+	log2 := bits.TrailingZeros64(uint64(fanout))
+	padLen := len(fmt.Sprintf("%X", fanout-1))
+	if ones := bf.Ones(); ones != int(pbnd.FieldLinks().Length()) {
+		v.violation(path, c, InvalidHAMT, "bitfield has %d bits set for %d links", ones, pbnd.FieldLinks().Length())
+	}
+
+	seen := make(map[int]struct{})
+	itr := pbnd.FieldLinks().Iterator()
+	for !itr.Done() {
+		_, lnk := itr.Next()
+		if !lnk.FieldName().Exists() {
+			v.violation(path, c, InvalidHAMT, "%s", hamt.ErrMissingLinkName)
+			continue
+		}
+		name := lnk.FieldName().Must().String()
+		if len(name) < padLen {
+			v.violation(path, c, InvalidHAMT, "%s", hamt.ErrInvalidLinkName{Name: name})
+			continue
+		}
+		idx, err := strconv.ParseUint(name[:padLen], 16, 64)
+		if err != nil || name[:padLen] != fmt.Sprintf("%0*X", padLen, idx) || int64(idx) >= fanout {
+			v.violation(path, c, InvalidHAMT, "%s", hamt.ErrInvalidLinkName{Name: name})
+			continue
+		}
+		if !bf.Bit(int(idx)) {
+			v.violation(path, c, InvalidHAMT, "link %q is in bucket %d which is not set in the bitfield", name, idx)
+		}
+		if _, ok := seen[int(idx)]; ok {
+			v.violation(path, c, InvalidHAMT, "more than one link in bucket %d", idx)
+		}
+		seen[int(idx)] = struct{}{}
+
+		if len(name) == padLen {
+			// a link to a child shard
+			child, err := v.verifyShardChild(path, c, lnk, depth+1)
+			if err != nil {
+				return result{}, err
+			}
+			res.tsize += child.tsize
+			res.complete = res.complete && child.complete
+			continue
+		}
+		entryName := name[padLen:]
+		if bucket, ok := hamt.Bucket(entryName, depth, log2); !ok {
+			v.violation(path, c, InvalidHAMT, "%s", hamt.ErrHAMTTooDeep)
+		} else if bucket != int(idx) {
+			v.violation(path, c, InvalidHAMT, "entry %q is in bucket %d but hashes to bucket %d", entryName, idx, bucket)
+		}
+		child, err := v.verifyChild(path+"/"+entryName, c, lnk)
+		if err != nil {
+			return result{}, err
+		}
+		res.tsize += child.tsize
+		res.complete = res.complete && child.complete
+	}
+	return res, nil
+}
+
+// shardBitfield checks the HAMT header fields of a shard, as the hamt package
+// does when reading it, and returns its bitfield
+func (v *verifier) shardBitfield(ufsData data.UnixFSData) (bitfield.Bitfield, error) {
+	if err := hamt.ValidateHAMTData(ufsData); err != nil {
+		return nil, err
+	}
+	bf, err := bitfield.NewBitfield(int(ufsData.FieldFanout().Must().Int()))
+	if err != nil {
+		return nil, err
+	}
+	bf.SetBytes(ufsData.FieldData().Must().Bytes())
+	return bf, nil
+}
+
+// verifyShardChild verifies a child shard of a HAMT. Shards are not memoized
+// as the validity of their entries depends on their depth in the HAMT.
+func (v *verifier) verifyShardChild(path string, parent cid.Cid, lnk dagpb.PBLink, depth int) (result, error) {
+	cl, ok := lnk.FieldHash().Link().(cidlink.Link)
+	if !ok {
+		return result{}, fmt.Errorf("unsupported link type: %T", lnk.FieldHash().Link())
+	}
+	pbnd, raw, err := v.load(path, cl.Cid)
+	if err != nil {
+		return result{}, err
+	}
+	res := result{tsize: uint64(len(raw)), dataType: -1, complete: raw != nil}
+	if pbnd == nil {
+		if raw != nil {
+			v.violation(path, cl.Cid, InvalidHAMT, "child shard is not a dag-pb node")
+			res.complete = false
+		}
+		return res, nil
+	}
+	var ufsData data.UnixFSData
+	if pbnd.FieldData().Exists() {
+		ufsData, err = data.DecodeUnixFSData(pbnd.FieldData().Must().Bytes())
+	}
+	if ufsData == nil || err != nil {
+		v.violation(path, cl.Cid, InvalidUnixFSData, "child shard has invalid UnixFS Data")
+		res.complete = false
+		return res, nil
+	}
+	res.dataType = ufsData.FieldDataType().Int()
+	if res.dataType != data.Data_HAMTShard {
+		v.violation(path, cl.Cid, UnexpectedType, "HAMT links to a %s node as a child shard", typeName(res.dataType))
+		res.complete = false
+		return res, nil
+	}
+	res, err = v.verifyShard(path, cl.Cid, pbnd, ufsData, res, depth)
+	if err != nil {
+		return result{}, err
+	}
+	if res.complete && lnk.FieldTsize().Exists() {
+		if tsize := uint64(lnk.FieldTsize().Must().Int()); tsize != res.tsize {
+			v.violation(path, parent, TsizeMismatch, "link to %s has Tsize %d, DAG size is %d", cl.Cid, tsize, res.tsize)
+		}
+	}
+	return res, nil
+}
+
+func typeName(dataType int64) string {
+	if name, ok := data.DataTypeNames[dataType]; ok {
+		return name
+	}
+	return "non-UnixFS"
+}
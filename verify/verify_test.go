@@ -0,0 +1,271 @@
+package verify_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/hamt"
+	"github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipfs/go-unixfsnode/verify"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func mkLinkSystem() (ipld.LinkSystem, *cidlink.Memory) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := &cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+	ls.NodeReifier = unixfsnode.Reify
+	return ls, storage
+}
+
+func TestVerifyValid(t *testing.T) {
+	for _, sharded := range []bool{false, true} {
+		ls, _ := mkLinkSystem()
+		dir := testutil.GenerateDirectory(t, &ls, random.NewSeededRand(1234), 4<<20, sharded)
+		report, err := verify.Verify(context.Background(), &ls, cidlink.Link{Cid: dir.Root})
+		require.NoError(t, err)
+		require.True(t, report.OK(), "unexpected violations: %v", report.Violations)
+		require.Equal(t, len(dir.SelfCids)+countBlocks(dir.Children), report.Blocks)
+	}
+}
+
+func countBlocks(entries []testutil.DirEntry) int {
+	seen := make(map[string]struct{})
+	var walk func([]testutil.DirEntry)
+	walk = func(entries []testutil.DirEntry) {
+		for _, e := range entries {
+			for _, c := range e.SelfCids {
+				seen[c.KeyString()] = struct{}{}
+			}
+			walk(e.Children)
+		}
+	}
+	walk(entries)
+	return len(seen)
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	expected := map[testutil.Malformation]verify.ViolationKind{
+		testutil.MalformedBitfield:        verify.InvalidHAMT,
+		testutil.MalformedBlockSizes:      verify.BlockSizesMismatch,
+		testutil.MalformedBlockSizesCount: verify.BlockSizesMismatch,
+		testutil.MalformedFanout:          verify.InvalidHAMT,
+		testutil.MalformedOversizedFanout: verify.InvalidHAMT,
+		testutil.MalformedHashType:        verify.InvalidHAMT,
+		testutil.MalformedTruncatedData:   verify.InvalidUnixFSData,
+		testutil.MalformedShardLinkName:   verify.InvalidHAMT,
+	}
+	for _, kind := range testutil.AllMalformations {
+		t.Run(kind.String(), func(t *testing.T) {
+			ls, _ := mkLinkSystem()
+			c, err := testutil.MalformedNode(ls, kind, testutil.WithRandReader(random.NewSeededRand(1)))
+			require.NoError(t, err)
+			report, err := verify.Verify(context.Background(), &ls, cidlink.Link{Cid: c})
+			require.NoError(t, err)
+			require.False(t, report.OK())
+			require.NotEmpty(t, report.Violations)
+			for _, v := range report.Violations {
+				require.Equal(t, expected[kind], v.Kind, v.String())
+			}
+		})
+	}
+}
+
+func TestVerifyMissingAndTsize(t *testing.T) {
+	ls, storage := mkLinkSystem()
+	fileA, sizeA, err := builder.BuildUnixFSFile(bytes.NewReader(random.Bytes(1<<20)), "", &ls)
+	require.NoError(t, err)
+	fileB, sizeB, err := builder.BuildUnixFSFile(bytes.NewReader(random.Bytes(1<<10)), "", &ls)
+	require.NoError(t, err)
+	entryA, err := builder.BuildUnixFSDirectoryEntry("a", int64(sizeA), fileA)
+	require.NoError(t, err)
+	// deliberately incorrect Tsize
+	entryB, err := builder.BuildUnixFSDirectoryEntry("b", int64(sizeB+1), fileB)
+	require.NoError(t, err)
+	root, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{entryA, entryB}, &ls)
+	require.NoError(t, err)
+
+	// remove one of the leaves of file a
+	nd, err := ls.Load(ipld.LinkContext{}, fileA, dagpb.Type.PBNode)
+	require.NoError(t, err)
+	leaf := nd.(ipld.ADL).Substrate().(dagpb.PBNode).FieldLinks().Lookup(1).FieldHash().Link().(cidlink.Link)
+	delete(storage.Bag, string(leaf.Cid.Hash()))
+
+	report, err := verify.Verify(context.Background(), &ls, root)
+	require.NoError(t, err)
+	require.False(t, report.OK())
+	require.Len(t, report.Missing, 1)
+	require.Equal(t, leaf.Cid, report.Missing[0].Cid)
+	require.Equal(t, "/a", report.Missing[0].Path)
+	require.Len(t, report.Violations, 1)
+	require.Equal(t, verify.TsizeMismatch, report.Violations[0].Kind)
+	require.Equal(t, "/b", report.Violations[0].Path)
+
+	// the report is machine readable
+	byts, err := json.Marshal(report)
+	require.NoError(t, err)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(byts, &decoded))
+	require.Len(t, decoded["missing"], 1)
+	require.Len(t, decoded["violations"], 1)
+}
+
+func TestCheckTsizes(t *testing.T) {
+	for _, sharded := range []bool{false, true} {
+		ls, _ := mkLinkSystem()
+		dir := testutil.GenerateDirectory(t, &ls, random.NewSeededRand(1234), 4<<20, sharded)
+		report, err := verify.CheckTsizes(context.Background(), &ls, cidlink.Link{Cid: dir.Root})
+		require.NoError(t, err)
+		require.True(t, report.OK(), "unexpected discrepancies: %v", report.Discrepancies)
+		require.Equal(t, len(dir.SelfCids)+countBlocks(dir.Children), report.Blocks)
+	}
+
+	ls, storage := mkLinkSystem()
+	file, size, err := builder.BuildUnixFSFile(bytes.NewReader(random.Bytes(1<<20)), "", &ls)
+	require.NoError(t, err)
+	entries := make([]dagpb.PBLink, 0, 50)
+	for i := 0; i < 50; i++ {
+		tsize := int64(size)
+		if i == 7 {
+			// deliberately incorrect Tsize
+			tsize++
+		}
+		entry, err := builder.BuildUnixFSDirectoryEntry(fmt.Sprintf("f%d", i), tsize, file)
+		require.NoError(t, err)
+		entries = append(entries, entry)
+	}
+	shard, shardSize, err := builder.BuildUnixFSShardedDirectory(16, multihash.MURMUR3X64_64, entries, &ls)
+	require.NoError(t, err)
+	shardEntry, err := builder.BuildUnixFSDirectoryEntry("shard", int64(shardSize), shard)
+	require.NoError(t, err)
+	// a link with no Tsize
+	noTsize, err := qp.BuildMap(dagpb.Type.PBLink, 2, func(ma ipld.MapAssembler) {
+		qp.MapEntry(ma, "Hash", qp.Link(file))
+		qp.MapEntry(ma, "Name", qp.String("untracked"))
+	})
+	require.NoError(t, err)
+	dirData, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.DataType(b, data.Data_Directory)
+	})
+	require.NoError(t, err)
+	rootNode, err := qp.BuildMap(dagpb.Type.PBNode, 2, func(ma ipld.MapAssembler) {
+		qp.MapEntry(ma, "Links", qp.List(2, func(la ipld.ListAssembler) {
+			qp.ListEntry(la, qp.Node(shardEntry))
+			qp.ListEntry(la, qp.Node(noTsize))
+		}))
+		qp.MapEntry(ma, "Data", qp.Bytes(data.EncodeUnixFSData(dirData)))
+	})
+	require.NoError(t, err)
+	root, err := ls.Store(ipld.LinkContext{}, cidlink.LinkPrototype{Prefix: shard.(cidlink.Link).Prefix()}, rootNode)
+	require.NoError(t, err)
+	rootBlock, err := ls.LoadRaw(ipld.LinkContext{}, root)
+	require.NoError(t, err)
+
+	report, err := verify.CheckTsizes(context.Background(), &ls, root)
+	require.NoError(t, err)
+	require.False(t, report.OK())
+	require.Empty(t, report.Missing)
+	require.Equal(t, uint64(len(rootBlock))+shardSize-1+size, report.Size)
+	// the wrong Tsize was propagated to the link to the shard
+	require.Len(t, report.Discrepancies, 3)
+	byPath := make(map[string]verify.TsizeDiscrepancy)
+	for _, d := range report.Discrepancies {
+		byPath[d.Path] = d
+	}
+	require.Equal(t, file.(cidlink.Link).Cid, byPath["/shard/f7"].Cid)
+	require.Equal(t, size+1, *byPath["/shard/f7"].Tsize)
+	require.Equal(t, size, byPath["/shard/f7"].Size)
+	require.Equal(t, shardSize, *byPath["/shard"].Tsize)
+	require.Equal(t, shardSize-1, byPath["/shard"].Size)
+	require.Nil(t, byPath["/untracked"].Tsize)
+	require.Equal(t, "untracked", byPath["/untracked"].Name)
+
+	// the sizes above a missing block are unknown, so aren't checked
+	delete(storage.Bag, string(file.(cidlink.Link).Cid.Hash()))
+	report, err = verify.CheckTsizes(context.Background(), &ls, root)
+	require.NoError(t, err)
+	require.Empty(t, report.Discrepancies)
+	require.Len(t, report.Missing, 1)
+}
+
+func TestVerifyMetadata(t *testing.T) {
+	ls, storage := mkLinkSystem()
+	file, size, err := builder.BuildUnixFSFile(bytes.NewReader(random.Bytes(1<<20)), "", &ls)
+	require.NoError(t, err)
+	md, mdSize, err := builder.BuildUnixFSMetadata("text/plain", file, size, &ls)
+	require.NoError(t, err)
+	entry, err := builder.BuildUnixFSDirectoryEntry("f", int64(mdSize), md)
+	require.NoError(t, err)
+	root, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{entry}, &ls)
+	require.NoError(t, err)
+
+	report, err := verify.Verify(context.Background(), &ls, root)
+	require.NoError(t, err)
+	require.True(t, report.OK(), "unexpected violations: %v", report.Violations)
+
+	// the target is verified through the Metadata node
+	delete(storage.Bag, string(file.(cidlink.Link).Cid.Hash()))
+	report, err = verify.Verify(context.Background(), &ls, root)
+	require.NoError(t, err)
+	require.Len(t, report.Missing, 1)
+	require.Equal(t, file.(cidlink.Link).Cid, report.Missing[0].Cid)
+	require.Equal(t, "/f", report.Missing[0].Path)
+}
+
+func TestVerifyShardHeader(t *testing.T) {
+	// shards are checked as the hamt package checks them when reading
+	for _, tc := range []struct {
+		fanout   uint64
+		hashType uint64
+	}{
+		{2, multihash.MURMUR3X64_64},
+		{4, multihash.MURMUR3X64_64},
+		{8, multihash.MURMUR3X64_64},
+		{48, multihash.MURMUR3X64_64},
+		{1024, multihash.MURMUR3X64_64},
+		{2048, multihash.MURMUR3X64_64},
+		{256, multihash.SHA2_256},
+	} {
+		t.Run(fmt.Sprintf("fanout %d hash 0x%x", tc.fanout, tc.hashType), func(t *testing.T) {
+			ls, _ := mkLinkSystem()
+			ufsd, err := builder.BuildUnixFS(func(b *builder.Builder) {
+				builder.DataType(b, data.Data_HAMTShard)
+				builder.Data(b, []byte{})
+				builder.HashType(b, tc.hashType)
+				builder.Fanout(b, tc.fanout)
+			})
+			require.NoError(t, err)
+			nd, err := qp.BuildMap(dagpb.Type.PBNode, 2, func(ma ipld.MapAssembler) {
+				qp.MapEntry(ma, "Links", qp.List(0, func(ipld.ListAssembler) {}))
+				qp.MapEntry(ma, "Data", qp.Bytes(data.EncodeUnixFSData(ufsd)))
+			})
+			require.NoError(t, err)
+			root, err := ls.Store(ipld.LinkContext{}, builder.DefaultInteriorLinkPrototype, nd)
+			require.NoError(t, err)
+
+			_, readErr := hamt.NewUnixFSHAMTShard(context.Background(), nd.(dagpb.PBNode), ufsd, &ls)
+			report, err := verify.Verify(context.Background(), &ls, root)
+			require.NoError(t, err)
+			if readErr == nil {
+				require.True(t, report.OK(), "unexpected violations: %v", report.Violations)
+				return
+			}
+			require.Len(t, report.Violations, 1)
+			require.Equal(t, verify.InvalidHAMT, report.Violations[0].Kind)
+			require.Equal(t, readErr.Error(), report.Violations[0].Message)
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package walk
+
+import (
+	"context"
+	"io"
+
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+)
+
+// ManifestEntry is a line of the manifest produced by Manifest. It is the
+// type builder.BuildUnixFSDirectoryFromManifest takes, so a manifest can be
+// built back into a tree.
+type ManifestEntry = builder.ManifestEntry
+
+// Manifest walks the UnixFS DAG at root as Walk does and calls fn with an
+// entry for each file, directory and symlink, in the order they are reached,
+// so that the manifest of a large DAG can be written out as it is made. Only
+// the blocks of directories and the root blocks of files are loaded. An error
+// returned by fn stops the walk, and is returned by Manifest.
+func Manifest(ctx context.Context, lsys *ipld.LinkSystem, root ipld.Link, fn func(ManifestEntry) error) error {
+	return Walk(ctx, lsys, root, Visitor{
+		OnFile: func(e Entry) error {
+			me := ManifestEntry{Path: e.Path, Type: data.Data_File, Cid: e.Cid}
+			if e.Data == nil {
+				// a single raw block
+				b, err := e.Substrate.AsBytes()
+				if err != nil {
+					return err
+				}
+				me.Size = uint64(len(b))
+				me.StoredSize = me.Size
+				return fn(me)
+			}
+			if e.Data.FieldFileSize().Exists() {
+				me.Size = uint64(e.Data.FieldFileSize().Must().Int())
+			} else if e.Data.FieldData().Exists() {
+				me.Size = uint64(len(e.Data.FieldData().Must().Bytes()))
+			}
+			var err error
+			me.StoredSize, err = storedSize(e.Substrate.(dagpb.PBNode))
+			if err != nil {
+				return err
+			}
+			return fn(me)
+		},
+		OnDirectory: func(e Entry) error {
+			me := ManifestEntry{Path: e.Path, Type: data.Data_Directory, Cid: e.Cid}
+			var err error
+			me.StoredSize, err = storedSize(e.Substrate.(dagpb.PBNode))
+			if err != nil {
+				return err
+			}
+			return fn(me)
+		},
+		OnSymlink: func(e Entry) error {
+			me := ManifestEntry{Path: e.Path, Type: data.Data_Symlink, Cid: e.Cid}
+			if e.Data.FieldData().Exists() {
+				me.Size = uint64(len(e.Data.FieldData().Must().Bytes()))
+			}
+			var err error
+			me.StoredSize, err = storedSize(e.Substrate.(dagpb.PBNode))
+			if err != nil {
+				return err
+			}
+			return fn(me)
+		},
+	})
+}
+
+// storedSize is the encoded size of pbn plus the Tsize of each of its links.
+func storedSize(pbn dagpb.PBNode) (uint64, error) {
+	var cw countingWriter
+	if err := dagpb.Encode(pbn, &cw); err != nil {
+		return 0, err
+	}
+	size := uint64(cw)
+	itr := pbn.FieldLinks().Iterator()
+	for !itr.Done() {
+		_, lnk := itr.Next()
+		if lnk.FieldTsize().Exists() {
+			size += uint64(lnk.FieldTsize().Must().Int())
+		}
+	}
+	return size, nil
+}
+
+type countingWriter uint64
+
+var _ io.Writer = (*countingWriter)(nil)
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	*cw += countingWriter(len(p))
+	return len(p), nil
+}
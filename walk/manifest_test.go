@@ -0,0 +1,114 @@
+package walk_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/walk"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	entry := func(name string, lnk ipld.Link, size uint64) dagpb.PBLink {
+		e, err := builder.BuildUnixFSDirectoryEntry(name, int64(size), lnk)
+		require.NoError(t, err)
+		return e
+	}
+
+	big, bigSize, err := builder.BuildUnixFSFile(bytes.NewReader(random.Bytes(10*1024)), "size-1024", &ls)
+	require.NoError(t, err)
+	small, smallSize, err := builder.BuildUnixFSFile(strings.NewReader("small"), "", &ls,
+		builder.WithLeafLinkPrototype(builder.DefaultInteriorLinkPrototype))
+	require.NoError(t, err)
+	link, linkSize, err := builder.BuildUnixFSSymlink("big", &ls)
+	require.NoError(t, err)
+	raw, rawSize, err := builder.BuildUnixFSFile(strings.NewReader("raw"), "", &ls)
+	require.NoError(t, err)
+	sub, subSize, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{
+		entry("raw", raw, rawSize),
+		entry("small", small, smallSize),
+	}, &ls)
+	require.NoError(t, err)
+	root, rootSize, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{
+		entry("big", big, bigSize),
+		entry("link", link, linkSize),
+		entry("sub", sub, subSize),
+	}, &ls)
+	require.NoError(t, err)
+
+	var manifest []walk.ManifestEntry
+	err = walk.Manifest(context.Background(), &ls, root, func(e walk.ManifestEntry) error {
+		manifest = append(manifest, e)
+		return nil
+	})
+	require.NoError(t, err)
+	expected := []walk.ManifestEntry{
+		{Path: "", Type: data.Data_Directory, StoredSize: rootSize},
+		{Path: "big", Type: data.Data_File, Size: 10 * 1024, StoredSize: bigSize},
+		{Path: "link", Type: data.Data_Symlink, Size: 3, StoredSize: linkSize},
+		{Path: "sub", Type: data.Data_Directory, StoredSize: subSize},
+		{Path: "sub/raw", Type: data.Data_File, Size: 3, StoredSize: 3},
+		{Path: "sub/small", Type: data.Data_File, Size: 5, StoredSize: smallSize},
+	}
+	for i, l := range []ipld.Link{root, big, link, sub, raw, small} {
+		expected[i].Cid = l.(cidlink.Link).Cid
+	}
+	require.Equal(t, expected, manifest)
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	entry := func(name string, lnk ipld.Link, size uint64) dagpb.PBLink {
+		e, err := builder.BuildUnixFSDirectoryEntry(name, int64(size), lnk)
+		require.NoError(t, err)
+		return e
+	}
+	file, fileSize, err := builder.BuildUnixFSFile(bytes.NewReader(random.Bytes(10*1024)), "size-1024", &ls)
+	require.NoError(t, err)
+	link, linkSize, err := builder.BuildUnixFSSymlink("file", &ls)
+	require.NoError(t, err)
+	empty, emptySize, err := builder.BuildUnixFSDirectory(nil, &ls)
+	require.NoError(t, err)
+	sub, subSize, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{
+		entry("empty", empty, emptySize),
+		entry("file", file, fileSize),
+	}, &ls)
+	require.NoError(t, err)
+	root, rootSize, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{
+		entry("file", file, fileSize),
+		entry("link", link, linkSize),
+		entry("sub", sub, subSize),
+	}, &ls)
+	require.NoError(t, err)
+
+	var manifest []walk.ManifestEntry
+	err = walk.Manifest(context.Background(), &ls, root, func(e walk.ManifestEntry) error {
+		manifest = append(manifest, e)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// the directories listed are rebuilt from their entries, and the empty
+	// one linked as it is
+	rebuilt, size, err := builder.BuildUnixFSDirectoryFromManifest(manifest, &ls)
+	require.NoError(t, err)
+	require.Equal(t, root, rebuilt)
+	require.Equal(t, rootSize, size)
+}
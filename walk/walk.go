@@ -0,0 +1,286 @@
+// Package walk walks UnixFS DAGs by what their nodes represent, calling back
+// for each file, directory, symlink, shard and file leaf reached, for tools
+// that would otherwise have to interpret the blocks of a raw IPLD traversal
+// themselves.
+package walk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/file"
+	"github.com/ipfs/go-unixfsnode/hamt"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// SkipNode can be returned from OnFile or OnDirectory to skip the leaves of
+// the file, or the shards and entries of the directory. Returned from any
+// other callback, it is the same as returning nil.
+var SkipNode = errors.New("skip this node")
+
+// Entry describes a node reached by Walk.
+type Entry struct {
+	// Path is the slash-separated path of the file or directory from the root,
+	// which is at "". Shards and leaves have the path of the directory or file
+	// they belong to.
+	Path string
+	// Cid is the CID of the block
+	Cid cid.Cid
+	// Data is the UnixFS data of the block, or nil for raw blocks
+	Data data.UnixFSData
+	// Node is the block as an ADL where there is one: a file.LargeBytesNode for
+	// files, and a map of entry names to links for directories. Symlinks,
+	// shards and leaves are given as the node loaded from the block.
+	Node ipld.Node
+	// Substrate is the node loaded from the block, which Node is built over
+	Substrate ipld.Node
+}
+
+// Visitor holds the callbacks Walk makes. Any of them may be nil. Returning
+// an error other than SkipNode from a callback stops the walk, and Walk
+// returns the error.
+type Visitor struct {
+	// OnFile is called for each file, including single raw blocks
+	OnFile func(Entry) error
+	// OnDirectory is called for each directory, sharded or not, before its
+	// entries are walked
+	OnDirectory func(Entry) error
+	// OnSymlink is called for each symlink; the target is the Data of its
+	// UnixFS data
+	OnSymlink func(Entry) error
+	// OnShard is called for each shard of a sharded directory, including the
+	// root shard, after OnDirectory and before any of the directory's entries
+	OnShard func(Entry) error
+	// OnLeaf is called for each leaf block of a multi-block file, in order. A
+	// file of a single block has no leaves. Leaves are only loaded if OnLeaf
+	// is set.
+	OnLeaf func(Entry) error
+}
+
+type walker struct {
+	ctx  context.Context
+	lsys *ipld.LinkSystem
+	// pbls is a copy of lsys without reification, for reading raw dag-pb
+	pbls   ipld.LinkSystem
+	lnkCtx linking.LinkContext
+	v      Visitor
+}
+
+// Walk walks the UnixFS DAG at root, depth first with the entries of each
+// directory in order, making the callbacks of v. Metadata nodes are walked
+// through to the node they describe. A node reached through more than one
+// path is walked once for each.
+func Walk(ctx context.Context, lsys *ipld.LinkSystem, root ipld.Link, v Visitor) error {
+	w := &walker{
+		ctx:    ctx,
+		lsys:   lsys,
+		pbls:   *lsys,
+		lnkCtx: linking.LinkContext{Ctx: ctx},
+		v:      v,
+	}
+	w.pbls.NodeReifier = nil
+	return w.walk("", root)
+}
+
+func call(fn func(Entry) error, e Entry) error {
+	if fn == nil {
+		return nil
+	}
+	return fn(e)
+}
+
+func (w *walker) walk(p string, lnk ipld.Link) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	cl, ok := lnk.(cidlink.Link)
+	if !ok {
+		return fmt.Errorf("%s: unsupported link type %T", p, lnk)
+	}
+	if cl.Cid.Prefix().Codec == cid.Raw {
+		nd, err := w.lsys.Load(w.lnkCtx, lnk, basicnode.Prototype.Bytes)
+		if err != nil {
+			return err
+		}
+		f, err := file.NewUnixFSFile(w.ctx, nd, w.lsys)
+		if err != nil {
+			return err
+		}
+		err = call(w.v.OnFile, Entry{Path: p, Cid: cl.Cid, Node: f, Substrate: nd})
+		if errors.Is(err, SkipNode) {
+			return nil
+		}
+		return err
+	}
+
+	pbn, err := w.loadPB(cl.Cid)
+	if err != nil {
+		return err
+	}
+	ufsd, err := decode(cl.Cid, pbn)
+	if err != nil {
+		return err
+	}
+	e := Entry{Path: p, Cid: cl.Cid, Data: ufsd, Node: pbn, Substrate: pbn}
+
+	switch ufsd.FieldDataType().Int() {
+	case data.Data_Directory, data.Data_HAMTShard:
+		e.Node, err = unixfsnode.Reify(w.lnkCtx, pbn, w.lsys)
+		if err != nil {
+			return err
+		}
+		if err := call(w.v.OnDirectory, e); err != nil {
+			if errors.Is(err, SkipNode) {
+				return nil
+			}
+			return err
+		}
+		if shard, ok := e.Node.(hamt.UnixFSHAMTShard); ok && w.v.OnShard != nil {
+			if err := w.shards(p, cl.Cid, shard); err != nil {
+				return err
+			}
+		}
+		itr := e.Node.MapIterator()
+		for !itr.Done() {
+			k, v, err := itr.Next()
+			if err != nil {
+				return err
+			}
+			name, err := k.AsString()
+			if err != nil {
+				return err
+			}
+			child, err := v.AsLink()
+			if err != nil {
+				return err
+			}
+			if err := w.walk(path.Join(p, name), child); err != nil {
+				return err
+			}
+		}
+		return nil
+	case data.Data_File, data.Data_Raw:
+		e.Node, err = unixfsnode.Reify(w.lnkCtx, pbn, w.lsys)
+		if err != nil {
+			return err
+		}
+		if err := call(w.v.OnFile, e); err != nil {
+			if errors.Is(err, SkipNode) {
+				return nil
+			}
+			return err
+		}
+		if w.v.OnLeaf == nil {
+			return nil
+		}
+		return w.leaves(p, pbn)
+	case data.Data_Symlink:
+		if err := call(w.v.OnSymlink, e); !errors.Is(err, SkipNode) {
+			return err
+		}
+		return nil
+	case data.Data_Metadata:
+		if links := pbn.FieldLinks().Length(); links != 1 {
+			return fmt.Errorf("%s: UnixFS metadata must have a single link, not %d", p, links)
+		}
+		return w.walk(p, pbn.FieldLinks().Lookup(0).FieldHash().Link())
+	default:
+		return data.ErrInvalidDataType{DataType: ufsd.FieldDataType().Int()}
+	}
+}
+
+func (w *walker) loadPB(c cid.Cid) (dagpb.PBNode, error) {
+	nd, err := w.pbls.Load(w.lnkCtx, cidlink.Link{Cid: c}, dagpb.Type.PBNode)
+	if err != nil {
+		return nil, err
+	}
+	pbn, ok := nd.(dagpb.PBNode)
+	if !ok {
+		return nil, fmt.Errorf("block %s is not dag-pb", c)
+	}
+	return pbn, nil
+}
+
+func decode(c cid.Cid, pbn dagpb.PBNode) (data.UnixFSData, error) {
+	if !pbn.FieldData().Exists() {
+		return nil, fmt.Errorf("block %s is not UnixFS: no Data field", c)
+	}
+	ufsd, err := data.DecodeUnixFSData(pbn.FieldData().Must().Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("block %s is not UnixFS: %w", c, err)
+	}
+	return ufsd, nil
+}
+
+func (w *walker) shards(p string, root cid.Cid, shard hamt.UnixFSHAMTShard) error {
+	ufsd, err := decode(root, shard.Substrate().(dagpb.PBNode))
+	if err != nil {
+		return err
+	}
+	if err := call(w.v.OnShard, Entry{Path: p, Cid: root, Data: ufsd, Node: shard.Substrate(), Substrate: shard.Substrate()}); err != nil && !errors.Is(err, SkipNode) {
+		return err
+	}
+	return shard.WalkShards(func(lnk ipld.Link, child hamt.UnixFSHAMTShard, _ int) error {
+		c := lnk.(cidlink.Link).Cid
+		pbn := child.Substrate().(dagpb.PBNode)
+		ufsd, err := decode(c, pbn)
+		if err != nil {
+			return err
+		}
+		if err := call(w.v.OnShard, Entry{Path: p, Cid: c, Data: ufsd, Node: pbn, Substrate: pbn}); !errors.Is(err, SkipNode) {
+			return err
+		}
+		return nil
+	})
+}
+
+func (w *walker) leaves(p string, pbn dagpb.PBNode) error {
+	itr := pbn.FieldLinks().Iterator()
+	for !itr.Done() {
+		if err := w.ctx.Err(); err != nil {
+			return err
+		}
+		_, pbLink := itr.Next()
+		cl, ok := pbLink.FieldHash().Link().(cidlink.Link)
+		if !ok {
+			return fmt.Errorf("%s: unsupported link type %T", p, pbLink.FieldHash().Link())
+		}
+		if cl.Cid.Prefix().Codec == cid.Raw {
+			nd, err := w.lsys.Load(w.lnkCtx, cl, basicnode.Prototype.Bytes)
+			if err != nil {
+				return err
+			}
+			if err := call(w.v.OnLeaf, Entry{Path: p, Cid: cl.Cid, Node: nd, Substrate: nd}); err != nil && !errors.Is(err, SkipNode) {
+				return err
+			}
+			continue
+		}
+		child, err := w.loadPB(cl.Cid)
+		if err != nil {
+			return err
+		}
+		if child.FieldLinks().Length() > 0 {
+			if err := w.leaves(p, child); err != nil {
+				return err
+			}
+			continue
+		}
+		ufsd, err := decode(cl.Cid, child)
+		if err != nil {
+			return err
+		}
+		if err := call(w.v.OnLeaf, Entry{Path: p, Cid: cl.Cid, Data: ufsd, Node: child, Substrate: child}); err != nil && !errors.Is(err, SkipNode) {
+			return err
+		}
+	}
+	return nil
+}
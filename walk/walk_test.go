@@ -0,0 +1,173 @@
+package walk_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-test/random"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipfs/go-unixfsnode/file"
+	"github.com/ipfs/go-unixfsnode/walk"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalk(t *testing.T) {
+	ctx := context.Background()
+	ls := cidlink.DefaultLinkSystem()
+	storage := cidlink.Memory{}
+	ls.StorageReadOpener = storage.OpenRead
+	ls.StorageWriteOpener = storage.OpenWrite
+
+	entry := func(name string, lnk ipld.Link, size uint64) dagpb.PBLink {
+		e, err := builder.BuildUnixFSDirectoryEntry(name, int64(size), lnk)
+		require.NoError(t, err)
+		return e
+	}
+
+	content := random.Bytes(10 * 1024)
+	big, bigSize, err := builder.BuildUnixFSFile(bytes.NewReader(content), "size-1024", &ls)
+	require.NoError(t, err)
+	small, smallSize, err := builder.BuildUnixFSFile(strings.NewReader("small"), "", &ls)
+	require.NoError(t, err)
+	link, linkSize, err := builder.BuildUnixFSSymlink("big", &ls)
+	require.NoError(t, err)
+	typed, typedSize, err := builder.BuildUnixFSMetadata("text/plain", small, smallSize, &ls)
+	require.NoError(t, err)
+
+	var shardEntries []dagpb.PBLink
+	for i := 0; i < 100; i++ {
+		shardEntries = append(shardEntries, entry(fmt.Sprintf("%03d", i), small, smallSize))
+	}
+	shard, shardSize, err := builder.BuildUnixFSShardedDirectory(16, multihash.MURMUR3X64_64, shardEntries, &ls)
+	require.NoError(t, err)
+
+	root, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{
+		entry("big", big, bigSize),
+		entry("link", link, linkSize),
+		entry("sharded", shard, shardSize),
+		entry("typed", typed, typedSize),
+	}, &ls)
+	require.NoError(t, err)
+
+	var files, dirs, symlinks []string
+	shards := make(map[cid.Cid]string)
+	var leaves []cid.Cid
+	var read []byte
+	err = walk.Walk(ctx, &ls, root, walk.Visitor{
+		OnFile: func(e walk.Entry) error {
+			files = append(files, e.Path)
+			require.Implements(t, (*file.LargeBytesNode)(nil), e.Node)
+			return nil
+		},
+		OnDirectory: func(e walk.Entry) error {
+			dirs = append(dirs, e.Path)
+			return nil
+		},
+		OnSymlink: func(e walk.Entry) error {
+			symlinks = append(symlinks, e.Path)
+			require.Equal(t, "big", string(e.Data.FieldData().Must().Bytes()))
+			return nil
+		},
+		OnShard: func(e walk.Entry) error {
+			shards[e.Cid] = e.Path
+			require.Equal(t, data.Data_HAMTShard, e.Data.FieldDataType().Int())
+			return nil
+		},
+		OnLeaf: func(e walk.Entry) error {
+			require.Equal(t, "big", e.Path)
+			leaves = append(leaves, e.Cid)
+			byts, err := e.Node.AsBytes()
+			require.NoError(t, err)
+			read = append(read, byts...)
+			return nil
+		},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"", "sharded"}, dirs)
+	require.Equal(t, []string{"link"}, symlinks)
+	require.Len(t, files, 102)
+	require.Equal(t, "big", files[0])
+	require.Equal(t, "typed", files[len(files)-1])
+	require.Len(t, leaves, 10)
+	require.Equal(t, content, read)
+	require.Greater(t, len(shards), 1)
+	require.Equal(t, "sharded", shards[shard.(cidlink.Link).Cid])
+
+	t.Run("reifying", func(t *testing.T) {
+		rls := ls
+		rls.NodeReifier = unixfsnode.Reify
+		var files, leaves int
+		err := walk.Walk(ctx, &rls, root, walk.Visitor{
+			OnFile: func(e walk.Entry) error {
+				files++
+				return nil
+			},
+			OnLeaf: func(e walk.Entry) error {
+				leaves++
+				return nil
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, 102, files)
+		require.Equal(t, 10, leaves)
+	})
+
+	t.Run("not dag-pb", func(t *testing.T) {
+		rls := ls
+		rls.NodeReifier = unixfsnode.Reify
+		lp := cidlink.LinkPrototype{Prefix: cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: multihash.SHA2_256, MhLength: -1}}
+		lnk, err := rls.Store(ipld.LinkContext{}, lp, basicnode.NewString("not unixfs"))
+		require.NoError(t, err)
+		require.Error(t, walk.Walk(ctx, &rls, lnk, walk.Visitor{}))
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		var files []string
+		var leaves int
+		err = walk.Walk(ctx, &ls, root, walk.Visitor{
+			OnFile: func(e walk.Entry) error {
+				files = append(files, e.Path)
+				return walk.SkipNode
+			},
+			OnDirectory: func(e walk.Entry) error {
+				if e.Path == "sharded" {
+					return walk.SkipNode
+				}
+				return nil
+			},
+			OnLeaf: func(e walk.Entry) error {
+				leaves++
+				return nil
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"big", "typed"}, files)
+		require.Zero(t, leaves)
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		stop := fmt.Errorf("stop")
+		var files int
+		err = walk.Walk(ctx, &ls, root, walk.Visitor{
+			OnFile: func(e walk.Entry) error {
+				files++
+				return stop
+			},
+		})
+		require.ErrorIs(t, err, stop)
+		require.Equal(t, 1, files)
+	})
+}